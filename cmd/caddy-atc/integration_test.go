@@ -0,0 +1,165 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// The integration tests run the caddy-atc binary against the real Docker
+// daemon: go test -tags integration ./cmd/caddy-atc. They start their own
+// gateway on ports 18080 and 18443 with caddy-atc's directories in a
+// temporary CADDY_ATC_HOME, and skip on a machine where caddy-atc is
+// already installed, whose gateway container and volumes they would share.
+
+const (
+	itHTTPPort  = "18080"
+	itHTTPSPort = "18443"
+)
+
+// gatewayVolumes are the volumes of the gateway's compose project.
+var gatewayVolumes = []string{"caddy-atc_caddy-atc-caddyfile", "caddy-atc_caddy-atc-data", "caddy-atc_caddy-atc-config"}
+
+// harness runs a caddy-atc binary built for the test.
+type harness struct {
+	t   *testing.T
+	bin string
+	env []string
+}
+
+// newHarness builds caddy-atc and brings up its gateway and a detached
+// watcher, which are removed when the test ends.
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("Docker is not available")
+	}
+	if exec.Command("docker", "container", "inspect", "caddy-atc").Run() == nil {
+		t.Skip("a caddy-atc gateway container already exists")
+	}
+	for _, v := range gatewayVolumes {
+		if exec.Command("docker", "volume", "inspect", v).Run() == nil {
+			t.Skipf("volume %s of an existing caddy-atc install exists", v)
+		}
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "caddy-atc")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	env := config.FilterEnv(config.DirEnv...)
+	env = append(env, config.HomeEnv+"="+filepath.Join(dir, "home"))
+	h := &harness{t: t, bin: bin, env: env}
+
+	t.Cleanup(func() {
+		h.run("down")
+		for _, v := range gatewayVolumes {
+			exec.Command("docker", "volume", "rm", v).Run()
+		}
+		exec.Command("docker", "network", "rm", "caddy-atc").Run()
+	})
+	h.mustRun("up", "--detach", "--http-port", itHTTPPort, "--https-port", itHTTPSPort)
+	return h
+}
+
+// run runs caddy-atc with args, returning its combined output.
+func (h *harness) run(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.bin, args...)
+	cmd.Env = h.env
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func (h *harness) mustRun(args ...string) string {
+	h.t.Helper()
+	out, err := h.run(args...)
+	if err != nil {
+		h.t.Fatalf("caddy-atc %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// waitFor runs caddy-atc with args until its output contains want.
+func (h *harness) waitFor(want string, args ...string) {
+	h.t.Helper()
+	var out string
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); time.Sleep(time.Second) {
+		if out, _ = h.run(args...); strings.Contains(out, want) {
+			return
+		}
+	}
+	h.t.Fatalf("caddy-atc %s never showed %q; last output:\n%s", strings.Join(args, " "), want, out)
+}
+
+func TestIntegration_Selftest(t *testing.T) {
+	h := newHarness(t)
+
+	out, err := h.run("selftest")
+	if err != nil {
+		t.Fatalf("selftest: %v\n%s", err, out)
+	}
+	if strings.Contains(out, "FAIL") {
+		t.Errorf("selftest reported failures:\n%s", out)
+	}
+}
+
+func TestIntegration_AdoptRouteReload(t *testing.T) {
+	h := newHarness(t)
+
+	dir := filepath.Join(t.TempDir(), "itapp")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The gateway's image is already pulled.
+	compose := `services:
+  web:
+    image: ` + config.DefaultImage + `
+    command: ["caddy", "respond", "--listen", ":8080", "--body", "itapp"]
+    labels:
+      caddy-atc.port: "8080"
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h.mustRun("adopt", dir, "--hostname", "itapp.localhost")
+	up := exec.Command("docker", "compose", "up", "-d")
+	up.Dir = dir
+	if out, err := up.CombinedOutput(); err != nil {
+		t.Fatalf("docker compose up: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		down := exec.Command("docker", "compose", "down", "--volumes")
+		down.Dir = dir
+		down.Run()
+	})
+	h.waitFor("itapp.localhost", "routes")
+
+	h.mustRun("route", "add", "vite.itapp.localhost", "5173")
+	h.waitFor("vite.itapp.localhost", "routes")
+
+	h.mustRun("reload")
+	out := h.mustRun("routes")
+	for _, want := range []string{"itapp.localhost", "vite.itapp.localhost"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("routes after reload doesn't list %s:\n%s", want, out)
+		}
+	}
+
+	h.mustRun("route", "remove", "vite.itapp.localhost")
+	h.mustRun("unadopt", dir)
+	if out := h.mustRun("routes"); strings.Contains(out, "vite.itapp.localhost") {
+		t.Errorf("routes still lists the removed static route:\n%s", out)
+	}
+}
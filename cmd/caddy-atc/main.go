@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -15,9 +19,23 @@ import (
 	"time"
 
 	"github.com/g-brodiei/caddy-atc/internal/adopt"
+	"github.com/g-brodiei/caddy-atc/internal/browse"
 	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/dns"
+	"github.com/g-brodiei/caddy-atc/internal/doctor"
 	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/history"
+	"github.com/g-brodiei/caddy-atc/internal/hosts"
+	"github.com/g-brodiei/caddy-atc/internal/lan"
+	"github.com/g-brodiei/caddy-atc/internal/lint"
+	"github.com/g-brodiei/caddy-atc/internal/logs"
+	"github.com/g-brodiei/caddy-atc/internal/migrate"
+	"github.com/g-brodiei/caddy-atc/internal/oauth"
+	"github.com/g-brodiei/caddy-atc/internal/repro"
 	"github.com/g-brodiei/caddy-atc/internal/routes"
+	"github.com/g-brodiei/caddy-atc/internal/selftest"
+	"github.com/g-brodiei/caddy-atc/internal/serve"
+	"github.com/g-brodiei/caddy-atc/internal/service"
 	"github.com/g-brodiei/caddy-atc/internal/start"
 	"github.com/g-brodiei/caddy-atc/internal/update"
 	"github.com/g-brodiei/caddy-atc/internal/watcher"
@@ -26,6 +44,10 @@ import (
 
 var version = "dev"
 
+// exitDockerTimeout is the exit code when Docker stops answering, matching
+// timeout(1), so scripts can tell a hung daemon from other failures.
+const exitDockerTimeout = 124
+
 func main() {
 	updateCh := update.CheckAsync(version)
 
@@ -34,6 +56,9 @@ func main() {
 		Short:   "Local development gateway - route projects by hostname",
 		Long:    "caddy-atc eliminates Docker port conflicts by routing HTTP traffic through a single Caddy gateway using hostname-based routing (project.localhost).",
 		Version: version,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			migrateLegacyHome()
+		},
 		PersistentPostRun: func(cmd *cobra.Command, args []string) {
 			select {
 			case result := <-updateCh:
@@ -51,22 +76,79 @@ func main() {
 	rootCmd.AddCommand(downCmd())
 	rootCmd.AddCommand(adoptCmd())
 	rootCmd.AddCommand(unadoptCmd())
+	rootCmd.AddCommand(disableProjectCmd(true))
+	rootCmd.AddCommand(disableProjectCmd(false))
+	rootCmd.AddCommand(autostartCmd())
+	rootCmd.AddCommand(retryPageCmd())
+	rootCmd.AddCommand(mixedContentCmd())
 	rootCmd.AddCommand(statusCmd())
 	rootCmd.AddCommand(routesCmd())
+	rootCmd.AddCommand(openCmd())
 	rootCmd.AddCommand(trustCmd())
 	rootCmd.AddCommand(logsCmd())
 	rootCmd.AddCommand(startCmd())
 	rootCmd.AddCommand(stopProjectCmd())
 	rootCmd.AddCommand(updateCmd())
+	rootCmd.AddCommand(pauseCmd())
+	rootCmd.AddCommand(resumeCmd())
+	rootCmd.AddCommand(reloadCmd())
+	rootCmd.AddCommand(caddyfileCmd())
+	rootCmd.AddCommand(pinCmd())
+	rootCmd.AddCommand(unpinCmd())
+	rootCmd.AddCommand(routeCmd())
+	rootCmd.AddCommand(importCaddyfileCmd())
+	rootCmd.AddCommand(serveCmd())
+	rootCmd.AddCommand(serviceCmd())
+	rootCmd.AddCommand(oauthCmd())
+	rootCmd.AddCommand(doctorCmd())
+	rootCmd.AddCommand(selftestCmd())
+	rootCmd.AddCommand(reproCmd())
+	rootCmd.AddCommand(lintCmd())
+	rootCmd.AddCommand(gatewayCmd())
+	rootCmd.AddCommand(dnsCmd())
+	rootCmd.AddCommand(hostsCmd())
+	rootCmd.AddCommand(envCmd())
+	rootCmd.AddCommand(lanCmd())
+	rootCmd.AddCommand(exposeCmd())
+	rootCmd.AddCommand(shareCmd())
+	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(migrateCmd())
+	rootCmd.AddCommand(queryCmd())
 
 	if err := rootCmd.Execute(); err != nil {
+		if gateway.IsTimeout(err) {
+			os.Exit(exitDockerTimeout)
+		}
 		os.Exit(1)
 	}
 }
 
+// migrateLegacyHome moves ~/.caddy-atc to the XDG directories, unless a
+// watcher still uses it; the next command after it stops does the move.
+func migrateLegacyHome() {
+	if !config.LegacyHomeInUse() || isWatcherRunning() {
+		return
+	}
+	if err := config.MigrateLegacyHome(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: moving %s to the XDG directories: %v\n", config.LegacyHomeDir(), err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Moved %s to %s and %s.\n", config.LegacyHomeDir(), config.ConfigDir(), config.StateDir())
+	if path, err := service.DefinitionPath(); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprintln(os.Stderr, "Run 'caddy-atc service install' again so the login service uses the new location.")
+		}
+	}
+}
+
 func upCmd() *cobra.Command {
 	var detach bool
 	var daemon bool
+	var observe bool
+	var noAutostart bool
+	var listen string
+	var httpPort, httpsPort int
+	var logLevel, logFormat string
 
 	cmd := &cobra.Command{
 		Use:   "up",
@@ -74,40 +156,182 @@ func upCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
+			// Fail on a bad level or format before starting anything.
+			if _, err := watcher.NewLogger(io.Discard, logLevel, logFormat); err != nil {
+				return err
+			}
+
 			if daemon {
-				return runDaemon(ctx)
+				return runDaemon(ctx, logLevel, logFormat)
+			}
+
+			if observe {
+				if detach {
+					return fmt.Errorf("--observe cannot be combined with --detach")
+				}
+				fmt.Println("Starting watcher in observe mode (press Ctrl+C to stop)...")
+				return runObserver(ctx, logLevel, logFormat)
+			}
+
+			if listen != "" {
+				if err := setGatewayListen(ctx, listen); err != nil {
+					return err
+				}
+			}
+			if httpPort != 0 || httpsPort != 0 {
+				if err := setGatewayPorts(ctx, httpPort, httpsPort); err != nil {
+					return err
+				}
 			}
 
-			// Start gateway
-			fmt.Println("Starting caddy-atc gateway...")
-			if err := gateway.Up(ctx); err != nil {
+			// Start gateway, or with lazy startup leave that to the
+			// watcher once the first route appears.
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			lazy, idle, err := cfg.LazyGateway()
+			if err != nil {
 				return err
 			}
+			if lazy {
+				fmt.Printf("Lazy gateway: starts with the first route, stops after %s without routes.\n", idle)
+				if err := gateway.Prepare(ctx); err != nil {
+					return err
+				}
+			} else {
+				fmt.Println("Starting caddy-atc gateway...")
+				if err := gateway.Up(ctx); err != nil {
+					return err
+				}
+			}
+
+			if !noAutostart {
+				if err := runAutostart(ctx); err != nil {
+					return err
+				}
+			}
+
+			if hostname, _ := cfg.DashboardHostname(); hostname != "" {
+				_, port := cfg.HTTPPorts()
+				fmt.Printf("Dashboard: %s\n", config.SiteURL(hostname, port))
+			}
 
 			if detach {
-				return runDetached()
+				return runDetached(logLevel, logFormat)
+			}
+
+			if err := checkForegroundWatcher(); err != nil {
+				return err
 			}
 
 			// Start watcher in foreground
 			fmt.Println("Starting watcher (press Ctrl+C to stop)...")
-			return runWatcher(ctx)
+			return runWatcher(ctx, logLevel, logFormat)
 		},
 	}
 
 	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run watcher in the background")
+	cmd.Flags().BoolVar(&observe, "observe", false, "Log the routes and Caddyfile the watcher would produce without changing anything")
+	cmd.Flags().BoolVar(&noAutostart, "no-autostart", false, "Don't start the projects marked autostart")
+	cmd.Flags().StringVar(&listen, "listen", "", "Host IP to publish the gateway's ports on, e.g. 0.0.0.0 for LAN access (saved in projects.yml)")
+	cmd.Flags().IntVar(&httpPort, "http-port", 0, "Port the gateway serves HTTP on (saved in config.yml)")
+	cmd.Flags().IntVar(&httpsPort, "https-port", 0, "Port the gateway serves HTTPS on (saved in config.yml)")
+	cmd.Flags().StringVar(&logLevel, "log-level", "info", "Watcher log level: "+strings.Join(watcher.LogLevels, ", "))
+	cmd.Flags().StringVar(&logFormat, "log-format", watcher.LogFormatText, "Watcher log format: text or json")
 	cmd.Flags().BoolVar(&daemon, "_daemon", false, "Internal: child process entrypoint")
 	cmd.Flags().MarkHidden("_daemon")
 
 	return cmd
 }
 
+// setGatewayListen saves the gateway's listen address and stops a gateway
+// published elsewhere, so that it is recreated with the new address.
+func setGatewayListen(ctx context.Context, listen string) error {
+	if err := config.ValidateListenIP(listen); err != nil {
+		return err
+	}
+	if err := config.LoadAndModify(func(c *config.Config) error {
+		if c.Gateway == nil {
+			c.Gateway = &config.GatewayConfig{}
+		}
+		c.Gateway.Listen = listen
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("Gateway listens on %s (saved in projects.yml).\n", listen)
+
+	if running, err := gateway.IsRunning(ctx); err != nil || !running {
+		return nil
+	}
+	if ip, err := gateway.PublishedIP(ctx); err == nil && net.ParseIP(ip).Equal(net.ParseIP(listen)) {
+		return nil
+	}
+	fmt.Println("Recreating the gateway to apply it...")
+	return gateway.Down(ctx)
+}
+
+// setGatewayPorts saves the gateway's HTTP and HTTPS ports, leaving a zero
+// one as it is, and stops a gateway published on other ports so that it is
+// recreated with the new ones.
+func setGatewayPorts(ctx context.Context, httpPort, httpsPort int) error {
+	var before, after [2]int
+	if err := config.ModifySettings(func(s *config.Settings) error {
+		before[0], before[1] = (&config.Config{Settings: *s}).HTTPPorts()
+		if httpPort != 0 {
+			if err := s.Set("http_port", strconv.Itoa(httpPort)); err != nil {
+				return err
+			}
+		}
+		if httpsPort != 0 {
+			if err := s.Set("https_port", strconv.Itoa(httpsPort)); err != nil {
+				return err
+			}
+		}
+		after[0], after[1] = (&config.Config{Settings: *s}).HTTPPorts()
+		return s.Validate()
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("Gateway serves HTTP on port %d and HTTPS on port %d (saved in config.yml).\n", after[0], after[1])
+
+	if before == after {
+		return nil
+	}
+	if running, err := gateway.IsRunning(ctx); err != nil || !running {
+		return nil
+	}
+	fmt.Println("Recreating the gateway to apply it...")
+	return gateway.Down(ctx)
+}
+
 func downCmd() *cobra.Command {
-	return &cobra.Command{
+	var all bool
+
+	cmd := &cobra.Command{
 		Use:   "down",
 		Short: "Stop the caddy-atc gateway",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
+			if all {
+				// Routing is paused while the stacks stop, so the watcher
+				// doesn't reload Caddy for each container going away.
+				paused := watcher.CurrentPause() == nil && watcher.Pause("caddy-atc down --all", os.Getpid()) == nil
+				err := stopProjects(ctx)
+				if err == nil {
+					stopWatcher()
+				}
+				if paused {
+					watcher.Resume()
+				}
+				if err != nil {
+					return err
+				}
+				return gateway.Down(ctx)
+			}
+
 			// Stop watcher if running (via PID file)
 			stopWatcher()
 
@@ -115,12 +339,56 @@ func downCmd() *cobra.Command {
 			return gateway.Down(ctx)
 		},
 	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Stop every adopted project's compose stack first")
+
+	return cmd
+}
+
+// stopProjects stops the compose stacks of the adopted projects with
+// running containers, each before the projects it depends on. A project
+// that fails to stop is reported and the others still stop.
+func stopProjects(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	running, err := start.RunningProjects(ctx)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name, proj := range cfg.Projects {
+		if running[proj.ComposeProject] {
+			names = append(names, name)
+		}
+	}
+	order, err := cfg.StartOrder(names)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(order)
+
+	if len(order) == 0 {
+		fmt.Println("No adopted projects are running.")
+	}
+	for i, name := range order {
+		fmt.Printf("Stopping %s (%d/%d)...\n", name, i+1, len(order))
+		if err := start.Stop(ctx, cfg.Projects[name].Dir); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Printf("Warning: stopping %s: %v\n", name, err)
+		}
+	}
+	return nil
 }
 
 func adoptCmd() *cobra.Command {
 	var hostname string
 	var dryRun bool
 	var composeFile string
+	var fix, verify, injectCA, autostart, retryPage bool
 
 	cmd := &cobra.Command{
 		Use:   "adopt [directory]",
@@ -143,26 +411,36 @@ func adoptCmd() *cobra.Command {
 				return err
 			}
 
-			// Display results using the exported FindPrimaryService
-			primaryIdx := adopt.FindPrimaryService(result.HTTPServices)
+			if result.ProjectFile {
+				fmt.Printf("Using %s\n", config.ProjectFileName)
+			}
+			httpsPort := config.DefaultHTTPSPort
+			if cfg, err := config.Load(); err == nil {
+				_, httpsPort = cfg.HTTPPorts()
+			}
 			fmt.Println("Detected HTTP services:")
-			for i, svc := range result.HTTPServices {
-				h := svc.Name + "." + result.Hostname
-				if i == primaryIdx {
-					h = result.Hostname
+			for _, svc := range result.HTTPServices {
+				upstream := ""
+				if svc.UpstreamScheme == "https" {
+					upstream = " (upstream https)"
 				}
-				fmt.Printf("  %-12s (port %-5s) -> %s\n", svc.Name, svc.Port, h)
+				fmt.Printf("  %-12s (port %-5s) -> %s%s\n", svc.Name, svc.Port, displayHost(result.Hostnames[svc.Name], httpsPort), upstream)
 			}
 
 			if len(result.SkippedServices) > 0 {
 				fmt.Println()
-				fmt.Println("Skipped (non-HTTP):")
+				fmt.Println("Skipped:")
 				for _, svc := range result.SkippedServices {
-					ports := strings.Join(svc.Ports, ", ")
-					if ports == "" {
-						ports = "no ports"
+					reason := strings.Join(svc.Ports, ", ")
+					switch {
+					case svc.Ignored:
+						reason = config.IgnoreLabel + " label"
+					case reason == "":
+						reason = "non-HTTP, no ports"
+					default:
+						reason = "non-HTTP, ports " + reason
 					}
-					fmt.Printf("  %-12s (%s)\n", svc.Name, ports)
+					fmt.Printf("  %-12s (%s)\n", svc.Name, reason)
 				}
 			}
 
@@ -172,268 +450,2755 @@ func adoptCmd() *cobra.Command {
 			} else {
 				fmt.Printf("Saved to %s\n", config.ProjectsPath())
 			}
+			warnUnresolvable(cmd.Context(), result.Hostname)
+
+			if verify && !dryRun {
+				if err := adopt.EnableVerify(result.ProjectName); err != nil {
+					return err
+				}
+				fmt.Println()
+				verifyAdopted(cmd.Context(), result)
+			}
+
+			if injectCA && !dryRun {
+				if err := adopt.EnableInjectCA(result.ProjectName); err != nil {
+					return err
+				}
+				fmt.Println("The gateway's root CA will be injected into the project's services by 'caddy-atc start --regenerate'.")
+			}
+
+			if autostart && !dryRun {
+				if err := adopt.EnableAutostart(result.ProjectName); err != nil {
+					return err
+				}
+				fmt.Println("'caddy-atc up' will start the project's compose stack.")
+			}
+
+			if retryPage && !dryRun {
+				if err := adopt.EnableRetryPage(result.ProjectName); err != nil {
+					return err
+				}
+				fmt.Println("Browsers get a self-reloading page instead of a 502 while a container restarts.")
+			}
+
+			if findings, err := lint.LoopbackBinds(result.Dir, composeFile); err == nil && len(findings) > 0 {
+				fmt.Println()
+				for _, f := range findings {
+					fmt.Printf("Warning: %s\n", f)
+				}
+				fmt.Println("'caddy-atc start --fix-bind' rewrites the compose file's listen addresses in its stripped copy.")
+			}
 
-			// Check if any HTTP service uses hostname-based site address
 			fmt.Println()
-			fmt.Printf("NOTE: If your project's Caddyfile uses '%s' as the site address,\n", result.Hostname)
-			fmt.Println("      change it to ':80' so it accepts HTTP from the gateway.")
+			if fix {
+				if err := fixProxyConfigs(result, dryRun); err != nil {
+					return err
+				}
+			} else {
+				// Check if any HTTP service uses hostname-based site address
+				fmt.Printf("NOTE: If your project's Caddyfile uses '%s' as the site address,\n", result.Hostname)
+				fmt.Println("      change it to ':80' so it accepts HTTP from the gateway (or re-run with --fix).")
+			}
 			fmt.Println()
 			fmt.Println("Start your project normally - caddy-atc will auto-connect it.")
 
 			return nil
 		},
 	}
-
-	cmd.Flags().StringVar(&hostname, "hostname", "", "Override base hostname (default: <dirname>.localhost)")
-	cmd.Flags().StringVarP(&composeFile, "file", "f", "", "Path to docker-compose file (default: auto-detect)")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview without saving")
-
-	return cmd
+
+	cmd.Flags().StringVar(&hostname, "hostname", "", "Override base hostname (default: <dirname>.localhost)")
+	cmd.Flags().StringVarP(&composeFile, "file", "f", "", "Path to docker-compose file (default: auto-detect)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview without saving")
+	cmd.Flags().BoolVar(&fix, "fix", false, "Offer to patch the project's Caddyfile or nginx config for the gateway")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Request the project's hostnames through the gateway once routed, and report the responses")
+	cmd.Flags().BoolVar(&injectCA, "inject-ca", false, "Have 'start' mount the gateway's root CA into the project's services and set the CA variables")
+	cmd.Flags().BoolVar(&autostart, "autostart", false, "Have 'up' start the project's compose stack once the gateway is up")
+	cmd.Flags().BoolVar(&retryPage, "retry-page", false, "Show browsers a self-reloading page instead of a 502 while a container restarts")
+
+	return cmd
+}
+
+// warnUnresolvable warns when hostname is on a domain that needs DNS setup
+// and doesn't resolve yet.
+func warnUnresolvable(ctx context.Context, hostname string) {
+	host := strings.TrimPrefix(hostname, "*.")
+	domain := host[strings.LastIndex(host, "."):]
+	if cfg, err := config.Load(); err == nil {
+		if d, err := cfg.DomainSuffix(); err == nil && strings.HasSuffix(host, d) {
+			domain = d
+		}
+	}
+	warning := config.DomainWarning(domain)
+	if warning == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err == nil {
+		return
+	}
+	fmt.Printf("Warning: %s does not resolve. %s.\n", host, warning)
+}
+
+// verifyAdopted requests the adopted project's routed hostnames through the
+// gateway and prints how they responded. If the project isn't routed yet,
+// the watcher verifies it when it starts.
+func verifyAdopted(ctx context.Context, result *adopt.Result) {
+	later := "Routes will be verified through the gateway when the project starts (see 'caddy-atc logs')."
+	if !isWatcherRunning() {
+		fmt.Println(later)
+		return
+	}
+	activeRoutes, err := routes.ListActive(ctx)
+	if err != nil {
+		fmt.Printf("Skipping verification: %v\n", err)
+		return
+	}
+	var hostnames []string
+	for _, r := range activeRoutes {
+		if r.Project == result.ProjectName && r.Status != "detected (not connected)" && r.Quarantine == "" &&
+			!strings.HasPrefix(r.Hostname, "*.") && !slices.Contains(hostnames, r.Hostname) {
+			hostnames = append(hostnames, r.Hostname)
+		}
+	}
+	if len(hostnames) == 0 {
+		fmt.Println(later)
+		return
+	}
+
+	addr, httpsPort := config.DefaultGatewayAddress, config.DefaultHTTPSPort
+	if cfg, err := config.Load(); err == nil {
+		addr, _ = cfg.GatewayAddress()
+		_, httpsPort = cfg.HTTPPorts()
+	}
+	fmt.Println("Verifying routes through the gateway:")
+	for _, h := range hostnames {
+		status, elapsed, err := watcher.VerifyRoute(ctx, net.JoinHostPort(addr, strconv.Itoa(httpsPort)), h)
+		if err != nil {
+			fmt.Printf("  %s failed: %v\n", h, err)
+			continue
+		}
+		fmt.Printf("  %s responded %d in %s\n", h, status, elapsed)
+	}
+}
+
+// fixProxyConfigs proposes patches to the project's own Caddyfile or nginx
+// config so it serves the adopted hostnames, and applies them if confirmed.
+func fixProxyConfigs(result *adopt.Result, dryRun bool) error {
+	hostnames := []string{result.Hostname}
+	for _, h := range result.Hostnames {
+		if !slices.Contains(hostnames, h) {
+			hostnames = append(hostnames, h)
+		}
+	}
+	slices.Sort(hostnames[1:])
+
+	fixes, err := lint.Fixes(result.Dir, hostnames)
+	if err != nil {
+		return err
+	}
+	if len(fixes) == 0 {
+		fmt.Println("No project Caddyfile or nginx config needs patching.")
+		return nil
+	}
+
+	fmt.Println("Proposed changes:")
+	for _, f := range fixes {
+		fmt.Printf("  %s:%d\n    - %s\n    + %s\n", f.File, f.Line, strings.TrimSpace(f.Old), strings.TrimSpace(f.New))
+	}
+	if dryRun || !confirm("Apply these changes?") {
+		fmt.Println("No files changed.")
+		return nil
+	}
+	if err := lint.Apply(result.Dir, fixes); err != nil {
+		return err
+	}
+	fmt.Printf("Patched %d file(s); restart the project to pick up the changes.\n", len(fixes))
+	return nil
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func unadoptCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unadopt [directory]",
+		Short: "Remove a project from automatic routing",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+
+			if err := adopt.Unadopt(dir); err != nil {
+				return err
+			}
+
+			fmt.Println("Project removed from caddy-atc.")
+			return nil
+		},
+	}
+}
+
+// disableProjectCmd returns the disable command, or enable when disable
+// is false.
+func disableProjectCmd(disable bool) *cobra.Command {
+	use, short, done := "disable <project>", "Stop routing a project without unadopting it", "disabled"
+	if !disable {
+		use, short, done = "enable <project>", "Route a disabled project again", "enabled"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long: `Disable a project to park it: it stays in projects.yml with its
+hostname and service mappings, which no other project can claim, but its
+containers are not routed. Enable it to route them again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			changed := false
+			err := config.LoadAndModify(func(cfg *config.Config) error {
+				var err error
+				changed, err = cfg.SetProjectDisabled(name, disable)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if !changed {
+				fmt.Printf("Project %s is already %s.\n", name, done)
+				return nil
+			}
+			fmt.Printf("Project %s %s.\n", name, done)
+			syncWatcher(cmd.Context())
+			return nil
+		},
+	}
+}
+
+func autostartCmd() *cobra.Command {
+	var off bool
+
+	cmd := &cobra.Command{
+		Use:   "autostart [project]",
+		Short: "Start a project's compose stack with 'caddy-atc up'",
+		Long: `Mark a project to have 'caddy-atc up' start its compose stack, as
+'caddy-atc start' would, once the gateway is up. With the login service
+installed, the stack comes up at login. Without a project, list the
+projects marked autostart.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				if off {
+					return fmt.Errorf("--off needs a project")
+				}
+				cfg, err := config.Load()
+				if err != nil {
+					return err
+				}
+				names := cfg.AutostartProjects()
+				if len(names) == 0 {
+					fmt.Println("No projects are started by 'caddy-atc up'.")
+					return nil
+				}
+				for _, name := range names {
+					fmt.Printf("  %s (%s)\n", name, cfg.Projects[name].Dir)
+				}
+				return nil
+			}
+
+			name := args[0]
+			changed := false
+			err := config.LoadAndModify(func(cfg *config.Config) error {
+				var err error
+				changed, err = cfg.SetProjectAutostart(name, !off)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			switch {
+			case !changed && off:
+				fmt.Printf("Project %s is not started by 'caddy-atc up'.\n", name)
+			case !changed:
+				fmt.Printf("Project %s is already started by 'caddy-atc up'.\n", name)
+			case off:
+				fmt.Printf("Project %s will no longer be started by 'caddy-atc up'.\n", name)
+			default:
+				fmt.Printf("Project %s will be started by 'caddy-atc up'.\n", name)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&off, "off", false, "Stop starting the project with 'caddy-atc up'")
+
+	return cmd
+}
+
+func retryPageCmd() *cobra.Command {
+	var off bool
+
+	cmd := &cobra.Command{
+		Use:   "retry-page <project>",
+		Short: "Show browsers a self-reloading page while a project's container restarts",
+		Long: `Have the gateway answer browsers with a page that reloads itself, backing
+off from one second, instead of a bare 502 while a container of the project
+can't be reached, e.g. during a compose restart. Other clients, like API
+calls, still get the error.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			changed := false
+			err := config.LoadAndModify(func(cfg *config.Config) error {
+				var err error
+				changed, err = cfg.SetProjectRetryPage(name, !off)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			switch {
+			case !changed && off:
+				fmt.Printf("Project %s has no retry page.\n", name)
+				return nil
+			case !changed:
+				fmt.Printf("Project %s already has the retry page.\n", name)
+				return nil
+			case off:
+				fmt.Printf("Project %s answers 502 again while unreachable.\n", name)
+			default:
+				fmt.Printf("Project %s shows the retry page while unreachable.\n", name)
+			}
+			syncWatcher(cmd.Context())
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&off, "off", false, "Answer 502 again instead of the retry page")
+
+	return cmd
+}
+
+func mixedContentCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "mixed-content <project> [upgrade|relax|off]",
+		Short: "Help apps written for http://localhost work over HTTPS",
+		Long: `Set how the gateway adjusts a project's responses for apps that assume
+plain http://localhost:
+
+  upgrade  add "Content-Security-Policy: upgrade-insecure-requests", so
+           browsers load http:// scripts, images and API calls over https
+           instead of blocking them as mixed content
+  relax    drop the app's own Content-Security-Policy headers, whose
+           http://localhost sources don't match the gateway's origins
+  off      leave responses alone
+
+Without a mode, print the project's current one.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			if len(args) == 1 {
+				cfg, err := config.Load()
+				if err != nil {
+					return err
+				}
+				proj, ok := cfg.Projects[name]
+				if !ok {
+					return fmt.Errorf("project %q is not adopted", name)
+				}
+				mode := proj.MixedContent
+				if mode == "" {
+					mode = "off"
+				}
+				fmt.Printf("%s: %s\n", name, mode)
+				return nil
+			}
+
+			mode := args[1]
+			if mode == "off" {
+				mode = ""
+			}
+			changed := false
+			err := config.LoadAndModify(func(cfg *config.Config) error {
+				var err error
+				changed, err = cfg.SetProjectMixedContent(name, mode)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if !changed {
+				fmt.Printf("Project %s already uses mixed-content mode %s.\n", name, args[1])
+				return nil
+			}
+			fmt.Printf("Project %s uses mixed-content mode %s.\n", name, args[1])
+			syncWatcher(cmd.Context())
+			return nil
+		},
+	}
+}
+
+// runAutostart starts the compose stacks of the projects marked autostart,
+// dependencies first. A project that fails to start is reported and the others still start.
+func runAutostart(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	names, err := cfg.StartOrder(cfg.AutostartProjects())
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		fmt.Printf("\nAutostarting %s (%d/%d)...\n", name, i+1, len(names))
+		if err := start.Run(ctx, start.Options{Dir: cfg.Projects[name].Dir}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Printf("Warning: starting %s: %v\n", name, err)
+		}
+	}
+	if len(names) > 0 {
+		fmt.Println()
+	}
+	return nil
+}
+
+func statusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show gateway health and active routes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if err := gateway.Ping(ctx); err != nil {
+				return printLastKnown(err, true)
+			}
+
+			// Check gateway
+			running, err := gateway.IsRunning(ctx)
+			if err != nil {
+				return err
+			}
+
+			if running {
+				fmt.Println("Gateway: running" + portsNote())
+			} else if isWatcherRunning() && lazyGateway() {
+				fmt.Println("Gateway: stopped (starts with the first route)")
+				fmt.Println("Watcher: running")
+				return nil
+			} else {
+				fmt.Println("Gateway: stopped")
+				return nil
+			}
+
+			// Check watcher
+			if st, err := watcher.WatcherStatus(ctx); err == nil {
+				fmt.Println("Watcher: running" + watcherNote(st))
+			} else if isWatcherRunning() {
+				if st := watcher.CurrentPause(); st != nil {
+					fmt.Printf("Watcher: running (paused: %s)%s\n", st.Reason, driftNote())
+				} else {
+					fmt.Println("Watcher: running" + driftNote())
+				}
+			} else {
+				fmt.Println("Watcher: stopped")
+			}
+
+			fmt.Println()
+
+			// List routes
+			activeRoutes, err := routes.ListActive(ctx)
+			if err != nil {
+				return fmt.Errorf("listing routes: %w", err)
+			}
+
+			if len(activeRoutes) == 0 {
+				fmt.Println("No active routes.")
+			} else {
+				fmt.Printf("Active routes (%d):\n", len(activeRoutes))
+				printRouteTable(activeRoutes)
+			}
+
+			if disabled := disabledProjects(); len(disabled) > 0 {
+				fmt.Printf("\nDisabled projects: %s\n", strings.Join(disabled, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+// watcherNote describes the running watcher's state as it reported it:
+// paused, reconnecting to Docker, or repairing drift.
+func watcherNote(st *watcher.Status) string {
+	var notes []string
+	if st.Paused != "" {
+		note := "paused: " + st.Paused
+		if st.Maintenance {
+			note += ", serving maintenance page"
+		}
+		if st.Pending {
+			note += ", changes pending"
+		}
+		notes = append(notes, note)
+	}
+	if st.DockerLost {
+		notes = append(notes, "reconnecting to Docker")
+	}
+	if st.DriftRepairs > 0 {
+		notes = append(notes, fmt.Sprintf("%d drift corrections", st.DriftRepairs))
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(notes, "; ") + ")"
+}
+
+// driftNote describes the routes the running watcher repaired after
+// missed container events, or returns "" if it hasn't had to.
+func driftNote() string {
+	snap := watcher.LoadSnapshot()
+	if snap == nil || snap.DriftRepairs == 0 {
+		return ""
+	}
+	// A snapshot older than the PID file was saved by an earlier watcher.
+	if info, err := os.Stat(config.PidPath()); err == nil && snap.Saved.Before(info.ModTime()) {
+		return ""
+	}
+	return fmt.Sprintf(" (%d drift corrections)", snap.DriftRepairs)
+}
+
+// disabledProjects returns the names of disabled projects, sorted.
+func disabledProjects() []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for name, proj := range cfg.Projects {
+		if proj.Disabled {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// printLastKnown stands in for status and routes while Docker is
+// unreachable, showing the state persisted by caddy-atc instead.
+func printLastKnown(dockerErr error, showProjects bool) error {
+	fmt.Printf("Docker unreachable - showing last-known state\n  %v\n\n", dockerErr)
+
+	if showProjects {
+		if isWatcherRunning() {
+			fmt.Println("Watcher: running")
+		} else {
+			fmt.Println("Watcher: stopped")
+		}
+		fmt.Println()
+
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(cfg.Projects))
+		for name := range cfg.Projects {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		if len(names) == 0 {
+			fmt.Println("No adopted projects.")
+		} else {
+			fmt.Printf("Adopted projects (%d):\n", len(names))
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PROJECT\tHOSTNAME\tDIRECTORY")
+			for _, name := range names {
+				proj := cfg.Projects[name]
+				if proj.Disabled {
+					name += " (disabled)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", name, proj.Hostname, proj.Dir)
+			}
+			w.Flush()
+		}
+		fmt.Println()
+	}
+
+	known, saved := routes.ListKnown()
+	if saved.IsZero() {
+		fmt.Println("No routes recorded yet.")
+		return nil
+	}
+	if len(known) == 0 {
+		fmt.Printf("No routes as of %s.\n", saved.Local().Format(time.DateTime))
+		return nil
+	}
+	fmt.Printf("Routes as of %s (%d):\n", saved.Local().Format(time.DateTime), len(known))
+	printRouteTable(known)
+	return nil
+}
+
+func routesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "routes",
+		Short: "List all active routes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if err := gateway.Ping(ctx); err != nil {
+				return printLastKnown(err, false)
+			}
+			activeRoutes, err := routes.ListActive(ctx)
+			if err != nil {
+				return err
+			}
+
+			if len(activeRoutes) == 0 {
+				fmt.Println("No active routes.")
+				return nil
+			}
+
+			printRouteTable(activeRoutes)
+			return nil
+		},
+	}
+}
+
+func openCmd() *cobra.Command {
+	var plainHTTP, printOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "open [service|hostname]",
+		Short: "Open a route in the default browser",
+		Long: `Open https://<hostname> in the default browser ($BROWSER if set).
+
+Without arguments, opens the base hostname of the project in the current
+directory. A service name opens that service's hostname, and anything with
+a dot is taken as a hostname.
+
+Examples:
+  caddy-atc open
+  caddy-atc open api
+  caddy-atc open admin.localhost --print`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			url, err := browse.URL(cmd.Context(), ".", target, plainHTTP)
+			if err != nil {
+				return err
+			}
+			if printOnly {
+				fmt.Println(url)
+				return nil
+			}
+			fmt.Printf("Opening %s\n", url)
+			return browse.Open(url)
+		},
+	}
+
+	cmd.Flags().BoolVar(&plainHTTP, "http", false, "Use http:// instead of https://")
+	cmd.Flags().BoolVar(&printOnly, "print", false, "Print the URL instead of opening it")
+	return cmd
+}
+
+func trustCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trust",
+		Short: "Install Caddy's root CA in system trust store",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return gateway.Trust(cmd.Context())
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "audit",
+		Short: "List where Caddy root CAs are installed and flag stale or duplicate ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := gateway.AuditTrust(cmd.Context())
+			if err != nil {
+				return err
+			}
+			printTrustAudit(report)
+			return nil
+		},
+	})
+
+	var caCert, caKey, caRoot string
+	importCmd := &cobra.Command{
+		Use:   "import-ca --cert <file> --key <file> [--root <file>]",
+		Short: "Sign certificates with a team-provided CA instead of a per-machine one",
+		Long: `Import a team CA so every developer's certificates chain to a root that
+IT already trusts on managed devices, making 'caddy-atc trust' unnecessary.
+
+Pass a root CA and its key, or an intermediate CA and its key with --root
+naming the root it chains to (the root's key then stays with the team).
+The gateway's issued certificates are discarded and it restarts, so every
+site gets a certificate from the team CA.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireGatewayAndWatcher(cmd.Context()); err != nil {
+				return err
+			}
+			mode, err := gateway.ImportCA(caCert, caKey, caRoot)
+			if err != nil {
+				return err
+			}
+			if err := setTeamCA(mode); err != nil {
+				return err
+			}
+			if err := gateway.ReissueCerts(cmd.Context(), true); err != nil {
+				return err
+			}
+
+			root, err := gateway.TeamRoot()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Certificates are now signed by the team CA (%s).\n", root.Subject.CommonName)
+			if trusted, err := gateway.CATrusted(cmd.Context()); err == nil && !trusted {
+				fmt.Println("This machine doesn't trust it yet; install it with 'caddy-atc trust'.")
+			}
+			return nil
+		},
+	}
+	importCmd.Flags().StringVar(&caCert, "cert", "", "PEM certificate of the team root or intermediate CA")
+	importCmd.Flags().StringVar(&caKey, "key", "", "PEM private key of that CA (unencrypted)")
+	importCmd.Flags().StringVar(&caRoot, "root", "", "PEM root certificate an intermediate --cert chains to")
+	importCmd.MarkFlagRequired("cert")
+	importCmd.MarkFlagRequired("key")
+	cmd.AddCommand(importCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "reset-ca",
+		Short: "Stop using an imported team CA and go back to the gateway's own CA",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireGatewayAndWatcher(cmd.Context()); err != nil {
+				return err
+			}
+			if err := setTeamCA(""); err != nil {
+				return err
+			}
+			if err := gateway.ReissueCerts(cmd.Context(), false); err != nil {
+				return err
+			}
+			if err := gateway.RemoveCA(); err != nil {
+				return err
+			}
+			fmt.Println("Certificates are signed by the gateway's own CA again; trust it with 'caddy-atc trust'.")
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+// requireGatewayAndWatcher fails unless both run, as changing the CA needs
+// the watcher to render it and the gateway to reissue certificates.
+func requireGatewayAndWatcher(ctx context.Context) error {
+	running, err := gateway.IsRunning(ctx)
+	if err != nil {
+		return err
+	}
+	if !running || !isWatcherRunning() {
+		return fmt.Errorf("caddy-atc is not running - run 'caddy-atc up' first")
+	}
+	return nil
+}
+
+// setTeamCA records the team CA mode in the pki settings.
+func setTeamCA(mode string) error {
+	return config.LoadAndModify(func(c *config.Config) error {
+		if c.PKI == nil {
+			c.PKI = &config.PKIConfig{}
+		}
+		c.PKI.TeamCA = mode
+		if *c.PKI == (config.PKIConfig{}) {
+			c.PKI = nil
+		}
+		return nil
+	})
+}
+
+func printTrustAudit(report *gateway.TrustAuditReport) {
+	if report.Current == "" {
+		fmt.Println("Gateway root CA: unknown (is the gateway running?)")
+	} else {
+		fmt.Println("Gateway root CA: SHA-256", report.Current)
+	}
+	if len(report.CAs) == 0 {
+		fmt.Println("\nNo Caddy root CAs found. Run 'caddy-atc trust' to install one.")
+		return
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCATION\tKIND\tNAME\tFINGERPRINT\tEXPIRES\tSTATUS")
+	var remove []string
+	for _, ca := range report.CAs {
+		status := ca.Status
+		if ca.Duplicate {
+			status += ", duplicate"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			ca.Location, ca.Kind, ca.CommonName, ca.Fingerprint[:16], ca.NotAfter.Format("2006-01-02"), status)
+		if ca.Remove != "" {
+			remove = append(remove, ca.Remove)
+		}
+	}
+	w.Flush()
+
+	if len(remove) > 0 {
+		fmt.Println("\nRemove stale and expired root CAs with:")
+		for _, r := range remove {
+			fmt.Println("  " + r)
+		}
+	}
+	for _, ca := range report.CAs {
+		if ca.Duplicate && ca.Status == gateway.CACurrent {
+			fmt.Println("\nThe gateway's root CA is installed more than once; keep one copy per trust store.")
+			break
+		}
+	}
+}
+
+func logsCmd() *cobra.Command {
+	var follow, access, withGateway bool
+	var project, method, status string
+
+	cmd := &cobra.Command{
+		Use:   "logs [watcher|gateway|access [hostname...]]",
+		Short: "Show watcher, gateway or access logs",
+		Long: `Show caddy-atc logs. The scope selects the source:
+
+  watcher  the watcher's log (default)
+  gateway  the Caddy container's output
+  access   per-host JSON access logs written by the gateway
+
+--project limits the output to one adopted project's containers and hostnames.
+Access logs can also be limited to hostnames, and to requests by method and
+status. --access is short for the access scope, so every argument is a
+hostname. --gateway merges the gateway's output into the watcher log in time
+order, each line marked with its source, to see both sides of a failing route.
+
+Examples:
+  caddy-atc logs --gateway -f
+  caddy-atc logs access myapp.localhost -f
+  caddy-atc logs --access api.localhost --method POST,PUT --status 5xx
+  caddy-atc logs --access --status 404,401`,
+		Args: cobra.ArbitraryArgs,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 && !access {
+				return logs.Scopes, cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := logs.Options{Project: project, Method: method, Status: status, Gateway: withGateway, Follow: follow}
+			switch {
+			case access:
+				opts.Scope, opts.Hosts = logs.ScopeAccess, args
+			case len(args) > 0:
+				opts.Scope, opts.Hosts = args[0], args[1:]
+			}
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+			return logs.Show(ctx, os.Stdout, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output")
+	cmd.Flags().StringVar(&project, "project", "", "Only show logs for this adopted project")
+	cmd.Flags().BoolVar(&access, "access", false, "Show access logs; arguments are hostnames")
+	cmd.Flags().BoolVar(&withGateway, "gateway", false, "Merge the gateway's output into the watcher log")
+	cmd.Flags().StringVar(&method, "method", "", "Only show requests with these methods (access logs, comma-separated)")
+	cmd.Flags().StringVar(&status, "status", "", "Only show responses with these statuses, e.g. 404 or 5xx (access logs, comma-separated)")
+	return cmd
+}
+
+func startCmd() *cobra.Command {
+	var keepPorts string
+	var composeFile string
+	var regenerate bool
+	var fixBind bool
+	var healthcheck bool
+	var profiles []string
+	var validate bool
+	var waitTimeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "start [directory] [-- command...]",
+		Short: "Start project with ports stripped (avoids conflicts)",
+		Long: `Strip host port bindings from the project's compose file and run a command.
+
+The stripped compose file is set via COMPOSE_FILE env var, so any docker compose
+calls inside your script transparently use it. The caddy-atc watcher handles
+routing automatically. Without a command, start then waits until the
+project's routes are served and healthy.
+
+Only services enabled by --profile, or else COMPOSE_PROFILES, are adopted
+and given healthchecks, as only those run. A compose file variant such as
+docker-compose.dev.yml that only amends services is loaded after the base
+file, as with 'docker compose -f docker-compose.yml -f docker-compose.dev.yml'.
+
+Examples:
+  caddy-atc start                          # docker compose up -d (default)
+  caddy-atc start -- ./scripts/dev.sh      # custom command
+  caddy-atc start --keep-ports db,redis    # keep host ports for db and redis
+  caddy-atc start -f docker-compose.demo.yaml  # use custom compose file
+  caddy-atc start -f docker-compose.dev.yml --profile debug  # dev variant with the debug profile
+  caddy-atc start --fix-bind --regenerate  # make loopback-bound servers listen on 0.0.0.0
+  caddy-atc start --healthcheck --regenerate  # give routed services a healthcheck
+  caddy-atc start --validate               # check the stripped files with docker compose config first`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			dir := "."
+			var userCmd []string
+
+			dashIdx := cmd.ArgsLenAtDash()
+			if dashIdx >= 0 {
+				if dashIdx > 0 {
+					dir = args[0]
+				}
+				userCmd = args[dashIdx:]
+			} else if len(args) > 0 {
+				dir = args[0]
+			}
+
+			var keepPortsList []string
+			if keepPorts != "" {
+				keepPortsList = strings.Split(keepPorts, ",")
+			}
+
+			return start.Run(ctx, start.Options{
+				Dir:         dir,
+				KeepPorts:   keepPortsList,
+				Command:     userCmd,
+				ComposeFile: composeFile,
+				Regenerate:  regenerate,
+				FixBind:     fixBind,
+				Healthcheck: healthcheck,
+				Profiles:    profiles,
+				Validate:    validate,
+				WaitTimeout: waitTimeout,
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&keepPorts, "keep-ports", "", "Comma-separated service names to keep host port bindings (e.g. db,redis)")
+	cmd.Flags().StringVarP(&composeFile, "file", "f", "", "Path to docker-compose file (default: auto-detect or use saved config)")
+	cmd.Flags().BoolVar(&regenerate, "regenerate", false, "Force regeneration of stripped compose file (overwrites customizations)")
+	cmd.Flags().BoolVar(&fixBind, "fix-bind", false, "Rewrite --host 127.0.0.1 style listen addresses to 0.0.0.0 in the stripped compose file")
+	cmd.Flags().BoolVar(&healthcheck, "healthcheck", false, "Add a healthcheck to routed services that define none in the stripped compose file")
+	cmd.Flags().BoolVar(&validate, "validate", false, "Check the stripped compose files with 'docker compose config' before running the command")
+	cmd.Flags().StringSliceVar(&profiles, "profile", nil, "Compose profile to enable, repeatable (default: COMPOSE_PROFILES)")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 2*time.Minute, "How long to wait for the project's routes to be served and healthy (0 to not wait)")
+
+	return cmd
+}
+
+func stopProjectCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop [directory]",
+		Short: "Stop project containers",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return start.Stop(cmd.Context(), dir)
+		},
+	}
+}
+
+func updateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "update",
+		Short: "Update caddy-atc to the latest version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if version == "dev" {
+				return fmt.Errorf("cannot update a development build (built from source without version tag)")
+			}
+
+			fmt.Printf("Current version: %s\n", version)
+			fmt.Println("Checking for updates...")
+
+			result := update.CheckSync(version)
+			if result == nil {
+				return fmt.Errorf("could not check for updates (network error?)")
+			}
+
+			if !result.UpdateAvail {
+				fmt.Printf("Already up to date (%s)\n", version)
+				return nil
+			}
+
+			fmt.Printf("New version available: %s\n\n", result.LatestVersion)
+			return update.SelfUpdate(result.LatestVersion)
+		},
+	}
+}
+
+func oauthCmd() *cobra.Command {
+	var path string
+	var svc string
+	var inspect bool
+	var off bool
+
+	cmd := &cobra.Command{
+		Use:   "oauth [directory]",
+		Short: "Show stable OAuth redirect URIs and toggle the callback inspector",
+		Long: `Print the redirect URIs to register with an OAuth provider for each
+routed service. Unlike localhost:<port>, these stay the same across restarts.
+
+With --inspect, the gateway answers the callback path itself, echoing the
+method, URI and query as plain text; the request also appears in
+'caddy-atc logs access', so you can see exactly what the provider sends.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			if inspect && off {
+				return fmt.Errorf("--inspect cannot be combined with --off")
+			}
+
+			if off {
+				n, err := oauth.DisableInspector(dir)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					fmt.Println("Callback inspector was not enabled.")
+				} else {
+					fmt.Println("Callback inspector disabled.")
+				}
+				return nil
+			}
+
+			uris, err := oauth.RedirectURIs(dir, path)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Register these redirect URIs with your OAuth provider:")
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, u := range uris {
+				fmt.Fprintf(w, "  %s\t%s\n", u.Service, u.URI)
+			}
+			w.Flush()
+
+			if inspect {
+				url, err := oauth.EnableInspector(dir, svc, path)
+				if err != nil {
+					return err
+				}
+				fmt.Println()
+				fmt.Printf("Callback inspector enabled on %s\n", url)
+				fmt.Println("Requests to it are answered by the gateway and logged (docker logs caddy-atc).")
+				fmt.Println("Disable with: caddy-atc oauth --off")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", oauth.DefaultCallbackPath, "Callback path")
+	cmd.Flags().BoolVar(&inspect, "inspect", false, "Answer the callback path at the gateway and log requests")
+	cmd.Flags().StringVar(&svc, "service", "", "Service to inspect (default: the service on the base hostname)")
+	cmd.Flags().BoolVar(&off, "off", false, "Disable the callback inspector")
+
+	return cmd
+}
+
+func serviceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Run the gateway and watcher at login (systemd user unit / launchd agent)",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "install",
+		Short: "Install and start the login service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := service.Install(); err != nil {
+				return err
+			}
+			path, _ := service.DefinitionPath()
+			fmt.Printf("Service installed: %s\n", path)
+			fmt.Println("caddy-atc will now start at login.")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the login service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := service.Uninstall(); err != nil {
+				return err
+			}
+			fmt.Println("Service removed.")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show whether the login service is installed and running",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state, err := service.Status()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Service: %s\n", state)
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func doctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose Docker, the gateway, certificates, DNS and ports",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checks := doctor.Run(cmd.Context())
+			if isWatcherRunning() {
+				checks = append(checks, doctor.Check{Name: "Watcher", OK: true, Detail: "running"})
+			} else {
+				checks = append(checks, doctor.Check{Name: "Watcher", OK: false, Detail: "not running", Hint: "run 'caddy-atc up'"})
+			}
+			return printChecks(checks)
+		},
+	}
+}
+
+func reproCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repro",
+		Short: "Generate sample projects for reproducing routing bugs",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the scenarios 'repro new' can generate",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range repro.Names() {
+				fmt.Printf("  %-24s %s\n", name, repro.Scenarios[name].Description)
+			}
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "new <scenario> [dir]",
+		Short: "Generate a minimal compose project exhibiting a scenario",
+		Long: `Write a minimal compose project exhibiting scenario into dir (default
+./caddy-atc-repro-<scenario>), with a README of the steps to run and the
+expected behavior. Attach the directory, or its files, to a bug report.
+
+Run 'caddy-atc repro list' for the scenarios.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "caddy-atc-repro-" + args[0]
+			if len(args) > 1 {
+				dir = args[1]
+			}
+			if err := repro.New(dir, args[0], version); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote the %s scenario to %s; its README.md has the steps to reproduce it.\n", args[0], dir)
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func selftestCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "selftest",
+		Short: "Route a sample project through the running gateway end to end",
+		Long: `Check that caddy-atc works in this environment: adopt and start a
+sample compose project, wait for the watcher to route it, request it over
+HTTPS through the gateway, route a hostname to a server on this machine,
+and reload. The sample project and route are removed afterwards.
+
+The gateway and watcher must be running ('caddy-atc up -d'). The sample
+runs the gateway image, so nothing is pulled.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checks := selftest.Run(cmd.Context(), os.Stdout)
+			fmt.Println()
+			return printChecks(checks)
+		},
+	}
+}
+
+// printChecks prints checks as a PASS/FAIL table with hints for failures,
+// returning an error if any failed.
+func printChecks(checks []doctor.Check) error {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range checks {
+		mark := "PASS"
+		if !c.OK {
+			mark = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", mark, c.Name, c.Detail)
+		if !c.OK && c.Hint != "" {
+			fmt.Fprintf(w, "\t\t-> %s\n", c.Hint)
+		}
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func lintCmd() *cobra.Command {
+	var composeFile string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "lint [directory]",
+		Short: "Flag compose setups that break routing through the gateway",
+		Long: `Check a project for dev-compose patterns that don't work behind the
+gateway:
+
+  caddyfile-hostname  a project Caddyfile addresses sites by hostname
+  localhost-env       an environment value points at localhost:PORT
+  missing-port        a built service has no EXPOSE or port label
+  port-collision      a host port is published twice, or 80/443 is published
+
+Exits non-zero when anything is found, for use in pre-commit hooks.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q (want text or json)", format)
+			}
+
+			findings, err := lint.Run(dir, composeFile)
+			if err != nil {
+				return err
+			}
+
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if findings == nil {
+					findings = []lint.Finding{}
+				}
+				if err := enc.Encode(findings); err != nil {
+					return err
+				}
+			} else {
+				for _, f := range findings {
+					fmt.Println(f)
+				}
+			}
+
+			if len(findings) > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d problem(s) found", len(findings))
+			}
+			if format == "text" {
+				fmt.Println("No problems found.")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&composeFile, "file", "f", "", "Path to docker-compose file (default: auto-detect)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text or json")
+	return cmd
+}
+
+func gatewayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gateway",
+		Short: "Manage the gateway's Caddy instance",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:       "log-level [debug|info|warn|error]",
+		Short:     "Show or change the gateway's log level without restarting it",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: config.LogLevels,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				level, err := gateway.LogLevel()
+				if err != nil {
+					return err
+				}
+				fmt.Println(level)
+				return nil
+			}
+
+			if err := gateway.SetLogLevel(args[0]); err != nil {
+				return err
+			}
+			if isWatcherRunning() {
+				fmt.Printf("Gateway log level set to %s; the watcher reloads Caddy with it now.\n", args[0])
+			} else {
+				fmt.Printf("Gateway log level set to %s; it applies once the watcher runs ('caddy-atc up').\n", args[0])
+			}
+			fmt.Println("Follow with 'caddy-atc logs gateway -f'.")
+			return nil
+		},
+	})
+
+	var image string
+	upgradeCmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Pull the gateway image and recreate the gateway, keeping its CA and certificates",
+		Long: `Pull the configured gateway image (image in config.yml, default
+caddy:2-alpine) and recreate the gateway container on it if it changed.
+With plugins installed ('gateway plugins'), the image is rebuilt with them
+instead. The data volume is kept, so the local CA and issued certificates
+survive. --image switches to another image, such as a custom build, and
+saves it in config.yml.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return gateway.Upgrade(cmd.Context(), image)
+		},
+	}
+	upgradeCmd.Flags().StringVar(&image, "image", "", "Image to switch to, e.g. caddy:2.10-alpine or a custom build")
+	cmd.AddCommand(upgradeCmd)
+	cmd.AddCommand(pluginsCmd())
+
+	return cmd
+}
+
+func pluginsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plugins",
+		Short: "Build Caddy plugins into the gateway with xcaddy",
+		Long: `Build Caddy plugins (e.g. github.com/mholt/caddy-l4, github.com/caddy-dns/cloudflare
+or github.com/corazawaf/coraza-caddy/v2) into a custom gateway image with
+xcaddy, run inside Docker. The gateway switches to the new image, keeping its
+CA and certificates. Plugins are kept in projects.yml, and 'gateway upgrade'
+rebuilds with the same set.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			plugins, err := gateway.Plugins()
+			if err != nil {
+				return err
+			}
+			if len(plugins) == 0 {
+				fmt.Println("No plugins installed. Add one with 'caddy-atc gateway plugins add <module>'.")
+				return nil
+			}
+			for _, p := range plugins {
+				fmt.Println(p)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <module>[@version]...",
+		Short: "Rebuild the gateway image with plugins added",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return gateway.AddPlugins(cmd.Context(), args)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <module>...",
+		Short: "Rebuild the gateway image without plugins",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return gateway.RemovePlugins(cmd.Context(), args)
+		},
+	})
+
+	return cmd
+}
+
+func hostsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hosts",
+		Short: "Manage caddy-atc hostnames in /etc/hosts",
+		Long: `Keep a clearly delimited block of caddy-atc hostnames in /etc/hosts, and on
+WSL in the Windows hosts file, pointing at the gateway. Needed for domains
+other than .localhost when the built-in DNS server isn't used.`,
+	}
+
+	var dryRun, auto bool
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Write all active and adopted hostnames to the hosts files",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			addr, err := hosts.Address(cfg)
+			if err != nil {
+				return err
+			}
+			var active []string
+			if activeRoutes, err := routes.ListActive(cmd.Context()); err == nil {
+				for _, r := range activeRoutes {
+					active = append(active, r.Hostname)
+				}
+			}
+			block := hosts.Block(addr, hosts.Hostnames(cfg, active))
+
+			if err := syncHostsFiles(block, dryRun); err != nil {
+				return err
+			}
+			if dryRun {
+				if block == "" {
+					fmt.Println("No hostnames to write.")
+				} else {
+					fmt.Print("\n" + block)
+				}
+				return nil
+			}
+
+			if auto {
+				if err := config.LoadAndModify(func(c *config.Config) error {
+					c.Hosts = &config.HostsConfig{AutoSync: true}
+					return nil
+				}); err != nil {
+					return err
+				}
+				fmt.Println("The watcher now syncs the hosts files whenever routes change.")
+				fmt.Println("It can't prompt for sudo, so /etc/hosts must be writable or 'sudo -n tee /etc/hosts' allowed.")
+			}
+			return nil
+		},
+	}
+	syncCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the block and the files that would change without writing")
+	syncCmd.Flags().BoolVar(&auto, "auto", false, "Also keep the hosts files in sync from the watcher")
+	cmd.AddCommand(syncCmd)
+
+	var cleanDryRun bool
+	cleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove the caddy-atc block from the hosts files and stop auto sync",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := syncHostsFiles("", cleanDryRun); err != nil {
+				return err
+			}
+			if cleanDryRun {
+				return nil
+			}
+			return config.LoadAndModify(func(c *config.Config) error {
+				c.Hosts = nil
+				return nil
+			})
+		},
+	}
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "Show the files that would change without writing")
+	cmd.AddCommand(cleanCmd)
+
+	return cmd
+}
+
+// syncHostsFiles writes block to the hosts files and reports which changed.
+func syncHostsFiles(block string, dryRun bool) error {
+	changed, err := hosts.Sync(hosts.Files(), block, dryRun, true)
+	verb := "Updated"
+	if dryRun {
+		verb = "Would update"
+	}
+	for _, path := range changed {
+		fmt.Printf("%s %s\n", verb, path)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		fmt.Println("Hosts files are up to date.")
+	}
+	return nil
+}
+
+func envCmd() *cobra.Command {
+	var ca bool
+	var shell string
+
+	cmd := &cobra.Command{
+		Use:   "env --ca",
+		Short: "Print environment variables that make CLI tools trust the gateway",
+		Long: `Print export lines for the current shell. With --ca, curl, Python
+(requests and ssl), git and Node.js trust gateway hostnames without
+per-tool flags:
+
+  eval "$(caddy-atc env --ca)"
+
+SSL_CERT_FILE, CURL_CA_BUNDLE, REQUESTS_CA_BUNDLE and GIT_SSL_CAINFO replace
+the system roots, so they point at a copy of the system bundle extended with
+the gateway's root CA. NODE_EXTRA_CA_CERTS adds to Node's roots, so it points
+at the root CA alone.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !ca {
+				return cmd.Help()
+			}
+			if shell != "sh" && shell != "fish" {
+				return fmt.Errorf("unsupported shell %q (sh or fish)", shell)
+			}
+			files, err := gateway.WriteCAFiles(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if files.System == "" {
+				fmt.Fprintln(os.Stderr, "Warning: no system CA bundle found; the bundle only trusts the gateway")
+			}
+
+			var vars [][2]string
+			for _, name := range gateway.BundleEnv {
+				vars = append(vars, [2]string{name, files.Bundle})
+			}
+			vars = append(vars, [2]string{gateway.RootEnv, files.Root})
+			for _, v := range vars {
+				if shell == "fish" {
+					fmt.Printf("set -gx %s %s;\n", v[0], shellQuote(v[1]))
+				} else {
+					fmt.Printf("export %s=%s\n", v[0], shellQuote(v[1]))
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&ca, "ca", false, "Point CLI tools at the gateway's root CA")
+	cmd.Flags().StringVar(&shell, "shell", "sh", "Syntax of the output: sh (also bash, zsh) or fish")
+	return cmd
+}
+
+// shellQuote single-quotes s for sh and fish.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func dnsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Resolve hostnames on a custom domain with the built-in DNS server",
+		Long: `Manage the watcher's DNS server, which resolves every hostname under the
+configured domain (e.g. *.myapp.test) to the gateway, and the system
+resolver drop-in that forwards the domain to it. Not needed for .localhost.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "enable",
+		Short: "Start the DNS server with the watcher and point the system resolver at it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			domain, addr, err := dnsSettings(cfg)
+			if err != nil {
+				return err
+			}
+			if err := config.LoadAndModify(func(c *config.Config) error {
+				if c.DNS == nil {
+					c.DNS = &config.DNSConfig{}
+				}
+				c.DNS.Enabled = true
+				return nil
+			}); err != nil {
+				return err
+			}
+			fmt.Printf("DNS server enabled for *%s on %s.\n", domain, addr)
+
+			resolver, err := dns.SystemResolver(domain, addr)
+			if err != nil {
+				return err
+			}
+			if !resolver.Installed() {
+				fmt.Printf("Configuring %s (%s)...\n", resolver.Name, resolver.Path)
+				if err := resolver.Install(); err != nil {
+					return err
+				}
+			}
+
+			if isWatcherRunning() {
+				fmt.Println("Restart the watcher to start the DNS server: caddy-atc down && caddy-atc up")
+			} else {
+				fmt.Println("The DNS server starts with the watcher ('caddy-atc up').")
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "disable",
+		Short: "Stop serving DNS and remove the system resolver drop-in",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			domain, addr, err := dnsSettings(cfg)
+			if err != nil {
+				return err
+			}
+			if err := config.LoadAndModify(func(c *config.Config) error {
+				if c.DNS != nil {
+					c.DNS.Enabled = false
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			if resolver, err := dns.SystemResolver(domain, addr); err == nil {
+				if err := resolver.Remove(); err != nil {
+					return err
+				}
+			}
+			fmt.Println("DNS server disabled.")
+			if isWatcherRunning() {
+				fmt.Println("It stops when the watcher restarts: caddy-atc down && caddy-atc up")
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show the DNS server and resolver configuration and test resolution",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			domain, addr, err := dnsSettings(cfg)
+			if err != nil {
+				return err
+			}
+			enabled := cfg.DNS != nil && cfg.DNS.Enabled
+
+			fmt.Printf("Domain:    *%s\n", domain)
+			if enabled {
+				fmt.Printf("Server:    enabled on %s\n", addr)
+			} else {
+				fmt.Println("Server:    disabled")
+			}
+			if resolver, err := dns.SystemResolver(domain, addr); err != nil {
+				fmt.Printf("Resolver:  %v\n", err)
+			} else if resolver.Installed() {
+				fmt.Printf("Resolver:  %s (%s)\n", resolver.Name, resolver.Path)
+			} else {
+				fmt.Printf("Resolver:  %s, not configured\n", resolver.Name)
+			}
+			if !enabled {
+				return nil
+			}
+			if !isWatcherRunning() {
+				fmt.Println("The watcher is not running; the DNS server starts with 'caddy-atc up'.")
+				return nil
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), 3*time.Second)
+			defer cancel()
+			name := "caddy-atc" + domain
+			if ip, err := dns.Lookup(ctx, addr, name); err != nil {
+				fmt.Printf("Server lookup of %s: %v\n", name, err)
+			} else {
+				fmt.Printf("Server lookup of %s: %s\n", name, ip)
+			}
+			if addrs, err := net.DefaultResolver.LookupHost(ctx, name); err != nil {
+				fmt.Printf("System lookup of %s: %v\n", name, err)
+			} else {
+				fmt.Printf("System lookup of %s: %s\n", name, strings.Join(addrs, ", "))
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+// dnsSettings returns the domain the DNS server answers for and the
+// address it listens on once enabled.
+func dnsSettings(cfg *config.Config) (domain, addr string, err error) {
+	domain, err = cfg.DomainSuffix()
+	if err != nil {
+		return "", "", err
+	}
+	if domain == config.DefaultDomain {
+		return "", "", fmt.Errorf("%s hostnames resolve without a DNS server; set one first with 'caddy-atc config set domain'", domain)
+	}
+	enabled := config.Config{DNS: &config.DNSConfig{Enabled: true}}
+	if cfg.DNS != nil {
+		enabled.DNS.Listen = cfg.DNS.Listen
+	}
+	addr, err = enabled.DNSListen()
+	return domain, addr, err
+}
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show or change global settings in config.yml",
+		Long: `Show or change the machine-wide gateway settings in config.yml:
+
+  image               gateway Caddy image
+  http_port           HTTP port the gateway listens on and publishes
+  https_port          HTTPS port the gateway listens on and publishes
+  network             Docker network shared by the gateway and routed containers
+  log_level           gateway Caddy log level
+  domain              suffix of default hostnames, e.g. .test
+  reload_debounce     how long the watcher batches container changes before a reload
+  reconcile_interval  how often the watcher checks its routes against running containers
+  admin_address       Caddy admin API address inside the gateway container
+  reload_mode         admin to patch changed routes in, caddyfile to always reload`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:       "get [key]",
+		Short:     "Show one setting, or all of them with their defaults",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: config.SettingKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := config.LoadSettings()
+			if err != nil {
+				return err
+			}
+			if len(args) == 1 {
+				value, err := s.Get(args[0])
+				if err != nil {
+					return err
+				}
+				if value == "" {
+					value = config.DefaultSetting(args[0])
+				}
+				fmt.Println(value)
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tVALUE")
+			for _, key := range config.SettingKeys {
+				value, _ := s.Get(key)
+				if value == "" {
+					value = config.DefaultSetting(key) + " (default)"
+				}
+				fmt.Fprintf(w, "%s\t%s\n", key, value)
+			}
+			return w.Flush()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:       "set <key> <value>",
+		Short:     "Change a setting; an empty value restores its default",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: config.SettingKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, value := args[0], args[1]
+			if err := config.ModifySettings(func(s *config.Settings) error {
+				if err := s.Set(key, value); err != nil {
+					return err
+				}
+				return s.Validate()
+			}); err != nil {
+				return err
+			}
+			if value == "" {
+				fmt.Printf("%s restored to its default.\n", key)
+			} else {
+				fmt.Printf("%s set to %s.\n", key, value)
+			}
+			switch key {
+			case "image":
+				fmt.Println("Switch the gateway to it with 'caddy-atc gateway upgrade'.")
+			case "http_port", "https_port", "network", "admin_address":
+				fmt.Println("Applies once the gateway is recreated: 'caddy-atc down && caddy-atc up'.")
+			case "domain":
+				fmt.Println("Applies to hostnames of projects adopted from now on.")
+				if suffix, err := (&config.Config{Settings: config.Settings{Domain: value}}).DomainSuffix(); err == nil {
+					if warning := config.DomainWarning(suffix); warning != "" {
+						fmt.Printf("Note: %s.\n", warning)
+					}
+				}
+			default:
+				if isWatcherRunning() {
+					fmt.Println("The running watcher picks it up now.")
+				}
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+// passphraseEnv supplies the migrate passphrase non-interactively.
+const passphraseEnv = "CADDY_ATC_PASSPHRASE"
+
+func queryCmd() *cobra.Command {
+	var since time.Duration
+	var types []string
+	var host, project, format string
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Show the watcher's recorded route changes and reloads",
+		Long: `Show the route changes and Caddy reloads the watcher recorded, oldest
+first. Event types:
+
+  route_added    a container started being routed
+  route_removed  a container's route was removed
+  reload         Caddy reloaded, with how long it took
+  reload_failed  a reload failed, with Caddy's error
+
+Examples:
+  caddy-atc query --since 24h --type reload_failed
+  caddy-atc query --host app.localhost --limit 20
+  caddy-atc query --project myapp --format json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q (want text or json)", format)
+			}
+			filter := history.Filter{Host: host, Project: project, Limit: limit}
+			if cfg, err := config.Load(); err == nil && cfg.Projects[project] != nil {
+				filter.Project = cfg.Projects[project].ComposeProject
+			}
+			for _, typ := range types {
+				if err := history.ValidateType(typ); err != nil {
+					return err
+				}
+				filter.Types = append(filter.Types, typ)
+			}
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+
+			events, err := history.Query(filter)
+			if err != nil {
+				return err
+			}
+
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if events == nil {
+					events = []history.Event{}
+				}
+				return enc.Encode(events)
+			}
+			if len(events) == 0 {
+				fmt.Println("No matching events.")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tTYPE\tHOSTNAME\tUPSTREAM\tDETAIL")
+			for _, e := range events {
+				upstream, detail := "-", "-"
+				if e.Container != "" {
+					upstream = e.Container + ":" + e.Port
+				}
+				switch {
+				case e.Error != "":
+					detail = e.Error
+				case e.Type == history.Reload:
+					detail = e.Duration.Round(time.Millisecond).String()
+				}
+				hostname := e.Hostname
+				if hostname == "" {
+					hostname = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					e.Time.Local().Format(time.DateTime), e.Type, hostname, upstream, detail)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().DurationVar(&since, "since", 0, "Only show events from this long ago, e.g. 1h")
+	cmd.Flags().StringSliceVar(&types, "type", nil, "Only show events of these types: "+strings.Join(history.Types, ", "))
+	cmd.Flags().StringVar(&host, "host", "", "Only show events for this hostname")
+	cmd.Flags().StringVar(&project, "project", "", "Only show events for this adopted project")
+	cmd.Flags().IntVar(&limit, "limit", 100, "Show at most this many of the latest events (0 for all)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text or json")
+	return cmd
+}
+
+func migrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move caddy-atc state to another machine in an encrypted archive",
+		Long: `Bundle adopted projects, static routes, global settings, an imported team CA
+and the gateway's local CA into an encrypted archive, and restore it on
+another machine. The archive is encrypted with a passphrase, read from the
+terminal or from ` + passphraseEnv + `.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "export [file]",
+		Short: "Write this machine's state to an encrypted archive",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "caddy-atc-migrate.bin"
+			if len(args) > 0 {
+				path = args[0]
+			}
+			passphrase, err := readPassphrase(true)
+			if err != nil {
+				return err
+			}
+			b, warnings, err := migrate.Export(cmd.Context(), path, passphrase)
+			if err != nil {
+				return err
+			}
+			for _, w := range warnings {
+				fmt.Printf("Warning: %s\n", w)
+			}
+			fmt.Printf("Exported %d file(s)", len(b.Home))
+			if len(b.LocalCA) > 0 {
+				fmt.Print(" and the gateway's local CA")
+			}
+			fmt.Printf(" to %s.\n", path)
+			if b.HasKeys() {
+				fmt.Println("It contains CA private keys: keep it safe and delete it after importing.")
+			}
+			return nil
+		},
+	})
+
+	var force bool
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Restore state from an archive written by 'migrate export'",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			passphrase, err := readPassphrase(false)
+			if err != nil {
+				return err
+			}
+			b, err := migrate.Read(args[0], passphrase)
+			if err != nil {
+				return err
+			}
+			if isWatcherRunning() {
+				return fmt.Errorf("stop caddy-atc with 'caddy-atc down' before importing")
+			}
+			if err := migrate.Restore(ctx, b, force); err != nil {
+				return err
+			}
+			fmt.Printf("Restored %d file(s) into %s.\n", len(b.Home), config.ConfigDir())
+			if len(b.LocalCA) > 0 {
+				fmt.Println("Restored the gateway's local CA. Run 'caddy-atc trust' to trust it on this machine.")
+			}
+
+			missing := migrate.MissingDirs(b)
+			if len(missing) > 0 {
+				names := make([]string, 0, len(missing))
+				for name := range missing {
+					names = append(names, name)
+				}
+				slices.Sort(names)
+				fmt.Println()
+				fmt.Println("These projects' directories don't exist here; re-adopt them from their new location:")
+				for _, name := range names {
+					fmt.Printf("  %-20s %s\n", name, missing[name])
+				}
+			}
+			fmt.Println()
+			fmt.Println("Run 'caddy-atc down && caddy-atc up' to apply the restored settings.")
+			return nil
+		},
+	}
+	importCmd.Flags().BoolVar(&force, "force", false, "Replace existing adopted projects")
+	cmd.AddCommand(importCmd)
+
+	return cmd
+}
+
+// readPassphrase returns the migrate passphrase from passphraseEnv, or
+// prompts for it with echo off, twice when confirm is set.
+func readPassphrase(confirm bool) (string, error) {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return p, nil
+	}
+	p, err := promptHidden("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	if confirm {
+		again, err := promptHidden("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return p, nil
+}
+
+// promptHidden reads a line from the terminal without echoing it.
+func promptHidden(prompt string) (string, error) {
+	stty := func(args ...string) error {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("no terminal to read the passphrase from; set %s", passphraseEnv)
+	}
+	defer stty("echo")
+
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Println()
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func lanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lan",
+		Short: "Serve this machine's LAN IP and mDNS name with trusted certificates",
+		Long: `Manage LAN mode, in which the gateway also serves this machine's LAN IP
+and mDNS name (<hostname>.local) with certificates from its local CA, so
+phones and other devices that can't resolve the adopted hostnames can
+connect over TLS once they trust the root CA.`,
+	}
+
+	var name string
+	enableCmd := &cobra.Command{
+		Use:   "enable",
+		Short: "Have the gateway serve the LAN IP and mDNS name",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name != "" {
+				if _, err := lan.MDNSName(name); err != nil {
+					return err
+				}
+			}
+			if err := config.LoadAndModify(func(c *config.Config) error {
+				if c.LAN == nil {
+					c.LAN = &config.LANConfig{}
+				}
+				c.LAN.Enabled = true
+				if name != "" {
+					c.LAN.Name = name
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			fmt.Println("LAN mode enabled.")
+			return printLANStatus(cmd.Context())
+		},
+	}
+	enableCmd.Flags().StringVar(&name, "name", "", "mDNS name to serve instead of <hostname>.local")
+	cmd.AddCommand(enableCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "disable",
+		Short: "Stop serving the LAN IP and mDNS name",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := config.LoadAndModify(func(c *config.Config) error {
+				if c.LAN != nil {
+					c.LAN.Enabled = false
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			fmt.Println("LAN mode disabled.")
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show the LAN addresses the gateway serves",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printLANStatus(cmd.Context())
+		},
+	})
+
+	return cmd
+}
+
+// printLANStatus shows the LAN addresses and what devices need to use them.
+func printLANStatus(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.LAN == nil || !cfg.LAN.Enabled {
+		fmt.Println("LAN mode is off; enable it with 'caddy-atc lan enable'.")
+		return nil
+	}
+	names, err := lan.Names(cfg.LAN.Name)
+	if err != nil {
+		return err
+	}
+	_, httpsPort := cfg.HTTPPorts()
+	fmt.Println("Serving:")
+	for _, n := range names {
+		if cfg.LAN.Expose != "" {
+			fmt.Printf("  %s -> %s\n", config.SiteURL(n, httpsPort), cfg.LAN.Expose)
+		} else {
+			fmt.Printf("  %s\n", config.SiteURL(n, httpsPort))
+		}
+	}
+	if cfg.LAN.Expose == "" {
+		fmt.Println("Proxy them to a project with 'caddy-atc expose <hostname>'.")
+	}
+
+	listen, err := cfg.GatewayListen()
+	if err != nil {
+		return err
+	}
+	if ip, err := gateway.PublishedIP(ctx); err == nil {
+		listen = ip
+	}
+	if gateway.IsLoopback(listen) {
+		fmt.Printf("The gateway only listens on %s, so other devices can't connect; run 'caddy-atc up --listen 0.0.0.0'.\n", listen)
+	}
+	if !isWatcherRunning() {
+		fmt.Println("The watcher is not running; start it with 'caddy-atc up'.")
+	}
+	fmt.Printf("Install the root CA (%s, saved by 'caddy-atc trust') on each device to trust these certificates.\n", config.RootCAPath())
+	return nil
+}
+
+func exposeCmd() *cobra.Command {
+	var off bool
+
+	cmd := &cobra.Command{
+		Use:   "expose [hostname]",
+		Short: "Proxy the LAN IP and mDNS name to a routed hostname, and print the URLs",
+		Long: `Make a routed hostname reachable from phones and other devices on the
+network: the gateway proxies https://<LAN IP> and https://<hostname>.local
+to it. This turns on LAN mode; the gateway must listen on a non-loopback
+address ('caddy-atc up --listen 0.0.0.0'). Without arguments, prints the
+URLs currently exposed.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if off {
+				if err := config.LoadAndModify(func(c *config.Config) error {
+					if c.LAN != nil {
+						c.LAN.Expose = ""
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				fmt.Println("Nothing is exposed on the LAN addresses any more.")
+				return nil
+			}
+			if len(args) == 0 {
+				return printLANStatus(cmd.Context())
+			}
+
+			hostname := strings.ToLower(args[0])
+			if err := config.ValidateHostname(hostname); err != nil {
+				return err
+			}
+			if strings.HasPrefix(hostname, "*.") {
+				return fmt.Errorf("expose a single hostname, not a wildcard")
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			listen, err := cfg.GatewayListen()
+			if err != nil {
+				return err
+			}
+			if gateway.IsLoopback(listen) {
+				return fmt.Errorf("the gateway only listens on %s - run 'caddy-atc up --listen 0.0.0.0' first", listen)
+			}
+
+			if err := config.LoadAndModify(func(c *config.Config) error {
+				if c.LAN == nil {
+					c.LAN = &config.LANConfig{}
+				}
+				c.LAN.Enabled = true
+				c.LAN.Expose = hostname
+				return nil
+			}); err != nil {
+				return err
+			}
+			return printLANStatus(cmd.Context())
+		},
+	}
+
+	cmd.Flags().BoolVar(&off, "off", false, "Stop proxying the LAN addresses to a hostname")
+	return cmd
+}
+
+func shareCmd() *cobra.Command {
+	var provider string
+
+	cmd := &cobra.Command{
+		Use:   "share <hostname>",
+		Short: "Share a route publicly through a tunnel until Ctrl+C",
+		Long: `Start a tunnel to the container behind a routed hostname and print its
+public URL. The tunnel client runs in a container on the caddy-atc
+network, so nothing needs to be installed and the project's ports can stay
+stripped. It is removed on Ctrl+C.
+
+Providers:
+  cloudflared  Cloudflare quick tunnel, no account needed (default)
+  ngrok        needs NGROK_AUTHTOKEN
+  localtunnel  loca.lt`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, ok := gateway.TunnelProviders[provider]
+			if !ok {
+				return fmt.Errorf("unknown provider %q (available: %s)", provider, strings.Join(gateway.TunnelProviderNames(), ", "))
+			}
+			hostname := strings.ToLower(args[0])
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			active, err := routes.ListActive(ctx)
+			if err != nil {
+				return err
+			}
+			var route *routes.ActiveRoute
+			for i, r := range active {
+				if r.Hostname == hostname && r.Quarantine == "" {
+					route = &active[i]
+					break
+				}
+			}
+			if route == nil {
+				return fmt.Errorf("no active route for %s - see 'caddy-atc routes'", hostname)
+			}
+
+			upstream := route.ContainerName + ":" + route.Port
+			fmt.Printf("Starting %s tunnel to %s (%s)...\n", p.Name, hostname, upstream)
+			return gateway.Share(ctx, p, hostname, upstream, func(url string) {
+				fmt.Printf("\n  %s -> %s\n\nPress Ctrl+C to stop sharing.\n", url, hostname)
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&provider, "provider", gateway.DefaultTunnelProvider, "Tunnel provider: "+strings.Join(gateway.TunnelProviderNames(), ", "))
+	return cmd
+}
+
+func pauseCmd() *cobra.Command {
+	var maintenance bool
+
+	cmd := &cobra.Command{
+		Use:   "pause",
+		Short: "Suspend Caddy reloads while containers churn",
+		Long: `Suspend Caddyfile writes and Caddy reloads. The watcher keeps tracking
+container start/stop events and applies a single consolidated update
+when routing is resumed.
+
+With --maintenance, the gateway answers every routed hostname with a
+503 maintenance page until then, e.g. during load tests or compose
+surgery.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reason := "paused by user"
+			if maintenance {
+				reason = "maintenance"
+			}
+			// A running watcher applies the pause at once; otherwise the
+			// marker is picked up when it starts.
+			err := watcher.PauseWatcher(cmd.Context(), reason, maintenance)
+			if errors.Is(err, watcher.ErrNotRunning) {
+				if maintenance {
+					err = watcher.PauseForMaintenance(reason)
+				} else {
+					err = watcher.Pause(reason, 0)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if maintenance {
+				fmt.Println("Routing paused; routed hostnames answer with a 503 maintenance page.")
+				fmt.Println("Run 'caddy-atc resume' to serve them again and apply pending changes.")
+				return nil
+			}
+			fmt.Println("Routing paused. Run 'caddy-atc resume' to apply pending changes.")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&maintenance, "maintenance", false, "Serve a 503 maintenance page on routed hostnames while paused")
+
+	return cmd
+}
+
+func resumeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "Resume Caddy reloads and apply pending route changes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if watcher.CurrentPause() == nil {
+				fmt.Println("Routing is not paused.")
+				return nil
+			}
+			err := watcher.ResumeWatcher(cmd.Context())
+			if errors.Is(err, watcher.ErrNotRunning) {
+				err = watcher.Resume()
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println("Routing resumed.")
+			return nil
+		},
+	}
 }
 
-func unadoptCmd() *cobra.Command {
+func reloadCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "unadopt [directory]",
-		Short: "Remove a project from automatic routing",
-		Args:  cobra.MaximumNArgs(1),
+		Use:   "reload",
+		Short: "Regenerate the Caddyfile and reload Caddy now",
+		Long: `Have the running watcher regenerate the Caddyfile from its routes and
+reload Caddy, e.g. after editing files the gateway reads.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dir := "."
-			if len(args) > 0 {
-				dir = args[0]
+			err := watcher.ReloadWatcher(cmd.Context())
+			if errors.Is(err, watcher.ErrNotRunning) {
+				return fmt.Errorf("%w (start it with 'caddy-atc up')", err)
 			}
-
-			if err := adopt.Unadopt(dir); err != nil {
+			if err != nil {
 				return err
 			}
-
-			fmt.Println("Project removed from caddy-atc.")
+			fmt.Println("Caddy reloaded.")
 			return nil
 		},
 	}
 }
 
-func statusCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "status",
-		Short: "Show gateway health and active routes",
+func caddyfileCmd() *cobra.Command {
+	var validate, builtin bool
+
+	cmd := &cobra.Command{
+		Use:   "caddyfile",
+		Short: "Print the Caddyfile generated from the current routes",
+		Long: `Print the Caddyfile the running watcher generates from its current routes,
+including your Caddyfile template if you have one, without writing it or
+reloading Caddy. Notes go to stderr, so the output can be redirected.
+
+With --validate, the gateway's 'caddy validate' checks it as well.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
-
-			// Check gateway
-			running, err := gateway.IsRunning(ctx)
+			generated, err := watcher.WatcherCaddyfile(ctx)
+			if errors.Is(err, watcher.ErrNotRunning) {
+				return fmt.Errorf("%w (start it with 'caddy-atc up'; the last Caddyfile written is %s)", err, config.CaddyfilePath())
+			}
 			if err != nil {
 				return err
 			}
 
-			if running {
-				fmt.Println("Gateway: running")
-			} else {
-				fmt.Println("Gateway: stopped")
-				return nil
-			}
-
-			// Check watcher
-			if isWatcherRunning() {
-				fmt.Println("Watcher: running")
-			} else {
-				fmt.Println("Watcher: stopped")
+			content := generated.Content
+			if builtin && generated.Builtin != "" {
+				content = generated.Builtin
+			} else if generated.Builtin != "" {
+				fmt.Fprintf(os.Stderr, "# Rendered with %s (--builtin prints the built-in Caddyfile)\n", config.CaddyfileTemplatePath())
 			}
+			fmt.Print(content)
 
-			fmt.Println()
-
-			// List routes
-			activeRoutes, err := routes.ListActive(ctx)
-			if err != nil {
-				return fmt.Errorf("listing routes: %w", err)
+			if data, err := os.ReadFile(config.CaddyfilePath()); err == nil {
+				stamp, written, stamped := watcher.ParseCaddyfileStamp(string(data))
+				generated, manual := watcher.SplitManualSections(written)
+				if generated != content {
+					fmt.Fprintf(os.Stderr, "# Differs from %s, which the gateway last loaded\n", config.CaddyfilePath())
+				}
+				if manual != "" {
+					fmt.Fprintf(os.Stderr, "# Followed by the manual sections kept in %s\n", config.CaddyfilePath())
+				}
+				if stamped && !stamp.Matches(written) {
+					fmt.Fprintf(os.Stderr, "# %s was edited by hand after caddy-atc %s wrote it at %s; the watcher backs it up before regenerating it\n", config.CaddyfilePath(), stamp.Version, stamp.Generated.Local().Format(time.DateTime))
+				}
 			}
-
-			if len(activeRoutes) == 0 {
-				fmt.Println("No active routes.")
+			if !validate {
 				return nil
 			}
-
-			fmt.Printf("Active routes (%d):\n", len(activeRoutes))
-			printRouteTable(activeRoutes)
-
+			if err := gateway.ValidateCaddyfile(ctx, content); err != nil {
+				return fmt.Errorf("caddy validate: %w", err)
+			}
+			fmt.Fprintln(os.Stderr, "# Valid")
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&validate, "validate", false, "Also check it with 'caddy validate' in the gateway container")
+	cmd.Flags().BoolVar(&builtin, "builtin", false, "Print the built-in Caddyfile even if a template overrides it")
+
+	return cmd
 }
 
-func routesCmd() *cobra.Command {
+func pinCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "routes",
-		Short: "List all active routes",
+		Use:   "pin <hostname> <container>",
+		Short: "Send all traffic for a hostname to one replica",
+		Long: `Direct every request for a hostname to a single container, bypassing
+load balancing across its replicas, for reproducing replica-specific bugs.
+Run 'caddy-atc unpin' to restore load balancing.`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx := cmd.Context()
+			hostname, container := args[0], args[1]
 
-			activeRoutes, err := routes.ListActive(ctx)
+			activeRoutes, err := routes.ListActive(cmd.Context())
 			if err != nil {
 				return err
 			}
-
-			if len(activeRoutes) == 0 {
-				fmt.Println("No active routes.")
-				return nil
+			var replicas []string
+			for _, r := range activeRoutes {
+				if r.Hostname == hostname {
+					replicas = append(replicas, r.ContainerName)
+				}
+			}
+			if len(replicas) == 0 {
+				return fmt.Errorf("no active route for %s", hostname)
+			}
+			if !slices.Contains(replicas, container) {
+				return fmt.Errorf("%s does not serve %s (replicas: %s)", container, hostname, strings.Join(replicas, ", "))
 			}
 
-			printRouteTable(activeRoutes)
+			if err := watcher.Pin(hostname, container); err != nil {
+				return err
+			}
+			fmt.Printf("Pinned %s to %s. Run 'caddy-atc unpin %s' to restore load balancing.\n", hostname, container, hostname)
 			return nil
 		},
 	}
 }
 
-func trustCmd() *cobra.Command {
+func unpinCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "trust",
-		Short: "Install Caddy's root CA in system trust store",
+		Use:   "unpin [hostname]",
+		Short: "Restore load balancing for a pinned hostname (all if omitted)",
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return gateway.Trust(cmd.Context())
+			hostname := ""
+			if len(args) > 0 {
+				hostname = args[0]
+			}
+			unpinned, err := watcher.Unpin(hostname)
+			if err != nil {
+				return err
+			}
+			switch {
+			case !unpinned && hostname != "":
+				fmt.Printf("%s is not pinned.\n", hostname)
+			case !unpinned:
+				fmt.Println("No hostnames are pinned.")
+			case hostname != "":
+				fmt.Printf("Unpinned %s.\n", hostname)
+			default:
+				fmt.Println("Unpinned all hostnames.")
+			}
+			return nil
 		},
 	}
 }
 
-func logsCmd() *cobra.Command {
-	var follow bool
-
+func routeCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "logs",
-		Short: "Show watcher logs",
+		Use:   "route",
+		Short: "Manage static routes to upstreams outside Docker",
+		Long: `Register routes from a hostname to a process that doesn't run in a
+container managed by caddy-atc, such as a Vite dev server on this machine,
+or to a directory of files the gateway serves itself. Static routes are
+stored in projects.yml and listed by 'caddy-atc routes'.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <hostname> <host:port>",
+		Short: "Proxy a hostname to an upstream",
+		Long: `Proxy hostname to upstream, replacing any static route it already has.
+
+Upstreams on localhost, or given as a bare port, are rewritten to
+host.docker.internal so the gateway container can reach them:
+
+  caddy-atc route add vite.localhost 5173
+  caddy-atc route add api.localhost localhost:8080`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			logPath := config.LogPath()
-			if _, err := os.Stat(logPath); os.IsNotExist(err) {
-				fmt.Println("No watcher logs found.")
-				return nil
+			route, replaced, err := routes.AddStatic(args[0], args[1])
+			if err != nil {
+				return err
 			}
-
-			if follow {
-				return gateway.Logs(cmd.Context(), true)
+			if replaced {
+				fmt.Printf("Updated %s -> %s\n", route.Hostname, route.Upstream)
+			} else {
+				fmt.Printf("Added %s -> %s\n", route.Hostname, route.Upstream)
 			}
-
-			data, err := os.ReadFile(logPath)
+			syncWatcher(cmd.Context())
+			return nil
+		},
+	})
+
+	var spa bool
+	addStatic := &cobra.Command{
+		Use:   "add-static <hostname> <dir>",
+		Short: "Serve a host directory at a hostname",
+		Long: `Serve the files in dir at hostname from the gateway, replacing any static
+route it already has. The directory is mounted read-only into the gateway
+container, which is recreated if it is running without it; certificates
+and the local CA are kept.
+
+  caddy-atc route add-static docs.myapp.localhost ./storybook-static
+  caddy-atc route add-static app.localhost ./dist --spa`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			route, replaced, err := routes.AddFileServer(args[0], args[1], spa)
 			if err != nil {
 				return err
 			}
-			fmt.Print(string(data))
+			if replaced {
+				fmt.Printf("Updated %s -> %s\n", route.Hostname, route.Root)
+			} else {
+				fmt.Printf("Added %s -> %s\n", route.Hostname, route.Root)
+			}
+			if err := gateway.SyncMounts(cmd.Context()); err != nil {
+				return err
+			}
+			syncWatcher(cmd.Context())
 			return nil
 		},
 	}
+	addStatic.Flags().BoolVar(&spa, "spa", false, "Serve /index.html for paths with no file (single-page apps)")
+	cmd.AddCommand(addStatic)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:     "remove <hostname>",
+		Aliases: []string{"rm"},
+		Short:   "Remove a hostname's static route",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := routes.RemoveStatic(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Removed %s\n", strings.ToLower(args[0]))
+			syncWatcher(cmd.Context())
+			return nil
+		},
+	})
 
-	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output")
 	return cmd
 }
 
-func startCmd() *cobra.Command {
-	var keepPorts string
-	var composeFile string
-	var regenerate bool
-
-	cmd := &cobra.Command{
-		Use:   "start [directory] [-- command...]",
-		Short: "Start project with ports stripped (avoids conflicts)",
-		Long: `Strip host port bindings from the project's compose file and run a command.
+// syncWatcher has a running watcher apply changes to projects.yml at once.
+func syncWatcher(ctx context.Context) {
+	switch err := watcher.SyncWatcher(ctx); {
+	case errors.Is(err, watcher.ErrNotRunning):
+		fmt.Println("The watcher is not running; this takes effect when it starts.")
+	case err != nil:
+		fmt.Printf("Warning: the watcher will apply this shortly: %v\n", err)
+	}
+}
 
-The stripped compose file is set via COMPOSE_FILE env var, so any docker compose
-calls inside your script transparently use it. The caddy-atc watcher handles
-routing automatically.
+func importCaddyfileCmd() *cobra.Command {
+	var dryRun bool
 
-Examples:
-  caddy-atc start                          # docker compose up -d (default)
-  caddy-atc start -- ./scripts/dev.sh      # custom command
-  caddy-atc start --keep-ports db,redis    # keep host ports for db and redis
-  caddy-atc start -f docker-compose.demo.yaml  # use custom compose file`,
+	cmd := &cobra.Command{
+		Use:   "import-caddyfile <path>",
+		Short: "Import reverse proxy site blocks from an existing Caddyfile",
+		Long: `Parse simple "host { reverse_proxy upstream }" site blocks from a
+hand-maintained Caddyfile and register them as static routes.
+
+Upstreams on localhost are rewritten to host.docker.internal so the gateway
+container can reach them. Blocks using matchers, nested handlers, or HTTPS
+upstreams are listed as skipped.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx := cmd.Context()
-
-			dir := "."
-			var userCmd []string
+			result, err := routes.ImportCaddyfile(args[0], dryRun)
+			if err != nil {
+				return err
+			}
 
-			dashIdx := cmd.ArgsLenAtDash()
-			if dashIdx >= 0 {
-				if dashIdx > 0 {
-					dir = args[0]
+			if len(result.Routes) == 0 {
+				fmt.Println("No new routes to import.")
+			} else {
+				fmt.Println("Imported static routes:")
+				for _, r := range result.Routes {
+					fmt.Printf("  %-30s -> %s\n", r.Hostname, r.Upstream)
 				}
-				userCmd = args[dashIdx:]
-			} else if len(args) > 0 {
-				dir = args[0]
 			}
 
-			var keepPortsList []string
-			if keepPorts != "" {
-				keepPortsList = strings.Split(keepPorts, ",")
+			if len(result.Skipped) > 0 {
+				fmt.Println()
+				fmt.Println("Skipped:")
+				for _, s := range result.Skipped {
+					fmt.Printf("  %-30s (%s)\n", s.Address, s.Reason)
+				}
 			}
 
-			return start.Run(ctx, start.Options{
-				Dir:         dir,
-				KeepPorts:   keepPortsList,
-				Command:     userCmd,
-				ComposeFile: composeFile,
-				Regenerate:  regenerate,
-			})
+			fmt.Println()
+			if dryRun {
+				fmt.Println("(dry run - no changes saved)")
+			} else if len(result.Routes) > 0 {
+				fmt.Printf("Saved to %s\n", config.ProjectsPath())
+			}
+			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&keepPorts, "keep-ports", "", "Comma-separated service names to keep host port bindings (e.g. db,redis)")
-	cmd.Flags().StringVarP(&composeFile, "file", "f", "", "Path to docker-compose file (default: auto-detect or use saved config)")
-	cmd.Flags().BoolVar(&regenerate, "regenerate", false, "Force regeneration of stripped compose file (overwrites customizations)")
-
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview without saving")
 	return cmd
 }
 
-func stopProjectCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "stop [directory]",
-		Short: "Stop project containers",
-		Args:  cobra.MaximumNArgs(1),
+func serveCmd() *cobra.Command {
+	var hostname string
+	var upload bool
+
+	cmd := &cobra.Command{
+		Use:   "serve [directory]",
+		Short: "Serve a host directory through the gateway",
+		Long: `Serve a directory with directory listings at https://<host> through the
+gateway, for sharing build artifacts between projects. The route is removed
+when the command exits.
+
+Examples:
+  caddy-atc serve ./dist                        # https://files.localhost
+  caddy-atc serve ./dist --host builds.localhost
+  caddy-atc serve ./drop --upload               # also accept uploads`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := "."
 			if len(args) > 0 {
 				dir = args[0]
 			}
-			return start.Stop(cmd.Context(), dir)
-		},
-	}
-}
 
-func updateCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "update",
-		Short: "Update caddy-atc to the latest version",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if version == "dev" {
-				return fmt.Errorf("cannot update a development build (built from source without version tag)")
+			if hostname == "" {
+				domain := config.DefaultDomain
+				if cfg, err := config.Load(); err == nil {
+					domain, _ = cfg.DomainSuffix()
+				}
+				hostname = "files" + domain
 			}
 
-			fmt.Printf("Current version: %s\n", version)
-			fmt.Println("Checking for updates...")
-
-			result := update.CheckSync(version)
-			if result == nil {
-				return fmt.Errorf("could not check for updates (network error?)")
+			if !isWatcherRunning() {
+				fmt.Println("Warning: watcher is not running - start it with 'caddy-atc up -d' to activate the route.")
 			}
 
-			if !result.UpdateAvail {
-				fmt.Printf("Already up to date (%s)\n", version)
-				return nil
-			}
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
 
-			fmt.Printf("New version available: %s\n\n", result.LatestVersion)
-			return update.SelfUpdate(result.LatestVersion)
+			return serve.Run(ctx, serve.Options{
+				Dir:      dir,
+				Hostname: hostname,
+				Upload:   upload,
+			})
 		},
 	}
+
+	cmd.Flags().StringVar(&hostname, "host", "", "Hostname to serve the directory at (default: files.<domain>, e.g. files.localhost)")
+	cmd.Flags().BoolVar(&upload, "upload", false, "Allow uploading files into the directory")
+	return cmd
+}
+
+// portsNote describes the gateway's ports when they aren't 80 and 443.
+func portsNote() string {
+	cfg, err := config.Load()
+	if err != nil {
+		return ""
+	}
+	http, https := cfg.HTTPPorts()
+	if http == config.DefaultHTTPPort && https == config.DefaultHTTPSPort {
+		return ""
+	}
+	return fmt.Sprintf(" (HTTP port %d, HTTPS port %d)", http, https)
+}
+
+// displayHost returns hostname as printed in command output: the bare name
+// on the standard HTTPS port, or its URL with the port otherwise.
+func displayHost(hostname string, httpsPort int) string {
+	if httpsPort == config.DefaultHTTPSPort {
+		return hostname
+	}
+	return config.SiteURL(hostname, httpsPort)
+}
+
+// routeAge returns how long ago since was in its largest whole unit, or
+// "-" if it is unknown.
+func routeAge(since, now time.Time) string {
+	if since.IsZero() {
+		return "-"
+	}
+	d := now.Sub(since)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", max(int(d/time.Second), 0))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
 }
 
 func printRouteTable(activeRoutes []routes.ActiveRoute) {
+	httpsPort := config.DefaultHTTPSPort
+	if cfg, err := config.Load(); err == nil {
+		_, httpsPort = cfg.HTTPPorts()
+	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "HOSTNAME\tCONTAINER\tPORT\tPROJECT\tSERVICE\tSTATUS")
+	fmt.Fprintln(w, "HOSTNAME\tCONTAINER\tPORT\tPROJECT\tSERVICE\tSTATUS\tHEALTH\tSOURCE\tAGE")
+	now := time.Now()
 	for _, r := range activeRoutes {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			r.Hostname, r.ContainerName, r.Port, r.Project, r.Service, r.Status)
+		health := r.Health
+		if health == "" {
+			health = "-"
+		}
+		source := r.Source
+		if source == "" {
+			source = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			displayHost(r.Hostname, httpsPort), r.ContainerName, r.Port, r.Project, r.Service, r.Status, health,
+			source, routeAge(r.Since, now))
 	}
 	w.Flush()
+
+	for _, r := range activeRoutes {
+		if r.Quarantine != "" {
+			fmt.Printf("\n%s (%s) is quarantined and not served:\n  %s\n", r.Hostname, r.ContainerName, r.Quarantine)
+		}
+	}
 }
 
-func runDetached() error {
+func runDetached(logLevel, logFormat string) error {
 	if err := config.EnsureHomeDir(); err != nil {
 		return err
 	}
@@ -465,7 +3230,7 @@ func runDetached() error {
 		return fmt.Errorf("finding executable path: %w", err)
 	}
 
-	child := exec.Command(exe, "up", "--_daemon")
+	child := exec.Command(exe, "up", "--_daemon", "--log-level", logLevel, "--log-format", logFormat)
 	child.Stdout = logFile
 	child.Stderr = logFile
 	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
@@ -485,6 +3250,15 @@ func runDetached() error {
 	return nil
 }
 
+// checkForegroundWatcher refuses a foreground watcher while a detached one
+// owns the PID file; a second watcher would race it on every reload.
+func checkForegroundWatcher() error {
+	if isWatcherRunning() {
+		return fmt.Errorf("watcher is already running in the background (logs: %s)", config.LogPath())
+	}
+	return nil
+}
+
 func waitForPidFileAt(path string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
@@ -499,21 +3273,24 @@ func waitForPidFileAt(path string, timeout time.Duration) error {
 	return fmt.Errorf("timed out waiting for PID file after %s", timeout)
 }
 
-func runDaemon(ctx context.Context) error {
+func runDaemon(ctx context.Context, logLevel, logFormat string) error {
 	if err := config.EnsureHomeDir(); err != nil {
 		return err
 	}
 
 	// In daemon mode, stdout/stderr are the log file (set by parent)
-	logger := log.New(os.Stdout, "[caddy-atc] ", log.LstdFlags)
+	logger, err := watcher.NewLogger(os.Stdout, logLevel, logFormat)
+	if err != nil {
+		return err
+	}
 
 	// Write PID file to signal parent we're alive
 	if err := os.WriteFile(config.PidPath(), []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
-		logger.Printf("Warning: could not write PID file: %v", err)
+		logger.Warn("Could not write PID file", "err", err)
 	}
 	defer os.Remove(config.PidPath())
 
-	w, err := watcher.New(logger)
+	w, err := watcher.New(logger, watcher.Options{Version: version})
 	if err != nil {
 		return err
 	}
@@ -522,11 +3299,11 @@ func runDaemon(ctx context.Context) error {
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	logger.Println("Watcher started (daemon mode)")
+	logger.Info("Watcher started (daemon mode)")
 	return w.Run(ctx)
 }
 
-func runWatcher(ctx context.Context) error {
+func runWatcher(ctx context.Context, logLevel, logFormat string) error {
 	if err := config.EnsureHomeDir(); err != nil {
 		return err
 	}
@@ -538,16 +3315,19 @@ func runWatcher(ctx context.Context) error {
 	}
 	defer logFile.Close()
 
-	logger := log.New(io.MultiWriter(os.Stdout, logFile), "[caddy-atc] ", log.LstdFlags)
+	logger, err := watcher.NewLogger(io.MultiWriter(os.Stdout, logFile), logLevel, logFormat)
+	if err != nil {
+		return err
+	}
 
 	// Write PID file
 	if err := os.WriteFile(config.PidPath(), []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
-		logger.Printf("Warning: could not write PID file: %v", err)
+		logger.Warn("Could not write PID file", "err", err)
 	}
 	defer os.Remove(config.PidPath())
 
 	// Create watcher
-	w, err := watcher.New(logger)
+	w, err := watcher.New(logger, watcher.Options{Version: version})
 	if err != nil {
 		return err
 	}
@@ -560,6 +3340,27 @@ func runWatcher(ctx context.Context) error {
 	return w.Run(ctx)
 }
 
+// runObserver runs the watcher in observe mode. It logs to stdout only and
+// leaves the PID file, watcher.log, network, and gateway untouched, so it can
+// run alongside (or instead of) a real watcher.
+func runObserver(ctx context.Context, logLevel, logFormat string) error {
+	logger, err := watcher.NewLogger(os.Stdout, logLevel, logFormat)
+	if err != nil {
+		return err
+	}
+
+	w, err := watcher.New(logger, watcher.Options{Observe: true, Version: version})
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	return w.Run(ctx)
+}
+
 func stopWatcher() {
 	data, err := os.ReadFile(config.PidPath())
 	if err != nil {
@@ -600,6 +3401,16 @@ func stopWatcher() {
 	os.Remove(config.PidPath())
 }
 
+// lazyGateway reports whether the watcher starts the gateway on demand.
+func lazyGateway() bool {
+	cfg, err := config.Load()
+	if err != nil {
+		return false
+	}
+	lazy, _, _ := cfg.LazyGateway()
+	return lazy
+}
+
 func isWatcherRunning() bool {
 	data, err := os.ReadFile(config.PidPath())
 	if err != nil {
@@ -2,10 +2,14 @@ package main
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
 )
 
 func TestIsCaddyATCProcess_CurrentProcess(t *testing.T) {
@@ -87,6 +91,15 @@ func TestUpCmd_Flags(t *testing.T) {
 		t.Errorf("--detach default = %q, want %q", f.DefValue, "false")
 	}
 
+	// --observe should exist and default to off
+	o := cmd.Flags().Lookup("observe")
+	if o == nil {
+		t.Fatal("--observe flag not found")
+	}
+	if o.DefValue != "false" {
+		t.Errorf("--observe default = %q, want %q", o.DefValue, "false")
+	}
+
 	// --_daemon should exist but be hidden
 	d := cmd.Flags().Lookup("_daemon")
 	if d == nil {
@@ -96,3 +109,73 @@ func TestUpCmd_Flags(t *testing.T) {
 		t.Error("--_daemon should be hidden")
 	}
 }
+
+func TestCheckForegroundWatcher(t *testing.T) {
+	t.Setenv("CADDY_ATC_HOME", t.TempDir())
+	writePid := func(pid int) {
+		t.Helper()
+		if err := os.WriteFile(config.PidPath(), []byte(strconv.Itoa(pid)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := checkForegroundWatcher(); err != nil {
+		t.Errorf("without a PID file: %v", err)
+	}
+
+	// A stale PID file, left by a watcher that was killed, doesn't block up.
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+	writePid(dead.Process.Pid)
+	if err := checkForegroundWatcher(); err != nil {
+		t.Errorf("with a stale PID file: %v", err)
+	}
+
+	// A live detached watcher: any process named caddy-atc.
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+	data, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := filepath.Join(t.TempDir(), "caddy-atc")
+	if err := os.WriteFile(fake, data, 0700); err != nil {
+		t.Fatal(err)
+	}
+	live := exec.Command(fake, "60")
+	if err := live.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		live.Process.Kill()
+		live.Wait()
+	})
+	writePid(live.Process.Pid)
+	err = checkForegroundWatcher()
+	if err == nil || !strings.Contains(err.Error(), "already running in the background") {
+		t.Errorf("with a running watcher: error = %v", err)
+	}
+}
+
+func TestRouteAge(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		since time.Time
+		want  string
+	}{
+		{time.Time{}, "-"},
+		{now.Add(-42 * time.Second), "42s"},
+		{now.Add(-90 * time.Minute), "1h"},
+		{now.Add(-59 * time.Minute), "59m"},
+		{now.Add(-50 * time.Hour), "2d"},
+	}
+	for _, tt := range tests {
+		if got := routeAge(tt.since, now); got != tt.want {
+			t.Errorf("routeAge(%v) = %q, want %q", tt.since, got, tt.want)
+		}
+	}
+}
@@ -16,10 +16,28 @@ type Result struct {
 	Hostname        string
 	HTTPServices    []ComposeService
 	SkippedServices []ComposeService
+	Hostnames       map[string]string // service name -> hostname
+	ProjectFile     bool              // declarations came from .caddy-atc.yml
 }
 
 // Adopt scans a project directory and registers it in the config.
 func Adopt(dir string, hostname string, composeFile string, dryRun bool) (*Result, error) {
+	return adopt(dir, hostname, composeFile, dryRun, func(absDir string, pf *config.ProjectFile) ([]ComposeService, error) {
+		return ScanComposeFile(absDir, composeFile, pf)
+	})
+}
+
+// AdoptComposeFiles is Adopt for the effective configuration of a compose
+// file chain, as ScanComposeFiles sees it, rather than the base file alone.
+// composeFile is recorded as the project's compose file as with Adopt.
+func AdoptComposeFiles(dir, composeFile string, files, profiles []string) (*Result, error) {
+	return adopt(dir, "", composeFile, false, func(_ string, pf *config.ProjectFile) ([]ComposeService, error) {
+		return ScanComposeFiles(files, profiles, pf)
+	})
+}
+
+// adopt registers the project in dir with the services scan finds.
+func adopt(dir string, hostname string, composeFile string, dryRun bool, scan func(absDir string, pf *config.ProjectFile) ([]ComposeService, error)) (*Result, error) {
 	// Resolve absolute path
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
@@ -35,18 +53,45 @@ func Adopt(dir string, hostname string, composeFile string, dryRun bool) (*Resul
 	// Determine project name from directory
 	projectName := filepath.Base(absDir)
 
+	// A checked-in .caddy-atc.yml supplies team-wide defaults.
+	pf, err := config.LoadProjectFile(absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// A global hostname template names services without explicit hostnames.
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	template := cfg.HostnameTemplate
+	if template != "" {
+		if err := config.ValidateHostnameTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+
 	// Default hostname
-	if hostname == "" {
-		hostname = projectName + ".localhost"
+	if hostname == "" && pf != nil {
+		hostname = pf.Hostname
+	}
+	domain, err := cfg.DomainSuffix()
+	if err != nil {
+		return nil, err
+	}
+	if hostname == "" && template == "" {
+		hostname = projectName + domain
 	}
 
 	// Validate hostname
-	if err := config.ValidateHostname(hostname); err != nil {
-		return nil, fmt.Errorf("invalid hostname: %w", err)
+	if hostname != "" {
+		if err := config.ValidateHostname(hostname); err != nil {
+			return nil, fmt.Errorf("invalid hostname: %w", err)
+		}
 	}
 
 	// Scan compose file
-	services, err := ScanComposeFile(absDir, composeFile)
+	services, err := scan(absDir, pf)
 	if err != nil {
 		return nil, err
 	}
@@ -68,8 +113,20 @@ func Adopt(dir string, hostname string, composeFile string, dryRun bool) (*Resul
 		return nil, fmt.Errorf("no HTTP services detected in %s", absDir)
 	}
 
+	// Without an explicit base hostname, the primary service is named by
+	// the template too.
+	if hostname == "" {
+		primary := httpServices[FindPrimaryService(httpServices)].Name
+		if hostname, err = config.ExpandHostnameTemplate(template, composeProject, primary); err != nil {
+			return nil, fmt.Errorf("invalid hostname for service %q: %w", primary, err)
+		}
+	}
+
 	// Assign hostnames
-	svcHostnames := assignHostnames(httpServices, hostname)
+	svcHostnames, err := assignHostnames(httpServices, hostname, template, composeProject)
+	if err != nil {
+		return nil, err
+	}
 
 	// Validate all generated hostnames
 	for svc, h := range svcHostnames {
@@ -84,6 +141,8 @@ func Adopt(dir string, hostname string, composeFile string, dryRun bool) (*Resul
 		Hostname:        hostname,
 		HTTPServices:    httpServices,
 		SkippedServices: skippedServices,
+		Hostnames:       svcHostnames,
+		ProjectFile:     pf != nil,
 	}
 
 	if dryRun {
@@ -92,13 +151,21 @@ func Adopt(dir string, hostname string, composeFile string, dryRun bool) (*Resul
 
 	// Save to config with file locking to prevent TOCTOU races
 	err = config.LoadAndModify(func(cfg *config.Config) error {
-		cfg.Projects[projectName] = &config.ProjectConfig{
+		proj := &config.ProjectConfig{
 			Dir:            absDir,
 			ComposeProject: composeProject,
 			Hostname:       hostname,
 			Services:       svcHostnames,
 			ComposeFile:    composeFile,
 		}
+		// Re-adopting keeps hand-edited per-service options.
+		if existing, ok := cfg.Projects[projectName]; ok {
+			proj.Options = existing.Options
+			proj.Verify = existing.Verify
+			proj.InjectCA = existing.InjectCA
+		}
+		setDetectedSchemes(proj, httpServices)
+		cfg.Projects[projectName] = proj
 		return nil
 	})
 	if err != nil {
@@ -127,7 +194,72 @@ func Unadopt(dir string) error {
 	})
 }
 
-func assignHostnames(services []ComposeService, baseHostname string) map[string]string {
+// EnableVerify has the watcher verify the adopted project's routes through
+// the gateway whenever they are first created.
+func EnableVerify(projectName string) error {
+	return config.LoadAndModify(func(cfg *config.Config) error {
+		proj, ok := cfg.Projects[projectName]
+		if !ok {
+			return fmt.Errorf("project %q is not adopted", projectName)
+		}
+		proj.Verify = true
+		return nil
+	})
+}
+
+// EnableInjectCA has `caddy-atc start` inject the gateway's root CA into
+// the adopted project's services.
+func EnableInjectCA(projectName string) error {
+	return config.LoadAndModify(func(cfg *config.Config) error {
+		proj, ok := cfg.Projects[projectName]
+		if !ok {
+			return fmt.Errorf("project %q is not adopted", projectName)
+		}
+		proj.InjectCA = true
+		return nil
+	})
+}
+
+// EnableAutostart has `caddy-atc up` start the adopted project's compose
+// stack.
+func EnableAutostart(projectName string) error {
+	return config.LoadAndModify(func(cfg *config.Config) error {
+		_, err := cfg.SetProjectAutostart(projectName, true)
+		return err
+	})
+}
+
+// EnableRetryPage has the gateway show browsers a self-reloading page
+// while a container of the adopted project is unreachable.
+func EnableRetryPage(projectName string) error {
+	return config.LoadAndModify(func(cfg *config.Config) error {
+		_, err := cfg.SetProjectRetryPage(projectName, true)
+		return err
+	})
+}
+
+// setDetectedSchemes records upstream_scheme: https for services detected
+// as serving TLS, unless the option is already set.
+func setDetectedSchemes(proj *config.ProjectConfig, services []ComposeService) {
+	for _, svc := range services {
+		if svc.UpstreamScheme != "https" {
+			continue
+		}
+		if proj.Options == nil {
+			proj.Options = make(map[string]*config.ServiceOptions)
+		}
+		opts := proj.Options[svc.Name]
+		if opts == nil {
+			opts = &config.ServiceOptions{}
+			proj.Options[svc.Name] = opts
+		}
+		if opts.UpstreamScheme == "" {
+			opts.UpstreamScheme = "https"
+		}
+	}
+}
+
+func assignHostnames(services []ComposeService, baseHostname, template, project string) (map[string]string, error) {
 	hostnames := make(map[string]string)
 
 	// Find the "primary" service - one that maps to base hostname
@@ -140,14 +272,25 @@ func assignHostnames(services []ComposeService, baseHostname string) map[string]
 	}
 
 	for i, svc := range services {
-		if i == primaryIdx {
+		if svc.Hostname != "" {
+			hostnames[svc.Name] = svc.Hostname
+			continue
+		}
+		switch {
+		case i == primaryIdx:
 			hostnames[svc.Name] = baseHostname
-		} else {
+		case template != "":
+			h, err := config.ExpandHostnameTemplate(template, project, svc.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hostname for service %q: %w", svc.Name, err)
+			}
+			hostnames[svc.Name] = h
+		default:
 			hostnames[svc.Name] = svc.Name + "." + subBase
 		}
 	}
 
-	return hostnames
+	return hostnames, nil
 }
 
 // FindPrimaryService identifies which service should get the base hostname.
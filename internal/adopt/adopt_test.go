@@ -4,8 +4,15 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
 )
 
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
 func TestFindPrimaryService(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -92,7 +99,10 @@ func TestAssignHostnames(t *testing.T) {
 			{Name: "web", Image: "nginx", Port: "80", IsHTTP: true},
 			{Name: "api", Image: "node:18", Port: "3000", IsHTTP: true},
 		}
-		hostnames := assignHostnames(services, "myapp.localhost")
+		hostnames, err := assignHostnames(services, "myapp.localhost", "", "myapp")
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		// web (index 0) is primary because nginx image
 		// FindPrimaryService returns 0 for nginx
@@ -106,7 +116,10 @@ func TestAssignHostnames(t *testing.T) {
 			{Name: "web", Image: "nginx", Port: "80", IsHTTP: true},
 			{Name: "api", Image: "node:18", Port: "3000", IsHTTP: true},
 		}
-		hostnames := assignHostnames(services, "myapp.localhost")
+		hostnames, err := assignHostnames(services, "myapp.localhost", "", "myapp")
+		if err != nil {
+			t.Fatal(err)
+		}
 		if hostnames["api"] != "api.myapp.localhost" {
 			t.Errorf("api hostname = %q, want %q", hostnames["api"], "api.myapp.localhost")
 		}
@@ -118,7 +131,10 @@ func TestAssignHostnames(t *testing.T) {
 			{Name: "client", Image: "node:18", Port: "3000", IsHTTP: true},
 			{Name: "server", Image: "node:18", Port: "3001", IsHTTP: true},
 		}
-		hostnames := assignHostnames(services, "*.curate.localhost")
+		hostnames, err := assignHostnames(services, "*.curate.localhost", "", "myapp")
+		if err != nil {
+			t.Fatal(err)
+		}
 		if hostnames["caddy"] != "*.curate.localhost" {
 			t.Errorf("caddy hostname = %q, want %q", hostnames["caddy"], "*.curate.localhost")
 		}
@@ -130,11 +146,31 @@ func TestAssignHostnames(t *testing.T) {
 		}
 	})
 
+	t.Run("hostname label overrides", func(t *testing.T) {
+		services := []ComposeService{
+			{Name: "web", Image: "nginx", Port: "80", IsHTTP: true},
+			{Name: "api", Image: "node:18", Port: "3000", IsHTTP: true, Hostname: "backend.myapp.localhost"},
+		}
+		hostnames, err := assignHostnames(services, "myapp.localhost", "", "myapp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hostnames["api"] != "backend.myapp.localhost" {
+			t.Errorf("api hostname = %q, want %q", hostnames["api"], "backend.myapp.localhost")
+		}
+		if hostnames["web"] != "myapp.localhost" {
+			t.Errorf("web hostname = %q, want %q", hostnames["web"], "myapp.localhost")
+		}
+	})
+
 	t.Run("single service gets base", func(t *testing.T) {
 		services := []ComposeService{
 			{Name: "app", Image: "node:18", Port: "3000", IsHTTP: true},
 		}
-		hostnames := assignHostnames(services, "myapp.localhost")
+		hostnames, err := assignHostnames(services, "myapp.localhost", "", "myapp")
+		if err != nil {
+			t.Fatal(err)
+		}
 		if hostnames["app"] != "myapp.localhost" {
 			t.Errorf("app hostname = %q, want %q", hostnames["app"], "myapp.localhost")
 		}
@@ -176,8 +212,7 @@ func TestAdopt_DryRun(t *testing.T) {
 	}
 
 	// Dry run should NOT create a config file
-	configPath := filepath.Join(tmpDir, ".caddy-atc", "projects.yml")
-	if _, err := os.Stat(configPath); err == nil {
+	if _, err := os.Stat(config.ProjectsPath()); err == nil {
 		t.Error("dry run should not create config file")
 	}
 }
@@ -300,3 +335,254 @@ func TestAdopt_NoHTTPServices(t *testing.T) {
 		t.Error("expected error when no HTTP services detected")
 	}
 }
+
+func TestAdopt_ReadoptKeepsServiceOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	projectDir := filepath.Join(tmpDir, "billing")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	composeContent := "services:\n  web:\n    image: nginx\n"
+	if err := os.WriteFile(filepath.Join(projectDir, "docker-compose.yml"), []byte(composeContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Adopt(projectDir, "", "", false); err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	err := config.LoadAndModify(func(cfg *config.Config) error {
+		cfg.Projects["billing"].Options = map[string]*config.ServiceOptions{
+			"web": {TLSClientCert: "client.crt", TLSClientKey: "client.key"},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Adopt(projectDir, "", "", false); err != nil {
+		t.Fatalf("re-Adopt() error = %v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := cfg.Projects["billing"].Options["web"]; o == nil || o.TLSClientCert != "client.crt" {
+		t.Errorf("Options after re-adopt = %+v, want preserved", cfg.Projects["billing"].Options)
+	}
+}
+
+func TestEnableVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	projectDir := filepath.Join(tmpDir, "shop")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "docker-compose.yml"), []byte("services:\n  web:\n    image: nginx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EnableVerify("shop"); err == nil {
+		t.Error("expected error for a project that isn't adopted")
+	}
+	if _, err := Adopt(projectDir, "", "", false); err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	if err := EnableVerify("shop"); err != nil {
+		t.Fatalf("EnableVerify() error = %v", err)
+	}
+
+	// Re-adopting keeps verification on.
+	if _, err := Adopt(projectDir, "", "", false); err != nil {
+		t.Fatalf("re-Adopt() error = %v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Projects["shop"].Verify {
+		t.Error("Verify after re-adopt = false, want preserved")
+	}
+}
+
+func TestAdopt_DetectsHTTPSUpstream(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	projectDir := filepath.Join(tmpDir, "auth")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	composeContent := `services:
+  keycloak:
+    image: quay.io/keycloak/keycloak
+    ports:
+      - "8443:8443"
+  web:
+    image: nginx
+`
+	if err := os.WriteFile(filepath.Join(projectDir, "docker-compose.yml"), []byte(composeContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Adopt(projectDir, "", "", false); err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := cfg.Projects["auth"]
+	if got := proj.ServiceOptions("keycloak").UpstreamScheme; got != "https" {
+		t.Errorf("keycloak upstream_scheme = %q, want https", got)
+	}
+	if got := proj.ServiceOptions("web").UpstreamScheme; got != "" {
+		t.Errorf("web upstream_scheme = %q, want unset", got)
+	}
+}
+
+func TestAdopt_ProjectFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	projectDir := filepath.Join(tmpDir, "shop")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	composeContent := `services:
+  web:
+    image: nginx
+  api:
+    build: .
+  admin:
+    image: nginx
+`
+	projectFile := `hostname: store.localhost
+services:
+  api:
+    port: "4000"
+  admin:
+    ignore: true
+`
+	if err := os.WriteFile(filepath.Join(projectDir, "docker-compose.yml"), []byte(composeContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, config.ProjectFileName), []byte(projectFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Adopt(projectDir, "", "", false)
+	if err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	if !result.ProjectFile {
+		t.Error("Result.ProjectFile = false, want true")
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := cfg.Projects["shop"]
+	if proj.Hostname != "store.localhost" {
+		t.Errorf("hostname = %q, want store.localhost from %s", proj.Hostname, config.ProjectFileName)
+	}
+	if _, ok := proj.Services["api"]; !ok {
+		t.Errorf("api not routed; services = %v", proj.Services)
+	}
+	if _, ok := proj.Services["admin"]; ok {
+		t.Errorf("admin routed despite ignore; services = %v", proj.Services)
+	}
+}
+
+func TestAdopt_HostnameTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	cfg := &config.Config{HostnameTemplate: "{project}.{service}.dev.test"}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	projectDir := filepath.Join(tmpDir, "shop")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	composeContent := `services:
+  web:
+    image: nginx
+  api:
+    image: node:18
+    ports:
+      - "3000:3000"
+`
+	if err := os.WriteFile(filepath.Join(projectDir, "docker-compose.yml"), []byte(composeContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Adopt(projectDir, "", "", true)
+	if err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	if result.Hostname != "shop.web.dev.test" {
+		t.Errorf("base hostname = %q, want shop.web.dev.test", result.Hostname)
+	}
+	if got := result.Hostnames["api"]; got != "shop.api.dev.test" {
+		t.Errorf("api hostname = %q, want shop.api.dev.test", got)
+	}
+
+	// An explicit base hostname still names the primary service.
+	result, err = Adopt(projectDir, "store.localhost", "", true)
+	if err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	if result.Hostnames["web"] != "store.localhost" || result.Hostnames["api"] != "shop.api.dev.test" {
+		t.Errorf("hostnames = %v", result.Hostnames)
+	}
+
+	cfg.HostnameTemplate = "{project}.localhost"
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Adopt(projectDir, "", "", true); err == nil {
+		t.Error("expected error for a template without {service}")
+	}
+}
+
+func TestAdopt_Domain(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	cfg := &config.Config{Domain: "test"}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	projectDir := filepath.Join(tmpDir, "shop")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	composeContent := "services:\n  web:\n    image: nginx\n  api:\n    image: node:18\n    ports:\n      - \"3000:3000\"\n"
+	if err := os.WriteFile(filepath.Join(projectDir, "docker-compose.yml"), []byte(composeContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Adopt(projectDir, "", "", true)
+	if err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	if result.Hostname != "shop.test" || result.Hostnames["api"] != "api.shop.test" {
+		t.Errorf("hostnames = %s, %v, want shop.test", result.Hostname, result.Hostnames)
+	}
+
+	cfg.Domain = ".bad domain"
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Adopt(projectDir, "", "", true); err == nil {
+		t.Error("expected error for an invalid domain")
+	}
+}
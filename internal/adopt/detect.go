@@ -5,20 +5,31 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/g-brodiei/caddy-atc/internal/config"
 	"gopkg.in/yaml.v3"
 )
 
 // ComposeService represents a service from a docker-compose.yml file.
 type ComposeService struct {
-	Name   string
-	Image  string
-	Ports  []string
-	IsHTTP bool
-	Port   string // detected HTTP port
+	Name     string
+	Image    string
+	Ports    []string
+	IsHTTP   bool
+	Port     string // detected HTTP port
+	Hostname string // from config.HostnameLabel, if set
+	Ignored  bool   // excluded from routing by config.IgnoreLabel
+
+	// HealthPath is from config.HealthPathLabel, if set and valid.
+	HealthPath string
+
+	// UpstreamScheme is from config.UpstreamSchemeLabel, or "https" when
+	// the detected port is a conventional TLS port.
+	UpstreamScheme string
 }
 
 // Known HTTP server images.
@@ -56,6 +67,9 @@ var knownHTTPPorts = map[string]bool{
 	"8080": true, "8443": true,
 }
 
+// Ports containers conventionally serve HTTPS on.
+var tlsPorts = map[string]bool{"443": true, "8443": true}
+
 // Known non-HTTP ports.
 var knownNonHTTPPorts = map[string]bool{
 	"5432": true, "3306": true, "27017": true, "6379": true,
@@ -69,55 +83,167 @@ type composeFile struct {
 }
 
 type composeServiceDef struct {
-	Image  string   `yaml:"image"`
-	Build  any      `yaml:"build"`
-	Ports  []string `yaml:"ports"`
-	Expose []string `yaml:"expose"`
+	Image    string   `yaml:"image"`
+	Build    any      `yaml:"build"`
+	Ports    []string `yaml:"ports"`
+	Expose   []string `yaml:"expose"`
+	Labels   any      `yaml:"labels"` // map or list of "key=value"
+	Profiles []string `yaml:"profiles"`
 }
 
 // ScanComposeFile reads a docker-compose file and detects HTTP services.
 // If composeFileName is non-empty, it is resolved relative to dir instead of auto-detecting.
-func ScanComposeFile(dir string, composeFileName string) ([]ComposeService, error) {
-	var composePath string
-	if composeFileName != "" {
-		if filepath.IsAbs(composeFileName) {
-			composePath = composeFileName
-		} else {
-			composePath = filepath.Join(dir, composeFileName)
-		}
-		if _, err := os.Stat(composePath); err != nil {
-			return nil, fmt.Errorf("compose file not found: %s", composePath)
-		}
-	} else {
-		composePath = findComposeFile(dir)
-		if composePath == "" {
-			return nil, fmt.Errorf("no docker-compose.yml found in %s", dir)
-		}
+// Declarations in pf (the project's .caddy-atc.yml, may be nil) count as labels.
+func ScanComposeFile(dir string, composeFileName string, pf *config.ProjectFile) ([]ComposeService, error) {
+	composePath, err := ResolveComposeFile(dir, composeFileName)
+	if err != nil {
+		return nil, err
 	}
 
-	info, err := os.Stat(composePath)
+	cf, err := readComposeFile(composePath)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeServices(cf, filepath.Dir(composePath), pf), nil
+}
+
+// readComposeFile reads and parses the compose file at path.
+func readComposeFile(path string) (composeFile, error) {
+	var cf composeFile
+	info, err := os.Stat(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading %s: %w", composePath, err)
+		return cf, fmt.Errorf("reading %s: %w", path, err)
 	}
 	const maxComposeSize = 1 << 20 // 1 MB
 	if info.Size() > maxComposeSize {
-		return nil, fmt.Errorf("compose file too large (%d bytes, max %d)", info.Size(), maxComposeSize)
+		return cf, fmt.Errorf("compose file too large (%d bytes, max %d)", info.Size(), maxComposeSize)
 	}
 
-	data, err := os.ReadFile(composePath)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading %s: %w", composePath, err)
+		return cf, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return cf, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cf, nil
+}
+
+// ScanComposeFiles detects HTTP services in the effective configuration of
+// a compose file chain, as Docker Compose would run it: the services of
+// paths merged in order, less those whose profiles are all inactive.
+// Relative build contexts resolve against the first file's directory.
+func ScanComposeFiles(paths, profiles []string, pf *config.ProjectFile) ([]ComposeService, error) {
+	files := make([]composeFile, len(paths))
+	for i, path := range paths {
+		cf, err := readComposeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = cf
+	}
+	return analyzeServices(effectiveServices(files, profiles), filepath.Dir(paths[0]), pf), nil
+}
+
+// ScanComposeNodes is ScanComposeFiles for compose files the caller already
+// parsed, docs[i] being the document of paths[i].
+func ScanComposeNodes(paths []string, docs []*yaml.Node, profiles []string, pf *config.ProjectFile) ([]ComposeService, error) {
+	files := make([]composeFile, len(paths))
+	for i, doc := range docs {
+		if err := doc.Decode(&files[i]); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", paths[i], err)
+		}
+	}
+	return analyzeServices(effectiveServices(files, profiles), filepath.Dir(paths[0]), pf), nil
+}
+
+// effectiveServices merges the services of files in order and drops the
+// ones not enabled by profiles.
+func effectiveServices(files []composeFile, profiles []string) composeFile {
+	merged := composeFile{Services: make(map[string]composeServiceDef)}
+	for _, cf := range files {
+		for name, svc := range cf.Services {
+			if prev, ok := merged.Services[name]; ok {
+				svc = mergeServiceDef(prev, svc)
+			}
+			merged.Services[name] = svc
+		}
+	}
+	for name, svc := range merged.Services {
+		if !ProfileActive(svc.Profiles, profiles) {
+			delete(merged.Services, name)
+		}
+	}
+	return merged
+}
+
+// mergeServiceDef applies an override file's definition of a service to
+// base the way Docker Compose does: single values replace, ports and
+// expose are added to, and labels are merged key by key.
+func mergeServiceDef(base, override composeServiceDef) composeServiceDef {
+	if override.Image != "" {
+		base.Image = override.Image
+	}
+	if override.Build != nil {
+		base.Build = override.Build
+	}
+	if override.Profiles != nil {
+		base.Profiles = override.Profiles
+	}
+	base.Ports = appendMissing(base.Ports, override.Ports)
+	base.Expose = appendMissing(base.Expose, override.Expose)
+	if override.Labels != nil {
+		labels := make(map[string]any)
+		for k, v := range parseLabels(base.Labels) {
+			labels[k] = v
+		}
+		for k, v := range parseLabels(override.Labels) {
+			labels[k] = v
+		}
+		base.Labels = labels
+	}
+	return base
+}
+
+func appendMissing(list, more []string) []string {
+	for _, item := range more {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
 	}
+	return list
+}
+
+// ProfileActive reports whether a service with the given profiles runs when
+// active are enabled. Services without profiles always run; "*" enables
+// every profile.
+func ProfileActive(serviceProfiles, active []string) bool {
+	if len(serviceProfiles) == 0 {
+		return true
+	}
+	for _, a := range active {
+		if a == "*" || slices.Contains(serviceProfiles, a) {
+			return true
+		}
+	}
+	return false
+}
 
+// ScanComposeNode detects HTTP services like ScanComposeFile in a compose
+// file the caller already parsed, so it isn't read and parsed twice.
+func ScanComposeNode(composePath string, doc *yaml.Node, pf *config.ProjectFile) ([]ComposeService, error) {
 	var cf composeFile
-	if err := yaml.Unmarshal(data, &cf); err != nil {
+	if err := doc.Decode(&cf); err != nil {
 		return nil, fmt.Errorf("parsing %s: %w", composePath, err)
 	}
+	return analyzeServices(cf, filepath.Dir(composePath), pf), nil
+}
 
-	composeDir := filepath.Dir(composePath)
+// analyzeServices classifies the services of cf, sorted by name.
+func analyzeServices(cf composeFile, composeDir string, pf *config.ProjectFile) []ComposeService {
 	var services []ComposeService
 	for name, svc := range cf.Services {
-		cs := analyzeService(name, svc, composeDir)
+		cs := analyzeService(name, withProjectFile(svc, name, pf), composeDir)
 		services = append(services, cs)
 	}
 
@@ -125,7 +251,27 @@ func ScanComposeFile(dir string, composeFileName string) ([]ComposeService, erro
 		return services[i].Name < services[j].Name
 	})
 
-	return services, nil
+	return services
+}
+
+// ResolveComposeFile returns the path of the compose file to scan: a
+// non-empty composeFileName resolved relative to dir, or else the first
+// standard compose file name found in dir.
+func ResolveComposeFile(dir string, composeFileName string) (string, error) {
+	if composeFileName == "" {
+		if composePath := findComposeFile(dir); composePath != "" {
+			return composePath, nil
+		}
+		return "", fmt.Errorf("no docker-compose.yml found in %s", dir)
+	}
+	composePath := composeFileName
+	if !filepath.IsAbs(composePath) {
+		composePath = filepath.Join(dir, composeFileName)
+	}
+	if _, err := os.Stat(composePath); err != nil {
+		return "", fmt.Errorf("compose file not found: %s", composePath)
+	}
+	return composePath, nil
 }
 
 func findComposeFile(dir string) string {
@@ -145,6 +291,15 @@ func findComposeFile(dir string) string {
 }
 
 func analyzeService(name string, svc composeServiceDef, composeDir string) ComposeService {
+	cs := classifyService(name, svc, composeDir)
+	if cs.IsHTTP && cs.UpstreamScheme == "" && tlsPorts[cs.Port] {
+		cs.UpstreamScheme = "https"
+	}
+	return cs
+}
+
+// classifyService decides whether a service speaks HTTP and on which port.
+func classifyService(name string, svc composeServiceDef, composeDir string) ComposeService {
 	cs := ComposeService{Name: name, Image: svc.Image}
 
 	// Collect all ports (from ports and expose directives)
@@ -177,6 +332,22 @@ func analyzeService(name string, svc composeServiceDef, composeDir string) Compo
 		}
 	}
 
+	labels := parseLabels(svc.Labels)
+	cs.Hostname = labels[config.HostnameLabel]
+	cs.UpstreamScheme, _ = config.ContainerUpstreamScheme(labels)
+	cs.HealthPath, _ = config.ContainerHealthPath(labels)
+	if config.IsIgnored(labels) {
+		cs.Ignored = true
+		return cs
+	}
+
+	// An explicit port label overrides every heuristic below.
+	if port := labels[config.PortLabel]; port != "" && config.ValidatePort(port) == nil {
+		cs.IsHTTP = true
+		cs.Port = port
+		return cs
+	}
+
 	// Check by image name first
 	imageName := extractImageBase(svc.Image)
 	if nonHTTPImages[imageName] {
@@ -227,6 +398,45 @@ func analyzeService(name string, svc composeServiceDef, composeDir string) Compo
 	return cs
 }
 
+// withProjectFile folds the project file's declarations for a service into
+// its labels, where the service doesn't set them itself.
+func withProjectFile(svc composeServiceDef, name string, pf *config.ProjectFile) composeServiceDef {
+	if pf == nil {
+		return svc
+	}
+	merged := pf.Labels(name, parseLabels(svc.Labels))
+	labels := make(map[string]any, len(merged))
+	for k, v := range merged {
+		labels[k] = v
+	}
+	svc.Labels = labels
+	return svc
+}
+
+// parseLabels normalizes compose `labels:`, which may be a mapping or a list
+// of "key=value" strings.
+func parseLabels(raw any) map[string]string {
+	labels := make(map[string]string)
+	switch v := raw.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if val != nil {
+				labels[k] = fmt.Sprint(val)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				continue
+			}
+			k, val, _ := strings.Cut(s, "=")
+			labels[k] = val
+		}
+	}
+	return labels
+}
+
 // extractContainerPort gets the container port from a port mapping like "8080:80" or "80".
 func extractContainerPort(portSpec string) string {
 	// Remove protocol suffix
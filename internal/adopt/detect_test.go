@@ -3,6 +3,7 @@ package adopt
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -136,6 +137,55 @@ func TestAnalyzeService_ExposeDirective(t *testing.T) {
 	}
 }
 
+func TestAnalyzeService_PortLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels any
+	}{
+		{"map", map[string]any{"caddy-atc.port": "4321"}},
+		{"map with int", map[string]any{"caddy-atc.port": 4321}},
+		{"list", []any{"other=x", "caddy-atc.port=4321"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Label wins even over a non-HTTP service name and known ports
+			svc := composeServiceDef{
+				Image:  "custom-image",
+				Ports:  []string{"8080:8080"},
+				Labels: tt.labels,
+			}
+			cs := analyzeService("redis", svc, "")
+			if !cs.IsHTTP || cs.Port != "4321" {
+				t.Errorf("IsHTTP = %v, Port = %q, want true, 4321", cs.IsHTTP, cs.Port)
+			}
+		})
+	}
+}
+
+func TestAnalyzeService_InvalidPortLabelIgnored(t *testing.T) {
+	svc := composeServiceDef{
+		Image:  "myapp:latest",
+		Ports:  []string{"8080:8080"},
+		Labels: map[string]any{"caddy-atc.port": "http"},
+	}
+	cs := analyzeService("api", svc, "")
+	if cs.Port != "8080" {
+		t.Errorf("Port = %q, want %q", cs.Port, "8080")
+	}
+}
+
+func TestAnalyzeService_IgnoreLabel(t *testing.T) {
+	svc := composeServiceDef{
+		Image:  "nginx",
+		Ports:  []string{"8080:80"},
+		Labels: map[string]any{"caddy-atc.ignore": "true"},
+	}
+	cs := analyzeService("admin", svc, "")
+	if cs.IsHTTP || !cs.Ignored {
+		t.Errorf("IsHTTP = %v, Ignored = %v, want false, true", cs.IsHTTP, cs.Ignored)
+	}
+}
+
 func TestScanComposeFile(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -157,7 +207,7 @@ func TestScanComposeFile(t *testing.T) {
 		t.Fatalf("writing compose file: %v", err)
 	}
 
-	services, err := ScanComposeFile(tmpDir, "")
+	services, err := ScanComposeFile(tmpDir, "", nil)
 	if err != nil {
 		t.Fatalf("ScanComposeFile() error = %v", err)
 	}
@@ -192,12 +242,66 @@ func TestScanComposeFile(t *testing.T) {
 
 func TestScanComposeFile_NoFile(t *testing.T) {
 	tmpDir := t.TempDir()
-	_, err := ScanComposeFile(tmpDir, "")
+	_, err := ScanComposeFile(tmpDir, "", nil)
 	if err == nil {
 		t.Error("expected error when no compose file exists")
 	}
 }
 
+func TestScanComposeFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := filepath.Join(tmpDir, "docker-compose.yml")
+	override := filepath.Join(tmpDir, "docker-compose.dev.yml")
+	os.WriteFile(base, []byte(`services:
+  web:
+    image: nginx
+  api:
+    build: .
+    labels:
+      caddy-atc.hostname: api.localhost
+  mail:
+    image: mailpit
+    profiles: [mail]
+  docs:
+    image: nginx
+    profiles: [docs, all]
+`), 0644)
+	os.WriteFile(override, []byte(`services:
+  api:
+    expose: ["8080"]
+    labels: ["caddy-atc.port=8080"]
+  admin:
+    image: nginx
+    profiles: [admin]
+`), 0644)
+
+	tests := []struct {
+		profiles []string
+		want     []string
+	}{
+		{nil, []string{"api", "web"}},
+		{[]string{"all"}, []string{"api", "docs", "web"}},
+		{[]string{"*"}, []string{"admin", "api", "docs", "mail", "web"}},
+	}
+	for _, tt := range tests {
+		services, err := ScanComposeFiles([]string{base, override}, tt.profiles, nil)
+		if err != nil {
+			t.Fatalf("ScanComposeFiles() error = %v", err)
+		}
+		var names []string
+		for _, svc := range services {
+			names = append(names, svc.Name)
+			// The override's port and labels apply on top of the base's.
+			if svc.Name == "api" && (!svc.IsHTTP || svc.Port != "8080" || svc.Hostname != "api.localhost") {
+				t.Errorf("api = %+v, want HTTP on 8080 at api.localhost", svc)
+			}
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("ScanComposeFiles(profiles %v) = %v, want %v", tt.profiles, names, tt.want)
+		}
+	}
+}
+
 func TestScanDockerfileExpose(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -296,7 +400,7 @@ func TestScanComposeFile_ExplicitFile(t *testing.T) {
 		t.Fatalf("writing compose file: %v", err)
 	}
 
-	services, err := ScanComposeFile(tmpDir, "docker-compose.demo.yaml")
+	services, err := ScanComposeFile(tmpDir, "docker-compose.demo.yaml", nil)
 	if err != nil {
 		t.Fatalf("ScanComposeFile() error = %v", err)
 	}
@@ -321,7 +425,7 @@ func TestScanComposeFile_ComposeYml(t *testing.T) {
 		t.Fatalf("writing compose file: %v", err)
 	}
 
-	services, err := ScanComposeFile(tmpDir, "")
+	services, err := ScanComposeFile(tmpDir, "", nil)
 	if err != nil {
 		t.Fatalf("ScanComposeFile() error = %v", err)
 	}
@@ -332,3 +436,25 @@ func TestScanComposeFile_ComposeYml(t *testing.T) {
 		t.Errorf("Name = %q, want %q", services[0].Name, "web")
 	}
 }
+
+func TestAnalyzeService_UpstreamScheme(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  composeServiceDef
+		want string
+	}{
+		{"tls port", composeServiceDef{Ports: []string{"8443:8443"}}, "https"},
+		{"port 443", composeServiceDef{Expose: []string{"443"}}, "https"},
+		{"plain port", composeServiceDef{Ports: []string{"8080:8080"}}, ""},
+		{"label", composeServiceDef{Ports: []string{"9000:9000"}, Labels: map[string]any{"caddy-atc.upstream-scheme": "https"}}, "https"},
+		{"label overrides tls port", composeServiceDef{Ports: []string{"8443:8443"}, Labels: map[string]any{"caddy-atc.upstream-scheme": "http"}}, "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := analyzeService("app", tt.svc, "")
+			if cs.UpstreamScheme != tt.want {
+				t.Errorf("UpstreamScheme = %q, want %q", cs.UpstreamScheme, tt.want)
+			}
+		})
+	}
+}
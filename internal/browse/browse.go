@@ -0,0 +1,144 @@
+// Package browse resolves a project's routes to URLs and opens them in the
+// default browser.
+package browse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/routes"
+)
+
+// URL returns the gateway URL for target: a hostname, a service of the
+// adopted project in dir, or, if target is empty, that project's base
+// hostname. With plainHTTP the URL is http:// on the gateway's HTTP port.
+func URL(ctx context.Context, dir, target string, plainHTTP bool) (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("loading config: %w", err)
+	}
+	host, err := resolveHost(ctx, cfg, dir, target)
+	if err != nil {
+		return "", err
+	}
+	httpPort, httpsPort := cfg.HTTPPorts()
+	if plainHTTP {
+		return config.HTTPSiteURL(host, httpPort), nil
+	}
+	return config.SiteURL(host, httpsPort), nil
+}
+
+// resolveHost returns the hostname target refers to. Anything with a dot
+// is taken as a hostname; otherwise target names a service of the project
+// in dir, routed by projects.yml or, through a hostname label, by the
+// watcher.
+func resolveHost(ctx context.Context, cfg *config.Config, dir, target string) (string, error) {
+	if strings.Contains(target, ".") {
+		host := strings.ToLower(target)
+		if err := config.ValidateHostname(host); err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(host, "*.") {
+			return "", fmt.Errorf("%s is a wildcard; give a hostname under it", host)
+		}
+		return host, nil
+	}
+
+	name, proj, err := findProject(cfg, dir)
+	if err != nil {
+		return "", err
+	}
+	host := proj.Hostname
+	if target != "" {
+		var ok bool
+		if host, ok = proj.Services[target]; !ok {
+			host = activeHost(ctx, proj.ComposeProject, target)
+		}
+		if host == "" {
+			return "", fmt.Errorf("service %q is not routed in project %q (routed: %s)", target, name, strings.Join(serviceNames(proj), ", "))
+		}
+	}
+	if strings.HasPrefix(host, "*.") {
+		return "", fmt.Errorf("project %q routes the wildcard %s; give a service or hostname", name, host)
+	}
+	return host, nil
+}
+
+// activeHost returns the hostname the watcher routes service of
+// composeProject to, or "" if it routes none.
+func activeHost(ctx context.Context, composeProject, service string) string {
+	active, err := routes.ListActive(ctx)
+	if err != nil {
+		return ""
+	}
+	for _, r := range active {
+		if r.Project == composeProject && r.Service == service && r.Quarantine == "" {
+			return r.Hostname
+		}
+	}
+	return ""
+}
+
+func findProject(cfg *config.Config, dir string) (string, *config.ProjectConfig, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("resolving path: %w", err)
+	}
+	name := filepath.Base(absDir)
+	proj, ok := cfg.Projects[name]
+	if !ok {
+		return "", nil, fmt.Errorf("project %q is not adopted; give a hostname or run from a project directory", name)
+	}
+	return name, proj, nil
+}
+
+func serviceNames(proj *config.ProjectConfig) []string {
+	names := make([]string, 0, len(proj.Services))
+	for svc := range proj.Services {
+		names = append(names, svc)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens url in the default browser: $BROWSER if set, otherwise the
+// platform's opener.
+func Open(url string) error {
+	name, args := browserCommand(runtime.GOOS, os.Getenv("BROWSER"), gateway.IsWSL())
+	if err := exec.Command(name, append(args, url)...).Run(); err != nil {
+		return fmt.Errorf("opening browser with %s: %w", name, err)
+	}
+	return nil
+}
+
+// browserCommand returns the command opening a URL, passed as its last
+// argument, on goos. browserEnv is $BROWSER, a colon-separated list of
+// commands of which the first is used.
+func browserCommand(goos, browserEnv string, wsl bool) (string, []string) {
+	if first, _, _ := strings.Cut(browserEnv, ":"); strings.TrimSpace(first) != "" {
+		fields := strings.Fields(first)
+		return fields[0], fields[1:]
+	}
+	switch {
+	case goos == "darwin":
+		return "open", nil
+	case goos == "windows":
+		return "rundll32", []string{"url.dll,FileProtocolHandler"}
+	case wsl:
+		// Open it in the Windows browser, which is where the gateway's
+		// root CA is trusted.
+		if _, err := exec.LookPath("wslview"); err == nil {
+			return "wslview", nil
+		}
+		return "rundll32.exe", []string{"url.dll,FileProtocolHandler"}
+	}
+	return "xdg-open", nil
+}
@@ -0,0 +1,88 @@
+package browse
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func TestURL(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	dir := filepath.Join(tmpDir, "myapp")
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"myapp": {
+			Dir:            dir,
+			ComposeProject: "myapp",
+			Hostname:       "myapp.localhost",
+			Services: map[string]string{
+				"web": "myapp.localhost",
+				"api": "api.myapp.localhost",
+				"dev": "*.dev.myapp.localhost",
+			},
+		},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		dir, target string
+		http        bool
+		want        string
+	}{
+		{dir, "", false, "https://myapp.localhost"},
+		{dir, "api", false, "https://api.myapp.localhost"},
+		{dir, "api", true, "http://api.myapp.localhost"},
+		{tmpDir, "Other.localhost", false, "https://other.localhost"},
+	}
+	for _, tt := range tests {
+		got, err := URL(ctx, tt.dir, tt.target, tt.http)
+		if err != nil {
+			t.Errorf("URL(%q, %q) error = %v", tt.dir, tt.target, err)
+		} else if got != tt.want {
+			t.Errorf("URL(%q, %q) = %q, want %q", tt.dir, tt.target, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ dir, target, errPart string }{
+		{dir, "dev", "wildcard"},
+		{dir, "*.dev.myapp.localhost", "wildcard"},
+		{tmpDir, "", "not adopted"},
+		{dir, "bad host.localhost", ""},
+	} {
+		if _, err := URL(ctx, tt.dir, tt.target, false); err == nil || !strings.Contains(err.Error(), tt.errPart) {
+			t.Errorf("URL(%q, %q) error = %v, want one mentioning %q", tt.dir, tt.target, err, tt.errPart)
+		}
+	}
+}
+
+func TestBrowserCommand(t *testing.T) {
+	tests := []struct {
+		goos, env string
+		wantName  string
+		wantArgs  []string
+	}{
+		{"linux", "", "xdg-open", nil},
+		{"darwin", "", "open", nil},
+		{"windows", "", "rundll32", []string{"url.dll,FileProtocolHandler"}},
+		{"linux", "firefox --new-tab:chromium", "firefox", []string{"--new-tab"}},
+		{"darwin", " ", "open", nil},
+	}
+	for _, tt := range tests {
+		name, args := browserCommand(tt.goos, tt.env, false)
+		if name != tt.wantName || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("browserCommand(%q, %q) = %q %v, want %q %v", tt.goos, tt.env, name, args, tt.wantName, tt.wantArgs)
+		}
+	}
+}
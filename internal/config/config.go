@@ -2,22 +2,100 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 const maxConfigFileSize = 1 << 20 // 1 MB
 
+// PortLabel is the compose service label that pins the HTTP port caddy-atc
+// routes to, overriding port detection.
+const PortLabel = "caddy-atc.port"
+
+// HostnameLabel is the compose service label that overrides the hostname a
+// service is routed at.
+const HostnameLabel = "caddy-atc.hostname"
+
+// IgnoreLabel is the compose service label that excludes a service from
+// routing when set to a true value ("true", "1", ...).
+const IgnoreLabel = "caddy-atc.ignore"
+
+// ProtocolLabel is the compose service label declaring what a service
+// speaks: "http" (default), "grpc" or "ws" (WebSocket).
+const ProtocolLabel = "caddy-atc.protocol"
+
+// Protocols accepted in ProtocolLabel.
+const (
+	ProtocolHTTP      = "http"
+	ProtocolGRPC      = "grpc"
+	ProtocolWebSocket = "ws"
+)
+
+// ContainerProtocol returns the protocol declared by labels, or ProtocolHTTP
+// when unset. An unknown value is reported as an error alongside ProtocolHTTP.
+func ContainerProtocol(labels map[string]string) (string, error) {
+	switch p := labels[ProtocolLabel]; p {
+	case "", ProtocolHTTP:
+		return ProtocolHTTP, nil
+	case ProtocolGRPC, ProtocolWebSocket:
+		return p, nil
+	default:
+		return ProtocolHTTP, fmt.Errorf("%s label: unknown protocol %q (want http, grpc or ws)", ProtocolLabel, p)
+	}
+}
+
+// UpstreamSchemeLabel is the compose service label declaring the scheme the
+// container itself serves: "http" (default) or "https".
+const UpstreamSchemeLabel = "caddy-atc.upstream-scheme"
+
+// ContainerUpstreamScheme returns the scheme declared by labels, or "" when
+// unset. An unknown value is reported as an error alongside "".
+func ContainerUpstreamScheme(labels map[string]string) (string, error) {
+	s := labels[UpstreamSchemeLabel]
+	if s != "" && s != "http" && s != "https" {
+		return "", fmt.Errorf("%s label: unknown scheme %q (want http or https)", UpstreamSchemeLabel, s)
+	}
+	return s, nil
+}
+
+// HealthPathLabel is the compose service label naming the path that answers
+// 2xx when the container is healthy. Setting it also enables Caddy's active
+// health checks for the route.
+const HealthPathLabel = "caddy-atc.health-path"
+
+// ContainerHealthPath returns the health path declared by labels, or "" when
+// unset. An invalid value is reported as an error alongside "".
+func ContainerHealthPath(labels map[string]string) (string, error) {
+	p := labels[HealthPathLabel]
+	if p != "" && !validURLPath.MatchString(p) {
+		return "", fmt.Errorf("%s label: invalid path %q (must be an absolute path like /healthz)", HealthPathLabel, p)
+	}
+	return p, nil
+}
+
+// IsIgnored reports whether labels opt the container out of routing.
+func IsIgnored(labels map[string]string) bool {
+	ignored, _ := strconv.ParseBool(labels[IgnoreLabel])
+	return ignored
+}
+
 // validName matches safe hostnames and container/service names:
 // alphanumeric, dots, hyphens, underscores. Must start with alphanumeric.
 var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
 
+// validURLPath matches a plain absolute URL path with no query, spaces, or
+// Caddyfile syntax characters.
+var validURLPath = regexp.MustCompile(`^/[a-zA-Z0-9._~/-]*$`)
+
 // ValidateHostname checks that a hostname is safe to interpolate into a Caddyfile.
 // Accepts an optional "*." wildcard prefix (e.g., "*.curate.localhost").
 func ValidateHostname(s string) error {
@@ -63,20 +141,22 @@ func ValidatePort(s string) error {
 	return nil
 }
 
-// HomeDir returns the caddy-atc home directory (~/.caddy-atc).
-// Panics if the user's home directory cannot be determined, rather than
-// falling back to a shared temp directory which could enable symlink attacks.
-func HomeDir() string {
-	home, err := os.UserHomeDir()
+// ValidateUpstream checks that a host:port upstream address is safe for
+// Caddyfile use. The host must be a plain hostname or IPv4 address.
+func ValidateUpstream(s string) error {
+	host, port, err := net.SplitHostPort(s)
 	if err != nil {
-		panic(fmt.Sprintf("caddy-atc: cannot determine home directory: %v", err))
+		return fmt.Errorf("invalid upstream %q: must be host:port", s)
 	}
-	return filepath.Join(home, ".caddy-atc")
+	if !validName.MatchString(host) {
+		return fmt.Errorf("invalid upstream host %q: must match [a-zA-Z0-9][a-zA-Z0-9._-]*", host)
+	}
+	return ValidatePort(port)
 }
 
 // CaddyfileDir returns the directory where the Caddyfile is stored.
 func CaddyfileDir() string {
-	return filepath.Join(HomeDir(), "caddyfile")
+	return filepath.Join(StateDir(), "caddyfile")
 }
 
 // CaddyfilePath returns the full path to the generated Caddyfile.
@@ -84,24 +164,114 @@ func CaddyfilePath() string {
 	return filepath.Join(CaddyfileDir(), "Caddyfile")
 }
 
+// TemplatesDir returns the directory of user templates overriding
+// generated files.
+func TemplatesDir() string {
+	return filepath.Join(ConfigDir(), "templates")
+}
+
+// CaddyfileTemplatePath returns the path to the Go template that, if it
+// exists, replaces the built-in Caddyfile layout.
+func CaddyfileTemplatePath() string {
+	return filepath.Join(TemplatesDir(), "Caddyfile.tmpl")
+}
+
 // ProjectsPath returns the path to the projects.yml file.
 func ProjectsPath() string {
-	return filepath.Join(HomeDir(), "projects.yml")
+	return filepath.Join(ConfigDir(), "projects.yml")
 }
 
 // LockPath returns the path to the config lock file.
 func LockPath() string {
-	return filepath.Join(HomeDir(), "projects.lock")
+	return filepath.Join(RuntimeDir(), "projects.lock")
 }
 
 // LogPath returns the path to the watcher log file.
 func LogPath() string {
-	return filepath.Join(HomeDir(), "watcher.log")
+	return filepath.Join(StateDir(), "watcher.log")
 }
 
 // PidPath returns the path to the watcher PID file.
 func PidPath() string {
-	return filepath.Join(HomeDir(), "watcher.pid")
+	return filepath.Join(RuntimeDir(), "watcher.pid")
+}
+
+// ProcessAlive reports whether a process with pid exists, such as the
+// owner recorded in a PID file or pause marker. A process of another user
+// counts as alive.
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// PausePath returns the path to the routing pause marker file.
+func PausePath() string {
+	return filepath.Join(RuntimeDir(), "paused")
+}
+
+// ControlSocketPath returns the path to the Unix socket the running
+// watcher serves its control API on.
+func ControlSocketPath() string {
+	return filepath.Join(RuntimeDir(), "watcher.sock")
+}
+
+// PinsPath returns the path to the hostnames pinned to a single replica.
+func PinsPath() string {
+	return filepath.Join(StateDir(), "pins.yml")
+}
+
+// HealthStatePath returns the path to the upstream health states recorded by
+// the running watcher.
+func HealthStatePath() string {
+	return filepath.Join(StateDir(), "health.yml")
+}
+
+// RootCAPath returns the path to the copy of the gateway's root CA saved by
+// `caddy-atc trust` and `caddy-atc env --ca`.
+func RootCAPath() string {
+	return filepath.Join(StateDir(), "caddy-atc-root-ca.crt")
+}
+
+// CABundlePath returns the path to the system CA bundle extended with the
+// gateway's root CA.
+func CABundlePath() string {
+	return filepath.Join(StateDir(), "ca-bundle.pem")
+}
+
+// TeamCADir returns the directory, inside the Caddyfile directory mounted
+// in the gateway, holding an imported team CA.
+func TeamCADir() string {
+	return filepath.Join(CaddyfileDir(), "ca")
+}
+
+// QuarantinePath returns the path to the list of routes the watcher has
+// excluded from the Caddyfile.
+func QuarantinePath() string {
+	return filepath.Join(StateDir(), "quarantine.yml")
+}
+
+// RouteSnapshotPath returns the path to the routes the watcher last
+// served, shown while Docker is unreachable.
+func RouteSnapshotPath() string {
+	return filepath.Join(StateDir(), "routes.yml")
+}
+
+// RouteMetaPath returns the path to the metadata the watcher keeps about
+// each route across restarts, such as when it appeared.
+func RouteMetaPath() string {
+	return filepath.Join(StateDir(), "route-meta.yml")
+}
+
+// ActiveRoutesPath returns the path to the container routes the watcher
+// last served, which a restarted watcher takes over.
+func ActiveRoutesPath() string {
+	return filepath.Join(StateDir(), "active-routes.yml")
+}
+
+// HistoryPath returns the path to the route changes and reloads recorded
+// by the watcher, read by `caddy-atc query`.
+func HistoryPath() string {
+	return filepath.Join(StateDir(), "history.jsonl")
 }
 
 // ServiceConfig holds the hostname for a single service.
@@ -109,24 +279,644 @@ type ServiceConfig struct {
 	Hostname string `yaml:"hostname"`
 }
 
+// ServiceOptions holds optional reverse proxy settings for one service.
+// File paths are on the host; the watcher copies the files into the
+// gateway's mounted config directory before referencing them.
+type ServiceOptions struct {
+	TLSClientCert string `yaml:"tls_client_cert,omitempty"` // PEM client certificate presented to the upstream
+	TLSClientKey  string `yaml:"tls_client_key,omitempty"`  // PEM private key for TLSClientCert
+	TLSTrustedCA  string `yaml:"tls_trusted_ca,omitempty"`  // PEM CA to verify the upstream (default: skip verification)
+
+	// Retry and timeout policy, for backends that are slow to boot.
+	LBTryDuration string `yaml:"lb_try_duration,omitempty"` // keep retrying a failed request this long (e.g. "30s")
+	LBTryInterval string `yaml:"lb_try_interval,omitempty"` // wait between retries (Caddy default 250ms)
+	LBRetries     int    `yaml:"lb_retries,omitempty"`      // max retries, independent of LBTryDuration
+	DialTimeout   string `yaml:"dial_timeout,omitempty"`    // upstream connect timeout
+
+	// WarmupPath, if set, is requested through the gateway as soon as the
+	// route becomes active so JIT-heavy backends are warm before first use.
+	WarmupPath string `yaml:"warmup_path,omitempty"`
+
+	// CallbackInspector, if set, is a path (e.g. "/auth/callback") the
+	// gateway answers itself, echoing and logging the request for debugging
+	// OAuth redirects instead of passing it to the service.
+	CallbackInspector string `yaml:"callback_inspector,omitempty"`
+
+	// CookieDomain rewrites the Domain attribute of Set-Cookie headers from
+	// the service, for backends configured with a production cookie domain.
+	// "auto" uses the requested hostname; anything else must be a hostname.
+	CookieDomain string `yaml:"cookie_domain,omitempty"`
+
+	// UpstreamScheme is "https" for containers that only serve TLS
+	// (e.g. on 443/8443). Their certificates are not verified unless
+	// TLSTrustedCA is set. A caddy-atc.upstream-scheme label overrides it.
+	UpstreamScheme string `yaml:"upstream_scheme,omitempty"`
+
+	// ReplicaHostnames also routes each replica of the service on its own
+	// hostname, e.g. web-1.myapp.localhost, alongside the load-balanced one.
+	ReplicaHostnames bool `yaml:"replica_hostnames,omitempty"`
+
+	// RedirectFrom lists hostnames, separated by commas, that permanently
+	// redirect to the service's hostname, e.g. "www.myapp.localhost". A
+	// caddy-atc.redirect-from label overrides it.
+	RedirectFrom string `yaml:"redirect_from,omitempty"`
+
+	// Rewrite lists path rewrites applied before proxying, separated by
+	// commas, e.g. "/old -> /new, /api/* -> /v1/*". A trailing * rewrites
+	// a path prefix. A caddy-atc.rewrite label overrides it.
+	Rewrite string `yaml:"rewrite,omitempty"`
+}
+
+// CookieDomainAuto selects the requested hostname as the cookie domain.
+const CookieDomainAuto = "auto"
+
+// UpstreamTLS reports whether the options require a TLS connection to the upstream.
+func (o ServiceOptions) UpstreamTLS() bool {
+	return o.TLSClientCert != "" || o.TLSTrustedCA != "" || o.UpstreamScheme == "https"
+}
+
+// Validate checks that the options are internally consistent.
+func (o ServiceOptions) Validate() error {
+	if (o.TLSClientCert == "") != (o.TLSClientKey == "") {
+		return fmt.Errorf("tls_client_cert and tls_client_key must be set together")
+	}
+	durations := []struct{ name, value string }{
+		{"lb_try_duration", o.LBTryDuration},
+		{"lb_try_interval", o.LBTryInterval},
+		{"dial_timeout", o.DialTimeout},
+	}
+	for _, d := range durations {
+		if err := validateDuration(d.value); err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+	}
+	if o.LBRetries < 0 {
+		return fmt.Errorf("lb_retries must not be negative")
+	}
+	if o.WarmupPath != "" {
+		if !strings.HasPrefix(o.WarmupPath, "/") {
+			return fmt.Errorf("warmup_path %q must start with /", o.WarmupPath)
+		}
+		if strings.ContainsFunc(o.WarmupPath, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+			return fmt.Errorf("warmup_path %q contains whitespace or control characters", o.WarmupPath)
+		}
+	}
+	if o.CallbackInspector != "" && !validURLPath.MatchString(o.CallbackInspector) {
+		return fmt.Errorf("callback_inspector %q must be a plain path matching /[a-zA-Z0-9._~/-]*", o.CallbackInspector)
+	}
+	if o.CookieDomain != "" && o.CookieDomain != CookieDomainAuto && !validName.MatchString(o.CookieDomain) {
+		return fmt.Errorf("cookie_domain %q must be %q or a hostname", o.CookieDomain, CookieDomainAuto)
+	}
+	if o.UpstreamScheme != "" && o.UpstreamScheme != "http" && o.UpstreamScheme != "https" {
+		return fmt.Errorf("upstream_scheme %q must be http or https", o.UpstreamScheme)
+	}
+	if _, err := ParseRedirectFrom(o.RedirectFrom); err != nil {
+		return fmt.Errorf("redirect_from: %w", err)
+	}
+	if _, err := ParseRewrites(o.Rewrite); err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+	return nil
+}
+
+// validateDuration checks an optional Go-style duration (e.g. "500ms", "30s").
+// Durations are interpolated into the Caddyfile, so anything else is rejected.
+func validateDuration(s string) error {
+	if s == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration %q must be positive", s)
+	}
+	return nil
+}
+
 // ProjectConfig represents a single adopted project.
 type ProjectConfig struct {
-	Dir            string            `yaml:"dir"`
-	ComposeProject string            `yaml:"compose_project"`
-	Hostname       string            `yaml:"hostname"`
-	Services       map[string]string `yaml:"services"`
-	ComposeFile    string            `yaml:"compose_file,omitempty"`
+	Dir            string                     `yaml:"dir"`
+	ComposeProject string                     `yaml:"compose_project"`
+	Hostname       string                     `yaml:"hostname"`
+	Services       map[string]string          `yaml:"services"`
+	ComposeFile    string                     `yaml:"compose_file,omitempty"`
+	Options        map[string]*ServiceOptions `yaml:"options,omitempty"` // keyed by service name
+
+	// Verify has the watcher request the project's hostname through the
+	// gateway when its routes are first created, and log the response.
+	Verify bool `yaml:"verify,omitempty"`
+
+	// InjectCA has `caddy-atc start` mount the gateway's root CA into the
+	// project's services and point the standard CA variables at it.
+	InjectCA bool `yaml:"inject_ca,omitempty"`
+
+	// Disabled keeps the project adopted, with its hostnames reserved, but
+	// excluded from routing.
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// Autostart has `caddy-atc up` start the project's compose stack once
+	// the gateway is up.
+	Autostart bool `yaml:"autostart,omitempty"`
+
+	// RetryPage has the gateway answer browsers with a page that reloads
+	// itself, backing off, instead of a bare 502 while a container of the
+	// project is unreachable, e.g. restarting.
+	RetryPage bool `yaml:"retry_page,omitempty"`
+
+	// MixedContent is one of the MixedContent* modes helping apps written
+	// for http://localhost work over the gateway's HTTPS; "" leaves
+	// responses alone.
+	MixedContent string `yaml:"mixed_content,omitempty"`
+
+	// DependsOn names projects whose stacks this one needs: autostart
+	// starts them first and `down --all` stops them last.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+}
+
+// StaticRoute is a manually registered route to an upstream that is not a
+// container managed by the watcher (e.g. a process on the Docker host).
+type StaticRoute struct {
+	Hostname string `yaml:"hostname"`
+	Upstream string `yaml:"upstream,omitempty"`  // host:port, as seen from the gateway container
+	OwnerPID int    `yaml:"owner_pid,omitempty"` // process serving the route, for temporary routes
+
+	// Root is a host directory the gateway serves with file_server instead
+	// of proxying to Upstream. It is mounted read-only at MountPath.
+	Root string `yaml:"root,omitempty"`
+	// SPA serves /index.html for paths with no file under Root.
+	SPA bool `yaml:"spa,omitempty"`
+}
+
+// FileRootMount is the gateway directory under which file route roots are
+// mounted.
+const FileRootMount = "/srv/caddy-atc"
+
+// MountPath returns where the route's Root is mounted in the gateway.
+func (r *StaticRoute) MountPath() string {
+	return FileRootMount + "/" + r.Hostname
+}
+
+// Validate checks that the route is safe to interpolate into a Caddyfile.
+func (r *StaticRoute) Validate() error {
+	if err := ValidateHostname(r.Hostname); err != nil {
+		return err
+	}
+	if r.Root == "" {
+		return ValidateUpstream(r.Upstream)
+	}
+	if r.Upstream != "" {
+		return fmt.Errorf("route has both an upstream and a root directory")
+	}
+	if strings.HasPrefix(r.Hostname, "*.") {
+		return fmt.Errorf("file routes do not support wildcard hostnames")
+	}
+	if !filepath.IsAbs(r.Root) || filepath.Clean(r.Root) != r.Root {
+		return fmt.Errorf("invalid root %q: must be a clean absolute path", r.Root)
+	}
+	return nil
 }
 
 // Config is the top-level config structure.
 type Config struct {
-	Projects map[string]*ProjectConfig `yaml:"projects"`
+	Projects     map[string]*ProjectConfig `yaml:"projects"`
+	StaticRoutes []*StaticRoute            `yaml:"static_routes,omitempty"`
+	Gateway      *GatewayConfig            `yaml:"gateway,omitempty"`
+
+	// HostnameTemplate names services that have no explicit hostname, e.g.
+	// "{service}-{project}.localhost". Unset keeps "<service>.<project
+	// hostname>" for secondary services and "<project><Domain>" for the
+	// primary one.
+	HostnameTemplate string `yaml:"hostname_template,omitempty"`
+
+	// Domain is the suffix of default hostnames, e.g. ".test". Unset
+	// means DefaultDomain.
+	Domain string `yaml:"domain,omitempty"`
+
+	Metrics   *MetricsConfig   `yaml:"metrics,omitempty"`
+	Dashboard *DashboardConfig `yaml:"dashboard,omitempty"`
+	PKI       *PKIConfig       `yaml:"pki,omitempty"`
+	DNS       *DNSConfig       `yaml:"dns,omitempty"`
+	Hosts     *HostsConfig     `yaml:"hosts,omitempty"`
+	LAN       *LANConfig       `yaml:"lan,omitempty"`
+	Docker    *DockerConfig    `yaml:"docker,omitempty"`
+
+	// Settings are the global options from config.yml, loaded alongside.
+	Settings Settings `yaml:"-"`
+}
+
+// PKIConfig labels the gateway's local certificate authority and sets its
+// certificate lifetimes; unset fields keep Caddy's defaults. Names only
+// apply when the CA is first created.
+type PKIConfig struct {
+	Name                 string `yaml:"name,omitempty"`                  // CA display name
+	RootCN               string `yaml:"root_cn,omitempty"`               // root certificate common name
+	IntermediateCN       string `yaml:"intermediate_cn,omitempty"`       // intermediate certificate common name
+	IntermediateLifetime string `yaml:"intermediate_lifetime,omitempty"` // e.g. "720h"; Caddy's default is 7 days
+	CertLifetime         string `yaml:"cert_lifetime,omitempty"`         // site certificates, e.g. "24h"; Caddy's default is 12h
+
+	// TeamCA is set by `caddy-atc trust import-ca` when a team-provided CA
+	// in TeamCADir signs the gateway's certificates instead of a generated
+	// one: TeamCARoot for a root CA and its key, TeamCAIntermediate for an
+	// intermediate CA and its key plus the root it chains to.
+	TeamCA string `yaml:"team_ca,omitempty"`
+}
+
+// Team CA modes.
+const (
+	TeamCARoot         = "root"
+	TeamCAIntermediate = "intermediate"
+)
+
+// validCAName matches CA names and common names: printable text without
+// quotes or Caddyfile syntax characters, at most 64 characters (the X.509
+// limit for a common name).
+var validCAName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9 ._(),-]{0,63}$`)
+
+// Validate checks that the PKI settings are safe to interpolate into the
+// Caddyfile.
+func (p *PKIConfig) Validate() error {
+	for _, f := range []struct{ name, value string }{
+		{"name", p.Name},
+		{"root_cn", p.RootCN},
+		{"intermediate_cn", p.IntermediateCN},
+	} {
+		if f.value != "" && !validCAName.MatchString(f.value) {
+			return fmt.Errorf("pki %s: invalid value %q (letters, digits, spaces and ._(),- only, at most 64 characters)", f.name, f.value)
+		}
+	}
+	if err := validateDuration(p.IntermediateLifetime); err != nil {
+		return fmt.Errorf("pki intermediate_lifetime: %w", err)
+	}
+	if err := validateDuration(p.CertLifetime); err != nil {
+		return fmt.Errorf("pki cert_lifetime: %w", err)
+	}
+	if p.TeamCA != "" && p.TeamCA != TeamCARoot && p.TeamCA != TeamCAIntermediate {
+		return fmt.Errorf("pki team_ca: must be %q or %q, got %q", TeamCARoot, TeamCAIntermediate, p.TeamCA)
+	}
+	return nil
+}
+
+// DefaultDomain is the suffix of default hostnames. Browsers and most
+// resolvers send *.localhost to loopback without any DNS setup.
+const DefaultDomain = ".localhost"
+
+// DomainSuffix returns the configured domain with a leading dot, or
+// DefaultDomain. An invalid domain is reported alongside DefaultDomain.
+func (c *Config) DomainSuffix() (string, error) {
+	domain := c.Settings.Domain
+	if domain == "" {
+		domain = c.Domain
+	}
+	if domain == "" {
+		return DefaultDomain, nil
+	}
+	d := strings.ToLower(strings.TrimPrefix(domain, "."))
+	if strings.HasPrefix(d, "*.") {
+		return DefaultDomain, fmt.Errorf("domain %q: must not be a wildcard", domain)
+	}
+	if err := ValidateHostname(d); err != nil {
+		return DefaultDomain, fmt.Errorf("domain: %w", err)
+	}
+	return "." + d, nil
+}
+
+// OrbStackDomain is the domain OrbStack serves its containers under.
+const OrbStackDomain = ".orb.local"
+
+// DomainWarning explains what a domain other than DefaultDomain needs to
+// resolve, or returns "" if it needs nothing.
+func DomainWarning(domain string) string {
+	if domain == OrbStackDomain || strings.HasSuffix(domain, OrbStackDomain) {
+		return fmt.Sprintf("OrbStack answers %s hostnames itself, so they never reach the gateway; prefer .localhost or .test", OrbStackDomain)
+	}
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+	switch tld {
+	case "localhost":
+		return ""
+	case "local":
+		return fmt.Sprintf("%s hostnames are resolved over mDNS (Bonjour/Avahi), which is slow and skips /etc/hosts on some systems; prefer .test", domain)
+	case "dev", "app", "page", "foo":
+		return fmt.Sprintf(".%s is a public TLD that browsers force onto HTTPS; its hostnames resolve on the internet unless you override them in /etc/hosts or a local resolver", tld)
+	default:
+		return fmt.Sprintf("%s hostnames won't resolve without extra DNS setup: add them to /etc/hosts, or run 'caddy-atc dns enable' to resolve them with the built-in DNS server", domain)
+	}
+}
+
+// MetricsConfig enables the watcher's Prometheus metrics endpoint.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Listen  string `yaml:"listen,omitempty"` // host:port; defaults to DefaultMetricsListen
+}
+
+// DefaultMetricsListen is where the metrics endpoint listens by default.
+const DefaultMetricsListen = "127.0.0.1:20190"
+
+// MetricsListen returns the address of the watcher's metrics endpoint, or
+// "" if it is disabled.
+func (c *Config) MetricsListen() (string, error) {
+	if c.Metrics == nil || !c.Metrics.Enabled {
+		return "", nil
+	}
+	if c.Metrics.Listen == "" {
+		return DefaultMetricsListen, nil
+	}
+	if _, port, err := net.SplitHostPort(c.Metrics.Listen); err != nil || ValidatePort(port) != nil {
+		return "", fmt.Errorf("metrics listen address %q: expected host:port", c.Metrics.Listen)
+	}
+	return c.Metrics.Listen, nil
+}
+
+// DashboardConfig controls the web dashboard the gateway serves, listing
+// the watcher's routes, their health and recent events.
+type DashboardConfig struct {
+	Disabled bool   `yaml:"disabled,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"` // defaults to DefaultDashboardHostname
+}
+
+// DefaultDashboardHostname is where the gateway serves the dashboard by
+// default.
+const DefaultDashboardHostname = "atc.localhost"
+
+// DashboardHostname returns the hostname the gateway serves the dashboard
+// at, or "" if it is disabled. An invalid hostname is reported alongside
+// DefaultDashboardHostname.
+func (c *Config) DashboardHostname() (string, error) {
+	if c.Dashboard != nil && c.Dashboard.Disabled {
+		return "", nil
+	}
+	if c.Dashboard == nil || c.Dashboard.Hostname == "" {
+		return DefaultDashboardHostname, nil
+	}
+	hostname := strings.ToLower(c.Dashboard.Hostname)
+	if strings.HasPrefix(hostname, "*.") {
+		return DefaultDashboardHostname, fmt.Errorf("dashboard hostname %q: must not be a wildcard", c.Dashboard.Hostname)
+	}
+	if err := ValidateHostname(hostname); err != nil {
+		return DefaultDashboardHostname, fmt.Errorf("dashboard hostname: %w", err)
+	}
+	return hostname, nil
+}
+
+// HostsConfig controls the hostnames block caddy-atc keeps in /etc/hosts.
+type HostsConfig struct {
+	// AutoSync has the watcher update the block whenever routes change.
+	AutoSync bool `yaml:"auto_sync,omitempty"`
+}
+
+// DockerConfig tunes how caddy-atc talks to the Docker daemon.
+type DockerConfig struct {
+	// Timeout bounds each Docker API call, e.g. "30s" for a slow VM.
+	// Defaults to DefaultDockerTimeout.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// DefaultDockerTimeout is how long a Docker API call may take by default.
+const DefaultDockerTimeout = 10 * time.Second
+
+// DockerTimeout returns how long a Docker API call may take. An invalid
+// value is reported alongside DefaultDockerTimeout.
+func (c *Config) DockerTimeout() (time.Duration, error) {
+	if c.Docker == nil || c.Docker.Timeout == "" {
+		return DefaultDockerTimeout, nil
+	}
+	if err := validateDuration(c.Docker.Timeout); err != nil {
+		return DefaultDockerTimeout, fmt.Errorf("docker timeout: %w", err)
+	}
+	d, _ := time.ParseDuration(c.Docker.Timeout)
+	return d, nil
+}
+
+// LANConfig enables LAN mode, in which the gateway also serves this
+// machine's LAN IP and mDNS name, with certificates from its local CA, for
+// devices that can't resolve the adopted hostnames.
+type LANConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Name overrides the mDNS name, which defaults to "<hostname>.local".
+	Name string `yaml:"name,omitempty"`
+
+	// Expose is the routed hostname the LAN IP and mDNS name proxy to.
+	Expose string `yaml:"expose,omitempty"`
+}
+
+// DNSConfig enables the watcher's DNS server, which resolves hostnames
+// under Domain to the gateway.
+type DNSConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Listen  string `yaml:"listen,omitempty"` // ip:port; defaults to DefaultDNSListen
+}
+
+// DefaultDNSListen is where the DNS server listens by default. Port 53 is
+// usually taken by the system resolver, which forwards Domain to it.
+const DefaultDNSListen = "127.0.0.1:15353"
+
+// DNSListen returns the UDP address of the watcher's DNS server, or "" if
+// it is disabled. The host must be an IP, since resolvers are pointed at it.
+func (c *Config) DNSListen() (string, error) {
+	if c.DNS == nil || !c.DNS.Enabled {
+		return "", nil
+	}
+	if c.DNS.Listen == "" {
+		return DefaultDNSListen, nil
+	}
+	host, port, err := net.SplitHostPort(c.DNS.Listen)
+	if err != nil || net.ParseIP(host) == nil || ValidatePort(port) != nil {
+		return "", fmt.Errorf("dns listen address %q: expected ip:port", c.DNS.Listen)
+	}
+	return c.DNS.Listen, nil
+}
+
+// GatewayConfig customizes the gateway container.
+type GatewayConfig struct {
+	// Image overrides the Caddy image, e.g. "caddy:2.10-alpine@sha256:<digest>".
+	// With a digest, `up` verifies the running container uses exactly it.
+	Image string `yaml:"image,omitempty"`
+
+	// Hardened runs the gateway as the invoking user with a read-only root
+	// filesystem, no capabilities, and no-new-privileges.
+	Hardened bool `yaml:"hardened,omitempty"`
+
+	// Lazy defers starting the gateway until the watcher routes its first
+	// container, and stops it again after IdleTimeout without routes.
+	Lazy bool `yaml:"lazy,omitempty"`
+
+	// IdleTimeout is how long a lazy gateway keeps running with no routes,
+	// e.g. "30m". Defaults to DefaultIdleTimeout.
+	IdleTimeout string `yaml:"idle_timeout,omitempty"`
+
+	// LogLevel is the gateway's Caddy log level ("debug", "info", "warn",
+	// "error"); unset means Caddy's default, info.
+	LogLevel string `yaml:"log_level,omitempty"`
+
+	// Address is where the gateway's published ports are reached from this
+	// machine, for a Docker daemon on a remote VM or dev server. Defaults
+	// to DefaultGatewayAddress.
+	Address string `yaml:"address,omitempty"`
+
+	// Listen is the host IP the gateway's ports are published on, e.g.
+	// "0.0.0.0" to accept connections from other devices on the LAN.
+	// Defaults to DefaultGatewayAddress, or to all interfaces when Address
+	// points at a remote Docker host.
+	Listen string `yaml:"listen,omitempty"`
+
+	// Plugins are Caddy modules built into the gateway with xcaddy, e.g.
+	// "github.com/mholt/caddy-l4" or "github.com/caddy-dns/cloudflare@v0.2.1".
+	// With any set, the gateway runs a local build on top of Image.
+	Plugins []string `yaml:"plugins,omitempty"`
+
+	// PlainErrors keeps Caddy's bare responses for unrouted hostnames and
+	// unreachable upstreams instead of caddy-atc's error pages.
+	PlainErrors bool `yaml:"plain_errors,omitempty"`
+}
+
+// ErrorPageDomains returns the domains whose unrouted hostnames get
+// caddy-atc's error page: DefaultDomain and the configured domain. nil
+// means error pages are off.
+func (c *Config) ErrorPageDomains() []string {
+	if c.Gateway != nil && c.Gateway.PlainErrors {
+		return nil
+	}
+	domains := []string{DefaultDomain}
+	if domain, err := c.DomainSuffix(); err == nil && domain != DefaultDomain {
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// DefaultGatewayAddress is where a local Docker daemon publishes the
+// gateway's ports.
+const DefaultGatewayAddress = "127.0.0.1"
+
+// GatewayListen returns the host IP the gateway's ports are published on.
+func (c *Config) GatewayListen() (string, error) {
+	if c.Gateway != nil && c.Gateway.Listen != "" {
+		if err := ValidateListenIP(c.Gateway.Listen); err != nil {
+			return "", fmt.Errorf("gateway listen: %w", err)
+		}
+		return c.Gateway.Listen, nil
+	}
+	if addr, _ := c.GatewayAddress(); addr != DefaultGatewayAddress {
+		return "0.0.0.0", nil
+	}
+	return DefaultGatewayAddress, nil
+}
+
+// ValidateListenIP checks an address to publish the gateway's ports on.
+func ValidateListenIP(ip string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP %q (e.g. 127.0.0.1 or 0.0.0.0)", ip)
+	}
+	return nil
+}
+
+// GatewayAddress returns the host or IP the gateway's ports are published
+// on. An invalid value is reported alongside DefaultGatewayAddress.
+func (c *Config) GatewayAddress() (string, error) {
+	if c.Gateway == nil || c.Gateway.Address == "" {
+		return DefaultGatewayAddress, nil
+	}
+	addr := c.Gateway.Address
+	if net.ParseIP(addr) == nil && !validName.MatchString(addr) {
+		return DefaultGatewayAddress, fmt.Errorf("gateway address: invalid host %q", addr)
+	}
+	return addr, nil
+}
+
+// LogLevels lists the gateway log levels, most verbose first.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// ValidateLogLevel checks a gateway log level.
+func ValidateLogLevel(level string) error {
+	if slices.Contains(LogLevels, level) {
+		return nil
+	}
+	return fmt.Errorf("invalid log level %q (want one of: %s)", level, strings.Join(LogLevels, ", "))
+}
+
+// GatewayLogLevel returns the configured gateway log level, or "" for
+// Caddy's default. Invalid values are ignored.
+func (c *Config) GatewayLogLevel() string {
+	level := c.Settings.LogLevel
+	if level == "" && c.Gateway != nil {
+		level = c.Gateway.LogLevel
+	}
+	if level == "info" || ValidateLogLevel(level) != nil {
+		return ""
+	}
+	return level
+}
+
+// GatewayImage returns the configured gateway image, or "" for the
+// default.
+func (c *Config) GatewayImage() string {
+	if c.Settings.Image != "" {
+		return c.Settings.Image
+	}
+	if c.Gateway != nil {
+		return c.Gateway.Image
+	}
+	return ""
+}
+
+// DefaultIdleTimeout is how long a lazy gateway idles before it is stopped.
+const DefaultIdleTimeout = 10 * time.Minute
+
+// LazyGateway reports whether the gateway is started on demand, and how
+// long it may run without routes before it is stopped.
+func (c *Config) LazyGateway() (bool, time.Duration, error) {
+	if c.Gateway == nil || !c.Gateway.Lazy {
+		return false, 0, nil
+	}
+	if c.Gateway.IdleTimeout == "" {
+		return true, DefaultIdleTimeout, nil
+	}
+	if err := validateDuration(c.Gateway.IdleTimeout); err != nil {
+		return true, DefaultIdleTimeout, fmt.Errorf("gateway idle_timeout: %w", err)
+	}
+	d, _ := time.ParseDuration(c.Gateway.IdleTimeout)
+	return true, d, nil
+}
+
+// validImage matches an image reference with an optional sha256 digest.
+var validImage = regexp.MustCompile(`^[a-z0-9][a-z0-9._/:-]*(@sha256:[a-f0-9]{64})?$`)
+
+// ValidateImage checks a gateway image reference. It is passed to docker
+// compose through the environment, so only plain references are accepted.
+func ValidateImage(s string) error {
+	if !validImage.MatchString(s) || strings.Contains(s, "@") && !strings.Contains(s, "@sha256:") {
+		return fmt.Errorf("invalid image reference %q: expected name[:tag][@sha256:<64 hex digits>]", s)
+	}
+	return nil
+}
+
+// validPlugin matches a Go module path with an optional version.
+var validPlugin = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+(/[A-Za-z0-9._~-]+)+(@[A-Za-z0-9._+-]+)?$`)
+
+// ValidatePlugin checks a Caddy plugin module, "<module path>[@<version>]".
+// It is passed to xcaddy in a generated Dockerfile, so only plain module
+// paths are accepted.
+func ValidatePlugin(s string) error {
+	if !validPlugin.MatchString(s) {
+		return fmt.Errorf("invalid plugin %q: expected a Go module path such as github.com/caddy-dns/cloudflare[@version]", s)
+	}
+	return nil
+}
+
+// PluginModule returns a plugin's module path without its version.
+func PluginModule(plugin string) string {
+	module, _, _ := strings.Cut(plugin, "@")
+	return module
 }
 
-// EnsureHomeDir creates the caddy-atc home directory and subdirectories.
+// EnsureHomeDir creates the caddy-atc config, state and runtime
+// directories.
 func EnsureHomeDir() error {
 	dirs := []string{
-		HomeDir(),
+		ConfigDir(),
+		StateDir(),
+		RuntimeDir(),
 		CaddyfileDir(),
 	}
 	for _, d := range dirs {
@@ -143,7 +933,11 @@ func Load() (*Config, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &Config{Projects: make(map[string]*ProjectConfig)}, nil
+			cfg := &Config{Projects: make(map[string]*ProjectConfig)}
+			if err := cfg.loadSettings(); err != nil {
+				return nil, err
+			}
+			return cfg, nil
 		}
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
@@ -163,6 +957,9 @@ func Load() (*Config, error) {
 	if cfg.Projects == nil {
 		cfg.Projects = make(map[string]*ProjectConfig)
 	}
+	if err := cfg.loadSettings(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
@@ -182,32 +979,73 @@ func (c *Config) Save() error {
 // and saves the result atomically. This prevents concurrent adopt/unadopt from
 // overwriting each other's changes.
 func LoadAndModify(fn func(*Config) error) error {
-	if err := EnsureHomeDir(); err != nil {
+	unlock, err := lockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cfg, err := Load()
+	if err != nil {
 		return err
 	}
 
+	if err := fn(cfg); err != nil {
+		return err
+	}
+
+	return cfg.Save()
+}
+
+// lockConfig takes the exclusive config file lock, returning its release.
+func lockConfig() (func(), error) {
+	if err := EnsureHomeDir(); err != nil {
+		return nil, err
+	}
+
 	lockFile, err := os.OpenFile(LockPath(), os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
-		return fmt.Errorf("opening lock file: %w", err)
+		return nil, fmt.Errorf("opening lock file: %w", err)
 	}
-	defer lockFile.Close()
 
 	// Acquire exclusive lock
 	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
-		return fmt.Errorf("acquiring config lock: %w", err)
+		lockFile.Close()
+		return nil, fmt.Errorf("acquiring config lock: %w", err)
 	}
-	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
 
-	cfg, err := Load()
+// RestoreFiles writes files, keyed by their path inside ConfigDir() or
+// StateDir(), atomically and under the config lock.
+func RestoreFiles(files map[string][]byte) error {
+	unlock, err := lockConfig()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	if err := fn(cfg); err != nil {
-		return err
+	for path, data := range files {
+		if !insideDir(path, ConfigDir()) && !insideDir(path, StateDir()) {
+			return fmt.Errorf("invalid file path %q", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+		}
+		if err := atomicWriteFile(path, data, 0600); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
 	}
+	return nil
+}
 
-	return cfg.Save()
+// insideDir reports whether path is below dir.
+func insideDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && filepath.IsLocal(rel)
 }
 
 // FindProjectByComposeProject looks up a project by its Docker Compose project name.
@@ -220,6 +1058,212 @@ func (c *Config) FindProjectByComposeProject(composeName string) (string, *Proje
 	return "", nil
 }
 
+// FindRoutedProject is FindProjectByComposeProject for routing: disabled
+// projects are not found.
+func (c *Config) FindRoutedProject(composeName string) (string, *ProjectConfig) {
+	name, proj := c.FindProjectByComposeProject(composeName)
+	if proj == nil || proj.Disabled {
+		return "", nil
+	}
+	return name, proj
+}
+
+// SetProjectDisabled disables or enables an adopted project. Returns false
+// if it already was.
+func (c *Config) SetProjectDisabled(name string, disabled bool) (bool, error) {
+	proj, ok := c.Projects[name]
+	if !ok {
+		return false, fmt.Errorf("project %q is not adopted", name)
+	}
+	if proj.Disabled == disabled {
+		return false, nil
+	}
+	proj.Disabled = disabled
+	return true, nil
+}
+
+// SetProjectAutostart marks or unmarks an adopted project to be started by
+// `caddy-atc up`. Returns false if it already was.
+func (c *Config) SetProjectAutostart(name string, autostart bool) (bool, error) {
+	proj, ok := c.Projects[name]
+	if !ok {
+		return false, fmt.Errorf("project %q is not adopted", name)
+	}
+	if proj.Autostart == autostart {
+		return false, nil
+	}
+	proj.Autostart = autostart
+	return true, nil
+}
+
+// Mixed-content modes for ProjectConfig.MixedContent.
+const (
+	// MixedContentUpgrade adds a Content-Security-Policy of
+	// upgrade-insecure-requests, so browsers fetch http:// subresources
+	// over https.
+	MixedContentUpgrade = "upgrade"
+	// MixedContentRelax drops the app's own Content-Security-Policy
+	// headers, whose http://localhost sources don't match the gateway's
+	// https origins.
+	MixedContentRelax = "relax"
+)
+
+// ValidateMixedContent checks a ProjectConfig.MixedContent mode.
+func ValidateMixedContent(mode string) error {
+	switch mode {
+	case "", MixedContentUpgrade, MixedContentRelax:
+		return nil
+	}
+	return fmt.Errorf("invalid mixed-content mode %q: must be %s or %s", mode, MixedContentUpgrade, MixedContentRelax)
+}
+
+// SetProjectMixedContent sets the mixed-content mode of the named project,
+// "" for none, reporting whether it changed.
+func (c *Config) SetProjectMixedContent(name, mode string) (bool, error) {
+	if err := ValidateMixedContent(mode); err != nil {
+		return false, err
+	}
+	proj, ok := c.Projects[name]
+	if !ok {
+		return false, fmt.Errorf("project %q is not adopted", name)
+	}
+	if proj.MixedContent == mode {
+		return false, nil
+	}
+	proj.MixedContent = mode
+	return true, nil
+}
+
+// SetProjectRetryPage turns the retry page on or off for the named
+// project, reporting whether it changed.
+func (c *Config) SetProjectRetryPage(name string, on bool) (bool, error) {
+	proj, ok := c.Projects[name]
+	if !ok {
+		return false, fmt.Errorf("project %q is not adopted", name)
+	}
+	if proj.RetryPage == on {
+		return false, nil
+	}
+	proj.RetryPage = on
+	return true, nil
+}
+
+// AutostartProjects returns the names of the projects marked autostart,
+// sorted, leaving out disabled ones.
+func (c *Config) AutostartProjects() []string {
+	var names []string
+	for name, proj := range c.Projects {
+		if proj.Autostart && !proj.Disabled {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// StartOrder sorts the named projects so that each comes after the
+// projects it depends on, and by name otherwise. Dependencies on projects
+// outside names are ignored. Stacks stop in the reverse order.
+func (c *Config) StartOrder(names []string) ([]string, error) {
+	in := make(map[string]bool, len(names))
+	for _, name := range names {
+		in[name] = true
+	}
+	sorted := slices.Clone(names)
+	slices.Sort(sorted)
+
+	const visiting, done = 1, 2
+	state := make(map[string]int, len(names))
+	var order []string
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("projects depend on each other: %s", strings.Join(path, " -> "))
+		}
+		state[name] = visiting
+		if proj := c.Projects[name]; proj != nil {
+			deps := slices.Clone(proj.DependsOn)
+			slices.Sort(deps)
+			for _, dep := range deps {
+				if !in[dep] {
+					continue
+				}
+				if err := visit(dep, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range sorted {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// AddStaticRoute registers a static route, ignoring exact duplicates.
+// Returns false if the route was already present.
+func (c *Config) AddStaticRoute(r *StaticRoute) bool {
+	for _, existing := range c.StaticRoutes {
+		if existing.Hostname == r.Hostname && existing.Upstream == r.Upstream {
+			return false
+		}
+	}
+	c.StaticRoutes = append(c.StaticRoutes, r)
+	return true
+}
+
+// RemoveStaticRoute removes static routes for hostname. If upstream is
+// non-empty, only the route to that upstream is removed. Returns the number
+// of routes removed.
+func (c *Config) RemoveStaticRoute(hostname, upstream string) int {
+	kept := c.StaticRoutes[:0]
+	removed := 0
+	for _, r := range c.StaticRoutes {
+		if r.Hostname == hostname && (upstream == "" || r.Upstream == upstream) {
+			removed++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	c.StaticRoutes = kept
+	return removed
+}
+
+// FindStaticRoute returns the first static route for hostname, or nil.
+func (c *Config) FindStaticRoute(hostname string) *StaticRoute {
+	for _, r := range c.StaticRoutes {
+		if r.Hostname == hostname {
+			return r
+		}
+	}
+	return nil
+}
+
+// HostnameOwner returns the name of the adopted project that routes the
+// given hostname, or "" if no project claims it.
+func (c *Config) HostnameOwner(hostname string) string {
+	for name, proj := range c.Projects {
+		if proj.Hostname == hostname {
+			return name
+		}
+		for _, h := range proj.Services {
+			if h == hostname {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
 // atomicWriteFile writes data to a temp file then renames it to the target path.
 func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
 	dir := filepath.Dir(path)
@@ -265,13 +1309,109 @@ func (p *ProjectConfig) ResolveHostname(serviceName string) string {
 	return serviceName + "." + base
 }
 
+// Hostname template placeholders.
+const (
+	ProjectPlaceholder = "{project}"
+	ServicePlaceholder = "{service}"
+)
+
+// ExpandHostnameTemplate fills in a hostname template for one service of a
+// compose project and validates the result.
+func ExpandHostnameTemplate(tmpl, project, service string) (string, error) {
+	if !strings.Contains(tmpl, ServicePlaceholder) {
+		return "", fmt.Errorf("hostname template %q must contain %s", tmpl, ServicePlaceholder)
+	}
+	h := strings.NewReplacer(ProjectPlaceholder, project, ServicePlaceholder, service).Replace(tmpl)
+	if strings.ContainsAny(h, "{}") {
+		return "", fmt.Errorf("hostname template %q: unknown placeholder (want %s or %s)", tmpl, ProjectPlaceholder, ServicePlaceholder)
+	}
+	if err := ValidateHostname(h); err != nil {
+		return "", fmt.Errorf("hostname template %q: %w", tmpl, err)
+	}
+	return h, nil
+}
+
+// ValidateHostnameTemplate checks a hostname template against a sample
+// project and service.
+func ValidateHostnameTemplate(tmpl string) error {
+	_, err := ExpandHostnameTemplate(tmpl, "project", "service")
+	return err
+}
+
+// ResolveHostname returns the hostname for a service of project projName:
+// its configured hostname, else the global HostnameTemplate, else the
+// project's own fallback. An invalid template falls back as well.
+func (c *Config) ResolveHostname(projName, serviceName string) string {
+	proj := c.Projects[projName]
+	if hostname, ok := proj.Services[serviceName]; ok {
+		return hostname
+	}
+	if c.HostnameTemplate != "" {
+		if h, err := ExpandHostnameTemplate(c.HostnameTemplate, proj.ComposeProject, serviceName); err == nil {
+			return h
+		}
+	}
+	return proj.ResolveHostname(serviceName)
+}
+
+// ReplicaNumberLabel is set by Docker Compose to a container's replica number.
+const ReplicaNumberLabel = "com.docker.compose.container-number"
+
+// ReplicaHostname returns the hostname of one replica of a service,
+// "<service>-<number>.<project hostname>", or "" if number isn't a replica
+// number.
+func (p *ProjectConfig) ReplicaHostname(serviceName, number string) string {
+	if number == "" || strings.Trim(number, "0123456789") != "" {
+		return ""
+	}
+	return serviceName + "-" + number + "." + strings.TrimPrefix(p.Hostname, "*.")
+}
+
+// ResolveContainerHostname returns the hostname for a container of project
+// projName with the given labels: its HostnameLabel if set, otherwise the
+// project's mapping for its compose service. An invalid label, or one naming
+// a hostname routed by another project, is reported as an error alongside
+// the fallback hostname.
+func (c *Config) ResolveContainerHostname(projName string, labels map[string]string) (string, error) {
+	fallback := c.ResolveHostname(projName, labels["com.docker.compose.service"])
+
+	h := labels[HostnameLabel]
+	if h == "" {
+		return fallback, nil
+	}
+	if err := ValidateHostname(h); err != nil {
+		return fallback, fmt.Errorf("%s label: %w", HostnameLabel, err)
+	}
+	if owner := c.HostnameOwner(h); owner != "" && owner != projName {
+		return fallback, fmt.Errorf("%s label: %s is routed by project %q", HostnameLabel, h, owner)
+	}
+	return h, nil
+}
+
+// ServiceOptions returns the proxy options for a service, with relative
+// file paths resolved against the project directory.
+func (p *ProjectConfig) ServiceOptions(serviceName string) ServiceOptions {
+	o, ok := p.Options[serviceName]
+	if !ok || o == nil {
+		return ServiceOptions{}
+	}
+	return resolveOptionPaths(*o, p.Dir)
+}
+
 // FilterEnv returns os.Environ() with any existing key=... entries for the
-// given key removed, preventing duplicates when appending.
-func FilterEnv(key string) []string {
-	prefix := strings.ToUpper(key) + "="
+// given keys removed, preventing duplicates when appending.
+func FilterEnv(keys ...string) []string {
 	var filtered []string
 	for _, e := range os.Environ() {
-		if !strings.HasPrefix(strings.ToUpper(e), prefix) {
+		name, _, _ := strings.Cut(e, "=")
+		drop := false
+		for _, key := range keys {
+			if strings.EqualFold(name, key) {
+				drop = true
+				break
+			}
+		}
+		if !drop {
 			filtered = append(filtered, e)
 		}
 	}
@@ -3,11 +3,19 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
 )
 
+func TestMain(m *testing.M) {
+	testenv.Main(m, DirEnv)
+}
+
 func TestValidateHostname(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -122,6 +130,56 @@ func TestResolveHostname(t *testing.T) {
 	}
 }
 
+func TestExpandHostnameTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{"{service}-{project}.localhost", "api-myapp.localhost", false},
+		{"{project}.{service}.dev.test", "myapp.api.dev.test", false},
+		{"{service}.localhost", "api.localhost", false},
+		{"{project}.localhost", "", true},        // every service would collide
+		{"{service}.{team}.localhost", "", true}, // unknown placeholder
+		{"{service} {project}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := ExpandHostnameTemplate(tt.tmpl, "myapp", "api")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandHostnameTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandHostnameTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigResolveHostname_Template(t *testing.T) {
+	cfg := &Config{
+		HostnameTemplate: "{service}-{project}.dev.test",
+		Projects: map[string]*ProjectConfig{
+			"myapp": {
+				ComposeProject: "myapp",
+				Hostname:       "myapp.dev.test",
+				Services:       map[string]string{"web": "myapp.dev.test"},
+			},
+		},
+	}
+	if got := cfg.ResolveHostname("myapp", "web"); got != "myapp.dev.test" {
+		t.Errorf("mapped service = %q, want myapp.dev.test", got)
+	}
+	if got := cfg.ResolveHostname("myapp", "worker"); got != "worker-myapp.dev.test" {
+		t.Errorf("unmapped service = %q, want worker-myapp.dev.test", got)
+	}
+
+	cfg.HostnameTemplate = "{bogus}"
+	if got := cfg.ResolveHostname("myapp", "worker"); got != "worker.myapp.dev.test" {
+		t.Errorf("invalid template = %q, want project fallback", got)
+	}
+}
+
 func TestResolveHostname_Wildcard(t *testing.T) {
 	wp := &ProjectConfig{
 		Hostname: "*.curate.localhost",
@@ -335,6 +393,31 @@ func TestAtomicWriteFile(t *testing.T) {
 	}
 }
 
+func TestRestoreFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	files := map[string][]byte{
+		ProjectsPath():                         []byte("projects: {}\n"),
+		filepath.Join(TeamCADir(), "root.crt"): []byte("root"),
+	}
+	if err := RestoreFiles(files); err != nil {
+		t.Fatalf("RestoreFiles() error = %v", err)
+	}
+	for path, want := range files {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	if err := RestoreFiles(map[string][]byte{filepath.Join(ConfigDir(), "..", "outside"): []byte("x")}); err == nil {
+		t.Error("RestoreFiles() accepted a path outside the home directory")
+	}
+}
+
 func TestLoadAndModifyConcurrent(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Setenv("HOME", tmpDir)
@@ -413,3 +496,683 @@ func TestProjectConfig_ComposeFileRoundTrip(t *testing.T) {
 	}
 }
 
+func TestValidateUpstream(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"valid host", "host.docker.internal:5173", false},
+		{"valid ip", "172.17.0.1:8080", false},
+		{"missing port", "web", true},
+		{"bad port", "web:abc", true},
+		{"injection", "web}\n:80", true},
+		{"empty host", ":80", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUpstream(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpstream(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddStaticRoute_Dedupes(t *testing.T) {
+	cfg := &Config{}
+	if !cfg.AddStaticRoute(&StaticRoute{Hostname: "vite.localhost", Upstream: "host.docker.internal:5173"}) {
+		t.Error("first AddStaticRoute() = false, want true")
+	}
+	if cfg.AddStaticRoute(&StaticRoute{Hostname: "vite.localhost", Upstream: "host.docker.internal:5173"}) {
+		t.Error("duplicate AddStaticRoute() = true, want false")
+	}
+	if len(cfg.StaticRoutes) != 1 {
+		t.Errorf("len(StaticRoutes) = %d, want 1", len(cfg.StaticRoutes))
+	}
+}
+
+func TestSetProjectDisabled(t *testing.T) {
+	cfg := &Config{
+		Projects: map[string]*ProjectConfig{
+			"myapp": {ComposeProject: "myapp", Hostname: "myapp.localhost"},
+		},
+	}
+
+	if changed, err := cfg.SetProjectDisabled("myapp", true); err != nil || !changed {
+		t.Fatalf("SetProjectDisabled(true) = %v, %v", changed, err)
+	}
+	if _, proj := cfg.FindRoutedProject("myapp"); proj != nil {
+		t.Error("FindRoutedProject() found a disabled project")
+	}
+	if _, proj := cfg.FindProjectByComposeProject("myapp"); proj == nil {
+		t.Error("FindProjectByComposeProject() lost a disabled project")
+	}
+	if owner := cfg.HostnameOwner("myapp.localhost"); owner != "myapp" {
+		t.Errorf("HostnameOwner() = %q, want the disabled project to keep its hostname", owner)
+	}
+	if changed, _ := cfg.SetProjectDisabled("myapp", true); changed {
+		t.Error("disabling twice reported a change")
+	}
+
+	if changed, err := cfg.SetProjectDisabled("myapp", false); err != nil || !changed {
+		t.Fatalf("SetProjectDisabled(false) = %v, %v", changed, err)
+	}
+	if _, proj := cfg.FindRoutedProject("myapp"); proj == nil {
+		t.Error("FindRoutedProject() didn't find the re-enabled project")
+	}
+
+	if _, err := cfg.SetProjectDisabled("missing", true); err == nil {
+		t.Error("SetProjectDisabled() accepted a project that isn't adopted")
+	}
+}
+
+func TestAutostartProjects(t *testing.T) {
+	cfg := &Config{
+		Projects: map[string]*ProjectConfig{
+			"web":    {ComposeProject: "web"},
+			"api":    {ComposeProject: "api"},
+			"parked": {ComposeProject: "parked", Autostart: true, Disabled: true},
+			"docs":   {ComposeProject: "docs"},
+		},
+	}
+
+	for _, name := range []string{"web", "api"} {
+		if changed, err := cfg.SetProjectAutostart(name, true); err != nil || !changed {
+			t.Fatalf("SetProjectAutostart(%s, true) = %v, %v", name, changed, err)
+		}
+	}
+	if changed, _ := cfg.SetProjectAutostart("web", true); changed {
+		t.Error("marking autostart twice reported a change")
+	}
+	if _, err := cfg.SetProjectAutostart("missing", true); err == nil {
+		t.Error("SetProjectAutostart() accepted a project that isn't adopted")
+	}
+	if changed, err := cfg.SetProjectRetryPage("web", true); err != nil || !changed || !cfg.Projects["web"].RetryPage {
+		t.Errorf("SetProjectRetryPage(web, true) = %v, %v", changed, err)
+	}
+	if _, err := cfg.SetProjectRetryPage("missing", true); err == nil {
+		t.Error("SetProjectRetryPage() accepted a project that isn't adopted")
+	}
+	if changed, err := cfg.SetProjectMixedContent("web", MixedContentUpgrade); err != nil || !changed || cfg.Projects["web"].MixedContent != MixedContentUpgrade {
+		t.Errorf("SetProjectMixedContent(web, upgrade) = %v, %v", changed, err)
+	}
+	if _, err := cfg.SetProjectMixedContent("web", "downgrade"); err == nil {
+		t.Error("SetProjectMixedContent() accepted an unknown mode")
+	}
+
+	if got := cfg.AutostartProjects(); !slices.Equal(got, []string{"api", "web"}) {
+		t.Errorf("AutostartProjects() = %v, want [api web]", got)
+	}
+
+	if changed, err := cfg.SetProjectAutostart("web", false); err != nil || !changed {
+		t.Fatalf("SetProjectAutostart(web, false) = %v, %v", changed, err)
+	}
+	if got := cfg.AutostartProjects(); !slices.Equal(got, []string{"api"}) {
+		t.Errorf("AutostartProjects() = %v, want [api]", got)
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	cfg := &Config{
+		Projects: map[string]*ProjectConfig{
+			"web":   {DependsOn: []string{"api", "auth"}},
+			"api":   {DependsOn: []string{"db"}},
+			"auth":  {},
+			"db":    {},
+			"docs":  {DependsOn: []string{"other"}},
+			"other": {},
+		},
+	}
+
+	got, err := cfg.StartOrder([]string{"web", "docs", "api", "auth", "db"})
+	if err != nil {
+		t.Fatalf("StartOrder() error = %v", err)
+	}
+	want := []string{"db", "api", "auth", "docs", "web"}
+	if !slices.Equal(got, want) {
+		t.Errorf("StartOrder() = %v, want %v", got, want)
+	}
+
+	cfg.Projects["db"].DependsOn = []string{"web"}
+	if _, err := cfg.StartOrder([]string{"web", "api", "db"}); err == nil || !strings.Contains(err.Error(), "depend on each other") {
+		t.Errorf("StartOrder() with a cycle = %v, want cycle error", err)
+	}
+}
+
+func TestHostnameOwner(t *testing.T) {
+	cfg := &Config{Projects: map[string]*ProjectConfig{
+		"myapp": {Hostname: "myapp.localhost", Services: map[string]string{"api": "api.myapp.localhost"}},
+	}}
+	if got := cfg.HostnameOwner("api.myapp.localhost"); got != "myapp" {
+		t.Errorf("HostnameOwner(service) = %q, want %q", got, "myapp")
+	}
+	if got := cfg.HostnameOwner("myapp.localhost"); got != "myapp" {
+		t.Errorf("HostnameOwner(base) = %q, want %q", got, "myapp")
+	}
+	if got := cfg.HostnameOwner("other.localhost"); got != "" {
+		t.Errorf("HostnameOwner(unknown) = %q, want empty", got)
+	}
+}
+
+func TestRemoveStaticRoute(t *testing.T) {
+	cfg := &Config{StaticRoutes: []*StaticRoute{
+		{Hostname: "a.localhost", Upstream: "host.docker.internal:1"},
+		{Hostname: "a.localhost", Upstream: "host.docker.internal:2"},
+		{Hostname: "b.localhost", Upstream: "host.docker.internal:3"},
+	}}
+	if n := cfg.RemoveStaticRoute("a.localhost", "host.docker.internal:2"); n != 1 {
+		t.Errorf("RemoveStaticRoute(exact) = %d, want 1", n)
+	}
+	if n := cfg.RemoveStaticRoute("a.localhost", ""); n != 1 {
+		t.Errorf("RemoveStaticRoute(hostname) = %d, want 1", n)
+	}
+	if len(cfg.StaticRoutes) != 1 || cfg.FindStaticRoute("b.localhost") == nil {
+		t.Errorf("StaticRoutes = %+v, want only b.localhost", cfg.StaticRoutes)
+	}
+}
+
+func TestStaticRoute_ValidateRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   StaticRoute
+		wantErr bool
+	}{
+		{"upstream", StaticRoute{Hostname: "a.localhost", Upstream: "host.docker.internal:1"}, false},
+		{"root", StaticRoute{Hostname: "docs.localhost", Root: "/home/dev/site/dist"}, false},
+		{"root and upstream", StaticRoute{Hostname: "docs.localhost", Root: "/srv", Upstream: "web:80"}, true},
+		{"relative root", StaticRoute{Hostname: "docs.localhost", Root: "dist"}, true},
+		{"unclean root", StaticRoute{Hostname: "docs.localhost", Root: "/home/dev/../dist"}, true},
+		{"wildcard root", StaticRoute{Hostname: "*.docs.localhost", Root: "/srv"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.route.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServiceOptions_ResolvesRelativePaths(t *testing.T) {
+	p := &ProjectConfig{
+		Dir: "/home/dev/billing",
+		Options: map[string]*ServiceOptions{
+			"api": {TLSClientCert: "certs/client.crt", TLSClientKey: "/etc/keys/client.key"},
+		},
+	}
+	got := p.ServiceOptions("api")
+	if got.TLSClientCert != "/home/dev/billing/certs/client.crt" {
+		t.Errorf("TLSClientCert = %q, want resolved against project dir", got.TLSClientCert)
+	}
+	if got.TLSClientKey != "/etc/keys/client.key" {
+		t.Errorf("TLSClientKey = %q, want absolute path unchanged", got.TLSClientKey)
+	}
+	// The stored config must not be mutated
+	if p.Options["api"].TLSClientCert != "certs/client.crt" {
+		t.Error("ServiceOptions() mutated stored options")
+	}
+	if got := p.ServiceOptions("web"); got != (ServiceOptions{}) {
+		t.Errorf("ServiceOptions(unknown) = %+v, want zero", got)
+	}
+}
+
+func TestServiceOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ServiceOptions
+		wantErr bool
+	}{
+		{"empty", ServiceOptions{}, false},
+		{"valid durations", ServiceOptions{LBTryDuration: "30s", LBTryInterval: "250ms", DialTimeout: "5s", LBRetries: 3}, false},
+		{"cert without key", ServiceOptions{TLSClientCert: "c.crt"}, true},
+		{"key without cert", ServiceOptions{TLSClientKey: "c.key"}, true},
+		{"bad duration", ServiceOptions{LBTryDuration: "soon"}, true},
+		{"negative duration", ServiceOptions{DialTimeout: "-1s"}, true},
+		{"zero duration", ServiceOptions{LBTryInterval: "0s"}, true},
+		{"negative retries", ServiceOptions{LBRetries: -1}, true},
+		{"warmup path", ServiceOptions{WarmupPath: "/health?deep=1"}, false},
+		{"relative warmup path", ServiceOptions{WarmupPath: "health"}, true},
+		{"warmup path with space", ServiceOptions{WarmupPath: "/a b"}, true},
+		{"callback inspector", ServiceOptions{CallbackInspector: "/auth/callback"}, false},
+		{"callback inspector with query", ServiceOptions{CallbackInspector: "/cb?x=1"}, true},
+		{"callback inspector injection", ServiceOptions{CallbackInspector: "/cb\n}"}, true},
+		{"cookie domain auto", ServiceOptions{CookieDomain: "auto"}, false},
+		{"cookie domain hostname", ServiceOptions{CookieDomain: "myapp.localhost"}, false},
+		{"cookie domain wildcard", ServiceOptions{CookieDomain: "*.myapp.localhost"}, true},
+		{"cookie domain injection", ServiceOptions{CookieDomain: `a" "b`}, true},
+		{"upstream scheme https", ServiceOptions{UpstreamScheme: "https"}, false},
+		{"upstream scheme invalid", ServiceOptions{UpstreamScheme: "h2"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{"caddy:2-alpine", false},
+		{"caddy:2.10-alpine@" + digest, false},
+		{"registry.example.com/mirror/caddy@" + digest, false},
+		{"caddy@sha256:abc", true},
+		{"Caddy:latest", true},
+		{"caddy:2 --privileged", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := ValidateImage(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateImage(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePlugin(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{"github.com/mholt/caddy-l4", false},
+		{"github.com/caddy-dns/cloudflare@v0.2.1", false},
+		{"github.com/corazawaf/coraza-caddy/v2", false},
+		{"caddy-l4", true},
+		{"github.com/mholt/caddy-l4\nRUN id", true},
+		{"github.com/mholt/caddy-l4 --output /tmp", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := ValidatePlugin(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePlugin(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+	if got := PluginModule("github.com/caddy-dns/cloudflare@v0.2.1"); got != "github.com/caddy-dns/cloudflare" {
+		t.Errorf("PluginModule() = %q", got)
+	}
+}
+
+func TestLazyGateway(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateway  *GatewayConfig
+		wantLazy bool
+		wantIdle time.Duration
+		wantErr  bool
+	}{
+		{"unset", nil, false, 0, false},
+		{"disabled", &GatewayConfig{IdleTimeout: "5m"}, false, 0, false},
+		{"default timeout", &GatewayConfig{Lazy: true}, true, DefaultIdleTimeout, false},
+		{"custom timeout", &GatewayConfig{Lazy: true, IdleTimeout: "30m"}, true, 30 * time.Minute, false},
+		{"invalid timeout", &GatewayConfig{Lazy: true, IdleTimeout: "soon"}, true, DefaultIdleTimeout, true},
+		{"negative timeout", &GatewayConfig{Lazy: true, IdleTimeout: "-1m"}, true, DefaultIdleTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Gateway: tt.gateway}
+			lazy, idle, err := cfg.LazyGateway()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LazyGateway() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if lazy != tt.wantLazy || idle != tt.wantIdle {
+				t.Errorf("LazyGateway() = %v, %s, want %v, %s", lazy, idle, tt.wantLazy, tt.wantIdle)
+			}
+		})
+	}
+}
+
+func TestGatewayAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway *GatewayConfig
+		want    string
+		wantErr bool
+	}{
+		{"unset", nil, DefaultGatewayAddress, false},
+		{"ip", &GatewayConfig{Address: "192.168.64.2"}, "192.168.64.2", false},
+		{"hostname", &GatewayConfig{Address: "devbox.lan"}, "devbox.lan", false},
+		{"invalid", &GatewayConfig{Address: "devbox:443"}, DefaultGatewayAddress, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Gateway: tt.gateway}
+			got, err := cfg.GatewayAddress()
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("GatewayAddress() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPKIConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pki     PKIConfig
+		wantErr bool
+	}{
+		{"empty", PKIConfig{}, false},
+		{"all set", PKIConfig{Name: "ACME Corp. Dev CA (local)", RootCN: "ACME Dev Root", IntermediateCN: "ACME Dev Intermediate", IntermediateLifetime: "720h", CertLifetime: "24h"}, false},
+		{"quote in name", PKIConfig{Name: `Dev "CA"`}, true},
+		{"brace in root cn", PKIConfig{RootCN: "Dev {Root}"}, true},
+		{"too long", PKIConfig{IntermediateCN: strings.Repeat("a", 65)}, true},
+		{"bad lifetime", PKIConfig{IntermediateLifetime: "30d"}, true},
+		{"negative cert lifetime", PKIConfig{CertLifetime: "-1h"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.pki.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDomainSuffix(t *testing.T) {
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{"", DefaultDomain, false},
+		{".test", ".test", false},
+		{"test", ".test", false},
+		{".Dev.Local", ".dev.local", false},
+		{"*.test", DefaultDomain, true},
+		{".bad domain", DefaultDomain, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Domain: tt.domain}
+		got, err := cfg.DomainSuffix()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("DomainSuffix(%q) = %q, %v, want %q, wantErr %v", tt.domain, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDomainWarning(t *testing.T) {
+	if w := DomainWarning(".localhost"); w != "" {
+		t.Errorf("DomainWarning(.localhost) = %q, want none", w)
+	}
+	for domain, want := range map[string]string{
+		".test":          "caddy-atc dns enable",
+		".dev.local":     "mDNS",
+		".orb.local":     "OrbStack",
+		".app.orb.local": "OrbStack",
+		".dev":           "public TLD",
+	} {
+		if w := DomainWarning(domain); !strings.Contains(w, want) {
+			t.Errorf("DomainWarning(%q) = %q, want it to mention %q", domain, w, want)
+		}
+	}
+}
+
+func TestResolveContainerHostname(t *testing.T) {
+	cfg := &Config{Projects: map[string]*ProjectConfig{
+		"myapp": {
+			Hostname: "myapp.localhost",
+			Services: map[string]string{"web": "myapp.localhost", "api": "api.myapp.localhost"},
+		},
+		"other": {
+			Hostname: "other.localhost",
+			Services: map[string]string{"web": "other.localhost"},
+		},
+	}}
+
+	tests := []struct {
+		name    string
+		label   string
+		want    string
+		wantErr bool
+	}{
+		{"no label", "", "api.myapp.localhost", false},
+		{"label", "backend.myapp.localhost", "backend.myapp.localhost", false},
+		{"label reuses own hostname", "myapp.localhost", "myapp.localhost", false},
+		{"label claims other project", "other.localhost", "api.myapp.localhost", true},
+		{"invalid label", "bad host", "api.myapp.localhost", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{"com.docker.compose.service": "api"}
+			if tt.label != "" {
+				labels[HostnameLabel] = tt.label
+			}
+			got, err := cfg.ResolveContainerHostname("myapp", labels)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("hostname = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"true", true},
+		{"1", true},
+		{"TRUE", true},
+		{"false", false},
+		{"", false},
+		{"yes", false},
+	}
+	for _, tt := range tests {
+		if got := IsIgnored(map[string]string{IgnoreLabel: tt.value}); got != tt.want {
+			t.Errorf("IsIgnored(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestContainerProtocol(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", ProtocolHTTP, false},
+		{"http", ProtocolHTTP, false},
+		{"grpc", ProtocolGRPC, false},
+		{"ws", ProtocolWebSocket, false},
+		{"h2c", ProtocolHTTP, true},
+	}
+	for _, tt := range tests {
+		got, err := ContainerProtocol(map[string]string{ProtocolLabel: tt.value})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ContainerProtocol(%q) = %q, %v, want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestContainerHealthPath(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/healthz", "/healthz", false},
+		{"/api/v1/health", "/api/v1/health", false},
+		{"healthz", "", true},
+		{"/health?full=1", "", true},
+		{"/health }", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ContainerHealthPath(map[string]string{HealthPathLabel: tt.value})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ContainerHealthPath(%q) = %q, %v, want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReplicaHostname(t *testing.T) {
+	p := &ProjectConfig{Hostname: "myapp.localhost"}
+	if got := p.ReplicaHostname("web", "2"); got != "web-2.myapp.localhost" {
+		t.Errorf("ReplicaHostname() = %q, want web-2.myapp.localhost", got)
+	}
+	wp := &ProjectConfig{Hostname: "*.curate.localhost"}
+	if got := wp.ReplicaHostname("api", "1"); got != "api-1.curate.localhost" {
+		t.Errorf("wildcard ReplicaHostname() = %q, want api-1.curate.localhost", got)
+	}
+	for _, n := range []string{"", "x", "1;"} {
+		if got := p.ReplicaHostname("web", n); got != "" {
+			t.Errorf("ReplicaHostname(%q) = %q, want empty", n, got)
+		}
+	}
+}
+
+func TestDNSListen(t *testing.T) {
+	tests := []struct {
+		name    string
+		dns     *DNSConfig
+		want    string
+		wantErr bool
+	}{
+		{"unset", nil, "", false},
+		{"disabled", &DNSConfig{Listen: "127.0.0.1:5300"}, "", false},
+		{"default address", &DNSConfig{Enabled: true}, DefaultDNSListen, false},
+		{"custom address", &DNSConfig{Enabled: true, Listen: "127.0.0.2:53"}, "127.0.0.2:53", false},
+		{"hostname", &DNSConfig{Enabled: true, Listen: "localhost:5300"}, "", true},
+		{"missing port", &DNSConfig{Enabled: true, Listen: "127.0.0.1"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Config{DNS: tt.dns}).DNSListen()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DNSListen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DNSListen() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatewayListen(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway *GatewayConfig
+		want    string
+		wantErr bool
+	}{
+		{"unset", nil, "127.0.0.1", false},
+		{"all interfaces", &GatewayConfig{Listen: "0.0.0.0"}, "0.0.0.0", false},
+		{"ipv6", &GatewayConfig{Listen: "::"}, "::", false},
+		{"remote daemon", &GatewayConfig{Address: "192.168.64.2"}, "0.0.0.0", false},
+		{"remote daemon, explicit", &GatewayConfig{Address: "192.168.64.2", Listen: "192.168.64.2"}, "192.168.64.2", false},
+		{"hostname", &GatewayConfig{Listen: "localhost"}, "", true},
+		{"with port", &GatewayConfig{Listen: "0.0.0.0:80"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Config{Gateway: tt.gateway}).GatewayListen()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GatewayListen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GatewayListen() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		docker  *DockerConfig
+		want    time.Duration
+		wantErr bool
+	}{
+		{"unset", nil, DefaultDockerTimeout, false},
+		{"custom", &DockerConfig{Timeout: "30s"}, 30 * time.Second, false},
+		{"invalid", &DockerConfig{Timeout: "soon"}, DefaultDockerTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Config{Docker: tt.docker}).DockerTimeout()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DockerTimeout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DockerTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsListen(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics *MetricsConfig
+		want    string
+		wantErr bool
+	}{
+		{"unset", nil, "", false},
+		{"disabled", &MetricsConfig{Listen: "127.0.0.1:9999"}, "", false},
+		{"default address", &MetricsConfig{Enabled: true}, DefaultMetricsListen, false},
+		{"custom address", &MetricsConfig{Enabled: true, Listen: "0.0.0.0:9100"}, "0.0.0.0:9100", false},
+		{"missing port", &MetricsConfig{Enabled: true, Listen: "localhost"}, "", true},
+		{"bad port", &MetricsConfig{Enabled: true, Listen: "localhost:http"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Config{Metrics: tt.metrics}).MetricsListen()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MetricsListen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MetricsListen() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboardHostname(t *testing.T) {
+	tests := []struct {
+		name      string
+		dashboard *DashboardConfig
+		want      string
+		wantErr   bool
+	}{
+		{"unset", nil, DefaultDashboardHostname, false},
+		{"disabled", &DashboardConfig{Disabled: true, Hostname: "atc.test"}, "", false},
+		{"custom hostname", &DashboardConfig{Hostname: "Routes.localhost"}, "routes.localhost", false},
+		{"wildcard", &DashboardConfig{Hostname: "*.atc.localhost"}, DefaultDashboardHostname, true},
+		{"invalid", &DashboardConfig{Hostname: "atc localhost"}, DefaultDashboardHostname, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Config{Dashboard: tt.dashboard}).DashboardHostname()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DashboardHostname() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DashboardHostname() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !ProcessAlive(os.Getpid()) {
+		t.Error("ProcessAlive(self) = false")
+	}
+	// Above the largest PID Linux and macOS hand out.
+	if ProcessAlive(9999999) {
+		t.Error("ProcessAlive(9999999) = true for a PID that can't exist")
+	}
+}
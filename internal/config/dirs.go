@@ -0,0 +1,225 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// HomeEnv overrides where caddy-atc keeps all of its files, as a single
+// directory laid out like the legacy ~/.caddy-atc.
+const HomeEnv = "CADDY_ATC_HOME"
+
+// DirEnv are the environment variables that choose caddy-atc's directories.
+var DirEnv = []string{HomeEnv, "XDG_CONFIG_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR"}
+
+// userHome returns the user's home directory.
+// Panics if the user's home directory cannot be determined, rather than
+// falling back to a shared temp directory which could enable symlink attacks.
+func userHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(fmt.Sprintf("caddy-atc: cannot determine home directory: %v", err))
+	}
+	return home
+}
+
+// LegacyHomeDir returns the directory caddy-atc used before the XDG layout.
+func LegacyHomeDir() string {
+	return filepath.Join(userHome(), ".caddy-atc")
+}
+
+// xdgDir returns $env/caddy-atc, or fallback/caddy-atc under the home
+// directory when env is unset or not absolute, as the XDG spec requires.
+func xdgDir(env, fallback string) string {
+	base := os.Getenv(env)
+	if !filepath.IsAbs(base) {
+		base = filepath.Join(userHome(), fallback)
+	}
+	return filepath.Join(base, "caddy-atc")
+}
+
+func xdgConfigDir() string { return xdgDir("XDG_CONFIG_HOME", ".config") }
+func xdgStateDir() string  { return xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state")) }
+
+// xdgRuntimeDir returns $XDG_RUNTIME_DIR/caddy-atc, or the state directory
+// on systems without one, such as macOS.
+func xdgRuntimeDir() string {
+	if base := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(base) {
+		return filepath.Join(base, "caddy-atc")
+	}
+	return xdgStateDir()
+}
+
+// singleDir returns the directory holding all files when the layout isn't
+// split: $CADDY_ATC_HOME, or ~/.caddy-atc until it has been migrated.
+func singleDir() (string, bool) {
+	if dir := os.Getenv(HomeEnv); dir != "" {
+		return filepath.Clean(dir), true
+	}
+	if LegacyHomeInUse() {
+		return LegacyHomeDir(), true
+	}
+	return "", false
+}
+
+// LegacyHomeInUse reports whether ~/.caddy-atc exists and hasn't been
+// migrated to the XDG directories.
+func LegacyHomeInUse() bool {
+	if os.Getenv(HomeEnv) != "" {
+		return false
+	}
+	if _, err := os.Stat(LegacyHomeDir()); err != nil {
+		return false
+	}
+	_, err := os.Stat(xdgConfigDir())
+	return os.IsNotExist(err)
+}
+
+// ConfigDir returns the directory of the files users edit: projects.yml
+// and config.yml.
+func ConfigDir() string {
+	if dir, ok := singleDir(); ok {
+		return dir
+	}
+	return xdgConfigDir()
+}
+
+// StateDir returns the directory of files caddy-atc generates and keeps
+// across restarts: the Caddyfile, logs and recorded state.
+func StateDir() string {
+	if dir, ok := singleDir(); ok {
+		return dir
+	}
+	return xdgStateDir()
+}
+
+// RuntimeDir returns the directory of files that only matter while
+// caddy-atc runs: the PID file, locks and the pause marker.
+func RuntimeDir() string {
+	if dir, ok := singleDir(); ok {
+		return dir
+	}
+	return xdgRuntimeDir()
+}
+
+// legacyRuntimeFiles are the files in ~/.caddy-atc that belong in the
+// runtime directory. The PID file and locks are stale once no watcher
+// runs, so they are dropped rather than moved.
+var (
+	legacyRuntimeFiles = map[string]bool{"paused": true}
+	legacyStaleFiles   = map[string]bool{"watcher.pid": true, "watcher.pid.lock": true, "projects.lock": true}
+	legacyConfigFiles  = map[string]bool{"projects.yml": true, "config.yml": true, "templates": true}
+)
+
+// MigrateLegacyHome moves ~/.caddy-atc into the XDG directories. The
+// config files go last, as the config directory existing marks the
+// migration done. Callers must make sure no watcher is running.
+func MigrateLegacyHome() error {
+	legacy := LegacyHomeDir()
+	entries, err := os.ReadDir(legacy)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", legacy, err)
+	}
+
+	var configEntries []string
+	for _, e := range entries {
+		name := e.Name()
+		src := filepath.Join(legacy, name)
+		switch {
+		case legacyConfigFiles[name]:
+			configEntries = append(configEntries, name)
+		case legacyStaleFiles[name]:
+			if err := os.Remove(src); err != nil {
+				return fmt.Errorf("removing %s: %w", src, err)
+			}
+		case legacyRuntimeFiles[name]:
+			if err := moveEntry(src, filepath.Join(xdgRuntimeDir(), name)); err != nil {
+				return err
+			}
+		default:
+			if err := moveEntry(src, filepath.Join(xdgStateDir(), name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(xdgConfigDir(), 0700); err != nil {
+		return fmt.Errorf("creating %s: %w", xdgConfigDir(), err)
+	}
+	for _, name := range configEntries {
+		if err := moveEntry(filepath.Join(legacy, name), filepath.Join(xdgConfigDir(), name)); err != nil {
+			return err
+		}
+	}
+	// Anything left behind, such as files created meanwhile, keeps the
+	// directory around rather than being lost.
+	os.Remove(legacy)
+	return nil
+}
+
+// moveEntry moves a file or directory, copying it when src and dst are on
+// different file systems.
+func moveEntry(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(dst), err)
+	}
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("moving %s: %w", src, err)
+	}
+	if err := copyTree(src, dst); err != nil {
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies regular files and directories under src to dst, keeping
+// their permissions.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
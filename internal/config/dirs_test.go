@@ -0,0 +1,104 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// XDG defaults.
+	if got, want := ConfigDir(), filepath.Join(home, ".config", "caddy-atc"); got != want {
+		t.Errorf("ConfigDir() = %q, want %q", got, want)
+	}
+	if got, want := StateDir(), filepath.Join(home, ".local", "state", "caddy-atc"); got != want {
+		t.Errorf("StateDir() = %q, want %q", got, want)
+	}
+	if got := RuntimeDir(); got != StateDir() {
+		t.Errorf("RuntimeDir() without XDG_RUNTIME_DIR = %q, want the state directory", got)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "cfg"))
+	t.Setenv("XDG_STATE_HOME", "relative")
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(home, "run"))
+	if got, want := ProjectsPath(), filepath.Join(home, "cfg", "caddy-atc", "projects.yml"); got != want {
+		t.Errorf("ProjectsPath() = %q, want %q", got, want)
+	}
+	if got, want := LogPath(), filepath.Join(home, ".local", "state", "caddy-atc", "watcher.log"); got != want {
+		t.Errorf("LogPath() with relative XDG_STATE_HOME = %q, want %q", got, want)
+	}
+	if got, want := PidPath(), filepath.Join(home, "run", "caddy-atc", "watcher.pid"); got != want {
+		t.Errorf("PidPath() = %q, want %q", got, want)
+	}
+
+	// CADDY_ATC_HOME puts everything in one directory.
+	t.Setenv(HomeEnv, filepath.Join(home, "atc"))
+	for _, path := range []string{ProjectsPath(), LogPath(), PidPath(), SettingsPath()} {
+		if filepath.Dir(path) != filepath.Join(home, "atc") {
+			t.Errorf("%s is outside CADDY_ATC_HOME", path)
+		}
+	}
+}
+
+func TestMigrateLegacyHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	legacy := filepath.Join(home, ".caddy-atc")
+	files := map[string]string{
+		"projects.yml":        "projects: {}\n",
+		"config.yml":          "https_port: 8443\n",
+		"watcher.log":         "log\n",
+		"watcher.pid":         "123\n",
+		"paused":              "reason\n",
+		"caddyfile/Caddyfile": "{}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(legacy, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !LegacyHomeInUse() {
+		t.Fatal("LegacyHomeInUse() = false with ~/.caddy-atc present")
+	}
+	if ProjectsPath() != filepath.Join(legacy, "projects.yml") {
+		t.Errorf("ProjectsPath() = %q before migration, want the legacy path", ProjectsPath())
+	}
+
+	if err := MigrateLegacyHome(); err != nil {
+		t.Fatalf("MigrateLegacyHome() error = %v", err)
+	}
+	if LegacyHomeInUse() {
+		t.Error("LegacyHomeInUse() = true after migration")
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy directory still exists: %v", err)
+	}
+	for path, want := range map[string]string{
+		ProjectsPath():  files["projects.yml"],
+		SettingsPath():  files["config.yml"],
+		LogPath():       files["watcher.log"],
+		PausePath():     files["paused"],
+		CaddyfilePath(): files["caddyfile/Caddyfile"],
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("reading %s: %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(PidPath()); !os.IsNotExist(err) {
+		t.Errorf("stale PID file was moved: %v", err)
+	}
+}
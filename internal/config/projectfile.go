@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFileName is the routing config a project can check in at its root,
+// so a team shares hostnames, ports and options through git.
+const ProjectFileName = ".caddy-atc.yml"
+
+// ProjectFile is the contents of a project's ProjectFileName.
+type ProjectFile struct {
+	Hostname string                         `yaml:"hostname,omitempty"` // base hostname, used by adopt
+	Services map[string]*ProjectFileService `yaml:"services,omitempty"` // keyed by compose service name
+
+	// Snippet holds Caddyfile directives embedded in the site block of each
+	// of the project's hostnames; if empty, SnippetFileName is read.
+	Snippet string `yaml:"snippet,omitempty"`
+}
+
+// ProjectFileService declares routing for one compose service. Hostname,
+// Port, Protocol and Ignore act as defaults for the matching caddy-atc.*
+// labels; options are merged under those in projects.yml.
+type ProjectFileService struct {
+	Hostname string `yaml:"hostname,omitempty"`
+	Port     string `yaml:"port,omitempty"`
+	Protocol string `yaml:"protocol,omitempty"`
+	Ignore   bool   `yaml:"ignore,omitempty"`
+
+	ServiceOptions `yaml:",inline"`
+}
+
+// LoadProjectFile reads and validates dir's ProjectFileName, and its
+// SnippetFileName unless the project file sets a snippet. It returns nil
+// without error if the project has neither.
+func LoadProjectFile(dir string) (*ProjectFile, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	pf, err := loadProjectFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	if pf != nil && pf.Snippet != "" {
+		return pf, nil
+	}
+	snippet, err := LoadSnippet(dir)
+	if err != nil || snippet == "" {
+		return pf, err
+	}
+	if pf == nil {
+		pf = &ProjectFile{}
+	}
+	pf.Snippet = snippet
+	return pf, nil
+}
+
+func loadProjectFile(dir string) (*ProjectFile, error) {
+	path := filepath.Join(dir, ProjectFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", ProjectFileName, err)
+	}
+	if info.Size() > maxConfigFileSize {
+		return nil, fmt.Errorf("%s too large (%d bytes, max %d)", ProjectFileName, info.Size(), maxConfigFileSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", ProjectFileName, err)
+	}
+	var pf ProjectFile
+	if err := yaml.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", ProjectFileName, err)
+	}
+	if err := pf.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ProjectFileName, err)
+	}
+	return &pf, nil
+}
+
+// Validate checks every declared value. The file comes from the project's
+// repository, so certificate paths must stay inside the project directory.
+func (pf *ProjectFile) Validate() error {
+	if pf.Hostname != "" {
+		if err := ValidateHostname(pf.Hostname); err != nil {
+			return fmt.Errorf("hostname: %w", err)
+		}
+	}
+	if err := ValidateSnippet(pf.Snippet); err != nil {
+		return err
+	}
+	for name, s := range pf.Services {
+		if s == nil {
+			continue
+		}
+		if s.Hostname != "" {
+			if err := ValidateHostname(s.Hostname); err != nil {
+				return fmt.Errorf("service %q: hostname: %w", name, err)
+			}
+		}
+		if s.Port != "" {
+			if err := ValidatePort(s.Port); err != nil {
+				return fmt.Errorf("service %q: port: %w", name, err)
+			}
+		}
+		if s.Protocol != "" {
+			if _, err := ContainerProtocol(map[string]string{ProtocolLabel: s.Protocol}); err != nil {
+				return fmt.Errorf("service %q: %w", name, err)
+			}
+		}
+		if err := s.ServiceOptions.Validate(); err != nil {
+			return fmt.Errorf("service %q: %w", name, err)
+		}
+		for _, p := range []string{s.TLSClientCert, s.TLSClientKey, s.TLSTrustedCA} {
+			if p != "" && !filepath.IsLocal(p) {
+				return fmt.Errorf("service %q: certificate path %q must be relative to the project and stay inside it", name, p)
+			}
+		}
+	}
+	return nil
+}
+
+// Labels returns labels with the file's declarations for service filled in
+// where the container doesn't set the corresponding caddy-atc.* label
+// itself. labels is not modified. A nil ProjectFile returns labels as is.
+func (pf *ProjectFile) Labels(service string, labels map[string]string) map[string]string {
+	s := pf.service(service)
+	if s == nil {
+		return labels
+	}
+	merged := maps.Clone(labels)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	setDefault := func(key, value string) {
+		if value != "" && merged[key] == "" {
+			merged[key] = value
+		}
+	}
+	setDefault(HostnameLabel, s.Hostname)
+	setDefault(PortLabel, s.Port)
+	setDefault(ProtocolLabel, s.Protocol)
+	if s.Ignore {
+		setDefault(IgnoreLabel, "true")
+	}
+	return merged
+}
+
+// SiteSnippet returns the project's snippet. A nil ProjectFile has none.
+func (pf *ProjectFile) SiteSnippet() string {
+	if pf == nil {
+		return ""
+	}
+	return pf.Snippet
+}
+
+func (pf *ProjectFile) service(name string) *ProjectFileService {
+	if pf == nil {
+		return nil
+	}
+	return pf.Services[name]
+}
+
+// EffectiveServiceOptions returns the options for a service: those declared
+// in the project file, overridden field by field by any set in projects.yml.
+func (p *ProjectConfig) EffectiveServiceOptions(serviceName string, pf *ProjectFile) ServiceOptions {
+	local := p.ServiceOptions(serviceName)
+	s := pf.service(serviceName)
+	if s == nil {
+		return local
+	}
+	shared := resolveOptionPaths(s.ServiceOptions, p.Dir)
+	return mergeOptions(shared, local)
+}
+
+// resolveOptionPaths makes relative certificate paths absolute against dir.
+func resolveOptionPaths(o ServiceOptions, dir string) ServiceOptions {
+	for _, f := range []*string{&o.TLSClientCert, &o.TLSClientKey, &o.TLSTrustedCA} {
+		if *f != "" && !filepath.IsAbs(*f) {
+			*f = filepath.Join(dir, *f)
+		}
+	}
+	return o
+}
+
+// mergeOptions returns base with every non-zero field of override applied.
+func mergeOptions(base, override ServiceOptions) ServiceOptions {
+	b := reflect.ValueOf(&base).Elem()
+	o := reflect.ValueOf(override)
+	for i := range o.NumField() {
+		if !o.Field(i).IsZero() {
+			b.Field(i).Set(o.Field(i))
+		}
+	}
+	// Client certificates come as a pair; don't mix a shared cert with a
+	// local key.
+	if override.TLSClientCert != "" || override.TLSClientKey != "" {
+		base.TLSClientCert, base.TLSClientKey = override.TLSClientCert, override.TLSClientKey
+	}
+	return base
+}
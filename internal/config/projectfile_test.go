@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProjectFile(t *testing.T) {
+	dir := t.TempDir()
+
+	pf, err := LoadProjectFile(dir)
+	if err != nil || pf != nil {
+		t.Fatalf("LoadProjectFile() without a file = %v, %v, want nil, nil", pf, err)
+	}
+
+	content := `hostname: myapp.localhost
+services:
+  api:
+    hostname: api.myapp.localhost
+    port: "8080"
+    protocol: grpc
+    lb_try_duration: 30s
+    tls_trusted_ca: certs/ca.pem
+  worker:
+    ignore: true
+`
+	if err := os.WriteFile(filepath.Join(dir, ProjectFileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pf, err = LoadProjectFile(dir)
+	if err != nil {
+		t.Fatalf("LoadProjectFile() error = %v", err)
+	}
+	if pf.Hostname != "myapp.localhost" {
+		t.Errorf("Hostname = %q", pf.Hostname)
+	}
+	api := pf.Services["api"]
+	if api == nil || api.Port != "8080" || api.Protocol != ProtocolGRPC || api.LBTryDuration != "30s" {
+		t.Errorf("api = %+v", api)
+	}
+	if w := pf.Services["worker"]; w == nil || !w.Ignore {
+		t.Errorf("worker = %+v, want ignored", w)
+	}
+}
+
+func TestProjectFileValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		pf   ProjectFile
+	}{
+		{"bad hostname", ProjectFile{Hostname: "bad host"}},
+		{"bad service hostname", ProjectFile{Services: map[string]*ProjectFileService{"web": {Hostname: "a;b"}}}},
+		{"bad port", ProjectFile{Services: map[string]*ProjectFileService{"web": {Port: "http"}}}},
+		{"bad protocol", ProjectFile{Services: map[string]*ProjectFileService{"web": {Protocol: "ftp"}}}},
+		{"bad option", ProjectFile{Services: map[string]*ProjectFileService{"web": {ServiceOptions: ServiceOptions{LBTryDuration: "soon"}}}}},
+		{"escaping cert path", ProjectFile{Services: map[string]*ProjectFileService{"web": {ServiceOptions: ServiceOptions{TLSTrustedCA: "../ca.pem"}}}}},
+		{"absolute cert path", ProjectFile{Services: map[string]*ProjectFileService{"web": {ServiceOptions: ServiceOptions{TLSTrustedCA: "/etc/ssl/ca.pem"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.pf.Validate(); err == nil {
+				t.Error("Validate() = nil, want error")
+			}
+		})
+	}
+
+	ok := ProjectFile{Services: map[string]*ProjectFileService{"web": {Port: "3000", ServiceOptions: ServiceOptions{TLSTrustedCA: "certs/ca.pem"}}}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestProjectFileLabels(t *testing.T) {
+	pf := &ProjectFile{Services: map[string]*ProjectFileService{
+		"web": {Hostname: "app.localhost", Port: "3000", Ignore: true},
+	}}
+	labels := map[string]string{PortLabel: "8080"}
+
+	got := pf.Labels("web", labels)
+	if got[PortLabel] != "8080" {
+		t.Errorf("port label = %q, container label should win", got[PortLabel])
+	}
+	if got[HostnameLabel] != "app.localhost" || got[IgnoreLabel] != "true" {
+		t.Errorf("Labels() = %v, want file defaults filled in", got)
+	}
+	if len(labels) != 1 {
+		t.Errorf("Labels() modified its input: %v", labels)
+	}
+
+	if got := pf.Labels("api", labels); got[HostnameLabel] != "" {
+		t.Errorf("undeclared service got %v", got)
+	}
+	var none *ProjectFile
+	if got := none.Labels("web", nil); got != nil {
+		t.Errorf("nil ProjectFile Labels() = %v", got)
+	}
+}
+
+func TestEffectiveServiceOptions(t *testing.T) {
+	pf := &ProjectFile{Services: map[string]*ProjectFileService{
+		"web": {ServiceOptions: ServiceOptions{
+			LBTryDuration: "30s",
+			DialTimeout:   "5s",
+			TLSClientCert: "certs/client.pem",
+			TLSClientKey:  "certs/client-key.pem",
+		}},
+	}}
+	proj := &ProjectConfig{
+		Dir: "/src/myapp",
+		Options: map[string]*ServiceOptions{
+			"web": {DialTimeout: "10s", TLSClientCert: "/home/me/cert.pem", TLSClientKey: "/home/me/key.pem"},
+		},
+	}
+
+	got := proj.EffectiveServiceOptions("web", pf)
+	if got.LBTryDuration != "30s" {
+		t.Errorf("LBTryDuration = %q, want shared value", got.LBTryDuration)
+	}
+	if got.DialTimeout != "10s" {
+		t.Errorf("DialTimeout = %q, want local override", got.DialTimeout)
+	}
+	if got.TLSClientCert != "/home/me/cert.pem" || got.TLSClientKey != "/home/me/key.pem" {
+		t.Errorf("client cert = %q/%q, want local pair", got.TLSClientCert, got.TLSClientKey)
+	}
+
+	got = (&ProjectConfig{Dir: "/src/myapp"}).EffectiveServiceOptions("web", pf)
+	if got.TLSClientCert != "/src/myapp/certs/client.pem" {
+		t.Errorf("TLSClientCert = %q, want resolved against project dir", got.TLSClientCert)
+	}
+}
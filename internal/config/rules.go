@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RedirectFromLabel is the compose service label listing hostnames that
+// redirect to the container's hostname. It overrides
+// ServiceOptions.RedirectFrom.
+const RedirectFromLabel = "caddy-atc.redirect-from"
+
+// RewriteLabel is the compose service label listing path rewrites for the
+// container's route. It overrides ServiceOptions.Rewrite.
+const RewriteLabel = "caddy-atc.rewrite"
+
+// RewriteRule rewrites requests for From to To. With Prefix, From and To
+// are path prefixes ending in "/" and the rest of the path is kept.
+type RewriteRule struct {
+	From, To string
+	Prefix   bool
+}
+
+// ParseRedirectFrom splits a comma-separated list of hostnames, as in
+// ServiceOptions.RedirectFrom.
+func ParseRedirectFrom(s string) ([]string, error) {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if err := ValidateHostname(h); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// ParseRewrites splits a comma-separated list of "from -> to" path
+// rewrites, as in ServiceOptions.Rewrite. Paths ending in "/*" rewrite a
+// prefix; either both or neither side of a rule must.
+func ParseRewrites(s string) ([]RewriteRule, error) {
+	var rules []RewriteRule
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(r, "->")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule %q: want \"/from -> /to\"", r)
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		fromPrefix, toPrefix := strings.HasSuffix(from, "/*"), strings.HasSuffix(to, "/*")
+		if fromPrefix != toPrefix {
+			return nil, fmt.Errorf("invalid rule %q: both paths or neither must end in /*", r)
+		}
+		from, to = strings.TrimSuffix(from, "*"), strings.TrimSuffix(to, "*")
+		for _, p := range []string{from, to} {
+			if !validURLPath.MatchString(p) {
+				return nil, fmt.Errorf("invalid path %q in %q: must match /[a-zA-Z0-9._~/-]*", p, r)
+			}
+		}
+		rules = append(rules, RewriteRule{From: from, To: to, Prefix: fromPrefix})
+	}
+	return rules, nil
+}
+
+// ContainerRedirectFrom returns the hostnames declared by labels, or ""
+// when unset. An invalid value is reported as an error alongside "".
+func ContainerRedirectFrom(labels map[string]string) (string, error) {
+	s := labels[RedirectFromLabel]
+	if _, err := ParseRedirectFrom(s); err != nil {
+		return "", fmt.Errorf("%s label: %w", RedirectFromLabel, err)
+	}
+	return s, nil
+}
+
+// ContainerRewrite returns the rewrites declared by labels, or "" when
+// unset. An invalid value is reported as an error alongside "".
+func ContainerRewrite(labels map[string]string) (string, error) {
+	s := labels[RewriteLabel]
+	if _, err := ParseRewrites(s); err != nil {
+		return "", fmt.Errorf("%s label: %w", RewriteLabel, err)
+	}
+	return s, nil
+}
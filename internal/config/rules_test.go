@@ -0,0 +1,54 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRedirectFrom(t *testing.T) {
+	got, err := ParseRedirectFrom(" WWW.myapp.localhost, old.myapp.localhost,")
+	if err != nil {
+		t.Fatalf("ParseRedirectFrom() error = %v", err)
+	}
+	want := []string{"www.myapp.localhost", "old.myapp.localhost"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRedirectFrom() = %v, want %v", got, want)
+	}
+	if _, err := ParseRedirectFrom("bad host {"); err == nil {
+		t.Error("ParseRedirectFrom() accepted an invalid hostname")
+	}
+}
+
+func TestParseRewrites(t *testing.T) {
+	got, err := ParseRewrites("/old -> /new, /api/* -> /v1/*")
+	if err != nil {
+		t.Fatalf("ParseRewrites() error = %v", err)
+	}
+	want := []RewriteRule{
+		{From: "/old", To: "/new"},
+		{From: "/api/", To: "/v1/", Prefix: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRewrites() = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{
+		"/old /new",
+		"/api/* -> /v1",
+		"/a -> /b {",
+		"old -> /new",
+	} {
+		if _, err := ParseRewrites(bad); err == nil {
+			t.Errorf("ParseRewrites(%q) accepted an invalid rule", bad)
+		}
+	}
+}
+
+func TestContainerRewrite(t *testing.T) {
+	if got, err := ContainerRewrite(map[string]string{RewriteLabel: "/a -> /b"}); err != nil || got != "/a -> /b" {
+		t.Errorf("ContainerRewrite() = %q, %v, want the label value", got, err)
+	}
+	if got, err := ContainerRewrite(map[string]string{RewriteLabel: "/a"}); err == nil || got != "" {
+		t.Errorf("ContainerRewrite() of an invalid label = %q, %v, want an error", got, err)
+	}
+}
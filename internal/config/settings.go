@@ -0,0 +1,429 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SettingsPath returns the path to the global settings file.
+func SettingsPath() string {
+	return filepath.Join(ConfigDir(), "config.yml")
+}
+
+// Settings are the machine-wide gateway options in config.yml, kept apart
+// from the adopted projects in projects.yml. Unset fields keep their
+// defaults; gateway.image, gateway.log_level and domain in projects.yml
+// are still honored when the matching setting is unset.
+type Settings struct {
+	// Image is the gateway's Caddy image, e.g. "caddy:2.10-alpine".
+	Image string `yaml:"image,omitempty"`
+
+	// HTTPPort and HTTPSPort are the ports the gateway listens on and
+	// publishes. Default to DefaultHTTPPort and DefaultHTTPSPort.
+	HTTPPort  int `yaml:"http_port,omitempty"`
+	HTTPSPort int `yaml:"https_port,omitempty"`
+
+	// Network is the Docker network shared by the gateway and routed
+	// containers. Defaults to DefaultNetwork.
+	Network string `yaml:"network,omitempty"`
+
+	// LogLevel is the gateway's Caddy log level.
+	LogLevel string `yaml:"log_level,omitempty"`
+
+	// Domain is the suffix of default hostnames, e.g. ".test".
+	Domain string `yaml:"domain,omitempty"`
+
+	// ReloadDebounce is how long the watcher waits for more container
+	// changes before reloading Caddy. Defaults to DefaultReloadDebounce;
+	// "0s" reloads at once.
+	ReloadDebounce string `yaml:"reload_debounce,omitempty"`
+
+	// ReconcileInterval is how often the watcher compares its routes with
+	// the running containers to repair missed events. Defaults to
+	// DefaultReconcileInterval; "0s" turns it off.
+	ReconcileInterval string `yaml:"reconcile_interval,omitempty"`
+
+	// AdminAddress is where Caddy's admin API listens inside the gateway
+	// container. Defaults to DefaultAdminAddress.
+	AdminAddress string `yaml:"admin_address,omitempty"`
+
+	// ReloadMode is how the watcher applies route changes: ReloadModeAdmin
+	// patches changed routes through the admin API, ReloadModeCaddyfile
+	// reloads the whole Caddyfile. Defaults to ReloadModeAdmin.
+	ReloadMode string `yaml:"reload_mode,omitempty"`
+}
+
+// Setting defaults.
+const (
+	DefaultHTTPPort     = 80
+	DefaultHTTPSPort    = 443
+	DefaultNetwork      = "caddy-atc"
+	DefaultAdminAddress = "localhost:2019"
+	DefaultImage        = "caddy:2-alpine"
+	DefaultReloadMode   = ReloadModeAdmin
+
+	DefaultReloadDebounce    = 500 * time.Millisecond
+	DefaultReconcileInterval = time.Minute
+)
+
+// Reload modes.
+const (
+	ReloadModeAdmin     = "admin"
+	ReloadModeCaddyfile = "caddyfile"
+)
+
+// Ports `up` falls back to when the default ports are held by another
+// program.
+const (
+	FallbackHTTPPort  = 8880
+	FallbackHTTPSPort = 8443
+)
+
+// SettingKeys are the config.yml keys `caddy-atc config` accepts, in file
+// order.
+var SettingKeys = []string{"image", "http_port", "https_port", "network", "log_level", "domain", "reload_debounce", "reconcile_interval", "admin_address", "reload_mode"}
+
+// loadSettings reads config.yml into c.Settings.
+func (c *Config) loadSettings() error {
+	s, err := LoadSettings()
+	if err != nil {
+		return err
+	}
+	c.Settings = *s
+	return nil
+}
+
+// LoadSettings reads config.yml. A missing file means all defaults.
+func LoadSettings() (*Settings, error) {
+	path := SettingsPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Settings{}, nil
+		}
+		return nil, fmt.Errorf("reading settings: %w", err)
+	}
+	if info.Size() > maxConfigFileSize {
+		return nil, fmt.Errorf("settings file too large (%d bytes, max %d)", info.Size(), maxConfigFileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading settings: %w", err)
+	}
+	var s Settings
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes config.yml atomically.
+func (s *Settings) Save() error {
+	if err := EnsureHomeDir(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshaling settings: %w", err)
+	}
+	return atomicWriteFile(SettingsPath(), data, 0600)
+}
+
+// ModifySettings loads config.yml under the config lock, calls fn, and
+// saves the result.
+func ModifySettings(fn func(*Settings) error) error {
+	unlock, err := lockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s, err := LoadSettings()
+	if err != nil {
+		return err
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+	return s.Save()
+}
+
+// Get returns the value of a setting as written in config.yml, or "" if
+// it is unset.
+func (s *Settings) Get(key string) (string, error) {
+	switch key {
+	case "image":
+		return s.Image, nil
+	case "http_port":
+		return portString(s.HTTPPort), nil
+	case "https_port":
+		return portString(s.HTTPSPort), nil
+	case "network":
+		return s.Network, nil
+	case "log_level":
+		return s.LogLevel, nil
+	case "domain":
+		return s.Domain, nil
+	case "reload_debounce":
+		return s.ReloadDebounce, nil
+	case "reconcile_interval":
+		return s.ReconcileInterval, nil
+	case "admin_address":
+		return s.AdminAddress, nil
+	case "reload_mode":
+		return s.ReloadMode, nil
+	}
+	return "", unknownSetting(key)
+}
+
+// Set validates and changes a setting; an empty value unsets it.
+func (s *Settings) Set(key, value string) error {
+	if value != "" {
+		if err := validateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	switch key {
+	case "image":
+		s.Image = value
+	case "http_port", "https_port":
+		port := 0
+		if value != "" {
+			port, _ = strconv.Atoi(value)
+		}
+		if key == "http_port" {
+			s.HTTPPort = port
+		} else {
+			s.HTTPSPort = port
+		}
+	case "network":
+		s.Network = value
+	case "log_level":
+		s.LogLevel = value
+	case "domain":
+		s.Domain = value
+	case "reload_debounce":
+		s.ReloadDebounce = value
+	case "reconcile_interval":
+		s.ReconcileInterval = value
+	case "admin_address":
+		s.AdminAddress = value
+	case "reload_mode":
+		s.ReloadMode = value
+	default:
+		return unknownSetting(key)
+	}
+	return nil
+}
+
+// Validate checks every setting in the file.
+func (s *Settings) Validate() error {
+	for _, key := range SettingKeys {
+		value, _ := s.Get(key)
+		if value == "" {
+			continue
+		}
+		if err := validateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	if http, https := (&Config{Settings: *s}).HTTPPorts(); http == https {
+		return fmt.Errorf("http_port and https_port must differ, both are %d", http)
+	}
+	return nil
+}
+
+// validNetwork matches Docker network names.
+var validNetwork = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,62}$`)
+
+func validateSetting(key, value string) error {
+	var err error
+	switch key {
+	case "image":
+		err = ValidateImage(value)
+	case "http_port", "https_port":
+		if port, convErr := strconv.Atoi(value); convErr != nil || port < 1 || port > 65535 {
+			err = fmt.Errorf("invalid port %q", value)
+		}
+	case "network":
+		if !validNetwork.MatchString(value) {
+			err = fmt.Errorf("invalid network name %q", value)
+		}
+	case "log_level":
+		err = ValidateLogLevel(value)
+	case "domain":
+		_, err = (&Config{Domain: value}).DomainSuffix()
+		return err
+	case "reload_debounce":
+		if d, parseErr := time.ParseDuration(value); parseErr != nil || d < 0 {
+			err = fmt.Errorf("invalid duration %q", value)
+		}
+	case "reconcile_interval":
+		if d, parseErr := time.ParseDuration(value); parseErr != nil || d < 0 {
+			err = fmt.Errorf("invalid duration %q", value)
+		} else if d > 0 && d < time.Second {
+			err = fmt.Errorf("%s is too short: use at least 1s, or 0s to turn it off", value)
+		}
+	case "admin_address":
+		err = ValidateAdminAddress(value)
+	case "reload_mode":
+		if value != ReloadModeAdmin && value != ReloadModeCaddyfile {
+			err = fmt.Errorf("invalid mode %q: use %s or %s", value, ReloadModeAdmin, ReloadModeCaddyfile)
+		}
+	default:
+		return unknownSetting(key)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// ValidateAdminAddress checks a Caddy admin API address, "host:port". The
+// watcher reloads Caddy through it, so it can't be turned off.
+func ValidateAdminAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: expected host:port", addr)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+	if host != "" && host != "localhost" && net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid host in %q: use localhost or an IP address", addr)
+	}
+	return nil
+}
+
+func unknownSetting(key string) error {
+	return fmt.Errorf("unknown setting %q (one of %s)", key, strings.Join(SettingKeys, ", "))
+}
+
+func portString(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+// DefaultSetting returns the value a setting has when unset.
+func DefaultSetting(key string) string {
+	switch key {
+	case "image":
+		return DefaultImage
+	case "http_port":
+		return strconv.Itoa(DefaultHTTPPort)
+	case "https_port":
+		return strconv.Itoa(DefaultHTTPSPort)
+	case "network":
+		return DefaultNetwork
+	case "log_level":
+		return "info"
+	case "domain":
+		return DefaultDomain
+	case "reload_debounce":
+		return DefaultReloadDebounce.String()
+	case "reconcile_interval":
+		return DefaultReconcileInterval.String()
+	case "admin_address":
+		return DefaultAdminAddress
+	case "reload_mode":
+		return DefaultReloadMode
+	}
+	return ""
+}
+
+// HTTPPorts returns the gateway's HTTP and HTTPS ports.
+func (c *Config) HTTPPorts() (int, int) {
+	http, https := DefaultHTTPPort, DefaultHTTPSPort
+	if c.Settings.HTTPPort != 0 {
+		http = c.Settings.HTTPPort
+	}
+	if c.Settings.HTTPSPort != 0 {
+		https = c.Settings.HTTPSPort
+	}
+	return http, https
+}
+
+// NetworkName returns the Docker network of the gateway.
+func (c *Config) NetworkName() string {
+	if c.Settings.Network != "" {
+		return c.Settings.Network
+	}
+	return DefaultNetwork
+}
+
+// AdminAddress returns the admin API address to configure in the gateway,
+// or "" for Caddy's default.
+func (c *Config) AdminAddress() string {
+	if c.Settings.AdminAddress == DefaultAdminAddress {
+		return ""
+	}
+	return c.Settings.AdminAddress
+}
+
+// ReloadMode returns how the watcher applies route changes to the gateway.
+// An invalid value is reported alongside the default.
+func (c *Config) ReloadMode() (string, error) {
+	if c.Settings.ReloadMode == "" {
+		return DefaultReloadMode, nil
+	}
+	if err := validateSetting("reload_mode", c.Settings.ReloadMode); err != nil {
+		return DefaultReloadMode, err
+	}
+	return c.Settings.ReloadMode, nil
+}
+
+// ReloadDebounce returns how long the watcher batches container changes
+// before reloading Caddy. An invalid value is reported alongside the
+// default.
+func (c *Config) ReloadDebounce() (time.Duration, error) {
+	if c.Settings.ReloadDebounce == "" {
+		return DefaultReloadDebounce, nil
+	}
+	if err := validateSetting("reload_debounce", c.Settings.ReloadDebounce); err != nil {
+		return DefaultReloadDebounce, err
+	}
+	d, _ := time.ParseDuration(c.Settings.ReloadDebounce)
+	return d, nil
+}
+
+// ReconcileInterval returns how often the watcher checks its routes
+// against the running containers; 0 turns the check off. An invalid value
+// is reported alongside the default.
+func (c *Config) ReconcileInterval() (time.Duration, error) {
+	if c.Settings.ReconcileInterval == "" {
+		return DefaultReconcileInterval, nil
+	}
+	if err := validateSetting("reconcile_interval", c.Settings.ReconcileInterval); err != nil {
+		return DefaultReconcileInterval, err
+	}
+	d, _ := time.ParseDuration(c.Settings.ReconcileInterval)
+	return d, nil
+}
+
+// SiteURL returns the HTTPS URL of hostname on a gateway listening on
+// httpsPort.
+func SiteURL(hostname string, httpsPort int) string {
+	if httpsPort == DefaultHTTPSPort || httpsPort == 0 {
+		return "https://" + hostname
+	}
+	return "https://" + net.JoinHostPort(hostname, strconv.Itoa(httpsPort))
+}
+
+// HTTPSiteURL returns the plain HTTP URL of hostname on a gateway
+// listening on httpPort.
+func HTTPSiteURL(hostname string, httpPort int) string {
+	if httpPort == DefaultHTTPPort || httpPort == 0 {
+		return "http://" + hostname
+	}
+	return "http://" + net.JoinHostPort(hostname, strconv.Itoa(httpPort))
+}
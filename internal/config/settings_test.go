@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSettingsSetGet(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	err := ModifySettings(func(s *Settings) error {
+		for key, value := range map[string]string{
+			"image":           "caddy:2.10-alpine",
+			"https_port":      "8443",
+			"network":         "dev-gateway",
+			"log_level":       "debug",
+			"domain":          ".test",
+			"reload_debounce": "2s",
+			"admin_address":   "localhost:2020",
+			"reload_mode":     "caddyfile",
+		} {
+			if err := s.Set(key, value); err != nil {
+				return err
+			}
+		}
+		return s.Validate()
+	})
+	if err != nil {
+		t.Fatalf("ModifySettings() error = %v", err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GatewayImage(); got != "caddy:2.10-alpine" {
+		t.Errorf("GatewayImage() = %q", got)
+	}
+	if http, https := cfg.HTTPPorts(); http != 80 || https != 8443 {
+		t.Errorf("HTTPPorts() = %d, %d; want 80, 8443", http, https)
+	}
+	if got := cfg.NetworkName(); got != "dev-gateway" {
+		t.Errorf("NetworkName() = %q", got)
+	}
+	if got := cfg.GatewayLogLevel(); got != "debug" {
+		t.Errorf("GatewayLogLevel() = %q", got)
+	}
+	if got, _ := cfg.DomainSuffix(); got != ".test" {
+		t.Errorf("DomainSuffix() = %q", got)
+	}
+	if got, _ := cfg.ReloadDebounce(); got != 2*time.Second {
+		t.Errorf("ReloadDebounce() = %v", got)
+	}
+	if got := cfg.AdminAddress(); got != "localhost:2020" {
+		t.Errorf("AdminAddress() = %q", got)
+	}
+	if got, _ := cfg.ReloadMode(); got != ReloadModeCaddyfile {
+		t.Errorf("ReloadMode() = %q", got)
+	}
+
+	// Settings are not written into projects.yml.
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(ProjectsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "projects: {}\n" {
+		t.Errorf("projects.yml = %q", data)
+	}
+
+	s, err := LoadSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("https_port", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get("https_port"); got != "" {
+		t.Errorf("after unset, https_port = %q", got)
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	cfg := &Config{
+		Gateway: &GatewayConfig{Image: "caddy:2.9-alpine", LogLevel: "debug"},
+		Domain:  ".legacy",
+	}
+	if got := cfg.GatewayImage(); got != "caddy:2.9-alpine" {
+		t.Errorf("GatewayImage() without settings = %q", got)
+	}
+
+	cfg.Settings = Settings{Image: "caddy:2.10-alpine", LogLevel: "info", Domain: "test"}
+	if got := cfg.GatewayImage(); got != "caddy:2.10-alpine" {
+		t.Errorf("GatewayImage() = %q, want config.yml's", got)
+	}
+	if got := cfg.GatewayLogLevel(); got != "" {
+		t.Errorf("GatewayLogLevel() = %q, want config.yml's info to override debug", got)
+	}
+	if got, _ := cfg.DomainSuffix(); got != ".test" {
+		t.Errorf("DomainSuffix() = %q", got)
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	s := &Settings{}
+	for key, value := range map[string]string{
+		"http_port":       "0",
+		"https_port":      "http",
+		"network":         "bad network",
+		"log_level":       "verbose",
+		"domain":          "*.test",
+		"reload_debounce": "-1s",
+		"admin_address":   "off",
+		"reload_mode":     "api",
+		"image":           "caddy:2 --privileged",
+		"color":           "blue",
+	} {
+		if err := s.Set(key, value); err == nil {
+			t.Errorf("Set(%q, %q) accepted an invalid value", key, value)
+		}
+	}
+
+	if err := (&Settings{HTTPPort: 443}).Validate(); err == nil {
+		t.Error("Validate() accepted http_port equal to the default https_port")
+	}
+}
+
+func TestSiteURL(t *testing.T) {
+	if got := SiteURL("app.localhost", 443); got != "https://app.localhost" {
+		t.Errorf("SiteURL(443) = %q", got)
+	}
+	if got := SiteURL("app.localhost", 8443); got != "https://app.localhost:8443" {
+		t.Errorf("SiteURL(8443) = %q", got)
+	}
+}
+
+func TestHTTPSiteURL(t *testing.T) {
+	if got := HTTPSiteURL("app.localhost", 80); got != "http://app.localhost" {
+		t.Errorf("HTTPSiteURL(80) = %q", got)
+	}
+	if got := HTTPSiteURL("app.localhost", 8080); got != "http://app.localhost:8080" {
+		t.Errorf("HTTPSiteURL(8080) = %q", got)
+	}
+}
+
+func TestReloadDebounceDefault(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      DefaultReloadDebounce,
+		"0s":    0,
+		"1s":    time.Second,
+		"bogus": DefaultReloadDebounce,
+	} {
+		cfg := &Config{Settings: Settings{ReloadDebounce: value}}
+		if got, _ := cfg.ReloadDebounce(); got != want {
+			t.Errorf("ReloadDebounce() with %q = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestReconcileInterval(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      DefaultReconcileInterval,
+		"0s":    0,
+		"30s":   30 * time.Second,
+		"10ms":  DefaultReconcileInterval,
+		"bogus": DefaultReconcileInterval,
+	} {
+		cfg := &Config{Settings: Settings{ReconcileInterval: value}}
+		if got, _ := cfg.ReconcileInterval(); got != want {
+			t.Errorf("ReconcileInterval() with %q = %v, want %v", value, got, want)
+		}
+	}
+}
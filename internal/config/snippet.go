@@ -0,0 +1,170 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SnippetFileName is a file of Caddyfile directives a project can check in
+// at its root, embedded in the site block of each of its hostnames. The
+// project file's snippet key takes precedence over it.
+const SnippetFileName = "Caddyfile.atc-snippet"
+
+// maxSnippetSize limits a project's snippet.
+const maxSnippetSize = 16 << 10 // 16 KB
+
+// snippetDirectives are the directives a snippet may use at its top level:
+// headers, compression, basic auth and responses, e.g. to answer CORS
+// preflights. Blocks under them hold only their own subdirectives.
+var snippetDirectives = map[string]bool{
+	"basic_auth":     true,
+	"basicauth":      true,
+	"encode":         true,
+	"header":         true,
+	"request_body":   true,
+	"request_header": true,
+	"respond":        true,
+	"vars":           true,
+}
+
+// snippetPlaceholders are placeholder prefixes that read the gateway's
+// environment or files, which a project must not be able to reflect into
+// responses.
+var snippetPlaceholders = []string{"{$", "{env.", "{file.", "{system."}
+
+// ValidateSnippet checks that a snippet only uses snippetDirectives and
+// named matchers at its top level, keeps its blocks balanced so it can't
+// close the site block it is embedded in, and reads no gateway environment
+// or files.
+func ValidateSnippet(s string) error {
+	if len(s) > maxSnippetSize {
+		return fmt.Errorf("snippet too large (%d bytes, max %d)", len(s), maxSnippetSize)
+	}
+	depth := 0
+	for i, line := range strings.Split(s, "\n") {
+		tokens, err := snippetTokens(line)
+		if err != nil {
+			return fmt.Errorf("snippet line %d: %w", i+1, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		if tokens[0] == "import" {
+			// Caddy expands imports wherever they appear.
+			return fmt.Errorf("snippet line %d: import is not allowed", i+1)
+		}
+		if name := tokens[0]; depth == 0 && name != "}" && !strings.HasPrefix(name, "@") && !snippetDirectives[name] {
+			return fmt.Errorf("snippet line %d: directive %q is not allowed (use a @matcher or one of %s)",
+				i+1, name, strings.Join(slices.Sorted(maps.Keys(snippetDirectives)), ", "))
+		}
+		for j, tok := range tokens {
+			switch {
+			case tok == "{":
+				if j == 0 || j != len(tokens)-1 {
+					return fmt.Errorf("snippet line %d: '{' must end a directive's line", i+1)
+				}
+				depth++
+			case tok == "}":
+				if len(tokens) != 1 {
+					return fmt.Errorf("snippet line %d: '}' must be on its own line", i+1)
+				}
+				if depth == 0 {
+					return fmt.Errorf("snippet line %d: unbalanced '}'", i+1)
+				}
+				depth--
+			case strings.HasPrefix(tok, "<<"):
+				return fmt.Errorf("snippet line %d: heredocs are not allowed", i+1)
+			default:
+				for _, p := range snippetPlaceholders {
+					if strings.Contains(tok, p) {
+						return fmt.Errorf("snippet line %d: placeholder %s...} is not allowed", i+1, p)
+					}
+				}
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("snippet has %d unclosed '{'", depth)
+	}
+	return nil
+}
+
+// snippetTokens splits a Caddyfile line into tokens the way Caddy does:
+// on unquoted whitespace, with "..." and `...` quoting and # starting a
+// comment. Quoted tokens keep their quotes, so a quoted brace never opens
+// or closes a block.
+func snippetTokens(line string) ([]string, error) {
+	if strings.ContainsAny(line, "\r\x00") {
+		return nil, errors.New("control characters are not allowed")
+	}
+	var tokens []string
+	var tok strings.Builder
+	var quote rune
+	escaped := false
+	flush := func() {
+		if tok.Len() > 0 {
+			tokens = append(tokens, tok.String())
+			tok.Reset()
+		}
+	}
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			tok.WriteRune(r)
+			if escaped {
+				escaped = false
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '`':
+			tok.WriteRune(r)
+			quote = r
+		case r == '#' && tok.Len() == 0:
+			flush()
+			return tokens, nil
+		case r == ' ' || r == '\t':
+			flush()
+		default:
+			tok.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if strings.HasSuffix(tok.String(), `\`) {
+		return nil, errors.New("line continuations are not allowed")
+	}
+	flush()
+	return tokens, nil
+}
+
+// LoadSnippet reads dir's SnippetFileName, returning "" without error if
+// the project has none.
+func LoadSnippet(dir string) (string, error) {
+	path := filepath.Join(dir, SnippetFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading %s: %w", SnippetFileName, err)
+	}
+	if info.Size() > maxSnippetSize {
+		return "", fmt.Errorf("%s too large (%d bytes, max %d)", SnippetFileName, info.Size(), maxSnippetSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", SnippetFileName, err)
+	}
+	if err := ValidateSnippet(string(data)); err != nil {
+		return "", fmt.Errorf("%s: %w", SnippetFileName, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSnippet(t *testing.T) {
+	valid := []string{
+		"",
+		"encode gzip zstd",
+		`header {
+    Access-Control-Allow-Origin *
+    -Server
+}
+@preflight method OPTIONS
+respond @preflight 204
+basic_auth /admin/* {
+    dev $2a$14$Zkx19XLiW6VYouLHR5NmfOFU0z2GTNmpkT/5qqR7hx4IjWJPDhjvG
+}`,
+		`header Content-Security-Policy "default-src 'self' { }"  # braces in quotes`,
+		"header X-Host {http.request.host}",
+	}
+	for _, s := range valid {
+		if err := ValidateSnippet(s); err != nil {
+			t.Errorf("ValidateSnippet(%q) = %v", s, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"closes the site":       "encode gzip\n}\nevil.localhost {\n    respond hi",
+		"unclosed block":        "header {\n    X-A 1",
+		"brace mid line":        "header { X-A 1 }",
+		"disallowed directive":  "reverse_proxy evil:80",
+		"file server":           "root * /\nfile_server",
+		"import":                "header {\n    import /etc/caddy/Caddyfile\n}",
+		"env placeholder":       "respond {env.CADDY_ATC_ADMIN}",
+		"parse-time env":        "respond {$HOME}",
+		"file placeholder":      "header X-Key {file./data/caddy/pki/authorities/local/root.key}",
+		"heredoc":               "respond <<EOF\nhi\nEOF",
+		"unterminated quote":    `respond "hi`,
+		"carriage return":       "encode gzip\r",
+		"disallowed in matcher": "@m path /x\nbind 0.0.0.0",
+	}
+	for name, s := range invalid {
+		if err := ValidateSnippet(s); err == nil {
+			t.Errorf("%s: ValidateSnippet(%q) = nil, want error", name, s)
+		}
+	}
+	if err := ValidateSnippet(strings.Repeat("encode gzip\n", 2000)); err == nil {
+		t.Error("expected error for oversized snippet")
+	}
+}
+
+func TestLoadProjectFile_Snippet(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, SnippetFileName), []byte("encode gzip\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := LoadProjectFile(dir)
+	if err != nil || pf.SiteSnippet() != "encode gzip\n" {
+		t.Fatalf("LoadProjectFile() = %+v, %v, want the snippet file", pf, err)
+	}
+
+	// The project file's key takes precedence.
+	content := "snippet: |\n  header X-Team a\n"
+	if err := os.WriteFile(filepath.Join(dir, ProjectFileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pf, err = LoadProjectFile(dir); err != nil || pf.SiteSnippet() != "header X-Team a\n" {
+		t.Errorf("LoadProjectFile() = %+v, %v, want the project file's snippet", pf, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ProjectFileName), []byte("snippet: \"reverse_proxy evil:80\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProjectFile(dir); err == nil {
+		t.Error("expected error for a disallowed directive")
+	}
+
+	if err := os.Remove(filepath.Join(dir, ProjectFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SnippetFileName), []byte("}\nevil.localhost {\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProjectFile(dir); err == nil {
+		t.Error("expected error for a snippet closing the site block")
+	}
+
+	var none *ProjectFile
+	if none.SiteSnippet() != "" {
+		t.Error("nil ProjectFile has a snippet")
+	}
+}
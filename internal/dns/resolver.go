@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Resolver is a drop-in file that makes the system resolver forward a
+// domain to the DNS server.
+type Resolver struct {
+	Name    string   // resolver being configured, e.g. "systemd-resolved"
+	Path    string   // drop-in file
+	Content string   // drop-in file content
+	Reload  []string // command that applies changes, if any
+}
+
+// SystemResolver returns the drop-in that forwards domain to the DNS
+// server listening on addr, for the resolver this system uses.
+func SystemResolver(domain, addr string) (*Resolver, error) {
+	return systemResolver(runtime.GOOS, exists, domain, addr)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func systemResolver(goos string, exists func(string) bool, domain, addr string) (*Resolver, error) {
+	zone := strings.ToLower(strings.Trim(domain, "."))
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("dns listen address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	switch {
+	case goos == "darwin":
+		return &Resolver{
+			Name:    "macOS resolver",
+			Path:    filepath.Join("/etc/resolver", zone),
+			Content: fmt.Sprintf("# Managed by caddy-atc\nnameserver %s\nport %s\n", host, port),
+		}, nil
+	case goos != "linux":
+		return nil, fmt.Errorf("configuring the resolver is not supported on %s; forward %s to %s manually", goos, zone, addr)
+	case exists("/run/systemd/resolve/stub-resolv.conf"):
+		return &Resolver{
+			Name:    "systemd-resolved",
+			Path:    "/etc/systemd/resolved.conf.d/caddy-atc.conf",
+			Content: fmt.Sprintf("# Managed by caddy-atc\n[Resolve]\nDNS=%s\nDomains=~%s\n", net.JoinHostPort(host, port), zone),
+			Reload:  []string{"systemctl", "restart", "systemd-resolved"},
+		}, nil
+	case exists("/etc/NetworkManager/dnsmasq.d"):
+		return &Resolver{
+			Name:    "NetworkManager (dnsmasq)",
+			Path:    "/etc/NetworkManager/dnsmasq.d/caddy-atc.conf",
+			Content: fmt.Sprintf("# Managed by caddy-atc\nserver=/%s/%s#%s\n", zone, host, port),
+			Reload:  []string{"systemctl", "reload", "NetworkManager"},
+		}, nil
+	case exists("/etc/dnsmasq.d"):
+		return &Resolver{
+			Name:    "dnsmasq",
+			Path:    "/etc/dnsmasq.d/caddy-atc.conf",
+			Content: fmt.Sprintf("# Managed by caddy-atc\nserver=/%s/%s#%s\n", zone, host, port),
+			Reload:  []string{"systemctl", "restart", "dnsmasq"},
+		}, nil
+	default:
+		return nil, fmt.Errorf("no supported resolver found (systemd-resolved, NetworkManager or dnsmasq); forward %s to %s manually", zone, addr)
+	}
+}
+
+// Installed reports whether the drop-in is in place with its content.
+func (r *Resolver) Installed() bool {
+	data, err := os.ReadFile(r.Path)
+	return err == nil && string(data) == r.Content
+}
+
+// Install writes the drop-in with sudo and applies it.
+func (r *Resolver) Install() error {
+	if err := sudo(nil, "mkdir", "-p", filepath.Dir(r.Path)); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(r.Path), err)
+	}
+	if err := sudo(strings.NewReader(r.Content), "tee", r.Path); err != nil {
+		return fmt.Errorf("writing %s: %w", r.Path, err)
+	}
+	return r.reload()
+}
+
+// Remove deletes the drop-in with sudo and applies the change.
+func (r *Resolver) Remove() error {
+	if !exists(r.Path) {
+		return nil
+	}
+	if err := sudo(nil, "rm", "-f", r.Path); err != nil {
+		return fmt.Errorf("removing %s: %w", r.Path, err)
+	}
+	return r.reload()
+}
+
+func (r *Resolver) reload() error {
+	if len(r.Reload) == 0 {
+		return nil
+	}
+	if err := sudo(nil, r.Reload...); err != nil {
+		return fmt.Errorf("reloading %s: %w", r.Name, err)
+	}
+	return nil
+}
+
+// sudo runs a command as root, feeding it stdin.
+func sudo(stdin io.Reader, args ...string) error {
+	cmd := exec.Command("sudo", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = io.Discard
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
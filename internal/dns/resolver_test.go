@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemResolver(t *testing.T) {
+	only := func(paths ...string) func(string) bool {
+		return func(p string) bool {
+			for _, q := range paths {
+				if p == q {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	tests := []struct {
+		name     string
+		goos     string
+		exists   func(string) bool
+		wantPath string
+		want     string
+	}{
+		{"macOS", "darwin", only(), "/etc/resolver/myapp.test", "nameserver 127.0.0.1\nport 15353\n"},
+		{"systemd-resolved", "linux", only("/run/systemd/resolve/stub-resolv.conf", "/etc/dnsmasq.d"),
+			"/etc/systemd/resolved.conf.d/caddy-atc.conf", "DNS=127.0.0.1:15353\nDomains=~myapp.test\n"},
+		{"NetworkManager", "linux", only("/etc/NetworkManager/dnsmasq.d"),
+			"/etc/NetworkManager/dnsmasq.d/caddy-atc.conf", "server=/myapp.test/127.0.0.1#15353\n"},
+		{"dnsmasq", "linux", only("/etc/dnsmasq.d"), "/etc/dnsmasq.d/caddy-atc.conf", "server=/myapp.test/127.0.0.1#15353\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := systemResolver(tt.goos, tt.exists, ".myapp.test", "127.0.0.1:15353")
+			if err != nil {
+				t.Fatalf("systemResolver() error = %v", err)
+			}
+			if r.Path != tt.wantPath {
+				t.Errorf("Path = %q, want %q", r.Path, tt.wantPath)
+			}
+			if !strings.HasSuffix(r.Content, tt.want) {
+				t.Errorf("Content = %q, want it to end with %q", r.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemResolver_Unsupported(t *testing.T) {
+	if _, err := systemResolver("linux", func(string) bool { return false }, ".test", "127.0.0.1:15353"); err == nil {
+		t.Error("expected an error without a supported resolver")
+	}
+	if _, err := systemResolver("windows", func(string) bool { return true }, ".test", "127.0.0.1:15353"); err == nil {
+		t.Error("expected an error on windows")
+	}
+}
+
+func TestSystemResolver_UnspecifiedListen(t *testing.T) {
+	r, err := systemResolver("darwin", nil, ".test", "0.0.0.0:15353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(r.Content, "nameserver 127.0.0.1\n") {
+		t.Errorf("Content = %q, want the resolver pointed at loopback", r.Content)
+	}
+}
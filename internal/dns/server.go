@@ -0,0 +1,258 @@
+// Package dns is a minimal authoritative DNS server that resolves every
+// name under one domain to the gateway, so hostnames on custom domains
+// work without /etc/hosts edits.
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	typeA    = 1
+	typeAAAA = 28
+	classIN  = 1
+
+	rcodeFormErr = 1
+	rcodeNotImp  = 4
+	rcodeRefused = 5
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+	flagRecursion     = 0x0100
+
+	headerLen = 12
+	answerTTL = 60
+	maxPacket = 512
+)
+
+// Server answers A and AAAA queries for a zone and its subdomains with one
+// address, and refuses queries for other names.
+type Server struct {
+	zone string
+	ip   net.IP
+}
+
+// NewServer returns a server for domain (e.g. ".test") answering ip.
+func NewServer(domain string, ip net.IP) *Server {
+	return &Server{zone: strings.ToLower(strings.Trim(domain, ".")), ip: ip}
+}
+
+// ListenAndServe answers queries on the UDP address addr until ctx is
+// done. It returns once the socket is bound.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("dns server: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go s.serve(conn)
+	return nil
+}
+
+func (s *Server) serve(conn net.PacketConn) {
+	buf := make([]byte, maxPacket)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if resp := s.handle(buf[:n]); resp != nil {
+			conn.WriteTo(resp, peer)
+		}
+	}
+}
+
+// handle returns the response to a query, or nil if req isn't one.
+func (s *Server) handle(req []byte) []byte {
+	if len(req) < headerLen {
+		return nil
+	}
+	flags := binary.BigEndian.Uint16(req[2:4])
+	if flags&flagResponse != 0 {
+		return nil
+	}
+	opcode := flags >> 11 & 0xf
+	resp := make([]byte, headerLen, maxPacket)
+	copy(resp, req[:2])
+	respFlags := flagResponse | opcode<<11 | flagAuthoritative | flags&flagRecursion
+
+	reply := func(rcode uint16, question []byte) []byte {
+		binary.BigEndian.PutUint16(resp[2:4], respFlags|rcode)
+		if question != nil {
+			binary.BigEndian.PutUint16(resp[4:6], 1)
+			resp = append(resp, question...)
+		}
+		return resp
+	}
+
+	if opcode != 0 {
+		return reply(rcodeNotImp, nil)
+	}
+	if binary.BigEndian.Uint16(req[4:6]) != 1 {
+		return reply(rcodeFormErr, nil)
+	}
+	name, end, err := readName(req, headerLen)
+	if err != nil || end+4 > len(req) {
+		return reply(rcodeFormErr, nil)
+	}
+	question := req[headerLen : end+4]
+	qtype := binary.BigEndian.Uint16(req[end : end+2])
+	qclass := binary.BigEndian.Uint16(req[end+2 : end+4])
+
+	if name != s.zone && !strings.HasSuffix(name, "."+s.zone) {
+		return reply(rcodeRefused, question)
+	}
+	resp = reply(0, question)
+
+	rdata := s.ip.To4()
+	rtype := uint16(typeA)
+	if rdata == nil {
+		rdata, rtype = s.ip.To16(), typeAAAA
+	}
+	if qclass != classIN || qtype != rtype || rdata == nil {
+		return resp // the name exists, without records of this type
+	}
+	binary.BigEndian.PutUint16(resp[6:8], 1)
+	resp = append(resp, 0xc0, headerLen) // pointer to the question's name
+	resp = binary.BigEndian.AppendUint16(resp, rtype)
+	resp = binary.BigEndian.AppendUint16(resp, classIN)
+	resp = binary.BigEndian.AppendUint32(resp, answerTTL)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+	return append(resp, rdata...)
+}
+
+// readName reads the uncompressed name at off in msg, returning it in
+// lowercase without the trailing dot, and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, errors.New("name runs past the message")
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			return strings.ToLower(strings.Join(labels, ".")), off, nil
+		}
+		if n&0xc0 != 0 || off+n > len(msg) {
+			return "", 0, errors.New("malformed name")
+		}
+		labels = append(labels, string(msg[off:off+n]))
+		off += n
+	}
+}
+
+// skipName returns the offset after the possibly compressed name at off.
+func skipName(msg []byte, off int) (int, error) {
+	for off < len(msg) {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			return off + 2, nil
+		}
+		off += n + 1
+	}
+	return 0, errors.New("name runs past the message")
+}
+
+// Lookup asks the DNS server at addr for the A record of name, or AAAA if
+// it has no A record.
+func Lookup(ctx context.Context, addr, name string) (net.IP, error) {
+	for _, qtype := range []uint16{typeA, typeAAAA} {
+		ip, err := lookup(ctx, addr, name, qtype)
+		if err != nil || ip != nil {
+			return ip, err
+		}
+	}
+	return nil, fmt.Errorf("no address for %s", name)
+}
+
+func lookup(ctx context.Context, addr, name string, qtype uint16) (net.IP, error) {
+	query := make([]byte, headerLen, maxPacket)
+	rand.Read(query[:2])
+	binary.BigEndian.PutUint16(query[2:4], flagRecursion)
+	binary.BigEndian.PutUint16(query[4:6], 1)
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, qtype)
+	query = binary.BigEndian.AppendUint16(query, classIN)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, maxPacket)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp = resp[:n]
+	if len(resp) < headerLen || resp[0] != query[0] || resp[1] != query[1] {
+		return nil, errors.New("malformed response")
+	}
+	if rcode := binary.BigEndian.Uint16(resp[2:4]) & 0xf; rcode != 0 {
+		if rcode == rcodeRefused {
+			return nil, fmt.Errorf("server refused %s", name)
+		}
+		return nil, fmt.Errorf("server answered rcode %d", rcode)
+	}
+
+	off := headerLen
+	for range binary.BigEndian.Uint16(resp[4:6]) {
+		if off, err = skipName(resp, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	for range binary.BigEndian.Uint16(resp[6:8]) {
+		if off, err = skipName(resp, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(resp) {
+			return nil, errors.New("malformed response")
+		}
+		rtype := binary.BigEndian.Uint16(resp[off : off+2])
+		rdlen := int(binary.BigEndian.Uint16(resp[off+8 : off+10]))
+		off += 10
+		if off+rdlen > len(resp) {
+			return nil, errors.New("malformed response")
+		}
+		if rtype == qtype {
+			return net.IP(resp[off : off+rdlen]), nil
+		}
+		off += rdlen
+	}
+	return nil, nil
+}
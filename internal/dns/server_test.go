@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// startServer serves s on a free loopback port and returns its address.
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve(conn)
+	return conn.LocalAddr().String()
+}
+
+func TestLookup(t *testing.T) {
+	addr := startServer(t, NewServer(".myapp.test", net.ParseIP("127.0.0.1")))
+	ctx := context.Background()
+
+	for _, name := range []string{"myapp.test", "api.myapp.test", "A.B.MyApp.Test"} {
+		ip, err := Lookup(ctx, addr, name)
+		if err != nil {
+			t.Fatalf("Lookup(%s) error = %v", name, err)
+		}
+		if !ip.Equal(net.ParseIP("127.0.0.1")) {
+			t.Errorf("Lookup(%s) = %s, want 127.0.0.1", name, ip)
+		}
+	}
+
+	_, err := Lookup(ctx, addr, "example.com")
+	if err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("Lookup(example.com) error = %v, want refused", err)
+	}
+	if _, err := Lookup(ctx, addr, "notmyapp.test"); err == nil {
+		t.Error("Lookup(notmyapp.test) succeeded, want refused")
+	}
+}
+
+func TestLookup_IPv6(t *testing.T) {
+	addr := startServer(t, NewServer(".test", net.ParseIP("::1")))
+	ip, err := Lookup(context.Background(), addr, "app.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv6loopback) {
+		t.Errorf("Lookup() = %s, want ::1", ip)
+	}
+}
+
+func TestHandle_NoData(t *testing.T) {
+	s := NewServer(".test", net.ParseIP("127.0.0.1"))
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0,
+		3, 'a', 'p', 'p', 4, 't', 'e', 's', 't', 0, 0, typeAAAA, 0, classIN}
+	resp := s.handle(query)
+	if resp == nil {
+		t.Fatal("handle() = nil")
+	}
+	if resp[0] != 0x12 || resp[1] != 0x34 {
+		t.Error("response ID doesn't match the query")
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	if flags&flagResponse == 0 || flags&flagAuthoritative == 0 || flags&0xf != 0 {
+		t.Errorf("flags = %#x, want an authoritative NOERROR response", flags)
+	}
+	if n := binary.BigEndian.Uint16(resp[6:8]); n != 0 {
+		t.Errorf("AAAA query for an IPv4 gateway got %d answers, want 0", n)
+	}
+}
+
+func TestHandle_Malformed(t *testing.T) {
+	s := NewServer(".test", net.ParseIP("127.0.0.1"))
+	if resp := s.handle([]byte{1, 2, 3}); resp != nil {
+		t.Error("handle() answered a truncated header")
+	}
+	// A response is never answered, so servers can't loop.
+	if resp := s.handle([]byte{0, 1, 0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0}); resp != nil {
+		t.Error("handle() answered a response")
+	}
+	resp := s.handle([]byte{0, 1, 0x01, 0, 0, 1, 0, 0, 0, 0, 0, 0, 5, 'a'})
+	if resp == nil || binary.BigEndian.Uint16(resp[2:4])&0xf != rcodeFormErr {
+		t.Errorf("truncated question: response = %v, want FORMERR", resp)
+	}
+}
@@ -0,0 +1,309 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// Check is the outcome of a single diagnostic.
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+	Hint   string // how to fix a failed check
+}
+
+// probeHostname is resolved to check that *.localhost reaches this host.
+const probeHostname = "caddy-atc-doctor.localhost"
+
+// Run performs the environment diagnostics. Checks that depend on an
+// earlier failed one (e.g. everything after Docker being unreachable) are
+// skipped.
+func Run(ctx context.Context) []Check {
+	var checks []Check
+
+	cli, err := gateway.NewClient()
+	if err == nil {
+		defer cli.Close()
+		_, err = gateway.Call(ctx, cli.Ping)
+	}
+	if err != nil {
+		return append(checks, Check{"Docker", false, err.Error(), "start Docker, or check DOCKER_HOST and your permissions on the socket"})
+	}
+	if name := gateway.DockerContext(); name != "" && name != "default" && os.Getenv("DOCKER_HOST") == "" {
+		checks = append(checks, Check{"Docker", true, "reachable (context " + name + ")", ""})
+	} else {
+		checks = append(checks, Check{"Docker", true, "reachable", ""})
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		cfg = &config.Config{}
+	}
+	var vm string
+	if rt, err := gateway.DetectRuntime(ctx, cli); err == nil {
+		checks = append(checks, runtimeCheck(rt, cfg))
+		if rt.VM {
+			vm = rt.Name
+		}
+	}
+
+	_, err = gateway.Call(ctx, func(ctx context.Context) (network.Inspect, error) {
+		return cli.NetworkInspect(ctx, gateway.Network(), network.InspectOptions{})
+	})
+	if err != nil {
+		checks = append(checks, Check{"Network", false, gateway.Network() + " missing", "run 'caddy-atc up'"})
+	} else {
+		checks = append(checks, Check{"Network", true, gateway.Network(), ""})
+	}
+
+	addr, _ := cfg.GatewayAddress()
+	checks = append(checks, resolutionCheck(ctx, addr, cfg))
+
+	running, err := gateway.IsRunning(ctx)
+	running = err == nil && running
+	httpPort, httpsPort := cfg.HTTPPorts()
+	ports := []string{strconv.Itoa(httpPort), strconv.Itoa(httpsPort)}
+	checks = append(checks, portChecks(ctx, cli, addr, ports, running, vm)...)
+	if containers, err := gateway.ListContainers(ctx, cli, container.ListOptions{}); err == nil {
+		domain, _ := cfg.DomainSuffix()
+		checks = append(checks, proxiesCheck(containers, domain))
+	}
+	if lazy, _, _ := cfg.LazyGateway(); !running && lazy {
+		return append(checks, Check{"Gateway", true, "stopped (lazy, starts with the first route)", ""})
+	}
+	if !running {
+		return append(checks, Check{"Gateway", false, "not running", "run 'caddy-atc up'"})
+	}
+	checks = append(checks, Check{"Gateway", true, "running", ""})
+
+	if err := gateway.ValidateConfig(ctx); err != nil {
+		checks = append(checks, Check{"Caddyfile", false, err.Error(), "check 'caddy-atc logs' for the route that caused it"})
+	} else {
+		checks = append(checks, Check{"Caddyfile", true, "valid", ""})
+	}
+
+	trusted, err := gateway.CATrusted(ctx)
+	switch {
+	case err != nil:
+		checks = append(checks, Check{"CA", false, firstLine(err.Error()), "visit any routed https:// URL to create the CA, then run 'caddy-atc trust'"})
+	case !trusted:
+		checks = append(checks, Check{"CA", false, "root CA not in the system trust store", "run 'caddy-atc trust'"})
+	default:
+		checks = append(checks, Check{"CA", true, "trusted", ""})
+	}
+
+	enabled, issues, err := gateway.CheckHardening(ctx)
+	switch {
+	case err != nil:
+		checks = append(checks, Check{"Hardening", false, err.Error(), ""})
+	case !enabled:
+		checks = append(checks, Check{"Hardening", true, "not enabled", ""})
+	case len(issues) > 0:
+		checks = append(checks, Check{"Hardening", false, strings.Join(issues, "; "), "run 'caddy-atc down' and 'caddy-atc up' to apply"})
+	default:
+		checks = append(checks, Check{"Hardening", true, "applied", ""})
+	}
+
+	return checks
+}
+
+// resolutionCheck verifies that hostnames resolve to the gateway: .localhost
+// hostnames to loopback for a local gateway, adopted hostnames to its
+// address for a remote one or a custom domain.
+func resolutionCheck(ctx context.Context, gatewayAddr string, cfg *config.Config) Check {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if domain, _ := cfg.DomainSuffix(); !isLoopback(gatewayAddr) || domain != config.DefaultDomain {
+		return adoptedResolutionCheck(ctx, gatewayAddr, domain, cfg)
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, probeHostname)
+	if err != nil {
+		return Check{"Resolution", false, fmt.Sprintf("%s does not resolve", probeHostname),
+			"use a resolver that maps *.localhost to 127.0.0.1 (e.g. systemd-resolved), or add hostnames to /etc/hosts"}
+	}
+	if !allLoopback(addrs) {
+		return Check{"Resolution", false, fmt.Sprintf("%s resolves to %s", probeHostname, strings.Join(addrs, ", ")),
+			"*.localhost must resolve to 127.0.0.1; check your DNS settings and /etc/hosts"}
+	}
+	return Check{"Resolution", true, "*.localhost resolves to loopback", ""}
+}
+
+// adoptedResolutionCheck verifies that adopted hostnames resolve to the
+// gateway's address. Wildcard hostnames are checked with a probe subdomain.
+func adoptedResolutionCheck(ctx context.Context, gatewayAddr, domain string, cfg *config.Config) Check {
+	want, err := net.DefaultResolver.LookupHost(ctx, gatewayAddr)
+	if err != nil {
+		return Check{"Resolution", false, fmt.Sprintf("gateway address %s does not resolve", gatewayAddr),
+			"check gateway.address in " + config.ProjectsPath()}
+	}
+
+	var hostnames []string
+	for _, proj := range cfg.Projects {
+		if h, ok := strings.CutPrefix(proj.Hostname, "*."); ok {
+			hostnames = append(hostnames, "caddy-atc-doctor."+h)
+		} else if proj.Hostname != "" {
+			hostnames = append(hostnames, proj.Hostname)
+		}
+	}
+	if len(hostnames) == 0 {
+		return Check{"Resolution", true, "no adopted hostnames to check", ""}
+	}
+	sort.Strings(hostnames)
+
+	for _, h := range hostnames {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, h)
+		if err != nil || !slices.ContainsFunc(addrs, func(a string) bool { return slices.Contains(want, a) }) {
+			hint := fmt.Sprintf("point adopted hostnames at %s (e.g. in /etc/hosts), or use a hostname_template on a domain that resolves to it", gatewayAddr)
+			if warning := config.DomainWarning(domain); warning != "" && strings.HasSuffix(h, domain) {
+				hint = warning
+			}
+			return Check{"Resolution", false, fmt.Sprintf("%s does not resolve to the gateway at %s", h, gatewayAddr), hint}
+		}
+	}
+	return Check{"Resolution", true, fmt.Sprintf("adopted hostnames resolve to %s", gatewayAddr), ""}
+}
+
+// isLoopback reports whether the gateway address is this machine.
+func isLoopback(addr string) bool {
+	ip := net.ParseIP(addr)
+	return addr == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+func allLoopback(addrs []string) bool {
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil || !ip.IsLoopback() {
+			return false
+		}
+	}
+	return len(addrs) > 0
+}
+
+// runtimeCheck reports the Docker installation and flags its quirks that
+// break routing.
+func runtimeCheck(rt gateway.Runtime, cfg *config.Config) Check {
+	if !rt.HostGateway() {
+		return Check{"Runtime", false, rt.String() + " can't map host.docker.internal into the gateway",
+			"upgrade Docker to 20.10 or later; static routes and 'caddy-atc serve' reach this machine through it"}
+	}
+	if rt.Name == gateway.RuntimeOrbStack {
+		var hostnames []string
+		for _, proj := range cfg.Projects {
+			hostnames = append(hostnames, proj.Hostname)
+			for _, h := range proj.Services {
+				hostnames = append(hostnames, h)
+			}
+		}
+		sort.Strings(hostnames)
+		for _, h := range hostnames {
+			if strings.HasSuffix(h, config.OrbStackDomain) {
+				return Check{"Runtime", false, fmt.Sprintf("OrbStack answers %s itself, so it never reaches the gateway", h),
+					fmt.Sprintf("give the project a hostname outside %s in %s", config.OrbStackDomain, config.ProjectsPath())}
+			}
+		}
+	}
+	if rt.VM {
+		return Check{"Runtime", true, rt.String() + " (VM; forwards published ports and host.docker.internal to this machine)", ""}
+	}
+	return Check{"Runtime", true, rt.String(), ""}
+}
+
+// portChecks verifies that the gateway's HTTP and HTTPS ports are
+// published by the running gateway, or are free for it when it isn't
+// running. When Docker runs in the VM named vm, published ports are also
+// checked to be forwarded to this machine.
+func portChecks(ctx context.Context, cli *client.Client, gatewayAddr string, ports []string, gatewayRunning bool, vm string) []Check {
+	published := make(map[string]bool)
+	if gatewayRunning {
+		if info, err := gateway.InspectContainer(ctx, cli, gateway.ContainerName); err == nil && info.NetworkSettings != nil {
+			for port, bindings := range info.NetworkSettings.Ports {
+				if len(bindings) > 0 && port.Proto() == "tcp" {
+					published[port.Port()] = true
+				}
+			}
+		}
+	}
+
+	var checks []Check
+	for _, port := range ports {
+		name := "Port " + port
+		switch {
+		case published[port] && vm != "" && !portInUse(gatewayAddr, port):
+			checks = append(checks, Check{name, false, "published by the gateway but not forwarded by " + vm,
+				"restart " + vm + " to restore its port forwarding"})
+		case published[port]:
+			checks = append(checks, Check{name, true, "published by the gateway", ""})
+		case gatewayRunning:
+			checks = append(checks, Check{name, false, "not published by the gateway", "run 'caddy-atc down' and 'caddy-atc up'"})
+		case portInUse(gatewayAddr, port):
+			n, _ := strconv.Atoi(port)
+			owner := gateway.FindPortOwner(ctx, cli, n)
+			checks = append(checks, Check{name, false, "in use by " + owner.String(),
+				owner.Hint() + ", or move the gateway with 'caddy-atc up --http-port <port> --https-port <port>'"})
+		default:
+			checks = append(checks, Check{name, true, "free", ""})
+		}
+	}
+	return checks
+}
+
+// proxiesCheck flags other proxies routing containers by hostname, which
+// compete with the gateway for hostnames under domain.
+func proxiesCheck(containers []types.Container, domain string) Check {
+	proxies := gateway.RunningProxies(containers)
+	if len(proxies) == 0 {
+		return Check{"Proxies", true, "no other local proxies", ""}
+	}
+	names := make([]string, len(proxies))
+	for i, p := range proxies {
+		names[i] = p.String()
+	}
+	foreign := gateway.ForeignRoutes(containers, domain)
+	if len(foreign) == 0 {
+		return Check{"Proxies", true, strings.Join(names, ", ") + " running, routing no " + domain + " hostnames", ""}
+	}
+	hosts := make([]string, 0, len(foreign))
+	for h := range foreign {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	detail := fmt.Sprintf("%s routes %s", strings.Join(names, ", "), hosts[0])
+	if len(hosts) > 1 {
+		detail += fmt.Sprintf(" and %d more %s hostnames", len(hosts)-1, domain)
+	}
+	return Check{"Proxies", false, detail,
+		fmt.Sprintf("adopt the project of %s with caddy-atc and drop its traefik labels, or stop %s", foreign[hosts[0]], proxies[0].Container)}
+}
+
+// portInUse reports whether something accepts connections on port at the
+// gateway address.
+func portInUse(addr, port string) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, port), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
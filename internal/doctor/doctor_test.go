@@ -0,0 +1,82 @@
+package doctor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+func TestAllLoopback(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		want  bool
+	}{
+		{[]string{"127.0.0.1", "::1"}, true},
+		{[]string{"127.0.1.1"}, true},
+		{[]string{"127.0.0.1", "192.168.1.10"}, false},
+		{[]string{"not-an-ip"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := allLoopback(tt.addrs); got != tt.want {
+			t.Errorf("allLoopback(%v) = %v, want %v", tt.addrs, got, tt.want)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":    true,
+		"::1":          true,
+		"localhost":    true,
+		"192.168.64.2": false,
+		"devbox.lan":   false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestProxiesCheck(t *testing.T) {
+	traefik := types.Container{Names: []string{"/proxy"}, Image: "traefik:v3"}
+	if c := proxiesCheck(nil, ".localhost"); !c.OK {
+		t.Errorf("proxiesCheck() without proxies = %+v, want OK", c)
+	}
+	if c := proxiesCheck([]types.Container{traefik}, ".localhost"); !c.OK {
+		t.Errorf("proxiesCheck() with an idle proxy = %+v, want OK", c)
+	}
+
+	shop := types.Container{Names: []string{"/shop-web-1"}, Labels: map[string]string{
+		"traefik.http.routers.shop.rule": "Host(`shop.localhost`)",
+	}}
+	c := proxiesCheck([]types.Container{traefik, shop}, ".localhost")
+	if c.OK || !strings.Contains(c.Detail, "shop.localhost") || !strings.Contains(c.Hint, "shop-web-1") {
+		t.Errorf("proxiesCheck() with a routed hostname = %+v, want a failure naming shop.localhost and shop-web-1", c)
+	}
+}
+
+func TestRuntimeCheck(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"app": {Hostname: "app.localhost", Services: map[string]string{"web": "app.localhost", "api": "api.app.orb.local"}},
+	}}
+
+	if c := runtimeCheck(gateway.Runtime{Name: gateway.RuntimeEngine, Version: "27.5.1"}, cfg); !c.OK || c.Detail != "Docker Engine 27.5.1" {
+		t.Errorf("runtimeCheck(Docker Engine) = %+v, want OK", c)
+	}
+	if c := runtimeCheck(gateway.Runtime{Name: gateway.RuntimeEngine, Version: "19.03.12"}, cfg); c.OK {
+		t.Errorf("runtimeCheck(Docker Engine 19.03) = %+v, want a failure", c)
+	}
+	if c := runtimeCheck(gateway.Runtime{Name: gateway.RuntimeDesktop, VM: true}, cfg); !c.OK || !strings.Contains(c.Detail, "VM") {
+		t.Errorf("runtimeCheck(Docker Desktop) = %+v, want OK noting the VM", c)
+	}
+	c := runtimeCheck(gateway.Runtime{Name: gateway.RuntimeOrbStack, VM: true}, cfg)
+	if c.OK || !strings.Contains(c.Detail, "api.app.orb.local") {
+		t.Errorf("runtimeCheck(OrbStack) = %+v, want a failure naming api.app.orb.local", c)
+	}
+}
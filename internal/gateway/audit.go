@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// Kinds of places a root CA is found.
+const (
+	KindCopy   = "copy"   // the copy saved by `caddy-atc trust`
+	KindAnchor = "anchor" // installed in a trust store
+	KindBundle = "bundle" // part of a bundle the system generates from anchors
+)
+
+// Statuses of an installed root CA.
+const (
+	CACurrent = "current" // the gateway's root CA
+	CAStale   = "stale"   // a root CA from an earlier install
+	CAExpired = "expired"
+	CAUnknown = "unknown" // the gateway's root CA couldn't be read
+)
+
+// defaultCAName is the name Caddy gives its local CA; root certificates
+// are named "Caddy Local Authority - <year> ECC Root".
+const defaultCAName = "Caddy Local Authority"
+
+// InstalledCA is a Caddy root CA found on this machine.
+type InstalledCA struct {
+	Location    string // file or keychain path
+	Kind        string
+	CommonName  string
+	Fingerprint string // SHA-256, hex
+	NotAfter    time.Time
+	Status      string
+	Duplicate   bool   // installed more than once
+	Remove      string // command that removes it, if it isn't current
+}
+
+// TrustAuditReport lists the Caddy root CAs found on this machine.
+type TrustAuditReport struct {
+	Current string // fingerprint of the gateway's root CA, "" if unknown
+	CAs     []InstalledCA
+}
+
+// trustLocation is a file, glob or keychain that may hold root CAs.
+type trustLocation struct {
+	path     string
+	kind     string
+	keychain bool
+	refresh  string // command that regenerates bundles after removal
+}
+
+// AuditTrust finds every Caddy root CA in the trust stores and files
+// caddy-atc installs to, and compares them with the gateway's root CA.
+// The gateway need not be running; statuses are then unknown.
+func AuditTrust(ctx context.Context) (*TrustAuditReport, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	current, names := "", caNames(cfg)
+	if cli, err := NewClient(); err == nil {
+		if certPEM, err := currentRootCA(ctx, cli); err == nil {
+			if cert, err := parseCert(certPEM); err == nil {
+				current = fingerprint(cert)
+				names = append(names, cert.Subject.CommonName)
+			}
+		}
+		cli.Close()
+	}
+
+	return auditLocations(trustLocations(), names, current, time.Now())
+}
+
+// caNames returns the names a root CA of the gateway's may carry.
+func caNames(cfg *config.Config) []string {
+	names := []string{defaultCAName}
+	if cfg.PKI != nil {
+		for _, n := range []string{cfg.PKI.Name, cfg.PKI.RootCN} {
+			if n != "" {
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// trustLocations returns where root CAs are installed on this system.
+func trustLocations() []trustLocation {
+	locs := []trustLocation{{path: config.RootCAPath(), kind: KindCopy}}
+
+	switch runtime.GOOS {
+	case "darwin":
+		locs = append(locs, trustLocation{path: "/Library/Keychains/System.keychain", kind: KindAnchor, keychain: true})
+		if home, err := os.UserHomeDir(); err == nil {
+			locs = append(locs, trustLocation{path: filepath.Join(home, "Library/Keychains/login.keychain-db"), kind: KindAnchor, keychain: true})
+		}
+	case "linux":
+		locs = append(locs,
+			trustLocation{path: "/usr/local/share/ca-certificates/*", kind: KindAnchor, refresh: "update-ca-certificates --fresh"},
+			trustLocation{path: "/etc/pki/ca-trust/source/anchors/*", kind: KindAnchor, refresh: "update-ca-trust"},
+			trustLocation{path: "/etc/ca-certificates/trust-source/anchors/*", kind: KindAnchor, refresh: "update-ca-trust"},
+			trustLocation{path: "/etc/ssl/certs/ca-certificates.crt", kind: KindBundle},
+			trustLocation{path: "/etc/pki/tls/certs/ca-bundle.crt", kind: KindBundle},
+		)
+		if IsWSL() {
+			locs = append(locs, trustLocation{path: "/mnt/c/Users/*/caddy-atc-root-ca.crt", kind: KindCopy})
+		}
+	}
+	return locs
+}
+
+// auditLocations collects the root CAs named after names from locs.
+func auditLocations(locs []trustLocation, names []string, current string, now time.Time) (*TrustAuditReport, error) {
+	report := &TrustAuditReport{Current: current}
+	for _, loc := range locs {
+		sources, err := readLocation(loc)
+		if err != nil {
+			return nil, err
+		}
+		for _, src := range sources {
+			for _, cert := range caddyRoots(src.data, names) {
+				report.CAs = append(report.CAs, installedCA(loc, src.path, cert, current, now))
+			}
+		}
+	}
+	markDuplicates(report.CAs)
+	return report, nil
+}
+
+type locationData struct {
+	path string
+	data []byte
+}
+
+// readLocation reads the files or keychain at loc. Missing files and
+// unreadable keychains are skipped, since most locations only exist on
+// some distributions.
+func readLocation(loc trustLocation) ([]locationData, error) {
+	if loc.keychain {
+		out, err := exec.Command("security", "find-certificate", "-a", "-p", loc.path).Output()
+		if err != nil {
+			return nil, nil
+		}
+		return []locationData{{loc.path, out}}, nil
+	}
+
+	paths, err := filepath.Glob(loc.path)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", loc.path, err)
+	}
+	var sources []locationData
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		sources = append(sources, locationData{p, data})
+	}
+	return sources, nil
+}
+
+// caddyRoots returns the CA certificates in PEM data whose common name
+// carries one of names.
+func caddyRoots(data []byte, names []string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || !cert.IsCA {
+			continue
+		}
+		for _, n := range names {
+			if strings.Contains(cert.Subject.CommonName, n) {
+				certs = append(certs, cert)
+				break
+			}
+		}
+	}
+}
+
+// installedCA describes cert as found at path.
+func installedCA(loc trustLocation, path string, cert *x509.Certificate, current string, now time.Time) InstalledCA {
+	ca := InstalledCA{
+		Location:    path,
+		Kind:        loc.kind,
+		CommonName:  cert.Subject.CommonName,
+		Fingerprint: fingerprint(cert),
+		NotAfter:    cert.NotAfter,
+	}
+	switch {
+	case current != "" && ca.Fingerprint == current:
+		ca.Status = CACurrent
+	case now.After(cert.NotAfter):
+		ca.Status = CAExpired
+	case current != "":
+		ca.Status = CAStale
+	default:
+		ca.Status = CAUnknown
+	}
+	if ca.Status == CAStale || ca.Status == CAExpired {
+		ca.Remove = removeCommand(loc, path, cert)
+	}
+	return ca
+}
+
+// removeCommand returns the command that removes cert from path. Bundles
+// are regenerated from anchors, so they have none of their own.
+func removeCommand(loc trustLocation, path string, cert *x509.Certificate) string {
+	switch {
+	case loc.kind == KindBundle:
+		return ""
+	case loc.keychain:
+		sum := sha1.Sum(cert.Raw)
+		return fmt.Sprintf("sudo security delete-certificate -Z %s %s", strings.ToUpper(hex.EncodeToString(sum[:])), path)
+	case loc.kind == KindCopy:
+		return "rm " + path
+	default:
+		return fmt.Sprintf("sudo rm %s && sudo %s", path, loc.refresh)
+	}
+}
+
+// markDuplicates flags root CAs installed as more than one anchor, or
+// appearing more than once in the same bundle.
+func markDuplicates(cas []InstalledCA) {
+	key := func(ca InstalledCA) string {
+		if ca.Kind == KindBundle {
+			return ca.Location + "|" + ca.Fingerprint
+		}
+		return ca.Fingerprint
+	}
+	counts := make(map[string]int)
+	for _, ca := range cas {
+		if ca.Kind != KindCopy {
+			counts[key(ca)]++
+		}
+	}
+	for i, ca := range cas {
+		if ca.Kind != KindCopy && counts[key(ca)] > 1 {
+			cas[i].Duplicate = true
+		}
+	}
+}
+
+// parseCert parses the first certificate in PEM data.
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("CA cert is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CA cert: %w", err)
+	}
+	return cert, nil
+}
+
+// fingerprint returns the hex SHA-256 fingerprint of cert.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
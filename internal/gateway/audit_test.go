@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRoot returns a self-signed CA certificate named cn, in PEM.
+func testRoot(t *testing.T, cn string, notAfter time.Time) ([]byte, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaddyRoots(t *testing.T) {
+	year := time.Now().Add(365 * 24 * time.Hour)
+	caddy, _ := testRoot(t, "Caddy Local Authority - 2024 ECC Root", year)
+	custom, _ := testRoot(t, "Acme Dev Root", year)
+	other, _ := testRoot(t, "ISRG Root X1", year)
+
+	data := append(append(append([]byte{}, other...), caddy...), custom...)
+	certs := caddyRoots(data, []string{defaultCAName})
+	if len(certs) != 1 || !strings.HasPrefix(certs[0].Subject.CommonName, defaultCAName) {
+		t.Fatalf("caddyRoots() found %d certs, want the Caddy root", len(certs))
+	}
+
+	certs = caddyRoots(data, []string{defaultCAName, "Acme Dev Root"})
+	if len(certs) != 2 {
+		t.Errorf("caddyRoots() with a PKI root name found %d certs, want 2", len(certs))
+	}
+
+	if certs := caddyRoots([]byte("garbage"), []string{defaultCAName}); len(certs) != 0 {
+		t.Errorf("caddyRoots() on non-PEM data = %d certs, want 0", len(certs))
+	}
+}
+
+func TestAuditLocations(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	currentPEM, current := testRoot(t, "Caddy Local Authority - 2025 ECC Root", now.Add(365*24*time.Hour))
+	stalePEM, _ := testRoot(t, "Caddy Local Authority - 2023 ECC Root", now.Add(30*24*time.Hour))
+	expiredPEM, _ := testRoot(t, "Caddy Local Authority - 2020 ECC Root", now.Add(-24*time.Hour))
+
+	writeFile(t, filepath.Join(dir, "home/caddy-atc-root-ca.crt"), currentPEM)
+	writeFile(t, filepath.Join(dir, "anchors/caddy-atc-root-ca.crt"), currentPEM)
+	writeFile(t, filepath.Join(dir, "anchors/caddy-root.crt"), currentPEM)
+	writeFile(t, filepath.Join(dir, "anchors/old-caddy.crt"), append(append([]byte{}, stalePEM...), expiredPEM...))
+	writeFile(t, filepath.Join(dir, "bundle.crt"), append(append([]byte{}, currentPEM...), stalePEM...))
+
+	locs := []trustLocation{
+		{path: filepath.Join(dir, "home/caddy-atc-root-ca.crt"), kind: KindCopy},
+		{path: filepath.Join(dir, "anchors/*"), kind: KindAnchor, refresh: "update-ca-certificates --fresh"},
+		{path: filepath.Join(dir, "bundle.crt"), kind: KindBundle},
+		{path: filepath.Join(dir, "missing/*"), kind: KindAnchor},
+	}
+	report, err := auditLocations(locs, []string{defaultCAName}, fingerprint(current), now)
+	if err != nil {
+		t.Fatalf("auditLocations() error = %v", err)
+	}
+	if len(report.CAs) != 7 {
+		t.Fatalf("found %d CAs, want 7: %+v", len(report.CAs), report.CAs)
+	}
+
+	byStatus := make(map[string]int)
+	duplicates := 0
+	for _, ca := range report.CAs {
+		byStatus[ca.Status]++
+		if ca.Duplicate {
+			duplicates++
+			if ca.Kind != KindAnchor || ca.Status != CACurrent {
+				t.Errorf("%s (%s) flagged as duplicate", ca.Location, ca.Status)
+			}
+		}
+		switch ca.Status {
+		case CACurrent:
+			if ca.Remove != "" {
+				t.Errorf("current CA at %s has a remove command", ca.Location)
+			}
+		case CAStale, CAExpired:
+			if ca.Kind == KindAnchor && !strings.Contains(ca.Remove, "sudo rm "+ca.Location) {
+				t.Errorf("Remove = %q, want it to remove %s", ca.Remove, ca.Location)
+			}
+			if ca.Kind == KindBundle && ca.Remove != "" {
+				t.Errorf("bundle entry has remove command %q", ca.Remove)
+			}
+		}
+	}
+	if byStatus[CACurrent] != 4 || byStatus[CAStale] != 2 || byStatus[CAExpired] != 1 {
+		t.Errorf("statuses = %v, want 4 current, 2 stale, 1 expired", byStatus)
+	}
+	if duplicates != 2 {
+		t.Errorf("%d duplicates, want the 2 anchors of the current CA", duplicates)
+	}
+}
+
+func TestAuditLocations_UnknownCurrent(t *testing.T) {
+	dir := t.TempDir()
+	rootPEM, _ := testRoot(t, "Caddy Local Authority - 2025 ECC Root", time.Now().Add(time.Hour))
+	writeFile(t, filepath.Join(dir, "root.crt"), rootPEM)
+
+	report, err := auditLocations([]trustLocation{{path: filepath.Join(dir, "root.crt"), kind: KindAnchor}}, []string{defaultCAName}, "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.CAs) != 1 || report.CAs[0].Status != CAUnknown || report.CAs[0].Remove != "" {
+		t.Errorf("CAs = %+v, want one of unknown status without a remove command", report.CAs)
+	}
+}
+
+func TestRemoveCommand_Keychain(t *testing.T) {
+	_, cert := testRoot(t, "Caddy Local Authority - 2025 ECC Root", time.Now().Add(time.Hour))
+	loc := trustLocation{path: "/Library/Keychains/System.keychain", kind: KindAnchor, keychain: true}
+	cmd := removeCommand(loc, loc.path, cert)
+	if !strings.HasPrefix(cmd, "sudo security delete-certificate -Z ") || !strings.HasSuffix(cmd, loc.path) {
+		t.Errorf("removeCommand() = %q", cmd)
+	}
+}
@@ -6,3 +6,6 @@ import (
 
 //go:embed docker-compose.yml
 var ComposeFile []byte
+
+//go:embed docker-compose.hardened.yml
+var HardenedComposeFile []byte
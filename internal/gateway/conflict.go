@@ -0,0 +1,322 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// PortOwner is what holds a port the gateway wants: a container publishing
+// it or a host process listening on it.
+type PortOwner struct {
+	Port      int
+	Product   string // known proxy, e.g. "Traefik"; empty if not one
+	Container string // container publishing the port
+	Image     string // the container's image
+	Process   string // host process listening on the port
+	PID       int
+}
+
+// String names the owner, e.g. "Traefik (container traefik-1)".
+func (o PortOwner) String() string {
+	var what string
+	switch {
+	case o.Container != "":
+		what = "container " + o.Container
+	case o.PID > 0:
+		what = fmt.Sprintf("%s, pid %d", o.Process, o.PID)
+	default:
+		return "another program"
+	}
+	if o.Product == "" {
+		return what
+	}
+	return o.Product + " (" + what + ")"
+}
+
+// Hint says how to free the port for the gateway.
+func (o PortOwner) Hint() string {
+	switch {
+	case o.Container != "" && o.takesOver():
+		return fmt.Sprintf("caddy-atc can take over its sites: adopt the projects it routes, then 'docker stop %s'", o.Container)
+	case o.Container != "":
+		return fmt.Sprintf("stop it with 'docker stop %s' if it doesn't need port %d", o.Container, o.Port)
+	case o.Product == ProductValet:
+		return "run 'valet stop', or 'valet use' a port other than 80/443"
+	case o.Product == ProductOrbStack || o.Product == ProductDocker:
+		return fmt.Sprintf("a container publishes port %d in another Docker context; find it with 'docker ps' there", o.Port)
+	case o.PID > 0 && o.takesOver():
+		return fmt.Sprintf("caddy-atc can take over its sites: adopt the projects it routes, then stop %s (%s)", o.Product, stopCommand(o.Process))
+	case o.PID > 0:
+		return fmt.Sprintf("stop %s (%s)", o.Process, stopCommand(o.Process))
+	default:
+		return fmt.Sprintf("find it with 'sudo lsof -i :%d'", o.Port)
+	}
+}
+
+// takesOver reports whether the owner is a reverse proxy whose sites
+// caddy-atc can route instead.
+func (o PortOwner) takesOver() bool {
+	return slices.Contains([]string{ProductTraefik, ProductNginxProxy, ProductCaddy}, o.Product)
+}
+
+// Local proxies recognized by FindPortOwner.
+const (
+	ProductTraefik    = "Traefik"
+	ProductNginxProxy = "nginx-proxy"
+	ProductNginx      = "nginx"
+	ProductCaddy      = "Caddy"
+	ProductApache     = "Apache"
+	ProductHAProxy    = "HAProxy"
+	ProductValet      = "Laravel Valet"
+	ProductOrbStack   = "OrbStack"
+	ProductDocker     = "Docker"
+)
+
+// knownProxies maps image base names and process names to products, most
+// specific first.
+var knownProxies = []struct{ match, product string }{
+	{"traefik", ProductTraefik},
+	{"nginx-proxy", ProductNginxProxy},
+	{"caddy", ProductCaddy},
+	{"haproxy", ProductHAProxy},
+	{"httpd", ProductApache},
+	{"apache2", ProductApache},
+	{"nginx", ProductNginx},
+	{"orbstack", ProductOrbStack},
+	{"com.docker.backend", ProductDocker},
+	{"docker-proxy", ProductDocker},
+	{"vpnkit", ProductDocker},
+}
+
+// identify returns the product an image or process name belongs to, or "".
+func identify(name string) string {
+	name = strings.ToLower(name)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name, _, _ = strings.Cut(name, ":")
+	name, _, _ = strings.Cut(name, "@")
+	for _, p := range knownProxies {
+		if strings.Contains(name, p.match) {
+			return p.product
+		}
+	}
+	return ""
+}
+
+// RunningProxies returns the containers, other than the gateway, running
+// a proxy that routes other containers by hostname on its own: Traefik or
+// nginx-proxy.
+func RunningProxies(containers []types.Container) []PortOwner {
+	var proxies []PortOwner
+	for _, c := range containers {
+		if len(c.Names) == 0 || strings.TrimPrefix(c.Names[0], "/") == ContainerName {
+			continue
+		}
+		if product := identify(c.Image); product == ProductTraefik || product == ProductNginxProxy {
+			proxies = append(proxies, PortOwner{Product: product, Container: strings.TrimPrefix(c.Names[0], "/"), Image: c.Image})
+		}
+	}
+	return proxies
+}
+
+// FindPortOwner identifies what holds port on this machine: a container
+// publishing it, else the listening process if the system lets us see it.
+// cli may be nil to skip containers.
+func FindPortOwner(ctx context.Context, cli *client.Client, port int) PortOwner {
+	owner := PortOwner{Port: port}
+	if cli != nil {
+		if containers, err := ListContainers(ctx, cli, container.ListOptions{}); err == nil {
+			if c := publishingContainer(containers, port); c != nil {
+				owner.Container = strings.TrimPrefix(c.Names[0], "/")
+				owner.Image = c.Image
+				owner.Product = identify(c.Image)
+				return owner
+			}
+		}
+	}
+	owner.PID, owner.Process = listeningProcess(port)
+	if owner.PID > 0 {
+		owner.Product = identify(owner.Process)
+		if owner.Product == ProductNginx && valetInstalled() {
+			owner.Product = ProductValet
+		}
+	}
+	return owner
+}
+
+// publishingContainer returns the container, other than the gateway, that
+// publishes TCP port on the host.
+func publishingContainer(containers []types.Container, port int) *types.Container {
+	for i, c := range containers {
+		if len(c.Names) == 0 || strings.TrimPrefix(c.Names[0], "/") == ContainerName {
+			continue
+		}
+		for _, p := range c.Ports {
+			if int(p.PublicPort) == port && p.Type == "tcp" {
+				return &containers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// listeningProcess returns the process listening on TCP port, using lsof
+// or ss. Processes of other users are only visible with privileges, so
+// this may find nothing.
+func listeningProcess(port int) (int, string) {
+	p := strconv.Itoa(port)
+	if out, err := exec.Command("lsof", "-nP", "-iTCP:"+p, "-sTCP:LISTEN", "-Fpc").Output(); err == nil {
+		if pid, comm := parseLsof(out); pid > 0 {
+			return pid, comm
+		}
+	}
+	if runtime.GOOS == "linux" {
+		if out, err := exec.Command("ss", "-Hltnp", "sport", "=", ":"+p).Output(); err == nil {
+			return parseSS(out)
+		}
+	}
+	return 0, ""
+}
+
+// parseLsof returns the first process in lsof -F pc output.
+func parseLsof(out []byte) (int, string) {
+	pid, comm := 0, ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case 'p':
+			if pid > 0 {
+				return pid, comm
+			}
+			pid, _ = strconv.Atoi(line[1:])
+		case 'c':
+			comm = line[1:]
+		}
+	}
+	return pid, comm
+}
+
+var ssUsers = regexp.MustCompile(`users:\(\("([^"]+)",pid=(\d+)`)
+
+// parseSS returns the first process in ss -p output.
+func parseSS(out []byte) (int, string) {
+	m := ssUsers.FindSubmatch(out)
+	if m == nil {
+		return 0, ""
+	}
+	pid, _ := strconv.Atoi(string(m[2]))
+	return pid, string(m[1])
+}
+
+// valetInstalled reports whether Laravel Valet is set up, whose nginx
+// serves its sites on 80/443.
+func valetInstalled() bool {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	for _, dir := range []string{".config/valet", ".valet"} {
+		if _, err := os.Stat(filepath.Join(home, dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stopCommand suggests how to stop a system service by its process name.
+func stopCommand(process string) string {
+	name := identify(process)
+	switch {
+	case name == "":
+		return "e.g. 'kill <pid>'"
+	case runtime.GOOS == "darwin":
+		return "e.g. 'brew services stop " + strings.ToLower(process) + "'"
+	default:
+		return "e.g. 'sudo systemctl stop " + strings.ToLower(process) + "'"
+	}
+}
+
+// traefikRule matches the hostnames of a Traefik router rule.
+var traefikRule = regexp.MustCompile("Host(?:SNI)?\\(([^)]*)\\)")
+
+// ForeignRoutes returns the hostnames under domain that containers route
+// through Traefik router rules, mapped to the container.
+func ForeignRoutes(containers []types.Container, domain string) map[string]string {
+	routes := make(map[string]string)
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(c.Names[0], "/")
+		var hosts []string
+		for key, value := range c.Labels {
+			if strings.HasPrefix(key, "traefik.") && strings.Contains(key, ".routers.") && strings.HasSuffix(key, ".rule") {
+				for _, m := range traefikRule.FindAllStringSubmatch(value, -1) {
+					for _, h := range strings.Split(m[1], ",") {
+						hosts = append(hosts, strings.Trim(strings.TrimSpace(h), "`\"'"))
+					}
+				}
+			}
+		}
+		for _, h := range hosts {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if strings.HasSuffix(h, domain) {
+				routes[h] = name
+			}
+		}
+	}
+	return routes
+}
+
+// warnProxies warns when another proxy routes hostnames under the
+// configured domain, so which one answers depends on the port it's
+// reached on.
+func warnProxies(ctx context.Context, cli *client.Client) {
+	containers, err := ListContainers(ctx, cli, container.ListOptions{})
+	if err != nil {
+		return
+	}
+	proxies := RunningProxies(containers)
+	if len(proxies) == 0 {
+		return
+	}
+	domain := config.DefaultDomain
+	if cfg, err := config.Load(); err == nil {
+		domain, _ = cfg.DomainSuffix()
+	}
+	foreign := ForeignRoutes(containers, domain)
+	if len(foreign) == 0 {
+		return
+	}
+	hosts := make([]string, 0, len(foreign))
+	for h := range foreign {
+		hosts = append(hosts, h)
+	}
+	slices.Sort(hosts)
+	if len(hosts) > 3 {
+		hosts = append(hosts[:3], "...")
+	}
+	fmt.Printf("Warning: %s also routes %s hostnames (%s).\n", proxies[0], domain, strings.Join(hosts, ", "))
+	fmt.Println("         Adopt those projects with caddy-atc and drop their traefik labels, or stop it.")
+}
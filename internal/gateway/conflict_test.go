@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestIdentify(t *testing.T) {
+	for name, want := range map[string]string{
+		"traefik:v3.1":                         ProductTraefik,
+		"docker.io/library/traefik@sha256:abc": ProductTraefik,
+		"nginxproxy/nginx-proxy:1.6":           ProductNginxProxy,
+		"jwilder/nginx-proxy":                  ProductNginxProxy,
+		"nginx":                                ProductNginx,
+		"httpd":                                ProductApache,
+		"OrbStack Helper":                      ProductOrbStack,
+		"com.docker.backend":                   ProductDocker,
+		"postgres:16":                          "",
+	} {
+		if got := identify(name); got != want {
+			t.Errorf("identify(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseLsof(t *testing.T) {
+	out := []byte("p812\ncnginx\nf6\np813\ncnginx\n")
+	if pid, comm := parseLsof(out); pid != 812 || comm != "nginx" {
+		t.Errorf("parseLsof() = %d, %q, want 812, nginx", pid, comm)
+	}
+	if pid, _ := parseLsof(nil); pid != 0 {
+		t.Errorf("parseLsof(empty) pid = %d, want 0", pid)
+	}
+}
+
+func TestParseSS(t *testing.T) {
+	out := []byte(`LISTEN 0      511          0.0.0.0:80        0.0.0.0:*    users:(("traefik",pid=4321,fd=7))` + "\n")
+	if pid, comm := parseSS(out); pid != 4321 || comm != "traefik" {
+		t.Errorf("parseSS() = %d, %q, want 4321, traefik", pid, comm)
+	}
+	// Without privileges, ss omits other users' processes.
+	if pid, _ := parseSS([]byte("LISTEN 0 511 0.0.0.0:80 0.0.0.0:*\n")); pid != 0 {
+		t.Errorf("parseSS() without users pid = %d, want 0", pid)
+	}
+}
+
+func TestPublishingContainer(t *testing.T) {
+	containers := []types.Container{
+		{Names: []string{"/" + ContainerName}, Image: "caddy", Ports: []types.Port{{PublicPort: 80, Type: "tcp"}}},
+		{Names: []string{"/dns"}, Image: "coredns", Ports: []types.Port{{PublicPort: 80, Type: "udp"}}},
+		{Names: []string{"/proxy"}, Image: "traefik:v3", Ports: []types.Port{{PrivatePort: 80, PublicPort: 80, Type: "tcp"}}},
+	}
+	c := publishingContainer(containers, 80)
+	if c == nil || c.Names[0] != "/proxy" {
+		t.Fatalf("publishingContainer() = %+v, want the proxy container", c)
+	}
+	if c := publishingContainer(containers, 443); c != nil {
+		t.Errorf("publishingContainer(443) = %+v, want nil", c)
+	}
+}
+
+func TestPortOwner(t *testing.T) {
+	tests := []struct {
+		owner      PortOwner
+		name, hint string
+	}{
+		{PortOwner{Port: 80, Product: ProductTraefik, Container: "proxy"}, "Traefik (container proxy)", "take over its sites"},
+		{PortOwner{Port: 80, Container: "web"}, "container web", "'docker stop web'"},
+		{PortOwner{Port: 443, Product: ProductValet, Process: "nginx", PID: 99}, "Laravel Valet (nginx, pid 99)", "valet stop"},
+		{PortOwner{Port: 80, Process: "python3", PID: 7}, "python3, pid 7", "stop python3"},
+		{PortOwner{Port: 80}, "another program", "sudo lsof -i :80"},
+	}
+	for _, tt := range tests {
+		if got := tt.owner.String(); got != tt.name {
+			t.Errorf("String() = %q, want %q", got, tt.name)
+		}
+		if got := tt.owner.Hint(); !strings.Contains(got, tt.hint) {
+			t.Errorf("Hint() for %s = %q, want it to mention %q", tt.name, got, tt.hint)
+		}
+	}
+}
+
+func TestForeignRoutes(t *testing.T) {
+	containers := []types.Container{
+		{Names: []string{"/shop-web-1"}, Labels: map[string]string{
+			"traefik.http.routers.shop.rule": "Host(`shop.localhost`) || Host(`www.shop.localhost`, `shop.test`)",
+		}},
+		{Names: []string{"/db"}, Labels: map[string]string{"traefik.enable": "false"}},
+	}
+	got := ForeignRoutes(containers, ".localhost")
+	if len(got) != 2 || got["shop.localhost"] != "shop-web-1" || got["www.shop.localhost"] != "shop-web-1" {
+		t.Errorf("ForeignRoutes() = %v, want shop.localhost and www.shop.localhost", got)
+	}
+}
+
+func TestRunningProxies(t *testing.T) {
+	containers := []types.Container{
+		{Names: []string{"/" + ContainerName}, Image: "caddy:2"},
+		{Names: []string{"/app-caddy-1"}, Image: "caddy:2"},
+		{Names: []string{"/proxy"}, Image: "nginxproxy/nginx-proxy"},
+	}
+	got := RunningProxies(containers)
+	if len(got) != 1 || got[0].Container != "proxy" || got[0].Product != ProductNginxProxy {
+		t.Errorf("RunningProxies() = %+v, want only the nginx-proxy container", got)
+	}
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// NewClient connects to the Docker daemon the docker CLI would use. Plain
+// DOCKER_HOST values are handled by the SDK; ssh:// hosts and non-default
+// Docker contexts (colima, remote dev servers) are reached through
+// `docker system dial-stdio`, so they connect exactly as the CLI does.
+func NewClient() (*client.Client, error) {
+	if !needsCLIDialer() {
+		return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	}
+	return client.NewClientWithOpts(
+		client.WithHost("http://docker"),
+		client.WithDialContext(dialStdio),
+		client.WithAPIVersionNegotiation(),
+	)
+}
+
+// Ping checks that the Docker daemon answers within the Docker timeout, so
+// commands that can do without Docker don't hang on an unresponsive one.
+func Ping(ctx context.Context) error {
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+	if _, err := Call(ctx, cli.Ping); err != nil {
+		return fmt.Errorf("pinging Docker: %w", err)
+	}
+	return nil
+}
+
+// needsCLIDialer reports whether the daemon is one the SDK can't reach on
+// its own.
+func needsCLIDialer() bool {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return strings.HasPrefix(host, "ssh://")
+	}
+	name := DockerContext()
+	return name != "" && name != "default"
+}
+
+// DockerContext returns the Docker context selected by DOCKER_CONTEXT or
+// the CLI config's currentContext, or "" if none is.
+func DockerContext() string {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name
+	}
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return ""
+	}
+	return cfg.CurrentContext
+}
+
+// dialStdio opens a connection to the daemon through the docker CLI. The
+// process outlives ctx, since the HTTP client pools connections.
+func dialStdio(_ context.Context, _, _ string) (net.Conn, error) {
+	cmd := exec.Command("docker", "system", "dial-stdio")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = io.Discard
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("running docker system dial-stdio: %w", err)
+	}
+	return &stdioConn{cmd: cmd, r: stdout, w: stdin}, nil
+}
+
+// stdioConn is a net.Conn over a `docker system dial-stdio` process.
+type stdioConn struct {
+	cmd *exec.Cmd
+	r   io.ReadCloser
+	w   io.WriteCloser
+}
+
+func (c *stdioConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *stdioConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *stdioConn) Close() error {
+	c.w.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *stdioConn) LocalAddr() net.Addr              { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr             { return stdioAddr{} }
+func (c *stdioConn) SetDeadline(time.Time) error      { return nil }
+func (c *stdioConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *stdioConn) SetWriteDeadline(time.Time) error { return nil }
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "docker system dial-stdio" }
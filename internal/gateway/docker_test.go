@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func TestDockerContext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("DOCKER_HOST", "")
+
+	if got := DockerContext(); got != "" {
+		t.Errorf("DockerContext() without config = %q, want empty", got)
+	}
+	if needsCLIDialer() {
+		t.Error("expected the SDK to connect without a context")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext": "colima"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := DockerContext(); got != "colima" {
+		t.Errorf("DockerContext() = %q, want colima", got)
+	}
+	if !needsCLIDialer() {
+		t.Error("expected a non-default context to connect through the CLI")
+	}
+
+	t.Setenv("DOCKER_CONTEXT", "default")
+	if needsCLIDialer() {
+		t.Error("expected DOCKER_CONTEXT=default to override the config")
+	}
+
+	// DOCKER_HOST takes precedence over contexts, as in the CLI.
+	t.Setenv("DOCKER_CONTEXT", "colima")
+	t.Setenv("DOCKER_HOST", "tcp://192.168.64.2:2375")
+	if needsCLIDialer() {
+		t.Error("expected a tcp DOCKER_HOST to be handled by the SDK")
+	}
+	t.Setenv("DOCKER_HOST", "ssh://dev@devbox")
+	if !needsCLIDialer() {
+		t.Error("expected an ssh DOCKER_HOST to connect through the CLI")
+	}
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// systemBundles are the system CA bundles of common distributions and
+// macOS, in the order they are tried.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt", // Debian, Ubuntu, Arch, Alpine
+	"/etc/pki/tls/certs/ca-bundle.crt",   // Fedora, RHEL
+	"/etc/ssl/ca-bundle.pem",             // openSUSE
+	"/etc/ssl/cert.pem",                  // macOS
+}
+
+// BundleEnv are the variables through which curl, OpenSSL-based tools,
+// Python requests and git take a CA bundle. They replace the tool's roots,
+// so they are pointed at CAFiles.Bundle.
+var BundleEnv = []string{"SSL_CERT_FILE", "CURL_CA_BUNDLE", "REQUESTS_CA_BUNDLE", "GIT_SSL_CAINFO"}
+
+// RootEnv is the variable through which Node.js adds a CA to its roots, so
+// it is pointed at CAFiles.Root.
+const RootEnv = "NODE_EXTRA_CA_CERTS"
+
+// CAFiles are the files CLI tools are pointed at to trust the gateway.
+type CAFiles struct {
+	Root   string // the root CA alone, for tools that add it to the system roots
+	Bundle string // the system roots plus the root CA, for tools that replace them
+	System string // the system bundle Bundle extends, "" if none was found
+}
+
+// WriteCAFiles saves the gateway's root CA and a system bundle extended
+// with it. The root CA is fetched from the gateway if it is running, and
+// otherwise taken from the copy saved earlier.
+func WriteCAFiles(ctx context.Context) (*CAFiles, error) {
+	rootPEM, err := fetchRootCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(config.RootCAPath(), rootPEM, 0600); err != nil {
+		return nil, fmt.Errorf("saving CA cert: %w", err)
+	}
+
+	files := &CAFiles{Root: config.RootCAPath(), Bundle: config.CABundlePath()}
+	var bundle []byte
+	for _, path := range systemBundles {
+		if data, err := os.ReadFile(path); err == nil {
+			bundle, files.System = data, path
+			break
+		}
+	}
+	if err := os.WriteFile(files.Bundle, appendPEM(bundle, rootPEM), 0600); err != nil {
+		return nil, fmt.Errorf("saving CA bundle: %w", err)
+	}
+	return files, nil
+}
+
+// fetchRootCA returns the current root CA, falling back to the saved copy
+// when the gateway is unreachable.
+func fetchRootCA(ctx context.Context) ([]byte, error) {
+	if cli, err := NewClient(); err == nil {
+		defer cli.Close()
+		if isContainerRunning(ctx, cli) {
+			if rootPEM, err := currentRootCA(ctx, cli); err == nil {
+				return rootPEM, nil
+			}
+		}
+	}
+	rootPEM, err := os.ReadFile(config.RootCAPath())
+	if err != nil {
+		return nil, fmt.Errorf("no root CA saved yet and the gateway is not running - run 'caddy-atc up' first")
+	}
+	if _, err := parseCert(rootPEM); err != nil {
+		return nil, fmt.Errorf("%s: %w", config.RootCAPath(), err)
+	}
+	return rootPEM, nil
+}
+
+// appendPEM appends a PEM block to a bundle, on a line of its own.
+func appendPEM(bundle, block []byte) []byte {
+	out := bytes.Clone(bundle)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return append(out, block...)
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestWriteCAFiles_SavedCopy(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "none.sock"))
+	rootPEM, _ := testRoot(t, "Caddy Local Authority - 2025 ECC Root", time.Now().Add(time.Hour))
+	writeFile(t, config.RootCAPath(), rootPEM)
+
+	system := filepath.Join(t.TempDir(), "ca-certificates.crt")
+	otherPEM, _ := testRoot(t, "ISRG Root X1", time.Now().Add(time.Hour))
+	writeFile(t, system, []byte(strings.TrimSuffix(string(otherPEM), "\n")))
+	orig := systemBundles
+	systemBundles = []string{filepath.Join(t.TempDir(), "missing.crt"), system}
+	t.Cleanup(func() { systemBundles = orig })
+
+	files, err := WriteCAFiles(context.Background())
+	if err != nil {
+		t.Fatalf("WriteCAFiles() error = %v", err)
+	}
+	if files.System != system {
+		t.Errorf("System = %q, want %q", files.System, system)
+	}
+	bundle, err := os.ReadFile(files.Bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bundle) != string(otherPEM)+string(rootPEM) {
+		t.Errorf("bundle is not the system roots followed by the root CA:\n%s", bundle)
+	}
+	if got := caddyRoots(bundle, []string{defaultCAName}); len(got) != 1 {
+		t.Errorf("bundle holds %d Caddy roots, want 1", len(got))
+	}
+}
+
+func TestWriteCAFiles_NothingSaved(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "none.sock"))
+	if _, err := WriteCAFiles(context.Background()); err == nil || !strings.Contains(err.Error(), "caddy-atc up") {
+		t.Errorf("WriteCAFiles() error = %v, want a hint to run up", err)
+	}
+}
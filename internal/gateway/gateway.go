@@ -3,45 +3,69 @@ package gateway
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/g-brodiei/caddy-atc/internal/config"
 )
 
-const (
-	NetworkName   = "caddy-atc"
-	ContainerName = "caddy-atc"
-)
+const ContainerName = "caddy-atc"
+
+// caddyfileVolume is the compose volume binding config.CaddyfileDir() into
+// the gateway.
+const caddyfileVolume = "caddy-atc_caddy-atc-caddyfile"
+
+// Network returns the Docker network shared by the gateway and routed
+// containers, from config.yml. It is read once per process; a change
+// applies once the gateway and watcher are restarted.
+var Network = sync.OnceValue(func() string {
+	cfg, err := config.Load()
+	if err != nil {
+		return config.DefaultNetwork
+	}
+	return cfg.NetworkName()
+})
+
+// stopSeconds is how long the gateway gets to shut down gracefully before
+// it is killed.
+var stopSeconds = 10
 
 // EnsureNetwork creates the caddy-atc Docker network if it doesn't exist.
 func EnsureNetwork(ctx context.Context, cli *client.Client) error {
-	networks, err := cli.NetworkList(ctx, network.ListOptions{
-		Filters: filters.NewArgs(filters.Arg("name", NetworkName)),
+	networks, err := Call(ctx, func(ctx context.Context) ([]network.Summary, error) {
+		return cli.NetworkList(ctx, network.ListOptions{
+			Filters: filters.NewArgs(filters.Arg("name", Network())),
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("listing networks: %w", err)
 	}
 	for _, n := range networks {
-		if n.Name == NetworkName {
+		if n.Name == Network() {
 			return nil
 		}
 	}
 
-	_, err = cli.NetworkCreate(ctx, NetworkName, network.CreateOptions{
-		Driver: "bridge",
+	err = Do(ctx, func(ctx context.Context) error {
+		_, err := cli.NetworkCreate(ctx, Network(), network.CreateOptions{
+			Driver: "bridge",
+		})
+		return err
 	})
 	if err != nil {
 		return fmt.Errorf("creating network: %w", err)
 	}
-	fmt.Println("Created Docker network:", NetworkName)
+	fmt.Println("Created Docker network:", Network())
 	return nil
 }
 
@@ -60,33 +84,119 @@ func WriteInitialCaddyfile() error {
 {
     local_certs
     skip_install_trust
+    admin {$CADDY_ATC_ADMIN:localhost:2019}
+    http_port {$CADDY_ATC_HTTP_PORT:80}
+    https_port {$CADDY_ATC_HTTPS_PORT:443}
 }
 `
 	return os.WriteFile(path, []byte(content), 0644)
 }
 
-// Up creates the network, writes initial Caddyfile, and starts the Caddy container.
-func Up(ctx context.Context) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// Prepare creates the network and writes the initial Caddyfile without
+// starting the container, so the watcher can route containers before the
+// gateway exists (lazy startup).
+func Prepare(ctx context.Context) error {
+	cli, err := NewClient()
 	if err != nil {
 		return fmt.Errorf("connecting to Docker: %w", err)
 	}
 	defer cli.Close()
+	return prepare(ctx, cli)
+}
 
+func prepare(ctx context.Context, cli *client.Client) error {
 	if err := EnsureNetwork(ctx, cli); err != nil {
 		return err
 	}
-
 	if err := WriteInitialCaddyfile(); err != nil {
 		return fmt.Errorf("writing initial Caddyfile: %w", err)
 	}
+	return nil
+}
+
+// Up creates the network, writes initial Caddyfile, and starts the Caddy container.
+func Up(ctx context.Context) error {
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	if err := prepare(ctx, cli); err != nil {
+		return err
+	}
+	if err := ensureCaddyfileVolume(ctx, cli); err != nil {
+		return err
+	}
+
+	image, err := configuredImage()
+	if err != nil {
+		return err
+	}
+	hardened, err := hardenedEnabled()
+	if err != nil {
+		return err
+	}
+	listen, err := configuredListen()
+	if err != nil {
+		return err
+	}
+	admin, err := configuredAdmin()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	files := fileRoutes(cfg)
 
 	// Check if container already running
 	if isContainerRunning(ctx, cli) {
 		fmt.Println("Caddy gateway is already running.")
+		if err := checkImage(ctx, cli, image); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			fmt.Println("         Run 'caddy-atc down' and 'caddy-atc up' to recreate it.")
+		}
+		_, httpsPort, err := configuredPorts()
+		if err != nil {
+			return err
+		}
+		if info, err := InspectContainer(ctx, cli, ContainerName); err == nil {
+			if ip, err := publishedIP(info, httpsPort); err != nil {
+				fmt.Printf("Warning: %v.\n", err)
+				fmt.Println("         Run 'caddy-atc down' and 'caddy-atc up' to recreate it.")
+			} else if !sameIP(ip, listen) {
+				fmt.Printf("Warning: gateway listens on %s, not %s.\n", ip, listen)
+				fmt.Println("         Run 'caddy-atc down' and 'caddy-atc up' to recreate it.")
+			}
+		}
+		if info, err := InspectContainer(ctx, cli, ContainerName); err == nil {
+			if missing := missingMounts(info, files); len(missing) > 0 {
+				fmt.Printf("Warning: gateway does not mount %s for %s.\n", missing[0].Root, missing[0].Hostname)
+				fmt.Println("         Run 'caddy-atc down' and 'caddy-atc up' to recreate it.")
+			}
+		}
+		if hardened {
+			if info, err := InspectContainer(ctx, cli, ContainerName); err == nil {
+				if issues := hardeningIssues(info); len(issues) > 0 {
+					fmt.Printf("Warning: gateway is not hardened (%s).\n", strings.Join(issues, "; "))
+					fmt.Println("         Run 'caddy-atc down' and 'caddy-atc up' to apply the hardened profile.")
+				}
+			}
+		}
 		return nil
 	}
 
+	if err := ensurePluginImage(ctx, cli); err != nil {
+		return err
+	}
+
+	httpPort, httpsPort, err := resolvePorts(ctx, cli, listen)
+	if err != nil {
+		return err
+	}
+
 	// Write embedded compose file to temp location and run docker compose up
 	tmpDir, err := os.MkdirTemp("", "caddy-atc-compose-*")
 	if err != nil {
@@ -99,8 +209,43 @@ func Up(ctx context.Context) error {
 		return fmt.Errorf("writing compose file: %w", err)
 	}
 
-	env := append(config.FilterEnv("CADDY_ATC_HOME"), "CADDY_ATC_HOME="+config.HomeDir())
-	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", composePath, "-p", "caddy-atc", "up", "-d")
+	env := append(config.FilterEnv("CADDY_ATC_CADDYFILE", "CADDY_ATC_IMAGE", "CADDY_ATC_UID", "CADDY_ATC_GID", "CADDY_ATC_LISTEN",
+		"CADDY_ATC_HTTP_PORT", "CADDY_ATC_HTTPS_PORT", "CADDY_ATC_NETWORK", "CADDY_ATC_ADMIN"),
+		"CADDY_ATC_CADDYFILE="+config.CaddyfileDir(), "CADDY_ATC_IMAGE="+image, listenEnv(listen), "CADDY_ATC_NETWORK="+Network())
+	env = append(env, portsEnv(httpPort, httpsPort)...)
+	env = append(env, "CADDY_ATC_ADMIN="+admin)
+	args := []string{"compose", "-f", composePath}
+
+	if hardened {
+		userEnv, err := hardenedEnv()
+		if err != nil {
+			return err
+		}
+		env = append(env, userEnv...)
+		hardenedPath := filepath.Join(tmpDir, "docker-compose.hardened.yml")
+		if err := os.WriteFile(hardenedPath, HardenedComposeFile, 0644); err != nil {
+			return fmt.Errorf("writing compose file: %w", err)
+		}
+		if err := chownVolumes(ctx, composePath, env); err != nil {
+			return err
+		}
+		args = append(args, "-f", hardenedPath)
+	}
+
+	if len(files) > 0 {
+		mounts, err := mountsCompose(files)
+		if err != nil {
+			return fmt.Errorf("writing compose file: %w", err)
+		}
+		mountsPath := filepath.Join(tmpDir, "docker-compose.mounts.yml")
+		if err := os.WriteFile(mountsPath, mounts, 0644); err != nil {
+			return fmt.Errorf("writing compose file: %w", err)
+		}
+		args = append(args, "-f", mountsPath)
+	}
+
+	args = append(args, "-p", "caddy-atc", "up", "-d")
+	cmd := exec.CommandContext(ctx, "docker", args...)
 	cmd.Env = env
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -108,13 +253,56 @@ func Up(ctx context.Context) error {
 		return fmt.Errorf("starting Caddy container: %w", err)
 	}
 
+	if err := checkImage(ctx, cli, image); err != nil {
+		if pinnedDigest(image) == "" {
+			fmt.Printf("Warning: %v\n", err)
+		} else {
+			// Never leave an unverified pinned gateway running.
+			Down(ctx)
+			return err
+		}
+	}
+
 	fmt.Println("Caddy gateway started.")
+	warnProxies(ctx, cli)
+	return nil
+}
+
+// ensureCaddyfileVolume removes the gateway and its Caddyfile volume when
+// the volume binds another directory, e.g. ~/.caddy-atc before it moved to
+// the XDG directories, so that compose recreates both on the current one.
+func ensureCaddyfileVolume(ctx context.Context, cli *client.Client) error {
+	vol, err := Call(ctx, func(ctx context.Context) (volume.Volume, error) {
+		return cli.VolumeInspect(ctx, caddyfileVolume)
+	})
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("inspecting Caddyfile volume: %w", err)
+	}
+	if vol.Options["device"] == config.CaddyfileDir() {
+		return nil
+	}
+
+	fmt.Printf("Recreating the gateway to mount %s...\n", config.CaddyfileDir())
+	err = DoWithin(ctx, DockerTimeout()+time.Duration(stopSeconds)*time.Second, func(ctx context.Context) error {
+		return cli.ContainerRemove(ctx, ContainerName, container.RemoveOptions{Force: true})
+	})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("removing container: %w", err)
+	}
+	if err := Do(ctx, func(ctx context.Context) error {
+		return cli.VolumeRemove(ctx, caddyfileVolume, false)
+	}); err != nil {
+		return fmt.Errorf("removing Caddyfile volume: %w", err)
+	}
 	return nil
 }
 
 // Down stops the Caddy container.
 func Down(ctx context.Context) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient()
 	if err != nil {
 		return fmt.Errorf("connecting to Docker: %w", err)
 	}
@@ -126,13 +314,18 @@ func Down(ctx context.Context) error {
 		return nil
 	}
 
-	timeout := 10
-	stopOpts := container.StopOptions{Timeout: &timeout}
-	if err := cli.ContainerStop(ctx, ContainerName, stopOpts); err != nil {
+	stopOpts := container.StopOptions{Timeout: &stopSeconds}
+	err = DoWithin(ctx, DockerTimeout()+time.Duration(stopSeconds)*time.Second, func(ctx context.Context) error {
+		return cli.ContainerStop(ctx, ContainerName, stopOpts)
+	})
+	if err != nil {
 		return fmt.Errorf("stopping container: %w", err)
 	}
 
-	if err := cli.ContainerRemove(ctx, ContainerName, container.RemoveOptions{}); err != nil {
+	err = Do(ctx, func(ctx context.Context) error {
+		return cli.ContainerRemove(ctx, ContainerName, container.RemoveOptions{})
+	})
+	if err != nil {
 		// Container may auto-remove; ignore "not found" errors only
 		if !strings.Contains(err.Error(), "No such container") {
 			return fmt.Errorf("removing container: %w", err)
@@ -147,19 +340,20 @@ func Down(ctx context.Context) error {
 // the container appears running in the Docker API but is actually unresponsive
 // (common after WSL2 sleep/hibernate).
 func Restart(ctx context.Context) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient()
 	if err != nil {
 		return fmt.Errorf("connecting to Docker: %w", err)
 	}
 	defer cli.Close()
 
-	timeout := 10
-	return cli.ContainerRestart(ctx, ContainerName, container.StopOptions{Timeout: &timeout})
+	return DoWithin(ctx, DockerTimeout()+time.Duration(stopSeconds)*time.Second, func(ctx context.Context) error {
+		return cli.ContainerRestart(ctx, ContainerName, container.StopOptions{Timeout: &stopSeconds})
+	})
 }
 
 // IsRunning checks if the gateway is running.
 func IsRunning(ctx context.Context) (bool, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient()
 	if err != nil {
 		return false, fmt.Errorf("connecting to Docker: %w", err)
 	}
@@ -167,32 +361,8 @@ func IsRunning(ctx context.Context) (bool, error) {
 	return isContainerRunning(ctx, cli), nil
 }
 
-// Logs streams the Caddy container logs.
-func Logs(ctx context.Context, follow bool) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return fmt.Errorf("connecting to Docker: %w", err)
-	}
-	defer cli.Close()
-
-	opts := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Follow:     follow,
-		Tail:       "100",
-	}
-	reader, err := cli.ContainerLogs(ctx, ContainerName, opts)
-	if err != nil {
-		return fmt.Errorf("getting container logs: %w", err)
-	}
-	defer reader.Close()
-
-	_, err = io.Copy(os.Stdout, reader)
-	return err
-}
-
 func isContainerRunning(ctx context.Context, cli *client.Client) bool {
-	containers, err := cli.ContainerList(ctx, container.ListOptions{
+	containers, err := ListContainers(ctx, cli, container.ListOptions{
 		Filters: filters.NewArgs(filters.Arg("name", ContainerName)),
 	})
 	if err != nil {
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// hardenedEnabled reports whether projects.yml asks for the hardened profile.
+func hardenedEnabled() (bool, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return false, fmt.Errorf("loading config: %w", err)
+	}
+	return cfg.Gateway != nil && cfg.Gateway.Hardened, nil
+}
+
+// hardenedEnv returns the user the hardened gateway runs as. It is the
+// invoking user so that files caddy-atc writes into the mounted config
+// directory (e.g. 0600 upstream client keys) stay readable.
+func hardenedEnv() ([]string, error) {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		return nil, fmt.Errorf("the hardened gateway runs as the invoking user; run caddy-atc as a non-root user")
+	}
+	return []string{
+		"CADDY_ATC_UID=" + strconv.Itoa(uid),
+		"CADDY_ATC_GID=" + strconv.Itoa(gid),
+	}, nil
+}
+
+// chownVolumes hands the gateway's data and config volumes to the hardened
+// user. It runs a one-off root container from the base compose file (no
+// hardening), so volumes created by earlier root-run gateways stay usable.
+func chownVolumes(ctx context.Context, composePath string, env []string) error {
+	owner := strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", composePath, "-p", "caddy-atc",
+		"run", "--rm", "--no-deps", "--entrypoint", "chown", "caddy", "-R", owner, "/data", "/config")
+	cmd.Env = env
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("preparing volumes for hardened gateway: %w", err)
+	}
+	return nil
+}
+
+// CheckHardening inspects the running gateway and returns the hardened
+// profile settings it lacks. The bool result reports whether the profile is
+// enabled in config; when it is not, no issues are returned.
+func CheckHardening(ctx context.Context) (bool, []string, error) {
+	enabled, err := hardenedEnabled()
+	if err != nil || !enabled {
+		return enabled, nil, err
+	}
+
+	cli, err := NewClient()
+	if err != nil {
+		return true, nil, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	info, err := InspectContainer(ctx, cli, ContainerName)
+	if err != nil {
+		return true, nil, fmt.Errorf("inspecting gateway container: %w", err)
+	}
+	return true, hardeningIssues(info), nil
+}
+
+// hardeningIssues lists hardened-profile settings missing from a container.
+func hardeningIssues(info types.ContainerJSON) []string {
+	var issues []string
+	if info.HostConfig == nil || info.Config == nil {
+		return []string{"container configuration unavailable"}
+	}
+	hc := info.HostConfig
+
+	if !hc.ReadonlyRootfs {
+		issues = append(issues, "root filesystem is writable")
+	}
+	noNewPrivs := false
+	for _, opt := range hc.SecurityOpt {
+		if opt == "no-new-privileges" || opt == "no-new-privileges:true" {
+			noNewPrivs = true
+		}
+	}
+	if !noNewPrivs {
+		issues = append(issues, "no-new-privileges is not set")
+	}
+	dropsAll := false
+	for _, c := range hc.CapDrop {
+		if strings.EqualFold(c, "ALL") {
+			dropsAll = true
+		}
+	}
+	if !dropsAll {
+		issues = append(issues, "capabilities are not dropped")
+	}
+	user, _, _ := strings.Cut(info.Config.User, ":")
+	if user == "" || user == "0" || user == "root" {
+		issues = append(issues, "runs as root")
+	}
+	if _, ok := hc.Tmpfs["/tmp"]; !ok {
+		issues = append(issues, "/tmp is not a tmpfs")
+	}
+	return issues
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func makeInspect(hc *container.HostConfig, user string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{HostConfig: hc},
+		Config:            &container.Config{User: user},
+	}
+}
+
+func TestHardeningIssues_Hardened(t *testing.T) {
+	info := makeInspect(&container.HostConfig{
+		ReadonlyRootfs: true,
+		SecurityOpt:    []string{"no-new-privileges:true"},
+		CapDrop:        []string{"ALL"},
+		Tmpfs:          map[string]string{"/tmp": ""},
+	}, "1000:1000")
+
+	if issues := hardeningIssues(info); len(issues) != 0 {
+		t.Errorf("hardeningIssues() = %v, want none", issues)
+	}
+}
+
+func TestHardeningIssues_Default(t *testing.T) {
+	info := makeInspect(&container.HostConfig{}, "")
+
+	want := []string{
+		"root filesystem is writable",
+		"no-new-privileges is not set",
+		"capabilities are not dropped",
+		"runs as root",
+		"/tmp is not a tmpfs",
+	}
+	if got := hardeningIssues(info); !reflect.DeepEqual(got, want) {
+		t.Errorf("hardeningIssues() = %v, want %v", got, want)
+	}
+}
+
+func TestHardeningIssues_RootUser(t *testing.T) {
+	info := makeInspect(&container.HostConfig{
+		ReadonlyRootfs: true,
+		SecurityOpt:    []string{"no-new-privileges"},
+		CapDrop:        []string{"all"},
+		Tmpfs:          map[string]string{"/tmp": ""},
+	}, "0:0")
+
+	if got := hardeningIssues(info); !reflect.DeepEqual(got, []string{"runs as root"}) {
+		t.Errorf("hardeningIssues() = %v, want [runs as root]", got)
+	}
+}
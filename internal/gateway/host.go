@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+// HostUpstream is the hostname the gateway container uses to reach the
+// Docker host (mapped via extra_hosts with Docker Engine, built in when
+// Docker runs in a VM).
+const HostUpstream = "host.docker.internal"
+
+// HostAddress returns the address a process on the Docker host should bind
+// to so the gateway container (and nothing on the LAN) can reach it, along
+// with the upstream host the gateway should proxy to.
+//
+// When Docker runs in a VM (Docker Desktop, OrbStack, colima), the VM
+// forwards host.docker.internal to the host's loopback, on Linux as well.
+// With Docker Engine it is the caddy-atc bridge network's gateway IP.
+func HostAddress(ctx context.Context) (bindIP string, upstreamHost string, err error) {
+	cli, err := NewClient()
+	if err != nil {
+		return "", "", fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	rt, err := DetectRuntime(ctx, cli)
+	if err != nil {
+		// Docker on macOS always runs in a VM.
+		rt.VM = runtime.GOOS == "darwin"
+	}
+	if rt.VM {
+		return "127.0.0.1", HostUpstream, nil
+	}
+
+	res, err := Call(ctx, func(ctx context.Context) (network.Inspect, error) {
+		return cli.NetworkInspect(ctx, Network(), network.InspectOptions{})
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("inspecting network %s: %w (run 'caddy-atc up' first)", Network(), err)
+	}
+	for _, cfg := range res.IPAM.Config {
+		if ip := net.ParseIP(cfg.Gateway); ip != nil && ip.To4() != nil {
+			return cfg.Gateway, cfg.Gateway, nil
+		}
+	}
+	return "", "", fmt.Errorf("network %s has no IPv4 gateway address", Network())
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// DefaultImage is the gateway image used when none is configured.
+const DefaultImage = config.DefaultImage
+
+// Image returns the image the gateway runs.
+func Image() (string, error) {
+	return configuredImage()
+}
+
+// configuredImage returns the image the gateway runs: CustomImage when
+// plugins are configured, otherwise the base image.
+func configuredImage() (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("loading config: %w", err)
+	}
+	if len(configuredPlugins(cfg)) > 0 {
+		return CustomImage, nil
+	}
+	return baseImage(cfg)
+}
+
+// baseImage returns the configured gateway image, or DefaultImage when
+// unset.
+func baseImage(cfg *config.Config) (string, error) {
+	image := cfg.GatewayImage()
+	if image == "" {
+		return DefaultImage, nil
+	}
+	if err := config.ValidateImage(image); err != nil {
+		return "", fmt.Errorf("gateway image: %w", err)
+	}
+	return image, nil
+}
+
+// pinnedDigest returns the "sha256:..." digest of an image reference, or ""
+// if the reference is not pinned.
+func pinnedDigest(ref string) string {
+	if _, digest, ok := strings.Cut(ref, "@"); ok {
+		return digest
+	}
+	return ""
+}
+
+// checkImage verifies the running gateway container against the configured
+// image: a pinned digest must match exactly, and an image built for another
+// CPU architecture (e.g. amd64 on Apple Silicon) produces a warning since
+// it runs under emulation.
+func checkImage(ctx context.Context, cli *client.Client, ref string) error {
+	info, err := InspectContainer(ctx, cli, ContainerName)
+	if err != nil {
+		return fmt.Errorf("inspecting gateway container: %w", err)
+	}
+	img, err := InspectImage(ctx, cli, info.Image)
+	if err != nil {
+		return fmt.Errorf("inspecting gateway image: %w", err)
+	}
+
+	if digest := pinnedDigest(ref); digest != "" && !hasDigest(img.RepoDigests, digest) {
+		return fmt.Errorf("gateway image digest mismatch: want %s, running %s (%s)",
+			digest, strings.Join(img.RepoDigests, ", "), info.Image)
+	}
+
+	sys, err := Call(ctx, cli.Info)
+	if err == nil && img.Architecture != "" && normalizeArch(sys.Architecture) != normalizeArch(img.Architecture) {
+		fmt.Printf("Warning: gateway image is %s but Docker runs on %s; it will run under emulation.\n",
+			img.Architecture, sys.Architecture)
+		if pinnedDigest(ref) != "" {
+			fmt.Println("         Pin the multi-arch index digest rather than a single-platform manifest digest.")
+		}
+	}
+	return nil
+}
+
+// hasDigest reports whether any "repo@sha256:..." entry carries digest.
+func hasDigest(repoDigests []string, digest string) bool {
+	for _, rd := range repoDigests {
+		if _, d, ok := strings.Cut(rd, "@"); ok && d == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeArch maps kernel (uname -m) and OCI architecture names to the
+// OCI form so they can be compared.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64", "arm64/v8":
+		return "arm64"
+	}
+	return arch
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPinnedDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	if got := pinnedDigest("caddy:2-alpine@" + digest); got != digest {
+		t.Errorf("pinnedDigest() = %q, want %q", got, digest)
+	}
+	if got := pinnedDigest("caddy:2-alpine"); got != "" {
+		t.Errorf("pinnedDigest() = %q, want empty", got)
+	}
+}
+
+func TestHasDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	other := "sha256:" + strings.Repeat("cd", 32)
+	repoDigests := []string{"caddy@" + other, "mirror.example.com/caddy@" + digest}
+
+	if !hasDigest(repoDigests, digest) {
+		t.Error("expected digest to be found")
+	}
+	if hasDigest(repoDigests[:1], digest) {
+		t.Error("expected digest not to be found")
+	}
+	if hasDigest(nil, digest) {
+		t.Error("expected no match for locally built image without repo digests")
+	}
+}
+
+func TestNormalizeArch(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"x86_64", "amd64"},
+		{"amd64", "amd64"},
+		{"aarch64", "arm64"},
+		{"arm64", "arm64"},
+		{"riscv64", "riscv64"},
+	}
+	for _, tt := range tests {
+		if got := normalizeArch(tt.input); got != tt.want {
+			t.Errorf("normalizeArch(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCaddyVersion(t *testing.T) {
+	for output, want := range map[string]string{
+		"v2.10.0 h1:fonubSaQKF1YANl8TXqGcn4IbIRUDdfAkpcsfI/vX5U=\n": "v2.10.0",
+		"v2.9.1\n": "v2.9.1",
+		"":         "(unknown version)",
+	} {
+		if got := caddyVersion(output); got != want {
+			t.Errorf("caddyVersion(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// configuredListen returns the host IP projects.yml publishes the gateway's
+// ports on.
+func configuredListen() (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("loading config: %w", err)
+	}
+	return cfg.GatewayListen()
+}
+
+// configuredPorts returns the gateway's HTTP and HTTPS ports from
+// config.yml.
+func configuredPorts() (int, int, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return 0, 0, fmt.Errorf("loading config: %w", err)
+	}
+	if err := cfg.Settings.Validate(); err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", config.SettingsPath(), err)
+	}
+	http, https := cfg.HTTPPorts()
+	return http, https, nil
+}
+
+// resolvePorts returns the ports to publish the gateway on listen. A
+// default port held by another program is swapped for its fallback, which
+// is saved in config.yml so the watcher and printed URLs follow it. Ports
+// set explicitly are used as they are.
+func resolvePorts(ctx context.Context, cli *client.Client, listen string) (int, int, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return 0, 0, fmt.Errorf("loading config: %w", err)
+	}
+	if err := cfg.Settings.Validate(); err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", config.SettingsPath(), err)
+	}
+	http, https := cfg.HTTPPorts()
+
+	newHTTP, newHTTPS := http, https
+	if cfg.Settings.HTTPPort == 0 {
+		if newHTTP, err = pickPort(listen, http, config.FallbackHTTPPort, "http_port"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if cfg.Settings.HTTPSPort == 0 {
+		if newHTTPS, err = pickPort(listen, https, config.FallbackHTTPSPort, "https_port"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if newHTTP == http && newHTTPS == https {
+		return http, https, nil
+	}
+
+	if err := config.ModifySettings(func(s *config.Settings) error {
+		if newHTTP != http {
+			s.HTTPPort = newHTTP
+		}
+		if newHTTPS != https {
+			s.HTTPSPort = newHTTPS
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+	for _, p := range []struct {
+		key      string
+		from, to int
+	}{{"http_port", http, newHTTP}, {"https_port", https, newHTTPS}} {
+		if p.from != p.to {
+			owner := FindPortOwner(ctx, cli, p.from)
+			fmt.Printf("Port %d is in use by %s; the gateway uses %d instead (saved in config.yml).\n", p.from, owner, p.to)
+			fmt.Printf("         To free it, %s.\n", owner.Hint())
+			fmt.Printf("         Once it is free, run 'caddy-atc config set %s \"\"' and 'caddy-atc down && caddy-atc up'.\n", p.key)
+		}
+	}
+	return newHTTP, newHTTPS, nil
+}
+
+// pickPort returns port, or fallback when something already accepts
+// connections on port at listen.
+func pickPort(listen string, port, fallback int, key string) (int, error) {
+	if !portInUse(listen, port) {
+		return port, nil
+	}
+	if portInUse(listen, fallback) {
+		return 0, fmt.Errorf("ports %d and %d are both in use by other programs; choose another with 'caddy-atc up --%s <port>'",
+			port, fallback, strings.ReplaceAll(key, "_", "-"))
+	}
+	return fallback, nil
+}
+
+// portInUse reports whether something accepts connections on port at
+// listen. An unspecified address is probed on loopback.
+func portInUse(listen string, port int) bool {
+	host := listen
+	if ip := net.ParseIP(listen); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// configuredAdmin returns the address of Caddy's admin API in the gateway.
+func configuredAdmin() (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("loading config: %w", err)
+	}
+	if admin := cfg.AdminAddress(); admin != "" {
+		if err := config.ValidateAdminAddress(admin); err != nil {
+			return "", fmt.Errorf("admin_address: %w", err)
+		}
+		return admin, nil
+	}
+	return config.DefaultAdminAddress, nil
+}
+
+// portsEnv returns the compose variables publishing the gateway on the
+// HTTP and HTTPS ports.
+func portsEnv(http, https int) []string {
+	return []string{"CADDY_ATC_HTTP_PORT=" + strconv.Itoa(http), "CADDY_ATC_HTTPS_PORT=" + strconv.Itoa(https)}
+}
+
+// listenEnv returns the compose variable publishing the gateway's ports on
+// ip. IPv6 addresses are bracketed, as the ports syntax requires.
+func listenEnv(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		ip = "[" + ip + "]"
+	}
+	return "CADDY_ATC_LISTEN=" + ip
+}
+
+// PublishedIP returns the host IP the running gateway's HTTPS port is
+// published on.
+func PublishedIP(ctx context.Context) (string, error) {
+	cli, err := NewClient()
+	if err != nil {
+		return "", fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+	_, https, err := configuredPorts()
+	if err != nil {
+		return "", err
+	}
+	info, err := InspectContainer(ctx, cli, ContainerName)
+	if err != nil {
+		return "", fmt.Errorf("inspecting gateway container: %w", err)
+	}
+	return publishedIP(info, https)
+}
+
+func publishedIP(info types.ContainerJSON, httpsPort int) (string, error) {
+	if info.HostConfig != nil {
+		if bindings := info.HostConfig.PortBindings[nat.Port(strconv.Itoa(httpsPort)+"/tcp")]; len(bindings) > 0 {
+			if bindings[0].HostIP == "" {
+				return "0.0.0.0", nil
+			}
+			return bindings[0].HostIP, nil
+		}
+	}
+	return "", fmt.Errorf("gateway does not publish port %d", httpsPort)
+}
+
+// sameIP reports whether two listen addresses are the same IP.
+func sameIP(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipA.Equal(ipB)
+}
+
+// IsLoopback reports whether the gateway listening on ip is unreachable
+// from other devices.
+func IsLoopback(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsLoopback()
+}
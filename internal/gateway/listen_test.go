@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestListenEnv(t *testing.T) {
+	for ip, want := range map[string]string{
+		"127.0.0.1": "CADDY_ATC_LISTEN=127.0.0.1",
+		"0.0.0.0":   "CADDY_ATC_LISTEN=0.0.0.0",
+		"::":        "CADDY_ATC_LISTEN=[::]",
+		"fd00::1":   "CADDY_ATC_LISTEN=[fd00::1]",
+	} {
+		if got := listenEnv(ip); got != want {
+			t.Errorf("listenEnv(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestPublishedIP(t *testing.T) {
+	bound := func(ip string) *container.HostConfig {
+		return &container.HostConfig{PortBindings: nat.PortMap{
+			"443/tcp": {{HostIP: ip, HostPort: "443"}},
+		}}
+	}
+	tests := []struct {
+		name    string
+		hc      *container.HostConfig
+		want    string
+		wantErr bool
+	}{
+		{"loopback", bound("127.0.0.1"), "127.0.0.1", false},
+		{"all interfaces", bound(""), "0.0.0.0", false},
+		{"not published", &container.HostConfig{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := publishedIP(makeInspect(tt.hc, ""), 443)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publishedIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("publishedIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickPort(t *testing.T) {
+	listenOn := func() (net.Listener, int) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		return ln, ln.Addr().(*net.TCPAddr).Port
+	}
+	freePort := func() int {
+		ln, port := listenOn()
+		ln.Close()
+		return port
+	}
+
+	_, busy := listenOn()
+	_, busyFallback := listenOn()
+	free, freeFallback := freePort(), freePort()
+
+	if got, err := pickPort("127.0.0.1", free, freeFallback, "http_port"); err != nil || got != free {
+		t.Errorf("free port: got %d, %v; want %d", got, err, free)
+	}
+	if got, err := pickPort("127.0.0.1", busy, freeFallback, "http_port"); err != nil || got != freeFallback {
+		t.Errorf("busy port: got %d, %v; want fallback %d", got, err, freeFallback)
+	}
+	if got, err := pickPort("0.0.0.0", busy, freeFallback, "http_port"); err != nil || got != freeFallback {
+		t.Errorf("busy port on all interfaces: got %d, %v; want fallback %d", got, err, freeFallback)
+	}
+	if _, err := pickPort("127.0.0.1", busy, busyFallback, "https_port"); err == nil || !strings.Contains(err.Error(), "--https-port") {
+		t.Errorf("both busy: got %v, want error naming --https-port", err)
+	}
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// localCADir is where Caddy keeps the certificates and keys of the local CA
+// it generated.
+const localCADir = "/data/caddy/pki/authorities/local"
+
+// LocalCA returns the files of the gateway's generated CA, keyed by name
+// (root.crt, root.key, intermediate.crt, intermediate.key).
+func LocalCA(ctx context.Context) (map[string][]byte, error) {
+	cli, err := NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	if !isContainerRunning(ctx, cli) {
+		return nil, fmt.Errorf("caddy gateway is not running - run 'caddy-atc up' first")
+	}
+	return Call(ctx, func(ctx context.Context) (map[string][]byte, error) {
+		reader, _, err := cli.CopyFromContainer(ctx, ContainerName, localCADir)
+		if err != nil {
+			return nil, fmt.Errorf("extracting local CA: %w", err)
+		}
+		defer reader.Close()
+
+		files := make(map[string][]byte)
+		tr := tar.NewReader(reader)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading local CA archive: %w", err)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, io.LimitReader(tr, maxCertSize+1)); err != nil {
+				return nil, fmt.Errorf("reading local CA archive: %w", err)
+			}
+			if buf.Len() > maxCertSize {
+				return nil, fmt.Errorf("local CA file %s too large (>%d bytes)", hdr.Name, maxCertSize)
+			}
+			files[path.Base(hdr.Name)] = buf.Bytes()
+		}
+		if _, ok := files["root.crt"]; !ok {
+			return nil, fmt.Errorf("the gateway has not generated its local CA yet")
+		}
+		return files, nil
+	})
+}
+
+// RestoreLocalCA replaces the gateway's generated CA with files from
+// LocalCA, then discards the certificates it issued and restarts it, so
+// every site gets a certificate from the restored CA.
+func RestoreLocalCA(ctx context.Context, files map[string][]byte) error {
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	if !isContainerRunning(ctx, cli) {
+		return fmt.Errorf("caddy gateway is not running - run 'caddy-atc up' first")
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "local/", Typeflag: tar.TypeDir, Mode: 0700}); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if name != path.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("invalid local CA file name %q", name)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: "local/" + name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	// The files take the container user's ownership, so a hardened
+	// gateway running unprivileged can still read its keys.
+	if err := Do(ctx, func(ctx context.Context) error {
+		return cli.CopyToContainer(ctx, ContainerName, path.Dir(localCADir), &buf, container.CopyToContainerOptions{CopyUIDGID: true})
+	}); err != nil {
+		return fmt.Errorf("restoring local CA: %w", err)
+	}
+	return discardIssuedCerts(ctx)
+}
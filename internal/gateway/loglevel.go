@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// LogLevel returns the gateway's configured log level.
+func LogLevel() (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("loading config: %w", err)
+	}
+	if level := cfg.GatewayLogLevel(); level != "" {
+		return level, nil
+	}
+	return "info", nil
+}
+
+// SetLogLevel records the gateway log level in config.yml. The running
+// watcher renders it into the Caddyfile's global options and reloads Caddy,
+// so the change survives later reloads and needs no container restart.
+func SetLogLevel(level string) error {
+	if err := config.ValidateLogLevel(level); err != nil {
+		return err
+	}
+	return config.ModifySettings(func(s *config.Settings) error {
+		// Kept even for "info", so it overrides a level left in projects.yml.
+		s.LogLevel = level
+		return nil
+	})
+}
@@ -0,0 +1,36 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if err := SetLogLevel("debug"); err != nil {
+		t.Fatalf("SetLogLevel(debug) error = %v", err)
+	}
+	if level, err := LogLevel(); err != nil || level != "debug" {
+		t.Errorf("LogLevel() = %q, %v, want debug", level, err)
+	}
+
+	if err := SetLogLevel("info"); err != nil {
+		t.Fatalf("SetLogLevel(info) error = %v", err)
+	}
+	if level, err := LogLevel(); err != nil || level != "info" {
+		t.Errorf("LogLevel() = %q, %v, want info", level, err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Gateway != nil {
+		t.Errorf("Gateway = %+v, want nil after reverting to the default", cfg.Gateway)
+	}
+
+	if err := SetLogLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// AccessLogDir is where the gateway writes per-host JSON access logs,
+// inside its data volume.
+const AccessLogDir = "/data/access"
+
+// logTail is how many lines are shown before following.
+const logTail = "100"
+
+// AccessLogPath returns the in-container access log file for a hostname.
+// Wildcard hostnames map "*" to "_", as for upstream certificates.
+func AccessLogPath(hostname string) string {
+	return AccessLogDir + "/" + strings.ReplaceAll(hostname, "*", "_") + ".log"
+}
+
+// Logs writes the Caddy container's stdout and stderr to w.
+func Logs(ctx context.Context, w io.Writer, follow bool) error {
+	return containerLogs(ctx, w, container.LogsOptions{Follow: follow, Tail: logTail})
+}
+
+// TimestampedLogs writes the Caddy container's stdout and stderr to w like
+// Logs, each line prefixed with Docker's RFC 3339 timestamp and a space.
+// With a non-zero since, only output after it is written, all of it.
+func TimestampedLogs(ctx context.Context, w io.Writer, follow bool, since time.Time) error {
+	opts := container.LogsOptions{Follow: follow, Tail: logTail, Timestamps: true}
+	if !since.IsZero() {
+		opts.Tail = "all"
+		opts.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+	return containerLogs(ctx, w, opts)
+}
+
+func containerLogs(ctx context.Context, w io.Writer, opts container.LogsOptions) error {
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	opts.ShowStdout, opts.ShowStderr = true, true
+	reader, err := cli.ContainerLogs(ctx, ContainerName, opts)
+	if err != nil {
+		return fmt.Errorf("getting container logs: %w", err)
+	}
+	defer reader.Close()
+
+	// The container has no TTY, so the stream is multiplexed.
+	_, err = stdcopy.StdCopy(w, w, reader)
+	return err
+}
+
+// AccessLogs writes the access logs of the given hostnames, or of every
+// host when none are given, to w: the last 100 lines of each, or the whole
+// files if all. Files live in the gateway's data volume, so they are read
+// through docker exec.
+func AccessLogs(ctx context.Context, w io.Writer, hostnames []string, follow, all bool) error {
+	lines := logTail
+	if all {
+		lines = "+1"
+	}
+	args := []string{"exec", ContainerName, "tail", "-n", lines}
+	if follow {
+		// -F keeps retrying files that don't exist yet or get rolled.
+		args = append(args, "-F")
+	}
+
+	if len(hostnames) == 0 {
+		// Expand the glob inside the container; paths come from this
+		// package, never from user input.
+		args = []string{"exec", ContainerName, "sh", "-c",
+			"tail " + strings.Join(args[3:], " ") + " " + AccessLogDir + "/*.log"}
+	} else {
+		for _, h := range hostnames {
+			args = append(args, AccessLogPath(h))
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("reading access logs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// fileRoutes returns the valid static routes served from a host directory.
+func fileRoutes(cfg *config.Config) []*config.StaticRoute {
+	var routes []*config.StaticRoute
+	for _, r := range cfg.StaticRoutes {
+		if r.Root != "" && r.Validate() == nil {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// mountsCompose returns a compose override binding each file route's root
+// read-only at its mount path in the gateway.
+func mountsCompose(routes []*config.StaticRoute) ([]byte, error) {
+	type bind struct {
+		Type     string `yaml:"type"`
+		Source   string `yaml:"source"`
+		Target   string `yaml:"target"`
+		ReadOnly bool   `yaml:"read_only"`
+	}
+	volumes := make([]bind, len(routes))
+	for i, r := range routes {
+		volumes[i] = bind{Type: "bind", Source: r.Root, Target: r.MountPath(), ReadOnly: true}
+	}
+	override := map[string]any{
+		"services": map[string]any{
+			"caddy": map[string]any{"volumes": volumes},
+		},
+	}
+	return yaml.Marshal(override)
+}
+
+// missingMounts returns the file routes whose root the container doesn't
+// have mounted at its mount path.
+func missingMounts(info types.ContainerJSON, routes []*config.StaticRoute) []*config.StaticRoute {
+	mounted := make(map[string]string, len(info.Mounts))
+	for _, m := range info.Mounts {
+		mounted[m.Destination] = m.Source
+	}
+	var missing []*config.StaticRoute
+	for _, r := range routes {
+		if mounted[r.MountPath()] != r.Root {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// SyncMounts recreates a running gateway that lacks the mount of a file
+// route's root directory. Mounts of removed routes are left until the
+// gateway is next recreated, since they are harmless.
+func SyncMounts(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	routes := fileRoutes(cfg)
+	if len(routes) == 0 {
+		return nil
+	}
+
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	if !isContainerRunning(ctx, cli) {
+		return nil
+	}
+	info, err := InspectContainer(ctx, cli, ContainerName)
+	if err != nil {
+		return fmt.Errorf("inspecting gateway container: %w", err)
+	}
+	missing := missingMounts(info, routes)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Recreating the gateway to mount %s (certificates and the local CA are kept)...\n", missing[0].Root)
+	if err := Down(ctx); err != nil {
+		return err
+	}
+	return Up(ctx)
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestMountsCompose(t *testing.T) {
+	routes := []*config.StaticRoute{{Hostname: "docs.localhost", Root: "/home/dev/my site/dist"}}
+	got, err := mountsCompose(routes)
+	if err != nil {
+		t.Fatalf("mountsCompose() error = %v", err)
+	}
+	for _, want := range []string{
+		"source: /home/dev/my site/dist",
+		"target: /srv/caddy-atc/docs.localhost",
+		"read_only: true",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("expected %q in override:\n%s", want, got)
+		}
+	}
+}
+
+func TestMissingMounts(t *testing.T) {
+	routes := []*config.StaticRoute{
+		{Hostname: "docs.localhost", Root: "/home/dev/docs"},
+		{Hostname: "book.localhost", Root: "/home/dev/storybook"},
+	}
+	info := types.ContainerJSON{Mounts: []types.MountPoint{
+		{Source: "/home/dev/docs", Destination: "/srv/caddy-atc/docs.localhost"},
+		{Source: "/home/dev/old", Destination: "/srv/caddy-atc/book.localhost"},
+	}}
+	missing := missingMounts(info, routes)
+	if len(missing) != 1 || missing[0].Hostname != "book.localhost" {
+		t.Errorf("missingMounts() = %+v, want only book.localhost", missing)
+	}
+}
@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// CustomImage tags the gateway image built with the configured plugins.
+const CustomImage = "caddy-atc/caddy:custom"
+
+// buildLabel records what CustomImage was built from, so a stale build is
+// noticed and rebuilt.
+const buildLabel = "caddy-atc.build"
+
+// configuredPlugins returns the plugins in projects.yml.
+func configuredPlugins(cfg *config.Config) []string {
+	if cfg.Gateway == nil {
+		return nil
+	}
+	return cfg.Gateway.Plugins
+}
+
+// Plugins returns the plugins built into the gateway.
+func Plugins() ([]string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	return configuredPlugins(cfg), nil
+}
+
+// AddPlugins builds the gateway image with plugins added to the installed
+// ones and switches a running gateway to it. A plugin whose module is
+// already installed replaces it, so versions can be changed. projects.yml
+// is only updated once the build succeeds.
+func AddPlugins(ctx context.Context, plugins []string) error {
+	for _, p := range plugins {
+		if err := config.ValidatePlugin(p); err != nil {
+			return err
+		}
+	}
+	return changePlugins(ctx, func(installed []string) []string {
+		return addPlugins(installed, plugins)
+	})
+}
+
+// RemovePlugins rebuilds the gateway image without plugins, given by module
+// path, and switches a running gateway to it. Removing the last plugin
+// returns the gateway to its base image.
+func RemovePlugins(ctx context.Context, modules []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	for _, m := range modules {
+		if !hasPlugin(configuredPlugins(cfg), m) {
+			return fmt.Errorf("plugin %s is not installed", m)
+		}
+	}
+	return changePlugins(ctx, func(installed []string) []string {
+		return removePlugins(installed, modules)
+	})
+}
+
+func changePlugins(ctx context.Context, change func([]string) []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	base, err := baseImage(cfg)
+	if err != nil {
+		return err
+	}
+	installed := configuredPlugins(cfg)
+	plugins := change(installed)
+	if strings.Join(plugins, " ") == strings.Join(installed, " ") {
+		fmt.Println("The gateway already has these plugins.")
+		return nil
+	}
+
+	image := base
+	if len(plugins) > 0 {
+		if err := buildImage(ctx, base, plugins, false); err != nil {
+			return err
+		}
+		image = CustomImage
+	}
+	if err := config.LoadAndModify(func(c *config.Config) error {
+		if c.Gateway == nil {
+			c.Gateway = &config.GatewayConfig{}
+		}
+		c.Gateway.Plugins = plugins
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+	return switchImage(ctx, cli, image)
+}
+
+// addPlugins returns installed with plugins added, replacing any of the
+// same module.
+func addPlugins(installed, plugins []string) []string {
+	result := append([]string(nil), installed...)
+	for _, p := range plugins {
+		replaced := false
+		for i, q := range result {
+			if config.PluginModule(q) == config.PluginModule(p) {
+				result[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// removePlugins returns installed without the plugins of modules.
+func removePlugins(installed, modules []string) []string {
+	var result []string
+	for _, p := range installed {
+		if !hasPlugin(modules, config.PluginModule(p)) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// hasPlugin reports whether plugins include module, with any version.
+func hasPlugin(plugins []string, module string) bool {
+	for _, p := range plugins {
+		if config.PluginModule(p) == config.PluginModule(module) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildImage builds CustomImage from base with plugins using the matching
+// xcaddy builder image, inside Docker. pull refreshes both base images.
+func buildImage(ctx context.Context, base string, plugins []string, pull bool) error {
+	builder, err := builderImage(base)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"build", "-t", CustomImage, "--label", buildLabel + "=" + buildID(base, plugins)}
+	if pull {
+		args = append(args, "--pull")
+	}
+	args = append(args, "-")
+	fmt.Printf("Building %s with %s...\n", CustomImage, strings.Join(plugins, ", "))
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = strings.NewReader(dockerfile(builder, base, plugins))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("building %s: %w", CustomImage, err)
+	}
+	return nil
+}
+
+// ensurePluginImage builds CustomImage if plugins are configured and it is
+// missing or was built from other settings, e.g. after projects.yml was
+// edited by hand or the image was pruned.
+func ensurePluginImage(ctx context.Context, cli *client.Client) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	plugins := configuredPlugins(cfg)
+	if len(plugins) == 0 {
+		return nil
+	}
+	for _, p := range plugins {
+		if err := config.ValidatePlugin(p); err != nil {
+			return fmt.Errorf("gateway plugins: %w", err)
+		}
+	}
+	base, err := baseImage(cfg)
+	if err != nil {
+		return err
+	}
+	if img, err := InspectImage(ctx, cli, CustomImage); err == nil && img.Config != nil &&
+		img.Config.Labels[buildLabel] == buildID(base, plugins) {
+		return nil
+	}
+	return buildImage(ctx, base, plugins, false)
+}
+
+// buildID identifies a build of base with plugins.
+func buildID(base string, plugins []string) string {
+	return strings.Join(append([]string{base}, plugins...), " ")
+}
+
+// builderImage returns the official xcaddy builder image matching a Caddy
+// image, e.g. "caddy:2.10-builder-alpine" for "caddy:2.10-alpine". Mirrors
+// of the official image are supported as long as they carry the builder
+// tags too.
+func builderImage(base string) (string, error) {
+	ref, _, _ := strings.Cut(base, "@")
+	repo, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo, tag = ref[:i], ref[i+1:]
+	}
+	if path.Base(repo) != "caddy" {
+		return "", fmt.Errorf("plugins need an official caddy base image, not %s", base)
+	}
+	switch {
+	case tag == "latest":
+		tag = "builder"
+	case tag == "alpine":
+		tag = "builder-alpine"
+	case strings.HasSuffix(tag, "-alpine"):
+		tag = strings.TrimSuffix(tag, "-alpine") + "-builder-alpine"
+	case strings.Contains(tag, "windows") || strings.Contains(tag, "nanoserver"):
+		return "", fmt.Errorf("plugins are not supported on Windows images such as %s", base)
+	default:
+		tag += "-builder"
+	}
+	return repo + ":" + tag, nil
+}
+
+// dockerfile returns a Dockerfile that builds Caddy with plugins in builder
+// and copies the binary into base.
+func dockerfile(builder, base string, plugins []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s AS builder\n", builder)
+	b.WriteString("RUN xcaddy build")
+	for _, p := range plugins {
+		b.WriteString(" \\\n    --with " + p)
+	}
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "FROM %s\n", base)
+	b.WriteString("COPY --from=builder /usr/bin/caddy /usr/bin/caddy\n")
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilderImage(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		base    string
+		want    string
+		wantErr bool
+	}{
+		{"caddy:2-alpine", "caddy:2-builder-alpine", false},
+		{"caddy:2.10-alpine@" + digest, "caddy:2.10-builder-alpine", false},
+		{"caddy:2.10", "caddy:2.10-builder", false},
+		{"caddy", "caddy:builder", false},
+		{"caddy:alpine", "caddy:builder-alpine", false},
+		{"registry.example.com:5000/mirror/caddy:2-alpine", "registry.example.com:5000/mirror/caddy:2-builder-alpine", false},
+		{"caddy:2-windowsservercore", "", true},
+		{"ghcr.io/example/proxy:1", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			got, err := builderImage(tt.base)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("builderImage(%q) = %q, %v; want %q", tt.base, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerfile(t *testing.T) {
+	got := dockerfile("caddy:2-builder-alpine", "caddy:2-alpine",
+		[]string{"github.com/mholt/caddy-l4", "github.com/caddy-dns/cloudflare@v0.2.1"})
+	want := `FROM caddy:2-builder-alpine AS builder
+RUN xcaddy build \
+    --with github.com/mholt/caddy-l4 \
+    --with github.com/caddy-dns/cloudflare@v0.2.1
+
+FROM caddy:2-alpine
+COPY --from=builder /usr/bin/caddy /usr/bin/caddy
+`
+	if got != want {
+		t.Errorf("dockerfile() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAddRemovePlugins(t *testing.T) {
+	installed := []string{"github.com/mholt/caddy-l4", "github.com/caddy-dns/cloudflare@v0.1.0"}
+
+	got := addPlugins(installed, []string{"github.com/caddy-dns/cloudflare@v0.2.1", "github.com/corazawaf/coraza-caddy/v2"})
+	want := []string{"github.com/mholt/caddy-l4", "github.com/caddy-dns/cloudflare@v0.2.1", "github.com/corazawaf/coraza-caddy/v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("addPlugins() = %v, want %v", got, want)
+	}
+	if installed[1] != "github.com/caddy-dns/cloudflare@v0.1.0" {
+		t.Error("addPlugins() modified its input")
+	}
+
+	got = removePlugins(installed, []string{"github.com/caddy-dns/cloudflare"})
+	if !reflect.DeepEqual(got, []string{"github.com/mholt/caddy-l4"}) {
+		t.Errorf("removePlugins() = %v", got)
+	}
+	if got := removePlugins(installed, []string{"github.com/mholt/caddy-l4", "github.com/caddy-dns/cloudflare"}); got != nil {
+		t.Errorf("removePlugins(all) = %v, want nil", got)
+	}
+}
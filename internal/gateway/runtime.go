@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// Docker installations whose networking caddy-atc adapts to.
+const (
+	RuntimeEngine   = "Docker Engine"
+	RuntimeDesktop  = "Docker Desktop"
+	RuntimeOrbStack = "OrbStack"
+	RuntimeColima   = "colima"
+	RuntimeRancher  = "Rancher Desktop"
+)
+
+// Runtime describes the Docker installation the gateway runs on.
+type Runtime struct {
+	Name    string // one of the Runtime constants
+	Version string // the daemon's version
+
+	// VM is set when containers run in a virtual machine, which forwards
+	// published ports to this machine and host.docker.internal to its
+	// loopback. Otherwise containers share the host's network stack.
+	VM bool
+}
+
+// HostGateway reports whether host.docker.internal resolves in the
+// gateway: VMs provide it, and Docker Engine maps it through the
+// host-gateway extra host since 20.10.
+func (r Runtime) HostGateway() bool {
+	if r.VM {
+		return true
+	}
+	major, minor, ok := parseVersion(r.Version)
+	return !ok || major > 20 || major == 20 && minor >= 10
+}
+
+// String names the runtime with its version, e.g. "Docker Engine 27.5.1".
+func (r Runtime) String() string {
+	if r.Version == "" || r.Name != RuntimeEngine {
+		return r.Name
+	}
+	return r.Name + " " + r.Version
+}
+
+// DetectRuntime asks the daemon which Docker installation it is.
+func DetectRuntime(ctx context.Context, cli *client.Client) (Runtime, error) {
+	info, err := Call(ctx, cli.Info)
+	if err != nil {
+		return Runtime{}, fmt.Errorf("querying Docker: %w", err)
+	}
+	return classifyRuntime(info.OperatingSystem, info.Name, info.ServerVersion), nil
+}
+
+// classifyRuntime identifies the runtime from the daemon's reported
+// operating system and host name.
+func classifyRuntime(operatingSystem, name, version string) Runtime {
+	operatingSystem, name = strings.ToLower(operatingSystem), strings.ToLower(name)
+	rt := Runtime{Name: RuntimeEngine, Version: version}
+	switch {
+	case strings.Contains(operatingSystem, "docker desktop") || name == "docker-desktop":
+		rt.Name, rt.VM = RuntimeDesktop, true
+	case strings.Contains(operatingSystem, "orbstack") || name == "orbstack":
+		rt.Name, rt.VM = RuntimeOrbStack, true
+	case strings.Contains(name, "rancher-desktop"):
+		rt.Name, rt.VM = RuntimeRancher, true
+	case name == "colima" || strings.HasPrefix(name, "colima-"):
+		rt.Name, rt.VM = RuntimeColima, true
+	}
+	return rt
+}
+
+// parseVersion returns the major and minor numbers of a version such as
+// "27.5.1".
+func parseVersion(v string) (int, int, bool) {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
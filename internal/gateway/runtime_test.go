@@ -0,0 +1,41 @@
+package gateway
+
+import "testing"
+
+func TestClassifyRuntime(t *testing.T) {
+	tests := []struct {
+		os, name string
+		want     string
+		vm       bool
+	}{
+		{"Docker Desktop", "docker-desktop", RuntimeDesktop, true},
+		{"OrbStack", "orbstack", RuntimeOrbStack, true},
+		{"Ubuntu 24.04 LTS", "colima", RuntimeColima, true},
+		{"Ubuntu 24.04 LTS", "colima-work", RuntimeColima, true},
+		{"Alpine Linux v3.20", "lima-rancher-desktop", RuntimeRancher, true},
+		{"Debian GNU/Linux 12 (bookworm)", "devbox", RuntimeEngine, false},
+	}
+	for _, tt := range tests {
+		rt := classifyRuntime(tt.os, tt.name, "27.5.1")
+		if rt.Name != tt.want || rt.VM != tt.vm {
+			t.Errorf("classifyRuntime(%q, %q) = %+v, want %s (VM %v)", tt.os, tt.name, rt, tt.want, tt.vm)
+		}
+	}
+}
+
+func TestRuntimeHostGateway(t *testing.T) {
+	for version, want := range map[string]bool{
+		"27.5.1":   true,
+		"20.10.24": true,
+		"20.10":    true,
+		"19.03.12": false,
+		"dev":      true,
+	} {
+		if got := (Runtime{Name: RuntimeEngine, Version: version}).HostGateway(); got != want {
+			t.Errorf("HostGateway() for Docker Engine %s = %v, want %v", version, got, want)
+		}
+	}
+	if !(Runtime{Name: RuntimeDesktop, Version: "19.03.12", VM: true}).HostGateway() {
+		t.Error("HostGateway() = false for a VM, want true")
+	}
+}
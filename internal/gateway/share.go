@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// TunnelProvider is a tunnel client run in a container on the caddy-atc
+// network, so it reaches upstream containers directly without published
+// ports.
+type TunnelProvider struct {
+	Name  string
+	Image string
+
+	// Command returns the client's arguments for tunneling to upstream
+	// ("container:port").
+	Command func(upstream string) []string
+
+	// Env lists variables the client needs from the environment, such as
+	// an auth token.
+	Env []string
+
+	// URL matches the public URL in the client's output; the first
+	// submatch, if any, is the URL.
+	URL *regexp.Regexp
+}
+
+// TunnelProviders are the tunnel clients `caddy-atc share` can run, by
+// name.
+var TunnelProviders = map[string]*TunnelProvider{
+	"cloudflared": {
+		Name:  "cloudflared",
+		Image: "cloudflare/cloudflared:latest",
+		Command: func(upstream string) []string {
+			return []string{"tunnel", "--no-autoupdate", "--url", "http://" + upstream}
+		},
+		URL: regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`),
+	},
+	"ngrok": {
+		Name:  "ngrok",
+		Image: "ngrok/ngrok:latest",
+		Command: func(upstream string) []string {
+			return []string{"http", "http://" + upstream, "--log", "stdout", "--log-format", "logfmt"}
+		},
+		Env: []string{"NGROK_AUTHTOKEN"},
+		URL: regexp.MustCompile(`url=(https://\S+)`),
+	},
+	"localtunnel": {
+		Name:  "localtunnel",
+		Image: "node:lts-alpine",
+		Command: func(upstream string) []string {
+			host, port, _ := strings.Cut(upstream, ":")
+			return []string{"npx", "--yes", "localtunnel", "--port", port, "--local-host", host}
+		},
+		URL: regexp.MustCompile(`your url is: (https://\S+)`),
+	},
+}
+
+// DefaultTunnelProvider needs no account.
+const DefaultTunnelProvider = "cloudflared"
+
+// TunnelProviderNames returns the names of TunnelProviders, sorted.
+func TunnelProviderNames() []string {
+	names := make([]string, 0, len(TunnelProviders))
+	for name := range TunnelProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// publicURL returns the public URL in a line of the client's output, or "".
+func (p *TunnelProvider) publicURL(line string) string {
+	m := p.URL.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	default:
+		return m[0]
+	}
+}
+
+// Share tunnels to upstream ("container:port") with provider until ctx is
+// done, calling onURL with the public URL once the tunnel is up. The
+// client's container is removed on return.
+func Share(ctx context.Context, provider *TunnelProvider, hostname, upstream string, onURL func(string)) error {
+	if err := config.ValidateHostname(hostname); err != nil {
+		return err
+	}
+	for _, name := range provider.Env {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("%s needs %s set in the environment", provider.Name, name)
+		}
+	}
+
+	name := "caddy-atc-share-" + strings.TrimPrefix(hostname, "*.")
+	args := []string{"run", "--rm", "--name", name, "--network", Network(),
+		"--label", config.IgnoreLabel + "=true",
+		// Static routes may point at the host, as they do for the gateway.
+		"--add-host", "host.docker.internal:host-gateway"}
+	for _, env := range provider.Env {
+		args = append(args, "-e", env)
+	}
+	args = append(args, provider.Image)
+	args = append(args, provider.Command(upstream)...)
+
+	cmd := exec.Command("docker", args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", provider.Name, err)
+	}
+
+	// Killing `docker run` leaves the container running; remove it.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			exec.Command("docker", "rm", "-f", name).Run()
+		case <-stopped:
+		}
+	}()
+
+	last, found := scanTunnelOutput(out, provider, onURL)
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if !found {
+		return fmt.Errorf("%s exited before the tunnel was up: %s", provider.Name, last)
+	}
+	if err != nil {
+		return fmt.Errorf("%s exited: %w: %s", provider.Name, err, last)
+	}
+	return fmt.Errorf("%s exited: %s", provider.Name, last)
+}
+
+// scanTunnelOutput reads the client's output until it ends, calling onURL
+// with the first public URL found. It returns the last non-empty line and
+// whether a URL was found.
+func scanTunnelOutput(r io.Reader, provider *TunnelProvider, onURL func(string)) (string, bool) {
+	var last string
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		last = line
+		if !found {
+			if url := provider.publicURL(line); url != "" {
+				found = true
+				onURL(url)
+			}
+		}
+	}
+	return last, found
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTunnelProviders_PublicURL(t *testing.T) {
+	tests := []struct {
+		provider string
+		line     string
+		want     string
+	}{
+		{"cloudflared", "2026-03-01T12:00:00Z INF |  https://quiet-river-fox.trycloudflare.com                    |", "https://quiet-river-fox.trycloudflare.com"},
+		{"cloudflared", "2026-03-01T12:00:00Z INF Requesting new quick Tunnel on trycloudflare.com...", ""},
+		{"ngrok", `t=2026-03-01T12:00:00+0000 lvl=info msg="started tunnel" obj=tunnels name=command_line addr=http://app-web-1:3000 url=https://1a2b-203-0-113-7.ngrok-free.app`, "https://1a2b-203-0-113-7.ngrok-free.app"},
+		{"localtunnel", "your url is: https://odd-cats-jump.loca.lt", "https://odd-cats-jump.loca.lt"},
+	}
+	for _, tt := range tests {
+		if got := TunnelProviders[tt.provider].publicURL(tt.line); got != tt.want {
+			t.Errorf("%s publicURL(%q) = %q, want %q", tt.provider, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelProviders_Command(t *testing.T) {
+	got := TunnelProviders["localtunnel"].Command("app-web-1:3000")
+	want := []string{"npx", "--yes", "localtunnel", "--port", "3000", "--local-host", "app-web-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("localtunnel command = %v, want %v", got, want)
+	}
+	if got := TunnelProviders["cloudflared"].Command("app-web-1:3000"); got[len(got)-1] != "http://app-web-1:3000" {
+		t.Errorf("cloudflared command = %v", got)
+	}
+}
+
+func TestScanTunnelOutput(t *testing.T) {
+	output := `Unable to find image 'cloudflare/cloudflared:latest' locally
+INF |  https://quiet-river-fox.trycloudflare.com  |
+INF Registered tunnel connection
+
+INF |  https://other.trycloudflare.com  |
+`
+	var urls []string
+	last, found := scanTunnelOutput(strings.NewReader(output), TunnelProviders["cloudflared"], func(u string) {
+		urls = append(urls, u)
+	})
+	if !found || !reflect.DeepEqual(urls, []string{"https://quiet-river-fox.trycloudflare.com"}) {
+		t.Errorf("urls = %v, found = %v; want the first URL once", urls, found)
+	}
+	if last != "INF |  https://other.trycloudflare.com  |" {
+		t.Errorf("last = %q", last)
+	}
+
+	if _, found := scanTunnelOutput(strings.NewReader("ERR failed to connect\n"), TunnelProviders["cloudflared"], func(string) {}); found {
+		t.Error("found a URL in output without one")
+	}
+}
+
+func TestShare_RequiresEnv(t *testing.T) {
+	t.Setenv("NGROK_AUTHTOKEN", "")
+	err := Share(t.Context(), TunnelProviders["ngrok"], "app.localhost", "app-web-1:3000", func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "NGROK_AUTHTOKEN") {
+		t.Errorf("Share() error = %v, want missing NGROK_AUTHTOKEN", err)
+	}
+}
@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// issuedCertsPath is where Caddy stores certificates issued by the local CA.
+const issuedCertsPath = "/data/caddy/certificates/local"
+
+// ImportCA validates a team-provided CA and copies it into
+// config.TeamCADir(). Without rootPath, certPath and keyPath are a root CA;
+// with it, they are an intermediate CA signed by that root, whose key
+// stays with the team. It returns the team CA mode for the pki settings.
+func ImportCA(certPath, keyPath, rootPath string) (string, error) {
+	cert, certPEM, err := readCACert(certPath)
+	if err != nil {
+		return "", err
+	}
+	keyPEM, err := readLimited(keyPath)
+	if err != nil {
+		return "", err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", keyPath, err)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return "", fmt.Errorf("%s is not the key of %s", keyPath, certPath)
+	}
+
+	mode := config.TeamCARoot
+	files := map[string][]byte{"root.crt": certPEM, "root.key": keyPEM}
+	if rootPath == "" {
+		if err := cert.CheckSignatureFrom(cert); err != nil {
+			return "", fmt.Errorf("%s is not a self-signed root CA; pass the root it chains to with --root", certPath)
+		}
+	} else {
+		root, rootPEM, err := readCACert(rootPath)
+		if err != nil {
+			return "", err
+		}
+		if err := cert.CheckSignatureFrom(root); err != nil {
+			return "", fmt.Errorf("%s is not signed by %s: %w", certPath, rootPath, err)
+		}
+		mode = config.TeamCAIntermediate
+		files = map[string][]byte{"root.crt": rootPEM, "intermediate.crt": certPEM, "intermediate.key": keyPEM}
+	}
+
+	dir := config.TeamCADir()
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("removing previous team CA: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating %s: %w", dir, err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return "", fmt.Errorf("saving team CA: %w", err)
+		}
+	}
+	return mode, nil
+}
+
+// RemoveCA deletes an imported team CA.
+func RemoveCA() error {
+	if err := os.RemoveAll(config.TeamCADir()); err != nil {
+		return fmt.Errorf("removing team CA: %w", err)
+	}
+	return nil
+}
+
+// TeamRoot returns the root certificate of the imported team CA.
+func TeamRoot() (*x509.Certificate, error) {
+	cert, _, err := readCACert(filepath.Join(config.TeamCADir(), "root.crt"))
+	return cert, err
+}
+
+// ReissueCerts waits for the watcher to render the current CA settings
+// into the Caddyfile, then discards the certificates the gateway has
+// issued and restarts it, so every site gets a certificate from that CA.
+func ReissueCerts(ctx context.Context, teamCA bool) error {
+	marker := "/etc/caddy/ca/root.crt"
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := os.ReadFile(config.CaddyfilePath())
+		if err == nil && strings.Contains(string(data), marker) == teamCA {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the watcher has not applied the CA settings - check 'caddy-atc logs'")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	return discardIssuedCerts(ctx)
+}
+
+// discardIssuedCerts deletes the certificates the gateway has issued and
+// restarts it, so they are issued again by the current CA.
+func discardIssuedCerts(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "docker", "exec", ContainerName, "rm", "-rf", issuedCertsPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("discarding issued certificates: %w: %s", err, lastLine(string(output)))
+	}
+	if err := Restart(ctx); err != nil {
+		return fmt.Errorf("restarting gateway: %w", err)
+	}
+	return nil
+}
+
+// currentRootCA returns the PEM root CA the gateway's certificates chain
+// to: an imported team root, or the one Caddy generated.
+func currentRootCA(ctx context.Context, cli *client.Client) ([]byte, error) {
+	if cfg, err := config.Load(); err == nil && cfg.PKI != nil && cfg.PKI.TeamCA != "" {
+		_, certPEM, err := readCACert(filepath.Join(config.TeamCADir(), "root.crt"))
+		return certPEM, err
+	}
+	return rootCA(ctx, cli)
+}
+
+// readCACert reads a PEM CA certificate that hasn't expired.
+func readCACert(path string) (*x509.Certificate, []byte, error) {
+	data, err := readLimited(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := parseCert(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate", path)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, nil, fmt.Errorf("%s expired on %s", path, cert.NotAfter.Format("2006-01-02"))
+	}
+	return cert, data, nil
+}
+
+func readLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxCertSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if len(data) > maxCertSize {
+		return nil, fmt.Errorf("%s too large (>%d bytes)", path, maxCertSize)
+	}
+	return data, nil
+}
+
+// parsePrivateKey parses an unencrypted PEM private key in PKCS #8, SEC 1
+// (EC) or PKCS #1 (RSA) form.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM private key found")
+		}
+		var key any
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("encrypted private keys are not supported; decrypt it with openssl first")
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+}
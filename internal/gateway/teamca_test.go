@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// testCA issues a CA certificate named cn, signed by parent and parentKey,
+// or self-signed if parent is nil. It writes the certificate and its key
+// to dir and returns the certificate, key and their paths.
+func testCA(t *testing.T, dir, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.ReplaceAll(strings.ToLower(cn), " ", "-")
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	writeFile(t, certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return cert, key, certPath, keyPath
+}
+
+func TestImportCA_Root(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	_, _, certPath, keyPath := testCA(t, dir, "Team Root", nil, nil)
+
+	mode, err := ImportCA(certPath, keyPath, "")
+	if err != nil {
+		t.Fatalf("ImportCA() error = %v", err)
+	}
+	if mode != config.TeamCARoot {
+		t.Errorf("mode = %q, want %q", mode, config.TeamCARoot)
+	}
+	for _, name := range []string{"root.crt", "root.key"} {
+		info, err := os.Stat(filepath.Join(config.TeamCADir(), name))
+		if err != nil {
+			t.Fatalf("%s not imported: %v", name, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s mode = %v, want 0600", name, info.Mode().Perm())
+		}
+	}
+	root, err := TeamRoot()
+	if err != nil || root.Subject.CommonName != "Team Root" {
+		t.Errorf("TeamRoot() = %v, %v", root, err)
+	}
+
+	if err := RemoveCA(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.TeamCADir()); !os.IsNotExist(err) {
+		t.Error("RemoveCA() left the team CA in place")
+	}
+}
+
+func TestImportCA_Intermediate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	root, rootKey, rootPath, _ := testCA(t, dir, "Team Root", nil, nil)
+	_, _, certPath, keyPath := testCA(t, dir, "Team Intermediate", root, rootKey)
+
+	if _, err := ImportCA(certPath, keyPath, ""); err == nil || !strings.Contains(err.Error(), "--root") {
+		t.Errorf("ImportCA() of an intermediate without its root: error = %v, want a --root hint", err)
+	}
+
+	mode, err := ImportCA(certPath, keyPath, rootPath)
+	if err != nil {
+		t.Fatalf("ImportCA() error = %v", err)
+	}
+	if mode != config.TeamCAIntermediate {
+		t.Errorf("mode = %q, want %q", mode, config.TeamCAIntermediate)
+	}
+	for _, name := range []string{"root.crt", "intermediate.crt", "intermediate.key"} {
+		if _, err := os.Stat(filepath.Join(config.TeamCADir(), name)); err != nil {
+			t.Errorf("%s not imported: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(config.TeamCADir(), "root.key")); !os.IsNotExist(err) {
+		t.Error("intermediate mode should not hold a root key")
+	}
+
+	_, _, otherRoot, _ := testCA(t, dir, "Other Root", nil, nil)
+	if _, err := ImportCA(certPath, keyPath, otherRoot); err == nil {
+		t.Error("expected an error for an intermediate not signed by --root")
+	}
+}
+
+func TestImportCA_KeyMismatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	_, _, certPath, _ := testCA(t, dir, "Team Root", nil, nil)
+	_, _, _, otherKey := testCA(t, dir, "Other Root", nil, nil)
+
+	if _, err := ImportCA(certPath, otherKey, ""); err == nil || !strings.Contains(err.Error(), "is not the key of") {
+		t.Errorf("ImportCA() error = %v, want key mismatch", err)
+	}
+	if _, err := os.Stat(config.TeamCADir()); !os.IsNotExist(err) {
+		t.Error("a rejected CA should not be imported")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+	if err != nil {
+		t.Fatalf("parsePrivateKey(SEC 1) error = %v", err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Error("parsed key doesn't match")
+	}
+
+	if _, err := parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte{1}})); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("encrypted key: error = %v", err)
+	}
+	if _, err := parsePrivateKey([]byte("nothing")); err == nil {
+		t.Error("expected an error without a PEM key")
+	}
+}
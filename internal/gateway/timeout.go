@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// ErrDockerTimeout is returned when a Docker API call doesn't complete
+// within the configured timeout, usually because the daemon or its VM is
+// hung.
+var ErrDockerTimeout = errors.New("Docker daemon did not respond in time")
+
+// dockerTimeout caches the configured per-call timeout; zero means it
+// hasn't been loaded yet.
+var dockerTimeout atomic.Int64
+
+// DockerTimeout returns how long a single Docker API call may take, from
+// the docker timeout setting in projects.yml.
+func DockerTimeout() time.Duration {
+	if d := dockerTimeout.Load(); d > 0 {
+		return time.Duration(d)
+	}
+	d := config.DefaultDockerTimeout
+	if cfg, err := config.Load(); err == nil {
+		d, _ = cfg.DockerTimeout()
+	}
+	dockerTimeout.Store(int64(d))
+	return d
+}
+
+// SetDockerTimeout changes the per-call timeout, for a long-running
+// watcher picking up settings changes.
+func SetDockerTimeout(d time.Duration) {
+	dockerTimeout.Store(int64(d))
+}
+
+// Call runs a Docker API call bounded by DockerTimeout. If the timeout
+// expires first, the error wraps ErrDockerTimeout.
+func Call[T any](ctx context.Context, call func(context.Context) (T, error)) (T, error) {
+	return CallWithin(ctx, DockerTimeout(), call)
+}
+
+// CallWithin runs a Docker API call bounded by timeout, for calls such as
+// stopping a container that legitimately take longer than most.
+func CallWithin[T any](ctx context.Context, timeout time.Duration, call func(context.Context) (T, error)) (T, error) {
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	v, err := call(callCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return v, fmt.Errorf("%w (no answer within %s)", ErrDockerTimeout, timeout)
+	}
+	return v, err
+}
+
+// Do is Call for API calls that only return an error.
+func Do(ctx context.Context, call func(context.Context) error) error {
+	return DoWithin(ctx, DockerTimeout(), call)
+}
+
+// DoWithin is CallWithin for API calls that only return an error.
+func DoWithin(ctx context.Context, timeout time.Duration, call func(context.Context) error) error {
+	_, err := CallWithin(ctx, timeout, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, call(ctx)
+	})
+	return err
+}
+
+// InspectContainer is cli.ContainerInspect bounded by DockerTimeout.
+func InspectContainer(ctx context.Context, cli *client.Client, id string) (types.ContainerJSON, error) {
+	return Call(ctx, func(ctx context.Context) (types.ContainerJSON, error) {
+		return cli.ContainerInspect(ctx, id)
+	})
+}
+
+// InspectImage is cli.ImageInspectWithRaw bounded by DockerTimeout.
+func InspectImage(ctx context.Context, cli *client.Client, ref string) (types.ImageInspect, error) {
+	return Call(ctx, func(ctx context.Context) (types.ImageInspect, error) {
+		img, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		return img, err
+	})
+}
+
+// ListContainers is cli.ContainerList bounded by DockerTimeout.
+func ListContainers(ctx context.Context, cli *client.Client, opts container.ListOptions) ([]types.Container, error) {
+	return Call(ctx, func(ctx context.Context) ([]types.Container, error) {
+		return cli.ContainerList(ctx, opts)
+	})
+}
+
+// IsTimeout reports whether err is a Docker call timing out.
+func IsTimeout(err error) bool {
+	return errors.Is(err, ErrDockerTimeout)
+}
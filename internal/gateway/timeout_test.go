@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallWithin(t *testing.T) {
+	hang := func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	_, err := CallWithin(t.Context(), 10*time.Millisecond, hang)
+	if !IsTimeout(err) {
+		t.Errorf("hung call: error = %v, want ErrDockerTimeout", err)
+	}
+
+	// Cancellation by the caller is not a timeout.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := CallWithin(ctx, time.Second, hang); IsTimeout(err) || !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled call: error = %v, want context.Canceled", err)
+	}
+
+	got, err := CallWithin(t.Context(), time.Second, func(context.Context) (string, error) { return "ok", nil })
+	if err != nil || got != "ok" {
+		t.Errorf("quick call = %q, %v", got, err)
+	}
+
+	failed := errors.New("no such container")
+	if _, err := CallWithin(t.Context(), time.Second, func(context.Context) (string, error) { return "", failed }); err != failed {
+		t.Errorf("failed call: error = %v, want %v", err, failed)
+	}
+}
+
+func TestDockerTimeout(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer SetDockerTimeout(0)
+
+	SetDockerTimeout(0)
+	if got := DockerTimeout(); got != 10*time.Second {
+		t.Errorf("DockerTimeout() = %v, want the default", got)
+	}
+	SetDockerTimeout(time.Minute)
+	if got := DockerTimeout(); got != time.Minute {
+		t.Errorf("DockerTimeout() = %v, want 1m", got)
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -23,7 +23,7 @@ const (
 
 // Trust extracts the Caddy root CA certificate and installs it in the system trust store.
 func Trust(ctx context.Context) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := NewClient()
 	if err != nil {
 		return fmt.Errorf("connecting to Docker: %w", err)
 	}
@@ -33,22 +33,13 @@ func Trust(ctx context.Context) error {
 		return fmt.Errorf("caddy gateway is not running - run 'caddy-atc up' first")
 	}
 
-	// Extract root CA cert from container
-	reader, _, err := cli.CopyFromContainer(ctx, ContainerName, caCertPath)
+	certData, err := currentRootCA(ctx, cli)
 	if err != nil {
-		return fmt.Errorf("extracting CA cert: %w\nThe CA cert may not exist yet. Try visiting https://localhost first to trigger cert generation", err)
-	}
-	defer reader.Close()
-
-	// CopyFromContainer returns a tar archive
-	certData, err := extractFromTar(reader, maxCertSize)
-	if err != nil {
-		return fmt.Errorf("reading CA cert from archive: %w", err)
+		return err
 	}
 
 	// Save to home dir
-	homeDir := config.HomeDir()
-	certLocalPath := filepath.Join(homeDir, "caddy-atc-root-ca.crt")
+	certLocalPath := config.RootCAPath()
 	if err := os.WriteFile(certLocalPath, certData, 0600); err != nil {
 		return fmt.Errorf("saving CA cert: %w", err)
 	}
@@ -62,10 +53,68 @@ func Trust(ctx context.Context) error {
 	return nil
 }
 
+// RootCA returns the PEM root CA certificate the gateway's certificates
+// chain to: the team CA's root while one is in use, else Caddy's own.
+func RootCA(ctx context.Context) ([]byte, error) {
+	cli, err := NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+	return currentRootCA(ctx, cli)
+}
+
+// rootCA extracts the PEM root CA certificate from the gateway container.
+func rootCA(ctx context.Context, cli *client.Client) ([]byte, error) {
+	// The archive streams after CopyFromContainer returns, so the read is
+	// bounded along with the call.
+	return Call(ctx, func(ctx context.Context) ([]byte, error) {
+		reader, _, err := cli.CopyFromContainer(ctx, ContainerName, caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("extracting CA cert: %w\nThe CA cert may not exist yet. Try visiting https://localhost first to trigger cert generation", err)
+		}
+		defer reader.Close()
+
+		// CopyFromContainer returns a tar archive
+		certData, err := extractFromTar(reader, maxCertSize)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA cert from archive: %w", err)
+		}
+		return certData, nil
+	})
+}
+
+// CATrusted reports whether the gateway's root CA is in the system trust
+// store, by verifying it against the system roots.
+func CATrusted(ctx context.Context) (bool, error) {
+	cli, err := NewClient()
+	if err != nil {
+		return false, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	certData, err := currentRootCA(ctx, cli)
+	if err != nil {
+		return false, err
+	}
+	return trustedBySystem(certData)
+}
+
+// trustedBySystem reports whether a PEM certificate chains to the system
+// roots. A root CA does so only if it is itself installed.
+func trustedBySystem(certPEM []byte) (bool, error) {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return false, err
+	}
+	_, err = cert.Verify(x509.VerifyOptions{})
+	return err == nil, nil
+}
+
 func installCert(certPath string) error {
 	switch runtime.GOOS {
 	case "linux":
-		if isWSL() {
+		if IsWSL() {
 			return installCertWSL(certPath)
 		}
 		return installCertLinux(certPath)
@@ -172,7 +221,8 @@ func detectWindowsUser() string {
 	return ""
 }
 
-func isWSL() bool {
+// IsWSL reports whether this is Linux running under WSL.
+func IsWSL() bool {
 	data, err := os.ReadFile("/proc/version")
 	if err != nil {
 		return false
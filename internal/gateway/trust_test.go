@@ -3,9 +3,17 @@ package gateway
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io"
+	"math/big"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func makeTar(t *testing.T, entries []struct {
@@ -176,3 +184,36 @@ func TestInstallCert_NonLinuxNonDarwin(t *testing.T) {
 		t.Errorf("installCert() returned error on %s: %v", runtime.GOOS, err)
 	}
 }
+
+func TestTrustedBySystem(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Caddy Local Authority - Test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A freshly generated root is never in the system store.
+	trusted, err := trustedBySystem(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	if err != nil {
+		t.Fatalf("trustedBySystem() error = %v", err)
+	}
+	if trusted {
+		t.Error("trustedBySystem() = true for an untrusted root")
+	}
+
+	if _, err := trustedBySystem([]byte("not a cert")); err == nil {
+		t.Error("expected error for non-PEM input")
+	}
+}
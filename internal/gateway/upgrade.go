@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// Upgrade pulls the configured gateway image and recreates a running
+// gateway on it. With plugins configured, the image is rebuilt with them
+// on freshly pulled base and builder images instead. The data volume
+// holding the local CA and issued certificates is kept, so nothing needs
+// to be trusted again. With ref, the configured image is changed to it
+// first.
+func Upgrade(ctx context.Context, ref string) error {
+	if ref != "" {
+		if err := config.ValidateImage(ref); err != nil {
+			return err
+		}
+		if err := config.ModifySettings(func(s *config.Settings) error {
+			s.Image = ref
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	image, err := baseImage(cfg)
+	if err != nil {
+		return err
+	}
+
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	if plugins := configuredPlugins(cfg); len(plugins) > 0 {
+		if err := buildImage(ctx, image, plugins, true); err != nil {
+			return err
+		}
+		image = CustomImage
+	} else {
+		fmt.Printf("Pulling %s...\n", image)
+		pull := exec.CommandContext(ctx, "docker", "pull", image)
+		pull.Stdout = os.Stdout
+		pull.Stderr = os.Stderr
+		if err := pull.Run(); err != nil {
+			// Custom builds may only exist locally.
+			if _, inspectErr := InspectImage(ctx, cli, image); inspectErr != nil {
+				return fmt.Errorf("pulling %s: %w", image, err)
+			}
+			fmt.Printf("Pull failed; using the local %s.\n", image)
+		}
+	}
+	return switchImage(ctx, cli, image)
+}
+
+// switchImage checks that image runs Caddy and recreates a running gateway
+// on it if it runs another image.
+func switchImage(ctx context.Context, cli *client.Client, image string) error {
+	// Check the image runs Caddy before replacing a working gateway.
+	out, err := exec.CommandContext(ctx, "docker", "run", "--rm", "--entrypoint", "caddy", image, "version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s does not run caddy: %w: %s", image, err, lastLine(string(out)))
+	}
+	fmt.Printf("%s runs Caddy %s.\n", image, caddyVersion(string(out)))
+
+	if !isContainerRunning(ctx, cli) {
+		fmt.Println("The gateway is not running; it starts on the new image with 'caddy-atc up'.")
+		return nil
+	}
+	info, err := InspectContainer(ctx, cli, ContainerName)
+	if err != nil {
+		return fmt.Errorf("inspecting gateway container: %w", err)
+	}
+	img, err := InspectImage(ctx, cli, image)
+	if err != nil {
+		return fmt.Errorf("inspecting %s: %w", image, err)
+	}
+	if info.Image == img.ID {
+		fmt.Println("The gateway already runs this image.")
+		return nil
+	}
+
+	fmt.Println("Recreating the gateway (certificates and the local CA are kept)...")
+	if err := Down(ctx); err != nil {
+		return err
+	}
+	if err := Up(ctx); err != nil {
+		return err
+	}
+	if err := ValidateConfig(ctx); err != nil {
+		fmt.Printf("Warning: the new image rejects the Caddyfile: %v\n", err)
+		fmt.Println("         Fix it, or go back with 'caddy-atc gateway upgrade --image <previous image>'.")
+	}
+	return nil
+}
+
+// caddyVersion returns the version from `caddy version` output, e.g.
+// "v2.10.0" from "v2.10.0 h1:...".
+func caddyVersion(output string) string {
+	fields := strings.Fields(lastLine(output))
+	if len(fields) == 0 {
+		return "(unknown version)"
+	}
+	return fields[0]
+}
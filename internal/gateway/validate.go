@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ValidateConfig runs `caddy validate` on the mounted Caddyfile inside the
+// gateway container, returning Caddy's error output if it is rejected.
+func ValidateConfig(ctx context.Context) error {
+	return validate(ctx, "/etc/caddy/Caddyfile", "")
+}
+
+// ValidateCaddyfile runs `caddy validate` inside the gateway container on
+// content, which need not be written anywhere yet.
+func ValidateCaddyfile(ctx context.Context, content string) error {
+	return validate(ctx, "/dev/stdin", content)
+}
+
+func validate(ctx context.Context, path, stdin string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	args := []string{"exec", ContainerName, "caddy", "validate", "--config", path, "--adapter", "caddyfile"}
+	if stdin != "" {
+		args = slices.Insert(args, 1, "-i")
+	}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, lastLine(string(output)))
+	}
+	return nil
+}
+
+// lastLine returns the final non-empty line of s, where Caddy puts its
+// error message.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
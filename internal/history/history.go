@@ -0,0 +1,138 @@
+// Package history records route changes and Caddy reloads made by the
+// watcher, so `caddy-atc query` can look back at how routing behaved.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// Event types.
+const (
+	RouteAdded   = "route_added"
+	RouteRemoved = "route_removed"
+	Reload       = "reload"
+	ReloadFailed = "reload_failed"
+)
+
+// Types lists the event types.
+var Types = []string{RouteAdded, RouteRemoved, Reload, ReloadFailed}
+
+// maxFileSize is the size at which the history file is rotated. One older
+// generation is kept.
+const maxFileSize = 4 << 20
+
+// Event is one recorded change. Route events name the route; reload events
+// carry how long the reload took and, if it failed, why.
+type Event struct {
+	Time      time.Time     `json:"time"`
+	Type      string        `json:"type"`
+	Hostname  string        `json:"hostname,omitempty"`
+	Container string        `json:"container,omitempty"`
+	Port      string        `json:"port,omitempty"`
+	Project   string        `json:"project,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Record appends an event to the history file.
+func Record(e Event) error {
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	path := config.HistoryPath()
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxFileSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("rotating history: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshaling history event: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("opening history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return nil
+}
+
+// Filter selects events. Zero fields match everything.
+type Filter struct {
+	Since   time.Time
+	Types   []string
+	Host    string // exact hostname
+	Project string // compose project
+	Limit   int    // keep only the latest Limit events
+}
+
+// ValidateType checks an event type filter.
+func ValidateType(typ string) error {
+	if !slices.Contains(Types, typ) {
+		return fmt.Errorf("unknown event type %q (one of %s)", typ, strings.Join(Types, ", "))
+	}
+	return nil
+}
+
+func (f Filter) match(e Event) bool {
+	switch {
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case len(f.Types) > 0 && !slices.Contains(f.Types, e.Type):
+		return false
+	case f.Host != "" && e.Hostname != f.Host:
+		return false
+	case f.Project != "" && e.Project != f.Project:
+		return false
+	}
+	return true
+}
+
+// Query returns the recorded events matching f, oldest first. Lines that
+// don't parse are skipped.
+func Query(f Filter) ([]Event, error) {
+	var events []Event
+	path := config.HistoryPath()
+	for _, p := range []string{path + ".1", path} {
+		file, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading history: %w", err)
+		}
+		r := bufio.NewReader(file)
+		for {
+			line, err := r.ReadBytes('\n')
+			var e Event
+			if json.Unmarshal(line, &e) == nil && f.match(e) {
+				events = append(events, e)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("reading history: %w", err)
+			}
+		}
+		file.Close()
+	}
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[len(events)-f.Limit:]
+	}
+	return events, nil
+}
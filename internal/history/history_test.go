@@ -0,0 +1,101 @@
+package history
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func TestRecordQuery(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if events, err := Query(Filter{}); err != nil || events != nil {
+		t.Fatalf("Query() before any event = %v, %v", events, err)
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, e := range []Event{
+		{Type: RouteAdded, Hostname: "app.localhost", Container: "app-web-1", Port: "80", Project: "app"},
+		{Type: Reload, Duration: 40 * time.Millisecond},
+		{Type: RouteAdded, Hostname: "api.localhost", Container: "api-web-1", Port: "8080", Project: "api"},
+		{Type: ReloadFailed, Error: "adapting config"},
+		{Type: RouteRemoved, Hostname: "app.localhost", Container: "app-web-1", Port: "80", Project: "app"},
+	} {
+		e.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string // hostnames, or types for reloads
+	}{
+		{"all", Filter{}, []string{"app.localhost", Reload, "api.localhost", ReloadFailed, "app.localhost"}},
+		{"host", Filter{Host: "app.localhost"}, []string{"app.localhost", "app.localhost"}},
+		{"project", Filter{Project: "api"}, []string{"api.localhost"}},
+		{"types", Filter{Types: []string{Reload, ReloadFailed}}, []string{Reload, ReloadFailed}},
+		{"since", Filter{Since: start.Add(3 * time.Minute)}, []string{ReloadFailed, "app.localhost"}},
+		{"limit", Filter{Limit: 2}, []string{ReloadFailed, "app.localhost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range events {
+				if e.Hostname != "" {
+					got = append(got, e.Hostname)
+				} else {
+					got = append(got, e.Type)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Query() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordRotates(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := config.EnsureHomeDir(); err != nil {
+		t.Fatal(err)
+	}
+	old := `{"time":"2026-03-01T12:00:00Z","type":"reload"}` + "\n"
+	if err := os.WriteFile(config.HistoryPath(), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(config.HistoryPath(), maxFileSize); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Record(Event{Time: time.Now(), Type: ReloadFailed}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.HistoryPath() + ".1"); err != nil {
+		t.Fatalf("history not rotated: %v", err)
+	}
+	events, err := Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != Reload || events[1].Type != ReloadFailed {
+		t.Errorf("Query() after rotation = %+v", events)
+	}
+}
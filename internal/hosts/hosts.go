@@ -0,0 +1,216 @@
+// Package hosts keeps a delimited block of caddy-atc hostnames in the
+// system hosts file, and on WSL in the Windows one, for domains that don't
+// resolve on their own.
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+const (
+	beginMarker = "# BEGIN caddy-atc managed hostnames"
+	endMarker   = "# END caddy-atc managed hostnames"
+)
+
+// windowsHostsPath is the Windows hosts file as seen from WSL.
+const windowsHostsPath = "/mnt/c/Windows/System32/drivers/etc/hosts"
+
+// File is a hosts file caddy-atc manages a block in.
+type File struct {
+	Path    string
+	Windows bool // written from WSL, which can't sudo for it
+}
+
+// Files returns the hosts files on this system: /etc/hosts, plus the
+// Windows hosts file on WSL.
+func Files() []File {
+	files := []File{{Path: "/etc/hosts"}}
+	if gateway.IsWSL() {
+		if _, err := os.Stat(windowsHostsPath); err == nil {
+			files = append(files, File{Path: windowsHostsPath, Windows: true})
+		}
+	}
+	return files
+}
+
+// Hostnames returns the adopted hostnames in cfg, its static routes and
+// active, sorted and without duplicates. Wildcards are skipped, since
+// hosts files can't express them.
+func Hostnames(cfg *config.Config, active []string) []string {
+	var names []string
+	add := func(h string) {
+		if h != "" && !strings.Contains(h, "*") {
+			names = append(names, strings.ToLower(h))
+		}
+	}
+	for _, proj := range cfg.Projects {
+		add(proj.Hostname)
+		for _, h := range proj.Services {
+			add(h)
+		}
+	}
+	for _, sr := range cfg.StaticRoutes {
+		add(sr.Hostname)
+	}
+	for _, h := range active {
+		add(h)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Address returns the IP hostnames should point at: the gateway address,
+// resolved if it is a hostname.
+func Address(cfg *config.Config) (string, error) {
+	addr, err := cfg.GatewayAddress()
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(addr) != nil {
+		return addr, nil
+	}
+	ips, err := net.LookupIP(addr)
+	if err != nil {
+		return "", fmt.Errorf("resolving gateway address: %w", err)
+	}
+	return ips[0].String(), nil
+}
+
+// Block returns the managed block mapping hostnames to addr, or "" if
+// there are none.
+func Block(addr string, hostnames []string) string {
+	if len(hostnames) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(beginMarker + "\n")
+	for _, h := range hostnames {
+		fmt.Fprintf(&b, "%s %s\n", addr, h)
+	}
+	b.WriteString(endMarker + "\n")
+	return b.String()
+}
+
+// Render returns content with its managed block replaced by block, which
+// is appended at the end. An empty block removes it. Line endings follow
+// the file's. A begin marker without an end marker is an error, since the
+// extent of the block to replace is unknown.
+func Render(content, block string) (string, error) {
+	eol := "\n"
+	if strings.Contains(content, "\r\n") {
+		eol = "\r\n"
+	}
+	var kept []string
+	start, beginLine := -1, 0
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		switch {
+		case strings.TrimSpace(line) == beginMarker && start < 0:
+			start, beginLine = len(kept), i+1
+			kept = append(kept, line)
+		case strings.TrimSpace(line) == endMarker:
+			if start >= 0 {
+				kept = kept[:start]
+				start = -1
+			}
+		default:
+			kept = append(kept, line)
+		}
+	}
+	if start >= 0 {
+		return "", fmt.Errorf("line %d: %q has no matching %q line; fix the block by hand", beginLine, beginMarker, endMarker)
+	}
+	for len(kept) > 0 && kept[len(kept)-1] == "" {
+		kept = kept[:len(kept)-1]
+	}
+
+	out := strings.Join(kept, "\n")
+	if out != "" {
+		out += "\n"
+	}
+	if block != "" {
+		if out != "" {
+			out += "\n"
+		}
+		out += block
+	}
+	return strings.ReplaceAll(out, "\n", eol), nil
+}
+
+// Sync writes block into each file whose managed block differs, returning
+// the paths it changed. With dryRun nothing is written. Files the user
+// can't write are written with sudo, which prompts only if interactive.
+func Sync(files []File, block string, dryRun, interactive bool) ([]string, error) {
+	var changed []string
+	for _, f := range files {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return changed, fmt.Errorf("reading %s: %w", f.Path, err)
+		}
+		updated, err := Render(string(data), block)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", f.Path, err)
+		}
+		if updated == string(data) {
+			continue
+		}
+		changed = append(changed, f.Path)
+		if dryRun {
+			continue
+		}
+		if err := write(f, []byte(updated), interactive); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// write replaces a hosts file's content in place, keeping its owner and
+// mode.
+func write(f File, data []byte, interactive bool) error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err == nil {
+		_, err = file.Write(data)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", f.Path, err)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("writing %s: %w", f.Path, err)
+	}
+	if f.Windows {
+		return fmt.Errorf("writing %s: permission denied; run the WSL terminal as Administrator, or make the file writable for your Windows user", f.Path)
+	}
+
+	args := []string{"tee", f.Path}
+	if !interactive {
+		args = append([]string{"-n"}, args...)
+	}
+	cmd := exec.Command("sudo", args...)
+	cmd.Stdin = strings.NewReader(string(data))
+	cmd.Stdout = io.Discard
+	if interactive {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		if !interactive {
+			return fmt.Errorf("writing %s: sudo needs a password; add a NOPASSWD sudoers rule for 'tee %s', or run 'caddy-atc hosts sync'", f.Path, f.Path)
+		}
+		return fmt.Errorf("writing %s with sudo: %w", f.Path, err)
+	}
+	return nil
+}
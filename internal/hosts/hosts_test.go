@@ -0,0 +1,146 @@
+package hosts
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+const systemHosts = "127.0.0.1 localhost\n::1 localhost\n"
+
+// render calls Render, failing the test on an error.
+func render(t *testing.T, content, block string) string {
+	t.Helper()
+	got, err := Render(content, block)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	return got
+}
+
+func TestRender(t *testing.T) {
+	block := Block("127.0.0.1", []string{"app.test", "api.app.test"})
+
+	added := render(t, systemHosts, block)
+	want := systemHosts + "\n" + beginMarker + "\n127.0.0.1 app.test\n127.0.0.1 api.app.test\n" + endMarker + "\n"
+	if added != want {
+		t.Errorf("Render() added block:\n%s\nwant:\n%s", added, want)
+	}
+	if again := render(t, added, block); again != added {
+		t.Errorf("Render() is not idempotent:\n%s", again)
+	}
+
+	replaced := render(t, added+"10.0.0.1 after\n", Block("127.0.0.1", []string{"other.test"}))
+	if strings.Contains(replaced, "app.test") || !strings.Contains(replaced, "127.0.0.1 other.test") {
+		t.Errorf("Render() did not replace the block:\n%s", replaced)
+	}
+	if !strings.Contains(replaced, "10.0.0.1 after\n") {
+		t.Errorf("Render() dropped a line after the block:\n%s", replaced)
+	}
+
+	if removed := render(t, added, ""); removed != systemHosts {
+		t.Errorf("Render() with no block = %q, want %q", removed, systemHosts)
+	}
+}
+
+func TestRender_CRLF(t *testing.T) {
+	windows := "# Copyright (c) Microsoft Corp.\r\n127.0.0.1 localhost\r\n"
+	got := render(t, windows, Block("127.0.0.1", []string{"app.test"}))
+	if strings.Count(got, "\r\n") != strings.Count(got, "\n") {
+		t.Errorf("Render() mixed line endings in a CRLF file: %q", got)
+	}
+	if render(t, got, "") != windows {
+		t.Errorf("removing the block did not restore the file: %q", render(t, got, ""))
+	}
+}
+
+func TestRender_UnterminatedBlock(t *testing.T) {
+	broken := systemHosts + beginMarker + "\n127.0.0.1 app.test\n10.0.0.1 mine.lan\n"
+	_, err := Render(broken, Block("127.0.0.1", []string{"app.test"}))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("Render() error = %v, want the unterminated block reported", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	os.WriteFile(path, []byte(broken), 0644)
+	if _, err := Sync([]File{{Path: path}}, "", false, false); err == nil {
+		t.Error("Sync() wrote a file with an unterminated block")
+	}
+	if data, _ := os.ReadFile(path); string(data) != broken {
+		t.Errorf("file changed: %q", data)
+	}
+}
+
+func TestHostnames(t *testing.T) {
+	cfg := &config.Config{
+		Projects: map[string]*config.ProjectConfig{
+			"app":  {Hostname: "app.test", Services: map[string]string{"web": "app.test", "api": "API.app.test"}},
+			"wild": {Hostname: "*.wild.test", Services: map[string]string{"proxy": "*.wild.test", "ui": "ui.wild.test"}},
+		},
+		StaticRoutes: []*config.StaticRoute{{Hostname: "files.test", Upstream: "host:8000"}},
+	}
+	got := Hostnames(cfg, []string{"app.test", "adhoc.test"})
+	want := []string{"adhoc.test", "api.app.test", "app.test", "files.test", "ui.wild.test"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Hostnames() = %v, want %v", got, want)
+	}
+}
+
+func TestSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(systemHosts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{{Path: path}}
+	block := Block("127.0.0.1", []string{"app.test"})
+
+	changed, err := Sync(files, block, true, false)
+	if err != nil || len(changed) != 1 {
+		t.Fatalf("Sync(dryRun) = %v, %v; want the file reported", changed, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != systemHosts {
+		t.Error("Sync(dryRun) wrote the file")
+	}
+
+	if _, err := Sync(files, block, false, false); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "127.0.0.1 app.test") {
+		t.Errorf("Sync() did not write the block:\n%s", data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Sync() changed the mode to %v", info.Mode().Perm())
+	}
+
+	if changed, err := Sync(files, block, false, false); err != nil || len(changed) != 0 {
+		t.Errorf("second Sync() = %v, %v; want no changes", changed, err)
+	}
+
+	if _, err := Sync(files, "", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != systemHosts {
+		t.Errorf("cleaning did not restore the file:\n%s", data)
+	}
+}
+
+func TestSync_WindowsPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write read-only files")
+	}
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(systemHosts), 0444); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Sync([]File{{Path: path, Windows: true}}, Block("127.0.0.1", []string{"app.test"}), false, false)
+	if err == nil || !strings.Contains(err.Error(), "Administrator") {
+		t.Errorf("Sync() error = %v, want an Administrator hint", err)
+	}
+}
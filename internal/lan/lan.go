@@ -0,0 +1,120 @@
+// Package lan finds the addresses other devices on the local network reach
+// this machine at: its LAN IP and its mDNS name.
+package lan
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// Names returns the LAN IP and mDNS name of this machine. name overrides
+// the mDNS name.
+func Names(name string) ([]string, error) {
+	ip, err := IP()
+	if err != nil {
+		return nil, err
+	}
+	host, err := MDNSName(name)
+	if err != nil {
+		return nil, err
+	}
+	return []string{ip.String(), host}, nil
+}
+
+// IP returns this machine's LAN IPv4 address: the source address of the
+// default route if it is private, otherwise the first private address of
+// an interface that isn't a container bridge.
+func IP() (net.IP, error) {
+	// Dialing UDP only selects a route; nothing is sent.
+	if conn, err := net.Dial("udp4", "192.0.2.1:9"); err == nil {
+		addr := conn.LocalAddr().(*net.UDPAddr)
+		conn.Close()
+		if addr.IP.IsPrivate() {
+			return addr.IP, nil
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("listing network interfaces: %w", err)
+	}
+	var candidates []candidate
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				candidates = append(candidates, candidate{iface.Name, ipNet.IP})
+			}
+		}
+	}
+	if ip := choose(candidates); ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no LAN address found; connect to a network first")
+}
+
+type candidate struct {
+	iface string
+	ip    net.IP
+}
+
+// bridgePrefixes name interfaces of container and VM bridges, whose
+// addresses other devices can't reach.
+var bridgePrefixes = []string{"docker", "br-", "veth", "cni", "flannel", "virbr", "vmnet", "vboxnet"}
+
+// choose returns the first private IPv4 address outside bridges.
+func choose(candidates []candidate) net.IP {
+	for _, c := range candidates {
+		ip := c.ip.To4()
+		if ip == nil || !ip.IsPrivate() || isBridge(c.iface) {
+			continue
+		}
+		return ip
+	}
+	return nil
+}
+
+func isBridge(iface string) bool {
+	for _, p := range bridgePrefixes {
+		if strings.HasPrefix(iface, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MDNSName returns name, or "<hostname>.local" if it is empty.
+func MDNSName(name string) (string, error) {
+	if name == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("reading hostname: %w", err)
+		}
+		name = mdnsName(host)
+	}
+	name = strings.ToLower(name)
+	if err := config.ValidateHostname(name); err != nil {
+		return "", fmt.Errorf("mDNS name: %w", err)
+	}
+	if strings.Contains(name, "*") {
+		return "", fmt.Errorf("mDNS name %q: wildcards are not supported", name)
+	}
+	return name, nil
+}
+
+// mdnsName turns a hostname such as "Dev-Box.example.com" into
+// "dev-box.local".
+func mdnsName(hostname string) string {
+	label, _, _ := strings.Cut(hostname, ".")
+	return strings.ToLower(label) + ".local"
+}
@@ -0,0 +1,59 @@
+package lan
+
+import (
+	"net"
+	"testing"
+)
+
+func TestChoose(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []candidate
+		want       string
+	}{
+		{
+			name: "skips bridges, loopback-like and public addresses",
+			candidates: []candidate{
+				{"docker0", net.ParseIP("172.17.0.1")},
+				{"br-1a2b", net.ParseIP("172.18.0.1")},
+				{"eth0", net.ParseIP("fe80::1")},
+				{"tun0", net.ParseIP("203.0.113.7")},
+				{"wlan0", net.ParseIP("192.168.1.23")},
+				{"eth1", net.ParseIP("10.0.0.5")},
+			},
+			want: "192.168.1.23",
+		},
+		{
+			name:       "only bridges",
+			candidates: []candidate{{"docker0", net.ParseIP("172.17.0.1")}},
+			want:       "<nil>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := choose(tt.candidates).String(); got != tt.want {
+				t.Errorf("choose() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMDNSName(t *testing.T) {
+	for host, want := range map[string]string{
+		"devbox":                "devbox.local",
+		"Dev-Box.example.com":   "dev-box.local",
+		"MacBook-Pro.local":     "macbook-pro.local",
+		"workstation.corp.lan.": "workstation.local",
+	} {
+		if got := mdnsName(host); got != want {
+			t.Errorf("mdnsName(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	if got, err := MDNSName("Phone-Test.local"); err != nil || got != "phone-test.local" {
+		t.Errorf("MDNSName(override) = %q, %v", got, err)
+	}
+	if _, err := MDNSName("bad name.local"); err == nil {
+		t.Error("MDNSName() accepted an invalid name")
+	}
+}
@@ -0,0 +1,321 @@
+package lint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/adopt"
+	"gopkg.in/yaml.v3"
+)
+
+// Listen-address patterns. A server bound to loopback inside its container
+// refuses the gateway's connections, which arrive on the container's
+// network interface.
+var (
+	// loopbackFlag matches a flag setting the listen address, in one
+	// argument ("--host=127.0.0.1") or a command string ("-b localhost:8000").
+	loopbackFlag = regexp.MustCompile(`(^|[\s"'])(--host|--hostname|--bind|--listen|--addr|--address|--ip|-b|-H)(=|\s+)(["']?)(?:127\.0\.0\.1|localhost)([:\s"']|$)`)
+	// loopbackRunserver matches Django's "runserver 127.0.0.1:8000".
+	loopbackRunserver = regexp.MustCompile(`(runserver\s+)(?:127\.0\.0\.1|localhost)([:\s"']|$)`)
+	// bindFlagArg is a flag whose value is the next argument.
+	bindFlagArg = regexp.MustCompile(`^(--host|--hostname|--bind|--listen|--addr|--address|--ip|-b|-H|runserver)$`)
+	// loopbackAddr is a whole argument or env value naming loopback.
+	loopbackAddr = regexp.MustCompile(`^(?:127\.0\.0\.1|localhost)(:\d+)?$`)
+)
+
+// bindEnv are environment variables servers and frameworks read their
+// listen address from.
+var bindEnv = map[string]bool{
+	"HOST": true, "HOSTNAME": true, "BIND": true, "BIND_ADDRESS": true, "BIND_HOST": true,
+	"LISTEN_ADDRESS": true, "LISTEN_HOST": true, "SERVER_HOST": true, "APP_HOST": true,
+	"NUXT_HOST": true, "NITRO_HOST": true, "UVICORN_HOST": true, "FLASK_RUN_HOST": true,
+}
+
+// loopbackMessage explains a loopback bind found in what.
+func loopbackMessage(what string) string {
+	return fmt.Sprintf("%s binds the server to loopback, which the gateway can't reach from outside the container; listen on 0.0.0.0 instead", what)
+}
+
+// fixLoopbackString rewrites loopback listen addresses set by flags in a
+// command string to 0.0.0.0, keeping any port.
+func fixLoopbackString(s string) (string, bool) {
+	out := loopbackFlag.ReplaceAllString(s, "${1}${2}${3}${4}0.0.0.0${5}")
+	out = loopbackRunserver.ReplaceAllString(out, "${1}0.0.0.0${2}")
+	return out, out != s
+}
+
+// fixLoopbackArgs rewrites loopback listen addresses in an argument list,
+// returning the indexes of the arguments it changed.
+func fixLoopbackArgs(args []string) []int {
+	var changed []int
+	for i, arg := range args {
+		if i > 0 && bindFlagArg.MatchString(args[i-1]) && loopbackAddr.MatchString(arg) {
+			args[i] = "0.0.0.0" + loopbackAddr.FindStringSubmatch(arg)[1]
+			changed = append(changed, i)
+		} else if fixed, ok := fixLoopbackString(arg); ok {
+			args[i] = fixed
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+// fixLoopbackEnv rewrites the value of a listen-address variable naming
+// loopback to 0.0.0.0, keeping any port.
+func fixLoopbackEnv(name, value string) (string, bool) {
+	m := loopbackAddr.FindStringSubmatch(strings.Trim(value, `"'`))
+	if !bindEnv[name] || m == nil {
+		return value, false
+	}
+	return "0.0.0.0" + m[1], true
+}
+
+// loopbackBinds flags services whose command, entrypoint, environment or
+// Dockerfile binds the server to loopback. Dockerfile findings are reported
+// with the Dockerfile's path; composeDir resolves build contexts.
+func loopbackBinds(file, composeDir string, services []composeService) []Finding {
+	var findings []Finding
+	for _, svc := range services {
+		for _, key := range []string{"command", "entrypoint"} {
+			for _, n := range checkLoopbackArgs(mapValue(svc.node, key), false) {
+				findings = append(findings, Finding{file, n.Line, RuleLoopbackBind, svc.name, loopbackMessage("its " + key)})
+			}
+		}
+		env := mapValue(svc.node, "environment")
+		for _, n := range checkLoopbackEnv(env, false) {
+			name, _, _ := strings.Cut(n.Value, "=")
+			if env.Kind == yaml.MappingNode {
+				name = mapKey(env, n)
+			}
+			findings = append(findings, Finding{file, n.Line, RuleLoopbackBind, svc.name, loopbackMessage(name)})
+		}
+		if path := dockerfilePath(composeDir, mapValue(svc.node, "build")); path != "" {
+			for _, f := range dockerfileLoopbackBinds(path) {
+				f.Service = svc.name
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings
+}
+
+// checkLoopbackArgs returns the scalar nodes of a command or entrypoint
+// (string or list form) that bind to loopback, rewriting them if fix.
+func checkLoopbackArgs(n *yaml.Node, fix bool) []*yaml.Node {
+	if n == nil {
+		return nil
+	}
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if fixed, ok := fixLoopbackString(n.Value); ok {
+			if fix {
+				n.Value = fixed
+			}
+			return []*yaml.Node{n}
+		}
+	case yaml.SequenceNode:
+		args := make([]string, len(n.Content))
+		for i, item := range n.Content {
+			args[i] = item.Value
+		}
+		var found []*yaml.Node
+		for _, i := range fixLoopbackArgs(args) {
+			if fix {
+				n.Content[i].Value = args[i]
+			}
+			found = append(found, n.Content[i])
+		}
+		return found
+	}
+	return nil
+}
+
+// checkLoopbackEnv returns the value nodes (mapping form) or items (list
+// form) of an environment that bind to loopback, rewriting them if fix.
+func checkLoopbackEnv(env *yaml.Node, fix bool) []*yaml.Node {
+	if env == nil {
+		return nil
+	}
+	var found []*yaml.Node
+	switch env.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(env.Content); i += 2 {
+			if fixed, ok := fixLoopbackEnv(env.Content[i].Value, env.Content[i+1].Value); ok {
+				if fix {
+					env.Content[i+1].Value = fixed
+				}
+				found = append(found, env.Content[i+1])
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range env.Content {
+			name, value, _ := strings.Cut(item.Value, "=")
+			if fixed, ok := fixLoopbackEnv(name, value); ok {
+				if fix {
+					item.Value = name + "=" + fixed
+				}
+				found = append(found, item)
+			}
+		}
+	}
+	return found
+}
+
+// mapKey returns the key of value in a mapping node.
+func mapKey(n, value *yaml.Node) string {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i+1] == value {
+			return n.Content[i].Value
+		}
+	}
+	return ""
+}
+
+// FixLoopbackBinds rewrites loopback listen addresses in a compose
+// service's command, entrypoint and environment to 0.0.0.0, and reports
+// whether it changed anything. Dockerfiles are left alone.
+func FixLoopbackBinds(svc *yaml.Node) bool {
+	changed := len(checkLoopbackArgs(mapValue(svc, "command"), true)) > 0
+	changed = len(checkLoopbackArgs(mapValue(svc, "entrypoint"), true)) > 0 || changed
+	changed = len(checkLoopbackEnv(mapValue(svc, "environment"), true)) > 0 || changed
+	return changed
+}
+
+// dockerfilePath returns the local Dockerfile of a service's build, or ""
+// if it has none or builds from a remote context.
+func dockerfilePath(composeDir string, build *yaml.Node) string {
+	if build == nil {
+		return ""
+	}
+	context, dockerfile := build.Value, "Dockerfile"
+	if build.Kind == yaml.MappingNode {
+		context = "."
+		if c := mapValue(build, "context"); c != nil {
+			context = c.Value
+		}
+		if d := mapValue(build, "dockerfile"); d != nil {
+			dockerfile = d.Value
+		}
+	}
+	if context == "" || strings.Contains(context, "://") || strings.HasPrefix(context, "git@") {
+		return ""
+	}
+	if !filepath.IsAbs(context) {
+		context = filepath.Join(composeDir, context)
+	}
+	if filepath.IsAbs(dockerfile) {
+		return dockerfile
+	}
+	return filepath.Join(context, dockerfile)
+}
+
+// dockerfileLoopbackBinds flags CMD, ENTRYPOINT and ENV instructions in a
+// Dockerfile that bind to loopback. Findings carry the Dockerfile's path.
+// An unreadable Dockerfile yields none; missing-port covers broken builds.
+func dockerfileLoopbackBinds(path string) []Finding {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var findings []Finding
+	add := func(line int, what string) {
+		findings = append(findings, Finding{File: path, Line: line, Rule: RuleLoopbackBind, Message: loopbackMessage(what)})
+	}
+	scanner := bufio.NewScanner(f)
+	var instruction string
+	start := 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if instruction == "" {
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			start = line
+		}
+		if cont, ok := strings.CutSuffix(text, `\`); ok {
+			instruction += cont + " "
+			continue
+		}
+		instruction += text
+		keyword, args, _ := strings.Cut(instruction, " ")
+		args = strings.TrimSpace(args)
+		instruction = ""
+
+		switch strings.ToUpper(keyword) {
+		case "CMD", "ENTRYPOINT":
+			var list []string
+			if json.Unmarshal([]byte(args), &list) == nil {
+				if len(fixLoopbackArgs(list)) > 0 {
+					add(start, "its "+strings.ToUpper(keyword))
+				}
+			} else if _, ok := fixLoopbackString(args); ok {
+				add(start, "its "+strings.ToUpper(keyword))
+			}
+		case "ENV":
+			for _, name := range dockerfileEnvLoopback(args) {
+				add(start, name)
+			}
+		}
+	}
+	return findings
+}
+
+// dockerfileEnvLoopback returns the listen-address variables an ENV
+// instruction ("K=V K2=V2" or legacy "K V") sets to loopback.
+func dockerfileEnvLoopback(args string) []string {
+	var names []string
+	if name, value, ok := strings.Cut(args, " "); ok && !strings.Contains(name, "=") {
+		if _, loop := fixLoopbackEnv(name, strings.TrimSpace(value)); loop {
+			names = append(names, name)
+		}
+		return names
+	}
+	for _, field := range strings.Fields(args) {
+		name, value, _ := strings.Cut(field, "=")
+		if _, loop := fixLoopbackEnv(name, value); loop {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// LoopbackBinds reports the services of the project in dir (compose file
+// auto-detected if composeFile is empty) that bind their server to
+// loopback. File paths in findings are relative to dir.
+func LoopbackBinds(dir, composeFile string) ([]Finding, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+	composePath, err := adopt.ResolveComposeFile(absDir, composeFile)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseCompose(composePath)
+	if err != nil {
+		return nil, err
+	}
+	findings := loopbackBinds(relPath(absDir, composePath), filepath.Dir(composePath), doc)
+	for i := range findings {
+		findings[i].File = relPath(absDir, findings[i].File)
+	}
+	return findings, nil
+}
+
+// relPath returns path relative to dir, or path itself if it has none.
+func relPath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	if r, err := filepath.Rel(dir, path); err == nil {
+		return r
+	}
+	return path
+}
@@ -0,0 +1,116 @@
+package lint
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoopbackBinds(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "docker-compose.yml"), `services:
+  web:
+    image: node:20
+    command: npm run dev -- --host 127.0.0.1
+  api:
+    image: python:3
+    command: ["uvicorn", "app:app", "--host", "localhost", "--port", "8000"]
+    environment:
+      HOST: 127.0.0.1
+      DATABASE_URL: postgres://localhost:5432/app
+  admin:
+    build:
+      context: ./admin
+    environment:
+      - FLASK_RUN_HOST=localhost
+  ok:
+    image: node:20
+    command: npm run dev -- --host 0.0.0.0
+    environment:
+      HOSTNAME: myapp.localhost
+`)
+	writeFile(t, filepath.Join(dir, "admin", "Dockerfile"), `FROM python:3
+ENV PORT=8000 BIND_ADDRESS=127.0.0.1:8000
+CMD ["gunicorn", \
+     "-b", "127.0.0.1:8000", "app:app"]
+`)
+
+	findings, err := LoopbackBinds(dir, "")
+	if err != nil {
+		t.Fatalf("LoopbackBinds() error = %v", err)
+	}
+	type key struct {
+		file    string
+		line    int
+		service string
+	}
+	var got []key
+	for _, f := range findings {
+		if f.Rule != RuleLoopbackBind {
+			t.Errorf("rule = %q, want %q", f.Rule, RuleLoopbackBind)
+		}
+		got = append(got, key{f.File, f.Line, f.Service})
+	}
+	dockerfile := filepath.Join("admin", "Dockerfile")
+	want := []key{
+		{"docker-compose.yml", 4, "web"},
+		{"docker-compose.yml", 7, "api"},
+		{"docker-compose.yml", 9, "api"},
+		{"docker-compose.yml", 15, "admin"},
+		{dockerfile, 2, "admin"},
+		{dockerfile, 3, "admin"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings:\n%v\nwant:\n%v", findings, want)
+	}
+}
+
+func TestFixLoopbackString(t *testing.T) {
+	tests := map[string]string{
+		"npm run dev -- --host 127.0.0.1":           "npm run dev -- --host 0.0.0.0",
+		"vite --host=localhost --port 5173":         "vite --host=0.0.0.0 --port 5173",
+		"gunicorn -b 127.0.0.1:8000 app:app":        "gunicorn -b 0.0.0.0:8000 app:app",
+		"python manage.py runserver localhost:8000": "python manage.py runserver 0.0.0.0:8000",
+		`sh -c "next dev -H localhost"`:             `sh -c "next dev -H 0.0.0.0"`,
+		"npm run dev -- --host 0.0.0.0":             "npm run dev -- --host 0.0.0.0",
+		"curl http://localhost:3000":                "curl http://localhost:3000",
+		"app --hostname localhost.example.com":      "app --hostname localhost.example.com",
+	}
+	for in, want := range tests {
+		got, changed := fixLoopbackString(in)
+		if got != want || changed != (in != want) {
+			t.Errorf("fixLoopbackString(%q) = %q, %v; want %q", in, got, changed, want)
+		}
+	}
+}
+
+func TestFixLoopbackBinds(t *testing.T) {
+	var svc yaml.Node
+	err := yaml.Unmarshal([]byte(`command: ["flask", "run", "--host", "127.0.0.1:5000"]
+entrypoint: serve --listen localhost
+environment:
+  - HOST=localhost:3000
+  - API_URL=http://localhost:4000
+`), &svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !FixLoopbackBinds(svc.Content[0]) {
+		t.Fatal("FixLoopbackBinds() = false, want true")
+	}
+	out, err := yaml.Marshal(svc.Content[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"0.0.0.0:5000"`, "serve --listen 0.0.0.0", "HOST=0.0.0.0:3000", "API_URL=http://localhost:4000"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("fixed service missing %q:\n%s", want, out)
+		}
+	}
+	if FixLoopbackBinds(svc.Content[0]) {
+		t.Error("second FixLoopbackBinds() = true, want false")
+	}
+}
@@ -0,0 +1,157 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Fix is a proposed one-line edit to a proxy config inside a project.
+type Fix struct {
+	File string // relative to the project directory
+	Line int
+	Old  string
+	New  string
+}
+
+// nginxServerName matches an nginx server_name directive, capturing the
+// text before the names, the names, and the rest of the line.
+var nginxServerName = regexp.MustCompile(`^(\s*server_name\s+)([^;#]*?)\s*(;.*)$`)
+
+// nginxDirs are directories whose *.conf files are nginx configs.
+var nginxDirs = []string{"nginx", "conf.d", "sites-available", "sites-enabled"}
+
+// Fixes proposes edits to the project's Caddyfiles and nginx configs so
+// they accept requests the gateway forwards for hostnames. Only files with
+// a single site are patched: with several, which hostname belongs to which
+// site is ambiguous, so those are left to the user.
+func Fixes(dir string, hostnames []string) ([]Fix, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+
+	var fixes []Fix
+	caddyfiles, err := findCaddyfiles(absDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range caddyfiles {
+		fix, ok, err := caddyfileFix(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fixes = append(fixes, fix)
+		}
+	}
+
+	nginxConfs, err := findFiles(absDir, func(dir, name string) bool {
+		return name == "nginx.conf" || strings.HasSuffix(name, ".conf") && slices.Contains(nginxDirs, filepath.Base(dir))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range nginxConfs {
+		fix, ok, err := nginxFix(path, hostnames)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fixes = append(fixes, fix)
+		}
+	}
+
+	for i := range fixes {
+		if rel, err := filepath.Rel(absDir, fixes[i].File); err == nil {
+			fixes[i].File = rel
+		}
+	}
+	return fixes, nil
+}
+
+// caddyfileFix rewrites the address of a Caddyfile's only site block to
+// ":80" when it is addressed by hostname alone.
+func caddyfileFix(path string) (Fix, bool, error) {
+	sites, err := caddyfileSites(path)
+	if err != nil {
+		return Fix{}, false, err
+	}
+	var hostSites []siteLine
+	for _, s := range sites {
+		if len(s.addrs) > 0 && !slices.ContainsFunc(s.addrs, func(a string) bool { return !hostnameAddress(a) }) {
+			hostSites = append(hostSites, s)
+		}
+	}
+	if len(hostSites) != 1 {
+		return Fix{}, false, nil
+	}
+	s := hostSites[0]
+	indent := s.text[:len(s.text)-len(strings.TrimLeft(s.text, " \t"))]
+	brace := strings.Index(s.text, "{")
+	return Fix{path, s.line, s.text, indent + ":80 " + s.text[brace:]}, true, nil
+}
+
+// nginxFix adds the missing hostnames to the server_name of an nginx
+// config's only server. A catch-all server ("_") needs no fix.
+func nginxFix(path string, hostnames []string) (Fix, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fix{}, false, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var fix Fix
+	found := 0
+	for i, line := range strings.Split(string(data), "\n") {
+		m := nginxServerName.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		found++
+		names := strings.Fields(m[2])
+		if slices.Contains(names, "_") {
+			continue
+		}
+		var missing []string
+		for _, h := range hostnames {
+			if !slices.Contains(names, h) && !slices.Contains(missing, h) {
+				missing = append(missing, h)
+			}
+		}
+		if len(missing) > 0 {
+			fix = Fix{path, i + 1, line, m[1] + strings.Join(append(names, missing...), " ") + m[3]}
+		}
+	}
+	if found != 1 || fix.Line == 0 {
+		return Fix{}, false, nil
+	}
+	return fix, true, nil
+}
+
+// Apply writes fixes to the files under dir. A file changed since the fix
+// was proposed is left alone and reported as an error.
+func Apply(dir string, fixes []Fix) error {
+	for _, fix := range fixes {
+		path := filepath.Join(dir, fix.File)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", fix.File, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", fix.File, err)
+		}
+		lines := strings.Split(string(data), "\n")
+		if fix.Line < 1 || fix.Line > len(lines) || lines[fix.Line-1] != fix.Old {
+			return fmt.Errorf("%s:%d changed since the fix was proposed", fix.File, fix.Line)
+		}
+		lines[fix.Line-1] = fix.New
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+			return fmt.Errorf("writing %s: %w", fix.File, err)
+		}
+	}
+	return nil
+}
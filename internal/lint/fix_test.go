@@ -0,0 +1,60 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFixes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Caddyfile"), `{
+    admin off
+}
+
+myapp.example.com { # production
+    reverse_proxy api:3000
+}
+`)
+	// Several hostname sites: which one the gateway hostname belongs to is ambiguous.
+	writeFile(t, filepath.Join(dir, "docker", "Caddyfile.multi"), "a.example.com {\n}\nb.example.com {\n}\n")
+	// Already accepts any hostname on :80.
+	writeFile(t, filepath.Join(dir, "docker", "Caddyfile.ok"), ":80, myapp.example.com {\n}\n")
+	writeFile(t, filepath.Join(dir, "nginx", "default.conf"), `server {
+    listen 80;
+    server_name myapp.example.com;  # prod
+}
+`)
+	writeFile(t, filepath.Join(dir, "nginx.conf"), "server {\n    server_name _;\n}\n")
+	writeFile(t, filepath.Join(dir, "app", "settings.conf"), "server_name other.example.com;\n")
+
+	fixes, err := Fixes(dir, []string{"myapp.localhost", "api.myapp.localhost", "myapp.localhost"})
+	if err != nil {
+		t.Fatalf("Fixes() error = %v", err)
+	}
+	want := []Fix{
+		{"Caddyfile", 5, "myapp.example.com { # production", ":80 { # production"},
+		{filepath.Join("nginx", "default.conf"), 3, "    server_name myapp.example.com;  # prod",
+			"    server_name myapp.example.com myapp.localhost api.myapp.localhost;  # prod"},
+	}
+	if !reflect.DeepEqual(fixes, want) {
+		t.Errorf("Fixes() =\n%+v\nwant\n%+v", fixes, want)
+	}
+
+	if err := Apply(dir, fixes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "Caddyfile"))
+	if want := "{\n    admin off\n}\n\n:80 { # production\n    reverse_proxy api:3000\n}\n"; string(data) != want {
+		t.Errorf("patched Caddyfile =\n%s", data)
+	}
+	if fixes, _ := Fixes(dir, []string{"myapp.localhost", "api.myapp.localhost"}); len(fixes) != 0 {
+		t.Errorf("Fixes() after Apply = %+v, want none", fixes)
+	}
+
+	// A fix for a line that changed since is refused.
+	if err := Apply(dir, want[:1]); err == nil {
+		t.Error("expected error applying a stale fix")
+	}
+}
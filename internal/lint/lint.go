@@ -0,0 +1,370 @@
+package lint
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/adopt"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules reported by Run.
+const (
+	RuleCaddyfileHostname = "caddyfile-hostname" // project Caddyfile binds a hostname
+	RuleLocalhostEnv      = "localhost-env"      // env value points at localhost:PORT
+	RuleLoopbackBind      = "loopback-bind"      // server listens on 127.0.0.1 inside its container
+	RuleMissingPort       = "missing-port"       // built service with no detectable port
+	RulePortCollision     = "port-collision"     // host port published twice or shadowing the gateway
+)
+
+// Finding is one problem found in a project.
+type Finding struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Rule    string `json:"rule"`
+	Service string `json:"service,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	loc := f.File
+	if f.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", f.File, f.Line)
+	}
+	if f.Service != "" {
+		return fmt.Sprintf("%s: [%s] %s: %s", loc, f.Rule, f.Service, f.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", loc, f.Rule, f.Message)
+}
+
+// maxCaddyfileDepth limits how deep Run looks for project Caddyfiles.
+const maxCaddyfileDepth = 3
+
+// localhostURL matches loopback host:port references in env values.
+var localhostURL = regexp.MustCompile(`\b(localhost|127\.0\.0\.1):(\d+)`)
+
+// Run lints the project in dir and its compose file (auto-detected if
+// composeFile is empty). File paths in findings are relative to dir.
+func Run(dir, composeFile string) ([]Finding, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+	composePath, err := adopt.ResolveComposeFile(absDir, composeFile)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := config.LoadProjectFile(absDir)
+	if err != nil {
+		return nil, err
+	}
+	services, err := adopt.ScanComposeFile(absDir, composePath, pf)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseCompose(composePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := func(path string) string { return relPath(absDir, path) }
+	file := rel(composePath)
+
+	var findings []Finding
+	findings = append(findings, localhostEnv(file, doc)...)
+	findings = append(findings, missingPorts(file, doc, services)...)
+	findings = append(findings, portCollisions(file, doc)...)
+	for _, f := range loopbackBinds(file, filepath.Dir(composePath), doc) {
+		f.File = rel(f.File)
+		findings = append(findings, f)
+	}
+
+	caddyfiles, err := findCaddyfiles(absDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range caddyfiles {
+		found, err := caddyfileHostnames(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			f.File = rel(path)
+			findings = append(findings, f)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+// composeService is a service definition with the YAML nodes lint needs.
+type composeService struct {
+	name string
+	node *yaml.Node // the service's mapping
+}
+
+// parseCompose returns the services of a compose file in file order.
+func parseCompose(path string) ([]composeService, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	servicesNode := mapValue(root.Content[0], "services")
+	if servicesNode == nil || servicesNode.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	var services []composeService
+	for i := 0; i+1 < len(servicesNode.Content); i += 2 {
+		if svc := servicesNode.Content[i+1]; svc.Kind == yaml.MappingNode {
+			services = append(services, composeService{servicesNode.Content[i].Value, svc})
+		}
+	}
+	return services, nil
+}
+
+// mapValue returns the value node for key in a mapping node, or nil.
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// localhostEnv flags environment values that reach for localhost:PORT,
+// which inside a container is the container itself, not the host or
+// another service.
+func localhostEnv(file string, services []composeService) []Finding {
+	var findings []Finding
+	for _, svc := range services {
+		env := mapValue(svc.node, "environment")
+		if env == nil {
+			continue
+		}
+		check := func(name, value string, line int) {
+			m := localhostURL.FindStringSubmatch(value)
+			if m == nil {
+				return
+			}
+			if _, ok := fixLoopbackEnv(name, value); ok {
+				return // a listen address, reported by loopback-bind
+			}
+			findings = append(findings, Finding{file, line, RuleLocalhostEnv, svc.name,
+				fmt.Sprintf("%s points at %s, which is the container itself; use the service name or its caddy-atc hostname (ATC_PUBLIC_URL)", name, m[0])})
+		}
+		switch env.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(env.Content); i += 2 {
+				check(env.Content[i].Value, env.Content[i+1].Value, env.Content[i+1].Line)
+			}
+		case yaml.SequenceNode:
+			for _, item := range env.Content {
+				name, value, _ := strings.Cut(item.Value, "=")
+				check(name, value, item.Line)
+			}
+		}
+	}
+	return findings
+}
+
+// missingPorts flags built services whose HTTP port can't be detected, so
+// neither adopt nor the watcher can route them.
+func missingPorts(file string, services []composeService, detected []adopt.ComposeService) []Finding {
+	byName := make(map[string]adopt.ComposeService, len(detected))
+	for _, d := range detected {
+		byName[d.Name] = d
+	}
+	var findings []Finding
+	for _, svc := range services {
+		build := mapValue(svc.node, "build")
+		d := byName[svc.name]
+		if build == nil || d.Ignored || d.IsHTTP || len(d.Ports) > 0 {
+			continue
+		}
+		findings = append(findings, Finding{file, build.Line, RuleMissingPort, svc.name,
+			fmt.Sprintf("no port declared; if it serves HTTP, add EXPOSE to its Dockerfile or a %s label, otherwise label it %s=true", config.PortLabel, config.IgnoreLabel)})
+	}
+	return findings
+}
+
+// portCollisions flags host ports published by more than one service, and
+// ports 80 and 443, which the gateway publishes.
+func portCollisions(file string, services []composeService) []Finding {
+	owner := make(map[string]string)
+	var findings []Finding
+	for _, svc := range services {
+		ports := mapValue(svc.node, "ports")
+		if ports == nil || ports.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, p := range ports.Content {
+			host := publishedPort(p)
+			if host == "" {
+				continue
+			}
+			switch {
+			case host == "80" || host == "443":
+				findings = append(findings, Finding{file, p.Line, RulePortCollision, svc.name,
+					fmt.Sprintf("publishes host port %s, which the caddy-atc gateway uses; route it through its hostname instead", host)})
+			case owner[host] != "" && owner[host] != svc.name:
+				findings = append(findings, Finding{file, p.Line, RulePortCollision, svc.name,
+					fmt.Sprintf("host port %s is also published by %s", host, owner[host])})
+			default:
+				owner[host] = svc.name
+			}
+		}
+	}
+	return findings
+}
+
+// publishedPort returns the host port of a compose port entry in short
+// ("127.0.0.1:8080:80/tcp") or long ({published: 8080}) syntax, or "" if
+// it publishes none.
+func publishedPort(n *yaml.Node) string {
+	if n.Kind == yaml.MappingNode {
+		if p := mapValue(n, "published"); p != nil {
+			return p.Value
+		}
+		return ""
+	}
+	spec, _, _ := strings.Cut(n.Value, "/")
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 {
+		return "" // container port only; Docker picks a random host port
+	}
+	return parts[len(parts)-2]
+}
+
+// findCaddyfiles returns Caddyfiles within the project, other than its
+// gateway snippet.
+func findCaddyfiles(root string) ([]string, error) {
+	return findFiles(root, func(_, name string) bool {
+		if name == config.SnippetFileName {
+			return false
+		}
+		return name == "Caddyfile" || strings.HasPrefix(name, "Caddyfile.") || strings.HasSuffix(name, ".Caddyfile")
+	})
+}
+
+// findFiles returns the files within the project for which match, given the
+// file's directory and name, is true, skipping hidden and dependency
+// directories.
+func findFiles(root string, match func(dir, name string) bool) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable entries are not the project's problem
+		}
+		if d.IsDir() {
+			name := d.Name()
+			depth := strings.Count(strings.TrimPrefix(path, root), string(filepath.Separator))
+			if path != root && (strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" || depth > maxCaddyfileDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if match(filepath.Dir(path), d.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// siteLine is the opening line of a top-level Caddyfile site block.
+type siteLine struct {
+	line  int
+	text  string // the line as written
+	addrs []string
+}
+
+// caddyfileSites returns the opening lines of the top-level site blocks in
+// a Caddyfile.
+func caddyfileSites(path string) ([]siteLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var sites []siteLine
+	depth := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if depth == 0 && strings.HasSuffix(text, "{") {
+			sites = append(sites, siteLine{line, raw, siteAddresses(strings.TrimSuffix(text, "{"))})
+		}
+		depth += strings.Count(text, "{") - strings.Count(text, "}")
+		depth = max(depth, 0)
+	}
+	return sites, scanner.Err()
+}
+
+// caddyfileHostnames flags top-level site blocks addressed by hostname.
+// Behind the gateway, a project's Caddy receives plain HTTP and should
+// listen on ":80" instead.
+func caddyfileHostnames(path string) ([]Finding, error) {
+	sites, err := caddyfileSites(path)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, s := range sites {
+		for _, addr := range s.addrs {
+			if hostnameAddress(addr) {
+				findings = append(findings, Finding{Line: s.line, Rule: RuleCaddyfileHostname,
+					Message: fmt.Sprintf("site address %q only matches that hostname; use ':80' so it accepts HTTP from the gateway", addr)})
+			}
+		}
+	}
+	return findings, nil
+}
+
+func siteAddresses(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// hostnameAddress reports whether a site address names a host, rather than
+// being a bare port (":80"), a snippet ("(name)") or a placeholder.
+func hostnameAddress(addr string) bool {
+	if strings.HasPrefix(addr, "(") || strings.HasPrefix(addr, "{") {
+		return false
+	}
+	if _, rest, ok := strings.Cut(addr, "://"); ok {
+		addr = rest
+	}
+	host := addr
+	if i := strings.IndexAny(host, ":/"); i >= 0 {
+		host = host[:i]
+	}
+	return host != ""
+}
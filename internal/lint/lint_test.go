@@ -0,0 +1,134 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "docker-compose.yml"), `services:
+  web:
+    image: caddy
+    ports:
+      - "80:80"
+  api:
+    build: ./api
+    ports:
+      - "8080:3000"
+    environment:
+      DATABASE_URL: postgres://db:5432/app
+      AUTH_URL: http://localhost:4000/auth
+  admin:
+    build: ./admin
+    ports:
+      - "127.0.0.1:8080:3001"
+    environment:
+      - API=http://127.0.0.1:8080
+  worker:
+    build: ./worker
+  cron:
+    build: ./cron
+    labels:
+      caddy-atc.ignore: "true"
+`)
+	writeFile(t, filepath.Join(dir, "Caddyfile"), `{
+    admin off
+}
+
+(common) {
+    encode gzip
+}
+
+myapp.localhost, :80 {
+    import common
+    reverse_proxy api:3000
+}
+`)
+	writeFile(t, filepath.Join(dir, "node_modules", "pkg", "Caddyfile"), "example.com {\n}\n")
+
+	findings, err := Run(dir, "")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	type key struct {
+		file    string
+		line    int
+		rule    string
+		service string
+	}
+	var got []key
+	for _, f := range findings {
+		got = append(got, key{f.File, f.Line, f.Rule, f.Service})
+	}
+	want := []key{
+		{"Caddyfile", 9, RuleCaddyfileHostname, ""},
+		{"docker-compose.yml", 5, RulePortCollision, "web"},
+		{"docker-compose.yml", 12, RuleLocalhostEnv, "api"},
+		{"docker-compose.yml", 16, RulePortCollision, "admin"},
+		{"docker-compose.yml", 18, RuleLocalhostEnv, "admin"},
+		{"docker-compose.yml", 20, RuleMissingPort, "worker"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings:\n%v\nwant:\n%v", findings, want)
+	}
+}
+
+func TestRun_NoComposeFile(t *testing.T) {
+	if _, err := Run(t.TempDir(), ""); err == nil {
+		t.Error("expected error without a compose file")
+	}
+}
+
+func TestPublishedPort(t *testing.T) {
+	tests := map[string]string{
+		"80":                  "",
+		"8080:80":             "8080",
+		"127.0.0.1:8080:80":   "8080",
+		"9000:9000/udp":       "9000",
+		"[::1]:3000:3000/tcp": "3000",
+	}
+	for spec, want := range tests {
+		n := scalar(spec)
+		if got := publishedPort(n); got != want {
+			t.Errorf("publishedPort(%q) = %q, want %q", spec, got, want)
+		}
+	}
+}
+
+func TestHostnameAddress(t *testing.T) {
+	tests := map[string]bool{
+		":80":                    false,
+		"http://:80":             false,
+		"(snippet)":              false,
+		"{$SITE_ADDRESS}":        false,
+		"myapp.localhost":        true,
+		"localhost:8080":         true,
+		"https://api.localhost":  true,
+		"http://myapp.localhost": true,
+	}
+	for addr, want := range tests {
+		if got := hostnameAddress(addr); got != want {
+			t.Errorf("hostnameAddress(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func scalar(v string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: v}
+}
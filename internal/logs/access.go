@@ -0,0 +1,108 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// accessEntry is the part of a Caddy access log line that requests are
+// filtered by.
+type accessEntry struct {
+	Request struct {
+		Method string `json:"method"`
+	} `json:"request"`
+	Status int `json:"status"`
+}
+
+// accessFilter passes through only complete access log lines whose request
+// matches the method and status filters. Anything that isn't a JSON log
+// line, such as tail's file headers, is dropped.
+type accessFilter struct {
+	w       io.Writer
+	methods map[string]bool // nil matches any method
+	status  []statusRange   // nil matches any status
+	buf     []byte
+}
+
+// statusRange is an inclusive range of HTTP statuses.
+type statusRange struct{ lo, hi int }
+
+// newAccessFilter parses comma-separated methods ("GET,POST") and statuses
+// ("404", "5xx"), either of which may be empty.
+func newAccessFilter(w io.Writer, methods, statuses string) (*accessFilter, error) {
+	f := &accessFilter{w: w}
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		if f.methods == nil {
+			f.methods = make(map[string]bool)
+		}
+		f.methods[strings.ToUpper(m)] = true
+	}
+	for _, s := range strings.Split(statuses, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s == "" {
+			continue
+		}
+		r, err := parseStatus(s)
+		if err != nil {
+			return nil, err
+		}
+		f.status = append(f.status, r)
+	}
+	return f, nil
+}
+
+// parseStatus parses a status ("404") or a status class ("4xx").
+func parseStatus(s string) (statusRange, error) {
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		lo := int(s[0]-'0') * 100
+		return statusRange{lo, lo + 99}, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 100 || code > 599 {
+		return statusRange{}, fmt.Errorf("invalid status %q (want e.g. 404 or 5xx)", s)
+	}
+	return statusRange{code, code}, nil
+}
+
+func (f *accessFilter) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := f.buf[:i+1]
+		f.buf = f.buf[i+1:]
+		if f.match(line) {
+			if _, err := f.w.Write(line); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (f *accessFilter) match(line []byte) bool {
+	var e accessEntry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return false
+	}
+	if f.methods != nil && !f.methods[e.Request.Method] {
+		return false
+	}
+	if f.status == nil {
+		return true
+	}
+	for _, r := range f.status {
+		if e.Status >= r.lo && e.Status <= r.hi {
+			return true
+		}
+	}
+	return false
+}
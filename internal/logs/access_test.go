@@ -0,0 +1,45 @@
+package logs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAccessFilter(t *testing.T) {
+	var out bytes.Buffer
+	f, err := newAccessFilter(&out, "post, put", "5xx,404")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get404 := `{"logger":"http.log.access.log0","request":{"method":"GET","host":"app.localhost","uri":"/x"},"status":404}` + "\n"
+	post502 := `{"logger":"http.log.access.log0","request":{"method":"POST","host":"app.localhost","uri":"/api"},"status":502}` + "\n"
+	put404 := `{"logger":"http.log.access.log0","request":{"method":"PUT","host":"app.localhost","uri":"/api"},"status":404}` + "\n"
+	post200 := `{"logger":"http.log.access.log0","request":{"method":"POST","host":"app.localhost","uri":"/api"},"status":200}` + "\n"
+
+	// Lines split across writes are matched once complete.
+	f.Write([]byte("==> /data/access/app.localhost.log <==\n" + get404 + post502[:20]))
+	f.Write([]byte(post502[20:] + put404 + post200))
+
+	if want := post502 + put404; out.String() != want {
+		t.Errorf("filtered output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := map[string]statusRange{
+		"404": {404, 404},
+		"5xx": {500, 599},
+		"1xx": {100, 199},
+	}
+	for s, want := range tests {
+		if got, err := parseStatus(s); err != nil || got != want {
+			t.Errorf("parseStatus(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"6xx", "99", "abc", "40x", "600"} {
+		if _, err := parseStatus(s); err == nil {
+			t.Errorf("parseStatus(%q) accepted an invalid status", s)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/routes"
+)
+
+// Log scopes shown by `caddy-atc logs`.
+const (
+	ScopeWatcher = "watcher"
+	ScopeGateway = "gateway"
+	ScopeAccess  = "access"
+)
+
+// Scopes lists the valid scopes, default first.
+var Scopes = []string{ScopeWatcher, ScopeGateway, ScopeAccess}
+
+// tailLines is how many watcher log lines are shown before following.
+const tailLines = 100
+
+// pollInterval is how often a followed watcher log is checked for growth.
+const pollInterval = 500 * time.Millisecond
+
+// Options configures Show.
+type Options struct {
+	Scope   string // one of Scopes; empty means ScopeWatcher
+	Project string // limit output to one adopted project
+
+	// Hosts, Method and Status filter the access scope: only the logs of
+	// Hosts, and only requests with one of the comma-separated methods
+	// ("GET,POST") and statuses ("404", "5xx").
+	Hosts  []string
+	Method string
+	Status string
+
+	// Gateway merges the Caddy container's output into the watcher scope,
+	// in time order with each line prefixed by its source.
+	Gateway bool
+
+	Follow bool
+}
+
+// Show writes the logs of one scope to w.
+func Show(ctx context.Context, w io.Writer, opts Options) error {
+	if opts.Scope != ScopeAccess && (len(opts.Hosts) > 0 || opts.Method != "" || opts.Status != "") {
+		return fmt.Errorf("hostname, method and status filters apply to access logs only")
+	}
+	if opts.Gateway && opts.Scope != ScopeWatcher && opts.Scope != "" {
+		return fmt.Errorf("--gateway merges gateway output into the watcher log; use `caddy-atc logs gateway` to show it alone")
+	}
+	if len(opts.Hosts) > 0 && opts.Project != "" {
+		return fmt.Errorf("give hostnames or --project, not both")
+	}
+	for _, h := range opts.Hosts {
+		if err := config.ValidateHostname(h); err != nil {
+			return err
+		}
+	}
+
+	var hosts, needles []string
+	if opts.Project != "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+		proj, ok := cfg.Projects[opts.Project]
+		if !ok {
+			return fmt.Errorf("project %q is not adopted", opts.Project)
+		}
+		// Hostname labels can route containers to hostnames that are not
+		// in projects.yml, so include what is routed right now.
+		var active []routes.ActiveRoute
+		if opts.Scope != ScopeWatcher && opts.Scope != "" {
+			active, _ = routes.ListActive(ctx)
+		}
+		hosts = projectHosts(proj, active)
+		needles = append([]string{proj.ComposeProject}, hosts...)
+	}
+
+	switch opts.Scope {
+	case ScopeWatcher, "":
+		if needles != nil {
+			w = &lineFilter{w: w, needles: needles}
+		}
+		if opts.Gateway {
+			return showMerged(ctx, w, opts.Follow)
+		}
+		return showWatcher(ctx, w, opts.Follow)
+	case ScopeGateway:
+		if needles != nil {
+			w = &lineFilter{w: w, needles: needles}
+		}
+		return gateway.Logs(ctx, w, opts.Follow)
+	case ScopeAccess:
+		if opts.Project != "" && len(hosts) == 0 {
+			return fmt.Errorf("project %q has no hostnames", opts.Project)
+		}
+		if len(opts.Hosts) > 0 {
+			hosts = opts.Hosts
+		}
+		if opts.Method == "" && opts.Status == "" {
+			return gateway.AccessLogs(ctx, w, hosts, opts.Follow, false)
+		}
+		f, err := newAccessFilter(w, opts.Method, opts.Status)
+		if err != nil {
+			return err
+		}
+		// Matching requests may be rare, so search the whole files.
+		return gateway.AccessLogs(ctx, f, hosts, opts.Follow, true)
+	}
+	return fmt.Errorf("unknown log scope %q (want one of: %s)", opts.Scope, strings.Join(Scopes, ", "))
+}
+
+// projectHosts returns the sorted hostnames of a project: its configured
+// hostnames plus those of its active routes.
+func projectHosts(proj *config.ProjectConfig, active []routes.ActiveRoute) []string {
+	seen := make(map[string]bool)
+	add := func(h string) {
+		if h != "" && config.ValidateHostname(h) == nil {
+			seen[h] = true
+		}
+	}
+	add(proj.Hostname)
+	for _, h := range proj.Services {
+		add(h)
+	}
+	for _, r := range active {
+		if r.Project == proj.ComposeProject {
+			add(r.Hostname)
+		}
+	}
+
+	hosts := make([]string, 0, len(seen))
+	for h := range seen {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// showWatcher writes the watcher log. When following, it starts with the
+// last tailLines lines and then polls for appended data.
+func showWatcher(ctx context.Context, w io.Writer, follow bool) error {
+	path := config.LogPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !follow {
+		fmt.Fprintln(w, "No watcher logs found.")
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading watcher log: %w", err)
+	}
+	if !follow {
+		_, err := w.Write(data)
+		return err
+	}
+
+	if _, err := w.Write(lastLines(data, tailLines)); err != nil {
+		return err
+	}
+	return followWatcher(ctx, w, path, int64(len(data)))
+}
+
+// followWatcher polls the watcher log at path for data appended after
+// offset and copies it to w until ctx is done; a file that shrinks
+// (truncated or replaced) is read again from the start.
+func followWatcher(ctx context.Context, w io.Writer, path string, offset int64) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // not created yet, or being replaced
+		}
+		if info.Size() < offset {
+			offset = 0
+		}
+		if info.Size() == offset {
+			continue
+		}
+		n, err := copyFrom(w, path, offset)
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+}
+
+// copyFrom copies path from offset to EOF into w.
+func copyFrom(w io.Writer, path string, offset int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil // replaced between stat and open; retry next tick
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("reading watcher log: %w", err)
+	}
+	return io.Copy(w, f)
+}
+
+// lastLines returns the final n lines of data.
+func lastLines(data []byte, n int) []byte {
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+// lineFilter passes through only complete lines containing one of needles.
+type lineFilter struct {
+	w       io.Writer
+	needles []string
+	buf     []byte
+}
+
+func (f *lineFilter) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := f.buf[:i+1]
+		f.buf = f.buf[i+1:]
+		if f.match(line) {
+			if _, err := f.w.Write(line); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (f *lineFilter) match(line []byte) bool {
+	for _, n := range f.needles {
+		if n != "" && bytes.Contains(line, []byte(n)) {
+			return true
+		}
+	}
+	return false
+}
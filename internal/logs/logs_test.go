@@ -0,0 +1,122 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/routes"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func TestLineFilter(t *testing.T) {
+	var out bytes.Buffer
+	f := &lineFilter{w: &out, needles: []string{"myapp", "api.localhost"}}
+
+	// Lines split across writes are matched once complete.
+	f.Write([]byte("Route added: myapp.localhost -> myapp-web-1:3000\nRoute added: other"))
+	f.Write([]byte(".localhost -> other-web-1:80\n{\"host\":\"api.localhost\"}\npartial myapp"))
+
+	want := "Route added: myapp.localhost -> myapp-web-1:3000\n{\"host\":\"api.localhost\"}\n"
+	if out.String() != want {
+		t.Errorf("filtered output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		data string
+		n    int
+		want string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\n", 5, "a\nb\n"},
+		{"", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := string(lastLines([]byte(tt.data), tt.n)); got != tt.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", tt.data, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProjectHosts(t *testing.T) {
+	proj := &config.ProjectConfig{
+		ComposeProject: "myapp",
+		Hostname:       "myapp.localhost",
+		Services:       map[string]string{"web": "myapp.localhost", "api": "api.myapp.localhost"},
+	}
+	active := []routes.ActiveRoute{
+		{Hostname: "admin.localhost", Project: "myapp"},
+		{Hostname: "other.localhost", Project: "other"},
+		{Hostname: "bad host", Project: "myapp"},
+	}
+
+	got := projectHosts(proj, active)
+	want := []string{"admin.localhost", "api.myapp.localhost", "myapp.localhost"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectHosts() = %v, want %v", got, want)
+	}
+}
+
+func TestShow_Watcher(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := config.EnsureHomeDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Show(context.Background(), &out, Options{}); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "No watcher logs found.") {
+		t.Errorf("Show() without a log = %q", out.String())
+	}
+
+	log := "Route added: myapp.localhost -> myapp-web-1:3000\nRoute added: other.localhost -> other-web-1:80\n"
+	if err := os.WriteFile(config.LogPath(), []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"myapp": {ComposeProject: "myapp", Hostname: "myapp.localhost", Services: map[string]string{"web": "myapp.localhost"}},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := Show(context.Background(), &out, Options{Scope: ScopeWatcher, Project: "myapp"}); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	if want := "Route added: myapp.localhost -> myapp-web-1:3000\n"; out.String() != want {
+		t.Errorf("Show(--project myapp) = %q, want %q", out.String(), want)
+	}
+
+	if err := Show(context.Background(), &out, Options{Project: "missing"}); err == nil {
+		t.Error("expected error for unknown project")
+	}
+	if err := Show(context.Background(), &out, Options{Scope: "caddy"}); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+	if err := Show(context.Background(), &out, Options{Scope: ScopeAccess, Gateway: true}); err == nil {
+		t.Error("expected error for --gateway outside the watcher scope")
+	}
+	if err := Show(context.Background(), &out, Options{Status: "5xx"}); err == nil {
+		t.Error("expected error for a status filter on the watcher log")
+	}
+	if err := Show(context.Background(), &out, Options{Scope: ScopeAccess, Hosts: []string{"myapp.localhost"}, Project: "myapp"}); err == nil {
+		t.Error("expected error for hostnames with --project")
+	}
+	if err := Show(context.Background(), &out, Options{Scope: ScopeAccess, Hosts: []string{"not a host"}}); err == nil {
+		t.Error("expected error for an invalid hostname")
+	}
+}
@@ -0,0 +1,208 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// Prefixes marking the source of each line in merged output.
+const (
+	watcherPrefix = "watcher | "
+	gatewayPrefix = "gateway | "
+)
+
+// legacyLayout is the timestamp of watcher logs written before they were
+// structured, after the "[caddy-atc] " prefix.
+const legacyLayout = "2006/01/02 15:04:05"
+
+// logLine is one line of merged output.
+type logLine struct {
+	at   time.Time
+	text []byte // prefixed, with its newline
+}
+
+// showMerged writes the watcher log and the gateway's output interleaved
+// in time order, each line prefixed with its source. When following, it
+// starts with the last tailLines lines of each and then streams both.
+func showMerged(ctx context.Context, w io.Writer, follow bool) error {
+	path := config.LogPath()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading watcher log: %w", err)
+	}
+	offset := int64(len(data))
+	if follow {
+		data = lastLines(data, tailLines)
+	}
+
+	fetched := time.Now()
+	var gw bytes.Buffer
+	gwErr := gateway.TimestampedLogs(ctx, &gw, false, time.Time{})
+
+	watcherLines := splitLines(data, watcherPrefix, watcherLineTime)
+	gatewayLines := splitLines(gw.Bytes(), gatewayPrefix, gatewayLineTime)
+	for _, l := range mergeLines(watcherLines, gatewayLines) {
+		if _, err := w.Write(l.text); err != nil {
+			return err
+		}
+	}
+	if gwErr != nil {
+		fmt.Fprintf(w, "%sunavailable: %v\n", gatewayPrefix, gwErr)
+	}
+	if !follow {
+		return nil
+	}
+
+	// Continue the gateway stream just after its last line, so nothing is
+	// shown twice.
+	since := fetched
+	if n := len(gatewayLines); n > 0 && !gatewayLines[n-1].at.IsZero() {
+		since = gatewayLines[n-1].at.Add(time.Nanosecond)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	if gwErr == nil {
+		wg.Go(func() {
+			pw := &prefixWriter{mu: &mu, w: w, prefix: gatewayPrefix, stripTime: true}
+			if err := gateway.TimestampedLogs(ctx, pw, true, since); err != nil && ctx.Err() == nil {
+				pw.Write([]byte(fmt.Sprintf("unavailable: %v\n", err)))
+			}
+		})
+	}
+	err = followWatcher(ctx, &prefixWriter{mu: &mu, w: w, prefix: watcherPrefix}, path, offset)
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// splitLines splits data into prefixed lines timed by lineTime. The prefix
+// replaces the part of a line lineTime reports as consumed. Lines without
+// a time, such as the rest of a stack trace, take the previous line's.
+func splitLines(data []byte, prefix string, lineTime func([]byte) (time.Time, int)) []logLine {
+	var lines []logLine
+	var last time.Time
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		at, skip := lineTime(line)
+		if at.IsZero() {
+			at = last
+		}
+		last = at
+		text := make([]byte, 0, len(prefix)+len(line)-skip+1)
+		text = append(append(append(text, prefix...), line[skip:]...), '\n')
+		lines = append(lines, logLine{at: at, text: text})
+	}
+	return lines
+}
+
+// mergeLines interleaves two sources in time order. Lines with the same
+// time, and lines before the first timed one, keep their order with the
+// watcher's first.
+func mergeLines(watcher, gw []logLine) []logLine {
+	merged := append(append([]logLine(nil), watcher...), gw...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].at.Before(merged[j].at)
+	})
+	return merged
+}
+
+// watcherLineTime returns the time of a watcher log line, written by the
+// text or JSON logger or by versions before structured logging. The line
+// is kept whole.
+func watcherLineTime(line []byte) (time.Time, int) {
+	var value []byte
+	switch {
+	case bytes.HasPrefix(line, []byte("time=")):
+		value = line[len("time="):]
+		if i := bytes.IndexByte(value, ' '); i >= 0 {
+			value = value[:i]
+		}
+	case bytes.HasPrefix(line, []byte(`{"time":"`)):
+		value = line[len(`{"time":"`):]
+		if i := bytes.IndexByte(value, '"'); i >= 0 {
+			value = value[:i]
+		}
+	case bytes.HasPrefix(line, []byte("[caddy-atc] ")):
+		value = line[len("[caddy-atc] "):]
+		if len(value) < len(legacyLayout) {
+			return time.Time{}, 0
+		}
+		t, _ := time.ParseInLocation(legacyLayout, string(value[:len(legacyLayout)]), time.Local)
+		return t, 0
+	default:
+		return time.Time{}, 0
+	}
+	t, _ := time.Parse(time.RFC3339Nano, string(value))
+	return t, 0
+}
+
+// gatewayLineTime returns the Docker timestamp leading a gateway log line
+// and the length of it and the space after it.
+func gatewayLineTime(line []byte) (time.Time, int) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		i = len(line)
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(line[:i]))
+	if err != nil {
+		return time.Time{}, 0
+	}
+	if i < len(line) {
+		i++
+	}
+	return t, i
+}
+
+// prefixWriter writes complete lines to w with a prefix, holding mu so
+// lines from concurrent sources don't interleave. With stripTime, the
+// Docker timestamp leading each line is dropped.
+type prefixWriter struct {
+	mu        *sync.Mutex
+	w         io.Writer
+	prefix    string
+	stripTime bool
+	buf       []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := p.buf[:i+1]
+		p.buf = p.buf[i+1:]
+		if p.stripTime {
+			_, skip := gatewayLineTime(line[:i])
+			line = line[skip:]
+		}
+		p.mu.Lock()
+		_, err := io.WriteString(p.w, p.prefix)
+		if err == nil {
+			_, err = p.w.Write(line)
+		}
+		p.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,73 @@
+package logs
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMergeLines(t *testing.T) {
+	watcher := "time=2026-10-16T10:00:01.000Z level=INFO msg=\"Route added\" hostname=app.localhost\n" +
+		"time=2026-10-16T10:00:03.000Z level=WARN msg=\"Upstream down\"\n" +
+		"goroutine 1 [running]:\n" +
+		"{\"time\":\"2026-10-16T10:00:05Z\",\"level\":\"INFO\",\"msg\":\"Route removed\"}\n"
+	gateway := "2026-10-16T10:00:00.500000000Z {\"msg\":\"serving initial configuration\"}\n" +
+		"2026-10-16T10:00:03.000000000Z {\"msg\":\"dial tcp: connection refused\"}\n" +
+		"2026-10-16T10:00:04.000000000Z {\"msg\":\"aborting with incomplete response\"}\n"
+
+	var out bytes.Buffer
+	for _, l := range mergeLines(
+		splitLines([]byte(watcher), watcherPrefix, watcherLineTime),
+		splitLines([]byte(gateway), gatewayPrefix, gatewayLineTime),
+	) {
+		out.Write(l.text)
+	}
+
+	want := "gateway | {\"msg\":\"serving initial configuration\"}\n" +
+		"watcher | time=2026-10-16T10:00:01.000Z level=INFO msg=\"Route added\" hostname=app.localhost\n" +
+		"watcher | time=2026-10-16T10:00:03.000Z level=WARN msg=\"Upstream down\"\n" +
+		"watcher | goroutine 1 [running]:\n" +
+		"gateway | {\"msg\":\"dial tcp: connection refused\"}\n" +
+		"gateway | {\"msg\":\"aborting with incomplete response\"}\n" +
+		"watcher | {\"time\":\"2026-10-16T10:00:05Z\",\"level\":\"INFO\",\"msg\":\"Route removed\"}\n"
+	if out.String() != want {
+		t.Errorf("merged =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWatcherLineTime(t *testing.T) {
+	legacy := time.Date(2026, 10, 16, 10, 0, 0, 0, time.Local)
+	tests := []struct {
+		line string
+		want time.Time
+	}{
+		{"time=2026-10-16T10:00:00.250+02:00 level=INFO msg=x", time.Date(2026, 10, 16, 8, 0, 0, 250e6, time.UTC)},
+		{`{"time":"2026-10-16T08:00:00Z","msg":"x"}`, time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)},
+		{"[caddy-atc] 2026/10/16 10:00:00 Route added: app.localhost", legacy},
+		{"[caddy-atc] 2026/10", time.Time{}},
+		{"panic: oops", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got, _ := watcherLineTime([]byte(tt.line)); !got.Equal(tt.want) {
+			t.Errorf("watcherLineTime(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	gw := &prefixWriter{mu: &mu, w: &out, prefix: gatewayPrefix, stripTime: true}
+	wa := &prefixWriter{mu: &mu, w: &out, prefix: watcherPrefix}
+
+	// Partial lines wait for their newline.
+	gw.Write([]byte("2026-10-16T10:00:00.000000000Z started"))
+	wa.Write([]byte("Route added\n"))
+	gw.Write([]byte(" serving\nno timestamp\n"))
+
+	want := "watcher | Route added\ngateway | started serving\ngateway | no timestamp\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
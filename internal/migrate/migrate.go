@@ -0,0 +1,353 @@
+// Package migrate bundles a machine's caddy-atc state into an encrypted
+// archive and restores it on another machine.
+package migrate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"gopkg.in/yaml.v3"
+)
+
+// Archive layout. Config files are stored under home/ with their path in
+// the legacy ~/.caddy-atc layout; the gateway's generated CA under localca/.
+const (
+	homePrefix    = "home/"
+	localCAPrefix = "localca/"
+	teamCAPrefix  = "caddyfile/ca/"
+)
+
+// Encryption parameters. The archive is magic | salt | nonce | AES-256-GCM
+// ciphertext, keyed with PBKDF2-SHA256 from the passphrase.
+const (
+	magic         = "caddy-atc-migrate-v1\n"
+	saltSize      = 16
+	kdfIterations = 600_000
+	maxBundleSize = 64 << 20 // 64 MB
+)
+
+// ErrPassphrase is returned when an archive can't be decrypted, because the
+// passphrase is wrong or the file was modified.
+var ErrPassphrase = errors.New("wrong passphrase or corrupted archive")
+
+// Bundle is the state carried between machines.
+type Bundle struct {
+	// Home holds config files keyed by their archive name: projects.yml
+	// with its static routes, config.yml and an imported team CA under
+	// caddyfile/ca/.
+	Home map[string][]byte
+
+	// LocalCA holds the gateway's generated CA files, keyed by name. Empty
+	// if the gateway wasn't running at export.
+	LocalCA map[string][]byte
+}
+
+// HasKeys reports whether the bundle carries CA private keys.
+func (b *Bundle) HasKeys() bool {
+	for name := range b.LocalCA {
+		if strings.HasSuffix(name, ".key") {
+			return true
+		}
+	}
+	for name := range b.Home {
+		if strings.HasSuffix(name, ".key") {
+			return true
+		}
+	}
+	return false
+}
+
+// localPath returns where the file with an archive name lives on this
+// machine, or false for names that aren't part of the exported state.
+func localPath(name string) (string, bool) {
+	switch name {
+	case "projects.yml":
+		return config.ProjectsPath(), true
+	case "config.yml":
+		return config.SettingsPath(), true
+	}
+	base, ok := strings.CutPrefix(name, teamCAPrefix)
+	if !ok || base == "" || base != path.Base(base) || base == ".." {
+		return "", false
+	}
+	return filepath.Join(config.TeamCADir(), base), true
+}
+
+// homeFiles returns the archive names of the files that make up the
+// exported state.
+func homeFiles() ([]string, error) {
+	files := []string{"projects.yml", "config.yml"}
+	entries, err := os.ReadDir(config.TeamCADir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading team CA: %w", err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			files = append(files, teamCAPrefix+e.Name())
+		}
+	}
+	return files, nil
+}
+
+// Collect gathers the state to export. Without a running gateway the local
+// CA is left out, which is reported as a warning.
+func Collect(ctx context.Context) (*Bundle, []string, error) {
+	names, err := homeFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	b := &Bundle{Home: make(map[string][]byte)}
+	for _, name := range names {
+		path, _ := localPath(name)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		b.Home[name] = data
+	}
+	if _, ok := b.Home["projects.yml"]; !ok {
+		return nil, nil, fmt.Errorf("nothing to export: %s does not exist", config.ProjectsPath())
+	}
+
+	var warnings []string
+	if running, err := gateway.IsRunning(ctx); err != nil || !running {
+		warnings = append(warnings, "the gateway is not running, so its local CA is not included; the new machine will generate its own and need 'caddy-atc trust'")
+	} else if b.LocalCA, err = gateway.LocalCA(ctx); err != nil {
+		return nil, nil, err
+	}
+	return b, warnings, nil
+}
+
+// Seal encodes b as an archive encrypted with passphrase.
+func Seal(b *Bundle, passphrase string) ([]byte, error) {
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	tw := tar.NewWriter(gz)
+	for _, files := range []struct {
+		prefix string
+		files  map[string][]byte
+	}{{homePrefix, b.Home}, {localCAPrefix, b.LocalCA}} {
+		for _, name := range sortedKeys(files.files) {
+			data := files.files[name]
+			if err := tw.WriteHeader(&tar.Header{Name: files.prefix + name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data))}); err != nil {
+				return nil, err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(magic), salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain.Bytes(), []byte(magic)), nil
+}
+
+// Open decrypts and decodes an archive written by Seal.
+func Open(data []byte, passphrase string) (*Bundle, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(magic))
+	if !ok {
+		return nil, fmt.Errorf("not a caddy-atc migration archive")
+	}
+	if len(rest) < saltSize {
+		return nil, ErrPassphrase
+	}
+	salt, rest := rest[:saltSize], rest[saltSize:]
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(magic))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	b := &Bundle{Home: make(map[string][]byte), LocalCA: make(map[string][]byte)}
+	tr := tar.NewReader(io.LimitReader(gz, maxBundleSize))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		switch name := hdr.Name; {
+		case strings.HasPrefix(name, homePrefix) && isLocalName(strings.TrimPrefix(name, homePrefix)):
+			b.Home[strings.TrimPrefix(name, homePrefix)] = content
+		case strings.HasPrefix(name, localCAPrefix) && path.Base(name) == strings.TrimPrefix(name, localCAPrefix):
+			b.LocalCA[path.Base(name)] = content
+		default:
+			return nil, fmt.Errorf("unexpected file %q in archive", name)
+		}
+	}
+	return b, nil
+}
+
+func isLocalName(name string) bool {
+	_, ok := localPath(name)
+	return ok
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Export collects this machine's state and writes it to path, encrypted
+// with passphrase. It returns warnings about state left out.
+func Export(ctx context.Context, path, passphrase string) (*Bundle, []string, error) {
+	b, warnings, err := Collect(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := Seal(b, passphrase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypting archive: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, nil, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return b, warnings, nil
+}
+
+// Read decrypts the archive at path.
+func Read(path, passphrase string) (*Bundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	if info.Size() > maxBundleSize {
+		return nil, fmt.Errorf("archive too large (%d bytes, max %d)", info.Size(), maxBundleSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	return Open(data, passphrase)
+}
+
+// Restore writes b's config files into place, refusing to
+// replace adopted projects unless force is set, and then restores the
+// gateway's local CA, starting the gateway if needed.
+func Restore(ctx context.Context, b *Bundle, force bool) error {
+	if !force {
+		if cfg, err := config.Load(); err == nil && len(cfg.Projects) > 0 {
+			return fmt.Errorf("%s already has %d adopted project(s); pass --force to replace them", config.ProjectsPath(), len(cfg.Projects))
+		}
+	}
+	// The team CA goes along with the pki settings in projects.yml.
+	if err := gateway.RemoveCA(); err != nil {
+		return err
+	}
+	files := make(map[string][]byte, len(b.Home))
+	for name, data := range b.Home {
+		if path, ok := localPath(name); ok {
+			files[path] = data
+		}
+	}
+	if err := config.RestoreFiles(files); err != nil {
+		return err
+	}
+	if len(b.LocalCA) == 0 {
+		return nil
+	}
+
+	if running, err := gateway.IsRunning(ctx); err != nil {
+		return err
+	} else if !running {
+		if err := gateway.Up(ctx); err != nil {
+			return err
+		}
+	}
+	return gateway.RestoreLocalCA(ctx, b.LocalCA)
+}
+
+// MissingDirs returns the adopted projects in b whose directories don't
+// exist on this machine, keyed by project name.
+func MissingDirs(b *Bundle) map[string]string {
+	missing := make(map[string]string)
+	data, ok := b.Home["projects.yml"]
+	if !ok {
+		return missing
+	}
+	var cfg config.Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return missing
+	}
+	for name, proj := range cfg.Projects {
+		if _, err := os.Stat(proj.Dir); err != nil {
+			missing[name] = proj.Dir
+		}
+	}
+	return missing
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
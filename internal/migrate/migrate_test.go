@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	b := &Bundle{
+		Home: map[string][]byte{
+			"projects.yml":          []byte("projects: {}\n"),
+			"config.yml":            []byte("https_port: 8443\n"),
+			"caddyfile/ca/root.crt": []byte("team root"),
+		},
+		LocalCA: map[string][]byte{"root.crt": []byte("root"), "root.key": []byte("key")},
+	}
+	data, err := Seal(b, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("projects:")) || bytes.Contains(data, []byte("key")) {
+		t.Error("archive contains plaintext")
+	}
+
+	got, err := Open(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range b.Home {
+		if !bytes.Equal(got.Home[name], want) {
+			t.Errorf("Home[%q] = %q, want %q", name, got.Home[name], want)
+		}
+	}
+	for name, want := range b.LocalCA {
+		if !bytes.Equal(got.LocalCA[name], want) {
+			t.Errorf("LocalCA[%q] = %q, want %q", name, got.LocalCA[name], want)
+		}
+	}
+
+	if _, err := Open(data, "wrong"); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("wrong passphrase: got %v, want ErrPassphrase", err)
+	}
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Open(tampered, "correct horse"); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("tampered archive: got %v, want ErrPassphrase", err)
+	}
+	if _, err := Open([]byte("projects: {}\n"), "correct horse"); err == nil {
+		t.Error("expected error for a file that isn't an archive")
+	}
+}
+
+func TestOpenRejectsEscapingPaths(t *testing.T) {
+	for _, b := range []*Bundle{
+		{Home: map[string][]byte{"../.bashrc": []byte("x")}},
+		{LocalCA: map[string][]byte{"../root.key": []byte("x")}},
+	} {
+		data, err := Seal(b, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(data, "pass"); err == nil {
+			t.Errorf("expected error for %v", b)
+		}
+	}
+}
+
+func TestMissingDirs(t *testing.T) {
+	dir := t.TempDir()
+	gone := filepath.Join(dir, "gone")
+	b := &Bundle{Home: map[string][]byte{"projects.yml": []byte(
+		"projects:\n  here:\n    dir: " + dir + "\n  there:\n    dir: " + gone + "\n")}}
+
+	missing := MissingDirs(b)
+	if len(missing) != 1 || missing["there"] != gone {
+		t.Errorf("MissingDirs = %v, want only there -> %s", missing, gone)
+	}
+}
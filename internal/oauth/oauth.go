@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// DefaultCallbackPath is the redirect path suggested when none is given.
+const DefaultCallbackPath = "/auth/callback"
+
+// RedirectURI is a stable OAuth redirect URI for one service.
+type RedirectURI struct {
+	Service string
+	URI     string
+}
+
+// RedirectURIs returns the redirect URIs to register with an OAuth provider
+// for each routed service of the project in dir, sorted by service name.
+// Wildcard hostnames are left out since providers need an exact URI.
+func RedirectURIs(dir, path string) ([]RedirectURI, error) {
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	name, proj, err := findProject(cfg, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, httpsPort := cfg.HTTPPorts()
+	var uris []RedirectURI
+	for svc, host := range proj.Services {
+		if strings.HasPrefix(host, "*.") {
+			continue
+		}
+		uris = append(uris, RedirectURI{Service: svc, URI: config.SiteURL(host, httpsPort) + path})
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("project %q has no routed services with a concrete hostname", name)
+	}
+	sort.Slice(uris, func(i, j int) bool { return uris[i].Service < uris[j].Service })
+	return uris, nil
+}
+
+// EnableInspector makes the gateway answer path on service's hostname with
+// the callback inspector. An empty service selects the project's primary
+// service (the one on the base hostname). Returns the inspected URL.
+func EnableInspector(dir, service, path string) (string, error) {
+	if err := validatePath(path); err != nil {
+		return "", err
+	}
+	var url string
+	err := config.LoadAndModify(func(cfg *config.Config) error {
+		name, proj, err := findProject(cfg, dir)
+		if err != nil {
+			return err
+		}
+		if service == "" {
+			if service = primaryService(proj); service == "" {
+				return fmt.Errorf("project %q has no service on %s; choose one with --service", name, proj.Hostname)
+			}
+		}
+		host, ok := proj.Services[service]
+		if !ok {
+			return fmt.Errorf("service %q is not routed in project %q", service, name)
+		}
+		if strings.HasPrefix(host, "*.") {
+			return fmt.Errorf("service %q uses wildcard hostname %s; choose another with --service", service, host)
+		}
+
+		if proj.Options == nil {
+			proj.Options = make(map[string]*config.ServiceOptions)
+		}
+		opts := proj.Options[service]
+		if opts == nil {
+			opts = &config.ServiceOptions{}
+			proj.Options[service] = opts
+		}
+		opts.CallbackInspector = path
+		_, httpsPort := cfg.HTTPPorts()
+		url = config.SiteURL(host, httpsPort) + path
+		return nil
+	})
+	return url, err
+}
+
+// DisableInspector removes the callback inspector from every service of
+// the project in dir. Returns the number of services it was removed from.
+func DisableInspector(dir string) (int, error) {
+	removed := 0
+	err := config.LoadAndModify(func(cfg *config.Config) error {
+		_, proj, err := findProject(cfg, dir)
+		if err != nil {
+			return err
+		}
+		for svc, opts := range proj.Options {
+			if opts == nil || opts.CallbackInspector == "" {
+				continue
+			}
+			opts.CallbackInspector = ""
+			removed++
+			if *opts == (config.ServiceOptions{}) {
+				delete(proj.Options, svc)
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func findProject(cfg *config.Config, dir string) (string, *config.ProjectConfig, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("resolving path: %w", err)
+	}
+	name := filepath.Base(absDir)
+	proj, ok := cfg.Projects[name]
+	if !ok {
+		return "", nil, fmt.Errorf("project %q is not adopted", name)
+	}
+	return name, proj, nil
+}
+
+func primaryService(proj *config.ProjectConfig) string {
+	for svc, host := range proj.Services {
+		if host == proj.Hostname {
+			return svc
+		}
+	}
+	return ""
+}
+
+func validatePath(path string) error {
+	return config.ServiceOptions{CallbackInspector: path}.Validate()
+}
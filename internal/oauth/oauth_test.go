@@ -0,0 +1,102 @@
+package oauth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func setupProject(t *testing.T) string {
+	t.Helper()
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	dir := filepath.Join(tmpDir, "myapp")
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"myapp": {
+			Dir:      dir,
+			Hostname: "myapp.localhost",
+			Services: map[string]string{
+				"web": "myapp.localhost",
+				"api": "api.myapp.localhost",
+			},
+		},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRedirectURIs(t *testing.T) {
+	dir := setupProject(t)
+
+	uris, err := RedirectURIs(dir, DefaultCallbackPath)
+	if err != nil {
+		t.Fatalf("RedirectURIs() error = %v", err)
+	}
+	want := []RedirectURI{
+		{"api", "https://api.myapp.localhost/auth/callback"},
+		{"web", "https://myapp.localhost/auth/callback"},
+	}
+	if len(uris) != len(want) {
+		t.Fatalf("got %d URIs, want %d: %+v", len(uris), len(want), uris)
+	}
+	for i := range want {
+		if uris[i] != want[i] {
+			t.Errorf("uris[%d] = %+v, want %+v", i, uris[i], want[i])
+		}
+	}
+
+	if _, err := RedirectURIs(dir, "/cb?x=1"); err == nil {
+		t.Error("expected error for path with query")
+	}
+}
+
+func TestEnableDisableInspector(t *testing.T) {
+	dir := setupProject(t)
+
+	url, err := EnableInspector(dir, "", "/oauth2/callback")
+	if err != nil {
+		t.Fatalf("EnableInspector() error = %v", err)
+	}
+	if url != "https://myapp.localhost/oauth2/callback" {
+		t.Errorf("url = %q", url)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Projects["myapp"].ServiceOptions("web").CallbackInspector; got != "/oauth2/callback" {
+		t.Errorf("web CallbackInspector = %q", got)
+	}
+
+	n, err := DisableInspector(dir)
+	if err != nil {
+		t.Fatalf("DisableInspector() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("DisableInspector() removed %d, want 1", n)
+	}
+	cfg, err = config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Projects["myapp"].Options["web"]; ok {
+		t.Error("expected empty options entry to be removed")
+	}
+}
+
+func TestEnableInspector_UnknownService(t *testing.T) {
+	dir := setupProject(t)
+	if _, err := EnableInspector(dir, "worker", DefaultCallbackPath); err == nil {
+		t.Error("expected error for unrouted service")
+	}
+}
@@ -0,0 +1,149 @@
+// Package repro generates minimal compose projects that exhibit a routing
+// scenario, so routing bugs can be reproduced and shared as fixtures.
+package repro
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Scenario is a sample project exhibiting one routing behavior.
+type Scenario struct {
+	Name        string
+	Description string
+	Expect      string            // what caddy-atc should do with the project
+	Files       map[string]string // relative path -> contents
+}
+
+// Scenarios are the projects `repro new` can generate, keyed by name.
+var Scenarios = map[string]*Scenario{
+	"multi-service": {
+		Name:        "multi-service",
+		Description: "two HTTP services and a database",
+		Expect: "Adopt routes web at the project hostname and api at api.<project hostname>;\n" +
+			"db is detected as non-HTTP and not routed.",
+		Files: map[string]string{"docker-compose.yml": `services:
+  web:
+    image: traefik/whoami
+    ports:
+      - "8080:80"
+  api:
+    image: traefik/whoami
+    command: ["--port", "3000"]
+    ports:
+      - "3000:3000"
+  db:
+    image: postgres:16-alpine
+    environment:
+      POSTGRES_PASSWORD: postgres
+    ports:
+      - "5432:5432"
+`},
+	},
+	"dockerfile-expose-only": {
+		Name:        "dockerfile-expose-only",
+		Description: "a built service whose port is only declared by EXPOSE in its Dockerfile",
+		Expect:      "Adopt detects port 8000 from the Dockerfile's EXPOSE and routes web to it.",
+		Files: map[string]string{
+			"docker-compose.yml": `services:
+  web:
+    build: ./web
+`,
+			"web/Dockerfile": `FROM traefik/whoami
+EXPOSE 8000
+CMD ["--port", "8000"]
+`,
+		},
+	},
+	"long-syntax-ports": {
+		Name:        "long-syntax-ports",
+		Description: "ports in the long (mapping) syntax, including a UDP port",
+		Expect: "Adopt detects port 80 from the long-syntax entry, and start strips every\n" +
+			"published port, leaving the service reachable only through the gateway.",
+		Files: map[string]string{"docker-compose.yml": `services:
+  web:
+    image: traefik/whoami
+    ports:
+      - target: 80
+        published: "8080"
+        protocol: tcp
+        mode: host
+      - target: 9999
+        published: "9999"
+        protocol: udp
+`},
+	},
+	"crash-loop": {
+		Name:        "crash-loop",
+		Description: "a service that exits a few seconds after starting and is restarted forever",
+		Expect: "Each restart re-adds the route; the gateway answers 502 while the\n" +
+			"container is down, and the watcher log shows the route coming and going.",
+		Files: map[string]string{"docker-compose.yml": `services:
+  web:
+    image: busybox
+    command: ["sh", "-c", "echo starting; httpd -f -p 8080 & sleep 5; echo crashing; exit 1"]
+    restart: always
+    labels:
+      caddy-atc.port: "8080"
+`},
+	},
+}
+
+// Names returns the scenario names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(Scenarios))
+	for name := range Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New writes the project for scenario into dir, with a README recording
+// the expected behavior and the caddy-atc version. dir must not exist or
+// be empty.
+func New(dir, scenario, version string) error {
+	s, ok := Scenarios[scenario]
+	if !ok {
+		return fmt.Errorf("unknown scenario %q (want one of %s)", scenario, strings.Join(Names(), ", "))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+
+	files := map[string]string{"README.md": readme(s, version)}
+	for path, content := range s.Files {
+		files[path] = content
+	}
+	for path, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readme(s *Scenario, version string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# caddy-atc repro: %s\n\n", s.Name)
+	fmt.Fprintf(&b, "A minimal project with %s, generated by caddy-atc %s.\n\n", s.Description, version)
+	b.WriteString("## Steps\n\n```bash\n")
+	b.WriteString("caddy-atc adopt .\ncaddy-atc start .\ncaddy-atc routes\n")
+	b.WriteString("curl -v https://<hostname from 'caddy-atc routes'>\n")
+	b.WriteString("caddy-atc logs watcher\ncaddy-atc stop .\n```\n\n")
+	fmt.Fprintf(&b, "## Expected\n\n%s\n\n", s.Expect)
+	b.WriteString("## Actual\n\n<!-- What happened instead, with the output of the steps above. -->\n")
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package repro
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/adopt"
+)
+
+func TestNew_ScenariosDetect(t *testing.T) {
+	tests := []struct {
+		scenario string
+		want     map[string]string // HTTP service -> detected port
+	}{
+		{"multi-service", map[string]string{"web": "80", "api": "3000"}},
+		{"dockerfile-expose-only", map[string]string{"web": "8000"}},
+		// adopt doesn't parse long-syntax ports yet; the scenario reproduces that.
+		{"long-syntax-ports", nil},
+		{"crash-loop", map[string]string{"web": "8080"}},
+	}
+	if len(tests) != len(Scenarios) {
+		t.Fatalf("%d scenarios tested, want all %d", len(tests), len(Scenarios))
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "repro")
+			if err := New(dir, tt.scenario, "v1.2.3"); err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			readme, err := os.ReadFile(filepath.Join(dir, "README.md"))
+			if err != nil || !strings.Contains(string(readme), "v1.2.3") {
+				t.Errorf("README.md = %q, %v, want the version recorded", readme, err)
+			}
+			if tt.want == nil {
+				return
+			}
+			services, err := adopt.ScanComposeFile(dir, "", nil)
+			if err != nil {
+				t.Fatalf("ScanComposeFile() error = %v", err)
+			}
+			got := make(map[string]string)
+			for _, svc := range services {
+				if svc.IsHTTP {
+					got[svc.Name] = svc.Port
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("HTTP services = %v, want %v", got, tt.want)
+			}
+			for name, port := range tt.want {
+				if got[name] != port {
+					t.Errorf("service %s port = %q, want %q", name, got[name], port)
+				}
+			}
+		})
+	}
+}
+
+func TestNew_Refuses(t *testing.T) {
+	dir := t.TempDir()
+	if err := New(dir, "nope", "dev"); err == nil || !strings.Contains(err.Error(), "multi-service") {
+		t.Errorf("New() with an unknown scenario = %v, want an error listing scenarios", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(dir, "crash-loop", "dev"); err == nil {
+		t.Error("New() wrote into a non-empty directory")
+	}
+}
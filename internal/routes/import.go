@@ -0,0 +1,261 @@
+package routes
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// SkippedSite records a Caddyfile site block that could not be imported.
+type SkippedSite struct {
+	Address string
+	Reason  string
+}
+
+// ImportResult holds the outcome of parsing a hand-maintained Caddyfile.
+type ImportResult struct {
+	Routes  []*config.StaticRoute
+	Skipped []SkippedSite
+}
+
+// site is a top-level Caddyfile site block as seen by the importer.
+type site struct {
+	addresses []string
+	upstreams []string
+	reason    string // non-empty if the block can't be imported
+}
+
+// ParseCaddyfile extracts simple `host { reverse_proxy upstream... }` site
+// blocks from a Caddyfile. Anything more involved (path matchers, nested
+// handlers, snippets, HTTPS upstreams) is reported as skipped rather than
+// approximated, so imported routes always behave like the originals.
+func ParseCaddyfile(data []byte) *ImportResult {
+	var sites []*site
+	var cur *site
+	depth := 0
+
+	for _, raw := range strings.Split(string(data), "\n") {
+		for _, line := range splitBraces(stripComment(raw)) {
+			fields := strings.Fields(line)
+			opens := strings.HasSuffix(line, "{")
+			closes := strings.HasPrefix(line, "}")
+
+			if depth == 0 {
+				if !opens {
+					continue // import, snippet reference, or stray token
+				}
+				addrs := strings.TrimSpace(strings.TrimSuffix(line, "{"))
+				if addrs == "" || strings.HasPrefix(addrs, "(") {
+					cur = nil // global options or snippet definition
+				} else {
+					cur = &site{addresses: splitAddresses(addrs)}
+					sites = append(sites, cur)
+				}
+				depth = 1
+				continue
+			}
+
+			if cur != nil && fields[0] == "reverse_proxy" {
+				args := fields[1:]
+				if opens {
+					args = args[:len(args)-1]
+				}
+				switch {
+				case depth > 1:
+					cur.reason = "reverse_proxy nested in a handler block"
+				case len(args) > 0 && isMatcher(args[0]):
+					cur.reason = "path or named matchers are not supported"
+				case len(cur.upstreams) > 0:
+					cur.reason = "multiple reverse_proxy directives"
+				default:
+					cur.upstreams = args
+				}
+			}
+
+			if closes {
+				depth--
+			}
+			if opens {
+				depth++
+			}
+			if depth <= 0 {
+				depth = 0
+				cur = nil
+			}
+		}
+	}
+
+	result := &ImportResult{}
+	for _, s := range sites {
+		addrLabel := strings.Join(s.addresses, ", ")
+		if s.reason == "" && len(s.upstreams) == 0 {
+			s.reason = "no reverse_proxy directive"
+		}
+		if s.reason != "" {
+			result.Skipped = append(result.Skipped, SkippedSite{addrLabel, s.reason})
+			continue
+		}
+
+		var upstreams []string
+		for _, u := range s.upstreams {
+			up, err := normalizeUpstream(u)
+			if err != nil {
+				s.reason = err.Error()
+				break
+			}
+			upstreams = append(upstreams, up)
+		}
+		if s.reason != "" {
+			result.Skipped = append(result.Skipped, SkippedSite{addrLabel, s.reason})
+			continue
+		}
+
+		for _, addr := range s.addresses {
+			host, err := normalizeSiteAddress(addr)
+			if err != nil {
+				result.Skipped = append(result.Skipped, SkippedSite{addr, err.Error()})
+				continue
+			}
+			for _, up := range upstreams {
+				result.Routes = append(result.Routes, &config.StaticRoute{Hostname: host, Upstream: up})
+			}
+		}
+	}
+	return result
+}
+
+// splitBraces splits a line into the pieces the parser reads as lines: a
+// site or block opener ending in "{", the directives between, and each
+// "}". A one-line block such as `app.test { reverse_proxy :3000 }` thus
+// reads like its multi-line form. Placeholders like {host} are left alone,
+// since braces only count as separate tokens.
+func splitBraces(line string) []string {
+	var pieces, cur []string
+	flush := func() {
+		if len(cur) > 0 {
+			pieces = append(pieces, strings.Join(cur, " "))
+			cur = nil
+		}
+	}
+	for _, f := range strings.Fields(line) {
+		switch f {
+		case "{":
+			cur = append(cur, f)
+			flush()
+		case "}":
+			flush()
+			pieces = append(pieces, f)
+		default:
+			cur = append(cur, f)
+		}
+	}
+	flush()
+	return pieces
+}
+
+// ImportCaddyfile parses the Caddyfile at path and registers its site blocks
+// as static routes. Hostnames already routed by an adopted project are skipped.
+// With dryRun, nothing is saved.
+func ImportCaddyfile(path string, dryRun bool) (*ImportResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	const maxCaddyfileSize = 1 << 20 // 1 MB
+	if info.Size() > maxCaddyfileSize {
+		return nil, fmt.Errorf("Caddyfile too large (%d bytes, max %d)", info.Size(), maxCaddyfileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	parsed := ParseCaddyfile(data)
+	result := &ImportResult{Skipped: parsed.Skipped}
+
+	apply := func(cfg *config.Config) error {
+		for _, r := range parsed.Routes {
+			if owner := cfg.HostnameOwner(r.Hostname); owner != "" {
+				result.Skipped = append(result.Skipped, SkippedSite{r.Hostname, fmt.Sprintf("already routed by project %q", owner)})
+				continue
+			}
+			if cfg.AddStaticRoute(r) {
+				result.Routes = append(result.Routes, r)
+			}
+		}
+		return nil
+	}
+
+	if dryRun {
+		cfg, err := config.Load()
+		if err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+		return result, apply(cfg)
+	}
+	if err := config.LoadAndModify(apply); err != nil {
+		return nil, fmt.Errorf("saving config: %w", err)
+	}
+	return result, nil
+}
+
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i == 0 || (i > 0 && (line[i-1] == ' ' || line[i-1] == '\t')) {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+func splitAddresses(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+func isMatcher(tok string) bool {
+	return strings.HasPrefix(tok, "/") || strings.HasPrefix(tok, "@") || tok == "*"
+}
+
+// normalizeSiteAddress reduces a site address such as "https://app.test:443"
+// to a bare hostname the gateway can serve.
+func normalizeSiteAddress(addr string) (string, error) {
+	host := strings.TrimPrefix(strings.TrimPrefix(addr, "https://"), "http://")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return "", fmt.Errorf("site address %q has no hostname", addr)
+	}
+	if err := config.ValidateHostname(host); err != nil {
+		return "", err
+	}
+	return host, nil
+}
+
+// normalizeUpstream converts a reverse_proxy upstream into host:port form.
+// Loopback upstreams are rewritten to reach the Docker host, since the
+// gateway runs inside a container.
+func normalizeUpstream(u string) (string, error) {
+	if strings.HasPrefix(u, "https://") {
+		return "", fmt.Errorf("HTTPS upstream %q is not supported", u)
+	}
+	u = strings.TrimPrefix(u, "http://")
+
+	host, port, err := net.SplitHostPort(u)
+	if err != nil {
+		// No port: Caddy defaults to 80 for plain upstreams
+		host, port = u, "80"
+	}
+	switch host {
+	case "localhost", "127.0.0.1", "::1", "":
+		host = gateway.HostUpstream
+	}
+
+	up := net.JoinHostPort(host, port)
+	if err := config.ValidateUpstream(up); err != nil {
+		return "", err
+	}
+	return up, nil
+}
@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+const sampleCaddyfile = `# My local proxies
+{
+    local_certs
+}
+
+(common) {
+    encode gzip
+}
+
+app.test, www.app.test {
+    reverse_proxy localhost:3000
+}
+
+https://api.test:443 {
+    import common
+    reverse_proxy http://backend:8080 backend2:8080 {
+        lb_policy round_robin
+    }
+}
+
+docs.test {
+    handle /v1/* {
+        reverse_proxy localhost:4000
+    }
+}
+
+static.test {
+    root * /srv
+    file_server
+}
+
+matcher.test {
+    reverse_proxy /api/* localhost:5000
+}
+
+secure.test {
+    reverse_proxy https://keycloak:8443
+}
+`
+
+func TestParseCaddyfile(t *testing.T) {
+	result := ParseCaddyfile([]byte(sampleCaddyfile))
+
+	want := []config.StaticRoute{
+		{Hostname: "app.test", Upstream: "host.docker.internal:3000"},
+		{Hostname: "www.app.test", Upstream: "host.docker.internal:3000"},
+		{Hostname: "api.test", Upstream: "backend:8080"},
+		{Hostname: "api.test", Upstream: "backend2:8080"},
+	}
+	if len(result.Routes) != len(want) {
+		t.Fatalf("got %d routes, want %d: %+v", len(result.Routes), len(want), result.Routes)
+	}
+	for i, w := range want {
+		if *result.Routes[i] != w {
+			t.Errorf("route[%d] = %+v, want %+v", i, *result.Routes[i], w)
+		}
+	}
+
+	skipped := make(map[string]string)
+	for _, s := range result.Skipped {
+		skipped[s.Address] = s.Reason
+	}
+	for _, addr := range []string{"docs.test", "static.test", "matcher.test", "secure.test"} {
+		if _, ok := skipped[addr]; !ok {
+			t.Errorf("expected %s to be skipped, got skipped=%v", addr, skipped)
+		}
+	}
+}
+
+func TestParseCaddyfile_OneLineBlocks(t *testing.T) {
+	result := ParseCaddyfile([]byte(`app.test { reverse_proxy localhost:3000 }
+files.test { file_server }
+api.test {
+    handle /v1/* { reverse_proxy backend:8080 }
+}
+`))
+
+	want := config.StaticRoute{Hostname: "app.test", Upstream: "host.docker.internal:3000"}
+	if len(result.Routes) != 1 || *result.Routes[0] != want {
+		t.Fatalf("routes = %+v, want [%+v]", result.Routes, want)
+	}
+	skipped := make(map[string]string)
+	for _, s := range result.Skipped {
+		skipped[s.Address] = s.Reason
+	}
+	if skipped["files.test"] != "no reverse_proxy directive" {
+		t.Errorf("files.test reason = %q", skipped["files.test"])
+	}
+	if skipped["api.test"] != "reverse_proxy nested in a handler block" {
+		t.Errorf("api.test reason = %q", skipped["api.test"])
+	}
+}
+
+func TestNormalizeUpstream(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"localhost:3000", "host.docker.internal:3000", false},
+		{"127.0.0.1:8080", "host.docker.internal:8080", false},
+		{"[::1]:9000", "host.docker.internal:9000", false},
+		{"http://web:80", "web:80", false},
+		{"web", "web:80", false},
+		{"https://web:443", "", true},
+		{"bad}host:80", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := normalizeUpstream(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeUpstream(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeUpstream(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportCaddyfile_SkipsAdoptedHostnames(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"myapp": {Hostname: "app.test", Services: map[string]string{"web": "app.test"}},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(tmpDir, "Caddyfile")
+	data := "app.test {\n    reverse_proxy localhost:3000\n}\nother.test {\n    reverse_proxy localhost:4000\n}\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportCaddyfile(path, false)
+	if err != nil {
+		t.Fatalf("ImportCaddyfile() error = %v", err)
+	}
+	if len(result.Routes) != 1 || result.Routes[0].Hostname != "other.test" {
+		t.Errorf("Routes = %+v, want only other.test", result.Routes)
+	}
+
+	loaded, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.StaticRoutes) != 1 {
+		t.Fatalf("saved %d static routes, want 1", len(loaded.StaticRoutes))
+	}
+
+	// Importing again is idempotent
+	result, err = ImportCaddyfile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Routes) != 0 {
+		t.Errorf("re-import added %d routes, want 0", len(result.Routes))
+	}
+}
@@ -3,10 +3,11 @@ package routes
 import (
 	"context"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"github.com/g-brodiei/caddy-atc/internal/config"
 	"github.com/g-brodiei/caddy-atc/internal/gateway"
 	"github.com/g-brodiei/caddy-atc/internal/watcher"
@@ -20,11 +21,59 @@ type ActiveRoute struct {
 	Project       string
 	Service       string
 	Status        string
+	Health        string    // watcher.Health*, or "" if the upstream isn't probed
+	Quarantine    string    // why the watcher excluded the route, if it did
+	Source        string    // watcher.Source*, how the port was chosen
+	Since         time.Time // when the watcher first routed it; zero if unknown
 }
 
-// ListActive queries running containers and returns active routes.
+// ListActive returns the active routes: those the running watcher serves,
+// or if it doesn't answer, those it would derive from the running
+// containers.
 func ListActive(ctx context.Context) ([]ActiveRoute, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if served, err := watcher.WatcherRoutes(ctx); err == nil {
+		return fromWatcher(served), nil
+	}
+	return listContainers(ctx)
+}
+
+// fromWatcher converts the routes served by the watcher for display.
+func fromWatcher(served []watcher.ServedRoute) []ActiveRoute {
+	routes := make([]ActiveRoute, 0, len(served))
+	for _, r := range served {
+		route := ActiveRoute{
+			Hostname:      r.Hostname,
+			ContainerName: r.Container,
+			Port:          r.Port,
+			Project:       r.Project,
+			Service:       r.Service,
+			Status:        "routed",
+			Quarantine:    r.Quarantine,
+			Source:        r.Source,
+		}
+		switch {
+		case route.Quarantine != "":
+			route.Status = "QUARANTINED"
+		case route.Project == "":
+			route.Status = "static"
+		case r.Replica:
+			route.Status = "routed (replica)"
+		}
+		if route.Project == "" {
+			route.Project, route.Service = "-", "-"
+		}
+		routes = append(routes, route)
+	}
+	markPinned(routes, watcher.CurrentPins())
+	markHealth(routes, watcher.LoadHealth())
+	markMeta(routes, watcher.LoadRouteMeta())
+	return routes
+}
+
+// listContainers queries running containers and returns the routes they
+// get.
+func listContainers(ctx context.Context) ([]ActiveRoute, error) {
+	cli, err := gateway.NewClient()
 	if err != nil {
 		return nil, fmt.Errorf("connecting to Docker: %w", err)
 	}
@@ -35,12 +84,13 @@ func ListActive(ctx context.Context) ([]ActiveRoute, error) {
 		return nil, fmt.Errorf("loading config: %w", err)
 	}
 
-	containers, err := cli.ContainerList(ctx, container.ListOptions{})
+	containers, err := gateway.ListContainers(ctx, cli, container.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("listing containers: %w", err)
 	}
 
 	var routes []ActiveRoute
+	projectFiles := make(map[string]*config.ProjectFile)
 
 	for _, c := range containers {
 		if len(c.Names) == 0 {
@@ -53,33 +103,45 @@ func ListActive(ctx context.Context) ([]ActiveRoute, error) {
 
 		composeProject := c.Labels["com.docker.compose.project"]
 		composeService := c.Labels["com.docker.compose.service"]
-		if composeProject == "" {
+		if composeProject == "" || config.IsIgnored(c.Labels) {
 			continue
 		}
 
-		_, projCfg := cfg.FindProjectByComposeProject(composeProject)
+		projName, projCfg := cfg.FindRoutedProject(composeProject)
 		if projCfg == nil {
 			continue
 		}
+		pf, ok := projectFiles[projName]
+		if !ok {
+			pf, _ = config.LoadProjectFile(projCfg.Dir)
+			projectFiles[projName] = pf
+		}
+		labels := pf.Labels(composeService, c.Labels)
+		if config.IsIgnored(labels) {
+			continue
+		}
 
-		info, err := cli.ContainerInspect(ctx, c.ID)
+		info, err := gateway.InspectContainer(ctx, cli, c.ID)
 		if err != nil {
 			continue
 		}
+		if info.Config != nil {
+			info.Config.Labels = labels
+		}
 
 		port := watcher.DetectHTTPPort(info)
 		if port == "" {
 			continue
 		}
 
-		hostname := projCfg.ResolveHostname(composeService)
+		hostname, _ := cfg.ResolveContainerHostname(projName, labels)
 
 		// Check if connected to caddy-atc network
 		status := "routed"
 		if info.NetworkSettings != nil {
 			connected := false
 			for netName := range info.NetworkSettings.Networks {
-				if netName == gateway.NetworkName {
+				if netName == gateway.Network() {
 					connected = true
 					break
 				}
@@ -96,8 +158,139 @@ func ListActive(ctx context.Context) ([]ActiveRoute, error) {
 			Project:       composeProject,
 			Service:       composeService,
 			Status:        status,
+			Source:        watcher.PortSource(info),
+		})
+		if projCfg.EffectiveServiceOptions(composeService, pf).ReplicaHostnames {
+			if replica := projCfg.ReplicaHostname(composeService, labels[config.ReplicaNumberLabel]); replica != "" {
+				routes = append(routes, ActiveRoute{
+					Hostname:      replica,
+					ContainerName: name,
+					Port:          port,
+					Project:       composeProject,
+					Service:       composeService,
+					Status:        status + " (replica)",
+					Source:        watcher.PortSource(info),
+				})
+			}
+		}
+	}
+
+	for _, sr := range cfg.StaticRoutes {
+		host, port, err := net.SplitHostPort(sr.Upstream)
+		if sr.Root != "" {
+			host, port, err = "file_server", "-", nil
+		}
+		if err != nil {
+			continue
+		}
+		routes = append(routes, ActiveRoute{
+			Hostname:      sr.Hostname,
+			ContainerName: host,
+			Port:          port,
+			Project:       "-",
+			Service:       "-",
+			Status:        "static",
+			Source:        watcher.SourceManual,
 		})
 	}
 
+	markQuarantined(routes, watcher.LoadQuarantine())
+	markPinned(routes, watcher.CurrentPins())
+	markHealth(routes, watcher.LoadHealth())
+	markMeta(routes, watcher.LoadRouteMeta())
 	return routes, nil
 }
+
+// ListKnown returns the routes the watcher last served, for when Docker is
+// unreachable, and when it recorded them. The time is zero if it never
+// has.
+func ListKnown() ([]ActiveRoute, time.Time) {
+	snap := watcher.LoadSnapshot()
+	if snap == nil {
+		return nil, time.Time{}
+	}
+	routes := make([]ActiveRoute, 0, len(snap.Routes))
+	for _, r := range snap.Routes {
+		route := ActiveRoute{
+			Hostname:      r.Hostname,
+			ContainerName: r.ContainerName,
+			Port:          r.Port,
+			Project:       r.Project,
+			Service:       r.Service,
+			Status:        "last known",
+			Quarantine:    r.Quarantine,
+		}
+		if route.Project == "" {
+			route.Project, route.Service, route.Status = "-", "-", "static"
+		}
+		if route.Quarantine != "" {
+			route.Status = "QUARANTINED"
+		}
+		routes = append(routes, route)
+	}
+	markMeta(routes, watcher.LoadRouteMeta())
+	return routes, snap.Saved
+}
+
+// markMeta sets when each route appeared, as recorded by the watcher. The
+// source and last recorded health fill in for what isn't known otherwise.
+func markMeta(routes []ActiveRoute, metas watcher.RouteMetas) {
+	for i := range routes {
+		meta, ok := metas[watcher.RouteMetaKey(routes[i].Hostname, routes[i].ContainerName, routes[i].Port)]
+		if !ok {
+			continue
+		}
+		routes[i].Since = meta.Since
+		if routes[i].Source == "" {
+			routes[i].Source = meta.Source
+		}
+		if routes[i].Health == "" && routes[i].Quarantine == "" {
+			routes[i].Health = meta.Health
+		}
+	}
+}
+
+// markHealth sets the health the watcher last recorded for each route's
+// upstream.
+func markHealth(routes []ActiveRoute, states watcher.HealthStates) {
+	for i := range routes {
+		if routes[i].Quarantine != "" {
+			continue
+		}
+		if h, ok := states[routes[i].ContainerName+":"+routes[i].Port]; ok {
+			routes[i].Health = h.Status
+		}
+	}
+}
+
+// markPinned flags routes whose hostname is pinned to one replica: the
+// pinned container serves it, its other replicas are bypassed.
+func markPinned(routes []ActiveRoute, pins watcher.Pins) {
+	for i := range routes {
+		pinned, ok := pins[routes[i].Hostname]
+		if !ok || routes[i].Quarantine != "" {
+			continue
+		}
+		if routes[i].ContainerName == pinned {
+			routes[i].Status = "pinned"
+		} else {
+			routes[i].Status = "bypassed (pinned to " + pinned + ")"
+		}
+	}
+}
+
+// markQuarantined flags the routes the watcher has excluded from the
+// Caddyfile, matching on hostname (or replica hostname) and upstream
+// container.
+func markQuarantined(routes []ActiveRoute, quarantined []watcher.QuarantinedRoute) {
+	for i := range routes {
+		for _, q := range quarantined {
+			hostMatch := q.Hostname == routes[i].Hostname || q.ReplicaHostname != "" && q.ReplicaHostname == routes[i].Hostname
+			if hostMatch && q.ContainerName == routes[i].ContainerName {
+				routes[i].Status = "QUARANTINED"
+				routes[i].Quarantine = q.Reason
+				break
+			}
+		}
+	}
+}
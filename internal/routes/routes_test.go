@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+	"github.com/g-brodiei/caddy-atc/internal/watcher"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func TestMarkQuarantined(t *testing.T) {
+	active := []ActiveRoute{
+		{Hostname: "app.localhost", ContainerName: "app-web-1", Status: "routed"},
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Status: "routed"},
+		{Hostname: "api.localhost", ContainerName: "app-api-2", Status: "routed"},
+	}
+	markQuarantined(active, []watcher.QuarantinedRoute{
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Reason: "bad option"},
+	})
+
+	if active[1].Status != "QUARANTINED" || active[1].Quarantine != "bad option" {
+		t.Errorf("quarantined route = %+v", active[1])
+	}
+	for _, i := range []int{0, 2} {
+		if active[i].Status != "routed" || active[i].Quarantine != "" {
+			t.Errorf("route %d = %+v, want untouched", i, active[i])
+		}
+	}
+}
+
+func TestFromWatcher(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	active := fromWatcher([]watcher.ServedRoute{
+		{Hostname: "app.localhost", Container: "app-web-1", Port: "3000", Project: "app", Service: "web", Source: watcher.SourceLabel},
+		{Hostname: "web-1.app.localhost", Container: "app-web-1", Port: "3000", Project: "app", Service: "web", Replica: true},
+		{Hostname: "api.localhost", Container: "app-api-1", Port: "8000", Project: "app", Service: "api", Quarantine: "bad option"},
+		{Hostname: "host.localhost", Container: "host.docker.internal", Port: "9000", Source: watcher.SourceManual},
+	})
+
+	want := []struct{ status, project, source string }{
+		{"routed", "app", watcher.SourceLabel},
+		{"routed (replica)", "app", ""},
+		{"QUARANTINED", "app", ""},
+		{"static", "-", watcher.SourceManual},
+	}
+	if len(active) != len(want) {
+		t.Fatalf("fromWatcher() returned %d routes, want %d", len(active), len(want))
+	}
+	for i, w := range want {
+		if active[i].Status != w.status || active[i].Project != w.project || active[i].Source != w.source {
+			t.Errorf("route %d = %+v, want status %q, project %q, source %q", i, active[i], w.status, w.project, w.source)
+		}
+	}
+	if active[2].Quarantine != "bad option" {
+		t.Errorf("quarantine reason = %q, want %q", active[2].Quarantine, "bad option")
+	}
+}
+
+func TestMarkPinned(t *testing.T) {
+	active := []ActiveRoute{
+		{Hostname: "app.localhost", ContainerName: "app-web-1", Status: "routed"},
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Status: "routed"},
+		{Hostname: "api.localhost", ContainerName: "app-api-2", Status: "routed"},
+	}
+	markPinned(active, watcher.Pins{"api.localhost": "app-api-2"})
+
+	want := []string{"routed", "bypassed (pinned to app-api-2)", "pinned"}
+	for i, w := range want {
+		if active[i].Status != w {
+			t.Errorf("route %d status = %q, want %q", i, active[i].Status, w)
+		}
+	}
+}
+
+func TestMarkHealth(t *testing.T) {
+	active := []ActiveRoute{
+		{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80"},
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "8080"},
+		{Hostname: "api.localhost", ContainerName: "app-api-2", Port: "8080", Quarantine: "bad option"},
+	}
+	markHealth(active, watcher.HealthStates{
+		"app-web-1:80":   {Status: watcher.HealthUnhealthy},
+		"app-api-2:8080": {Status: watcher.HealthHealthy},
+	})
+
+	want := []string{watcher.HealthUnhealthy, "", ""}
+	for i, w := range want {
+		if active[i].Health != w {
+			t.Errorf("route %d health = %q, want %q", i, active[i].Health, w)
+		}
+	}
+}
+
+func TestMarkMeta(t *testing.T) {
+	since := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	active := []ActiveRoute{
+		{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Source: watcher.SourceLabel, Health: watcher.HealthHealthy},
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "8080"},
+		{Hostname: "new.localhost", ContainerName: "app-new-1", Port: "80"},
+	}
+	markMeta(active, watcher.RouteMetas{
+		watcher.RouteMetaKey("app.localhost", "app-web-1", "80"):   {Since: since, Source: watcher.SourceHeuristic, Health: watcher.HealthUnhealthy},
+		watcher.RouteMetaKey("api.localhost", "app-api-1", "8080"): {Since: since, Source: watcher.SourceLabel, Health: watcher.HealthHealthy},
+	})
+
+	want := []ActiveRoute{
+		{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Source: watcher.SourceLabel, Health: watcher.HealthHealthy, Since: since},
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "8080", Source: watcher.SourceLabel, Health: watcher.HealthHealthy, Since: since},
+		{Hostname: "new.localhost", ContainerName: "app-new-1", Port: "80"},
+	}
+	if !reflect.DeepEqual(active, want) {
+		t.Errorf("markMeta() = %+v, want %+v", active, want)
+	}
+}
+
+func TestListKnown(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if got, saved := ListKnown(); got != nil || !saved.IsZero() {
+		t.Fatalf("ListKnown() = %+v, %v, want nothing before a snapshot", got, saved)
+	}
+
+	snapshot := `saved: 2026-03-01T12:00:00Z
+routes:
+  - {hostname: api.localhost, container: app-api-1, port: "8080", project: app, service: api, quarantine: bad option}
+  - {hostname: app.localhost, container: app-web-1, port: "3000", project: app, service: web}
+  - {hostname: docs.localhost, container: host.docker.internal, port: "8000"}
+`
+	if err := os.MkdirAll(filepath.Dir(config.RouteSnapshotPath()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.RouteSnapshotPath(), []byte(snapshot), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, saved := ListKnown()
+	if want := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC); !saved.Equal(want) {
+		t.Errorf("saved = %v, want %v", saved, want)
+	}
+	want := []ActiveRoute{
+		{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "8080", Project: "app", Service: "api", Status: "QUARANTINED", Quarantine: "bad option"},
+		{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web", Status: "last known"},
+		{Hostname: "docs.localhost", ContainerName: "host.docker.internal", Port: "8000", Project: "-", Service: "-", Status: "static"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListKnown() = %+v, want %+v", got, want)
+	}
+}
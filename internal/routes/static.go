@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// AddStatic registers a static route from hostname to upstream, replacing
+// any static route the hostname already has. Upstreams on localhost, and
+// bare ports, are rewritten to reach the Docker host. It returns the saved
+// route and whether it replaced one to another upstream.
+func AddStatic(hostname, upstream string) (*config.StaticRoute, bool, error) {
+	hostname = strings.ToLower(hostname)
+	if err := config.ValidateHostname(hostname); err != nil {
+		return nil, false, err
+	}
+	if _, err := strconv.Atoi(upstream); err == nil {
+		upstream = ":" + upstream
+	}
+	up, err := normalizeUpstream(upstream)
+	if err != nil {
+		return nil, false, err
+	}
+	route := &config.StaticRoute{Hostname: hostname, Upstream: up}
+
+	replaced := false
+	err = config.LoadAndModify(func(cfg *config.Config) error {
+		if owner := cfg.HostnameOwner(hostname); owner != "" {
+			return fmt.Errorf("%s is routed by project %q", hostname, owner)
+		}
+		if existing := cfg.FindStaticRoute(hostname); existing != nil {
+			if existing.OwnerPID > 0 && config.ProcessAlive(existing.OwnerPID) {
+				return fmt.Errorf("%s is served by 'caddy-atc serve' (pid %d)", hostname, existing.OwnerPID)
+			}
+			replaced = existing.Upstream != up
+			cfg.RemoveStaticRoute(hostname, "")
+		}
+		cfg.AddStaticRoute(route)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return route, replaced, nil
+}
+
+// AddFileServer registers a static route serving the host directory dir at
+// hostname, replacing any static route the hostname already has. With spa,
+// paths with no file fall back to /index.html. It returns the saved route
+// and whether it replaced another one.
+func AddFileServer(hostname, dir string, spa bool) (*config.StaticRoute, bool, error) {
+	hostname = strings.ToLower(hostname)
+	if err := config.ValidateHostname(hostname); err != nil {
+		return nil, false, err
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, false, fmt.Errorf("resolving path: %w", err)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, fmt.Errorf("%s is not a directory", root)
+	}
+	route := &config.StaticRoute{Hostname: hostname, Root: root, SPA: spa}
+	if err := route.Validate(); err != nil {
+		return nil, false, err
+	}
+
+	replaced := false
+	err = config.LoadAndModify(func(cfg *config.Config) error {
+		if owner := cfg.HostnameOwner(hostname); owner != "" {
+			return fmt.Errorf("%s is routed by project %q", hostname, owner)
+		}
+		if existing := cfg.FindStaticRoute(hostname); existing != nil {
+			if existing.OwnerPID > 0 && config.ProcessAlive(existing.OwnerPID) {
+				return fmt.Errorf("%s is served by 'caddy-atc serve' (pid %d)", hostname, existing.OwnerPID)
+			}
+			replaced = existing.Root != root || existing.SPA != spa
+			cfg.RemoveStaticRoute(hostname, "")
+		}
+		cfg.AddStaticRoute(route)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return route, replaced, nil
+}
+
+// RemoveStatic removes the static routes for hostname. A route registered
+// by a running 'caddy-atc serve' is left to it.
+func RemoveStatic(hostname string) error {
+	hostname = strings.ToLower(hostname)
+	return config.LoadAndModify(func(cfg *config.Config) error {
+		existing := cfg.FindStaticRoute(hostname)
+		if existing == nil {
+			return fmt.Errorf("no static route for %s", hostname)
+		}
+		if existing.OwnerPID > 0 && config.ProcessAlive(existing.OwnerPID) {
+			return fmt.Errorf("%s is served by 'caddy-atc serve' (pid %d); stop it instead", hostname, existing.OwnerPID)
+		}
+		cfg.RemoveStaticRoute(hostname, "")
+		return nil
+	})
+}
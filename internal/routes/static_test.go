@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestAddStatic(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"myapp": {Hostname: "app.localhost", Services: map[string]string{"web": "app.localhost"}},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	route, replaced, err := AddStatic("Vite.localhost", "5173")
+	if err != nil {
+		t.Fatalf("AddStatic() error = %v", err)
+	}
+	if route.Hostname != "vite.localhost" || route.Upstream != "host.docker.internal:5173" || replaced {
+		t.Errorf("AddStatic() = %+v, replaced %v, want vite.localhost -> host.docker.internal:5173", route, replaced)
+	}
+
+	if _, replaced, err := AddStatic("vite.localhost", "localhost:5174"); err != nil || !replaced {
+		t.Errorf("AddStatic() to a new upstream = replaced %v, %v, want a replacement", replaced, err)
+	}
+	loaded, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.StaticRoutes) != 1 || loaded.StaticRoutes[0].Upstream != "host.docker.internal:5174" {
+		t.Errorf("StaticRoutes = %+v, want only the route to 5174", loaded.StaticRoutes)
+	}
+
+	if _, _, err := AddStatic("app.localhost", "3000"); err == nil || !strings.Contains(err.Error(), "myapp") {
+		t.Errorf("AddStatic() for an adopted hostname = %v, want an error naming the project", err)
+	}
+	if _, _, err := AddStatic("bad host", "3000"); err == nil {
+		t.Error("AddStatic() accepted an invalid hostname")
+	}
+}
+
+func TestRemoveStatic(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &config.Config{StaticRoutes: []*config.StaticRoute{
+		{Hostname: "vite.localhost", Upstream: "host.docker.internal:5173"},
+		{Hostname: "files.localhost", Upstream: "172.18.0.1:40000", OwnerPID: os.Getpid()},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemoveStatic("vite.localhost"); err != nil {
+		t.Fatalf("RemoveStatic() error = %v", err)
+	}
+	if err := RemoveStatic("vite.localhost"); err == nil {
+		t.Error("RemoveStatic() of a missing route succeeded")
+	}
+	if err := RemoveStatic("files.localhost"); err == nil || !strings.Contains(err.Error(), "serve") {
+		t.Errorf("RemoveStatic() of a live serve route = %v, want it left to serve", err)
+	}
+}
+
+func TestAddFileServer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+
+	route, replaced, err := AddFileServer("Docs.myapp.localhost", dir, false)
+	if err != nil {
+		t.Fatalf("AddFileServer() error = %v", err)
+	}
+	if route.Hostname != "docs.myapp.localhost" || route.Root != dir || route.Upstream != "" || replaced {
+		t.Errorf("AddFileServer() = %+v, replaced %v, want docs.myapp.localhost serving %s", route, replaced, dir)
+	}
+	if _, replaced, err := AddFileServer("docs.myapp.localhost", dir, true); err != nil || !replaced {
+		t.Errorf("AddFileServer() with spa = replaced %v, %v, want a replacement", replaced, err)
+	}
+	loaded, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.StaticRoutes) != 1 || !loaded.StaticRoutes[0].SPA {
+		t.Errorf("StaticRoutes = %+v, want only the SPA route", loaded.StaticRoutes)
+	}
+
+	if _, _, err := AddFileServer("files.localhost", filepath.Join(dir, "missing"), false); err == nil {
+		t.Error("AddFileServer() accepted a missing directory")
+	}
+	if _, _, err := AddFileServer("*.files.localhost", dir, false); err == nil {
+		t.Error("AddFileServer() accepted a wildcard hostname")
+	}
+}
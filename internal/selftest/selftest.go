@@ -0,0 +1,331 @@
+// Package selftest exercises a running caddy-atc end to end: it adopts and
+// starts a sample compose project, adds a static route to a process on this
+// machine, and checks that the gateway serves both over HTTPS.
+package selftest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/adopt"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/doctor"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/routes"
+	"github.com/g-brodiei/caddy-atc/internal/watcher"
+)
+
+// Project is the name of the sample project, and the first label of its
+// hostname. The static route's hostname is "host." under it.
+const Project = "caddy-atc-selftest"
+
+// routeTimeout bounds waiting for the watcher to route the sample project
+// and for the gateway to answer, which includes issuing a certificate and
+// starting a lazy gateway.
+const routeTimeout = time.Minute
+
+// sampleCompose runs Caddy's respond command in the gateway image, so the
+// test pulls nothing.
+const sampleCompose = `services:
+  web:
+    image: %s
+    command: ["caddy", "respond", "--listen", ":8080", "--body", "%s"]
+    labels:
+      caddy-atc.port: "8080"
+`
+
+// Run tests the gateway and watcher the user has running, reporting each
+// step as a check. It removes the sample project and the static route
+// before returning. Progress is written to out.
+func Run(ctx context.Context, out io.Writer) []doctor.Check {
+	var checks []doctor.Check
+
+	st, err := watcher.WatcherStatus(ctx)
+	switch {
+	case err != nil:
+		return append(checks, doctor.Check{Name: "Watcher", Detail: err.Error(), Hint: "run 'caddy-atc up -d'"})
+	case st.Paused != "":
+		return append(checks, doctor.Check{Name: "Watcher", Detail: "routing is paused (" + st.Paused + ")", Hint: "run 'caddy-atc resume'"})
+	}
+	checks = append(checks, doctor.Check{Name: "Watcher", OK: true, Detail: fmt.Sprintf("running (pid %d)", st.PID)})
+
+	cfg, err := config.Load()
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Config", Detail: err.Error()})
+	}
+	domain, _ := cfg.DomainSuffix()
+	_, httpsPort := cfg.HTTPPorts()
+	addr, _ := cfg.GatewayAddress()
+	if proj, ok := cfg.Projects[Project]; ok {
+		return append(checks, doctor.Check{Name: "Project", Detail: Project + " is already adopted from " + proj.Dir,
+			Hint: "a previous selftest was interrupted; run 'caddy-atc unadopt " + proj.Dir + "'"})
+	}
+
+	hostname := Project + domain
+	staticHostname := "host." + hostname
+	c := &client{addr: net.JoinHostPort(addr, strconv.Itoa(httpsPort))}
+
+	dir, err := os.MkdirTemp("", "caddy-atc-selftest-")
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Project", Detail: err.Error()})
+	}
+	defer os.RemoveAll(dir)
+	projectDir := filepath.Join(dir, Project)
+
+	token := randomToken()
+	fmt.Fprintf(out, "Starting sample project %s...\n", Project)
+	adopted, err := startProject(ctx, projectDir, hostname, token)
+	if adopted {
+		defer cleanupProject(ctx, out, projectDir)
+	}
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Project", Detail: err.Error()})
+	}
+	checks = append(checks, doctor.Check{Name: "Project", OK: true, Detail: "adopted and started"})
+
+	fmt.Fprintf(out, "Waiting for the watcher to route %s...\n", hostname)
+	if err := waitRouted(ctx, hostname); err != nil {
+		return append(checks, doctor.Check{Name: "Routing", Detail: err.Error(), Hint: "check 'caddy-atc logs watcher'"})
+	}
+	checks = append(checks, doctor.Check{Name: "Routing", OK: true, Detail: hostname + " routed"})
+
+	rootPEM, err := gateway.RootCA(ctx)
+	if err == nil {
+		c.roots = x509.NewCertPool()
+		if !c.roots.AppendCertsFromPEM(rootPEM) {
+			err = errors.New("no certificate in the gateway's root CA")
+		}
+	}
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Proxy", Detail: "reading the gateway's root CA: " + err.Error()})
+	}
+	if err := c.expect(ctx, hostname, token); err != nil {
+		return append(checks, doctor.Check{Name: "Proxy", Detail: err.Error(), Hint: "run 'caddy-atc doctor'"})
+	}
+	checks = append(checks, doctor.Check{Name: "Proxy", OK: true, Detail: "https://" + hostname + " answered through the gateway"})
+
+	fmt.Fprintf(out, "Adding static route %s...\n", staticHostname)
+	hostToken := randomToken()
+	stop, err := serveHost(ctx, staticHostname, hostToken)
+	if err != nil {
+		return append(checks, doctor.Check{Name: "Static route", Detail: err.Error()})
+	}
+	defer stop()
+	if err := c.expect(ctx, staticHostname, hostToken); err != nil {
+		return append(checks, doctor.Check{Name: "Static route", Detail: err.Error(),
+			Hint: "check that the gateway container can reach " + gateway.HostUpstream})
+	}
+	checks = append(checks, doctor.Check{Name: "Static route", OK: true, Detail: "https://" + staticHostname + " reached this machine"})
+
+	fmt.Fprintln(out, "Reloading the gateway...")
+	if err := watcher.ReloadWatcher(ctx); err != nil {
+		return append(checks, doctor.Check{Name: "Reload", Detail: err.Error()})
+	}
+	if err := c.expect(ctx, hostname, token); err != nil {
+		return append(checks, doctor.Check{Name: "Reload", Detail: "after reloading: " + err.Error()})
+	}
+	return append(checks, doctor.Check{Name: "Reload", OK: true, Detail: "routes served after a reload"})
+}
+
+// startProject writes the sample project to dir, adopts it at hostname and
+// starts it. It reports whether the project was adopted, and so needs
+// cleaning up, even on failure.
+func startProject(ctx context.Context, dir, hostname, token string) (bool, error) {
+	image, err := gateway.Image()
+	if err != nil {
+		return false, err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return false, err
+	}
+	composeFile := fmt.Sprintf(sampleCompose, image, token)
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(composeFile), 0600); err != nil {
+		return false, err
+	}
+	if _, err := adopt.Adopt(dir, hostname, "", false); err != nil {
+		return false, fmt.Errorf("adopting: %w", err)
+	}
+	if err := compose(ctx, dir, "up", "-d"); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// cleanupProject stops and unadopts the sample project, even once ctx is
+// cancelled.
+func cleanupProject(ctx context.Context, out io.Writer, dir string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Minute)
+	defer cancel()
+	fmt.Fprintf(out, "Removing sample project %s...\n", Project)
+	if err := compose(ctx, dir, "down", "--volumes", "--remove-orphans"); err != nil {
+		fmt.Fprintf(out, "Warning: %v\n", err)
+	}
+	if err := adopt.Unadopt(dir); err != nil {
+		fmt.Fprintf(out, "Warning: %v\n", err)
+	}
+	watcher.SyncWatcher(ctx)
+}
+
+// compose runs docker compose in dir, returning its output on failure.
+func compose(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"compose"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = config.FilterEnv("COMPOSE_FILE", "COMPOSE_PROJECT_NAME")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("docker compose %s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// waitRouted waits for the running watcher to serve hostname.
+func waitRouted(ctx context.Context, hostname string) error {
+	deadline := time.Now().Add(routeTimeout)
+	for {
+		served, err := watcher.WatcherRoutes(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range served {
+			if r.Hostname != hostname {
+				continue
+			}
+			if r.Quarantine != "" {
+				return fmt.Errorf("%s is quarantined: %s", hostname, r.Quarantine)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not routed after %s", hostname, routeTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// serveHost serves body on this machine where the gateway can reach it,
+// as 'caddy-atc serve' does, and routes hostname to it. stop removes the
+// route and stops serving.
+func serveHost(ctx context.Context, hostname, body string) (stop func(), err error) {
+	bindIP, upstreamHost, err := gateway.HostAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(bindIP, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", bindIP, err)
+	}
+	srv := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, body) }),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go srv.Serve(ln)
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if _, _, err := routes.AddStatic(hostname, net.JoinHostPort(upstreamHost, port)); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	stop = func() {
+		routes.RemoveStatic(hostname)
+		watcher.SyncWatcher(context.WithoutCancel(ctx))
+		srv.Close()
+	}
+	if err := watcher.SyncWatcher(ctx); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+// client requests hostnames through the gateway at addr, trusting only
+// its root CA.
+type client struct {
+	addr  string
+	roots *x509.CertPool
+}
+
+// expect requests https://hostname/ until it answers with body, or
+// routeTimeout passes.
+func (c *client) expect(ctx context.Context, hostname, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, routeTimeout)
+	defer cancel()
+	var lastErr error
+	for {
+		got, err := c.get(ctx, hostname)
+		switch {
+		case err != nil:
+			lastErr = err
+		case strings.TrimSpace(got) == body:
+			return nil
+		default:
+			lastErr = fmt.Errorf("https://%s answered %q, not the sample response", hostname, firstLine(got))
+		}
+		select {
+		case <-ctx.Done():
+			return lastErr
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (c *client) get(ctx context.Context, hostname string) (string, error) {
+	var d net.Dialer
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "tcp", c.addr)
+			},
+			TLSClientConfig: &tls.Config{ServerName: hostname, RootCAs: c.roots},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+hostname+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("https://%s answered %s", hostname, resp.Status)
+	}
+	return string(data), nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	if len(line) > 80 {
+		line = line[:80] + "..."
+	}
+	return line
+}
+
+// randomToken returns a response body only this run's upstreams send.
+func randomToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "caddy-atc-selftest-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,45 @@
+package selftest
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientExpect(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "example.com" {
+			http.Error(w, "wrong host", http.StatusMisdirectedRequest)
+			return
+		}
+		io.WriteString(w, "token\n")
+	}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	c := &client{addr: srv.Listener.Addr().String(), roots: roots}
+
+	// The test server's certificate is issued for example.com.
+	if err := c.expect(context.Background(), "example.com", "token"); err != nil {
+		t.Errorf("expect() error = %v", err)
+	}
+
+	if _, err := c.get(context.Background(), "example.org"); err == nil {
+		t.Error("get() accepted a certificate for another hostname")
+	}
+}
+
+func TestClientRejectsUntrustedCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "token")
+	}))
+	defer srv.Close()
+	c := &client{addr: srv.Listener.Addr().String(), roots: x509.NewCertPool()}
+
+	if _, err := c.get(context.Background(), "example.com"); err == nil {
+		t.Error("get() trusted a certificate outside the gateway's CA")
+	}
+}
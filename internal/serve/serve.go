@@ -0,0 +1,295 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// maxUploadSize caps a single upload request.
+const maxUploadSize = 512 << 20 // 512 MB
+
+// Options configures the serve command.
+type Options struct {
+	Dir      string // Directory to serve
+	Hostname string // Gateway hostname (e.g. files.localhost)
+	Upload   bool   // Accept multipart uploads into the served directory
+}
+
+// Run serves opts.Dir through the gateway at opts.Hostname until ctx is
+// cancelled. The file server listens on the host side of the caddy-atc
+// network and is registered as a temporary static route, removed on exit.
+func Run(ctx context.Context, opts Options) error {
+	if err := config.ValidateHostname(opts.Hostname); err != nil {
+		return err
+	}
+	if strings.HasPrefix(opts.Hostname, "*.") {
+		return fmt.Errorf("serve does not support wildcard hostnames")
+	}
+
+	absDir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return fmt.Errorf("resolving path: %w", err)
+	}
+	root, err := os.OpenRoot(absDir)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", absDir, err)
+	}
+	defer root.Close()
+
+	bindIP, upstreamHost, err := gateway.HostAddress(ctx)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(bindIP, "0"))
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", bindIP, err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	route := &config.StaticRoute{
+		Hostname: opts.Hostname,
+		Upstream: net.JoinHostPort(upstreamHost, port),
+		OwnerPID: os.Getpid(),
+	}
+	if err := registerRoute(route); err != nil {
+		ln.Close()
+		return err
+	}
+	defer unregisterRoute(route)
+
+	srv := &http.Server{
+		Handler:           NewHandler(root, opts.Hostname, opts.Upload),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	httpsPort := config.DefaultHTTPSPort
+	if cfg, err := config.Load(); err == nil {
+		_, httpsPort = cfg.HTTPPorts()
+	}
+	fmt.Printf("Serving %s at %s\n", absDir, config.SiteURL(opts.Hostname, httpsPort))
+	if opts.Upload {
+		fmt.Println("Uploads enabled.")
+	}
+	fmt.Println("Press Ctrl+C to stop.")
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ln) }()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		fmt.Println("\nStopped serving.")
+		return nil
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// registerRoute adds the temporary static route, replacing one left behind
+// by a serve process that no longer exists.
+func registerRoute(route *config.StaticRoute) error {
+	return config.LoadAndModify(func(cfg *config.Config) error {
+		if owner := cfg.HostnameOwner(route.Hostname); owner != "" {
+			return fmt.Errorf("hostname %s is already routed by project %q", route.Hostname, owner)
+		}
+		if existing := cfg.FindStaticRoute(route.Hostname); existing != nil {
+			if existing.OwnerPID == 0 || config.ProcessAlive(existing.OwnerPID) {
+				return fmt.Errorf("hostname %s is already used by a static route to %s", route.Hostname, existing.Upstream)
+			}
+			cfg.RemoveStaticRoute(route.Hostname, "")
+		}
+		cfg.AddStaticRoute(route)
+		return nil
+	})
+}
+
+func unregisterRoute(route *config.StaticRoute) {
+	err := config.LoadAndModify(func(cfg *config.Config) error {
+		cfg.RemoveStaticRoute(route.Hostname, route.Upstream)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: could not remove route for %s: %v\n", route.Hostname, err)
+	}
+}
+
+// NewHandler returns an HTTP handler serving files from root with directory
+// listings. With upload enabled, directory listings include an upload form
+// and POST requests to a directory store the submitted files there, unless
+// they come from a site other than hostname. All filesystem access goes
+// through root, so requests can't escape it.
+func NewHandler(root *os.Root, hostname string, upload bool) http.Handler {
+	files := http.FileServerFS(root.FS())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := relPath(r.URL.Path)
+		switch {
+		case r.Method == http.MethodPost && upload && !sameSite(r, hostname):
+			http.Error(w, "cross-origin uploads are not allowed", http.StatusForbidden)
+		case r.Method == http.MethodPost && upload:
+			handleUpload(w, r, root, name)
+		case r.Method == http.MethodGet && upload && strings.HasSuffix(r.URL.Path, "/") && isDir(root, name):
+			handleListing(w, root, name, r.URL.Path)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			files.ServeHTTP(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// relPath converts a URL path to a cleaned path relative to the served root.
+func relPath(urlPath string) string {
+	p := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// sameSite reports whether r was sent by a page on hostname, going by the
+// browser's Sec-Fetch-Site and Origin headers. Requests without either,
+// such as from curl, are not from another site.
+func sameSite(r *http.Request, hostname string) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Hostname(), hostname)
+}
+
+func isDir(root *os.Root, name string) bool {
+	info, err := root.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+func handleUpload(w http.ResponseWriter, r *http.Request, root *os.Root, dir string) {
+	if !isDir(root, dir) {
+		http.Error(w, "uploads must target a directory", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected multipart upload", http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "reading upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			continue
+		}
+
+		// Only the base name is used; clients can't choose a subdirectory.
+		base := filepath.Base(part.FileName())
+		if base == "." || base == ".." || base == string(filepath.Separator) {
+			http.Error(w, "invalid file name", http.StatusBadRequest)
+			return
+		}
+
+		f, err := root.OpenFile(path.Join(dir, base), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				http.Error(w, base+" already exists", http.StatusConflict)
+				return
+			}
+			http.Error(w, "saving upload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, copyErr := io.Copy(f, part)
+		closeErr := f.Close()
+		if copyErr != nil || closeErr != nil {
+			root.Remove(path.Join(dir, base))
+			http.Error(w, "saving upload failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+var listingTmpl = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple> <button type="submit">Upload</button>
+</form>
+<ul>
+{{range .Entries}}<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{end}}</ul>
+</body></html>
+`))
+
+type listingEntry struct {
+	Name string
+	Href string
+}
+
+func handleListing(w http.ResponseWriter, root *os.Root, dir string, urlPath string) {
+	f, err := root.Open(dir)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		http.Error(w, "reading directory", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	var entries []listingEntry
+	if dir != "." {
+		entries = append(entries, listingEntry{Name: "../", Href: "../"})
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		entries = append(entries, listingEntry{Name: name, Href: (&url.URL{Path: name}).String()})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	listingTmpl.Execute(w, struct {
+		Path    string
+		Entries []listingEntry
+	}{urlPath, entries})
+}
@@ -0,0 +1,162 @@
+package serve
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestHandler(t *testing.T, upload bool) (http.Handler, string) {
+	t.Helper()
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	return NewHandler(root, "files.localhost", upload), dir
+}
+
+func uploadRequest(t *testing.T, target, filename, content string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHandler_ServesFilesAndListings(t *testing.T) {
+	h, dir := newTestHandler(t, false)
+	os.WriteFile(filepath.Join(dir, "app.apk"), []byte("binary"), 0644)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "app.apk") {
+		t.Errorf("listing: code=%d body=%q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app.apk", nil))
+	if rec.Body.String() != "binary" {
+		t.Errorf("file body = %q, want %q", rec.Body.String(), "binary")
+	}
+}
+
+func TestHandler_UploadDisabled(t *testing.T) {
+	h, dir := newTestHandler(t, false)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, uploadRequest(t, "/", "x.txt", "data"))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.txt")); !os.IsNotExist(err) {
+		t.Error("file was written with uploads disabled")
+	}
+}
+
+func TestHandler_UploadCrossOrigin(t *testing.T) {
+	h, dir := newTestHandler(t, true)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"other origin", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"cross-site fetch", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same-site fetch", map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "https://app.localhost"}, http.StatusForbidden},
+		{"served origin", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "https://files.localhost:8443"}, http.StatusSeeOther},
+		{"no browser headers", nil, http.StatusSeeOther},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := fmt.Sprintf("f%d.txt", i)
+			req := uploadRequest(t, "/", name, "data")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("code = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			_, err := os.Stat(filepath.Join(dir, name))
+			if written := err == nil; written != (tt.want == http.StatusSeeOther) {
+				t.Errorf("file written = %v", written)
+			}
+		})
+	}
+}
+
+func TestHandler_Upload(t *testing.T) {
+	h, dir := newTestHandler(t, true)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, uploadRequest(t, "/sub/", "build.zip", "zipdata"))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("code = %d, want %d: %s", rec.Code, http.StatusSeeOther, rec.Body.String())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "build.zip"))
+	if err != nil || string(data) != "zipdata" {
+		t.Errorf("uploaded file = %q, %v", data, err)
+	}
+
+	// Existing files are never overwritten
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, uploadRequest(t, "/sub/", "build.zip", "other"))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("re-upload code = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	// Listing includes the upload form
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sub/", nil))
+	if !strings.Contains(rec.Body.String(), `enctype="multipart/form-data"`) {
+		t.Error("upload listing missing form")
+	}
+}
+
+func TestHandler_UploadTraversal(t *testing.T) {
+	h, dir := newTestHandler(t, true)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, uploadRequest(t, "/../../", "../../escape.txt", "data"))
+	// Path is cleaned to the root and the file name reduced to its base
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt")); err == nil {
+		t.Fatal("upload escaped the served directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); err != nil {
+		t.Errorf("expected upload to land in served root: %v (code %d)", err, rec.Code)
+	}
+}
+
+func TestRelPath(t *testing.T) {
+	tests := map[string]string{
+		"/":            ".",
+		"":             ".",
+		"/a/b/":        "a/b",
+		"/../../etc":   "etc",
+		"/a/../../b/c": "b/c",
+	}
+	for in, want := range tests {
+		if got := relPath(in); got != want {
+			t.Errorf("relPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
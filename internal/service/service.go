@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+const (
+	systemdUnit  = "caddy-atc.service"
+	launchdLabel = "com.caddy-atc.watcher"
+	serviceLog   = "service.log"
+)
+
+// unit holds the values a service definition is rendered from.
+type unit struct {
+	Exe  string            // caddy-atc binary
+	Home string            // caddy-atc state directory (working directory)
+	Env  map[string]string // environment needed to reach Docker
+}
+
+// Install writes a user service definition that runs `caddy-atc up` at
+// login and starts it: a systemd user unit on Linux, a launchd agent on macOS.
+func Install() error {
+	u, err := currentUnit()
+	if err != nil {
+		return err
+	}
+	path, err := DefinitionPath()
+	if err != nil {
+		return err
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		if err := os.WriteFile(path, []byte(renderSystemdUnit(u)), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		if err := run("systemctl", "--user", "daemon-reload"); err != nil {
+			return err
+		}
+		return run("systemctl", "--user", "enable", "--now", systemdUnit)
+	case "darwin":
+		if err := os.WriteFile(path, []byte(renderLaunchdPlist(u)), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		return run("launchctl", "load", "-w", path)
+	}
+	return unsupported()
+}
+
+// Uninstall stops the service and removes its definition.
+func Uninstall() error {
+	path, err := DefinitionPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("service is not installed (%s not found)", path)
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		if err := run("systemctl", "--user", "disable", "--now", systemdUnit); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+		return run("systemctl", "--user", "daemon-reload")
+	case "darwin":
+		if err := run("launchctl", "unload", "-w", path); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+		return nil
+	}
+	return unsupported()
+}
+
+// Status describes whether the service is installed and running.
+func Status() (string, error) {
+	path, err := DefinitionPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "not installed", nil
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		// Both commands exit non-zero for inactive/disabled units; the
+		// state word on stdout is what matters.
+		active, _ := exec.Command("systemctl", "--user", "is-active", systemdUnit).Output()
+		enabled, _ := exec.Command("systemctl", "--user", "is-enabled", systemdUnit).Output()
+		return fmt.Sprintf("%s, %s", orUnknown(active), orUnknown(enabled)), nil
+	case "darwin":
+		if err := exec.Command("launchctl", "list", launchdLabel).Run(); err != nil {
+			return "installed, not loaded", nil
+		}
+		return "installed, loaded", nil
+	}
+	return "", unsupported()
+}
+
+// DefinitionPath returns where the service definition is installed.
+func DefinitionPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("finding home directory: %w", err)
+	}
+	switch runtime.GOOS {
+	case "linux":
+		base := os.Getenv("XDG_CONFIG_HOME")
+		if base == "" {
+			base = filepath.Join(home, ".config")
+		}
+		return filepath.Join(base, "systemd", "user", systemdUnit), nil
+	case "darwin":
+		return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+	}
+	return "", unsupported()
+}
+
+func currentUnit() (unit, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return unit{}, fmt.Errorf("finding executable path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	// Service managers start with a minimal environment; carry over what
+	// the docker CLI needs to find the daemon.
+	env := map[string]string{}
+	for _, key := range []string{"PATH", "DOCKER_HOST", "DOCKER_CONTEXT", config.HomeEnv, "XDG_CONFIG_HOME", "XDG_STATE_HOME"} {
+		if v := os.Getenv(key); v != "" && !strings.ContainsAny(v, "\r\n") {
+			env[key] = v
+		}
+	}
+	return unit{Exe: exe, Home: config.StateDir(), Env: env}, nil
+}
+
+// renderSystemdUnit renders a systemd user unit running the watcher in the
+// foreground; systemd handles restarts and the journal captures output.
+func renderSystemdUnit(u unit) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=caddy-atc gateway and watcher\n")
+	b.WriteString("After=network-online.target docker.service\n")
+	b.WriteString("\n[Service]\n")
+	fmt.Fprintf(&b, "ExecStart=%s up\n", systemdQuote(u.Exe))
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", strings.ReplaceAll(u.Home, "%", "%%"))
+	for _, key := range sortedKeys(u.Env) {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(key+"="+u.Env[key]))
+	}
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5\n")
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String()
+}
+
+// renderLaunchdPlist renders a launchd agent running the watcher at login.
+// The watcher writes its own log, so only stderr (startup failures) is kept.
+func renderLaunchdPlist(u unit) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&b, "    <key>Label</key>\n    <string>%s</string>\n", launchdLabel)
+	b.WriteString("    <key>ProgramArguments</key>\n    <array>\n")
+	fmt.Fprintf(&b, "        <string>%s</string>\n        <string>up</string>\n", xmlEscape(u.Exe))
+	b.WriteString("    </array>\n")
+	fmt.Fprintf(&b, "    <key>WorkingDirectory</key>\n    <string>%s</string>\n", xmlEscape(u.Home))
+	if len(u.Env) > 0 {
+		b.WriteString("    <key>EnvironmentVariables</key>\n    <dict>\n")
+		for _, key := range sortedKeys(u.Env) {
+			fmt.Fprintf(&b, "        <key>%s</key>\n        <string>%s</string>\n", xmlEscape(key), xmlEscape(u.Env[key]))
+		}
+		b.WriteString("    </dict>\n")
+	}
+	b.WriteString("    <key>RunAtLoad</key>\n    <true/>\n")
+	b.WriteString("    <key>KeepAlive</key>\n    <dict>\n        <key>SuccessfulExit</key>\n        <false/>\n    </dict>\n")
+	b.WriteString("    <key>StandardOutPath</key>\n    <string>/dev/null</string>\n")
+	fmt.Fprintf(&b, "    <key>StandardErrorPath</key>\n    <string>%s</string>\n", xmlEscape(filepath.Join(u.Home, serviceLog)))
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// systemdQuote quotes a unit file value, escaping characters systemd
+// interprets inside double quotes and disabling % specifier expansion.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "%", "%%")
+	return `"` + s + `"`
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orUnknown(out []byte) string {
+	if s := strings.TrimSpace(string(out)); s != "" {
+		return s
+	}
+	return "unknown"
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func unsupported() error {
+	return fmt.Errorf("service management is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSystemdUnit(t *testing.T) {
+	u := unit{
+		Exe:  "/home/dev/go/bin/caddy-atc",
+		Home: "/home/dev/.caddy-atc",
+		Env:  map[string]string{"PATH": "/usr/local/bin:/usr/bin", "DOCKER_HOST": "unix:///run/user/1000/docker.sock"},
+	}
+	got := renderSystemdUnit(u)
+
+	for _, want := range []string{
+		`ExecStart="/home/dev/go/bin/caddy-atc" up`,
+		"WorkingDirectory=/home/dev/.caddy-atc\n",
+		`Environment="DOCKER_HOST=unix:///run/user/1000/docker.sock"`,
+		`Environment="PATH=/usr/local/bin:/usr/bin"`,
+		"Restart=on-failure",
+		"WantedBy=default.target",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in unit:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "DOCKER_HOST") > strings.Index(got, "PATH=") {
+		t.Error("expected environment sorted by key")
+	}
+}
+
+func TestRenderSystemdUnit_Escaping(t *testing.T) {
+	got := renderSystemdUnit(unit{Exe: `/opt/my "tools"/100%/caddy-atc`, Home: "/home/dev/.caddy-atc"})
+	want := `ExecStart="/opt/my \"tools\"/100%%/caddy-atc" up`
+	if !strings.Contains(got, want) {
+		t.Errorf("expected %q in unit:\n%s", want, got)
+	}
+}
+
+func TestRenderLaunchdPlist(t *testing.T) {
+	u := unit{
+		Exe:  "/Users/dev/bin/caddy-atc",
+		Home: "/Users/dev/.caddy-atc",
+		Env:  map[string]string{"PATH": "/usr/local/bin:/usr/bin&more"},
+	}
+	got := renderLaunchdPlist(u)
+
+	for _, want := range []string{
+		"<string>com.caddy-atc.watcher</string>",
+		"<string>/Users/dev/bin/caddy-atc</string>\n        <string>up</string>",
+		"<string>/usr/local/bin:/usr/bin&amp;more</string>",
+		"<string>/Users/dev/.caddy-atc/service.log</string>",
+		"<key>RunAtLoad</key>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in plist:\n%s", want, got)
+		}
+	}
+}
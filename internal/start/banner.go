@@ -0,0 +1,54 @@
+package start
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// writeBanner prints the project's routed hostnames as https URLs. With
+// hyperlinks, each URL is wrapped in an OSC 8 escape so terminals that
+// support it make the URL clickable.
+func writeBanner(w io.Writer, name string, proj *config.ProjectConfig, httpsPort int, hyperlinks bool) {
+	services := make([]string, 0, len(proj.Services))
+	width := 0
+	for svc := range proj.Services {
+		services = append(services, svc)
+		width = max(width, len(svc))
+	}
+	sort.Strings(services)
+
+	fmt.Fprintf(w, "\n%s is up:\n", name)
+	if len(services) == 0 {
+		fmt.Fprintln(w, "  (no HTTP services routed)")
+		return
+	}
+	for _, svc := range services {
+		host := proj.Services[svc]
+		url := config.SiteURL(host, httpsPort)
+		// Wildcard hostnames have no single address to open.
+		if hyperlinks && !strings.HasPrefix(host, "*.") {
+			url = hyperlink(url, url)
+		}
+		fmt.Fprintf(w, "  %-*s  %s\n", width, svc, url)
+	}
+}
+
+// hyperlink wraps text in an OSC 8 terminal hyperlink to target.
+func hyperlink(target, text string) string {
+	return "\x1b]8;;" + target + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+// supportsHyperlinks reports whether f is a terminal that is likely to
+// render OSC 8 escapes (or at least ignore them cleanly).
+func supportsHyperlinks(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
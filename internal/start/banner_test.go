@@ -0,0 +1,48 @@
+package start
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestWriteBanner(t *testing.T) {
+	proj := &config.ProjectConfig{
+		Hostname: "myapp.localhost",
+		Services: map[string]string{
+			"web": "myapp.localhost",
+			"api": "api.myapp.localhost",
+		},
+	}
+
+	var buf bytes.Buffer
+	writeBanner(&buf, "myapp", proj, 443, false)
+	got := buf.String()
+
+	want := "\nmyapp is up:\n  api  https://api.myapp.localhost\n  web  https://myapp.localhost\n"
+	if got != want {
+		t.Errorf("writeBanner() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestWriteBanner_Hyperlinks(t *testing.T) {
+	proj := &config.ProjectConfig{
+		Services: map[string]string{
+			"web":    "myapp.localhost",
+			"client": "*.myapp.localhost",
+		},
+	}
+
+	var buf bytes.Buffer
+	writeBanner(&buf, "myapp", proj, 443, true)
+	got := buf.String()
+
+	if !strings.Contains(got, "\x1b]8;;https://myapp.localhost\x1b\\https://myapp.localhost\x1b]8;;\x1b\\") {
+		t.Errorf("expected OSC 8 link for myapp.localhost, got %q", got)
+	}
+	if strings.Contains(got, "\x1b]8;;https://*.") {
+		t.Errorf("wildcard hostname should not be linked, got %q", got)
+	}
+}
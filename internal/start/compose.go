@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
 )
 
 // atomicWriteFile writes to a temp file then renames to prevent partial writes.
@@ -42,8 +46,10 @@ const strippedPrefix = ".caddy-atc-compose"
 
 // DetectComposeFiles finds which compose files Docker Compose would load
 // for the given project directory. If composeFile is provided (non-empty),
-// uses that file and looks for overrides. Otherwise, checks COMPOSE_FILE env var
-// first, then falls back to standard file detection (with override auto-loading).
+// uses that file and looks for overrides; a variant such as
+// docker-compose.dev.yml that only amends the base file's services is
+// loaded after it. Otherwise, checks COMPOSE_FILE env var first, then falls
+// back to standard file detection (with override auto-loading).
 func DetectComposeFiles(dir string, composeFile string) ([]string, error) {
 	if composeFile != "" {
 		var base string
@@ -56,6 +62,9 @@ func DetectComposeFiles(dir string, composeFile string) ([]string, error) {
 			return nil, fmt.Errorf("compose file not found: %s", base)
 		}
 		files := []string{base}
+		if parent := variantBase(base); parent != "" {
+			files = []string{parent, base}
+		}
 		override := findOverrideFile(filepath.Dir(base), base)
 		if override != "" {
 			files = append(files, override)
@@ -97,43 +106,84 @@ func DetectComposeFiles(dir string, composeFile string) ([]string, error) {
 	return files, nil
 }
 
-// GenerateStrippedFiles creates port-stripped copies of the given compose files.
-// If regenerate is false and the stripped file already exists, it is reused as-is.
-// Returns the paths to the stripped files in the same order.
-func GenerateStrippedFiles(originals []string, keepPorts []string, regenerate bool) ([]string, error) {
-	var stripped []string
+// GenerateStrippedFiles creates port-stripped copies of the given compose files,
+// applying t. Public URLs, the CA and healthchecks are added to services in
+// the base file only; overrides just amend them.
+// If regenerate is false and the stripped file already exists, it
+// is reused as-is. Returns the paths to the stripped files in the same order.
+func GenerateStrippedFiles(originals []string, keepPorts []string, t Transform, regenerate bool) ([]string, error) {
+	return generateStrippedFiles(originals, nil, keepPorts, t, regenerate)
+}
 
+// generateStrippedFiles is GenerateStrippedFiles taking the compose files
+// already parsed in this run, keyed by path. The others are read and
+// parsed as needed. Files are stripped concurrently, as a COMPOSE_FILE
+// chain in a monorepo can hold many large files.
+func generateStrippedFiles(originals []string, docs map[string]*composeDoc, keepPorts []string, t Transform, regenerate bool) ([]string, error) {
+	stripped := make([]string, len(originals))
+	errs := make([]error, len(originals))
+	var wg sync.WaitGroup
 	for i, orig := range originals {
-		dir := filepath.Dir(orig)
-		name := strippedFilename(i, len(originals))
-		outPath := filepath.Join(dir, name)
+		outPath := filepath.Join(filepath.Dir(orig), strippedFilename(i, len(originals)))
+		stripped[i] = outPath
 
 		// Skip generation if file exists and regenerate is not requested
 		if !regenerate {
 			if _, err := os.Stat(outPath); err == nil {
-				stripped = append(stripped, outPath)
 				continue
 			}
 		}
 
-		data, err := os.ReadFile(orig)
-		if err != nil {
-			return nil, fmt.Errorf("reading %s: %w", orig, err)
+		// Services are declared in the base file; overrides only amend them.
+		ft := t
+		if i > 0 {
+			ft = Transform{FixBind: t.FixBind}
 		}
+		doc := docs[orig]
+		wg.Go(func() {
+			errs[i] = stripFile(orig, doc, outPath, keepPorts, ft)
+		})
+	}
+	wg.Wait()
 
-		out, err := StripPorts(data, keepPorts)
+	for _, err := range errs {
 		if err != nil {
-			return nil, fmt.Errorf("stripping ports from %s: %w", orig, err)
+			return nil, err
 		}
+	}
+	return stripped, nil
+}
 
-		if err := atomicWriteFile(outPath, out, 0644); err != nil {
-			return nil, fmt.Errorf("writing %s: %w", outPath, err)
+// stripFile writes the stripped copy of orig to outPath, parsing orig
+// unless doc holds it already.
+func stripFile(orig string, doc *composeDoc, outPath string, keepPorts []string, t Transform) error {
+	if doc == nil {
+		var err error
+		if doc, err = readCompose(orig); err != nil {
+			return err
 		}
-
-		stripped = append(stripped, outPath)
 	}
+	out, err := doc.transform(keepPorts, t)
+	if err != nil {
+		return fmt.Errorf("stripping ports from %s: %w", orig, err)
+	}
+	if err := atomicWriteFile(outPath, out, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", outPath, err)
+	}
+	return nil
+}
 
-	return stripped, nil
+// readCompose reads and parses a compose file.
+func readCompose(path string) (*composeDoc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	doc, err := parseCompose(data)
+	if err != nil {
+		return nil, fmt.Errorf("stripping ports from %s: %w", path, err)
+	}
+	return doc, nil
 }
 
 // BuildComposeFileEnv builds the COMPOSE_FILE env var value from file paths.
@@ -170,6 +220,72 @@ func findOverrideFile(dir string, base string) string {
 	return ""
 }
 
+// variantBase returns the base compose file that the variant at path, such
+// as docker-compose.dev.yml or compose.prod.yaml, is layered on, or "" if
+// path isn't a variant or defines complete services of its own. A variant
+// is layered when one of its services has neither an image nor a build,
+// so it can't run without the base file.
+func variantBase(path string) string {
+	name := filepath.Base(path)
+	stem, variant, ok := strings.Cut(strings.TrimSuffix(name, filepath.Ext(name)), ".")
+	if !ok || variant == "override" || (stem != "docker-compose" && stem != "compose") {
+		return ""
+	}
+	var parent string
+	for _, ext := range []string{".yml", ".yaml"} {
+		if p := filepath.Join(filepath.Dir(path), stem+ext); fileExists(p) {
+			parent = p
+			break
+		}
+	}
+	if parent == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var cf struct {
+		Services map[string]struct {
+			Image string `yaml:"image"`
+			Build any    `yaml:"build"`
+		} `yaml:"services"`
+	}
+	if yaml.Unmarshal(data, &cf) != nil {
+		return ""
+	}
+	for _, svc := range cf.Services {
+		if svc.Image == "" && svc.Build == nil {
+			return parent
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// ComposeProfiles returns the compose profiles in effect: flags, as given
+// to `start --profile`, or else those in COMPOSE_PROFILES. Either may hold
+// comma-separated lists.
+func ComposeProfiles(flags []string) []string {
+	if len(flags) == 0 {
+		flags = []string{os.Getenv("COMPOSE_PROFILES")}
+	}
+	var profiles []string
+	for _, f := range flags {
+		for p := range strings.SplitSeq(f, ",") {
+			if p = strings.TrimSpace(p); p != "" && !slices.Contains(profiles, p) {
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	return profiles
+}
+
 func strippedFilename(index int, total int) string {
 	if total == 1 {
 		return strippedPrefix + ".yml"
@@ -177,5 +293,9 @@ func strippedFilename(index int, total int) string {
 	if index == 0 {
 		return strippedPrefix + ".yml"
 	}
+	// Files of a COMPOSE_FILE chain may share a directory.
+	if index > 1 {
+		return fmt.Sprintf("%s.override-%d.yml", strippedPrefix, index)
+	}
 	return strippedPrefix + ".override.yml"
 }
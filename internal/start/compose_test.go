@@ -1,8 +1,10 @@
 package start
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -91,7 +93,7 @@ func TestGenerateStrippedFiles(t *testing.T) {
 	original := filepath.Join(dir, "docker-compose.yml")
 	os.WriteFile(original, []byte(compose), 0644)
 
-	stripped, err := GenerateStrippedFiles([]string{original}, nil, false)
+	stripped, err := GenerateStrippedFiles([]string{original}, nil, Transform{}, false)
 	if err != nil {
 		t.Fatalf("GenerateStrippedFiles() error = %v", err)
 	}
@@ -123,7 +125,7 @@ func TestGenerateStrippedFiles_Override(t *testing.T) {
 		filepath.Join(dir, "docker-compose.yml"),
 		filepath.Join(dir, "docker-compose.override.yml"),
 	}
-	stripped, err := GenerateStrippedFiles(originals, nil, false)
+	stripped, err := GenerateStrippedFiles(originals, nil, Transform{}, false)
 	if err != nil {
 		t.Fatalf("GenerateStrippedFiles() error = %v", err)
 	}
@@ -140,6 +142,63 @@ func TestGenerateStrippedFiles_Override(t *testing.T) {
 	}
 }
 
+func TestGenerateStrippedFiles_Chain(t *testing.T) {
+	dir := t.TempDir()
+	var originals []string
+	for i, name := range []string{"compose.yml", "compose.db.yml", "compose.cache.yml", "compose.dev.yml"} {
+		path := filepath.Join(dir, name)
+		svc := fmt.Sprintf("svc%d", i)
+		os.WriteFile(path, []byte("services:\n  "+svc+":\n    image: nginx\n    ports:\n      - \"80\"\n"), 0644)
+		originals = append(originals, path)
+	}
+
+	stripped, err := GenerateStrippedFiles(originals, nil, Transform{}, false)
+	if err != nil {
+		t.Fatalf("GenerateStrippedFiles() error = %v", err)
+	}
+	seen := make(map[string]bool)
+	for i, path := range stripped {
+		if seen[path] {
+			t.Fatalf("stripped files share %s", path)
+		}
+		seen[path] = true
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), fmt.Sprintf("svc%d:", i)) || strings.Contains(string(data), "ports:") {
+			t.Errorf("%s =\n%s", filepath.Base(path), data)
+		}
+	}
+
+	// A file that fails is reported; the others are unaffected.
+	os.WriteFile(originals[2], []byte("services: ["), 0644)
+	if _, err := GenerateStrippedFiles(originals, nil, Transform{}, true); err == nil || !strings.Contains(err.Error(), "compose.cache.yml") {
+		t.Errorf("GenerateStrippedFiles() error = %v, want one naming compose.cache.yml", err)
+	}
+}
+
+func TestGenerateStrippedFiles_ParsedDoc(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "docker-compose.yml")
+	os.WriteFile(original, []byte("services:\n  web:\n    image: nginx\n"), 0644)
+
+	// A document parsed earlier in the run is stripped without reading
+	// the file again.
+	doc, err := parseCompose([]byte("services:\n  api:\n    image: node\n    ports:\n      - \"3000:3000\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped, err := generateStrippedFiles([]string{original}, map[string]*composeDoc{original: doc}, nil, Transform{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(stripped[0])
+	if !strings.Contains(string(data), "api:") || strings.Contains(string(data), "ports:") {
+		t.Errorf("stripped file =\n%s", data)
+	}
+}
+
 func TestDetectComposeFiles_ExplicitFile(t *testing.T) {
 	dir := t.TempDir()
 	os.WriteFile(filepath.Join(dir, "docker-compose.demo.yaml"), []byte("services:\n  web:\n    image: nginx\n"), 0644)
@@ -189,7 +248,7 @@ func TestGenerateStrippedFiles_SkipsExisting(t *testing.T) {
 	customContent := "services:\n  web:\n    image: mycustom:latest\n"
 	os.WriteFile(strippedPath, []byte(customContent), 0644)
 
-	stripped, err := GenerateStrippedFiles([]string{original}, nil, false)
+	stripped, err := GenerateStrippedFiles([]string{original}, nil, Transform{}, false)
 	if err != nil {
 		t.Fatalf("GenerateStrippedFiles() error = %v", err)
 	}
@@ -216,7 +275,7 @@ func TestGenerateStrippedFiles_RegenerateOverwrites(t *testing.T) {
 	strippedPath := filepath.Join(dir, ".caddy-atc-compose.yml")
 	os.WriteFile(strippedPath, []byte("services:\n  web:\n    image: mycustom:latest\n"), 0644)
 
-	stripped, err := GenerateStrippedFiles([]string{original}, nil, true)
+	stripped, err := GenerateStrippedFiles([]string{original}, nil, Transform{}, true)
 	if err != nil {
 		t.Fatalf("GenerateStrippedFiles() error = %v", err)
 	}
@@ -235,6 +294,47 @@ func TestGenerateStrippedFiles_RegenerateOverwrites(t *testing.T) {
 	}
 }
 
+func TestDetectComposeFiles_Variant(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte("services:\n  web:\n    image: nginx\n"), 0644)
+	// The dev variant only amends web, so it's layered on the base file.
+	os.WriteFile(filepath.Join(dir, "docker-compose.dev.yml"), []byte("services:\n  web:\n    environment:\n      DEBUG: \"1\"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "docker-compose.dev.override.yml"), []byte("services: {}\n"), 0644)
+	// The prod variant is complete on its own.
+	os.WriteFile(filepath.Join(dir, "docker-compose.prod.yml"), []byte("services:\n  web:\n    image: nginx:1.27\n"), 0644)
+
+	for name, want := range map[string][]string{
+		"docker-compose.dev.yml":  {"docker-compose.yml", "docker-compose.dev.yml", "docker-compose.dev.override.yml"},
+		"docker-compose.prod.yml": {"docker-compose.prod.yml"},
+	} {
+		files, err := DetectComposeFiles(dir, name)
+		if err != nil {
+			t.Fatalf("DetectComposeFiles(%s) error = %v", name, err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, filepath.Base(f))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DetectComposeFiles(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestComposeProfiles(t *testing.T) {
+	t.Setenv("COMPOSE_PROFILES", "debug, mail")
+	if got := ComposeProfiles(nil); !reflect.DeepEqual(got, []string{"debug", "mail"}) {
+		t.Errorf("ComposeProfiles() from COMPOSE_PROFILES = %v", got)
+	}
+	if got := ComposeProfiles([]string{"admin", "docs,admin"}); !reflect.DeepEqual(got, []string{"admin", "docs"}) {
+		t.Errorf("ComposeProfiles(flags) = %v, want the flags to replace COMPOSE_PROFILES", got)
+	}
+	t.Setenv("COMPOSE_PROFILES", "")
+	if got := ComposeProfiles(nil); got != nil {
+		t.Errorf("ComposeProfiles() without profiles = %v", got)
+	}
+}
+
 func TestBuildComposeFileEnv(t *testing.T) {
 	files := []string{"/project/.caddy-atc-compose.yml", "/project/.caddy-atc-compose.override.yml"}
 	got := BuildComposeFileEnv(files)
@@ -243,3 +343,46 @@ func TestBuildComposeFileEnv(t *testing.T) {
 		t.Errorf("BuildComposeFileEnv() = %q, want %q", got, want)
 	}
 }
+
+// largeCompose returns a compose file with n services, each with ports,
+// environment and labels, as in a monorepo.
+func largeCompose(prefix string, n int) []byte {
+	var b strings.Builder
+	b.WriteString("# generated\nservices:\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "  %s%d:\n    image: node:20 # pinned\n    ports:\n      - \"%d:3000\"\n", prefix, i, 10000+i)
+		b.WriteString("    environment:\n      NODE_ENV: development\n      DATABASE_URL: postgres://db:5432/app\n")
+		fmt.Fprintf(&b, "    labels:\n      caddy-atc.hostname: %s%d.app.localhost\n", prefix, i)
+	}
+	return []byte(b.String())
+}
+
+// BenchmarkGenerateStrippedFiles measures stripping a COMPOSE_FILE chain
+// of six large files, as `start --regenerate` does.
+func BenchmarkGenerateStrippedFiles(b *testing.B) {
+	dir := b.TempDir()
+	var originals []string
+	for i := 0; i < 6; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("compose.%d.yml", i))
+		if err := os.WriteFile(path, largeCompose(fmt.Sprintf("svc%d-", i), 150), 0644); err != nil {
+			b.Fatal(err)
+		}
+		originals = append(originals, path)
+	}
+	t := Transform{PublicURLs: map[string]string{"svc0-1": "https://svc0-1.app.localhost"}, FixBind: true}
+	for b.Loop() {
+		if _, err := GenerateStrippedFiles(originals, nil, t, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTransformCompose measures stripping one large compose file.
+func BenchmarkTransformCompose(b *testing.B) {
+	data := largeCompose("svc", 500)
+	for b.Loop() {
+		if _, err := transformCompose(data, nil, Transform{FixBind: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
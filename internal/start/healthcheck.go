@@ -0,0 +1,91 @@
+package start
+
+import (
+	"fmt"
+
+	"github.com/g-brodiei/caddy-atc/internal/adopt"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Timing of synthesized healthchecks, in line with the watcher's own
+// upstream probes.
+const (
+	healthcheckInterval    = "10s"
+	healthcheckTimeout     = "3s"
+	healthcheckRetries     = 3
+	healthcheckStartPeriod = "60s"
+)
+
+// healthchecks maps the routed HTTP services of the project in dir, as
+// the compose files configure them with profiles active, to the command of
+// a healthcheck probing them from inside their container. Files are taken
+// from docs, and those not in it are read and added for stripping.
+func healthchecks(dir string, files []string, docs map[string]*composeDoc, profiles []string) (map[string]string, error) {
+	pf, err := config.LoadProjectFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*yaml.Node, len(files))
+	for i, path := range files {
+		if docs[path] == nil {
+			if docs[path], err = readCompose(path); err != nil {
+				return nil, err
+			}
+		}
+		nodes[i] = &docs[path].node
+	}
+	services, err := adopt.ScanComposeNodes(files, nodes, profiles, pf)
+	if err != nil {
+		return nil, err
+	}
+	commands := make(map[string]string)
+	for _, svc := range services {
+		if !svc.IsHTTP || svc.Ignored || svc.Port == "" {
+			continue
+		}
+		scheme := svc.UpstreamScheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		path := svc.HealthPath
+		if path == "" {
+			path = "/"
+		}
+		commands[svc.Name] = healthcheckCommand(scheme+"://localhost:"+svc.Port+path, svc.HealthPath != "")
+	}
+	return commands, nil
+}
+
+// healthcheckCommand returns a shell command that succeeds once url
+// answers: with a 2xx if strict, as a health path label promises, or else
+// with any response, which shows the server is up. curl is preferred, then
+// wget; images with neither can't be probed and pass.
+func healthcheckCommand(url string, strict bool) string {
+	curl, wget := "curl -sk", "wget -S -T 2 --no-check-certificate -O /dev/null '"+url+"' 2>&1 | grep -q HTTP/"
+	if strict {
+		curl, wget = "curl -fsk", "wget -q -T 2 --no-check-certificate -O /dev/null '"+url+"'"
+	}
+	return fmt.Sprintf("if command -v curl >/dev/null; then %s -o /dev/null --max-time 2 '%s'; elif command -v wget >/dev/null; then %s; fi", curl, url, wget)
+}
+
+// addHealthcheck gives a service a healthcheck running command, unless it
+// defines one (including one that disables the image's).
+func addHealthcheck(svc *yaml.Node, command string) {
+	for i := 0; i < len(svc.Content)-1; i += 2 {
+		if svc.Content[i].Value == "healthcheck" {
+			return
+		}
+	}
+	test := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+	test.Content = append(test.Content, scalarNode("CMD-SHELL"), scalarNode(command))
+	hc := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	hc.Content = append(hc.Content,
+		scalarNode("test"), test,
+		scalarNode("interval"), scalarNode(healthcheckInterval),
+		scalarNode("timeout"), scalarNode(healthcheckTimeout),
+		scalarNode("retries"), &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(healthcheckRetries)},
+		scalarNode("start_period"), scalarNode(healthcheckStartPeriod),
+	)
+	svc.Content = append(svc.Content, scalarNode("healthcheck"), hc)
+}
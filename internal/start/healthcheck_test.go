@@ -0,0 +1,141 @@
+package start
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestHealthchecks(t *testing.T) {
+	dir := t.TempDir()
+	compose := `services:
+  web:
+    image: node:20
+    ports:
+      - "3000:3000"
+    labels:
+      caddy-atc.health-path: /healthz
+  kc:
+    image: quay.io/keycloak/keycloak
+    expose:
+      - "8443"
+  db:
+    image: postgres:16
+  admin:
+    image: nginx
+    labels:
+      caddy-atc.ignore: "true"
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "docker-compose.yml")
+	docs := make(map[string]*composeDoc)
+	got, err := healthchecks(dir, []string{path}, docs, nil)
+	if err != nil {
+		t.Fatalf("healthchecks() error = %v", err)
+	}
+	want := map[string]string{
+		"web": healthcheckCommand("http://localhost:3000/healthz", true),
+		"kc":  healthcheckCommand("https://localhost:8443/", false),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("healthchecks() = %v, want %v", got, want)
+	}
+	if docs[path] == nil {
+		t.Error("healthchecks() did not keep the parsed compose file for stripping")
+	}
+}
+
+func TestHealthchecks_Profiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "docker-compose.yml")
+	override := filepath.Join(dir, "docker-compose.override.yml")
+	os.WriteFile(base, []byte(`services:
+  web:
+    image: nginx
+  debug:
+    image: nginx
+    profiles: [debug]
+  api:
+    build: .
+`), 0644)
+	// The override makes api an HTTP service.
+	os.WriteFile(override, []byte("services:\n  api:\n    expose: [\"8080\"]\n"), 0644)
+
+	for _, tt := range []struct {
+		profiles []string
+		want     []string
+	}{
+		{nil, []string{"api", "web"}},
+		{[]string{"debug"}, []string{"api", "debug", "web"}},
+	} {
+		got, err := healthchecks(dir, []string{base, override}, make(map[string]*composeDoc), tt.profiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for svc := range got {
+			names = append(names, svc)
+		}
+		slices.Sort(names)
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("healthchecks(profiles %v) = %v, want services %v", tt.profiles, names, tt.want)
+		}
+	}
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	loose := healthcheckCommand("http://localhost:3000/", false)
+	for _, want := range []string{"curl -sk -o /dev/null --max-time 2 'http://localhost:3000/'", "| grep -q HTTP/"} {
+		if !strings.Contains(loose, want) {
+			t.Errorf("healthcheckCommand(loose) = %q, want it to contain %q", loose, want)
+		}
+	}
+	strict := healthcheckCommand("http://localhost:3000/healthz", true)
+	if !strings.Contains(strict, "curl -fsk") || strings.Contains(strict, "grep") {
+		t.Errorf("healthcheckCommand(strict) = %q, want failing statuses to fail", strict)
+	}
+	// Compose interpolates $ in the stripped file.
+	if strings.Contains(loose+strict, "$") {
+		t.Errorf("healthcheck commands must not contain $: %q, %q", loose, strict)
+	}
+}
+
+func TestTransformCompose_Healthcheck(t *testing.T) {
+	input := `services:
+  web:
+    image: web
+  api:
+    image: api
+    healthcheck:
+      disable: true
+`
+	checks := map[string]string{"web": "true", "api": "true"}
+	got, err := transformCompose([]byte(input), nil, Transform{Healthchecks: checks})
+	if err != nil {
+		t.Fatalf("transformCompose() error = %v", err)
+	}
+
+	var parsed struct {
+		Services map[string]struct {
+			Healthcheck map[string]any `yaml:"healthcheck"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("parsing output: %v", err)
+	}
+	web := parsed.Services["web"].Healthcheck
+	if !reflect.DeepEqual(web["test"], []any{"CMD-SHELL", "true"}) || web["interval"] != "10s" || web["retries"] != 3 {
+		t.Errorf("web healthcheck = %v", web)
+	}
+	if api := parsed.Services["api"].Healthcheck; !reflect.DeepEqual(api, map[string]any{"disable": true}) {
+		t.Errorf("api healthcheck = %v, want its own kept", api)
+	}
+}
@@ -0,0 +1,450 @@
+package start
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Stripped compose files are written by patching the original text rather
+// than marshaling the edited nodes, which would reflow indentation, move
+// comments and requote strings. The edits are found by comparing the
+// nodes before and after the transform: removed keys are cut out, keys and
+// list items appended to block collections are inserted after their last
+// line, and changed scalars are replaced where they stand. Anything else,
+// such as an edit inside a flow collection, or a patched file that doesn't
+// parse back to the transformed document, falls back to marshaling.
+
+// textEdit replaces src[start:end] with text.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// patcher collects the text edits turning src into the transformed file.
+type patcher struct {
+	src    []byte
+	lines  []int // offset of the start of each line
+	indent int   // spaces per nesting level of inserted blocks
+	edits  []textEdit
+}
+
+// patchCompose returns src with the differences between before, a copy of
+// the parsed document, and after, the same document as transformed, or
+// false if they can't all be expressed as text edits.
+func patchCompose(src []byte, before, after *yaml.Node) ([]byte, bool) {
+	if bytes.ContainsRune(src, '\r') {
+		return nil, false
+	}
+	p := &patcher{src: src, lines: []int{0}, indent: documentIndent(before)}
+	for i, c := range src {
+		if c == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+	if !p.diff(before, after) {
+		return nil, false
+	}
+	out, ok := p.apply()
+	if !ok {
+		return nil, false
+	}
+	var check yaml.Node
+	if yaml.Unmarshal(out, &check) != nil || !sameNodes(&check, after) {
+		return nil, false
+	}
+	return out, true
+}
+
+// documentIndent guesses the indentation step of a parsed compose file
+// from the first nested block mapping, defaulting to two spaces.
+func documentIndent(doc *yaml.Node) int {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return 2
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+		if val.Kind == yaml.MappingNode && val.Style&yaml.FlowStyle == 0 && len(val.Content) > 0 {
+			if step := val.Content[0].Column - key.Column; step > 0 {
+				return step
+			}
+		}
+	}
+	return 2
+}
+
+// diff records the edits turning before into after. Nodes of after that
+// were parsed from src keep their position; added ones have none.
+func (p *patcher) diff(before, after *yaml.Node) bool {
+	if after.Line != before.Line || after.Column != before.Column || after.Kind != before.Kind {
+		return false
+	}
+	switch before.Kind {
+	case yaml.DocumentNode:
+		return len(before.Content) == len(after.Content) &&
+			(len(before.Content) == 0 || p.diff(before.Content[0], after.Content[0]))
+	case yaml.ScalarNode:
+		if before.Value == after.Value && before.Tag == after.Tag {
+			return true
+		}
+		return p.replaceScalar(before, after)
+	case yaml.AliasNode:
+		return before.Value == after.Value
+	case yaml.MappingNode:
+		if before.Style&yaml.FlowStyle != 0 {
+			return p.diffFlow(before, after)
+		}
+		return p.diffMapping(before, after)
+	case yaml.SequenceNode:
+		if before.Style&yaml.FlowStyle != 0 {
+			return p.diffFlow(before, after)
+		}
+		return p.diffSequence(before, after)
+	}
+	return false
+}
+
+// diffFlow handles a flow collection, in which only scalars can be
+// replaced.
+func (p *patcher) diffFlow(before, after *yaml.Node) bool {
+	if len(before.Content) != len(after.Content) {
+		return false
+	}
+	for i := range before.Content {
+		if !p.diff(before.Content[i], after.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffMapping handles a block mapping whose keys may have been removed or
+// appended.
+func (p *patcher) diffMapping(before, after *yaml.Node) bool {
+	kept := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		if key := after.Content[i]; key.Line != 0 {
+			kept[key.Value] = after.Content[i+1]
+		}
+	}
+	for i := 0; i+1 < len(before.Content); i += 2 {
+		key, val := before.Content[i], before.Content[i+1]
+		newVal, ok := kept[key.Value]
+		if !ok {
+			if !p.remove(key, val) {
+				return false
+			}
+			continue
+		}
+		if isBlockMapping(val) && len(val.Content) > 0 && len(newVal.Content) == 0 {
+			if !p.empty(key, val) {
+				return false
+			}
+			continue
+		}
+		if !p.diff(val, newVal) {
+			return false
+		}
+	}
+
+	var added []*yaml.Node
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		key, val := after.Content[i], after.Content[i+1]
+		if key.Line != 0 {
+			if len(added) > 0 {
+				return false // only appended keys can be inserted
+			}
+			continue
+		}
+		added = append(added, key, val)
+	}
+	if len(added) == 0 {
+		return true
+	}
+	// Added keys follow the last key kept.
+	for i := len(before.Content) - 2; i >= 0; i -= 2 {
+		key, val := before.Content[i], before.Content[i+1]
+		if _, ok := kept[key.Value]; !ok {
+			continue
+		}
+		block := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: added}
+		return p.insert(p.blockEnd(key.Line, key.Column-1, isBlockSequence(val)), before.Content[0].Column-1, block)
+	}
+	return false
+}
+
+// diffSequence handles a block sequence whose items may have been
+// appended.
+func (p *patcher) diffSequence(before, after *yaml.Node) bool {
+	if len(after.Content) < len(before.Content) || len(before.Content) == 0 {
+		return sameNodes(before, after)
+	}
+	for i, item := range before.Content {
+		if !p.diff(item, after.Content[i]) {
+			return false
+		}
+	}
+	added := after.Content[len(before.Content):]
+	if len(added) == 0 {
+		return true
+	}
+	for _, item := range added {
+		if item.Line != 0 {
+			return false
+		}
+	}
+	last := before.Content[len(before.Content)-1]
+	dash, ok := p.dashIndent(last)
+	if !ok {
+		return false
+	}
+	block := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: added}
+	return p.insert(p.blockEnd(last.Line, dash, false), dash, block)
+}
+
+// remove cuts a key and its value, with the lines belonging to them.
+func (p *patcher) remove(key, val *yaml.Node) bool {
+	start, ok := p.lineStart(key)
+	if !ok {
+		return false
+	}
+	p.edits = append(p.edits, textEdit{start: start, end: p.blockEnd(key.Line, key.Column-1, isBlockSequence(val)), text: ""})
+	return true
+}
+
+// empty replaces a block mapping whose keys were all removed with {}, as
+// compose reads a key without a value as null, not as an empty mapping.
+func (p *patcher) empty(key, val *yaml.Node) bool {
+	start, ok := p.lineStart(key)
+	if !ok || val.Anchor != "" {
+		return false
+	}
+	keyEnd, ok := p.scalarEnd(key, start+key.Column-1)
+	if !ok {
+		return false
+	}
+	end := p.blockEnd(key.Line, key.Column-1, false)
+	p.edits = append(p.edits, textEdit{start: start, end: end, text: string(p.src[start:keyEnd]) + ": {}\n"})
+	return true
+}
+
+// insert writes block, indented by indent spaces, at offset at, the end
+// of the last entry of the collection it extends.
+func (p *patcher) insert(at, indent int, block *yaml.Node) bool {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(p.indent)
+	if err := enc.Encode(block); err != nil {
+		return false
+	}
+	enc.Close()
+
+	prefix := strings.Repeat(" ", indent)
+	var text strings.Builder
+	if at > 0 && p.src[at-1] != '\n' {
+		text.WriteString("\n") // the file doesn't end with a newline
+	}
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if line != "\n" {
+			text.WriteString(prefix)
+		}
+		text.WriteString(line)
+	}
+	p.edits = append(p.edits, textEdit{start: at, end: at, text: text.String()})
+	return true
+}
+
+// replaceScalar rewrites a single-line scalar in its original style.
+func (p *patcher) replaceScalar(before, after *yaml.Node) bool {
+	if before.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 || before.Line < 1 || before.Line > len(p.lines) {
+		return false
+	}
+	start := p.lines[before.Line-1] + before.Column - 1
+	end, ok := p.scalarEnd(before, start)
+	if !ok {
+		return false
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: after.Tag, Style: before.Style, Value: after.Value}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return false
+	}
+	text := strings.TrimSuffix(string(out), "\n")
+	if strings.Contains(text, "\n") {
+		return false
+	}
+	p.edits = append(p.edits, textEdit{start: start, end: end, text: text})
+	return true
+}
+
+// scalarEnd returns the offset just past the source text of a single-line
+// scalar starting at start.
+func (p *patcher) scalarEnd(n *yaml.Node, start int) (int, bool) {
+	line := p.src[start:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	switch {
+	case n.Style&yaml.DoubleQuotedStyle != 0:
+		for i := 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return start + i + 1, true
+			}
+		}
+	case n.Style&yaml.SingleQuotedStyle != 0:
+		for i := 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+			return start + i + 1, true
+		}
+	default:
+		if bytes.HasPrefix(line, []byte(n.Value)) {
+			return start + len(n.Value), true
+		}
+	}
+	return 0, false
+}
+
+// lineStart returns the offset of the line n starts, provided only
+// indentation precedes it.
+func (p *patcher) lineStart(n *yaml.Node) (int, bool) {
+	if n.Line < 1 || n.Line > len(p.lines) {
+		return 0, false
+	}
+	start := p.lines[n.Line-1]
+	if strings.TrimLeft(string(p.src[start:start+n.Column-1]), " ") != "" {
+		return 0, false
+	}
+	return start, true
+}
+
+// dashIndent returns the indentation of the dash introducing a block
+// sequence item.
+func (p *patcher) dashIndent(item *yaml.Node) (int, bool) {
+	if item.Line < 1 || item.Line > len(p.lines) {
+		return 0, false
+	}
+	start := p.lines[item.Line-1]
+	before := string(p.src[start : start+item.Column-1])
+	trimmed := strings.TrimLeft(before, " ")
+	if !strings.HasPrefix(trimmed, "-") || strings.TrimLeft(trimmed[1:], " ") != "" {
+		return 0, false
+	}
+	return len(before) - len(trimmed), true
+}
+
+// blockEnd returns the offset just past the last line of the entry that
+// starts on line (1-based) at indent: the lines indented deeper, and with
+// seqLevel, block sequence items level with it, as compose files often
+// write them. Blank lines and comments not indented deeper belong to the
+// entry if it goes on after them, and otherwise to what follows.
+func (p *patcher) blockEnd(line, indent int, seqLevel bool) int {
+	end := p.lineEnd(line - 1)
+	for i := line; i < len(p.lines); i++ {
+		text := p.line(i)
+		trimmed := strings.TrimLeft(text, " ")
+		depth := len(text) - len(trimmed)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, "#") && depth <= indent:
+			continue
+		case depth > indent:
+		case depth == indent && seqLevel && (trimmed == "-" || strings.HasPrefix(trimmed, "- ")):
+		default:
+			return end
+		}
+		end = p.lineEnd(i)
+	}
+	return end
+}
+
+// isBlockMapping reports whether n is a mapping in block style.
+func isBlockMapping(n *yaml.Node) bool {
+	return n.Kind == yaml.MappingNode && n.Style&yaml.FlowStyle == 0
+}
+
+// isBlockSequence reports whether n is a sequence in block style.
+func isBlockSequence(n *yaml.Node) bool {
+	return n.Kind == yaml.SequenceNode && n.Style&yaml.FlowStyle == 0
+}
+
+// line returns line i (0-based) without its newline.
+func (p *patcher) line(i int) string {
+	end := len(p.src)
+	if i+1 < len(p.lines) {
+		end = p.lines[i+1] - 1
+	}
+	return string(p.src[p.lines[i]:end])
+}
+
+// lineEnd returns the offset just past line i (0-based), including its
+// newline.
+func (p *patcher) lineEnd(i int) int {
+	if i+1 < len(p.lines) {
+		return p.lines[i+1]
+	}
+	return len(p.src)
+}
+
+// apply returns src with the edits made, or false if any overlap.
+func (p *patcher) apply() ([]byte, bool) {
+	// Text inserted where a removal starts goes before it.
+	sort.SliceStable(p.edits, func(i, j int) bool {
+		a, b := p.edits[i], p.edits[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.end < b.end
+	})
+	var out bytes.Buffer
+	pos := 0
+	for _, e := range p.edits {
+		if e.start < pos {
+			return nil, false
+		}
+		out.Write(p.src[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(p.src[pos:])
+	return out.Bytes(), true
+}
+
+// sameNodes reports whether two trees have the same content.
+func sameNodes(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || a.Tag != b.Tag || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// cloneNode returns a deep copy of n.
+func cloneNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	if n.Content != nil {
+		c.Content = make([]*yaml.Node, len(n.Content))
+		for i, child := range n.Content {
+			c.Content[i] = cloneNode(child)
+		}
+	}
+	return &c
+}
@@ -0,0 +1,265 @@
+package start
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTransformCompose_PreservesFormatting(t *testing.T) {
+	input := `# Development stack
+services:
+    web:
+        image: node:20   # keep in sync with .nvmrc
+        command: npm run dev -- --host 127.0.0.1
+        ports:
+        - "3000:3000"   # app
+        - 9229:9229     # debugger
+
+        environment:
+            NODE_ENV: 'development'
+
+    # the database
+    db:
+        image: postgres:16
+        ports: ["5432:5432"]
+`
+	want := `# Development stack
+services:
+    web:
+        image: node:20   # keep in sync with .nvmrc
+        command: npm run dev -- --host 0.0.0.0
+
+        environment:
+            NODE_ENV: 'development'
+            ATC_PUBLIC_URL: https://web.localhost
+
+    # the database
+    db:
+        image: postgres:16
+        healthcheck:
+            test: [CMD-SHELL, pg_isready]
+            interval: 10s
+            timeout: 3s
+            retries: 3
+            start_period: 60s
+`
+	tr := Transform{
+		PublicURLs:   map[string]string{"web": "https://web.localhost"},
+		FixBind:      true,
+		Healthchecks: map[string]string{"db": "pg_isready"},
+	}
+	out, err := transformCompose([]byte(input), nil, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("transformCompose() =\n%s\nwant\n%s", out, want)
+	}
+	assertSameYAML(t, string(out), marshaled(t, input, tr))
+}
+
+func TestTransformCompose_PreservesFormattingCA(t *testing.T) {
+	input := `services:
+  web:
+    image: node:20
+    volumes:
+    - ./src:/app   # live reload
+    environment:
+    - NODE_ENV=development
+    - DEBUG=*
+`
+	tr := Transform{CA: &CAMount{Root: "/ca/root.crt", Bundle: "/ca/bundle.pem"}}
+	out, err := transformCompose([]byte(input), nil, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `services:
+  web:
+    image: node:20
+    volumes:
+    - ./src:/app   # live reload
+    - /ca/bundle.pem:/etc/caddy-atc/ca-bundle.pem:ro
+    - /ca/root.crt:/etc/caddy-atc/root-ca.crt:ro
+    environment:
+    - NODE_ENV=development
+    - DEBUG=*
+    - SSL_CERT_FILE=/etc/caddy-atc/ca-bundle.pem
+`
+	if got := string(out); len(got) < len(want) || got[:len(want)] != want {
+		t.Errorf("transformCompose() =\n%s\nwant it to start with\n%s", out, want)
+	}
+	assertSameYAML(t, string(out), marshaled(t, input, tr))
+}
+
+func TestPatchCompose_Fallback(t *testing.T) {
+	// A flow-style service can't be patched in place; the result is
+	// marshaled instead but means the same.
+	input := "services:\n  web: {image: nginx, ports: [\"80:80\"]}\n"
+	out, err := transformCompose([]byte(input), nil, Transform{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameYAML(t, string(out), "services:\n  web:\n    image: nginx\n")
+}
+
+func TestPatchCompose_NoTrailingNewline(t *testing.T) {
+	input := "services:\n  web:\n    image: nginx\n    ports:\n      - \"80:80\""
+	out, err := transformCompose([]byte(input), nil, Transform{PublicURLs: map[string]string{"web": "https://web.localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "services:\n  web:\n    image: nginx\n    environment:\n      ATC_PUBLIC_URL: https://web.localhost\n"
+	if string(out) != want {
+		t.Errorf("transformCompose() = %q, want %q", out, want)
+	}
+}
+
+func TestPatchCompose_CommentInRemovedBlock(t *testing.T) {
+	// Comments inside the ports list, even at column 0, go with it; the one
+	// after it belongs to the next service.
+	input := "services:\n  web:\n    image: nginx\n    ports:\n# host port\n      - \"80:80\"\n  # TLS\n      - \"443:443\"\n# the database\n  db:\n    image: postgres\n"
+	want := "services:\n  web:\n    image: nginx\n# the database\n  db:\n    image: postgres\n"
+	out, err := transformCompose([]byte(input), nil, Transform{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("transformCompose() = %q, want %q", out, want)
+	}
+}
+
+func TestPatchCompose_EmptiedService(t *testing.T) {
+	// An override file's service with only ports stays a mapping: compose
+	// rejects a service that is null.
+	input := "services:\n  web:\n    ports:\n      - \"80:80\"\n  api:\n    ports: [\"3000:3000\"]\n"
+	want := "services:\n  web: {}\n  api: {}\n"
+	out, err := transformCompose([]byte(input), nil, Transform{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("transformCompose() = %q, want %q", out, want)
+	}
+}
+
+// marshaled returns input transformed by t and marshaled from the nodes,
+// as stripped files were written before they were patched.
+func marshaled(t *testing.T, input string, tr Transform) string {
+	t.Helper()
+	d, err := parseCompose([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.transform(nil, tr); err != nil {
+		t.Fatal(err)
+	}
+	out, err := yaml.Marshal(&d.node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// assertSameYAML fails unless got and want decode to the same value.
+func assertSameYAML(t *testing.T, got, want string) {
+	t.Helper()
+	var g, w any
+	if err := yaml.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("output is not valid YAML: %v\n%s", err, got)
+	}
+	if err := yaml.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("output means\n%v\nwant\n%v\noutput:\n%s", g, w, got)
+	}
+}
+
+func TestTransformCompose_SameMeaning(t *testing.T) {
+	tr := Transform{
+		PublicURLs:   map[string]string{"web": "https://web.localhost", "api": "https://api.localhost"},
+		CA:           &CAMount{Root: "/ca/root.crt", Bundle: "/ca/bundle.pem"},
+		FixBind:      true,
+		Healthchecks: map[string]string{"web": "true", "api": "true"},
+	}
+	for name, input := range map[string]string{
+		"anchors": `x-common: &common
+  restart: unless-stopped
+services:
+  web:
+    <<: *common
+    image: nginx
+    ports: &ports
+      - "80:80"
+  api:
+    <<: *common
+    image: node
+    expose: ["3000"]
+`,
+		"block scalars": `services:
+  web:
+    image: nginx
+    command: >
+      sh -c "npm install &&
+      npm run dev -- --host 127.0.0.1"
+    ports:
+      - target: 80
+        published: 8080
+        protocol: tcp
+    environment:
+      SCRIPT: |
+        echo one
+        echo two
+`,
+		"quoted and nested": `services:
+  "web":
+    image: "nginx:1.27"
+    environment: {HOST: "127.0.0.1"}
+    volumes:
+      - type: bind
+        source: ./src
+        target: /app
+    ports:
+      - '127.0.0.1:8080:80'
+  api:
+    image: node
+    command: ["npm", "run", "dev", "--", "--host", "127.0.0.1"]
+    environment:
+      - "HOST=localhost"
+      - PORT=3000
+`,
+		"no services": "volumes:\n  data: {}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := transformCompose([]byte(input), []string{"db"}, tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameYAML(t, string(out), marshaled(t, input, tr))
+		})
+	}
+}
+
+func TestTransformCompose_PreservesFlowStyle(t *testing.T) {
+	input := `services:
+  api:
+    image: node
+    command: ["npm", "run", "dev", "--", "--host", "127.0.0.1"]
+    environment: {HOST: '127.0.0.1', PORT: "3000"}
+`
+	want := `services:
+  api:
+    image: node
+    command: ["npm", "run", "dev", "--", "--host", "0.0.0.0"]
+    environment: {HOST: '0.0.0.0', PORT: "3000"}
+`
+	out, err := transformCompose([]byte(input), nil, Transform{FixBind: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("transformCompose() =\n%s\nwant\n%s", out, want)
+	}
+}
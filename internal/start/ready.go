@@ -0,0 +1,186 @@
+package start
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/watcher"
+)
+
+// readyPoll is how often the watcher's routes are checked while waiting.
+const readyPoll = 500 * time.Millisecond
+
+// unprobedGrace is how long a routed service may go without a health
+// state before it is taken to be one the watcher doesn't probe, such as a
+// gRPC server without a health path.
+const unprobedGrace = 15 * time.Second
+
+// spinnerFrames animate the wait on a terminal.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// readiness is what a project's services wait for, by service name.
+type readiness struct {
+	pending map[string]string // not ready yet, and why
+	failed  map[string]string // quarantined, so they won't get ready
+}
+
+// checkReady sorts the services of composeProject that need a route into
+// ready, pending and failed ones. A service is ready once one of its routes
+// is served and its upstream healthy, or was never probed within
+// unprobedGrace of firstRouted, which records when each service was first
+// seen routed.
+func checkReady(composeProject string, services []string, served []watcher.ServedRoute, health watcher.HealthStates, firstRouted map[string]time.Time, now time.Time) readiness {
+	r := readiness{pending: make(map[string]string), failed: make(map[string]string)}
+	for _, svc := range services {
+		status, ready := "not routed yet", false
+		for _, route := range served {
+			if route.Project != composeProject || route.Service != svc {
+				continue
+			}
+			if route.Quarantine != "" {
+				status = "quarantined: " + route.Quarantine
+				continue
+			}
+			if _, ok := firstRouted[svc]; !ok {
+				firstRouted[svc] = now
+			}
+			h, probed := health[route.Container+":"+route.Port]
+			switch {
+			case probed && h.Status == watcher.HealthHealthy,
+				!probed && now.Sub(firstRouted[svc]) >= unprobedGrace:
+				ready = true
+			case probed:
+				status = h.Status
+				if h.Detail != "" {
+					status += ": " + h.Detail
+				}
+			default:
+				status = "waiting for a health check"
+			}
+		}
+		switch {
+		case ready:
+		case strings.HasPrefix(status, "quarantined"):
+			r.failed[svc] = status
+		default:
+			r.pending[svc] = status
+		}
+	}
+	return r
+}
+
+// runningServices returns the services of composeProject with a running
+// container.
+func runningServices(ctx context.Context, composeProject string) (map[string]bool, error) {
+	cli, err := gateway.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	containers, err := gateway.ListContainers(ctx, cli, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", "com.docker.compose.project="+composeProject)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	running := make(map[string]bool)
+	for _, c := range containers {
+		if svc := c.Labels["com.docker.compose.service"]; svc != "" {
+			running[svc] = true
+		}
+	}
+	return running, nil
+}
+
+// waitReady waits up to timeout for the running watcher to serve every
+// running routed service of proj with a healthy upstream, showing a
+// spinner on a terminal. Services still pending at the deadline, or
+// quarantined, are listed; the wait is only ever a convenience, so it
+// returns no error.
+func waitReady(ctx context.Context, proj *config.ProjectConfig, timeout time.Duration) {
+	if _, err := watcher.WatcherRoutes(ctx); err != nil {
+		fmt.Println("Watcher not running; not waiting for routes.")
+		return
+	}
+	running, err := runningServices(ctx, proj.ComposeProject)
+	if err != nil {
+		fmt.Printf("Warning: not waiting for routes: %v\n", err)
+		return
+	}
+	var services []string
+	for svc := range proj.Services {
+		if running[svc] {
+			services = append(services, svc)
+		}
+	}
+	sort.Strings(services)
+	if len(services) == 0 {
+		return
+	}
+
+	tty := supportsHyperlinks(os.Stdout)
+	if !tty {
+		fmt.Printf("Waiting up to %s for routes...\n", timeout)
+	}
+	start := time.Now()
+	deadline := start.Add(timeout)
+	firstRouted := make(map[string]time.Time)
+	var r readiness
+	for frame := 0; ; frame++ {
+		served, err := watcher.WatcherRoutes(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Warning: stopped waiting for routes: %v\n", err)
+			return
+		}
+		r = checkReady(proj.ComposeProject, services, served, watcher.LoadHealth(), firstRouted, time.Now())
+		if len(r.pending) == 0 || ctx.Err() != nil || time.Now().After(deadline) {
+			break
+		}
+		if tty {
+			fmt.Printf("\r\x1b[K%s Waiting for %s (%s)", spinnerFrames[frame%len(spinnerFrames)],
+				strings.Join(sortedKeys(r.pending), ", "), time.Since(start).Round(time.Second))
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(readyPoll):
+		}
+	}
+	if tty {
+		fmt.Print("\r\x1b[K")
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		fmt.Println("Stopped waiting for routes.")
+	case len(r.pending) == 0 && len(r.failed) == 0:
+		fmt.Printf("Routes ready after %s.\n", time.Since(start).Round(time.Second))
+	case len(r.pending) > 0:
+		fmt.Printf("Warning: routes not ready after %s:\n", timeout)
+	}
+	for _, svc := range sortedKeys(r.pending) {
+		fmt.Printf("  %s: %s\n", svc, r.pending[svc])
+	}
+	for _, svc := range sortedKeys(r.failed) {
+		fmt.Printf("Warning: %s is %s\n", svc, r.failed[svc])
+	}
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,45 @@
+package start
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/watcher"
+)
+
+func TestCheckReady(t *testing.T) {
+	served := []watcher.ServedRoute{
+		{Hostname: "myapp.localhost", Container: "myapp-web-1", Port: "3000", Project: "myapp", Service: "web"},
+		{Hostname: "api.myapp.localhost", Container: "myapp-api-1", Port: "8080", Project: "myapp", Service: "api"},
+		{Hostname: "grpc.myapp.localhost", Container: "myapp-grpc-1", Port: "50051", Project: "myapp", Service: "grpc"},
+		{Hostname: "bad.myapp.localhost", Container: "myapp-bad-1", Port: "80", Project: "myapp", Service: "bad", Quarantine: "invalid option"},
+		{Hostname: "other.localhost", Container: "other-admin-1", Port: "80", Project: "other", Service: "admin"},
+	}
+	health := watcher.HealthStates{
+		"myapp-web-1:3000": {Status: watcher.HealthHealthy},
+		"myapp-api-1:8080": {Status: watcher.HealthStarting, Detail: "connection refused"},
+	}
+	services := []string{"admin", "api", "bad", "grpc", "web"}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	firstRouted := make(map[string]time.Time)
+
+	r := checkReady("myapp", services, served, health, firstRouted, now)
+	wantPending := map[string]string{
+		"admin": "not routed yet",
+		"api":   "starting: connection refused",
+		"grpc":  "waiting for a health check",
+	}
+	if !reflect.DeepEqual(r.pending, wantPending) {
+		t.Errorf("pending = %v, want %v", r.pending, wantPending)
+	}
+	if want := map[string]string{"bad": "quarantined: invalid option"}; !reflect.DeepEqual(r.failed, want) {
+		t.Errorf("failed = %v, want %v", r.failed, want)
+	}
+
+	// An upstream the watcher never probes is ready after a grace period.
+	r = checkReady("myapp", services, served, health, firstRouted, now.Add(unprobedGrace))
+	if _, ok := r.pending["grpc"]; ok {
+		t.Errorf("grpc still pending after %s: %v", unprobedGrace, r.pending)
+	}
+}
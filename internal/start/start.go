@@ -8,10 +8,15 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
 
 	"github.com/g-brodiei/caddy-atc/internal/adopt"
 	"github.com/g-brodiei/caddy-atc/internal/config"
 	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/lint"
+	"github.com/g-brodiei/caddy-atc/internal/watcher"
 )
 
 // Options configures the start command.
@@ -21,6 +26,37 @@ type Options struct {
 	Command     []string // User command to run (nil = docker compose up -d)
 	ComposeFile string   // Explicit compose file path (empty = auto-detect or use saved config)
 	Regenerate  bool     // Force regeneration of stripped compose files
+	FixBind     bool     // Rewrite loopback listen addresses to 0.0.0.0 in stripped files
+	Healthcheck bool     // Add healthchecks to routed services lacking one in stripped files
+	Profiles    []string // Compose profiles to enable (nil = COMPOSE_PROFILES)
+	Validate    bool     // Check the stripped files with `docker compose config` before running
+
+	// WaitTimeout bounds how long `docker compose up -d` is followed by a
+	// wait for the project's routes to be served and healthy; 0 skips it.
+	WaitTimeout time.Duration
+}
+
+// warnLoopbackBinds warns about services that listen on loopback inside
+// their container, where the gateway can't reach them. With fixBind, the
+// ones in the compose file are rewritten in the stripped files instead, so
+// only Dockerfile findings are left to warn about.
+func warnLoopbackBinds(dir, composeFile string, fixBind bool) {
+	findings, err := lint.LoopbackBinds(dir, composeFile)
+	if err != nil {
+		return // the compose file's problems are reported when it's stripped
+	}
+	composePath, _ := adopt.ResolveComposeFile(dir, composeFile)
+	fixable := false
+	for _, f := range findings {
+		inCompose := filepath.Join(dir, f.File) == composePath
+		fixable = fixable || inCompose
+		if !fixBind || !inCompose {
+			fmt.Printf("Warning: %s\n", f)
+		}
+	}
+	if fixable && !fixBind {
+		fmt.Println("Run with --fix-bind to listen on 0.0.0.0 in the stripped compose file instead.")
+	}
 }
 
 // Run executes the start workflow: auto-adopt, ensure gateway, strip ports, exec command.
@@ -30,49 +66,60 @@ func Run(ctx context.Context, opts Options) error {
 		return fmt.Errorf("resolving directory: %w", err)
 	}
 
-	// 1. Auto-adopt if not already adopted
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
 
+	_, httpsPort := cfg.HTTPPorts()
+
+	// 1. Resolve compose files: flag > saved config > auto-detect.
 	projectName := filepath.Base(absDir)
-	if _, ok := cfg.Projects[projectName]; !ok {
-		fmt.Printf("Auto-adopting %s (%s.localhost)...\n", projectName, projectName)
-		if _, err := adopt.Adopt(absDir, "", opts.ComposeFile, false); err != nil {
+	proj := cfg.Projects[projectName]
+	composeFile := opts.ComposeFile
+	if composeFile == "" && proj != nil {
+		composeFile = proj.ComposeFile
+	}
+	composeFiles, err := DetectComposeFiles(absDir, composeFile)
+	if err != nil {
+		return err
+	}
+	profiles := ComposeProfiles(opts.Profiles)
+
+	// 2. Auto-adopt if not already adopted, from the services that will
+	// actually run.
+	if proj == nil {
+		fmt.Printf("Auto-adopting %s...\n", projectName)
+		result, err := adopt.AdoptComposeFiles(absDir, opts.ComposeFile, composeFiles, profiles)
+		if err != nil {
 			return fmt.Errorf("auto-adopt failed: %w", err)
 		}
+		fmt.Printf("Adopted %s at %s\n", projectName, result.Hostname)
+		// Re-load config to get the just-adopted project.
+		if adopted, err := config.Load(); err == nil {
+			proj = adopted.Projects[projectName]
+		}
 	}
 
-	// 2. Ensure gateway is running
+	// 3. Ensure gateway is running. A lazy gateway is started by the
+	// watcher once the project's containers are routed.
+	lazy, _, _ := cfg.LazyGateway()
 	running, err := gateway.IsRunning(ctx)
 	if err != nil {
 		return fmt.Errorf("checking gateway: %w", err)
 	}
-	if !running {
+	if lazy && !running {
+		if err := gateway.Prepare(ctx); err != nil {
+			return fmt.Errorf("preparing gateway: %w", err)
+		}
+	} else if !running {
 		fmt.Println("Starting caddy-atc gateway...")
 		if err := gateway.Up(ctx); err != nil {
 			return fmt.Errorf("starting gateway: %w", err)
 		}
 	}
 
-	// 3. Resolve compose file: flag > saved config > auto-detect
-	composeFile := opts.ComposeFile
-	if composeFile == "" {
-		// Re-load config to get potentially just-saved ComposeFile
-		cfg, err = config.Load()
-		if err == nil {
-			if proj, ok := cfg.Projects[projectName]; ok {
-				composeFile = proj.ComposeFile
-			}
-		}
-	}
-
-	// 4. Detect compose files
-	composeFiles, err := DetectComposeFiles(absDir, composeFile)
-	if err != nil {
-		return err
-	}
+	warnLoopbackBinds(absDir, composeFile, opts.FixBind)
 
 	// Check which stripped files already exist (for logging)
 	existedBefore := make(map[string]bool)
@@ -86,11 +133,40 @@ func Run(ctx context.Context, opts Options) error {
 		}
 	}
 
-	// 5. Generate stripped files
-	strippedFiles, err := GenerateStrippedFiles(composeFiles, opts.KeepPorts, opts.Regenerate)
+	var ca *CAMount
+	if proj != nil && proj.InjectCA {
+		files, err := gateway.WriteCAFiles(ctx)
+		if err != nil {
+			return fmt.Errorf("preparing CA files to inject: %w (run 'caddy-atc trust' once the gateway is up)", err)
+		}
+		ca = &CAMount{Root: files.Root, Bundle: files.Bundle}
+	}
+
+	transform := Transform{PublicURLs: publicURLs(proj, httpsPort), CA: ca, FixBind: opts.FixBind}
+	docs := make(map[string]*composeDoc)
+	if opts.Healthcheck {
+		// The files are analyzed and stripped from one parse.
+		if transform.Healthchecks, err = healthchecks(absDir, composeFiles, docs, profiles); err != nil {
+			return fmt.Errorf("detecting services to healthcheck: %w", err)
+		}
+	}
+
+	// 4. Generate stripped files
+	strippedFiles, err := generateStrippedFiles(composeFiles, docs, opts.KeepPorts, transform, opts.Regenerate)
 	if err != nil {
 		return err
 	}
+	if ca != nil && len(strippedFiles) > 0 && existedBefore[strippedFiles[0]] {
+		if data, err := os.ReadFile(strippedFiles[0]); err == nil && !strings.Contains(string(data), containerCADir) {
+			fmt.Printf("Note: %s predates CA injection; run with --regenerate to inject the root CA\n", filepath.Base(strippedFiles[0]))
+		}
+	}
+	if opts.FixBind && len(strippedFiles) > 0 && existedBefore[strippedFiles[0]] {
+		fmt.Printf("Note: using existing %s; run with --regenerate to rewrite loopback listen addresses\n", filepath.Base(strippedFiles[0]))
+	}
+	if opts.Healthcheck && len(strippedFiles) > 0 && existedBefore[strippedFiles[0]] {
+		fmt.Printf("Note: using existing %s; run with --regenerate to add healthchecks\n", filepath.Base(strippedFiles[0]))
+	}
 
 	for _, sf := range strippedFiles {
 		base := filepath.Base(sf)
@@ -103,23 +179,70 @@ func Run(ctx context.Context, opts Options) error {
 		}
 	}
 
-	// 6. Build environment with COMPOSE_FILE pointing to stripped files
+	// 5. Build environment with COMPOSE_FILE pointing to stripped files,
+	// and COMPOSE_PROFILES to the profiles given to start.
 	composeFileEnv := BuildComposeFileEnv(strippedFiles)
 	env := config.FilterEnv("COMPOSE_FILE")
+	if len(opts.Profiles) > 0 {
+		env = config.FilterEnv("COMPOSE_FILE", "COMPOSE_PROFILES")
+		env = append(env, "COMPOSE_PROFILES="+strings.Join(profiles, ","))
+	}
 	env = append(env, "COMPOSE_FILE="+composeFileEnv)
 
-	// 7. Execute command
+	if opts.Validate {
+		if err := validateStripped(ctx, absDir, env, strippedFiles); err != nil {
+			return err
+		}
+		fmt.Println("Validated stripped files with docker compose config")
+	}
+
+	// 6. Execute command
 	if len(opts.Command) == 0 {
-		return runDefault(ctx, absDir, env)
+		return runDefault(ctx, absDir, env, proj, httpsPort, opts.WaitTimeout)
 	}
 
 	return execUserCommand(absDir, env, opts.Command)
 }
 
-// runDefault runs `docker compose up -d` and returns.
-func runDefault(ctx context.Context, dir string, env []string) error {
+// publicURLs maps each routed service of proj to its https origin on a
+// gateway serving HTTPS on httpsPort. Wildcard hostnames have no single
+// origin and are left out.
+func publicURLs(proj *config.ProjectConfig, httpsPort int) map[string]string {
+	if proj == nil {
+		return nil
+	}
+	urls := make(map[string]string, len(proj.Services))
+	for svc, host := range proj.Services {
+		if strings.HasPrefix(host, "*.") {
+			continue
+		}
+		urls[svc] = config.SiteURL(host, httpsPort)
+	}
+	return urls
+}
+
+// runDefault runs `docker compose up -d` and returns. Routing is paused for
+// the duration so the watcher applies one consolidated reload instead of one
+// per container. On success, it waits up to wait for the project's routes
+// to be ready, then lists the project's URLs.
+func runDefault(ctx context.Context, dir string, env []string, proj *config.ProjectConfig, httpsPort int, wait time.Duration) error {
 	fmt.Println("Running: docker compose up -d")
 
+	// Leave an existing (user-requested) pause alone.
+	paused := false
+	if watcher.CurrentPause() == nil {
+		if err := watcher.Pause("caddy-atc start "+filepath.Base(dir), os.Getpid()); err != nil {
+			fmt.Printf("Warning: could not pause routing: %v\n", err)
+		} else {
+			paused = true
+			defer func() {
+				if paused {
+					watcher.Resume()
+				}
+			}()
+		}
+	}
+
 	cmd := exec.CommandContext(ctx, "docker", "compose", "up", "-d")
 	cmd.Dir = dir
 	cmd.Env = env
@@ -130,7 +253,21 @@ func runDefault(ctx context.Context, dir string, env []string) error {
 		return fmt.Errorf("docker compose up: %w", err)
 	}
 
-	fmt.Println("\nContainers started. The caddy-atc watcher will set up routes automatically.")
+	// The routes are only applied once routing resumes.
+	if paused {
+		watcher.Resume()
+		paused = false
+	}
+	if proj != nil && wait > 0 && watcher.CurrentPause() == nil {
+		waitReady(ctx, proj, wait)
+	}
+
+	if proj != nil {
+		writeBanner(os.Stdout, filepath.Base(dir), proj, httpsPort, supportsHyperlinks(os.Stdout))
+		fmt.Println()
+	} else {
+		fmt.Println("\nContainers started. The caddy-atc watcher will set up routes automatically.")
+	}
 	fmt.Println("Tip: Add .caddy-atc-compose*.yml to your .gitignore")
 	return nil
 }
@@ -183,13 +320,17 @@ func Stop(ctx context.Context, dir string) error {
 		return cmd.Run()
 	}
 
-	env := config.FilterEnv("COMPOSE_FILE")
-	env = append(env, "COMPOSE_FILE="+strippedPath)
-
-	overridePath := filepath.Join(absDir, strippedPrefix+".override.yml")
-	if _, err := os.Stat(overridePath); err == nil {
-		env[len(env)-1] = "COMPOSE_FILE=" + strippedPath + ":" + overridePath
+	// The overrides follow the base in order, as `start` named them.
+	files := []string{strippedPath}
+	for i := 1; ; i++ {
+		overridePath := filepath.Join(absDir, strippedFilename(i, i+1))
+		if _, err := os.Stat(overridePath); err != nil {
+			break
+		}
+		files = append(files, overridePath)
 	}
+	env := config.FilterEnv("COMPOSE_FILE")
+	env = append(env, "COMPOSE_FILE="+BuildComposeFileEnv(files))
 
 	fmt.Println("Running: docker compose down")
 	cmd := exec.CommandContext(ctx, "docker", "compose", "down")
@@ -204,3 +345,25 @@ func Stop(ctx context.Context, dir string) error {
 
 	return nil
 }
+
+// RunningProjects returns the compose projects that have running
+// containers.
+func RunningProjects(ctx context.Context) (map[string]bool, error) {
+	cli, err := gateway.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	containers, err := gateway.ListContainers(ctx, cli, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	running := make(map[string]bool)
+	for _, c := range containers {
+		if project := c.Labels["com.docker.compose.project"]; project != "" {
+			running[project] = true
+		}
+	}
+	return running, nil
+}
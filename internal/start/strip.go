@@ -2,32 +2,99 @@ package start
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/lint"
 	"gopkg.in/yaml.v3"
 )
 
+// PublicURLEnv is the environment variable injected into routed services
+// with the https origin the gateway serves them at.
+const PublicURLEnv = "ATC_PUBLIC_URL"
+
+// Paths the gateway's CA files are mounted at inside project containers.
+const (
+	containerCADir    = "/etc/caddy-atc/"
+	containerCARoot   = containerCADir + "root-ca.crt"
+	containerCABundle = containerCADir + "ca-bundle.pem"
+)
+
+// CAMount is the host's copy of the gateway's root CA, and a system bundle
+// including it, to mount into project containers.
+type CAMount struct {
+	Root   string
+	Bundle string
+}
+
+// Transform is what stripped compose files add to the originals besides
+// removing host ports.
+type Transform struct {
+	// PublicURLs maps services to the URL set as PublicURLEnv, unless the
+	// service already defines it.
+	PublicURLs map[string]string
+	// CA, if set, is mounted read-only into every service, with the
+	// standard CA variables pointing at it.
+	CA *CAMount
+	// FixBind rewrites loopback listen addresses in services' commands and
+	// environment to 0.0.0.0, so the gateway can reach them.
+	FixBind bool
+	// Healthchecks maps services to the command of a healthcheck
+	// synthesized for them, unless they define one.
+	Healthchecks map[string]string
+}
+
 // StripPorts parses a docker-compose YAML document and removes all `ports:`
 // entries from services. If keepPorts is non-empty, services whose names match
 // entries in keepPorts retain their ports. All other YAML content (variables,
 // anchors, comments, structure) is preserved via the yaml.v3 Node API.
 func StripPorts(data []byte, keepPorts []string) ([]byte, error) {
+	return transformCompose(data, keepPorts, Transform{})
+}
+
+// transformCompose strips ports like StripPorts and additionally applies t.
+func transformCompose(data []byte, keepPorts []string, t Transform) ([]byte, error) {
+	d, err := parseCompose(data)
+	if err != nil {
+		return nil, err
+	}
+	return d.transform(keepPorts, t)
+}
+
+// composeDoc is a compose file parsed once per run, so the service
+// analysis for healthchecks and the stripping share the parsed nodes.
+type composeDoc struct {
+	data []byte
+	node yaml.Node
+}
+
+// parseCompose parses a compose file's content.
+func parseCompose(data []byte) (*composeDoc, error) {
 	const maxComposeSize = 1 << 20 // 1 MB
 	if len(data) > maxComposeSize {
 		return nil, fmt.Errorf("compose file too large (%d bytes, max %d)", len(data), maxComposeSize)
 	}
 
-	var doc yaml.Node
-	if err := yaml.Unmarshal(data, &doc); err != nil {
+	d := &composeDoc{data: data}
+	if err := yaml.Unmarshal(data, &d.node); err != nil {
 		return nil, fmt.Errorf("parsing YAML: %w", err)
 	}
+	return d, nil
+}
 
+// transform strips ports and applies t, editing the parsed nodes in place,
+// so it must come after any analysis of them. The result keeps the
+// original's formatting and comments wherever the edits allow.
+func (d *composeDoc) transform(keepPorts []string, t Transform) ([]byte, error) {
+	doc := &d.node
 	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
-		return data, nil
+		return d.data, nil
 	}
+	before := cloneNode(doc)
 
 	root := doc.Content[0]
 	if root.Kind != yaml.MappingNode {
-		return data, nil
+		return d.data, nil
 	}
 
 	keepSet := make(map[string]bool, len(keepPorts))
@@ -47,19 +114,32 @@ func StripPorts(data []byte, keepPorts []string) ([]byte, error) {
 			svcName := valNode.Content[j].Value
 			svcNode := valNode.Content[j+1]
 
-			if keepSet[svcName] {
-				continue
-			}
-
 			if svcNode.Kind != yaml.MappingNode {
 				continue
 			}
 
-			stripPortsFromService(svcNode)
+			if url, ok := t.PublicURLs[svcName]; ok {
+				setDefaultEnv(svcNode, PublicURLEnv, url)
+			}
+			if t.CA != nil {
+				injectCA(svcNode, t.CA)
+			}
+			if t.FixBind {
+				lint.FixLoopbackBinds(svcNode)
+			}
+			if command, ok := t.Healthchecks[svcName]; ok {
+				addHealthcheck(svcNode, command)
+			}
+			if !keepSet[svcName] {
+				stripPortsFromService(svcNode)
+			}
 		}
 	}
 
-	out, err := yaml.Marshal(&doc)
+	if out, ok := patchCompose(d.data, before, doc); ok {
+		return out, nil
+	}
+	out, err := yaml.Marshal(doc)
 	if err != nil {
 		return nil, fmt.Errorf("marshaling YAML: %w", err)
 	}
@@ -76,3 +156,67 @@ func stripPortsFromService(svc *yaml.Node) {
 	}
 	svc.Content = filtered
 }
+
+// setDefaultEnv adds key=value to a service's environment unless the key is
+// already present. Both the mapping and the list form of `environment:` are
+// supported; a missing `environment:` is created in mapping form.
+func setDefaultEnv(svc *yaml.Node, key, value string) {
+	for i := 0; i < len(svc.Content)-1; i += 2 {
+		if svc.Content[i].Value != "environment" {
+			continue
+		}
+		env := svc.Content[i+1]
+		switch env.Kind {
+		case yaml.MappingNode:
+			for j := 0; j < len(env.Content)-1; j += 2 {
+				if env.Content[j].Value == key {
+					return
+				}
+			}
+			env.Content = append(env.Content, scalarNode(key), scalarNode(value))
+		case yaml.SequenceNode:
+			for _, item := range env.Content {
+				if item.Value == key || strings.HasPrefix(item.Value, key+"=") {
+					return
+				}
+			}
+			env.Content = append(env.Content, scalarNode(key+"="+value))
+		}
+		return
+	}
+	env := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	env.Content = append(env.Content, scalarNode(key), scalarNode(value))
+	svc.Content = append(svc.Content, scalarNode("environment"), env)
+}
+
+// injectCA mounts ca into a service and points the CA variables at it,
+// leaving variables the service already sets alone.
+func injectCA(svc *yaml.Node, ca *CAMount) {
+	addVolume(svc, ca.Bundle+":"+containerCABundle+":ro")
+	addVolume(svc, ca.Root+":"+containerCARoot+":ro")
+	for _, key := range gateway.BundleEnv {
+		setDefaultEnv(svc, key, containerCABundle)
+	}
+	setDefaultEnv(svc, gateway.RootEnv, containerCARoot)
+}
+
+// addVolume appends a short-syntax volume to a service, creating its
+// `volumes:` list if missing.
+func addVolume(svc *yaml.Node, volume string) {
+	for i := 0; i < len(svc.Content)-1; i += 2 {
+		if svc.Content[i].Value != "volumes" {
+			continue
+		}
+		if vols := svc.Content[i+1]; vols.Kind == yaml.SequenceNode {
+			vols.Content = append(vols.Content, scalarNode(volume))
+		}
+		return
+	}
+	vols := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	vols.Content = append(vols.Content, scalarNode(volume))
+	svc.Content = append(svc.Content, scalarNode("volumes"), vols)
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
@@ -3,6 +3,8 @@ package start
 import (
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestStripPorts_Basic(t *testing.T) {
@@ -232,3 +234,131 @@ volumes:
 		t.Error("expected volumes preserved")
 	}
 }
+
+func TestTransformCompose_InjectsPublicURL(t *testing.T) {
+	input := `services:
+  web:
+    image: nginx
+  api:
+    image: api
+    environment:
+      - DEBUG=1
+  worker:
+    image: worker
+    environment:
+      QUEUE: default
+  admin:
+    image: admin
+    environment:
+      ATC_PUBLIC_URL: https://custom.test
+`
+	urls := map[string]string{
+		"web":    "https://myapp.localhost",
+		"api":    "https://api.myapp.localhost",
+		"worker": "https://worker.myapp.localhost",
+		"admin":  "https://admin.myapp.localhost",
+	}
+	got, err := transformCompose([]byte(input), nil, Transform{PublicURLs: urls})
+	if err != nil {
+		t.Fatalf("transformCompose() error = %v", err)
+	}
+	output := string(got)
+
+	for _, want := range []string{
+		"ATC_PUBLIC_URL: https://myapp.localhost",
+		"- ATC_PUBLIC_URL=https://api.myapp.localhost",
+		"ATC_PUBLIC_URL: https://worker.myapp.localhost",
+		"ATC_PUBLIC_URL: https://custom.test",
+		"QUEUE: default",
+		"- DEBUG=1",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "admin.myapp.localhost") {
+		t.Errorf("existing ATC_PUBLIC_URL should not be overridden, got:\n%s", output)
+	}
+}
+
+func TestTransformCompose_InjectCA(t *testing.T) {
+	input := `services:
+  web:
+    image: web
+    volumes:
+      - ./src:/app
+  api:
+    image: api
+    environment:
+      - SSL_CERT_FILE=/custom.pem
+`
+	ca := &CAMount{Root: "/home/u/.caddy-atc/root.crt", Bundle: "/home/u/.caddy-atc/ca-bundle.pem"}
+	got, err := transformCompose([]byte(input), nil, Transform{CA: ca})
+	if err != nil {
+		t.Fatalf("transformCompose() error = %v", err)
+	}
+
+	var parsed struct {
+		Services map[string]struct {
+			Volumes     []string `yaml:"volumes"`
+			Environment any      `yaml:"environment"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("parsing output: %v", err)
+	}
+
+	wantVols := []string{
+		"/home/u/.caddy-atc/ca-bundle.pem:/etc/caddy-atc/ca-bundle.pem:ro",
+		"/home/u/.caddy-atc/root.crt:/etc/caddy-atc/root-ca.crt:ro",
+	}
+	web := parsed.Services["web"]
+	if len(web.Volumes) != 3 || web.Volumes[0] != "./src:/app" || web.Volumes[1] != wantVols[0] || web.Volumes[2] != wantVols[1] {
+		t.Errorf("web volumes = %v", web.Volumes)
+	}
+	if api := parsed.Services["api"]; len(api.Volumes) != 2 {
+		t.Errorf("api volumes = %v, want the two CA mounts", api.Volumes)
+	}
+
+	output := string(got)
+	for _, want := range []string{
+		"SSL_CERT_FILE: /etc/caddy-atc/ca-bundle.pem",
+		"NODE_EXTRA_CA_CERTS: /etc/caddy-atc/root-ca.crt",
+		"- SSL_CERT_FILE=/custom.pem",
+		"- REQUESTS_CA_BUNDLE=/etc/caddy-atc/ca-bundle.pem",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+	if strings.Count(output, "SSL_CERT_FILE") != 2 {
+		t.Errorf("existing SSL_CERT_FILE should not be overridden, got:\n%s", output)
+	}
+}
+
+func TestTransformCompose_FixBind(t *testing.T) {
+	input := `services:
+  web:
+    image: node
+    command: npm run dev -- --host 127.0.0.1
+    ports:
+      - "5173:5173"
+  api:
+    image: api
+    environment:
+      HOST: localhost
+`
+	got, err := transformCompose([]byte(input), nil, Transform{FixBind: true})
+	if err != nil {
+		t.Fatalf("transformCompose() error = %v", err)
+	}
+	output := string(got)
+	for _, want := range []string{"command: npm run dev -- --host 0.0.0.0", "HOST: 0.0.0.0"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "5173:5173") {
+		t.Errorf("ports should still be stripped, got:\n%s", output)
+	}
+}
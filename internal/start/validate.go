@@ -0,0 +1,41 @@
+package start
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// validateTimeout bounds `docker compose config`, which only reads files.
+const validateTimeout = 30 * time.Second
+
+// validateStripped runs `docker compose config --quiet` in dir with env,
+// so compose loads the stripped files as the started command will, and
+// returns compose's error if it rejects them. The files are left in place
+// for inspection.
+func validateStripped(ctx context.Context, dir string, env, stripped []string) error {
+	ctx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "docker", "compose", "config", "--quiet")
+	cmd.Dir = dir
+	cmd.Env = env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		names := make([]string, len(stripped))
+		for i, f := range stripped {
+			names[i] = filepath.Base(f)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("docker compose rejected the stripped compose files (kept for inspection: %s): %s", strings.Join(names, ", "), msg)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package start
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeDocker puts a docker script running body first in PATH.
+func fakeDocker(t *testing.T, body string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as docker")
+	}
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestValidateStripped(t *testing.T) {
+	dir := t.TempDir()
+	stripped := []string{filepath.Join(dir, ".caddy-atc-compose.yml"), filepath.Join(dir, ".caddy-atc-compose.override.yml")}
+
+	fakeDocker(t, `[ "$*" = "compose config --quiet" ] && [ -n "$COMPOSE_FILE" ] || exit 2`)
+	env := append(os.Environ(), "COMPOSE_FILE="+strings.Join(stripped, ":"))
+	if err := validateStripped(context.Background(), dir, env, stripped); err != nil {
+		t.Errorf("validateStripped() error = %v", err)
+	}
+
+	fakeDocker(t, `echo "validating $COMPOSE_FILE: services.web.healthcheck.retries must be a number" >&2; exit 15`)
+	err := validateStripped(context.Background(), dir, env, stripped)
+	if err == nil {
+		t.Fatal("validateStripped() succeeded, want compose's error")
+	}
+	for _, want := range []string{"kept for inspection: .caddy-atc-compose.yml, .caddy-atc-compose.override.yml", "services.web.healthcheck.retries must be a number"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validateStripped() error = %q, want it to contain %q", err, want)
+		}
+	}
+}
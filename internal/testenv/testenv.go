@@ -0,0 +1,18 @@
+// Package testenv holds setup shared by the tests of caddy-atc's packages.
+package testenv
+
+import (
+	"os"
+	"testing"
+)
+
+// Main runs a package's tests with the directory variables dirEnv
+// (config.DirEnv) unset, so the caller's CADDY_ATC_HOME and XDG directories
+// can't redirect tests away from the temporary HOME they set. Call it from
+// the package's TestMain.
+func Main(m *testing.M, dirEnv []string) {
+	for _, key := range dirEnv {
+		os.Unsetenv(key)
+	}
+	os.Exit(m.Run())
+}
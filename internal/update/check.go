@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
 )
 
 const (
@@ -34,11 +36,10 @@ func (c *cacheEntry) isStale() bool {
 }
 
 func cachePath() string {
-	home, err := os.UserHomeDir()
-	if err != nil {
+	if _, err := os.UserHomeDir(); err != nil {
 		return ""
 	}
-	return filepath.Join(home, ".caddy-atc", "update-check.json")
+	return filepath.Join(config.StateDir(), "update-check.json")
 }
 
 func loadCache(path string) (*cacheEntry, error) {
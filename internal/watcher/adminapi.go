@@ -0,0 +1,219 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// updateCaddy applies the written Caddyfile to the gateway. A change
+// confined to the routes of the gateway's servers is patched in through
+// Caddy's admin API; any other change, a failed patch, or reload_mode
+// "caddyfile" reloads the whole Caddyfile instead.
+func (w *Watcher) updateCaddy(ctx context.Context) error {
+	if cfg, err := w.config.load(); err == nil {
+		if mode, _ := cfg.ReloadMode(); mode == config.ReloadModeAdmin {
+			patched, err := patchRoutes(ctx, cmp.Or(cfg.AdminAddress(), config.DefaultAdminAddress))
+			switch {
+			case patched:
+				return nil
+			case err != nil && isContainerStoppedErr(err):
+				return err
+			case err != nil:
+				w.logger.Debug("Patching routes through the admin API failed, reloading Caddy", "err", err)
+			}
+		}
+	}
+	return ReloadCaddy(ctx)
+}
+
+// patchRoutes replaces the routes of the servers whose routes differ
+// between the running config and the mounted Caddyfile, through the admin
+// API at admin inside the gateway. It reports false, leaving a reload to
+// the caller, when the config differs outside routes, or not at all: a
+// reload then still picks up the files the config reads.
+func patchRoutes(ctx context.Context, admin string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	next, err := gatewayExec(ctx, nil, "caddy", "adapt", "--config", "/etc/caddy/Caddyfile", "--adapter", "caddyfile")
+	if err != nil {
+		return false, fmt.Errorf("adapting Caddyfile: %w", err)
+	}
+	current, err := adminRequest(ctx, admin, http.MethodGet, "/config/", nil)
+	if err != nil {
+		return false, err
+	}
+	patches, ok := routePatches(current, next)
+	if !ok || len(patches) == 0 {
+		return false, nil
+	}
+	for _, p := range patches {
+		if _, err := adminRequest(ctx, admin, p.method, p.path, p.body); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// routePatch is an admin API request changing one server's routes.
+type routePatch struct {
+	method string
+	path   string
+	body   []byte
+}
+
+// routePatches returns the admin API requests that turn the current JSON
+// config into next, one per server whose routes changed. It reports false
+// when anything but routes differs: servers added or removed, listeners,
+// TLS, logging or other apps.
+func routePatches(current, next []byte) ([]routePatch, bool) {
+	var cur, nxt map[string]any
+	if json.Unmarshal(current, &cur) != nil || json.Unmarshal(next, &nxt) != nil {
+		return nil, false
+	}
+	curServers, nxtServers := httpServers(cur), httpServers(nxt)
+	if curServers == nil || nxtServers == nil || len(curServers) != len(nxtServers) {
+		return nil, false
+	}
+
+	var patches []routePatch
+	for _, name := range slices.Sorted(maps.Keys(nxtServers)) {
+		ns, _ := nxtServers[name].(map[string]any)
+		cs, _ := curServers[name].(map[string]any)
+		if ns == nil || cs == nil {
+			return nil, false
+		}
+		curRoutes, hadRoutes := cs["routes"]
+		nxtRoutes, hasRoutes := ns["routes"]
+		if reflect.DeepEqual(curRoutes, nxtRoutes) {
+			continue
+		}
+		path := "/config/apps/http/servers/" + url.PathEscape(name) + "/routes"
+		switch {
+		case !hasRoutes:
+			patches = append(patches, routePatch{method: http.MethodDelete, path: path})
+			delete(cs, "routes")
+			continue
+		case hadRoutes:
+			// PATCH replaces the list; POST would append to it.
+			patches = append(patches, routePatch{method: http.MethodPatch, path: path})
+		default:
+			patches = append(patches, routePatch{method: http.MethodPost, path: path})
+		}
+		body, err := json.Marshal(nxtRoutes)
+		if err != nil {
+			return nil, false
+		}
+		patches[len(patches)-1].body = body
+		cs["routes"] = nxtRoutes
+	}
+	if !reflect.DeepEqual(cur, nxt) {
+		return nil, false
+	}
+	return patches, true
+}
+
+// httpServers returns apps.http.servers of a JSON config, or nil.
+func httpServers(cfg map[string]any) map[string]any {
+	apps, _ := cfg["apps"].(map[string]any)
+	httpApp, _ := apps["http"].(map[string]any)
+	servers, _ := httpApp["servers"].(map[string]any)
+	return servers
+}
+
+// adminRequest sends a request to the admin API at admin inside the
+// gateway and returns the response body. The API only listens inside the
+// container, so the request is piped through nc there.
+func adminRequest(ctx context.Context, admin, method, path string, body []byte) ([]byte, error) {
+	host, port, err := net.SplitHostPort(admin)
+	if err != nil {
+		return nil, fmt.Errorf("admin address %q: %w", admin, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	req, err := adminHTTPRequest(admin, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	out, err := gatewayExec(ctx, req, "nc", host, port)
+	if err != nil {
+		return nil, fmt.Errorf("admin API: %w", err)
+	}
+	return readAdminResponse(out)
+}
+
+// adminHTTPRequest returns the raw HTTP request for the admin API. Its
+// Host header is the admin address itself, which Caddy always accepts.
+func adminHTTPRequest(admin, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, "http://"+admin+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("admin API: %w", err)
+	}
+	req.Close = true
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		return nil, fmt.Errorf("admin API: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readAdminResponse parses a raw admin API response, returning its body or
+// Caddy's error message.
+func readAdminResponse(raw []byte) ([]byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("admin API: reading response: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("admin API: reading response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		msg := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			msg = apiErr.Error
+		}
+		return nil, fmt.Errorf("admin API: %s: %s", resp.Status, msg)
+	}
+	return body, nil
+}
+
+// gatewayExec runs a command in the gateway container with stdin and
+// returns its output. Docker's own error, such as the container not
+// running, is part of the error.
+func gatewayExec(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"exec", "-i", gateway.ContainerName}, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRoutePatches(t *testing.T) {
+	const base = `{"apps":{"http":{"servers":{
+		"srv0":{"listen":[":443"],"routes":[{"match":[{"host":["app.localhost"]}]}]},
+		"srv1":{"listen":[":80"],"routes":[{"handle":[{"handler":"static_response"}]}]}
+	}},"tls":{"automation":{"policies":[{"issuers":[{"module":"internal"}]}]}}}}`
+
+	tests := []struct {
+		name   string
+		next   string
+		want   []string // method and path of each patch
+		wantOK bool
+	}{
+		{
+			name:   "unchanged",
+			next:   base,
+			wantOK: true,
+		},
+		{
+			name: "route added",
+			next: strings.Replace(base, `[{"match":[{"host":["app.localhost"]}]}]`,
+				`[{"match":[{"host":["app.localhost"]}]},{"match":[{"host":["api.localhost"]}]}]`, 1),
+			want:   []string{"PATCH /config/apps/http/servers/srv0/routes"},
+			wantOK: true,
+		},
+		{
+			name:   "last route removed",
+			next:   strings.Replace(base, `,"routes":[{"match":[{"host":["app.localhost"]}]}]`, ``, 1),
+			want:   []string{"DELETE /config/apps/http/servers/srv0/routes"},
+			wantOK: true,
+		},
+		{
+			name:   "listener changed",
+			next:   strings.Replace(base, `":443"`, `":8443"`, 1),
+			wantOK: false,
+		},
+		{
+			name:   "tls changed",
+			next:   strings.Replace(base, `"internal"`, `"acme"`, 1),
+			wantOK: false,
+		},
+		{
+			name:   "server added",
+			next:   strings.Replace(base, `"srv1":`, `"srv2":{"listen":[":8080"]},"srv1":`, 1),
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches, ok := routePatches([]byte(base), []byte(tt.next))
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			var got []string
+			for _, p := range patches {
+				got = append(got, p.method+" "+p.path)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("patches = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The patch carries the server's new routes.
+	next := strings.Replace(base, `"app.localhost"`, `"web.localhost"`, 1)
+	patches, ok := routePatches([]byte(base), []byte(next))
+	if !ok || len(patches) != 1 {
+		t.Fatalf("patches = %v, ok = %v", patches, ok)
+	}
+	if got := string(patches[0].body); got != `[{"match":[{"host":["web.localhost"]}]}]` {
+		t.Errorf("body = %s", got)
+	}
+}
+
+func TestRoutePatches_NewRoutes(t *testing.T) {
+	current := `{"apps":{"http":{"servers":{"srv0":{"listen":[":443"]}}}}}`
+	next := `{"apps":{"http":{"servers":{"srv0":{"listen":[":443"],"routes":[{"terminal":true}]}}}}}`
+	patches, ok := routePatches([]byte(current), []byte(next))
+	if !ok || len(patches) != 1 || patches[0].method != http.MethodPost {
+		t.Fatalf("patches = %+v, ok = %v; want one POST", patches, ok)
+	}
+}
+
+func TestAdminHTTPRequest(t *testing.T) {
+	req, err := adminHTTPRequest("localhost:2019", http.MethodPatch, "/config/apps/http/servers/srv0/routes", []byte(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(req)
+	for _, want := range []string{
+		"PATCH /config/apps/http/servers/srv0/routes HTTP/1.1\r\n",
+		"Host: localhost:2019\r\n",
+		"Content-Length: 2\r\n",
+		"Content-Type: application/json\r\n",
+		"Connection: close\r\n",
+		"\r\n\r\n[]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("request missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestReadAdminResponse(t *testing.T) {
+	body, err := readAdminResponse([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 9\r\n\r\n{\"a\":1}\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "{\"a\":1}\r\n" {
+		t.Errorf("body = %q", body)
+	}
+
+	_, err = readAdminResponse([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 32\r\n\r\n{\"error\":\"loading config: boom\"}"))
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request: loading config: boom") {
+		t.Errorf("error = %v", err)
+	}
+
+	if _, err := readAdminResponse(nil); err == nil {
+		t.Error("empty response: error = nil")
+	}
+}
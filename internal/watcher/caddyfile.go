@@ -3,10 +3,14 @@ package watcher
 import (
 	"context"
 	"fmt"
+	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,12 +26,79 @@ type Route struct {
 	Port          string
 	Project       string
 	Service       string
+	Protocol      string // config.Protocol*; empty means plain HTTP
+	Scheme        string // from config.UpstreamSchemeLabel; overrides Options.UpstreamScheme
+	LearnedScheme string // learned from a TLS mismatch; overrides Options.UpstreamScheme
+	HealthPath    string // from config.HealthPathLabel; enables active health checks
+	RedirectFrom  string // from config.RedirectFromLabel; overrides Options.RedirectFrom
+	Rewrite       string // from config.RewriteLabel; overrides Options.Rewrite
+	Snippet       string // the project's Caddyfile snippet, embedded in the site block
+	RetryPage     bool   // answer browsers with a self-reloading page instead of a 502
+	MixedContent  string // config.MixedContent* mode of the project
+	Options       config.ServiceOptions
+
+	// ReplicaHostname routes just this container when
+	// Options.ReplicaHostnames is set; empty if it has no replica number.
+	ReplicaHostname string
+
+	// Source is how the route's port was chosen: one of the Source*
+	// constants.
+	Source string
+
+	// Root is the gateway directory served with file_server for a static
+	// file route, which has no container or port.
+	Root string
+	// SPA serves /index.html for paths with no file under Root.
+	SPA bool
+
+	// Quarantine is the validation or Caddy error that got the route
+	// excluded from the Caddyfile; empty for routes that are served.
+	Quarantine string
+}
+
+// upstreamScheme returns the scheme the gateway uses for the route's
+// upstream: the label's, else the one learned from proxy errors, else the
+// option's. "" means http.
+func (r *Route) upstreamScheme() string {
+	switch {
+	case r.Scheme != "":
+		return r.Scheme
+	case r.LearnedScheme != "":
+		return r.LearnedScheme
+	}
+	return r.Options.UpstreamScheme
+}
+
+// redirectHosts returns the hostnames redirecting to the route.
+func (r *Route) redirectHosts() []string {
+	from := r.Options.RedirectFrom
+	if r.RedirectFrom != "" {
+		from = r.RedirectFrom
+	}
+	hosts, _ := config.ParseRedirectFrom(from)
+	return hosts
 }
 
 // ActiveRoutes holds all currently active routes, keyed by container ID.
 type ActiveRoutes struct {
-	mu     sync.RWMutex
-	routes map[string]*Route // keyed by container ID
+	mu       sync.RWMutex
+	routes   map[string]*Route // keyed by container ID
+	logLevel string            // gateway log level; "" for Caddy's default
+	pins     Pins              // hostnames served by a single replica
+	pki      config.PKIConfig  // local CA names and lifetimes
+	lan      []string          // LAN IP and mDNS name; nil outside LAN mode
+	exposed  string            // hostname the LAN addresses proxy to
+
+	// dashboard is the hostname the dashboard is served at, proxied to
+	// dashboardUpstream; either is "" while it isn't served.
+	dashboard         string
+	dashboardUpstream string
+
+	// errorDomains are the domains whose unrouted hostnames get the error
+	// page; nil leaves Caddy's bare errors.
+	errorDomains []string
+
+	maintenance bool // sites answer with a 503 maintenance page
 }
 
 func NewActiveRoutes() *ActiveRoutes {
@@ -63,47 +134,267 @@ func (ar *ActiveRoutes) All() []*Route {
 		routes = append(routes, r)
 	}
 	sort.Slice(routes, func(i, j int) bool {
-		return routes[i].Hostname < routes[j].Hostname
+		if routes[i].Hostname != routes[j].Hostname {
+			return routes[i].Hostname < routes[j].Hostname
+		}
+		return routes[i].ContainerName < routes[j].ContainerName
 	})
 	return routes
 }
 
+// containerIDs maps each container route to the ID of its container.
+func (ar *ActiveRoutes) containerIDs() map[*Route]string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	ids := make(map[*Route]string, len(ar.routes))
+	for id, r := range ar.routes {
+		if !isStaticKey(id) {
+			ids[r] = id
+		}
+	}
+	return ids
+}
+
 func (ar *ActiveRoutes) Len() int {
 	ar.mu.RLock()
 	defer ar.mu.RUnlock()
 	return len(ar.routes)
 }
 
+// SetLogLevel sets the gateway log level rendered into the Caddyfile's
+// global options. Returns true if it changed.
+func (ar *ActiveRoutes) SetLogLevel(level string) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.logLevel == level {
+		return false
+	}
+	ar.logLevel = level
+	return true
+}
+
+// LogLevel returns the gateway log level.
+func (ar *ActiveRoutes) LogLevel() string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.logLevel
+}
+
+// SetPKI sets the local CA settings rendered into the Caddyfile's global
+// options. Returns true if they changed.
+func (ar *ActiveRoutes) SetPKI(pki config.PKIConfig) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.pki == pki {
+		return false
+	}
+	ar.pki = pki
+	return true
+}
+
+// PKI returns the local CA settings.
+func (ar *ActiveRoutes) PKI() config.PKIConfig {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.pki
+}
+
+// SetMaintenance has every site answer with a 503 maintenance page
+// instead of proxying, or proxy again. Returns true if it changed.
+func (ar *ActiveRoutes) SetMaintenance(on bool) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.maintenance == on {
+		return false
+	}
+	ar.maintenance = on
+	return true
+}
+
+// Maintenance reports whether sites answer with the maintenance page.
+func (ar *ActiveRoutes) Maintenance() bool {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.maintenance
+}
+
 // upstream holds a validated container:port pair for a reverse_proxy directive.
 type upstream struct {
 	Container string
 	Port      string
 }
 
+// site holds everything rendered into one hostname's site block.
+type site struct {
+	upstreams  []upstream
+	protocol   string
+	healthPath string
+	opts       config.ServiceOptions
+	root       string // file_server root instead of upstreams
+	spa        bool
+	snippet    string // validated project directives
+	retryPage  bool
+	errorPage  bool   // browsers get the down page when the upstream fails
+	mixed      string // config.MixedContent* mode
+	note       string // comment line naming where the routes come from
+}
+
+// gatewayConfigDir is where config.CaddyfileDir() is mounted in the gateway.
+const gatewayConfigDir = "/etc/caddy"
+
+// siteSpan records the Caddyfile lines (1-based, inclusive) of a site block.
+type siteSpan struct {
+	hostname    string
+	first, last int
+}
+
+// SetLAN sets the LAN IP and mDNS name the gateway serves in LAN mode, or
+// nil to stop serving them. Returns true if they changed.
+func (ar *ActiveRoutes) SetLAN(names []string) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if slices.Equal(ar.lan, names) {
+		return false
+	}
+	ar.lan = names
+	return true
+}
+
+// LAN returns the LAN IP and mDNS name the gateway serves.
+func (ar *ActiveRoutes) LAN() []string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.lan
+}
+
+// SetExposed sets the routed hostname the LAN addresses proxy to, or ""
+// for none. Returns true if it changed.
+func (ar *ActiveRoutes) SetExposed(hostname string) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.exposed == hostname {
+		return false
+	}
+	ar.exposed = hostname
+	return true
+}
+
+// Exposed returns the hostname the LAN addresses proxy to.
+func (ar *ActiveRoutes) Exposed() string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.exposed
+}
+
+// SetDashboard sets the hostname the gateway serves the dashboard at and
+// the host:port it proxies to; "" for either serves none. Returns true if
+// they changed.
+func (ar *ActiveRoutes) SetDashboard(hostname, upstream string) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.dashboard == hostname && ar.dashboardUpstream == upstream {
+		return false
+	}
+	ar.dashboard, ar.dashboardUpstream = hostname, upstream
+	return true
+}
+
+// Dashboard returns the hostname the dashboard is served at and its
+// upstream.
+func (ar *ActiveRoutes) Dashboard() (hostname, upstream string) {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.dashboard, ar.dashboardUpstream
+}
+
+// SetErrorPages sets the domains whose unrouted hostnames get the error
+// page, which also makes browsers get the error page when an upstream is
+// down; nil turns error pages off. Returns true if they changed.
+func (ar *ActiveRoutes) SetErrorPages(domains []string) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if slices.Equal(ar.errorDomains, domains) {
+		return false
+	}
+	ar.errorDomains = domains
+	return true
+}
+
+// ErrorPages returns the domains whose unrouted hostnames get the error
+// page.
+func (ar *ActiveRoutes) ErrorPages() []string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.errorDomains
+}
+
 // GenerateCaddyfile creates the Caddyfile content from active routes.
 // Routes sharing the same hostname are grouped into a single site block
-// with multiple upstreams (Caddy round-robins between them).
+// with multiple upstreams (Caddy round-robins between them). Quarantined
+// routes are left out.
 // All hostnames, container names, and ports are validated before interpolation.
 func GenerateCaddyfile(routes *ActiveRoutes) (string, error) {
-	// Group upstreams by hostname.
-	grouped := make(map[string][]upstream)
+	content, _, err := renderCaddyfile(routes)
+	return content, err
+}
+
+// listenerOptions set the admin API address and ports from the gateway
+// container's environment, which `caddy-atc up` fills from config.yml. A
+// running gateway keeps the values it was started with, so reloads always
+// match its published ports.
+const listenerOptions = `    admin {$CADDY_ATC_ADMIN:localhost:2019}
+    http_port {$CADDY_ATC_HTTP_PORT:80}
+    https_port {$CADDY_ATC_HTTPS_PORT:443}
+`
+
+// renderCaddyfile implements GenerateCaddyfile, also returning where each
+// site block sits so reload errors can be traced back to routes.
+func renderCaddyfile(routes *ActiveRoutes) (string, []siteSpan, error) {
+	// Group upstreams by hostname. Protocol and options come from the
+	// first route.
+	grouped := make(map[string]*site)
+	sources := make(map[string][]*Route)
+	errorDomains := routes.ErrorPages()
+	errorPages := len(errorDomains) > 0
 	for _, r := range routes.All() {
-		if err := config.ValidateHostname(r.Hostname); err != nil {
-			return "", fmt.Errorf("unsafe route skipped: %w", err)
+		if r.Quarantine != "" {
+			continue
 		}
-		if err := config.ValidateContainerName(r.ContainerName); err != nil {
-			return "", fmt.Errorf("unsafe route skipped: %w", err)
+		if err := validateRoute(r); err != nil {
+			return "", nil, err
 		}
-		if err := config.ValidatePort(r.Port); err != nil {
-			return "", fmt.Errorf("unsafe route skipped: %w", err)
+		hosts := []string{r.Hostname}
+		if r.ReplicaHostname != "" && r.Options.ReplicaHostnames {
+			hosts = append(hosts, r.ReplicaHostname)
+		}
+		for _, h := range hosts {
+			sources[h] = append(sources[h], r)
+			s, ok := grouped[h]
+			if !ok {
+				opts := r.Options
+				opts.UpstreamScheme = r.upstreamScheme()
+				if r.RedirectFrom != "" {
+					opts.RedirectFrom = r.RedirectFrom
+				}
+				if r.Rewrite != "" {
+					opts.Rewrite = r.Rewrite
+				}
+				s = &site{protocol: r.Protocol, healthPath: r.HealthPath, opts: opts, root: r.Root, spa: r.SPA, snippet: r.Snippet, retryPage: r.RetryPage, errorPage: errorPages, mixed: r.MixedContent}
+				grouped[h] = s
+			}
+			if r.Root != "" {
+				continue
+			}
+			s.upstreams = append(s.upstreams, upstream{r.ContainerName, r.Port})
 		}
-		grouped[r.Hostname] = append(grouped[r.Hostname], upstream{r.ContainerName, r.Port})
 	}
 
 	// Sort hostnames for deterministic output.
 	hostnames := make([]string, 0, len(grouped))
-	for h := range grouped {
+	ids := routes.containerIDs()
+	for h, s := range grouped {
 		hostnames = append(hostnames, h)
+		s.note = routeNote(sources[h], ids)
 	}
 	sort.Strings(hostnames)
 
@@ -112,38 +403,638 @@ func GenerateCaddyfile(routes *ActiveRoutes) (string, error) {
 	b.WriteString("{\n")
 	b.WriteString("    local_certs\n")
 	b.WriteString("    skip_install_trust\n")
+	b.WriteString(listenerOptions)
+	if level := routes.LogLevel(); level != "" {
+		if err := config.ValidateLogLevel(level); err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(&b, "    log {\n        level %s\n    }\n", strings.ToUpper(level))
+	}
+	pki := routes.PKI()
+	if err := pki.Validate(); err != nil {
+		return "", nil, err
+	}
+	writePKI(&b, pki)
+	lan := routes.LAN()
+	for _, name := range lan {
+		if err := config.ValidateHostname(name); err != nil {
+			return "", nil, fmt.Errorf("LAN address: %w", err)
+		}
+	}
+	if len(lan) > 0 {
+		// Clients connecting by IP send no server name.
+		fmt.Fprintf(&b, "    default_sni %s\n", lan[0])
+	}
+	if errorPages {
+		fmt.Fprintf(&b, "    on_demand_tls {\n        ask http://%s/\n    }\n", unroutedAskAddress)
+	}
 	b.WriteString("}\n")
 
+	var spans []siteSpan
+	maintenance := routes.Maintenance()
 	for _, hostname := range hostnames {
-		upstreams := grouped[hostname]
-		addrs := make([]string, len(upstreams))
-		for i, u := range upstreams {
-			addrs[i] = u.Container + ":" + u.Port
+		// Site blocks start with a blank line and the route's note before
+		// the site address.
+		first := strings.Count(b.String(), "\n") + 3
+		s := grouped[hostname]
+		if maintenance {
+			writeNoted(&b, s.note, func(b *strings.Builder) { writeMaintenanceSite(b, hostname, hostname) })
+			spans = append(spans, siteSpan{hostname, first, strings.Count(b.String(), "\n")})
+			continue
+		}
+		s.upstreams = pinUpstreams(s.upstreams, routes.Pinned(hostname))
+		writeNoted(&b, s.note, func(b *strings.Builder) { writeSite(b, hostname, s) })
+		spans = append(spans, siteSpan{hostname, first, strings.Count(b.String(), "\n")})
+	}
+	// Redirects never take over a hostname that is routed.
+	redirects := make(map[string]string)
+	for _, hostname := range hostnames {
+		from, _ := config.ParseRedirectFrom(grouped[hostname].opts.RedirectFrom)
+		for _, h := range from {
+			if _, routed := grouped[h]; !routed && redirects[h] == "" {
+				redirects[h] = hostname
+			}
+		}
+	}
+	for _, h := range slices.Sorted(maps.Keys(redirects)) {
+		first := strings.Count(b.String(), "\n") + 2
+		writeRedirectSite(&b, h, redirects[h])
+		spans = append(spans, siteSpan{redirects[h], first, strings.Count(b.String(), "\n")})
+	}
+	// Nor does the dashboard.
+	if hostname, upstream := routes.Dashboard(); hostname != "" && upstream != "" {
+		if _, routed := grouped[hostname]; !routed && redirects[hostname] == "" {
+			if err := writeDashboardSite(&b, hostname, upstream); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if len(lan) > 0 {
+		exposed := routes.Exposed()
+		if _, ok := grouped[exposed]; ok && maintenance {
+			writeMaintenanceSite(&b, strings.Join(lan, ", "), exposed)
+		} else if s, ok := grouped[exposed]; ok {
+			writeSiteAt(&b, strings.Join(lan, ", "), exposed, s)
+		} else {
+			writeLANSite(&b, lan, exposed)
+		}
+	}
+	if errorPages {
+		if err := writeUnroutedSites(&b, errorDomains); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return b.String(), spans, nil
+}
+
+// routeNote returns the comment line above the site block serving routes,
+// naming their project, service and containers so the block can be traced
+// back to them.
+func routeNote(routes []*Route, ids map[*Route]string) string {
+	r := routes[0]
+	fields := []string{"# caddy-atc route"}
+	if r.Project == "" {
+		fields = append(fields, "static")
+	} else {
+		fields = append(fields, "project="+noteValue(r.Project), "service="+noteValue(r.Service))
+	}
+	var containers []string
+	for _, r := range routes {
+		if id := ids[r]; id != "" {
+			containers = append(containers, shortID(id))
+		}
+	}
+	switch len(containers) {
+	case 0:
+	case 1:
+		fields = append(fields, "container="+containers[0])
+	default:
+		fields = append(fields, "containers="+strings.Join(containers, ","))
+	}
+	return strings.Join(fields, " ") + "\n"
+}
+
+// noteValue quotes s for a Caddyfile comment if it isn't a plain word, so
+// it can't end the comment line.
+func noteValue(s string) string {
+	if s == "" || strings.ContainsFunc(s, func(r rune) bool { return r <= ' ' || r == '"' || r > '~' }) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// writeNoted writes the site block rendered by write with note between
+// its leading blank line and its address.
+func writeNoted(b *strings.Builder, note string, write func(*strings.Builder)) {
+	var block strings.Builder
+	write(&block)
+	b.WriteString("\n")
+	b.WriteString(note)
+	b.WriteString(strings.TrimPrefix(block.String(), "\n"))
+}
+
+// validateRoute checks every route value that is interpolated into the
+// Caddyfile.
+func validateRoute(r *Route) error {
+	if err := config.ValidateHostname(r.Hostname); err != nil {
+		return fmt.Errorf("unsafe route skipped: %w", err)
+	}
+	if r.Root != "" {
+		sr := config.StaticRoute{Hostname: r.Hostname, Root: r.Root}
+		if err := sr.Validate(); err != nil || !strings.HasPrefix(r.Root, config.FileRootMount+"/") {
+			return fmt.Errorf("unsafe route skipped: invalid root %q", r.Root)
+		}
+		return nil
+	}
+	if err := config.ValidateContainerName(r.ContainerName); err != nil {
+		return fmt.Errorf("unsafe route skipped: %w", err)
+	}
+	if err := config.ValidatePort(r.Port); err != nil {
+		return fmt.Errorf("unsafe route skipped: %w", err)
+	}
+	if err := r.Options.Validate(); err != nil {
+		return fmt.Errorf("invalid options for %s: %w", r.Hostname, err)
+	}
+	for _, src := range []string{r.Options.TLSClientCert, r.Options.TLSClientKey, r.Options.TLSTrustedCA} {
+		if src == "" {
+			continue
+		}
+		if err := checkCertFile(src); err != nil {
+			return fmt.Errorf("upstream TLS for %s: %w", r.Hostname, err)
 		}
+	}
+	if r.ReplicaHostname != "" && r.Options.ReplicaHostnames {
+		if err := config.ValidateHostname(r.ReplicaHostname); err != nil {
+			return fmt.Errorf("unsafe replica route skipped: %w", err)
+		}
+	}
+	if _, err := config.ContainerHealthPath(map[string]string{config.HealthPathLabel: r.HealthPath}); err != nil {
+		return fmt.Errorf("unsafe route skipped: %w", err)
+	}
+	rules := config.ServiceOptions{RedirectFrom: r.RedirectFrom, Rewrite: r.Rewrite}
+	if err := rules.Validate(); err != nil {
+		return fmt.Errorf("invalid rules for %s: %w", r.Hostname, err)
+	}
+	if err := config.ValidateMixedContent(r.MixedContent); err != nil {
+		return fmt.Errorf("unsafe route skipped: %w", err)
+	}
+	if err := config.ValidateSnippet(r.Snippet); err != nil {
+		return fmt.Errorf("unsafe snippet for %s skipped: %w", r.Hostname, err)
+	}
+	return nil
+}
 
-		b.WriteString("\n")
-		b.WriteString(hostname)
-		b.WriteString(" {\n")
-		b.WriteString("    tls internal\n")
-		fmt.Fprintf(&b, "    reverse_proxy %s\n", strings.Join(addrs, " "))
+// writeSite renders one site block. Only validated values and paths built
+// by this package are interpolated.
+func writeSite(b *strings.Builder, hostname string, s *site) {
+	writeSiteAt(b, hostname, hostname, s)
+}
+
+// writeSiteAt renders the site block of hostname under another address.
+func writeSiteAt(b *strings.Builder, address, hostname string, s *site) {
+	prefix := ""
+	if s.opts.UpstreamScheme == "https" {
+		prefix = "https://"
+	}
+	addrs := make([]string, len(s.upstreams))
+	for i, u := range s.upstreams {
+		addrs[i] = prefix + u.Container + ":" + u.Port
+	}
+
+	b.WriteString("\n")
+	b.WriteString(address)
+	b.WriteString(" {\n")
+	b.WriteString("    tls internal\n")
+	writeAccessLog(b, hostname)
+	if s.opts.CallbackInspector != "" {
+		writeCallbackInspector(b, s.opts.CallbackInspector)
+	}
+	// Options were validated with the route.
+	rewrites, _ := config.ParseRewrites(s.opts.Rewrite)
+	writeRewrites(b, rewrites)
+	writeMixedContent(b, s.mixed)
+	writeSnippet(b, s.snippet)
+	if s.root != "" {
+		writeFileServer(b, s.root, s.spa)
 		b.WriteString("}\n")
+		return
+	}
+	if s.retryPage {
+		writeRetryPage(b, hostname)
+	} else if s.errorPage {
+		writeDownPage(b)
 	}
 
-	return b.String(), nil
+	proxy := proxyDirectives(s.protocol, s.opts)
+	if s.healthPath != "" {
+		// Caddy stops sending requests to replicas failing the check.
+		proxy = append(proxy, "health_uri "+s.healthPath, "health_interval "+healthInterval.String())
+	}
+	transport := transportDirectives(hostname, s.protocol, s.opts)
+	if len(proxy) == 0 && len(transport) == 0 {
+		fmt.Fprintf(b, "    reverse_proxy %s\n", strings.Join(addrs, " "))
+		b.WriteString("}\n")
+		return
+	}
+
+	fmt.Fprintf(b, "    reverse_proxy %s {\n", strings.Join(addrs, " "))
+	for _, line := range proxy {
+		fmt.Fprintf(b, "        %s\n", line)
+	}
+	if len(transport) > 0 {
+		b.WriteString("        transport http {\n")
+		for _, line := range transport {
+			fmt.Fprintf(b, "            %s\n", line)
+		}
+		b.WriteString("        }\n")
+	}
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
 }
 
-// WriteCaddyfile writes the Caddyfile to disk atomically (temp file + rename).
-func WriteCaddyfile(routes *ActiveRoutes) error {
-	content, err := GenerateCaddyfile(routes)
+// writeRewrites renders path rewrites, applied before the request is
+// proxied. Prefix rules keep the rest of the path.
+func writeRewrites(b *strings.Builder, rules []config.RewriteRule) {
+	for _, r := range rules {
+		if r.Prefix {
+			fmt.Fprintf(b, "    uri %s* replace %s %s 1\n", r.From, r.From, r.To)
+		} else {
+			fmt.Fprintf(b, "    rewrite %s %s\n", r.From, r.To)
+		}
+	}
+}
+
+// writeMixedContent renders the response headers of a mixed-content mode.
+// Deleting a header is deferred until the upstream has answered.
+func writeMixedContent(b *strings.Builder, mode string) {
+	switch mode {
+	case config.MixedContentUpgrade:
+		// An extra policy with no fetch directives restricts nothing the
+		// app's own policy allows.
+		b.WriteString("    header +Content-Security-Policy upgrade-insecure-requests\n")
+	case config.MixedContentRelax:
+		b.WriteString("    header {\n")
+		b.WriteString("        -Content-Security-Policy\n")
+		b.WriteString("        -Content-Security-Policy-Report-Only\n")
+		b.WriteString("    }\n")
+	}
+}
+
+// writeSnippet embeds a project's validated snippet, indented under the
+// site address.
+func writeSnippet(b *strings.Builder, snippet string) {
+	for _, line := range strings.Split(snippet, "\n") {
+		if line = strings.TrimRight(line, " \t"); line != "" {
+			fmt.Fprintf(b, "    %s\n", line)
+		}
+	}
+}
+
+// retryPageMaxDelay caps the retry page's backoff between reloads.
+const retryPageMaxDelay = 15 * time.Second
+
+// writeRetryPage renders an error handler answering browsers with a 503
+// page that reloads itself, backing off from one second, while the
+// upstream can't be reached. Other clients get the plain error. The page
+// has no braces, which Caddy would take for placeholders, and interpolates
+// only the validated hostname.
+func writeRetryPage(b *strings.Builder, hostname string) {
+	b.WriteString("    handle_errors {\n")
+	b.WriteString("        @atc_retry {\n")
+	b.WriteString("            expression `{err.status_code} in [502, 503, 504]`\n")
+	b.WriteString("            header Accept *text/html*\n")
+	b.WriteString("        }\n")
+	b.WriteString("        header @atc_retry Content-Type \"text/html; charset=utf-8\"\n")
+	b.WriteString("        header @atc_retry Cache-Control no-store\n")
+	b.WriteString("        header @atc_retry Retry-After 1\n")
+	b.WriteString("        respond @atc_retry <<HTML\n")
+	b.WriteString("            <!doctype html>\n")
+	fmt.Fprintf(b, "            <title>%s is restarting</title>\n", hostname)
+	b.WriteString("            <noscript><meta http-equiv=\"refresh\" content=\"5\"></noscript>\n")
+	b.WriteString("            <body style=\"font-family: sans-serif; margin: 3em\">\n")
+	fmt.Fprintf(b, "            <p>%s is not answering, probably because its container is restarting.</p>\n", hostname)
+	b.WriteString("            <p>This page reloads itself until it is back.</p>\n")
+	b.WriteString("            <script>\n")
+	b.WriteString("            var s = sessionStorage, n = Date.now() - (+s.getItem('atcRetryAt') || 0) < 60000 ? (+s.getItem('atcRetryN') || 0) + 1 : 0;\n")
+	b.WriteString("            s.setItem('atcRetryAt', Date.now()); s.setItem('atcRetryN', n);\n")
+	fmt.Fprintf(b, "            setTimeout(() => location.reload(), Math.min(1000 * 2 ** n, %d));\n", retryPageMaxDelay.Milliseconds())
+	b.WriteString("            </script>\n")
+	b.WriteString("            HTML 503\n")
+	b.WriteString("    }\n")
+}
+
+// writeRedirectSite renders a site permanently redirecting address to the
+// same URI on target, on the port the request came in on.
+func writeRedirectSite(b *strings.Builder, address, target string) {
+	fmt.Fprintf(b, "\n%s {\n", address)
+	b.WriteString("    tls internal\n")
+	fmt.Fprintf(b, "    redir https://%s:{http.request.port}{uri} permanent\n", target)
+	b.WriteString("}\n")
+}
+
+// writeFileServer renders the directives serving files from root, a
+// validated path in the gateway.
+func writeFileServer(b *strings.Builder, root string, spa bool) {
+	fmt.Fprintf(b, "    root * %s\n", root)
+	if spa {
+		b.WriteString("    try_files {path} /index.html\n")
+	}
+	b.WriteString("    file_server\n")
+}
+
+// writeLANSite renders the site for the LAN IP and mDNS name while they
+// expose no running route, so devices on the network still get a
+// certificate from the local CA for them.
+func writeLANSite(b *strings.Builder, names []string, exposed string) {
+	fmt.Fprintf(b, "\n%s {\n", strings.Join(names, ", "))
+	b.WriteString("    tls internal\n")
+	if exposed == "" {
+		fmt.Fprintf(b, "    respond \"caddy-atc gateway on %s\" 200\n", names[len(names)-1])
+	} else {
+		fmt.Fprintf(b, "    respond \"caddy-atc: %s is not running\" 502\n", exposed)
+	}
+	b.WriteString("}\n")
+}
+
+// writeDashboardSite renders the site serving the watcher's dashboard,
+// proxied to upstream on the Docker host.
+func writeDashboardSite(b *strings.Builder, hostname, upstream string) error {
+	if err := config.ValidateHostname(hostname); err != nil {
+		return fmt.Errorf("dashboard: %w", err)
+	}
+	host, port, err := net.SplitHostPort(upstream)
+	if err == nil {
+		err = config.ValidateContainerName(host)
+	}
+	if err == nil {
+		err = config.ValidatePort(port)
+	}
+	if err != nil {
+		return fmt.Errorf("dashboard upstream %q: %w", upstream, err)
+	}
+	fmt.Fprintf(b, "\n# caddy-atc dashboard\n%s {\n", hostname)
+	b.WriteString("    tls internal\n")
+	fmt.Fprintf(b, "    reverse_proxy %s\n", net.JoinHostPort(host, port))
+	b.WriteString("}\n")
+	return nil
+}
+
+// writeMaintenanceSite renders the site of hostname at address answering
+// every request with a 503 maintenance page, while routing is paused for
+// maintenance.
+func writeMaintenanceSite(b *strings.Builder, address, hostname string) {
+	fmt.Fprintf(b, "\n%s {\n", address)
+	b.WriteString("    tls internal\n")
+	writeAccessLog(b, hostname)
+	b.WriteString("    header Retry-After 60\n")
+	fmt.Fprintf(b, "    respond \"caddy-atc: %s is down for maintenance\" 503\n", hostname)
+	b.WriteString("}\n")
+}
+
+// writePKI renders the global options for the local CA that `local_certs`
+// issues from. Only validated values are interpolated.
+func writePKI(b *strings.Builder, pki config.PKIConfig) {
+	if pki.CertLifetime != "" {
+		fmt.Fprintf(b, "    cert_lifetime %s\n", pki.CertLifetime)
+	}
+	var lines []string
+	if pki.Name != "" {
+		lines = append(lines, fmt.Sprintf("name %q", pki.Name))
+	}
+	if pki.RootCN != "" {
+		lines = append(lines, fmt.Sprintf("root_cn %q", pki.RootCN))
+	}
+	if pki.IntermediateCN != "" {
+		lines = append(lines, fmt.Sprintf("intermediate_cn %q", pki.IntermediateCN))
+	}
+	if pki.IntermediateLifetime != "" {
+		lines = append(lines, "intermediate_lifetime "+pki.IntermediateLifetime)
+	}
+	if pki.TeamCA != "" {
+		// An imported team CA; the root key is only present in root mode.
+		dir := gatewayConfigDir + "/ca"
+		lines = append(lines, "root {", "    format pem_file", "    cert "+dir+"/root.crt")
+		if pki.TeamCA == config.TeamCARoot {
+			lines = append(lines, "    key "+dir+"/root.key", "}")
+		} else {
+			lines = append(lines, "}", "intermediate {", "    format pem_file",
+				"    cert "+dir+"/intermediate.crt", "    key "+dir+"/intermediate.key", "}")
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	b.WriteString("    pki {\n        ca local {\n")
+	for _, line := range lines {
+		fmt.Fprintf(b, "            %s\n", line)
+	}
+	b.WriteString("        }\n    }\n")
+}
+
+// writeAccessLog renders a JSON access log for the site into its own file
+// in the gateway's data volume, read back by `caddy-atc logs access`.
+func writeAccessLog(b *strings.Builder, hostname string) {
+	b.WriteString("    log {\n")
+	fmt.Fprintf(b, "        output file %s {\n", gateway.AccessLogPath(hostname))
+	b.WriteString("            roll_size 10MiB\n")
+	b.WriteString("            roll_keep 3\n")
+	b.WriteString("        }\n")
+	b.WriteString("        format json\n")
+	b.WriteString("    }\n")
+}
+
+// writeCallbackInspector renders directives that answer requests to path
+// at the gateway with a plain-text echo of the request; the request itself
+// lands in the site's access log. `respond` is ordered before
+// `reverse_proxy`, so the service never sees the callback.
+func writeCallbackInspector(b *strings.Builder, path string) {
+	fmt.Fprintf(b, "    @atc_callback path %s\n", path)
+	b.WriteString("    header @atc_callback Content-Type \"text/plain; charset=utf-8\"\n")
+	b.WriteString("    respond @atc_callback <<BODY\n")
+	b.WriteString("        caddy-atc OAuth callback inspector\n")
+	b.WriteString("\n")
+	b.WriteString("        Method: {method}\n")
+	b.WriteString("        URI:    {uri}\n")
+	b.WriteString("        Query:  {query}\n")
+	b.WriteString("        BODY 200\n")
+}
+
+// proxyDirectives returns reverse_proxy subdirectives for streaming,
+// load balancing, retries, and response header rewrites.
+func proxyDirectives(protocol string, opts config.ServiceOptions) []string {
+	var lines []string
+	if protocol == config.ProtocolWebSocket {
+		// Flush frames immediately, and keep connections open across the
+		// frequent reloads the watcher triggers instead of dropping them.
+		lines = append(lines, "flush_interval -1", "stream_close_delay 5m")
+	}
+	if opts.LBTryDuration != "" {
+		lines = append(lines, "lb_try_duration "+opts.LBTryDuration)
+	}
+	if opts.LBTryInterval != "" {
+		lines = append(lines, "lb_try_interval "+opts.LBTryInterval)
+	}
+	if opts.LBRetries > 0 {
+		lines = append(lines, fmt.Sprintf("lb_retries %d", opts.LBRetries))
+	}
+	if opts.CookieDomain != "" {
+		domain := opts.CookieDomain
+		if domain == config.CookieDomainAuto {
+			domain = "{http.request.host}"
+		}
+		// Applies to every Set-Cookie value; cookies without a Domain
+		// attribute are already host-only and left untouched.
+		lines = append(lines, fmt.Sprintf(`header_down Set-Cookie "(?i);[ ]*domain=[^;]*" "; Domain=%s"`, domain))
+	}
+	return lines
+}
+
+// transportDirectives returns the `transport http` subdirectives for a site.
+// Any tls_* subdirective makes Caddy connect to the upstream over TLS.
+func transportDirectives(hostname, protocol string, opts config.ServiceOptions) []string {
+	var lines []string
+	if protocol == config.ProtocolGRPC {
+		// gRPC needs HTTP/2 to the upstream; plaintext upstreams need h2c.
+		if opts.UpstreamTLS() {
+			lines = append(lines, "versions 2")
+		} else {
+			lines = append(lines, "versions h2c 2")
+		}
+	}
+	if opts.DialTimeout != "" {
+		lines = append(lines, "dial_timeout "+opts.DialTimeout)
+	}
+	if !opts.UpstreamTLS() {
+		return lines
+	}
+	dir := gatewayConfigDir + "/" + upstreamCertDir(hostname)
+
+	if opts.TLSClientCert != "" {
+		lines = append(lines, fmt.Sprintf("tls_client_auth %s/client.crt %s/client.key", dir, dir))
+	}
+	if opts.TLSTrustedCA != "" {
+		lines = append(lines, fmt.Sprintf("tls_trust_pool file %s/ca.crt", dir))
+	} else {
+		// Local upstreams almost always use self-signed certificates.
+		lines = append(lines, "tls_insecure_skip_verify")
+	}
+	return lines
+}
+
+// upstreamCertDir returns the path, relative to the Caddyfile directory,
+// holding upstream TLS material for a hostname.
+func upstreamCertDir(hostname string) string {
+	return "certs/" + strings.ReplaceAll(hostname, "*", "_")
+}
+
+// syncUpstreamCerts copies each route's upstream TLS files into the
+// Caddyfile directory (mounted in the gateway) and removes stale copies.
+func syncUpstreamCerts(routes []*Route) error {
+	base := filepath.Join(config.CaddyfileDir(), "certs")
+	want := make(map[string]bool)
+
+	for _, r := range routes {
+		if r.Quarantine != "" || !r.Options.UpstreamTLS() {
+			continue
+		}
+		rel := upstreamCertDir(r.Hostname)
+		name := filepath.Base(rel)
+		if want[name] {
+			continue
+		}
+		want[name] = true
+
+		dir := filepath.Join(config.CaddyfileDir(), rel)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("creating %s: %w", dir, err)
+		}
+		files := map[string]string{
+			"client.crt": r.Options.TLSClientCert,
+			"client.key": r.Options.TLSClientKey,
+			"ca.crt":     r.Options.TLSTrustedCA,
+		}
+		for dst, src := range files {
+			if src == "" {
+				continue
+			}
+			if err := copyCertFile(src, filepath.Join(dir, dst)); err != nil {
+				return fmt.Errorf("upstream TLS for %s: %w", r.Hostname, err)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(base)
 	if err != nil {
+		return nil // nothing to clean up
+	}
+	for _, e := range entries {
+		if !want[e.Name()] {
+			os.RemoveAll(filepath.Join(base, e.Name()))
+		}
+	}
+	return nil
+}
+
+// maxCertFileSize bounds certificate and key files copied for the gateway.
+const maxCertFileSize = 1 << 20 // 1 MB
+
+// checkCertFile checks that src is a readable regular file small enough
+// to copy for the gateway.
+func checkCertFile(src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", src, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	if info.Size() > maxCertFileSize {
+		return fmt.Errorf("%s too large (%d bytes, max %d)", src, info.Size(), maxCertFileSize)
+	}
+	return nil
+}
+
+func copyCertFile(src, dst string) error {
+	if err := checkCertFile(src); err != nil {
 		return err
 	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", src, err)
+	}
+	return atomicWriteFile(dst, data, 0600)
+}
+
+// WriteCaddyfile writes the Caddyfile to disk atomically (temp file + rename),
+// stamped with version and the current time. Manual sections of the
+// Caddyfile it replaces are kept; if that was otherwise edited by hand, it
+// is backed up first and the backup's path returned.
+func WriteCaddyfile(routes *ActiveRoutes, version string) (backup string, err error) {
+	content, err := GenerateCaddyfile(routes)
+	if err != nil {
+		return "", err
+	}
 
 	if err := config.EnsureHomeDir(); err != nil {
-		return err
+		return "", err
 	}
 
-	return atomicWriteFile(config.CaddyfilePath(), []byte(content), 0600)
+	if err := syncUpstreamCerts(routes.All()); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	manual, backup, err := preserveCaddyfileEdits(config.CaddyfilePath(), now)
+	if err != nil {
+		return "", err
+	}
+	return backup, atomicWriteFile(config.CaddyfilePath(), []byte(stampCaddyfile(content, manual, version, now)), 0600)
 }
 
 // atomicWriteFile writes to a temp file then renames to prevent partial writes.
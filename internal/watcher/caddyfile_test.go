@@ -1,9 +1,13 @@
 package watcher
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
 )
 
 func TestGenerateCaddyfile_EmptyRoutes(t *testing.T) {
@@ -18,6 +22,9 @@ func TestGenerateCaddyfile_EmptyRoutes(t *testing.T) {
 	if !strings.Contains(got, "skip_install_trust") {
 		t.Error("expected skip_install_trust in output")
 	}
+	if !strings.Contains(got, listenerOptions) {
+		t.Error("expected admin address and ports from the environment in output")
+	}
 	// Should not contain any reverse_proxy directives
 	if strings.Contains(got, "reverse_proxy") {
 		t.Error("expected no reverse_proxy in empty routes output")
@@ -322,3 +329,800 @@ func TestActiveRoutes_Concurrency(t *testing.T) {
 		t.Errorf("Len() = %d, want 0 after concurrent removes", ar.Len())
 	}
 }
+
+func TestActiveRoutes_SyncStatic(t *testing.T) {
+	ar := NewActiveRoutes()
+	ar.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-1", Port: "80"})
+
+	static := []*config.StaticRoute{
+		{Hostname: "vite.localhost", Upstream: "host.docker.internal:5173"},
+	}
+	if !ar.SyncStatic(static) {
+		t.Error("SyncStatic() = false on first sync, want true")
+	}
+	if ar.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", ar.Len())
+	}
+	if ar.SyncStatic(static) {
+		t.Error("SyncStatic() = true for unchanged set, want false")
+	}
+
+	got, err := GenerateCaddyfile(ar)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if !strings.Contains(got, "reverse_proxy host.docker.internal:5173") {
+		t.Errorf("expected static upstream in output:\n%s", got)
+	}
+
+	if !ar.SyncStatic(nil) {
+		t.Error("SyncStatic(nil) = false after removal, want true")
+	}
+	if ar.Len() != 1 {
+		t.Errorf("Len() = %d after removing static routes, want 1 (container route kept)", ar.Len())
+	}
+}
+
+func TestGenerateCaddyfile_FileRoute(t *testing.T) {
+	ar := NewActiveRoutes()
+	ar.SyncStatic([]*config.StaticRoute{
+		{Hostname: "docs.myapp.localhost", Root: "/home/dev/myapp/dist", SPA: true},
+	})
+	got, err := GenerateCaddyfile(ar)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"docs.myapp.localhost {",
+		"root * /srv/caddy-atc/docs.myapp.localhost",
+		"try_files {path} /index.html",
+		"file_server",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "reverse_proxy") || strings.Contains(got, "/home/dev") {
+		t.Errorf("file route proxies or exposes the host path:\n%s", got)
+	}
+
+	ar.Add("c1", &Route{Hostname: "evil.localhost", Root: "/etc"})
+	if _, err := GenerateCaddyfile(ar); err == nil {
+		t.Error("GenerateCaddyfile() accepted a root outside the mount directory")
+	}
+}
+
+func TestGenerateCaddyfile_RedirectsAndRewrites(t *testing.T) {
+	ar := NewActiveRoutes()
+	ar.Add("c1", &Route{
+		Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "3000",
+		Options: config.ServiceOptions{
+			RedirectFrom: "www.myapp.localhost, api.localhost",
+			Rewrite:      "/old -> /new, /api/* -> /v1/*",
+		},
+	})
+	ar.Add("c2", &Route{Hostname: "api.localhost", ContainerName: "api-1", Port: "8080"})
+	got, err := GenerateCaddyfile(ar)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"rewrite /old /new",
+		"uri /api/* replace /api/ /v1/ 1",
+		"www.myapp.localhost {",
+		"redir https://myapp.localhost:{http.request.port}{uri} permanent",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "\napi.localhost {") != 1 {
+		t.Errorf("a routed hostname was turned into a redirect:\n%s", got)
+	}
+}
+
+func TestGenerateCaddyfile_RuleLabelsOverrideOptions(t *testing.T) {
+	ar := NewActiveRoutes()
+	ar.Add("c1", &Route{
+		Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "3000",
+		Rewrite: "/a -> /b",
+		Options: config.ServiceOptions{Rewrite: "/old -> /new"},
+	})
+	got, err := GenerateCaddyfile(ar)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if !strings.Contains(got, "rewrite /a /b") || strings.Contains(got, "/old") {
+		t.Errorf("expected the label's rewrite only:\n%s", got)
+	}
+
+	ar.Add("c2", &Route{Hostname: "bad.localhost", ContainerName: "bad-1", Port: "80", Rewrite: "/a -> /b {"})
+	if _, err := GenerateCaddyfile(ar); err == nil {
+		t.Error("GenerateCaddyfile() accepted an unsafe rewrite")
+	}
+}
+
+// certFile writes a placeholder PEM file and returns its path.
+func certFile(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("PEM"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGenerateCaddyfile_UpstreamClientCert(t *testing.T) {
+	cert, key := certFile(t, "client.crt"), certFile(t, "client.key")
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{
+		Hostname:      "billing.localhost",
+		ContainerName: "billing-api-1",
+		Port:          "8443",
+		Options: config.ServiceOptions{
+			TLSClientCert: cert,
+			TLSClientKey:  key,
+		},
+	})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"reverse_proxy billing-api-1:8443 {",
+		"transport http {",
+		"tls_client_auth /etc/caddy/certs/billing.localhost/client.crt /etc/caddy/certs/billing.localhost/client.key",
+		"tls_insecure_skip_verify",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+	// Host paths must never reach the Caddyfile
+	if strings.Contains(got, filepath.Dir(cert)) {
+		t.Errorf("host path leaked into Caddyfile:\n%s", got)
+	}
+}
+
+func TestGenerateCaddyfile_UpstreamTrustedCA(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{
+		Hostname:      "billing.localhost",
+		ContainerName: "billing-api-1",
+		Port:          "8443",
+		Options:       config.ServiceOptions{TLSTrustedCA: certFile(t, "ca.pem")},
+	})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if !strings.Contains(got, "tls_trust_pool file /etc/caddy/certs/billing.localhost/ca.crt") {
+		t.Errorf("expected trust pool directive:\n%s", got)
+	}
+	if strings.Contains(got, "tls_insecure_skip_verify") {
+		t.Error("unexpected tls_insecure_skip_verify with trusted CA set")
+	}
+}
+
+func TestGenerateCaddyfile_RejectsCertWithoutKey(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{
+		Hostname:      "billing.localhost",
+		ContainerName: "billing-api-1",
+		Port:          "8443",
+		Options:       config.ServiceOptions{TLSClientCert: "/certs/client.crt"},
+	})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for client cert without key")
+	}
+}
+
+func TestSyncUpstreamCerts(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+
+	certPath := filepath.Join(tmpDir, "client.crt")
+	keyPath := filepath.Join(tmpDir, "client.key")
+	os.WriteFile(certPath, []byte("CERT"), 0600)
+	os.WriteFile(keyPath, []byte("KEY"), 0600)
+
+	// A stale directory from a removed route
+	stale := filepath.Join(config.CaddyfileDir(), "certs", "old.localhost")
+	os.MkdirAll(stale, 0700)
+
+	routes := []*Route{{
+		Hostname: "*.billing.localhost",
+		Options:  config.ServiceOptions{TLSClientCert: certPath, TLSClientKey: keyPath},
+	}}
+	if err := syncUpstreamCerts(routes); err != nil {
+		t.Fatalf("syncUpstreamCerts() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.CaddyfileDir(), "certs", "_.billing.localhost", "client.key"))
+	if err != nil || string(data) != "KEY" {
+		t.Errorf("copied key = %q, %v", data, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale cert directory was not removed")
+	}
+
+	routes[0].Options.TLSClientKey = filepath.Join(tmpDir, "missing.key")
+	if err := syncUpstreamCerts(routes); err == nil {
+		t.Error("expected error for missing key file")
+	}
+}
+
+func TestGenerateCaddyfile_RetryPolicy(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{
+		Hostname:      "app.localhost",
+		ContainerName: "app-web-1",
+		Port:          "3000",
+		Options: config.ServiceOptions{
+			LBTryDuration: "30s",
+			LBTryInterval: "500ms",
+			LBRetries:     5,
+			DialTimeout:   "2s",
+		},
+	})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	want := `    reverse_proxy app-web-1:3000 {
+        lb_try_duration 30s
+        lb_try_interval 500ms
+        lb_retries 5
+        transport http {
+            dial_timeout 2s
+        }
+    }
+`
+	if !strings.Contains(got, want) {
+		t.Errorf("expected retry policy block:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestGenerateCaddyfile_RejectsInvalidDuration(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{
+		Hostname:      "app.localhost",
+		ContainerName: "app-web-1",
+		Port:          "3000",
+		Options:       config.ServiceOptions{LBTryDuration: "30s\n}\nevil {"},
+	})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for injected duration")
+	}
+}
+
+func TestGenerateCaddyfile_CallbackInspector(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{
+		Hostname:      "app.localhost",
+		ContainerName: "app-web-1",
+		Port:          "3000",
+		Options:       config.ServiceOptions{CallbackInspector: "/auth/callback"},
+	})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"    @atc_callback path /auth/callback\n",
+		"    respond @atc_callback <<BODY\n",
+		"        URI:    {uri}\n",
+		"        BODY 200\n",
+		"    reverse_proxy app-web-1:3000\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in Caddyfile:\n%s", want, got)
+		}
+	}
+}
+
+func TestSyncOptions(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "3000", Project: "myapp", Service: "web"})
+	routes.Add("c2", &Route{Hostname: "other.localhost", ContainerName: "other-web-1", Port: "3000", Project: "other", Service: "web"})
+
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"myapp": {
+			ComposeProject: "myapp",
+			Options:        map[string]*config.ServiceOptions{"web": {LBTryDuration: "10s"}},
+		},
+	}}
+
+	if !routes.SyncOptions(cfg) {
+		t.Fatal("expected SyncOptions to report a change")
+	}
+	r, _ := routes.Get("c1")
+	if r.Options.LBTryDuration != "10s" {
+		t.Errorf("c1 LBTryDuration = %q, want 10s", r.Options.LBTryDuration)
+	}
+	if routes.SyncOptions(cfg) {
+		t.Error("expected no change on second sync")
+	}
+}
+
+func TestGenerateCaddyfile_CookieDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"auto", `header_down Set-Cookie "(?i);[ ]*domain=[^;]*" "; Domain={http.request.host}"`},
+		{"myapp.localhost", `header_down Set-Cookie "(?i);[ ]*domain=[^;]*" "; Domain=myapp.localhost"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			routes := NewActiveRoutes()
+			routes.Add("c1", &Route{
+				Hostname:      "api.myapp.localhost",
+				ContainerName: "myapp-api-1",
+				Port:          "8000",
+				Options:       config.ServiceOptions{CookieDomain: tt.domain},
+			})
+			got, err := GenerateCaddyfile(routes)
+			if err != nil {
+				t.Fatalf("GenerateCaddyfile() error = %v", err)
+			}
+			if !strings.Contains(got, "    reverse_proxy myapp-api-1:8000 {\n        "+tt.want+"\n    }\n") {
+				t.Errorf("expected %q in reverse_proxy block:\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGenerateCaddyfile_AccessLog(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "3000"})
+	routes.Add("c2", &Route{Hostname: "*.app.localhost", ContainerName: "app-web-2", Port: "3000"})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"        output file /data/access/app.localhost.log {\n",
+		"        output file /data/access/_.app.localhost.log {\n",
+		"        format json\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in Caddyfile:\n%s", want, got)
+		}
+	}
+}
+
+func TestGenerateCaddyfile_Protocol(t *testing.T) {
+	ca := certFile(t, "ca.crt")
+	tests := []struct {
+		name     string
+		protocol string
+		opts     config.ServiceOptions
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "grpc uses h2c",
+			protocol: config.ProtocolGRPC,
+			want:     []string{"        transport http {\n            versions h2c 2\n"},
+			notWant:  []string{"flush_interval"},
+		},
+		{
+			name:     "grpc over upstream TLS",
+			protocol: config.ProtocolGRPC,
+			opts:     config.ServiceOptions{DialTimeout: "5s", TLSTrustedCA: ca},
+			want:     []string{"            versions 2\n", "            dial_timeout 5s\n"},
+			notWant:  []string{"h2c"},
+		},
+		{
+			name:     "websocket streams",
+			protocol: config.ProtocolWebSocket,
+			want:     []string{"        flush_interval -1\n", "        stream_close_delay 5m\n"},
+			notWant:  []string{"transport http", "versions"},
+		},
+		{
+			name:     "plain http",
+			protocol: config.ProtocolHTTP,
+			want:     []string{"    reverse_proxy app-web-1:3000\n"},
+			notWant:  []string{"versions", "flush_interval"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := NewActiveRoutes()
+			routes.Add("c1", &Route{
+				Hostname:      "app.localhost",
+				ContainerName: "app-web-1",
+				Port:          "3000",
+				Protocol:      tt.protocol,
+				Options:       tt.opts,
+			})
+			got, err := GenerateCaddyfile(routes)
+			if err != nil {
+				t.Fatalf("GenerateCaddyfile() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected %q in Caddyfile:\n%s", want, got)
+				}
+			}
+			for _, unwanted := range tt.notWant {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("unexpected %q in Caddyfile:\n%s", unwanted, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateCaddyfile_HTTPSUpstream(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   Route
+		wantTLS bool
+	}{
+		{"option", Route{Options: config.ServiceOptions{UpstreamScheme: "https"}}, true},
+		{"label", Route{Scheme: "https"}, true},
+		{"label overrides option", Route{Scheme: "http", Options: config.ServiceOptions{UpstreamScheme: "https"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.route
+			r.Hostname, r.ContainerName, r.Port = "auth.localhost", "auth-keycloak-1", "8443"
+			routes := NewActiveRoutes()
+			routes.Add("c1", &r)
+			got, err := GenerateCaddyfile(routes)
+			if err != nil {
+				t.Fatalf("GenerateCaddyfile() error = %v", err)
+			}
+			hasTLS := strings.Contains(got, "    reverse_proxy https://auth-keycloak-1:8443 {\n") &&
+				strings.Contains(got, "            tls_insecure_skip_verify\n")
+			if hasTLS != tt.wantTLS {
+				t.Errorf("HTTPS upstream = %v, want %v:\n%s", hasTLS, tt.wantTLS, got)
+			}
+		})
+	}
+}
+
+func TestGenerateCaddyfile_LogLevel(t *testing.T) {
+	routes := NewActiveRoutes()
+	if !routes.SetLogLevel("debug") {
+		t.Fatal("expected SetLogLevel to report a change")
+	}
+	if routes.SetLogLevel("debug") {
+		t.Error("expected no change on second SetLogLevel")
+	}
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if want := "    https_port {$CADDY_ATC_HTTPS_PORT:443}\n    log {\n        level DEBUG\n    }\n}\n"; !strings.Contains(got, want) {
+		t.Errorf("expected global log level in Caddyfile:\n%s", got)
+	}
+
+	routes.SetLogLevel(`debug" }`)
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for invalid log level")
+	}
+}
+
+func TestGenerateCaddyfile_ReplicaHostnames(t *testing.T) {
+	opts := config.ServiceOptions{ReplicaHostnames: true}
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "80", Options: opts, ReplicaHostname: "web-1.myapp.localhost"})
+	routes.Add("c2", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-2", Port: "80", Options: opts, ReplicaHostname: "web-2.myapp.localhost"})
+
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"myapp.localhost {\n",
+		"reverse_proxy myapp-web-1:80 myapp-web-2:80",
+		"web-1.myapp.localhost {\n",
+		"web-2.myapp.localhost {\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Caddyfile missing %q:\n%s", want, got)
+		}
+	}
+
+	// Without the option, only the load-balanced site is served.
+	routes.Add("c1", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "80", ReplicaHostname: "web-1.myapp.localhost"})
+	routes.Add("c2", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-2", Port: "80", ReplicaHostname: "web-2.myapp.localhost"})
+	got, _ = GenerateCaddyfile(routes)
+	if strings.Contains(got, "web-1.myapp.localhost") {
+		t.Errorf("replica site rendered without replica_hostnames:\n%s", got)
+	}
+}
+
+func TestGenerateCaddyfile_HealthPath(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "8080", HealthPath: "/healthz"})
+	routes.Add("c2", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80"})
+
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	want := "    reverse_proxy app-api-1:8080 {\n        health_uri /healthz\n        health_interval 10s\n    }\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("expected active health check in Caddyfile:\n%s", got)
+	}
+	if !strings.Contains(got, "    reverse_proxy app-web-1:80\n") {
+		t.Errorf("expected no health check without a health path:\n%s", got)
+	}
+
+	routes.Add("c1", &Route{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "8080", HealthPath: "/healthz }"})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for invalid health path")
+	}
+}
+
+func TestGenerateCaddyfile_Snippet(t *testing.T) {
+	routes := NewActiveRoutes()
+	snippet := "header {\n  Access-Control-Allow-Origin *\n}\n\nencode gzip\n"
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Snippet: snippet})
+
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	want := "    header {\n      Access-Control-Allow-Origin *\n    }\n    encode gzip\n    reverse_proxy app-web-1:80\n}\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("expected snippet in site block:\n%s", got)
+	}
+
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Snippet: "}\nevil.localhost {\n"})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for a snippet closing the site block")
+	}
+}
+
+func TestGenerateCaddyfile_RetryPage(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", RetryPage: true})
+	routes.Add("c2", &Route{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "80"})
+
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if strings.Count(got, "handle_errors {") != 1 || !strings.Contains(got, "respond @atc_retry <<HTML\n") ||
+		!strings.Contains(got, "<title>app.localhost is restarting</title>") {
+		t.Fatalf("expected one retry page, for app.localhost:\n%s", got)
+	}
+	// Caddy would replace braced text in the page body.
+	start := strings.Index(got, "<<HTML")
+	body := got[start : strings.Index(got[start:], "HTML 503")+start]
+	if strings.ContainsAny(body, "{}") {
+		t.Errorf("retry page body has braces:\n%s", body)
+	}
+}
+
+func TestGenerateCaddyfile_MixedContent(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", MixedContent: config.MixedContentUpgrade})
+	routes.Add("c2", &Route{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "80", MixedContent: config.MixedContentRelax})
+
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"    header +Content-Security-Policy upgrade-insecure-requests\n    reverse_proxy app-web-1:80\n",
+		"    header {\n        -Content-Security-Policy\n        -Content-Security-Policy-Report-Only\n    }\n    reverse_proxy app-api-1:80\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in Caddyfile:\n%s", want, got)
+		}
+	}
+
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", MixedContent: "upgrade\n}"})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+}
+
+func TestGenerateCaddyfile_PKI(t *testing.T) {
+	routes := NewActiveRoutes()
+	if !routes.SetPKI(config.PKIConfig{Name: "caddy-atc Dev CA", RootCN: "caddy-atc Dev Root", IntermediateLifetime: "720h", CertLifetime: "24h"}) {
+		t.Fatal("expected SetPKI to report a change")
+	}
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	want := `    https_port {$CADDY_ATC_HTTPS_PORT:443}
+    cert_lifetime 24h
+    pki {
+        ca local {
+            name "caddy-atc Dev CA"
+            root_cn "caddy-atc Dev Root"
+            intermediate_lifetime 720h
+        }
+    }
+}
+`
+	if !strings.Contains(got, want) {
+		t.Errorf("expected pki global options in Caddyfile:\n%s", got)
+	}
+
+	routes.SetPKI(config.PKIConfig{})
+	if got, _ := GenerateCaddyfile(routes); strings.Contains(got, "pki") || strings.Contains(got, "cert_lifetime") {
+		t.Errorf("expected no pki options by default:\n%s", got)
+	}
+
+	routes.SetPKI(config.PKIConfig{Name: `Dev" } evil {`})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for unsafe CA name")
+	}
+}
+
+func TestGenerateCaddyfile_TeamCA(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.SetPKI(config.PKIConfig{TeamCA: config.TeamCARoot})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	want := `        ca local {
+            root {
+                format pem_file
+                cert /etc/caddy/ca/root.crt
+                key /etc/caddy/ca/root.key
+            }
+        }
+`
+	if !strings.Contains(got, want) {
+		t.Errorf("expected team root CA in Caddyfile:\n%s", got)
+	}
+
+	routes.SetPKI(config.PKIConfig{TeamCA: config.TeamCAIntermediate})
+	got, _ = GenerateCaddyfile(routes)
+	want = `            root {
+                format pem_file
+                cert /etc/caddy/ca/root.crt
+            }
+            intermediate {
+                format pem_file
+                cert /etc/caddy/ca/intermediate.crt
+                key /etc/caddy/ca/intermediate.key
+            }
+`
+	if !strings.Contains(got, want) {
+		t.Errorf("expected team intermediate CA in Caddyfile:\n%s", got)
+	}
+
+	routes.SetPKI(config.PKIConfig{TeamCA: "other"})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for unknown team CA mode")
+	}
+}
+
+func TestGenerateCaddyfile_LAN(t *testing.T) {
+	routes := NewActiveRoutes()
+	if !routes.SetLAN([]string{"192.168.1.23", "devbox.local"}) {
+		t.Fatal("SetLAN() = false, want true")
+	}
+	if routes.SetLAN([]string{"192.168.1.23", "devbox.local"}) {
+		t.Error("SetLAN() with the same names = true, want false")
+	}
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"    default_sni 192.168.1.23\n",
+		"\n192.168.1.23, devbox.local {\n    tls internal\n    respond \"caddy-atc gateway on devbox.local\" 200\n}\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in Caddyfile:\n%s", want, got)
+		}
+	}
+
+	routes.SetExposed("myapp.localhost")
+	got, _ = GenerateCaddyfile(routes)
+	if want := "    respond \"caddy-atc: myapp.localhost is not running\" 502\n"; !strings.Contains(got, want) {
+		t.Errorf("expected %q in Caddyfile:\n%s", want, got)
+	}
+
+	routes.Add("c1", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "3000"})
+	got, _ = GenerateCaddyfile(routes)
+	want := "\n192.168.1.23, devbox.local {\n    tls internal\n"
+	if !strings.Contains(got, want) || strings.Count(got, "reverse_proxy myapp-web-1:3000") != 2 {
+		t.Errorf("expected the LAN addresses to proxy to myapp.localhost:\n%s", got)
+	}
+
+	routes.SetLAN(nil)
+	got, _ = GenerateCaddyfile(routes)
+	if strings.Contains(got, "192.168.1.23") {
+		t.Errorf("LAN site should be gone once LAN mode is off:\n%s", got)
+	}
+
+	routes.SetLAN([]string{"bad host"})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("expected error for invalid LAN name")
+	}
+}
+
+func TestGenerateCaddyfile_RouteNotes(t *testing.T) {
+	routes := quarantineRoutes()
+	routes.Add("c4", &Route{Hostname: "tools.localhost", ContainerName: "tools-1", Port: "80", Project: "my tools", Service: "web"})
+	routes.SyncStatic([]*config.StaticRoute{{Hostname: "docs.localhost", Root: "/home/dev/docs"}})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"# caddy-atc route project=app service=web container=c1\napp.localhost {",
+		"# caddy-atc route project=app service=api containers=c2,c3\napi.app.localhost {",
+		"# caddy-atc route project=\"my tools\" service=web container=c4\ntools.localhost {",
+		"# caddy-atc route static\ndocs.localhost {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+}
+
+func TestGenerateCaddyfile_Dashboard(t *testing.T) {
+	routes := quarantineRoutes()
+	routes.SetDashboard("atc.localhost", "172.18.0.1:41234")
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if want := "\n# caddy-atc dashboard\natc.localhost {\n    tls internal\n    reverse_proxy 172.18.0.1:41234\n}\n"; !strings.Contains(got, want) {
+		t.Errorf("expected dashboard site %q in output:\n%s", want, got)
+	}
+
+	// A route for the hostname takes precedence.
+	routes.SetDashboard("app.localhost", "172.18.0.1:41234")
+	if got, _ := GenerateCaddyfile(routes); strings.Contains(got, "dashboard") {
+		t.Errorf("dashboard rendered for a routed hostname:\n%s", got)
+	}
+
+	routes.SetDashboard("atc.localhost", "172.18.0.1:41234 {")
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("GenerateCaddyfile() accepted an unsafe dashboard upstream")
+	}
+}
+
+func TestGenerateCaddyfile_ErrorPages(t *testing.T) {
+	routes := quarantineRoutes()
+	routes.Add("c4", &Route{Hostname: "retry.localhost", ContainerName: "retry-1", Port: "80", RetryPage: true})
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	if strings.Contains(got, "on_demand") || strings.Contains(got, downPage) {
+		t.Errorf("error pages rendered without SetErrorPages:\n%s", got)
+	}
+
+	routes.SetErrorPages([]string{".localhost", ".test"})
+	got, err = GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() error = %v", err)
+	}
+	for _, want := range []string{
+		"    on_demand_tls {\n        ask http://127.0.0.1:20191/\n    }\n}\n",
+		"\n# caddy-atc unrouted hostnames\nhttps:// {\n    tls internal {\n        on_demand\n    }\n    root * /etc/caddy/errors\n    rewrite * /unrouted.html\n",
+		"        status 404\n",
+		"\nhttp://127.0.0.1:20191 {\n    bind 127.0.0.1\n    @atc_local expression `{query.domain}.endsWith(\".localhost\") || {query.domain}.endsWith(\".test\")`\n",
+		"            rewrite * /down.html\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+	// Sites with the retry page keep it instead.
+	if n := strings.Count(got, "rewrite * /down.html"); n != 2 {
+		t.Errorf("down page in %d sites, want 2 (app and api, not retry):\n%s", n, got)
+	}
+
+	routes.SetErrorPages([]string{".bad domain"})
+	if _, err := GenerateCaddyfile(routes); err == nil {
+		t.Error("GenerateCaddyfile() accepted an unsafe error page domain")
+	}
+}
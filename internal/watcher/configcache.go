@@ -0,0 +1,57 @@
+package watcher
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// configStamp identifies a version of projects.yml and config.yml by their
+// modification times and sizes; the zero value stands for a missing file.
+type configStamp [2]struct {
+	mod  time.Time
+	size int64
+}
+
+// currentConfigStamp stats projects.yml and config.yml.
+func currentConfigStamp() configStamp {
+	var st configStamp
+	for i, path := range []string{config.ProjectsPath(), config.SettingsPath()} {
+		if info, err := os.Stat(path); err == nil {
+			st[i].mod, st[i].size = info.ModTime(), info.Size()
+		}
+	}
+	return st
+}
+
+// cachedConfig is a parsed config and the stamp of the files it was read
+// from.
+type cachedConfig struct {
+	cfg   *config.Config
+	stamp configStamp
+}
+
+// configCache keeps the last parsed config so container events cost two
+// stats instead of a read and YAML parse of both files. Reads don't lock;
+// concurrent misses may both parse, and either result is kept.
+type configCache struct {
+	cur atomic.Pointer[cachedConfig]
+}
+
+// load returns the config, parsing it again only when either file has
+// changed since it was cached. The result is shared and must not be
+// modified.
+func (c *configCache) load() (*config.Config, error) {
+	stamp := currentConfigStamp()
+	if cur := c.cur.Load(); cur != nil && cur.stamp == stamp {
+		return cur.cfg, nil
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	c.cur.Store(&cachedConfig{cfg: cfg, stamp: stamp})
+	return cfg, nil
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// saveProjects writes a projects.yml with n adopted projects.
+func saveProjects(t testing.TB, n int) {
+	t.Helper()
+	cfg := &config.Config{Projects: make(map[string]*config.ProjectConfig)}
+	for i := range n {
+		name := fmt.Sprintf("app%d", i)
+		cfg.Projects[name] = &config.ProjectConfig{
+			Dir:            "/src/" + name,
+			ComposeProject: name,
+			Hostname:       name + ".localhost",
+			Services:       map[string]string{"web": name + ".localhost"},
+		}
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	saveProjects(t, 1)
+
+	var c configCache
+	first, err := c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Error("unchanged config parsed again")
+	}
+
+	// Adopting a project changes the file's size, so it's picked up even
+	// within the same modification time.
+	saveProjects(t, 2)
+	changed, err := c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed.Projects) != 2 {
+		t.Errorf("cached config has %d projects after a change, want 2", len(changed.Projects))
+	}
+}
+
+// BenchmarkConfigLoad compares parsing the config on every container event
+// with reading it through the watcher's cache.
+func BenchmarkConfigLoad(b *testing.B) {
+	b.Setenv("HOME", b.TempDir())
+	saveProjects(b, 20)
+
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := config.Load(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		var c configCache
+		for b.Loop() {
+			if _, err := c.load(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
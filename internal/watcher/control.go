@@ -0,0 +1,412 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// ErrNotRunning is returned by the control client when no watcher answers
+// on the control socket.
+var ErrNotRunning = errors.New("the watcher is not running")
+
+// controlTimeout bounds control requests other than reloads, which may
+// wait for a lazy gateway to start.
+const controlTimeout = 5 * time.Second
+
+// Status is the running watcher's state, as served on its control socket.
+type Status struct {
+	PID          int       `json:"pid"`
+	Started      time.Time `json:"started"`
+	Paused       string    `json:"paused,omitempty"`      // why routing is paused
+	Pending      bool      `json:"pending,omitempty"`     // a reload waits for resume
+	Maintenance  bool      `json:"maintenance,omitempty"` // the maintenance page is served
+	Routes       int       `json:"routes"`
+	Quarantined  int       `json:"quarantined"`
+	DriftRepairs uint64    `json:"drift_repairs"`
+	DockerLost   bool      `json:"docker_lost,omitempty"` // event stream reconnecting
+}
+
+// ServedRoute is a route as the watcher programmed it into Caddy. Replica
+// hostnames are listed as routes of their own.
+type ServedRoute struct {
+	Hostname   string `json:"hostname"`
+	Container  string `json:"container"`
+	Port       string `json:"port"`
+	Project    string `json:"project,omitempty"` // empty for static routes
+	Service    string `json:"service,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Replica    bool   `json:"replica,omitempty"`
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+// startControl serves the control socket for the CLI. Observe mode leaves
+// it to the watcher whose routes are live. Failing to serve it doesn't
+// stop the watcher; the CLI falls back to the state files.
+func (w *Watcher) startControl(ctx context.Context) {
+	if w.opts.Observe {
+		return
+	}
+	if err := w.serveControl(ctx, config.ControlSocketPath()); err != nil {
+		w.logger.Warn("Control socket unavailable", "err", err)
+	}
+}
+
+// serveControl serves the control API on the Unix socket at path until ctx
+// is done. Requests are handled on the event loop, which owns the
+// watcher's state.
+func (w *Watcher) serveControl(ctx context.Context, path string) error {
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is served by another watcher", path)
+	}
+	// Left behind by a watcher that didn't shut down cleanly.
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return fmt.Errorf("control socket: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(rw http.ResponseWriter, r *http.Request) {
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			return w.status(), nil
+		})
+	})
+	mux.HandleFunc("GET /routes", func(rw http.ResponseWriter, r *http.Request) {
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			return servedRoutes(w.routes), nil
+		})
+	})
+	mux.HandleFunc("GET /caddyfile", func(rw http.ResponseWriter, r *http.Request) {
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			return generatedCaddyfile(w.routes)
+		})
+	})
+	mux.HandleFunc("POST /pause", func(rw http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Reason      string `json:"reason"`
+			Maintenance bool   `json:"maintenance"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+			writeControlError(rw, http.StatusBadRequest, errors.New("pause needs a reason"))
+			return
+		}
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			var err error
+			if req.Maintenance {
+				err = PauseForMaintenance(req.Reason)
+			} else {
+				err = Pause(req.Reason, 0)
+			}
+			if err != nil {
+				return nil, err
+			}
+			w.syncPause(ctx)
+			return w.status(), nil
+		})
+	})
+	mux.HandleFunc("POST /resume", func(rw http.ResponseWriter, r *http.Request) {
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			if err := Resume(); err != nil {
+				return nil, err
+			}
+			w.syncPause(ctx)
+			return w.status(), nil
+		})
+	})
+	mux.HandleFunc("POST /reload", func(rw http.ResponseWriter, r *http.Request) {
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			if st := CurrentPause(); st != nil {
+				return nil, fmt.Errorf("routing is paused (%s); run 'caddy-atc resume' first", st.Reason)
+			}
+			if err := w.reloadNow(ctx); err != nil {
+				return nil, err
+			}
+			return w.status(), nil
+		})
+	})
+	mux.HandleFunc("POST /sync", func(rw http.ResponseWriter, r *http.Request) {
+		w.handleControl(rw, r, func(ctx context.Context) (any, error) {
+			if err := w.syncConfig(ctx); err != nil {
+				return nil, err
+			}
+			return w.status(), nil
+		})
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.logger.Warn("Control socket stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// syncConfig applies changes to projects.yml and config.yml at once
+// rather than on the next control tick, reloading Caddy if routes changed.
+func (w *Watcher) syncConfig(ctx context.Context) error {
+	changed := w.refreshStaticRoutes()
+	if w.disabledDirty {
+		w.disabledDirty = false
+		w.syncDisabled(ctx)
+	}
+	if lanChanged := w.checkLAN(); changed || lanChanged || w.reloadTimer != nil {
+		return w.reloadNow(ctx)
+	}
+	return nil
+}
+
+// handleControl runs f on the event loop and writes its result as JSON.
+func (w *Watcher) handleControl(rw http.ResponseWriter, r *http.Request, f func(ctx context.Context) (any, error)) {
+	var (
+		v   any
+		err error
+	)
+	if !w.onLoop(r.Context(), func(ctx context.Context) { v, err = f(ctx) }) {
+		return
+	}
+	if err != nil {
+		writeControlError(rw, http.StatusConflict, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
+
+// onLoop runs f on the event loop and waits for it. Returns false if ctx
+// is done first.
+func (w *Watcher) onLoop(ctx context.Context, f func(ctx context.Context)) bool {
+	done := make(chan struct{})
+	select {
+	case w.control <- func(ctx context.Context) {
+		f(ctx)
+		close(done)
+	}:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func writeControlError(rw http.ResponseWriter, code int, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+}
+
+// status describes the watcher. It must run on the event loop.
+func (w *Watcher) status() Status {
+	st := Status{
+		PID:          os.Getpid(),
+		Started:      w.started,
+		Pending:      w.pending,
+		DriftRepairs: w.metrics.driftRepairs.Load(),
+		Maintenance:  w.routes.Maintenance(),
+		DockerLost:   w.streamDown,
+	}
+	if p := CurrentPause(); p != nil {
+		st.Paused = p.Reason
+	}
+	for _, r := range w.routes.All() {
+		if r.Quarantine != "" {
+			st.Quarantined++
+		} else {
+			st.Routes++
+		}
+	}
+	return st
+}
+
+// servedRoutes lists the routes, sorted by hostname and container.
+func servedRoutes(routes *ActiveRoutes) []ServedRoute {
+	served := []ServedRoute{}
+	for _, r := range routes.All() {
+		sr := ServedRoute{
+			Hostname:   r.Hostname,
+			Container:  r.ContainerName,
+			Port:       r.Port,
+			Project:    r.Project,
+			Service:    r.Service,
+			Source:     r.Source,
+			Quarantine: r.Quarantine,
+		}
+		if r.Root != "" {
+			sr.Container, sr.Port = "file_server", "-"
+		}
+		served = append(served, sr)
+		if r.ReplicaHostname != "" && r.Options.ReplicaHostnames {
+			sr.Hostname, sr.Replica = r.ReplicaHostname, true
+			served = append(served, sr)
+		}
+	}
+	sort.Slice(served, func(i, j int) bool {
+		a, b := served[i], served[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		return a.Container < b.Container
+	})
+	return served
+}
+
+// WatcherStatus asks the running watcher for its state.
+func WatcherStatus(ctx context.Context) (*Status, error) {
+	var st Status
+	if err := callControl(ctx, controlTimeout, http.MethodGet, "/status", nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// WatcherRoutes asks the running watcher for the routes it serves.
+func WatcherRoutes(ctx context.Context) ([]ServedRoute, error) {
+	var routes []ServedRoute
+	if err := callControl(ctx, controlTimeout, http.MethodGet, "/routes", nil, &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// GeneratedCaddyfile is the Caddyfile the watcher generates for its
+// current routes.
+type GeneratedCaddyfile struct {
+	Content string `json:"content"`
+	// Builtin is the built-in Caddyfile when Content comes from the user's
+	// template; empty otherwise.
+	Builtin string `json:"builtin,omitempty"`
+}
+
+// generatedCaddyfile renders routes as applyRoutes would, before the
+// template's validation.
+func generatedCaddyfile(routes *ActiveRoutes) (*GeneratedCaddyfile, error) {
+	builtin, err := GenerateCaddyfile(routes)
+	if err != nil {
+		return nil, err
+	}
+	templated, err := RenderCaddyfileTemplate(routes)
+	if err != nil {
+		return nil, fmt.Errorf("Caddyfile template %s: %w", config.CaddyfileTemplatePath(), err)
+	}
+	if templated == "" {
+		return &GeneratedCaddyfile{Content: builtin}, nil
+	}
+	return &GeneratedCaddyfile{Content: templated, Builtin: builtin}, nil
+}
+
+// WatcherCaddyfile asks the running watcher for the Caddyfile its current
+// routes generate.
+func WatcherCaddyfile(ctx context.Context) (*GeneratedCaddyfile, error) {
+	var c GeneratedCaddyfile
+	if err := callControl(ctx, controlTimeout, http.MethodGet, "/caddyfile", nil, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// PauseWatcher pauses routing through the running watcher, which applies
+// it at once instead of on its next control tick. With maintenance, it
+// returns once the gateway serves the maintenance page.
+func PauseWatcher(ctx context.Context, reason string, maintenance bool) error {
+	req := map[string]any{"reason": reason, "maintenance": maintenance}
+	return callControl(ctx, 2*time.Minute, http.MethodPost, "/pause", req, nil)
+}
+
+// ResumeWatcher resumes routing through the running watcher. It returns
+// once route changes deferred while paused have been applied.
+func ResumeWatcher(ctx context.Context) error {
+	return callControl(ctx, 2*time.Minute, http.MethodPost, "/resume", nil, nil)
+}
+
+// ReloadWatcher has the running watcher regenerate the Caddyfile and
+// reload Caddy.
+func ReloadWatcher(ctx context.Context) error {
+	return callControl(ctx, 2*time.Minute, http.MethodPost, "/reload", nil, nil)
+}
+
+// SyncWatcher has the running watcher apply changes to its config files,
+// such as a project being disabled, and returns once Caddy serves them.
+func SyncWatcher(ctx context.Context) error {
+	return callControl(ctx, 2*time.Minute, http.MethodPost, "/sync", nil, nil)
+}
+
+// callControl sends a request to the control socket and decodes the JSON
+// response into out, if non-nil. Failing to connect returns ErrNotRunning.
+func callControl(ctx context.Context, timeout time.Duration, method, path string, in, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return fmt.Errorf("encoding control request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://watcher"+path, &body)
+	if err != nil {
+		return err
+	}
+
+	socket := config.ControlSocketPath()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "unix", socket)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotRunning, err)
+			}
+			return conn, nil
+		},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrNotRunning) {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("contacting the watcher: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			return fmt.Errorf("watcher: %s", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding watcher response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// serveTestControl serves the control socket for w, running its requests
+// the way the event loop would.
+func serveTestControl(t *testing.T, w *Watcher) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	w.control = make(chan func(context.Context))
+	go func() {
+		for {
+			select {
+			case f := <-w.control:
+				f(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if err := w.serveControl(ctx, config.ControlSocketPath()); err != nil {
+		t.Fatalf("serveControl() error = %v", err)
+	}
+}
+
+func TestControl_RoutesAndStatus(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routes := quarantineRoutes()
+	routes.Add("c4", &Route{Hostname: "admin.app.localhost", ContainerName: "app-admin-1", Port: "80", Project: "app", Service: "admin",
+		ReplicaHostname: "admin-1.app.localhost", Options: config.ServiceOptions{ReplicaHostnames: true}})
+	routes.Quarantine("api.app.localhost", "bad upstream")
+	w := &Watcher{routes: routes, logger: slog.New(slog.DiscardHandler), started: time.Now()}
+	w.metrics.driftRepairs.Add(2)
+	serveTestControl(t, w)
+
+	ctx := context.Background()
+	served, err := WatcherRoutes(ctx)
+	if err != nil {
+		t.Fatalf("WatcherRoutes() error = %v", err)
+	}
+	var got []string
+	for _, r := range served {
+		entry := r.Hostname + " " + r.Container
+		if r.Replica {
+			entry += " replica"
+		}
+		if r.Quarantine != "" {
+			entry += " quarantined"
+		}
+		got = append(got, entry)
+	}
+	want := []string{
+		"admin-1.app.localhost app-admin-1 replica",
+		"admin.app.localhost app-admin-1",
+		"api.app.localhost app-api-1 quarantined",
+		"api.app.localhost app-api-2 quarantined",
+		"app.localhost app-web-1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("WatcherRoutes() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	st, err := WatcherStatus(ctx)
+	if err != nil {
+		t.Fatalf("WatcherStatus() error = %v", err)
+	}
+	if st.Routes != 2 || st.Quarantined != 2 || st.DriftRepairs != 2 || st.Paused != "" {
+		t.Errorf("WatcherStatus() = %+v, want 2 routes, 2 quarantined, 2 drift repairs, not paused", st)
+	}
+}
+
+func TestControl_PauseResumeReload(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	w := &Watcher{routes: NewActiveRoutes(), logger: slog.New(slog.DiscardHandler)}
+	serveTestControl(t, w)
+	ctx := context.Background()
+
+	if err := PauseWatcher(ctx, "bulk restart", false); err != nil {
+		t.Fatalf("PauseWatcher() error = %v", err)
+	}
+	if !w.paused {
+		t.Error("watcher didn't apply the pause at once")
+	}
+	if st := CurrentPause(); st == nil || st.Reason != "bulk restart" {
+		t.Errorf("CurrentPause() = %+v, want the pause to outlive the watcher", st)
+	}
+	if st, err := WatcherStatus(ctx); err != nil || st.Paused != "bulk restart" {
+		t.Errorf("WatcherStatus() = %+v, %v, want paused", st, err)
+	}
+
+	if err := ReloadWatcher(ctx); err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("ReloadWatcher() while paused = %v, want paused error", err)
+	}
+
+	if err := ResumeWatcher(ctx); err != nil {
+		t.Fatalf("ResumeWatcher() error = %v", err)
+	}
+	if w.paused || CurrentPause() != nil {
+		t.Error("watcher still paused after ResumeWatcher()")
+	}
+}
+
+func TestControl_NotRunning(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if _, err := WatcherStatus(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("WatcherStatus() without a watcher = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestServeControl_RefusesLiveSocket(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	serveTestControl(t, &Watcher{routes: NewActiveRoutes(), logger: slog.New(slog.DiscardHandler)})
+
+	second := &Watcher{routes: NewActiveRoutes(), logger: slog.New(slog.DiscardHandler)}
+	if err := second.serveControl(context.Background(), config.ControlSocketPath()); err == nil {
+		t.Error("serveControl() took over a socket another watcher serves")
+	}
+}
+
+func TestControl_SyncAppliesDisabled(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"app": {ComposeProject: "app", Hostname: "app.localhost"},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	w := &Watcher{routes: NewActiveRoutes(), logger: slog.New(slog.DiscardHandler), opts: Options{Observe: true}}
+	w.routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Project: "app"})
+	serveTestControl(t, w)
+	ctx := context.Background()
+	if err := SyncWatcher(ctx); err != nil {
+		t.Fatalf("SyncWatcher() error = %v", err)
+	}
+
+	if err := config.LoadAndModify(func(cfg *config.Config) error {
+		_, err := cfg.SetProjectDisabled("app", true)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncWatcher(ctx); err != nil {
+		t.Fatalf("SyncWatcher() error = %v", err)
+	}
+	if _, ok := w.routes.Get("c1"); ok {
+		t.Error("route of a disabled project kept after SyncWatcher()")
+	}
+}
+
+func TestControl_Caddyfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80"})
+	w := &Watcher{routes: routes, logger: slog.New(slog.DiscardHandler), started: time.Now()}
+	serveTestControl(t, w)
+
+	ctx := context.Background()
+	want, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := WatcherCaddyfile(ctx)
+	if err != nil {
+		t.Fatalf("WatcherCaddyfile() error = %v", err)
+	}
+	if got.Content != want || got.Builtin != "" {
+		t.Errorf("WatcherCaddyfile() = %+v, want the built-in Caddyfile", got)
+	}
+
+	writeTemplate(t, "# templated\n{{.Builtin}}")
+	if got, err = WatcherCaddyfile(ctx); err != nil {
+		t.Fatalf("WatcherCaddyfile() error = %v", err)
+	}
+	if got.Content != "# templated\n"+want || got.Builtin != want {
+		t.Errorf("WatcherCaddyfile() with a template = %+v", got)
+	}
+
+	writeTemplate(t, "{{.Nope}}")
+	if _, err := WatcherCaddyfile(ctx); err == nil {
+		t.Error("expected the template's error")
+	}
+}
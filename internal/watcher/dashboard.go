@@ -0,0 +1,145 @@
+package watcher
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"github.com/g-brodiei/caddy-atc/internal/history"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboardEvents is how many recent history events the dashboard shows.
+const dashboardEvents = 25
+
+// dashboardData is what the dashboard page shows, served as JSON at
+// /api/dashboard.
+type dashboardData struct {
+	Version string           `json:"version"`
+	Status  Status           `json:"status"`
+	Routes  []dashboardRoute `json:"routes"`
+	Events  []history.Event  `json:"events"` // newest first
+}
+
+// dashboardRoute is a served route with its link and upstream health.
+type dashboardRoute struct {
+	ServedRoute
+	URL          string `json:"url,omitempty"` // empty for wildcard and quarantined routes
+	Health       string `json:"health,omitempty"`
+	HealthDetail string `json:"health_detail,omitempty"`
+}
+
+// startDashboard serves the dashboard for the gateway to proxy to, if
+// projects.yml doesn't disable it. Observe mode leaves it to the watcher
+// whose routes are live. Failing to serve it doesn't stop the watcher.
+func (w *Watcher) startDashboard(ctx context.Context) {
+	if w.opts.Observe {
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		w.logger.Warn("Loading config failed", "err", err)
+		return
+	}
+	hostname, err := cfg.DashboardHostname()
+	if err != nil {
+		w.logger.Warn("Invalid setting", "err", err, "using", hostname)
+	}
+	if hostname == "" {
+		return
+	}
+	upstream, err := w.serveDashboard(ctx)
+	if err != nil {
+		w.logger.Warn("Dashboard unavailable", "err", err)
+		return
+	}
+	w.routes.SetDashboard(hostname, upstream)
+	_, httpsPort := cfg.HTTPPorts()
+	w.logger.Info("Serving dashboard", "url", config.SiteURL(hostname, httpsPort))
+}
+
+// serveDashboard serves the dashboard page and its data until ctx is done,
+// on the address containers reach the Docker host at, and returns the
+// host:port the gateway proxies to. Only reads are served: the page can't
+// change routing.
+func (w *Watcher) serveDashboard(ctx context.Context) (string, error) {
+	bindIP, upstreamHost, err := gateway.HostAddress(ctx)
+	if err != nil {
+		return "", err
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(bindIP, "0"))
+	if err != nil {
+		return "", fmt.Errorf("dashboard: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Write(dashboardPage)
+	})
+	mux.HandleFunc("GET /api/dashboard", func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			st        Status
+			served    []ServedRoute
+			httpsPort int
+		)
+		if !w.onLoop(r.Context(), func(context.Context) {
+			st, served, httpsPort = w.status(), servedRoutes(w.routes), w.httpsPort
+		}) {
+			return
+		}
+		// Events are read off the event loop; without them the page still
+		// shows the routes.
+		events, _ := history.Query(history.Filter{Limit: dashboardEvents})
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(rw).Encode(newDashboardData(w.opts.Version, st, served, httpsPort, LoadHealth(), events))
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.logger.Warn("Dashboard stopped", "err", err)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return net.JoinHostPort(upstreamHost, port), nil
+}
+
+// newDashboardData assembles the dashboard's data: routes with the URL
+// they are served at on httpsPort and the health of their upstream, and
+// events newest first.
+func newDashboardData(version string, st Status, served []ServedRoute, httpsPort int, health HealthStates, events []history.Event) *dashboardData {
+	d := &dashboardData{Version: version, Status: st, Routes: []dashboardRoute{}, Events: []history.Event{}}
+	for _, sr := range served {
+		dr := dashboardRoute{ServedRoute: sr}
+		if sr.Quarantine == "" {
+			if !strings.HasPrefix(sr.Hostname, "*.") {
+				dr.URL = config.SiteURL(sr.Hostname, httpsPort)
+			}
+			if h, ok := health[sr.Container+":"+sr.Port]; ok {
+				dr.Health, dr.HealthDetail = h.Status, h.Detail
+			}
+		}
+		d.Routes = append(d.Routes, dr)
+	}
+	d.Events = append(d.Events, events...)
+	slices.Reverse(d.Events)
+	return d
+}
@@ -0,0 +1,51 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/history"
+)
+
+func TestNewDashboardData(t *testing.T) {
+	served := []ServedRoute{
+		{Hostname: "*.app.localhost", Container: "app-web-1", Port: "3000", Project: "app", Service: "web"},
+		{Hostname: "api.app.localhost", Container: "app-api-1", Port: "8000", Project: "app", Service: "api", Quarantine: "bad snippet"},
+		{Hostname: "app.localhost", Container: "app-web-1", Port: "3000", Project: "app", Service: "web"},
+		{Hostname: "docs.localhost", Container: "file_server", Port: "-"},
+	}
+	health := HealthStates{
+		"app-web-1:3000": {Status: HealthUnhealthy, Detail: "HTTP 500"},
+		"app-api-1:8000": {Status: HealthHealthy},
+	}
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	events := []history.Event{
+		{Time: at, Type: history.RouteAdded, Hostname: "app.localhost"},
+		{Time: at.Add(time.Second), Type: history.Reload},
+	}
+
+	d := newDashboardData("v1.2.0", Status{Routes: 3, Quarantined: 1}, served, 8443, health, events)
+	if d.Version != "v1.2.0" || d.Status.Routes != 3 || len(d.Routes) != 4 {
+		t.Fatalf("newDashboardData() = %+v", d)
+	}
+	wildcard, quarantined, web, docs := d.Routes[0], d.Routes[1], d.Routes[2], d.Routes[3]
+	if wildcard.URL != "" || wildcard.Health != HealthUnhealthy {
+		t.Errorf("wildcard route = %+v, want no URL and the upstream's health", wildcard)
+	}
+	if quarantined.URL != "" || quarantined.Health != "" {
+		t.Errorf("quarantined route = %+v, want no URL or health", quarantined)
+	}
+	if web.URL != "https://app.localhost:8443" || web.Health != HealthUnhealthy || web.HealthDetail != "HTTP 500" {
+		t.Errorf("route = %+v", web)
+	}
+	if docs.URL != "https://docs.localhost:8443" || docs.Health != "" {
+		t.Errorf("file route = %+v", docs)
+	}
+	if len(d.Events) != 2 || d.Events[0].Type != history.Reload {
+		t.Errorf("events = %+v, want newest first", d.Events)
+	}
+
+	if d := newDashboardData("dev", Status{}, nil, 443, nil, nil); d.Routes == nil || d.Events == nil {
+		t.Error("empty dashboard data has null lists")
+	}
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"context"
+	"time"
+)
+
+// maxReloadDelay caps how long a steady stream of container changes can
+// hold back a debounced reload.
+const maxReloadDelay = 5 * time.Second
+
+// scheduleReload regenerates the Caddyfile and reloads Caddy after a
+// container change, then calls then (if any) unless routing is paused.
+// With a reload debounce configured, the reload waits until no further
+// changes arrive for that long, or at most maxReloadDelay, so a burst of
+// container starts costs one Caddyfile write and one reload.
+func (w *Watcher) scheduleReload(ctx context.Context, then func()) {
+	if then != nil {
+		w.afterReload = append(w.afterReload, then)
+	}
+	if w.debounce <= 0 {
+		w.reloadNow(ctx)
+		return
+	}
+
+	now := time.Now()
+	if w.reloadTimer == nil {
+		w.reloadSince = now
+		w.reloadTimer = time.NewTimer(w.debounce)
+		return
+	}
+	delay := min(w.debounce, w.reloadSince.Add(maxReloadDelay).Sub(now))
+	w.reloadTimer.Reset(max(delay, 0))
+}
+
+// reloadDue returns the channel the debounced reload fires on, or nil if
+// none is scheduled.
+func (w *Watcher) reloadDue() <-chan time.Time {
+	if w.reloadTimer == nil {
+		return nil
+	}
+	return w.reloadTimer.C
+}
+
+// reloadNow regenerates the Caddyfile and reloads Caddy at once, taking
+// along any debounced reload and its callbacks. The error is logged as
+// well as returned.
+func (w *Watcher) reloadNow(ctx context.Context) error {
+	if w.reloadTimer != nil {
+		w.reloadTimer.Stop()
+		w.reloadTimer = nil
+	}
+	then := w.afterReload
+	w.afterReload = nil
+	if err := w.reloadRoutes(ctx); err != nil {
+		w.logger.Error("Reloading routes failed", "err", err)
+		return err
+	}
+	if !w.pending {
+		for _, f := range then {
+			f()
+		}
+	}
+	return nil
+}
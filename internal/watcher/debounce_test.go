@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestScheduleReloadDebounce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	w := &Watcher{
+		routes:   NewActiveRoutes(),
+		logger:   slog.New(slog.DiscardHandler),
+		opts:     Options{Observe: true},
+		debounce: 20 * time.Millisecond,
+	}
+
+	ran := 0
+	w.scheduleReload(t.Context(), func() { ran++ })
+	w.scheduleReload(t.Context(), nil)
+	w.scheduleReload(t.Context(), func() { ran++ })
+	if ran != 0 {
+		t.Fatalf("callbacks ran before the debounced reload")
+	}
+
+	select {
+	case <-w.reloadDue():
+		w.reloadNow(t.Context())
+	case <-time.After(time.Second):
+		t.Fatal("debounced reload never fired")
+	}
+	if ran != 2 {
+		t.Errorf("callbacks ran %d times, want 2", ran)
+	}
+	if w.reloadDue() != nil {
+		t.Error("reload still scheduled after the reload")
+	}
+
+	// Without a debounce, the reload and callback happen at once.
+	w.debounce = 0
+	w.scheduleReload(t.Context(), func() { ran++ })
+	if ran != 3 || w.reloadDue() != nil {
+		t.Errorf("immediate reload: ran = %d, scheduled = %v", ran, w.reloadDue() != nil)
+	}
+}
+
+func TestScheduleReloadMaxDelay(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	w := &Watcher{
+		routes:   NewActiveRoutes(),
+		logger:   slog.New(slog.DiscardHandler),
+		opts:     Options{Observe: true},
+		debounce: time.Hour,
+	}
+
+	// A reload first scheduled long ago fires at once, however recent the
+	// latest change.
+	w.scheduleReload(t.Context(), nil)
+	w.reloadSince = time.Now().Add(-maxReloadDelay)
+	w.scheduleReload(t.Context(), nil)
+	select {
+	case <-w.reloadDue():
+	case <-time.After(time.Second):
+		t.Fatal("reload held back beyond maxReloadDelay")
+	}
+
+	// An immediate reload takes the pending one along.
+	ran := 0
+	w.scheduleReload(t.Context(), func() { ran++ })
+	w.reloadNow(t.Context())
+	if ran != 1 || w.reloadDue() != nil {
+		t.Errorf("reloadNow: ran = %d, scheduled = %v", ran, w.reloadDue() != nil)
+	}
+}
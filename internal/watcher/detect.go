@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"github.com/docker/docker/api/types"
+	"github.com/g-brodiei/caddy-atc/internal/config"
 )
 
 // Known HTTP ports in priority order.
@@ -43,8 +44,31 @@ var skipServices = map[string]bool{
 	"minio":         true,
 }
 
+// Route sources.
+const (
+	SourceLabel     = "label"     // the caddy-atc.port label
+	SourceHeuristic = "heuristic" // detected by DetectHTTPPort
+	SourceManual    = "manual"    // a static route
+)
+
+// PortSource returns how DetectHTTPPort chooses the container's port.
+func PortSource(info types.ContainerJSON) string {
+	if info.Config != nil && config.ValidatePort(info.Config.Labels[config.PortLabel]) == nil {
+		return SourceLabel
+	}
+	return SourceHeuristic
+}
+
 // DetectHTTPPort inspects a container and returns the likely HTTP port, or "" if none found.
+// A valid config.PortLabel on the container takes precedence over all heuristics.
 func DetectHTTPPort(info types.ContainerJSON) string {
+	if info.Config == nil {
+		return ""
+	}
+	if port := info.Config.Labels[config.PortLabel]; port != "" && config.ValidatePort(port) == nil {
+		return port
+	}
+
 	// Check service name - skip known non-HTTP services
 	serviceName := info.Config.Labels["com.docker.compose.service"]
 	if skipServices[serviceName] {
@@ -55,10 +79,8 @@ func DetectHTTPPort(info types.ContainerJSON) string {
 	exposedPorts := make(map[string]bool)
 
 	// From container config (EXPOSE in Dockerfile)
-	if info.Config != nil {
-		for port := range info.Config.ExposedPorts {
-			exposedPorts[port.Port()] = true
-		}
+	for port := range info.Config.ExposedPorts {
+		exposedPorts[port.Port()] = true
 	}
 
 	// From host port bindings
@@ -153,3 +153,25 @@ func TestDetectHTTPPort_CombinedExposedAndBindings(t *testing.T) {
 		t.Errorf("DetectHTTPPort() = %q, want %q", got, "80")
 	}
 }
+
+func TestDetectHTTPPort_PortLabel(t *testing.T) {
+	info := makeContainerJSON("postgres", nat.PortSet{
+		"80/tcp": struct{}{},
+	}, nil)
+	info.Config.Labels["caddy-atc.port"] = "4321"
+	got := DetectHTTPPort(info)
+	if got != "4321" {
+		t.Errorf("DetectHTTPPort() = %q, want %q", got, "4321")
+	}
+}
+
+func TestDetectHTTPPort_InvalidPortLabelIgnored(t *testing.T) {
+	info := makeContainerJSON("web", nat.PortSet{
+		"3000/tcp": struct{}{},
+	}, nil)
+	info.Config.Labels["caddy-atc.port"] = "99999"
+	got := DetectHTTPPort(info)
+	if got != "3000" {
+		t.Errorf("DetectHTTPPort() = %q, want %q", got, "3000")
+	}
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"context"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// syncDisabled applies projects being disabled or enabled in projects.yml
+// while the watcher runs: routes of disabled projects are removed and the
+// running containers of re-enabled ones are routed.
+func (w *Watcher) syncDisabled(ctx context.Context) {
+	cfg, err := w.config.load()
+	if err != nil {
+		w.logger.Error("Loading config failed", "err", err)
+		return
+	}
+	disabled := make(map[string]bool)
+	for _, proj := range cfg.Projects {
+		if proj.Disabled {
+			disabled[proj.ComposeProject] = true
+		}
+	}
+
+	logged := make(map[string]bool)
+	for id, r := range w.routes.containerRoutes() {
+		if !disabled[r.Project] {
+			continue
+		}
+		if !logged[r.Project] {
+			logged[r.Project] = true
+			w.logger.Info("Project disabled, removing its routes", "event", "project_disabled", "project", r.Project)
+		}
+		w.removeRoute(ctx, id)
+	}
+
+	var enabled []string
+	for project := range w.disabled {
+		if !disabled[project] {
+			enabled = append(enabled, project)
+		}
+	}
+	w.disabled = disabled
+	slices.Sort(enabled)
+
+	for _, project := range enabled {
+		w.logger.Info("Project enabled, routing its containers", "event", "project_enabled", "project", project)
+		containers, err := gateway.ListContainers(ctx, w.cli, container.ListOptions{
+			Filters: filters.NewArgs(filters.Arg("label", "com.docker.compose.project="+project)),
+		})
+		if err != nil {
+			w.logger.Error("Listing containers failed", "project", project, "err", err)
+			continue
+		}
+		for _, c := range containers {
+			if !isGatewayContainer(c.Names) {
+				w.handleContainerStart(ctx, c.ID)
+			}
+		}
+	}
+}
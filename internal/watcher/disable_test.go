@@ -0,0 +1,38 @@
+package watcher
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestSyncDisabled(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"app":  {ComposeProject: "app", Hostname: "app.localhost", Disabled: true},
+		"docs": {ComposeProject: "docs", Hostname: "docs.localhost"},
+	}}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Watcher{
+		routes: NewActiveRoutes(),
+		logger: slog.New(slog.DiscardHandler),
+		opts:   Options{Observe: true},
+	}
+	w.routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Project: "app"})
+	w.routes.Add("c2", &Route{Hostname: "docs.localhost", ContainerName: "docs-web-1", Port: "80", Project: "docs"})
+
+	w.syncDisabled(t.Context())
+	if _, ok := w.routes.Get("c1"); ok {
+		t.Error("route of a disabled project kept")
+	}
+	if _, ok := w.routes.Get("c2"); !ok {
+		t.Error("route of an enabled project removed")
+	}
+	if !w.disabled["app"] || len(w.disabled) != 1 {
+		t.Errorf("disabled = %v, want only app", w.disabled)
+	}
+}
@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/dns"
+)
+
+// startDNS starts the DNS server if projects.yml enables it, resolving
+// hostnames under the configured domain to the gateway. Observe mode
+// leaves it to the watcher whose routes are live, which holds its port.
+// Failing to start it doesn't stop the watcher.
+func (w *Watcher) startDNS(ctx context.Context) {
+	if w.opts.Observe {
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		w.logger.Warn("Loading config failed", "err", err)
+		return
+	}
+	addr, err := cfg.DNSListen()
+	if err == nil && addr != "" {
+		err = w.serveDNS(ctx, cfg, addr)
+	}
+	if err != nil {
+		w.logger.Warn("DNS server unavailable", "err", err)
+	}
+}
+
+func (w *Watcher) serveDNS(ctx context.Context, cfg *config.Config, addr string) error {
+	domain, err := cfg.DomainSuffix()
+	if err != nil {
+		return err
+	}
+	if domain == config.DefaultDomain {
+		return fmt.Errorf("dns server: %s resolves without it; set domain in config.yml", domain)
+	}
+	gatewayAddr, err := cfg.GatewayAddress()
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(gatewayAddr)
+	if ip == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", gatewayAddr)
+		if err != nil {
+			return fmt.Errorf("dns server: resolving gateway address: %w", err)
+		}
+		ip = ips[0]
+	}
+
+	if err := dns.NewServer(domain, ip).ListenAndServe(ctx, addr); err != nil {
+		return err
+	}
+	w.logger.Info("DNS server started", "domain", "*"+domain, "ip", ip, "addr", addr)
+	return nil
+}
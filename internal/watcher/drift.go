@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// checkDrift runs repairDrift once every reconcileInterval while the
+// event stream is connected.
+func (w *Watcher) checkDrift(ctx context.Context, now time.Time) {
+	if w.reconcileInterval <= 0 || w.streamDown {
+		return
+	}
+	if w.lastReconcile.IsZero() {
+		w.lastReconcile = now
+		return
+	}
+	if now.Sub(w.lastReconcile) < w.reconcileInterval {
+		return
+	}
+	w.lastReconcile = now
+	w.repairDrift(ctx)
+}
+
+// repairDrift compares the routes with the running containers and fixes
+// what missed events left behind: routes of containers that are gone are
+// removed and running containers of adopted projects are routed.
+func (w *Watcher) repairDrift(ctx context.Context) {
+	containers, err := gateway.ListContainers(ctx, w.cli, container.ListOptions{})
+	if err != nil {
+		w.logger.Warn("Checking routes against containers failed", "err", err)
+		return
+	}
+	cfg, err := w.config.load()
+	if err != nil {
+		w.logger.Error("Loading config failed", "err", err)
+		return
+	}
+
+	running := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		running[c.ID] = true
+	}
+	for id, r := range w.routes.containerRoutes() {
+		if running[id] {
+			continue
+		}
+		if _, ok := w.stopping[id]; ok {
+			continue // removed once restartGrace is over
+		}
+		w.logger.Info("Drift: container no longer running, removing its route", "event", "drift_removed", "container", r.ContainerName, "id", shortID(id), "hostname", r.Hostname)
+		w.removeRoute(ctx, id)
+		w.metrics.driftRepairs.Add(1)
+	}
+
+	for _, c := range containers {
+		if _, ok := w.routes.Get(c.ID); ok || isGatewayContainer(c.Names) || config.IsIgnored(c.Labels) {
+			continue
+		}
+		if _, proj := cfg.FindRoutedProject(c.Labels["com.docker.compose.project"]); proj == nil {
+			continue
+		}
+		w.handleContainerStart(ctx, c.ID)
+		if _, ok := w.routes.Get(c.ID); ok {
+			name := c.ID
+			if len(c.Names) > 0 {
+				name = strings.TrimPrefix(c.Names[0], "/")
+			}
+			w.logger.Info("Drift: container was running without a route, added it", "event", "drift_added", "container", name, "id", shortID(c.ID))
+			w.metrics.driftRepairs.Add(1)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDrift_Interval(t *testing.T) {
+	now := time.Now()
+	w := &Watcher{routes: NewActiveRoutes()}
+
+	// Turned off: nothing is tracked.
+	w.checkDrift(t.Context(), now)
+	if !w.lastReconcile.IsZero() {
+		t.Fatal("drift checked with reconciliation off")
+	}
+
+	// The first tick starts the interval instead of checking at once.
+	w.reconcileInterval = time.Minute
+	w.checkDrift(t.Context(), now)
+	if !w.lastReconcile.Equal(now) {
+		t.Fatalf("lastReconcile = %v, want %v", w.lastReconcile, now)
+	}
+	w.checkDrift(t.Context(), now.Add(30*time.Second))
+	if !w.lastReconcile.Equal(now) {
+		t.Error("drift checked before the interval was over")
+	}
+
+	// Nothing is checked while the event stream reconnects.
+	w.streamDown = true
+	w.checkDrift(t.Context(), now.Add(2*time.Minute))
+	if !w.lastReconcile.Equal(now) {
+		t.Error("drift checked while the event stream was down")
+	}
+}
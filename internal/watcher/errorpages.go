@@ -0,0 +1,191 @@
+package watcher
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+//go:embed errorpage.html
+var errorPageTemplate string
+
+var errorPage = template.Must(template.New("errorpage").Parse(errorPageTemplate))
+
+// errorPagesDir is the directory of the error pages under
+// config.CaddyfileDir(), and so under gatewayConfigDir in the gateway.
+const errorPagesDir = "errors"
+
+// Error pages the gateway serves, for hostnames without a route and for
+// routes whose upstream doesn't answer.
+const (
+	unroutedPage = "unrouted.html"
+	downPage     = "down.html"
+)
+
+// unroutedAskAddress is where the gateway answers its own question whether
+// to issue a certificate for an unrouted hostname, inside its container.
+const unroutedAskAddress = "127.0.0.1:20191"
+
+// writeUnroutedSites renders the catch-all site serving the unrouted page,
+// with certificates issued on demand for hostnames under domains only, and
+// the endpoint the gateway asks before issuing one.
+func writeUnroutedSites(b *strings.Builder, domains []string) error {
+	conds := make([]string, len(domains))
+	for i, d := range domains {
+		if err := config.ValidateHostname(strings.TrimPrefix(d, ".")); err != nil {
+			return fmt.Errorf("error page domain: %w", err)
+		}
+		conds[i] = fmt.Sprintf("{query.domain}.endsWith(%q)", "."+strings.TrimPrefix(d, "."))
+	}
+
+	b.WriteString("\n# caddy-atc unrouted hostnames\nhttps:// {\n")
+	b.WriteString("    tls internal {\n        on_demand\n    }\n")
+	fmt.Fprintf(b, "    root * %s/%s\n", gatewayConfigDir, errorPagesDir)
+	fmt.Fprintf(b, "    rewrite * /%s\n", unroutedPage)
+	b.WriteString("    header Cache-Control no-store\n")
+	b.WriteString("    file_server {\n        status 404\n    }\n")
+	b.WriteString("}\n")
+
+	fmt.Fprintf(b, "\nhttp://%s {\n", unroutedAskAddress)
+	b.WriteString("    bind 127.0.0.1\n")
+	fmt.Fprintf(b, "    @atc_local expression `%s`\n", strings.Join(conds, " || "))
+	b.WriteString("    respond @atc_local 200\n")
+	b.WriteString("    respond 403\n")
+	b.WriteString("}\n")
+	return nil
+}
+
+// writeDownPage renders an error handler answering browsers with the down
+// page, keeping the status, while the upstream can't be reached. Other
+// clients get the plain error.
+func writeDownPage(b *strings.Builder) {
+	b.WriteString("    handle_errors {\n")
+	b.WriteString("        @atc_down {\n")
+	b.WriteString("            expression `{err.status_code} in [502, 503, 504]`\n")
+	b.WriteString("            header Accept *text/html*\n")
+	b.WriteString("        }\n")
+	b.WriteString("        handle @atc_down {\n")
+	fmt.Fprintf(b, "            root * %s/%s\n", gatewayConfigDir, errorPagesDir)
+	fmt.Fprintf(b, "            rewrite * /%s\n", downPage)
+	b.WriteString("            header Cache-Control no-store\n")
+	b.WriteString("            file_server {\n")
+	b.WriteString("                status {err.status_code}\n")
+	b.WriteString("            }\n")
+	b.WriteString("        }\n")
+	b.WriteString("    }\n")
+}
+
+// errorPageData is what an error page shows. The page is the same for
+// every hostname, so it carries hints for each and picks one in the
+// browser.
+type errorPageData struct {
+	Down      bool
+	Routes    []errorPageRoute
+	Dashboard string // URL; "" if it isn't served
+	Hints     errorHints
+}
+
+// errorPageRoute is a link to a served hostname.
+type errorPageRoute struct {
+	Hostname string
+	URL      string
+	Project  string
+}
+
+// errorHints explain why a hostname isn't served: by exact hostname, else
+// by the project hostname it is under (".app.localhost"), else Default.
+type errorHints struct {
+	Hosts    map[string]string `json:"hosts"`
+	Suffixes map[string]string `json:"suffixes"`
+	Default  string            `json:"default"`
+}
+
+// syncErrorPages writes the unrouted and down pages for routes into the
+// Caddyfile directory (mounted in the gateway), or removes them when error
+// pages are off.
+func syncErrorPages(routes *ActiveRoutes, cfg *config.Config) error {
+	dir := filepath.Join(config.CaddyfileDir(), errorPagesDir)
+	if len(routes.ErrorPages()) == 0 {
+		os.RemoveAll(dir)
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	_, httpsPort := cfg.HTTPPorts()
+	for name, down := range map[string]bool{unroutedPage: false, downPage: true} {
+		var out bytes.Buffer
+		if err := errorPage.Execute(&out, newErrorPageData(routes, cfg, httpsPort, down)); err != nil {
+			return fmt.Errorf("rendering %s: %w", name, err)
+		}
+		if err := atomicWriteFile(filepath.Join(dir, name), out.Bytes(), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newErrorPageData lists the served hostnames and explains why the
+// requested one isn't: for the down page, which containers don't answer;
+// otherwise whether its route is quarantined, its project disabled or
+// stopped, or not adopted at all.
+func newErrorPageData(routes *ActiveRoutes, cfg *config.Config, httpsPort int, down bool) errorPageData {
+	d := errorPageData{Down: down, Hints: errorHints{Hosts: map[string]string{}, Suffixes: map[string]string{}}}
+	if hostname, upstream := routes.Dashboard(); hostname != "" && upstream != "" {
+		d.Dashboard = config.SiteURL(hostname, httpsPort)
+	}
+
+	seen := make(map[string]bool)
+	containers := make(map[string][]string)
+	for _, r := range routes.All() {
+		if r.Quarantine != "" {
+			d.Hints.Hosts[r.Hostname] = fmt.Sprintf("caddy-atc excluded the route for %s from the gateway: %s. Fix the cause and restart the container.", r.ContainerName, r.Quarantine)
+			continue
+		}
+		if r.Root == "" {
+			containers[r.Hostname] = append(containers[r.Hostname], r.ContainerName)
+		}
+		if seen[r.Hostname] || strings.HasPrefix(r.Hostname, "*.") {
+			continue
+		}
+		seen[r.Hostname] = true
+		d.Routes = append(d.Routes, errorPageRoute{Hostname: r.Hostname, URL: config.SiteURL(r.Hostname, httpsPort), Project: r.Project})
+	}
+	sort.Slice(d.Routes, func(i, j int) bool { return d.Routes[i].Hostname < d.Routes[j].Hostname })
+
+	if down {
+		for hostname, names := range containers {
+			slices.Sort(names)
+			d.Hints.Hosts[hostname] = fmt.Sprintf("The gateway couldn't reach %s: the container is stopped, restarting or not listening on its port. Check it with 'docker ps' and 'caddy-atc logs'.", strings.Join(slices.Compact(names), ", "))
+		}
+		d.Hints.Default = "The gateway couldn't reach this hostname's container: it is stopped, restarting or not listening on its port."
+		return d
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Projects)) {
+		proj := cfg.Projects[name]
+		hint := fmt.Sprintf("This hostname belongs to project %s, but no running container serves it: the container is stopped. Start it with 'caddy-atc start' in %s.", name, proj.Dir)
+		if proj.Disabled {
+			hint = fmt.Sprintf("This hostname belongs to project %s, which is disabled. Route it again with 'caddy-atc enable %s'.", name, name)
+		}
+		for _, h := range append([]string{proj.Hostname}, slices.Collect(maps.Values(proj.Services))...) {
+			if h != "" && d.Hints.Hosts[h] == "" {
+				d.Hints.Hosts[h] = hint
+			}
+		}
+		if proj.Hostname != "" {
+			d.Hints.Suffixes["."+proj.Hostname] = hint
+		}
+	}
+	d.Hints.Default = "No adopted project uses this hostname: its project isn't adopted. Run 'caddy-atc adopt' in the project's directory, or check the hostname against the routes below."
+	return d
+}
@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestNewErrorPageData(t *testing.T) {
+	routes := quarantineRoutes()
+	routes.Add("c4", &Route{Hostname: "bad.localhost", ContainerName: "bad-1", Port: "80", Quarantine: "invalid snippet"})
+	routes.SetDashboard("atc.localhost", "172.18.0.1:41234")
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{
+		"shop": {Dir: "/home/dev/shop", Hostname: "shop.localhost", Services: map[string]string{"admin": "admin.localhost"}},
+		"old":  {Dir: "/home/dev/old", Hostname: "old.localhost", Disabled: true},
+	}}
+
+	d := newErrorPageData(routes, cfg, 443, false)
+	if d.Down || d.Dashboard != "https://atc.localhost" {
+		t.Errorf("newErrorPageData() = %+v", d)
+	}
+	var hostnames []string
+	for _, r := range d.Routes {
+		hostnames = append(hostnames, r.Hostname)
+	}
+	if got := strings.Join(hostnames, " "); got != "api.app.localhost app.localhost" {
+		t.Errorf("routes = %s, want served hostnames once each", got)
+	}
+	for host, want := range map[string]string{
+		"shop.localhost":  "container is stopped. Start it with 'caddy-atc start' in /home/dev/shop",
+		"admin.localhost": "project shop",
+		"old.localhost":   "'caddy-atc enable old'",
+		"bad.localhost":   "invalid snippet",
+	} {
+		if !strings.Contains(d.Hints.Hosts[host], want) {
+			t.Errorf("hint for %s = %q, want it to contain %q", host, d.Hints.Hosts[host], want)
+		}
+	}
+	if !strings.Contains(d.Hints.Suffixes[".shop.localhost"], "project shop") {
+		t.Errorf("suffix hints = %v", d.Hints.Suffixes)
+	}
+	if !strings.Contains(d.Hints.Default, "isn't adopted") {
+		t.Errorf("default hint = %q", d.Hints.Default)
+	}
+
+	d = newErrorPageData(routes, cfg, 443, true)
+	if got := d.Hints.Hosts["api.app.localhost"]; !strings.Contains(got, "couldn't reach app-api-1, app-api-2:") {
+		t.Errorf("down hint = %q, want both replicas", got)
+	}
+}
+
+func TestSyncErrorPages(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "</script>"})
+	routes.SetErrorPages([]string{config.DefaultDomain})
+	cfg := &config.Config{}
+	if err := syncErrorPages(routes, cfg); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(config.CaddyfileDir(), errorPagesDir)
+	unrouted, err := os.ReadFile(filepath.Join(dir, unroutedPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	down, err := os.ReadFile(filepath.Join(dir, downPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unrouted), "is not routed") || !strings.Contains(string(down), "is not answering") {
+		t.Error("pages don't say why the hostname isn't served")
+	}
+	if !strings.Contains(string(unrouted), `<a href="https://app.localhost">app.localhost</a>`) {
+		t.Errorf("unrouted page doesn't link the route:\n%s", unrouted)
+	}
+	if strings.Count(string(down), "</script>") != 1 {
+		t.Errorf("project name not escaped:\n%s", down)
+	}
+
+	routes.SetErrorPages(nil)
+	if err := syncErrorPages(routes, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("error pages kept after turning them off: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+	"gopkg.in/yaml.v3"
+)
+
+// Upstream health states.
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+const (
+	// healthInterval is how often upstreams are probed, by the watcher and
+	// by Caddy's active health checks.
+	healthInterval = 10 * time.Second
+
+	// healthGrace is how long a new upstream may fail probes while it is
+	// still starting before it is reported unhealthy.
+	healthGrace = time.Minute
+
+	// healthProbeTimeout bounds a single probe, in seconds as wget takes it.
+	healthProbeTimeout = 3
+)
+
+// probeStatus matches the HTTP status in busybox wget's error output, e.g.
+// "wget: server returned error: HTTP/1.1 404 Not Found".
+var probeStatus = regexp.MustCompile(`HTTP/[0-9.]+ (\d{3})`)
+
+// UpstreamHealth is the last known health of one upstream. Health states
+// are stored in a file so the CLI can show them without IPC.
+type UpstreamHealth struct {
+	Status string    `yaml:"status"`
+	Detail string    `yaml:"detail,omitempty"` // why the last probe failed
+	Since  time.Time `yaml:"since"`            // when Status last changed
+}
+
+// HealthStates maps an upstream ("container:port") to its health.
+type HealthStates map[string]UpstreamHealth
+
+// healthTracker turns probe results into health states.
+type healthTracker struct {
+	states    HealthStates
+	firstSeen map[string]time.Time
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{states: make(HealthStates), firstSeen: make(map[string]time.Time)}
+}
+
+// record applies a probe result for upstream and returns the previous and
+// new status. A failing upstream stays starting until it first passes or
+// healthGrace has elapsed since it was first probed.
+func (t *healthTracker) record(upstream string, ok bool, detail string, now time.Time) (prev, cur string) {
+	first, seen := t.firstSeen[upstream]
+	if !seen {
+		first = now
+		t.firstSeen[upstream] = now
+	}
+	state := t.states[upstream]
+	prev = state.Status
+
+	switch {
+	case ok:
+		cur, detail = HealthHealthy, ""
+	case (prev == "" || prev == HealthStarting) && now.Sub(first) < healthGrace:
+		cur = HealthStarting
+	default:
+		cur = HealthUnhealthy
+	}
+	if cur != prev {
+		state.Since = now
+	}
+	state.Status, state.Detail = cur, detail
+	t.states[upstream] = state
+	return prev, cur
+}
+
+// prune forgets upstreams that are no longer routed.
+func (t *healthTracker) prune(keep map[string]bool) {
+	for u := range t.states {
+		if !keep[u] {
+			delete(t.states, u)
+			delete(t.firstSeen, u)
+		}
+	}
+}
+
+// runHealthChecks probes every routed upstream each healthInterval until
+// ctx is done, recording the results for `caddy-atc routes` and `status`.
+func (w *Watcher) runHealthChecks(ctx context.Context) {
+	tracker := newHealthTracker()
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.checkHealth(ctx, tracker)
+		}
+	}
+}
+
+// checkHealth runs one round of probes. Probes are made from inside the
+// gateway, where container names resolve, so rounds are skipped while it
+// is stopped.
+func (w *Watcher) checkHealth(ctx context.Context, tracker *healthTracker) {
+	if !w.gatewayRunning(ctx) {
+		return
+	}
+	probed := make(map[string]bool)
+	for _, r := range w.routes.All() {
+		upstream := r.ContainerName + ":" + r.Port
+		if r.Quarantine != "" || r.Root != "" || probed[upstream] {
+			continue
+		}
+		// gRPC servers don't answer plain HTTP/1.1 requests.
+		if r.Protocol == config.ProtocolGRPC && r.HealthPath == "" {
+			continue
+		}
+		probed[upstream] = true
+
+		ok, detail := probeUpstream(ctx, r)
+		if ctx.Err() != nil {
+			return
+		}
+		prev, cur := tracker.record(upstream, ok, detail, time.Now())
+		if cur != prev {
+			if err := w.meta.setHealth(upstream, cur); err != nil {
+				w.logger.Warn("Saving route metadata failed", "err", err)
+			}
+		}
+		switch {
+		case cur == HealthUnhealthy && prev != HealthUnhealthy:
+			w.logger.Warn("Upstream unhealthy", "event", "upstream_unhealthy", "upstream", upstream, "hostname", r.Hostname, "detail", detail)
+		case cur == HealthHealthy && prev == HealthUnhealthy:
+			w.logger.Info("Upstream healthy again", "event", "upstream_healthy", "upstream", upstream, "hostname", r.Hostname)
+		}
+	}
+	tracker.prune(probed)
+
+	if ctx.Err() != nil {
+		return // the watcher is stopping and clears the states
+	}
+	if err := saveHealth(tracker.states); err != nil {
+		w.logger.Warn("Saving health states failed", "err", err)
+	}
+}
+
+// probeUpstream requests the route's health path, or "/", from inside the
+// gateway.
+func probeUpstream(ctx context.Context, r *Route) (bool, string) {
+	scheme := r.upstreamScheme()
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := r.HealthPath
+	if path == "" {
+		path = "/"
+	}
+	url := scheme + "://" + r.ContainerName + ":" + r.Port + path
+
+	ctx, cancel := context.WithTimeout(ctx, 2*healthProbeTimeout*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", "exec", gateway.ContainerName,
+		"wget", "-q", "-T", strconv.Itoa(healthProbeTimeout), "--no-check-certificate", "-O", "/dev/null", url)
+	output, err := cmd.CombinedOutput()
+	return classifyProbe(string(output), err, r.HealthPath != "")
+}
+
+// classifyProbe interprets a wget probe. With a declared health path only
+// a 2xx answer (after redirects) is healthy; without one, any response
+// below 500 shows the server is up.
+func classifyProbe(output string, err error, strict bool) (bool, string) {
+	if err == nil {
+		return true, ""
+	}
+	if m := probeStatus.FindStringSubmatch(output); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		if !strict && code < 500 {
+			return true, ""
+		}
+		return false, "HTTP " + m[1]
+	}
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); lines[len(lines)-1] != "" {
+		return false, strings.TrimPrefix(lines[len(lines)-1], "wget: ")
+	}
+	return false, err.Error()
+}
+
+// saveHealth writes the health states, removing the file when there are none.
+func saveHealth(states HealthStates) error {
+	if len(states) == 0 {
+		return clearHealth()
+	}
+	data, err := yaml.Marshal(states)
+	if err != nil {
+		return fmt.Errorf("marshaling health states: %w", err)
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	return atomicWriteFile(config.HealthStatePath(), data, 0600)
+}
+
+// clearHealth removes the health states.
+func clearHealth() error {
+	if err := os.Remove(config.HealthStatePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing health states: %w", err)
+	}
+	return nil
+}
+
+// LoadHealth returns the upstream health states recorded by the running
+// watcher, or nil if there are none.
+func LoadHealth() HealthStates {
+	data, err := os.ReadFile(config.HealthStatePath())
+	if err != nil {
+		return nil
+	}
+	var states HealthStates
+	if err := yaml.Unmarshal(data, &states); err != nil {
+		return nil
+	}
+	return states
+}
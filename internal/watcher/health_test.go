@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthTracker(t *testing.T) {
+	tr := newHealthTracker()
+	start := time.Now()
+
+	if _, cur := tr.record("app-web-1:80", false, "connection refused", start); cur != HealthStarting {
+		t.Errorf("first failure = %q, want starting", cur)
+	}
+	if _, cur := tr.record("app-web-1:80", false, "connection refused", start.Add(healthGrace)); cur != HealthUnhealthy {
+		t.Errorf("failure after grace = %q, want unhealthy", cur)
+	}
+	if tr.states["app-web-1:80"].Detail != "connection refused" {
+		t.Errorf("detail = %q", tr.states["app-web-1:80"].Detail)
+	}
+	prev, cur := tr.record("app-web-1:80", true, "", start.Add(healthGrace+time.Second))
+	if prev != HealthUnhealthy || cur != HealthHealthy {
+		t.Errorf("recovery = %q -> %q, want unhealthy -> healthy", prev, cur)
+	}
+	// Once healthy, a failure is reported at once, without a grace period.
+	if _, cur := tr.record("app-web-1:80", false, "HTTP 503", start.Add(healthGrace+2*time.Second)); cur != HealthUnhealthy {
+		t.Errorf("failure after healthy = %q, want unhealthy", cur)
+	}
+
+	tr.record("app-api-1:8080", true, "", start)
+	tr.prune(map[string]bool{"app-api-1:8080": true})
+	if _, ok := tr.states["app-web-1:80"]; ok {
+		t.Error("prune kept an upstream that is no longer routed")
+	}
+	if _, ok := tr.states["app-api-1:8080"]; !ok {
+		t.Error("prune dropped a routed upstream")
+	}
+}
+
+func TestClassifyProbe(t *testing.T) {
+	exit1 := errors.New("exit status 1")
+	tests := []struct {
+		name       string
+		output     string
+		err        error
+		strict     bool
+		wantOK     bool
+		wantDetail string
+	}{
+		{"success", "", nil, true, true, ""},
+		{"404 without health path", "wget: server returned error: HTTP/1.1 404 Not Found\n", exit1, false, true, ""},
+		{"404 with health path", "wget: server returned error: HTTP/1.1 404 Not Found\n", exit1, true, false, "HTTP 404"},
+		{"502 without health path", "wget: server returned error: HTTP/1.1 502 Bad Gateway\n", exit1, false, false, "HTTP 502"},
+		{"refused", "wget: can't connect to remote host (172.18.0.3): Connection refused\n", exit1, false, false, "can't connect to remote host (172.18.0.3): Connection refused"},
+		{"no output", "", exit1, false, false, "exit status 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, detail := classifyProbe(tt.output, tt.err, tt.strict)
+			if ok != tt.wantOK || detail != tt.wantDetail {
+				t.Errorf("classifyProbe() = %v, %q, want %v, %q", ok, detail, tt.wantOK, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestSaveLoadHealth(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if LoadHealth() != nil {
+		t.Fatal("expected no health states before saving")
+	}
+	states := HealthStates{"app-web-1:80": {Status: HealthUnhealthy, Detail: "HTTP 502", Since: time.Now().UTC().Truncate(time.Second)}}
+	if err := saveHealth(states); err != nil {
+		t.Fatalf("saveHealth() error = %v", err)
+	}
+	got := LoadHealth()
+	if got["app-web-1:80"] != states["app-web-1:80"] {
+		t.Errorf("LoadHealth() = %+v, want %+v", got, states)
+	}
+
+	if err := saveHealth(nil); err != nil {
+		t.Fatal(err)
+	}
+	if LoadHealth() != nil {
+		t.Error("expected saving no states to remove the file")
+	}
+}
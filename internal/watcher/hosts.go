@@ -0,0 +1,48 @@
+package watcher
+
+import "github.com/g-brodiei/caddy-atc/internal/hosts"
+
+// syncHosts updates the hosts files' caddy-atc block when auto sync is
+// enabled. sudo is never prompted for, and a failure is logged once until
+// it changes.
+func (w *Watcher) syncHosts() {
+	if !w.hostsSync {
+		return
+	}
+	cfg, err := w.config.load()
+	if err != nil {
+		w.logger.Warn("Loading config failed", "err", err)
+		return
+	}
+	addr, err := hosts.Address(cfg)
+	if err != nil {
+		w.logHostsErr(err)
+		return
+	}
+
+	var active []string
+	for _, r := range w.routes.All() {
+		active = append(active, r.Hostname)
+		active = append(active, r.redirectHosts()...)
+	}
+	block := hosts.Block(addr, hosts.Hostnames(cfg, active))
+	if block == w.hostsBlock {
+		return
+	}
+	changed, err := hosts.Sync(hosts.Files(), block, false, false)
+	for _, path := range changed {
+		w.logger.Info("Updated caddy-atc hostnames", "event", "hosts_synced", "path", path)
+	}
+	if err != nil {
+		w.logHostsErr(err)
+		return
+	}
+	w.hostsBlock, w.hostsErr = block, ""
+}
+
+func (w *Watcher) logHostsErr(err error) {
+	if err.Error() != w.hostsErr {
+		w.hostsErr = err.Error()
+		w.logger.Warn("Syncing hosts files failed", "err", err)
+	}
+}
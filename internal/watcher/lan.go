@@ -0,0 +1,46 @@
+package watcher
+
+import (
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/lan"
+)
+
+// lanInterval is how often LAN mode re-detects the LAN IP, which changes
+// when the machine moves between networks.
+const lanInterval = 30 * time.Second
+
+// checkLAN updates the LAN addresses the gateway serves when LAN mode
+// settings changed or lanInterval has passed. Returns true if the
+// Caddyfile needs regenerating.
+func (w *Watcher) checkLAN() bool {
+	if !w.lanDirty && time.Since(w.lanChecked) < lanInterval {
+		return false
+	}
+	w.lanDirty = false
+	w.lanChecked = time.Now()
+
+	var names []string
+	if w.lanEnabled {
+		var err error
+		if names, err = lan.Names(w.lanName); err != nil {
+			if msg := err.Error(); msg != w.lanErr {
+				w.lanErr = msg
+				w.logger.Warn("LAN mode unavailable", "err", err)
+			}
+			names = nil
+		} else {
+			w.lanErr = ""
+		}
+	}
+	if !w.routes.SetLAN(names) {
+		return false
+	}
+	if len(names) > 0 {
+		w.logger.Info("LAN mode on", "event", "lan_on", "names", strings.Join(names, ","))
+	} else if !w.lanEnabled {
+		w.logger.Info("LAN mode off", "event", "lan_off")
+	}
+	return true
+}
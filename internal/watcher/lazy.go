@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// lazyReload handles a reload while the lazy gateway is not running: with
+// routes it starts the gateway, which loads the freshly written Caddyfile
+// itself; without routes there is nothing to serve and it stays stopped.
+// It reports whether the reload was handled.
+func (w *Watcher) lazyReload(ctx context.Context) (bool, error) {
+	if w.gatewayRunning(ctx) {
+		return false, nil
+	}
+	if w.routes.Len() == 0 {
+		return true, nil
+	}
+
+	w.logger.Info("Starting gateway", "event", "gateway_start", "routes", w.routes.Len())
+	if err := gateway.Up(ctx); err != nil {
+		return true, fmt.Errorf("starting gateway: %w", err)
+	}
+	if err := w.waitForGatewayReady(ctx); err != nil {
+		return true, fmt.Errorf("waiting for gateway: %w", err)
+	}
+	return true, nil
+}
+
+// checkIdle stops a lazy gateway once it has had no routes for the idle
+// timeout. Static routes count as routes, so they keep the gateway up.
+func (w *Watcher) checkIdle(ctx context.Context) {
+	if !w.lazy || w.opts.Observe || w.paused {
+		return
+	}
+	if w.routes.Len() > 0 {
+		w.idleSince = time.Time{}
+		return
+	}
+	now := time.Now()
+	if w.idleSince.IsZero() {
+		w.idleSince = now
+		return
+	}
+	if now.Sub(w.idleSince) < w.idleTimeout {
+		return
+	}
+	w.idleSince = now
+
+	if !w.gatewayRunning(ctx) {
+		return
+	}
+	w.logger.Info("No routes, stopping gateway", "event", "gateway_stop", "idle", w.idleTimeout)
+	if err := gateway.Down(ctx); err != nil {
+		w.logger.Error("Stopping gateway failed", "err", err)
+	}
+}
+
+func (w *Watcher) gatewayRunning(ctx context.Context) bool {
+	info, err := gateway.InspectContainer(ctx, w.cli, gateway.ContainerName)
+	return err == nil && info.State != nil && info.State.Running
+}
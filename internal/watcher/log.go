@@ -0,0 +1,45 @@
+package watcher
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats accepted by NewLogger.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogLevels lists the levels accepted by NewLogger, most verbose first.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// NewLogger returns a logger for the watcher writing records of level and
+// above to out, as logfmt-style text or as one JSON object per line.
+// Records carry attributes such as event, container, project and hostname,
+// so the JSON form can be filtered with jq.
+func NewLogger(out io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "info", "":
+		lvl = slog.LevelInfo
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil, fmt.Errorf("invalid log level %q (want one of %s)", level, strings.Join(LogLevels, ", "))
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case LogFormatText, "":
+		return slog.New(slog.NewTextHandler(out, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (want %s or %s)", format, LogFormatText, LogFormatJSON)
+}
@@ -0,0 +1,47 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "warn", LogFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Route added", "event", "route_added")
+	logger.Warn("No HTTP port detected, skipping", "container", "app-worker-1", "project", "app")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d records at warn level, want 1:\n%s", len(lines), buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("record is not JSON: %v", err)
+	}
+	if rec["level"] != "WARN" || rec["container"] != "app-worker-1" || rec["project"] != "app" {
+		t.Errorf("record = %v", rec)
+	}
+
+	buf.Reset()
+	logger, err = NewLogger(&buf, "DEBUG", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Routing config changed")
+	if !strings.Contains(buf.String(), `level=DEBUG msg="Routing config changed"`) {
+		t.Errorf("text log = %q", buf.String())
+	}
+
+	if _, err := NewLogger(&buf, "verbose", LogFormatText); err == nil {
+		t.Error("NewLogger() accepted an invalid level")
+	}
+	if _, err := NewLogger(&buf, "info", "xml"); err == nil {
+		t.Error("NewLogger() accepted an invalid format")
+	}
+}
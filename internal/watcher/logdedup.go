@@ -0,0 +1,170 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupFlush is how long repeats of a message are held back before the
+// "last message repeated" record is written, if no other message comes
+// first.
+const dedupFlush = 30 * time.Second
+
+// dedupHandler collapses consecutive identical log records into one
+// "last message repeated" record with a times attribute, as syslog does.
+// Handlers derived with WithAttrs or WithGroup share the state, so records
+// are compared across them.
+type dedupHandler struct {
+	next  slog.Handler
+	scope string // the attributes and groups added to next, for recordKey
+	state *dedupState
+}
+
+type dedupState struct {
+	mu    sync.Mutex
+	last  string       // key of the last record written
+	rec   slog.Record  // the last record written
+	out   slog.Handler // the handler that wrote it
+	count int
+	since time.Time
+}
+
+// newDedupLogger returns a logger writing through a dedupHandler to the
+// handler of out.
+func newDedupLogger(out *slog.Logger) (*slog.Logger, *dedupHandler) {
+	d := &dedupHandler{next: out.Handler(), state: &dedupState{}}
+	return slog.New(d), d
+}
+
+func (d *dedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return d.next.Enabled(ctx, level)
+}
+
+func (d *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scope := d.scope
+	for _, a := range attrs {
+		scope += "\x00" + a.String()
+	}
+	return &dedupHandler{next: d.next.WithAttrs(attrs), scope: scope, state: d.state}
+}
+
+func (d *dedupHandler) WithGroup(name string) slog.Handler {
+	return &dedupHandler{next: d.next.WithGroup(name), scope: d.scope + "\x00" + name + ".", state: d.state}
+}
+
+func (d *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	key := d.scope + "\x01" + recordKey(r)
+	s := d.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key == s.last {
+		if s.count == 0 {
+			s.since = r.Time
+		}
+		s.count++
+		return nil
+	}
+	s.flushLocked(ctx, r.Time)
+	s.last, s.rec, s.out = key, r.Clone(), d.next
+	return d.next.Handle(ctx, r)
+}
+
+// recordKey identifies a record by everything but its time.
+func recordKey(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(r.Level.String())
+	b.WriteString("\x00")
+	b.WriteString(r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		b.WriteString("\x00")
+		b.WriteString(a.String())
+		return true
+	})
+	return b.String()
+}
+
+// flushStale writes the repeat count of a message repeated for at least
+// dedupFlush, so a flood that stops is still accounted for.
+func (d *dedupHandler) flushStale(now time.Time) {
+	s := d.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count > 0 && now.Sub(s.since) >= dedupFlush {
+		s.flushLocked(context.Background(), now)
+		s.last, s.out = "", nil
+	}
+}
+
+func (s *dedupState) flushLocked(ctx context.Context, now time.Time) {
+	switch s.count {
+	case 0:
+	case 1:
+		r := s.rec.Clone()
+		r.Time = now
+		s.out.Handle(ctx, r)
+	default:
+		r := slog.NewRecord(now, s.rec.Level, "last message repeated", 0)
+		r.AddAttrs(slog.Int("times", s.count))
+		s.out.Handle(ctx, r)
+	}
+	s.count = 0
+}
+
+// warnInterval is how often an identical warning about one container is
+// logged, so a crash-looping container doesn't flood the log.
+const warnInterval = 5 * time.Minute
+
+// warnState tracks a warning for rate limiting.
+type warnState struct {
+	logged     time.Time
+	seen       time.Time
+	suppressed int
+}
+
+// warn logs a warning about container with the attributes in args, unless
+// the same warning about it was logged within warnInterval. The next time
+// it is logged, it notes how often it was suppressed.
+func (w *Watcher) warn(container, msg string, args ...any) {
+	w.warnAt(time.Now(), container, msg, args...)
+}
+
+func (w *Watcher) warnAt(now time.Time, container, msg string, args ...any) {
+	key := container + "\x00" + msg + "\x00" + fmt.Sprint(args...)
+
+	w.warnMu.Lock()
+	if w.warned == nil {
+		w.warned = make(map[string]*warnState)
+	}
+	st := w.warned[key]
+	if st != nil && now.Sub(st.logged) < warnInterval {
+		st.suppressed++
+		st.seen = now
+		w.warnMu.Unlock()
+		return
+	}
+	if container != "" {
+		args = append([]any{"container", strings.TrimPrefix(container, "/")}, args...)
+	}
+	if st != nil && st.suppressed > 0 {
+		args = append(args, "repeated", st.suppressed, "over", now.Sub(st.logged).Round(time.Second))
+	}
+	w.pruneWarnings(now)
+	w.warned[key] = &warnState{logged: now, seen: now}
+	w.warnMu.Unlock()
+
+	w.logger.Warn(msg, args...)
+}
+
+// pruneWarnings forgets warnings that haven't recurred for warnInterval,
+// such as those about removed containers.
+func (w *Watcher) pruneWarnings(now time.Time) {
+	for key, st := range w.warned {
+		if now.Sub(st.seen) >= warnInterval {
+			delete(w.warned, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLogger returns a text logger writing to buf without timestamps.
+func testLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func TestDedupHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger, dedup := newDedupLogger(testLogger(&buf))
+
+	for i := 0; i < 42; i++ {
+		logger.Info("Container started", "container", "app-web-1")
+	}
+	logger.Info("Route added")
+	logger.Info("Route removed")
+	logger.Info("Route removed")
+	logger.Warn("Route removed")
+	logger.Info("Route added", "hostname", "a.localhost")
+	logger.Info("Route added", "hostname", "b.localhost")
+
+	want := `level=INFO msg="Container started" container=app-web-1
+level=INFO msg="last message repeated" times=41
+level=INFO msg="Route added"
+level=INFO msg="Route removed"
+level=INFO msg="Route removed"
+level=WARN msg="Route removed"
+level=INFO msg="Route added" hostname=a.localhost
+level=INFO msg="Route added" hostname=b.localhost
+`
+	if got := buf.String(); got != want {
+		t.Errorf("log =\n%s\nwant\n%s", got, want)
+	}
+
+	// Records from derived loggers are compared with the shared state, and
+	// a single repeat is written as the record itself.
+	buf.Reset()
+	logger.With("project", "app").Info("Route added", "hostname", "b.localhost")
+	logger.With("project", "app").Info("Route added", "hostname", "b.localhost")
+	logger.Info("Route added")
+	want = `level=INFO msg="Route added" project=app hostname=b.localhost
+level=INFO msg="Route added" project=app hostname=b.localhost
+level=INFO msg="Route added"
+`
+	if got := buf.String(); got != want {
+		t.Errorf("log =\n%s\nwant\n%s", got, want)
+	}
+
+	buf.Reset()
+	logger.Info("Route added")
+	logger.Info("Route added")
+	dedup.flushStale(time.Now())
+	if buf.Len() != 0 {
+		t.Errorf("flushStale() wrote %q before dedupFlush", buf.String())
+	}
+	buf.Reset()
+	dedup.flushStale(time.Now().Add(dedupFlush))
+	if got := buf.String(); got != "level=INFO msg=\"last message repeated\" times=2\n" {
+		t.Errorf("flushStale() wrote %q", got)
+	}
+	buf.Reset()
+	logger.Info("Route added")
+	if got := buf.String(); got != "level=INFO msg=\"Route added\"\n" {
+		t.Errorf("after flushStale, log = %q, want the message again", got)
+	}
+}
+
+func TestWarnRateLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := &Watcher{logger: testLogger(&buf)}
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		w.warnAt(start.Add(time.Duration(i)*time.Second), "/app-web-1", "No HTTP port detected", "service", "web")
+	}
+	w.warnAt(start, "/app-worker-1", "No HTTP port detected", "service", "worker")
+	w.warnAt(start.Add(warnInterval), "/app-web-1", "No HTTP port detected", "service", "web")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`level=WARN msg="No HTTP port detected" container=app-web-1 service=web`,
+		`level=WARN msg="No HTTP port detected" container=app-worker-1 service=worker`,
+		`level=WARN msg="No HTTP port detected" container=app-web-1 service=web repeated=4 over=5m0s`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("log =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Warnings that stopped recurring are forgotten.
+	w.warnAt(start.Add(3*warnInterval), "/other", "x")
+	for key := range w.warned {
+		if strings.HasPrefix(key, "/app-worker-1\x00") {
+			t.Error("stale warning was not pruned")
+		}
+	}
+}
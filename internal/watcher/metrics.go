@@ -0,0 +1,170 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/history"
+)
+
+// metrics are the watcher's counters, exposed in the Prometheus text
+// format when the metrics endpoint is enabled.
+type metrics struct {
+	routesAdded    atomic.Uint64
+	routesRemoved  atomic.Uint64
+	reloads        atomic.Uint64
+	reloadFailures atomic.Uint64
+	events         atomic.Uint64
+	eventLag       atomic.Int64 // nanoseconds, of the last Docker event
+	driftRepairs   atomic.Uint64
+	proxyErrors    atomic.Uint64
+}
+
+// observeEvent records a Docker event emitted at unix nanosecond timeNano.
+func (m *metrics) observeEvent(timeNano int64, now time.Time) {
+	m.events.Add(1)
+	if timeNano > 0 {
+		m.eventLag.Store(max(now.UnixNano()-timeNano, 0))
+	}
+}
+
+// reloadCaddy applies the Caddyfile to the gateway, counting the attempt
+// for metrics and recording it in the history.
+func (w *Watcher) reloadCaddy(ctx context.Context) error {
+	w.metrics.reloads.Add(1)
+	start := time.Now()
+	err := w.updateCaddy(ctx)
+	e := history.Event{Time: start.UTC(), Type: history.Reload, Duration: time.Since(start)}
+	if err != nil {
+		w.metrics.reloadFailures.Add(1)
+		e.Type, e.Error = history.ReloadFailed, err.Error()
+	}
+	w.record(e)
+	return err
+}
+
+// routeAdded counts and records a route the watcher added.
+func (w *Watcher) routeAdded(r *Route) {
+	w.metrics.routesAdded.Add(1)
+	w.recordRoute(history.RouteAdded, r)
+}
+
+// routeRemoved counts and records a route the watcher removed.
+func (w *Watcher) routeRemoved(r *Route) {
+	w.metrics.routesRemoved.Add(1)
+	w.recordRoute(history.RouteRemoved, r)
+}
+
+func (w *Watcher) recordRoute(typ string, r *Route) {
+	w.record(history.Event{
+		Time:      time.Now().UTC(),
+		Type:      typ,
+		Hostname:  r.Hostname,
+		Container: r.ContainerName,
+		Port:      r.Port,
+		Project:   r.Project,
+	})
+}
+
+// record appends an event to the history. Observe mode changes nothing,
+// so it records nothing either.
+func (w *Watcher) record(e history.Event) {
+	if w.opts.Observe {
+		return
+	}
+	if err := history.Record(e); err != nil {
+		w.warn("", "Metrics unavailable", "err", err)
+	}
+}
+
+// startMetrics starts the metrics endpoint if projects.yml enables it.
+// Observe mode leaves it to the watcher whose routes are live. Failing to
+// start it doesn't stop the watcher.
+func (w *Watcher) startMetrics(ctx context.Context) {
+	if w.opts.Observe {
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		w.logger.Warn("Loading config failed", "err", err)
+		return
+	}
+	addr, err := cfg.MetricsListen()
+	if err == nil && addr != "" {
+		err = w.serveMetrics(ctx, addr)
+	}
+	if err != nil {
+		w.logger.Warn("Metrics endpoint unavailable", "err", err)
+	}
+}
+
+// serveMetrics serves /metrics on addr until ctx is done.
+func (w *Watcher) serveMetrics(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics endpoint: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.writeMetrics(rw, w.gatewayRunning(r.Context()))
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.logger.Warn("Metrics endpoint stopped", "err", err)
+		}
+	}()
+	w.logger.Info("Serving metrics", "url", "http://"+ln.Addr().String()+"/metrics")
+	return nil
+}
+
+// writeMetrics writes every metric in the Prometheus text format.
+func (w *Watcher) writeMetrics(out io.Writer, gatewayUp bool) {
+	active, quarantined := 0, 0
+	for _, r := range w.routes.All() {
+		if r.Quarantine != "" {
+			quarantined++
+		} else {
+			active++
+		}
+	}
+	up := 0
+	if gatewayUp {
+		up = 1
+	}
+
+	gauge := func(name, help string, v any) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, v)
+	}
+	counter := func(name, help string, v uint64) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+
+	gauge("caddy_atc_routes_active", "Routes served by the gateway.", active)
+	gauge("caddy_atc_routes_quarantined", "Routes excluded from the Caddyfile.", quarantined)
+	fmt.Fprintf(out, "# HELP caddy_atc_route_events_total Container routes added and removed.\n# TYPE caddy_atc_route_events_total counter\n")
+	fmt.Fprintf(out, "caddy_atc_route_events_total{action=\"add\"} %d\n", w.metrics.routesAdded.Load())
+	fmt.Fprintf(out, "caddy_atc_route_events_total{action=\"remove\"} %d\n", w.metrics.routesRemoved.Load())
+	counter("caddy_atc_reloads_total", "Caddy config reloads attempted.", w.metrics.reloads.Load())
+	counter("caddy_atc_reload_failures_total", "Caddy config reloads that failed.", w.metrics.reloadFailures.Load())
+	counter("caddy_atc_docker_events_total", "Docker container events received.", w.metrics.events.Load())
+	counter("caddy_atc_drift_repairs_total", "Routes added or removed by reconciliation after a missed event.", w.metrics.driftRepairs.Load())
+	counter("caddy_atc_proxy_errors_total", "Requests to routes the gateway failed to proxy (502-504).", w.metrics.proxyErrors.Load())
+	gauge("caddy_atc_docker_event_lag_seconds", "Delay between Docker emitting the last event and the watcher receiving it.",
+		time.Duration(w.metrics.eventLag.Load()).Seconds())
+	gauge("caddy_atc_gateway_up", "Whether the gateway container is running.", up)
+}
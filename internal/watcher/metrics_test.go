@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	w := &Watcher{routes: quarantineRoutes()}
+	w.routes.Quarantine("api.app.localhost", "bad option")
+	w.metrics.routesAdded.Add(3)
+	w.metrics.routesRemoved.Add(1)
+	w.metrics.reloads.Add(4)
+	w.metrics.reloadFailures.Add(2)
+	w.metrics.driftRepairs.Add(1)
+	w.metrics.proxyErrors.Add(5)
+
+	now := time.Now()
+	w.metrics.observeEvent(now.Add(-250*time.Millisecond).UnixNano(), now)
+
+	var out bytes.Buffer
+	w.writeMetrics(&out, true)
+	got := out.String()
+	for _, want := range []string{
+		"# TYPE caddy_atc_routes_active gauge\ncaddy_atc_routes_active 1\n",
+		"caddy_atc_routes_quarantined 2\n",
+		`caddy_atc_route_events_total{action="add"} 3`,
+		`caddy_atc_route_events_total{action="remove"} 1`,
+		"# TYPE caddy_atc_reloads_total counter\ncaddy_atc_reloads_total 4\n",
+		"caddy_atc_reload_failures_total 2\n",
+		"caddy_atc_docker_events_total 1\n",
+		"caddy_atc_drift_repairs_total 1\n",
+		"caddy_atc_proxy_errors_total 5\n",
+		"caddy_atc_docker_event_lag_seconds 0.25\n",
+		"caddy_atc_gateway_up 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestObserveEvent_ClockSkew(t *testing.T) {
+	var m metrics
+	now := time.Now()
+	m.observeEvent(now.Add(time.Second).UnixNano(), now)
+	if lag := m.eventLag.Load(); lag != 0 {
+		t.Errorf("eventLag = %d for an event from the future, want 0", lag)
+	}
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// PauseState describes why routing is paused. It is stored in the pause
+// marker file so the watcher and CLI can share it without IPC.
+type PauseState struct {
+	Reason string    `yaml:"reason"`
+	PID    int       `yaml:"pid,omitempty"` // owning process; 0 = until resumed
+	Since  time.Time `yaml:"since"`
+
+	// Maintenance has the gateway answer every routed hostname with a 503
+	// maintenance page until routing is resumed.
+	Maintenance bool `yaml:"maintenance,omitempty"`
+}
+
+// Pause suspends Caddyfile writes and reloads. The watcher keeps tracking
+// container events and applies a single consolidated update on resume.
+// If ownerPID is non-zero, the pause is treated as stale once that process
+// exits, so a crashed `caddy-atc start` can't leave routing paused forever.
+func Pause(reason string, ownerPID int) error {
+	return writePause(&PauseState{Reason: reason, PID: ownerPID, Since: time.Now()})
+}
+
+// PauseForMaintenance pauses routing until resumed, with the gateway
+// serving a 503 maintenance page on every routed hostname meanwhile.
+func PauseForMaintenance(reason string) error {
+	return writePause(&PauseState{Reason: reason, Since: time.Now(), Maintenance: true})
+}
+
+func writePause(st *PauseState) error {
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("marshaling pause state: %w", err)
+	}
+	return os.WriteFile(config.PausePath(), data, 0600)
+}
+
+// Resume removes the pause marker. The watcher notices on its next control
+// tick and reloads if any changes were deferred.
+func Resume() error {
+	if err := os.Remove(config.PausePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing pause marker: %w", err)
+	}
+	return nil
+}
+
+// CurrentPause returns the active pause state, or nil if routing is not
+// paused. A pause owned by a process that no longer exists is ignored.
+func CurrentPause() *PauseState {
+	data, err := os.ReadFile(config.PausePath())
+	if err != nil {
+		return nil
+	}
+	var st PauseState
+	if err := yaml.Unmarshal(data, &st); err != nil {
+		// Unparseable marker still counts as paused; `resume` clears it.
+		return &PauseState{Reason: "unknown"}
+	}
+	if st.PID > 0 && !config.ProcessAlive(st.PID) {
+		return nil
+	}
+	return &st
+}
@@ -0,0 +1,144 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestPauseResume(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if st := CurrentPause(); st != nil {
+		t.Fatalf("CurrentPause() = %+v before Pause, want nil", st)
+	}
+
+	if err := Pause("bulk restart", 0); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	st := CurrentPause()
+	if st == nil {
+		t.Fatal("CurrentPause() = nil after Pause, want state")
+	}
+	if st.Reason != "bulk restart" {
+		t.Errorf("Reason = %q, want %q", st.Reason, "bulk restart")
+	}
+
+	if err := Resume(); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if st := CurrentPause(); st != nil {
+		t.Errorf("CurrentPause() = %+v after Resume, want nil", st)
+	}
+
+	// Resuming when not paused is a no-op
+	if err := Resume(); err != nil {
+		t.Errorf("Resume() when not paused error = %v", err)
+	}
+}
+
+func TestCurrentPause_StaleOwner(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// PID that almost certainly doesn't exist
+	if err := Pause("caddy-atc start demo", 9999999); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if st := CurrentPause(); st != nil {
+		t.Errorf("CurrentPause() = %+v, want nil for dead owner", st)
+	}
+
+	if err := Pause("caddy-atc start demo", os.Getpid()); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if st := CurrentPause(); st == nil {
+		t.Error("CurrentPause() = nil, want state for live owner")
+	}
+}
+
+func TestCurrentPause_CorruptMarker(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := config.EnsureHomeDir(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.PausePath(), []byte("{not yaml"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if st := CurrentPause(); st == nil {
+		t.Error("CurrentPause() = nil for corrupt marker, want paused")
+	}
+}
+
+func TestSyncPause_Maintenance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var buf bytes.Buffer
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web"})
+	w := &Watcher{routes: routes, logger: slog.New(slog.NewTextHandler(&buf, nil)), opts: Options{Observe: true}}
+	ctx := context.Background()
+
+	if err := PauseForMaintenance("maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	w.syncPause(ctx)
+	if !w.paused || !routes.Maintenance() {
+		t.Fatalf("paused = %v, maintenance = %v after a maintenance pause", w.paused, routes.Maintenance())
+	}
+	if !strings.Contains(buf.String(), "caddy-atc: app.localhost is down for maintenance") {
+		t.Errorf("maintenance page not applied at once:\n%s", buf.String())
+	}
+
+	// Switching to a plain pause restores the proxies as of the pause.
+	buf.Reset()
+	if err := Pause("paused by user", 0); err != nil {
+		t.Fatal(err)
+	}
+	w.syncPause(ctx)
+	if routes.Maintenance() || !strings.Contains(buf.String(), "reverse_proxy app-web-1:3000") {
+		t.Errorf("plain pause kept the maintenance page:\n%s", buf.String())
+	}
+
+	if err := PauseForMaintenance("maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	w.syncPause(ctx)
+	buf.Reset()
+	if err := Resume(); err != nil {
+		t.Fatal(err)
+	}
+	w.syncPause(ctx)
+	if w.paused || routes.Maintenance() || !strings.Contains(buf.String(), "reverse_proxy app-web-1:3000") {
+		t.Errorf("resume didn't restore the routes:\n%s", buf.String())
+	}
+}
+
+func TestSyncPause_ResumeBetweenTicks(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var buf bytes.Buffer
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web"})
+	w := &Watcher{routes: routes, logger: slog.New(slog.NewTextHandler(&buf, nil)), opts: Options{Observe: true}}
+	ctx := context.Background()
+
+	// The pause is taken, a reload deferred and the pause lifted before
+	// the watcher's next tick ever sees the marker.
+	if err := Pause("caddy-atc start app", 0); err != nil {
+		t.Fatal(err)
+	}
+	w.reloadNow(ctx)
+	if !w.pending || strings.Contains(buf.String(), "would write Caddyfile") {
+		t.Fatalf("reload while paused: pending = %v, log:\n%s", w.pending, buf.String())
+	}
+	if err := Resume(); err != nil {
+		t.Fatal(err)
+	}
+	w.syncPause(ctx)
+	if w.pending || !strings.Contains(buf.String(), "would write Caddyfile") {
+		t.Errorf("deferred reload not applied after resume: pending = %v, log:\n%s", w.pending, buf.String())
+	}
+}
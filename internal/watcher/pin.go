@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"fmt"
+	"maps"
+	"os"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Pins maps a hostname to the one container that serves it, bypassing
+// load balancing across its replicas. Pins are stored in a file so the
+// watcher and CLI can share them without IPC.
+type Pins map[string]string
+
+// Pin directs all traffic for hostname to container until Unpin.
+func Pin(hostname, container string) error {
+	if err := config.ValidateHostname(hostname); err != nil {
+		return err
+	}
+	if err := config.ValidateContainerName(container); err != nil {
+		return err
+	}
+	pins := CurrentPins()
+	if pins == nil {
+		pins = make(Pins)
+	}
+	pins[hostname] = container
+	return savePins(pins)
+}
+
+// Unpin restores load balancing for hostname, or for every hostname if it
+// is empty. Returns false if nothing was pinned.
+func Unpin(hostname string) (bool, error) {
+	pins := CurrentPins()
+	if hostname == "" {
+		if len(pins) == 0 {
+			return false, nil
+		}
+		pins = nil
+	} else {
+		if _, ok := pins[hostname]; !ok {
+			return false, nil
+		}
+		delete(pins, hostname)
+	}
+	return true, savePins(pins)
+}
+
+// CurrentPins returns the pinned hostnames, or nil if there are none.
+func CurrentPins() Pins {
+	data, err := os.ReadFile(config.PinsPath())
+	if err != nil {
+		return nil
+	}
+	var pins Pins
+	if err := yaml.Unmarshal(data, &pins); err != nil || len(pins) == 0 {
+		return nil
+	}
+	return pins
+}
+
+// savePins writes pins, removing the file when there are none.
+func savePins(pins Pins) error {
+	if len(pins) == 0 {
+		if err := os.Remove(config.PinsPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing pins: %w", err)
+		}
+		return nil
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(pins)
+	if err != nil {
+		return fmt.Errorf("marshaling pins: %w", err)
+	}
+	return atomicWriteFile(config.PinsPath(), data, 0600)
+}
+
+// SetPins replaces the pinned hostnames rendered into the Caddyfile.
+// Returns true if they changed.
+func (ar *ActiveRoutes) SetPins(pins Pins) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if maps.Equal(ar.pins, pins) {
+		return false
+	}
+	ar.pins = maps.Clone(pins)
+	return true
+}
+
+// Pinned returns the container hostname is pinned to, or "".
+func (ar *ActiveRoutes) Pinned(hostname string) string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.pins[hostname]
+}
+
+// Pins returns a copy of the pinned hostnames.
+func (ar *ActiveRoutes) Pins() Pins {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return maps.Clone(ar.pins)
+}
+
+// checkPins applies pins changed by `caddy-atc pin` and `unpin`. Returns
+// true if the Caddyfile needs to be regenerated.
+func (w *Watcher) checkPins() bool {
+	old := w.routes.Pins()
+	pins := CurrentPins()
+	if !w.routes.SetPins(pins) {
+		return false
+	}
+	for h, c := range pins {
+		if old[h] != c {
+			w.logger.Info("Pinned hostname", "event", "pinned", "hostname", h, "container", c)
+		}
+	}
+	for h := range old {
+		if _, ok := pins[h]; !ok {
+			w.logger.Info("Unpinned hostname, load balancing restored", "event", "unpinned", "hostname", h)
+		}
+	}
+	return true
+}
+
+// pinUpstreams narrows a site's upstreams to its pinned container. A pin
+// to a container that isn't routed is ignored, so the site stays up.
+func pinUpstreams(upstreams []upstream, pinned string) []upstream {
+	if pinned == "" {
+		return upstreams
+	}
+	for _, u := range upstreams {
+		if u.Container == pinned {
+			return []upstream{u}
+		}
+	}
+	return upstreams
+}
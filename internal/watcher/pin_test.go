@@ -0,0 +1,81 @@
+package watcher
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPinUnpin(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if err := Pin("api.app.localhost", "app-api-2"); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if err := Pin("app.localhost", "app-web-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pin("bad host", "app-web-1"); err == nil {
+		t.Error("expected error for invalid hostname")
+	}
+	if got := CurrentPins()["api.app.localhost"]; got != "app-api-2" {
+		t.Errorf("pin = %q, want app-api-2", got)
+	}
+
+	if ok, err := Unpin("api.app.localhost"); !ok || err != nil {
+		t.Fatalf("Unpin() = %v, %v", ok, err)
+	}
+	if ok, _ := Unpin("api.app.localhost"); ok {
+		t.Error("Unpin() of an unpinned hostname reported true")
+	}
+	if ok, err := Unpin(""); !ok || err != nil {
+		t.Fatalf("Unpin(all) = %v, %v", ok, err)
+	}
+	if pins := CurrentPins(); pins != nil {
+		t.Errorf("CurrentPins() after unpinning all = %v", pins)
+	}
+}
+
+func TestGenerateCaddyfile_Pinned(t *testing.T) {
+	w := &Watcher{routes: quarantineRoutes(), logger: slog.New(slog.DiscardHandler)}
+	w.routes.SetPins(Pins{"api.app.localhost": "app-api-2"})
+
+	got, err := GenerateCaddyfile(w.routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "reverse_proxy app-api-2:8000") || strings.Contains(got, "app-api-1:8000") {
+		t.Errorf("expected only the pinned replica:\n%s", got)
+	}
+
+	// A pin to a container that isn't routed leaves the site load balanced.
+	w.routes.SetPins(Pins{"api.app.localhost": "app-api-9"})
+	got, _ = GenerateCaddyfile(w.routes)
+	if !strings.Contains(got, "reverse_proxy app-api-1:8000 app-api-2:8000") {
+		t.Errorf("expected both replicas for a stale pin:\n%s", got)
+	}
+}
+
+func TestCheckPins(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	w := &Watcher{routes: quarantineRoutes(), logger: slog.New(slog.DiscardHandler)}
+
+	if w.checkPins() {
+		t.Error("checkPins() = true with no pins")
+	}
+	if err := Pin("api.app.localhost", "app-api-1"); err != nil {
+		t.Fatal(err)
+	}
+	if !w.checkPins() {
+		t.Error("checkPins() = false after pinning")
+	}
+	if w.checkPins() {
+		t.Error("checkPins() = true without a change")
+	}
+	if _, err := Unpin(""); err != nil {
+		t.Fatal(err)
+	}
+	if !w.checkPins() || w.routes.Pinned("api.app.localhost") != "" {
+		t.Error("expected unpin to be applied")
+	}
+}
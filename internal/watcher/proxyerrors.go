@@ -0,0 +1,233 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// gatewayLogRetry is how long the watcher waits before following the
+// gateway's output again after the stream ends, e.g. because the gateway
+// stopped.
+const gatewayLogRetry = 10 * time.Second
+
+// caddyLogEntry is the part of a Caddy JSON log line needed to attribute a
+// failed request to a route.
+type caddyLogEntry struct {
+	Logger  string `json:"logger"`
+	Msg     string `json:"msg"`
+	Status  int    `json:"status"`
+	Request struct {
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+// proxyError returns the entry for a request the gateway failed to proxy,
+// or false if line is anything else.
+func proxyError(line string) (caddyLogEntry, bool) {
+	var e caddyLogEntry
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		return e, false
+	}
+	if e.Logger != "http.log.error" || e.Status < 502 || e.Status > 504 || e.Request.Host == "" {
+		return e, false
+	}
+	if host, _, err := net.SplitHostPort(e.Request.Host); err == nil {
+		e.Request.Host = host
+	}
+	e.Request.Host = strings.ToLower(e.Request.Host)
+	return e, true
+}
+
+// followProxyErrors follows the gateway's output until ctx is done and
+// logs a hint for each failed request to a route, rate-limited per
+// container by warn.
+func (w *Watcher) followProxyErrors(ctx context.Context) {
+	for {
+		if w.gatewayRunning(ctx) {
+			if err := w.scanGatewayLog(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				w.logger.Warn("Following gateway log failed", "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(gatewayLogRetry):
+		}
+	}
+}
+
+// scanGatewayLog reads the gateway's output from since until the stream
+// ends.
+func (w *Watcher) scanGatewayLog(ctx context.Context, since time.Time) error {
+	reader, err := w.cli.ContainerLogs(ctx, gateway.ContainerName, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Since:      strconv.FormatInt(since.Unix(), 10),
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// The container has no TTY, so the stream is multiplexed.
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, reader)
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if e, ok := proxyError(scanner.Text()); ok {
+			w.explainProxyError(ctx, e)
+		}
+	}
+	return scanner.Err()
+}
+
+// explainProxyError counts a failed request and logs why the route's
+// upstream likely failed, from the error and the container's state.
+func (w *Watcher) explainProxyError(ctx context.Context, e caddyLogEntry) {
+	var route *Route
+	for _, r := range w.routes.All() {
+		if (r.Hostname == e.Request.Host || r.ReplicaHostname == e.Request.Host) && r.Root == "" {
+			route = r
+			break
+		}
+	}
+	if route == nil {
+		return
+	}
+	w.metrics.proxyErrors.Add(1)
+
+	if scheme := schemeMismatch(e.Msg); scheme != "" && route.Source != SourceManual && learnableScheme(route) {
+		host := e.Request.Host
+		select {
+		case w.control <- func(ctx context.Context) { w.learnScheme(ctx, host, scheme) }:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	state := ""
+	if route.Source != SourceManual {
+		state = "unknown"
+		if info, err := gateway.InspectContainer(ctx, w.cli, route.ContainerName); err == nil && info.State != nil {
+			state = info.State.Status
+		}
+	}
+	w.warn(route.ContainerName, "Proxy error", "event", "proxy_error", "hostname", e.Request.Host, "status", e.Status, "hint", proxyErrorHint(route, e.Msg, state))
+}
+
+// schemeMismatch returns the scheme an upstream evidently speaks when a
+// proxy error shows the gateway used the other one, or "".
+func schemeMismatch(msg string) string {
+	switch {
+	case strings.Contains(msg, "does not look like a TLS handshake"),
+		strings.Contains(msg, "server gave HTTP response to HTTPS client"):
+		return "http"
+	// A TLS server answers a plaintext request with an alert record,
+	// which starts with bytes 0x15 0x03.
+	case strings.Contains(msg, "malformed HTTP response") && strings.Contains(msg, `\x15\x03`):
+		return "https"
+	}
+	return ""
+}
+
+// learnableScheme reports whether the watcher may switch the route's
+// upstream scheme. A scheme set by label, or implied by upstream TLS
+// certificates, is the user's decision.
+func learnableScheme(r *Route) bool {
+	return r.Scheme == "" && r.Options.TLSClientCert == "" && r.Options.TLSTrustedCA == ""
+}
+
+// learnScheme switches the container routes of hostname to the upstream
+// scheme their server evidently speaks, records it in the route metadata
+// so it outlives the watcher, and reloads Caddy. It must run on the event
+// loop.
+func (w *Watcher) learnScheme(ctx context.Context, hostname, scheme string) {
+	switched := w.routes.LearnScheme(hostname, scheme)
+	if len(switched) == 0 {
+		return // already switched by an earlier error
+	}
+	for _, r := range switched {
+		if err := w.meta.setScheme(r.Hostname, r.ContainerName, r.Port, scheme, time.Now()); err != nil {
+			w.logger.Warn("Saving route metadata failed", "err", err)
+		}
+	}
+	r := switched[0]
+	w.logger.Info("Upstream speaks "+strings.ToUpper(scheme)+", switching its route to "+scheme+"://", "event", "scheme_learned", "upstream", r.ContainerName+":"+r.Port, "hostname", hostname, "scheme", scheme)
+	if err := w.reloadRoutes(ctx); err != nil {
+		w.logger.Error("Reloading routes failed", "err", err)
+	}
+}
+
+// LearnScheme sets the learned upstream scheme of the container routes of
+// hostname that may be switched and don't use scheme yet. Routes are
+// shared with readers, so they are replaced rather than mutated. Returns
+// the switched routes.
+func (ar *ActiveRoutes) LearnScheme(hostname, scheme string) []*Route {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	var switched []*Route
+	for key, r := range ar.routes {
+		if r.Hostname != hostname && r.ReplicaHostname != hostname || isStaticKey(key) || r.Root != "" ||
+			!learnableScheme(r) || normalScheme(r.upstreamScheme()) == scheme {
+			continue
+		}
+		updated := *r
+		updated.LearnedScheme = scheme
+		ar.routes[key] = &updated
+		switched = append(switched, &updated)
+	}
+	return switched
+}
+
+// normalScheme returns scheme, with "" meaning http.
+func normalScheme(scheme string) string {
+	if scheme == "" {
+		return "http"
+	}
+	return scheme
+}
+
+// proxyErrorHint explains a proxy error for route. state is the Docker
+// state of its container ("running", "exited", ...), or "" for a static
+// route to a process outside Docker.
+func proxyErrorHint(r *Route, msg, state string) string {
+	target := r.ContainerName + ":" + r.Port
+	if state != "" && state != "running" {
+		return fmt.Sprintf("container %s is %s", r.ContainerName, state)
+	}
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		if state == "" {
+			return fmt.Sprintf("nothing accepts connections on %s; check the process is running and listens on 0.0.0.0, not 127.0.0.1", target)
+		}
+		return fmt.Sprintf("%s is running but nothing accepts connections on port %s; check the app listens on 0.0.0.0, not 127.0.0.1 or localhost", r.ContainerName, r.Port)
+	case strings.Contains(msg, "no such host"), strings.Contains(msg, "server misbehaving"):
+		return fmt.Sprintf("%s does not resolve from the gateway; check it is on the %s network", r.ContainerName, gateway.Network())
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return fmt.Sprintf("%s did not answer in time; it may still be starting, or be busy", target)
+	case strings.Contains(msg, "does not look like a TLS handshake"), strings.Contains(msg, "server gave HTTP response to HTTPS client"):
+		return fmt.Sprintf("%s serves plain HTTP; remove upstream_scheme: https", target)
+	case strings.Contains(msg, "malformed HTTP"), strings.Contains(msg, "EOF"), strings.Contains(msg, "connection reset"):
+		return fmt.Sprintf("%s closed the connection; if it serves HTTPS, set upstream_scheme: https", target)
+	}
+	return msg
+}
@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProxyError(t *testing.T) {
+	line := `{"level":"error","ts":1760000000.1,"logger":"http.log.error","msg":"dial tcp 172.18.0.5:3000: connect: connection refused","request":{"remote_ip":"172.18.0.1","host":"MyApp.localhost:8443","uri":"/"},"duration":0.001,"status":502,"err_id":"abc"}`
+	e, ok := proxyError(line)
+	if !ok {
+		t.Fatal("proxyError() = false for a 502")
+	}
+	if e.Request.Host != "myapp.localhost" || e.Status != 502 || !strings.Contains(e.Msg, "connection refused") {
+		t.Errorf("proxyError() = %+v", e)
+	}
+
+	for _, other := range []string{
+		`{"level":"info","logger":"http.log.access","request":{"host":"myapp.localhost"},"status":502}`,
+		`{"level":"error","logger":"http.log.error","msg":"not found","request":{"host":"myapp.localhost"},"status":404}`,
+		`{"level":"info","msg":"serving initial configuration"}`,
+		`not json`,
+	} {
+		if _, ok := proxyError(other); ok {
+			t.Errorf("proxyError(%s) = true, want false", other)
+		}
+	}
+}
+
+func TestProxyErrorHint(t *testing.T) {
+	web := &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "3000"}
+	vite := &Route{Hostname: "vite.localhost", ContainerName: "host.docker.internal", Port: "5173", Source: SourceManual}
+	tests := []struct {
+		name  string
+		route *Route
+		msg   string
+		state string
+		want  string
+	}{
+		{"not listening", web, "dial tcp 172.18.0.5:3000: connect: connection refused", "running", "listens on 0.0.0.0"},
+		{"stopped", web, "dial tcp: lookup myapp-web-1 on 127.0.0.11:53: no such host", "exited", "myapp-web-1 is exited"},
+		{"restarting", web, "dial tcp 172.18.0.5:3000: connect: connection refused", "restarting", "is restarting"},
+		{"not on network", web, "dial tcp: lookup myapp-web-1: no such host", "running", "caddy-atc network"},
+		{"timeout", web, "dial tcp 172.18.0.5:3000: i/o timeout", "running", "did not answer in time"},
+		{"https upstream", web, "malformed HTTP response \"\\x15\\x03\\x01\"", "running", "upstream_scheme: https"},
+		{"host process", vite, "dial tcp 172.17.0.1:5173: connect: connection refused", "", "host.docker.internal:5173; check the process"},
+		{"unknown", web, "something else", "running", "something else"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxyErrorHint(tt.route, tt.msg, tt.state); !strings.Contains(got, tt.want) {
+				t.Errorf("proxyErrorHint() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemeMismatch(t *testing.T) {
+	tests := map[string]string{
+		`http: server gave HTTP response to HTTPS client`:                                                    "http",
+		`tls: first record does not look like a TLS handshake`:                                               "http",
+		`net/http: HTTP/1.x transport connection broken: malformed HTTP response "\x15\x03\x01\x00\x02\x02"`: "https",
+		`net/http: HTTP/1.x transport connection broken: malformed HTTP response "SSH-2.0"`:                  "",
+		`dial tcp 172.18.0.5:3000: connect: connection refused`:                                              "",
+	}
+	for msg, want := range tests {
+		if got := schemeMismatch(msg); got != want {
+			t.Errorf("schemeMismatch(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func TestActiveRoutes_LearnScheme(t *testing.T) {
+	routes := NewActiveRoutes()
+	web := &Route{Hostname: "kc.localhost", ContainerName: "app-kc-1", Port: "8443", Project: "app"}
+	labeled := &Route{Hostname: "kc.localhost", ContainerName: "app-kc-2", Port: "8443", Project: "app", Scheme: "http"}
+	routes.Add("c1", web)
+	routes.Add("c2", labeled)
+
+	switched := routes.LearnScheme("kc.localhost", "https")
+	if len(switched) != 1 || switched[0].ContainerName != "app-kc-1" || switched[0].LearnedScheme != "https" {
+		t.Fatalf("LearnScheme() = %+v, want only app-kc-1 switched", switched)
+	}
+	if web.LearnedScheme != "" {
+		t.Error("LearnScheme() mutated the shared route")
+	}
+	if again := routes.LearnScheme("kc.localhost", "https"); len(again) != 0 {
+		t.Errorf("second LearnScheme() = %+v, want none", again)
+	}
+
+	got, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "tls_insecure_skip_verify") {
+		t.Errorf("expected an HTTPS transport after learning https:\n%s", got)
+	}
+}
@@ -0,0 +1,254 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// caddyfileLine matches the line number in Caddy's Caddyfile adapter
+// errors, e.g. "..., at /etc/caddy/Caddyfile:14" or
+// "/etc/caddy/Caddyfile:14 - Error during parsing: ...".
+var caddyfileLine = regexp.MustCompile(`Caddyfile:(\d+)`)
+
+// Quarantine excludes the routes of hostname, including those that serve it
+// as their replica hostname, from the Caddyfile, recording
+// reason on each. Routes are shared with readers, so they are replaced
+// rather than mutated. Returns the quarantined routes.
+func (ar *ActiveRoutes) Quarantine(hostname, reason string) []*Route {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	var quarantined []*Route
+	for key, r := range ar.routes {
+		if r.Hostname != hostname && r.ReplicaHostname != hostname || r.Quarantine != "" {
+			continue
+		}
+		updated := *r
+		updated.Quarantine = reason
+		ar.routes[key] = &updated
+		quarantined = append(quarantined, &updated)
+	}
+	return quarantined
+}
+
+// QuarantineInvalid quarantines every route whose values fail validation,
+// so one bad hostname, port or option doesn't keep the Caddyfile from being
+// generated for everyone else. Returns the quarantined routes.
+func (ar *ActiveRoutes) QuarantineInvalid() []*Route {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	var quarantined []*Route
+	for key, r := range ar.routes {
+		if r.Quarantine != "" {
+			continue
+		}
+		err := validateRoute(r)
+		if err == nil {
+			continue
+		}
+		updated := *r
+		updated.Quarantine = err.Error()
+		ar.routes[key] = &updated
+		quarantined = append(quarantined, &updated)
+	}
+	return quarantined
+}
+
+// blameReloadError returns the hostname of the site that caused a failed
+// reload: the site containing the Caddyfile line named by an adapter error,
+// or else the longest site hostname (or its certificate directory) that a
+// provisioning error mentions. Returns "" if the error can't be attributed.
+func blameReloadError(spans []siteSpan, output string) string {
+	if m := caddyfileLine.FindStringSubmatch(output); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		for _, s := range spans {
+			if line >= s.first && line <= s.last {
+				return s.hostname
+			}
+		}
+	}
+
+	blamed := ""
+	for _, s := range spans {
+		if len(s.hostname) <= len(blamed) {
+			continue
+		}
+		if strings.Contains(output, s.hostname) || strings.Contains(output, upstreamCertDir(s.hostname)) {
+			blamed = s.hostname
+		}
+	}
+	return blamed
+}
+
+// reloadErrorMessage extracts Caddy's own error message from reload output,
+// falling back to its last non-empty line.
+func reloadErrorMessage(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if msg, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "Error: "); ok {
+			return msg
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// logQuarantined reports which project and service caused each route to be
+// quarantined.
+func (w *Watcher) logQuarantined(routes []*Route) {
+	for _, r := range routes {
+		if r.Project != "" {
+			w.logger.Error("Route rejected by Caddy", "project", r.Project, "service", r.Service, "hostname", r.Hostname, "err", r.Quarantine)
+		} else {
+			w.logger.Error("Static route rejected by Caddy", "hostname", r.Hostname, "err", r.Quarantine)
+		}
+	}
+}
+
+// quarantineInvalidRoutes excludes routes that fail validation before the
+// Caddyfile is generated.
+func (w *Watcher) quarantineInvalidRoutes() {
+	quarantined := w.routes.QuarantineInvalid()
+	w.logQuarantined(quarantined)
+	if len(quarantined) > 0 {
+		w.logger.Warn("Quarantined invalid routes until their containers restart or their options change", "event", "quarantined", "routes", len(quarantined))
+	}
+}
+
+// quarantineFailedSite attributes a reload failure to one site, excludes
+// its routes from the Caddyfile and logs which project and service caused
+// it. Returns false if the failure can't be attributed.
+func (w *Watcher) quarantineFailedSite(reloadErr error) bool {
+	content, spans, err := renderCaddyfile(w.routes)
+	if err != nil {
+		return false
+	}
+	// Caddy counts lines in the file written, below its stamp line.
+	for i := range spans {
+		spans[i].first++
+		spans[i].last++
+	}
+	output := reloadErr.Error()
+	blame := output
+	if !builtinCaddyfileWritten(content) {
+		// The user's template lays sites out its own way, so a line number
+		// says nothing about which site failed.
+		blame = caddyfileLine.ReplaceAllString(output, "Caddyfile")
+	}
+	hostname := blameReloadError(spans, blame)
+	if hostname == "" {
+		return false
+	}
+
+	w.logQuarantined(w.routes.Quarantine(hostname, reloadErrorMessage(output)))
+	w.logger.Warn("Quarantined route until its containers restart or its options change", "event", "quarantined", "hostname", hostname)
+	return true
+}
+
+// builtinCaddyfileWritten reports whether the Caddyfile on disk is the
+// built-in content rather than the user's template.
+func builtinCaddyfileWritten(content string) bool {
+	data, err := os.ReadFile(config.CaddyfilePath())
+	if err != nil {
+		return true
+	}
+	_, current, _ := ParseCaddyfileStamp(string(data))
+	current, _ = SplitManualSections(current)
+	return current == content
+}
+
+// reloadWithQuarantine retries a reload that Caddy rejected, quarantining
+// the offending site each time, until the remaining routes load or the
+// failure can no longer be attributed to a site.
+func (w *Watcher) reloadWithQuarantine(ctx context.Context, reloadErr error) error {
+	for w.quarantineFailedSite(reloadErr) {
+		if err := w.writeCaddyfile(); err != nil {
+			return fmt.Errorf("writing Caddyfile: %w", err)
+		}
+		w.applyCaddyfileTemplate(ctx)
+		if reloadErr = w.reloadCaddy(ctx); reloadErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("reloading Caddy: %w", reloadErr)
+}
+
+// QuarantinedRoute is a route the watcher excluded from the Caddyfile. The
+// list is stored in a file so `caddy-atc routes` can show it without IPC.
+type QuarantinedRoute struct {
+	Hostname        string `yaml:"hostname"`
+	ReplicaHostname string `yaml:"replica_hostname,omitempty"`
+	ContainerName   string `yaml:"container"`
+	Project         string `yaml:"project,omitempty"`
+	Service         string `yaml:"service,omitempty"`
+	Reason          string `yaml:"reason"`
+}
+
+// saveQuarantine records the currently quarantined routes, removing the
+// file when there are none.
+func saveQuarantine(routes *ActiveRoutes) error {
+	var list []QuarantinedRoute
+	for _, r := range routes.All() {
+		if r.Quarantine == "" {
+			continue
+		}
+		q := QuarantinedRoute{
+			Hostname:      r.Hostname,
+			ContainerName: r.ContainerName,
+			Project:       r.Project,
+			Service:       r.Service,
+			Reason:        r.Quarantine,
+		}
+		if r.Options.ReplicaHostnames {
+			q.ReplicaHostname = r.ReplicaHostname
+		}
+		list = append(list, q)
+	}
+	if len(list) == 0 {
+		return clearQuarantine()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Hostname != list[j].Hostname {
+			return list[i].Hostname < list[j].Hostname
+		}
+		return list[i].ContainerName < list[j].ContainerName
+	})
+
+	data, err := yaml.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("marshaling quarantine list: %w", err)
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	return atomicWriteFile(config.QuarantinePath(), data, 0600)
+}
+
+// clearQuarantine removes the quarantine list.
+func clearQuarantine() error {
+	if err := os.Remove(config.QuarantinePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing quarantine list: %w", err)
+	}
+	return nil
+}
+
+// LoadQuarantine returns the routes the running watcher has quarantined.
+func LoadQuarantine() []QuarantinedRoute {
+	data, err := os.ReadFile(config.QuarantinePath())
+	if err != nil {
+		return nil
+	}
+	var list []QuarantinedRoute
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil
+	}
+	return list
+}
@@ -0,0 +1,241 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func quarantineRoutes() *ActiveRoutes {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web"})
+	routes.Add("c2", &Route{Hostname: "api.app.localhost", ContainerName: "app-api-1", Port: "8000", Project: "app", Service: "api"})
+	routes.Add("c3", &Route{Hostname: "api.app.localhost", ContainerName: "app-api-2", Port: "8000", Project: "app", Service: "api"})
+	return routes
+}
+
+func TestRenderCaddyfile_Spans(t *testing.T) {
+	content, spans, err := renderCaddyfile(quarantineRoutes())
+	if err != nil {
+		t.Fatalf("renderCaddyfile() error = %v", err)
+	}
+	lines := strings.Split(content, "\n")
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for _, s := range spans {
+		if got := lines[s.first-1]; got != s.hostname+" {" {
+			t.Errorf("line %d = %q, want site address for %s", s.first, got, s.hostname)
+		}
+		if got := lines[s.last-1]; got != "}" {
+			t.Errorf("line %d = %q, want closing brace of %s", s.last, got, s.hostname)
+		}
+	}
+}
+
+func TestBlameReloadError(t *testing.T) {
+	_, spans, err := renderCaddyfile(quarantineRoutes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// api.app.localhost sorts first; its block spans the lines after the
+	// global options.
+	apiLine := spans[0].first + 3
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "adapter error line",
+			output: "Error: adapting config using caddyfile: parsing caddyfile tokens for 'reverse_proxy': unrecognized subdirective, at /etc/caddy/Caddyfile:" + strconv.Itoa(apiLine),
+			want:   "api.app.localhost",
+		},
+		{
+			name:   "legacy parse error line",
+			output: "/etc/caddy/Caddyfile:" + strconv.Itoa(spans[1].first) + " - Error during parsing: unknown directive",
+			want:   "app.localhost",
+		},
+		{
+			name:   "provisioning error naming a host",
+			output: "Error: loading new config: loading http app module: provision http: server srv0: host api.app.localhost: bad",
+			want:   "api.app.localhost",
+		},
+		{
+			name:   "unattributable",
+			output: "Error: loading new config: listen tcp :443: bind: address already in use",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blameReloadError(spans, tt.output); got != tt.want {
+				t.Errorf("blameReloadError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadErrorMessage(t *testing.T) {
+	output := "reloading Caddy: exit status 1\n{\"level\":\"info\",\"msg\":\"using config\"}\nError: adapting config: bad thing\n"
+	if got := reloadErrorMessage(output); got != "adapting config: bad thing" {
+		t.Errorf("reloadErrorMessage() = %q", got)
+	}
+	if got := reloadErrorMessage("exit status 1\n"); got != "exit status 1" {
+		t.Errorf("reloadErrorMessage() fallback = %q", got)
+	}
+}
+
+func TestQuarantineFailedSite(t *testing.T) {
+	w := &Watcher{routes: quarantineRoutes(), logger: slog.New(slog.DiscardHandler)}
+
+	err := errors.New("reloading Caddy: exit status 1\nError: provision http: host api.app.localhost: bad option")
+	if !w.quarantineFailedSite(err) {
+		t.Fatal("expected the failure to be attributed")
+	}
+
+	for _, id := range []string{"c2", "c3"} {
+		r, _ := w.routes.Get(id)
+		if r.Quarantine != "provision http: host api.app.localhost: bad option" {
+			t.Errorf("route %s Quarantine = %q", id, r.Quarantine)
+		}
+	}
+	if r, _ := w.routes.Get("c1"); r.Quarantine != "" {
+		t.Errorf("unrelated route quarantined: %q", r.Quarantine)
+	}
+
+	got, genErr := GenerateCaddyfile(w.routes)
+	if genErr != nil {
+		t.Fatal(genErr)
+	}
+	if strings.Contains(got, "api.app.localhost {") || !strings.Contains(got, "app.localhost {") {
+		t.Errorf("expected only the healthy site in Caddyfile:\n%s", got)
+	}
+
+	// A restarted container replaces its route and is served again.
+	w.routes.Add("c2", &Route{Hostname: "api.app.localhost", ContainerName: "app-api-1", Port: "8000", Project: "app", Service: "api"})
+	got, _ = GenerateCaddyfile(w.routes)
+	if !strings.Contains(got, "api.app.localhost {") {
+		t.Errorf("expected restarted route in Caddyfile:\n%s", got)
+	}
+}
+
+func TestReloadWithQuarantine_Template(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	w := &Watcher{routes: quarantineRoutes(), logger: slog.New(slog.DiscardHandler)}
+	writeTemplate(t, "# templated\n{{range .Sites}}{{.Block}}{{end}}{{.Global}}")
+	fakeDocker(t, "exit 0")
+	if err := w.writeCaddyfile(); err != nil {
+		t.Fatal(err)
+	}
+	w.applyCaddyfileTemplate(context.Background())
+
+	// The line of app.localhost in the built-in Caddyfile belongs to
+	// another site in the template's.
+	_, spans, _ := renderCaddyfile(w.routes)
+	line := 0
+	for _, s := range spans {
+		if s.hostname == "app.localhost" {
+			line = s.first + 2
+		}
+	}
+	err := errors.New("Error: adapting config using caddyfile: Caddyfile:" + strconv.Itoa(line) + ": unrecognized directive: bogus")
+	if w.quarantineFailedSite(err) {
+		t.Error("blamed a site by a line of the templated Caddyfile")
+	}
+
+	err = errors.New("Error: provision http: host api.app.localhost: bad option")
+	if err := w.reloadWithQuarantine(context.Background(), err); err != nil {
+		t.Fatalf("reloadWithQuarantine() error = %v", err)
+	}
+	if r, _ := w.routes.Get("c1"); r.Quarantine != "" {
+		t.Errorf("app.localhost quarantined: %q", r.Quarantine)
+	}
+	data, _ := os.ReadFile(config.CaddyfilePath())
+	if got := string(data); !strings.Contains(got, "# templated") || strings.Contains(got, "api.app.localhost {") {
+		t.Errorf("expected the template without the quarantined site:\n%s", got)
+	}
+}
+
+func TestQuarantineInvalidRoutes(t *testing.T) {
+	w := &Watcher{routes: quarantineRoutes(), logger: slog.New(slog.DiscardHandler)}
+	w.routes.Add("c4", &Route{
+		Hostname: "bad.app.localhost", ContainerName: "app-bad-1", Port: "80", Project: "app", Service: "bad",
+		Options: config.ServiceOptions{LBTryDuration: "soon"},
+	})
+	if _, err := GenerateCaddyfile(w.routes); err == nil {
+		t.Fatal("expected invalid options to break generation before quarantine")
+	}
+
+	w.quarantineInvalidRoutes()
+
+	if r, _ := w.routes.Get("c4"); !strings.Contains(r.Quarantine, "invalid options for bad.app.localhost") {
+		t.Errorf("invalid route Quarantine = %q", r.Quarantine)
+	}
+	got, err := GenerateCaddyfile(w.routes)
+	if err != nil {
+		t.Fatalf("GenerateCaddyfile() after quarantine error = %v", err)
+	}
+	if strings.Contains(got, "bad.app.localhost") || !strings.Contains(got, "api.app.localhost {") {
+		t.Errorf("expected healthy sites only:\n%s", got)
+	}
+}
+
+func TestQuarantineInvalidRoutes_MissingCertFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	w := &Watcher{routes: quarantineRoutes(), logger: slog.New(slog.DiscardHandler)}
+	w.routes.Add("c4", &Route{
+		Hostname: "billing.app.localhost", ContainerName: "app-billing-1", Port: "8443", Project: "app", Service: "billing",
+		Options: config.ServiceOptions{TLSTrustedCA: filepath.Join(t.TempDir(), "missing.pem")},
+	})
+
+	w.quarantineInvalidRoutes()
+
+	if r, _ := w.routes.Get("c4"); !strings.Contains(r.Quarantine, "upstream TLS for billing.app.localhost") {
+		t.Errorf("route with missing CA Quarantine = %q", r.Quarantine)
+	}
+	if _, err := WriteCaddyfile(w.routes, "test"); err != nil {
+		t.Fatalf("WriteCaddyfile() error = %v", err)
+	}
+	got, _ := os.ReadFile(config.CaddyfilePath())
+	if strings.Contains(string(got), "billing.app.localhost") || !strings.Contains(string(got), "api.app.localhost {") {
+		t.Errorf("expected healthy sites only:\n%s", got)
+	}
+}
+
+func TestSaveQuarantine(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	routes := quarantineRoutes()
+	routes.Quarantine("api.app.localhost", "bad option")
+	if err := saveQuarantine(routes); err != nil {
+		t.Fatalf("saveQuarantine() error = %v", err)
+	}
+	want := []QuarantinedRoute{
+		{Hostname: "api.app.localhost", ContainerName: "app-api-1", Project: "app", Service: "api", Reason: "bad option"},
+		{Hostname: "api.app.localhost", ContainerName: "app-api-2", Project: "app", Service: "api", Reason: "bad option"},
+	}
+	if got := LoadQuarantine(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadQuarantine() = %+v, want %+v", got, want)
+	}
+
+	// Once nothing is quarantined the file goes away.
+	if err := saveQuarantine(quarantineRoutes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.QuarantinePath()); !os.IsNotExist(err) {
+		t.Errorf("quarantine file still present: %v", err)
+	}
+	if got := LoadQuarantine(); got != nil {
+		t.Errorf("LoadQuarantine() = %+v, want nil", got)
+	}
+}
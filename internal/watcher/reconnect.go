@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// Reconnection to the Docker daemon backs off exponentially between these
+// delays.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// nextReconnectDelay returns the delay after a failed reconnection that
+// waited d.
+func nextReconnectDelay(d time.Duration) time.Duration {
+	return min(2*d, maxReconnectDelay)
+}
+
+// subscribe opens the Docker event stream for the container events the
+// watcher handles.
+func (w *Watcher) subscribe(ctx context.Context) (<-chan events.Message, <-chan error) {
+	eventFilter := filters.NewArgs(
+		filters.Arg("type", "container"),
+		filters.Arg("event", "start"),
+		filters.Arg("event", "stop"),
+		filters.Arg("event", "die"),
+		filters.Arg("event", "restart"),
+		filters.Arg("event", "pause"),
+		filters.Arg("event", "unpause"),
+		filters.Arg("event", "rename"),
+	)
+	return w.cli.Events(ctx, events.ListOptions{Filters: eventFilter})
+}
+
+// reconnect reports whether the Docker daemon answers again.
+func (w *Watcher) reconnect(ctx context.Context) bool {
+	_, err := gateway.Call(ctx, w.cli.Ping)
+	return err == nil
+}
+
+// reconcile brings the routes in line with the containers running after
+// the event stream was lost: containers that stopped meanwhile lose their
+// routes and the rest are routed again.
+func (w *Watcher) reconcile(ctx context.Context) {
+	before := w.routes.containerRoutes()
+	for id := range before {
+		w.routes.Remove(id)
+	}
+	w.stopping = nil
+	w.lastReconcile = time.Now()
+
+	if err := w.scanExisting(ctx); err != nil {
+		w.logger.Warn("Scanning existing containers failed", "err", err)
+	}
+
+	gone := false
+	for id, r := range before {
+		if _, ok := w.routes.Get(id); !ok {
+			w.logger.Info("Route removed", "event", "route_removed", "hostname", r.Hostname, "upstream", r.ContainerName+":"+r.Port, "project", r.Project, "service", r.Service)
+			w.routeRemoved(r)
+			gone = true
+		}
+	}
+	// scanExisting only reloads Caddy when routes remain.
+	if gone && w.routes.Len() == 0 {
+		w.scheduleReload(ctx, nil)
+	}
+}
+
+// containerRoutes returns the routes of containers, leaving out static
+// routes, keyed by container ID.
+func (ar *ActiveRoutes) containerRoutes() map[string]*Route {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	routes := make(map[string]*Route)
+	for key, r := range ar.routes {
+		if !isStaticKey(key) {
+			routes[key] = r
+		}
+	}
+	return routes
+}
@@ -0,0 +1,35 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestNextReconnectDelay(t *testing.T) {
+	var got []time.Duration
+	for d := minReconnectDelay; len(got) < 7; d = nextReconnectDelay(d) {
+		got = append(got, d)
+	}
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 30 * time.Second, 30 * time.Second,
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestActiveRoutes_ContainerRoutes(t *testing.T) {
+	ar := NewActiveRoutes()
+	ar.Add("abc", &Route{Hostname: "app.localhost", ContainerName: "app", Port: "80"})
+	ar.SyncStatic([]*config.StaticRoute{{Hostname: "api.localhost", Upstream: "host.docker.internal:8080"}})
+
+	got := ar.containerRoutes()
+	if len(got) != 1 || got["abc"] == nil {
+		t.Errorf("containerRoutes() = %v, want only abc", got)
+	}
+}
@@ -0,0 +1,124 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/yaml.v3"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// savedRoutes are the watcher's container routes as of its last reload. A
+// restarted watcher takes them over for containers that are still running
+// instead of inspecting each one again.
+type savedRoutes struct {
+	Saved  time.Time         `yaml:"saved"`
+	Routes map[string]*Route `yaml:"routes"` // keyed by container ID
+}
+
+// saveActiveRoutes records the container routes.
+func saveActiveRoutes(routes *ActiveRoutes, now time.Time) error {
+	data, err := yaml.Marshal(savedRoutes{Saved: now.UTC(), Routes: routes.containerRoutes()})
+	if err != nil {
+		return fmt.Errorf("marshaling active routes: %w", err)
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	return atomicWriteFile(config.ActiveRoutesPath(), data, 0600)
+}
+
+// loadActiveRoutes returns the routes saved by an earlier watcher, or nil
+// if there are none or projects.yml or config.yml changed since, which
+// could change any route.
+func loadActiveRoutes() *savedRoutes {
+	data, err := os.ReadFile(config.ActiveRoutesPath())
+	if err != nil {
+		return nil
+	}
+	var saved savedRoutes
+	if err := yaml.Unmarshal(data, &saved); err != nil || len(saved.Routes) == 0 {
+		return nil
+	}
+	for _, path := range []string{config.ProjectsPath(), config.SettingsPath()} {
+		if modifiedSince(path, saved.Saved) {
+			return nil
+		}
+	}
+	return &saved
+}
+
+// reuse returns the saved route for container c if it still describes
+// it: the container has the same name, is on the gateway network, and its
+// project's .caddy-atc.yml hasn't changed since. Quarantined routes are
+// never reused, so their errors are found and recorded again.
+func (s *savedRoutes) reuse(c types.Container, proj *config.ProjectConfig) *Route {
+	if s == nil {
+		return nil
+	}
+	r := s.Routes[c.ID]
+	if r == nil || r.Quarantine != "" {
+		return nil
+	}
+	if len(c.Names) == 0 || strings.TrimPrefix(c.Names[0], "/") != r.ContainerName {
+		return nil
+	}
+	if c.NetworkSettings == nil || c.NetworkSettings.Networks[gateway.Network()] == nil {
+		return nil
+	}
+	if proj.Dir != "" && projectFileChanged(proj.Dir, s.Saved) {
+		return nil
+	}
+	return r
+}
+
+// modifiedSince reports whether the file at path changed after t. A file
+// that doesn't exist hasn't.
+func modifiedSince(path string, t time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().After(t)
+}
+
+// projectFileChanged reports whether the .caddy-atc.yml or snippet in dir
+// changed after t. A missing file may have been deleted since, so then any
+// change to the directory counts.
+func projectFileChanged(dir string, t time.Time) bool {
+	for _, name := range []string{config.ProjectFileName, config.SnippetFileName} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			if modifiedSince(dir, t) {
+				return true
+			}
+		} else if modifiedSince(path, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// caddyfileCurrent reports whether the Caddyfile on disk is what the
+// routes generate, with or without the user's template. Its stamp and
+// manual sections aren't compared.
+func caddyfileCurrent(routes *ActiveRoutes) bool {
+	content, err := GenerateCaddyfile(routes)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(config.CaddyfilePath())
+	if err != nil {
+		return false
+	}
+	_, current, _ := ParseCaddyfileStamp(string(data))
+	current, _ = SplitManualSections(current)
+	if current == content {
+		return true
+	}
+	templated, err := RenderCaddyfileTemplate(routes)
+	return err == nil && templated != "" && current == templated
+}
@@ -0,0 +1,114 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+func TestSaveActiveRoutes(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if got := loadActiveRoutes(); got != nil {
+		t.Fatalf("loadActiveRoutes() = %+v, want nil before any save", got)
+	}
+
+	web := &Route{Hostname: "web.app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web",
+		Source: SourceLabel, Options: config.ServiceOptions{LBRetries: 2, ReplicaHostnames: true}}
+	routes := NewActiveRoutes()
+	routes.Add("c1", web)
+	routes.SyncStatic([]*config.StaticRoute{{Hostname: "docs.localhost", Upstream: "host.docker.internal:8000"}})
+
+	saved := time.Now().Add(time.Hour) // after any file written by the test
+	if err := saveActiveRoutes(routes, saved); err != nil {
+		t.Fatal(err)
+	}
+	got := loadActiveRoutes()
+	if got == nil {
+		t.Fatal("loadActiveRoutes() = nil after a save")
+	}
+	if want := map[string]*Route{"c1": web}; !reflect.DeepEqual(got.Routes, want) {
+		t.Errorf("routes = %+v, want %+v", got.Routes, want)
+	}
+
+	// A later change to projects.yml could change any route.
+	if err := (&config.Config{}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(config.ProjectsPath(), saved.Add(time.Minute), saved.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadActiveRoutes(); got != nil {
+		t.Errorf("loadActiveRoutes() = %+v after projects.yml changed, want nil", got)
+	}
+}
+
+func TestSavedRoutesReuse(t *testing.T) {
+	dir := t.TempDir()
+	saved := &savedRoutes{
+		Saved: time.Now().Add(time.Hour),
+		Routes: map[string]*Route{
+			"c1": {Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80"},
+			"c2": {Hostname: "api.localhost", ContainerName: "app-api-1", Port: "80", Quarantine: "bad option"},
+		},
+	}
+	proj := &config.ProjectConfig{Dir: dir}
+	onNetwork := &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{gateway.Network(): {}}}
+
+	tests := []struct {
+		name string
+		c    types.Container
+		want bool
+	}{
+		{"unchanged", types.Container{ID: "c1", Names: []string{"/app-web-1"}, NetworkSettings: onNetwork}, true},
+		{"renamed", types.Container{ID: "c1", Names: []string{"/other"}, NetworkSettings: onNetwork}, false},
+		{"disconnected", types.Container{ID: "c1", Names: []string{"/app-web-1"}}, false},
+		{"quarantined", types.Container{ID: "c2", Names: []string{"/app-api-1"}, NetworkSettings: onNetwork}, false},
+		{"unknown", types.Container{ID: "c3", Names: []string{"/app-db-1"}, NetworkSettings: onNetwork}, false},
+	}
+	for _, tt := range tests {
+		if got := saved.reuse(tt.c, proj) != nil; got != tt.want {
+			t.Errorf("%s: reused = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A .caddy-atc.yml written after the save may change the route.
+	if err := os.WriteFile(filepath.Join(dir, config.ProjectFileName), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := saved.Saved.Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, config.ProjectFileName), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if saved.reuse(tests[0].c, proj) != nil {
+		t.Error("route reused after .caddy-atc.yml changed")
+	}
+
+	var none *savedRoutes
+	if none.reuse(tests[0].c, proj) != nil {
+		t.Error("nil savedRoutes reused a route")
+	}
+}
+
+func TestCaddyfileCurrent_Stamped(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routes := quarantineRoutes()
+	if _, err := WriteCaddyfile(routes, "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if !caddyfileCurrent(routes) {
+		t.Error("caddyfileCurrent() = false for the Caddyfile just written")
+	}
+	routes.Add("c4", &Route{Hostname: "new.localhost", ContainerName: "new-1", Port: "80"})
+	if caddyfileCurrent(routes) {
+		t.Error("caddyfileCurrent() = true after a route was added")
+	}
+}
@@ -0,0 +1,173 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// RouteMeta is what the watcher records about a route beyond the route
+// itself. It is stored in a file and kept across watcher restarts, so
+// `status` can show how long a route has existed.
+type RouteMeta struct {
+	Since  time.Time `yaml:"since"`            // when the route appeared
+	Source string    `yaml:"source"`           // one of the Source* constants
+	Health string    `yaml:"health,omitempty"` // last recorded health state
+	Scheme string    `yaml:"scheme,omitempty"` // upstream scheme learned from proxy errors
+}
+
+// RouteMetas maps RouteMetaKey to a route's metadata.
+type RouteMetas map[string]RouteMeta
+
+// RouteMetaKey identifies a route by hostname and upstream.
+func RouteMetaKey(hostname, container, port string) string {
+	return hostname + "|" + container + ":" + port
+}
+
+// routeMetaStore holds the watcher's route metadata. Health checks update
+// it from their own goroutine.
+type routeMetaStore struct {
+	mu     sync.Mutex
+	metas  RouteMetas
+	loaded bool
+}
+
+// sync records the current routes: routes seen before, including by an
+// earlier watcher, keep their metadata, new ones appear as of now and
+// routes that are gone are dropped, except for an upstream scheme learned
+// for them, which applies again when their container is back. Saves only
+// when anything changed.
+func (s *routeMetaStore) sync(routes []*Route, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+
+	metas := make(RouteMetas, len(routes))
+	changed := false
+	add := func(hostname string, r *Route) {
+		key := RouteMetaKey(hostname, r.ContainerName, r.Port)
+		meta, ok := s.metas[key]
+		if !ok || meta.Since.IsZero() {
+			meta.Since = now.UTC()
+			ok = false
+		}
+		if meta.Source != r.Source {
+			meta.Source = r.Source
+			ok = false
+		}
+		changed = changed || !ok
+		metas[key] = meta
+	}
+	for _, r := range routes {
+		add(r.Hostname, r)
+		if r.ReplicaHostname != "" && r.Options.ReplicaHostnames {
+			add(r.ReplicaHostname, r)
+		}
+	}
+	for key, meta := range s.metas {
+		if _, ok := metas[key]; ok || meta.Scheme == "" {
+			continue
+		}
+		kept := RouteMeta{Scheme: meta.Scheme}
+		changed = changed || meta != kept
+		metas[key] = kept
+	}
+	if !changed && len(metas) == len(s.metas) {
+		return nil
+	}
+	s.metas = metas
+	return saveRouteMeta(metas)
+}
+
+// setHealth records the health state of every route to upstream
+// ("container:port").
+func (s *routeMetaStore) setHealth(upstream, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+
+	changed := false
+	for key, meta := range s.metas {
+		if _, up, _ := strings.Cut(key, "|"); up != upstream || meta.Health == status || meta.Since.IsZero() {
+			continue
+		}
+		meta.Health = status
+		s.metas[key] = meta
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return saveRouteMeta(s.metas)
+}
+
+// scheme returns the upstream scheme learned for a route, or "".
+func (s *routeMetaStore) scheme(hostname, container, port string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	return s.metas[RouteMetaKey(hostname, container, port)].Scheme
+}
+
+// setScheme records the upstream scheme learned for a route.
+func (s *routeMetaStore) setScheme(hostname, container, port, scheme string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+
+	key := RouteMetaKey(hostname, container, port)
+	meta, ok := s.metas[key]
+	if ok && meta.Scheme == scheme {
+		return nil
+	}
+	if !ok {
+		meta.Since = now.UTC()
+	}
+	meta.Scheme = scheme
+	s.metas[key] = meta
+	return saveRouteMeta(s.metas)
+}
+
+// load reads the metadata saved by an earlier watcher on first use. The
+// caller holds s.mu.
+func (s *routeMetaStore) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	s.metas = LoadRouteMeta()
+	if s.metas == nil {
+		s.metas = make(RouteMetas)
+	}
+}
+
+// saveRouteMeta writes the route metadata.
+func saveRouteMeta(metas RouteMetas) error {
+	data, err := yaml.Marshal(metas)
+	if err != nil {
+		return fmt.Errorf("marshaling route metadata: %w", err)
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	return atomicWriteFile(config.RouteMetaPath(), data, 0600)
+}
+
+// LoadRouteMeta returns the route metadata the watcher last recorded, or
+// nil if there is none.
+func LoadRouteMeta() RouteMetas {
+	data, err := os.ReadFile(config.RouteMetaPath())
+	if err != nil {
+		return nil
+	}
+	var metas RouteMetas
+	if err := yaml.Unmarshal(data, &metas); err != nil {
+		return nil
+	}
+	return metas
+}
@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestRouteMetaStore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	web := &Route{Hostname: "web.app.localhost", ContainerName: "app-web-1", Port: "3000", Source: SourceHeuristic,
+		ReplicaHostname: "web-1.app.localhost", Options: config.ServiceOptions{ReplicaHostnames: true}}
+	api := &Route{Hostname: "api.app.localhost", ContainerName: "app-api-1", Port: "8080", Source: SourceLabel}
+
+	first := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var s routeMetaStore
+	if err := s.sync([]*Route{web}, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setHealth("app-web-1:3000", HealthHealthy); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setScheme("web.app.localhost", "app-web-1", "3000", "https", first); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new watcher keeps when known routes appeared and their health.
+	later := first.Add(time.Hour)
+	var restarted routeMetaStore
+	if err := restarted.sync([]*Route{web, api}, later); err != nil {
+		t.Fatal(err)
+	}
+	want := RouteMetas{
+		RouteMetaKey("web.app.localhost", "app-web-1", "3000"):   {Since: first, Source: SourceHeuristic, Health: HealthHealthy, Scheme: "https"},
+		RouteMetaKey("web-1.app.localhost", "app-web-1", "3000"): {Since: first, Source: SourceHeuristic, Health: HealthHealthy},
+		RouteMetaKey("api.app.localhost", "app-api-1", "8080"):   {Since: later, Source: SourceLabel},
+	}
+	if got := LoadRouteMeta(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadRouteMeta() = %+v, want %+v", got, want)
+	}
+	if got := restarted.scheme("web.app.localhost", "app-web-1", "3000"); got != "https" {
+		t.Errorf("scheme() = %q, want https", got)
+	}
+
+	// Routes that are gone are dropped, but for a learned scheme.
+	if err := restarted.sync([]*Route{api}, later); err != nil {
+		t.Fatal(err)
+	}
+	want = RouteMetas{
+		RouteMetaKey("web.app.localhost", "app-web-1", "3000"): {Scheme: "https"},
+		RouteMetaKey("api.app.localhost", "app-api-1", "8080"): {Since: later, Source: SourceLabel},
+	}
+	if got := LoadRouteMeta(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadRouteMeta() after removal = %+v, want %+v", got, want)
+	}
+
+	// When the container is back, the route appears anew with its scheme.
+	back := later.Add(time.Hour)
+	if err := restarted.sync([]*Route{web, api}, back); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.scheme("web.app.localhost", "app-web-1", "3000"); got != "https" {
+		t.Errorf("scheme() after re-adding = %q, want https", got)
+	}
+	got := LoadRouteMeta()[RouteMetaKey("web.app.localhost", "app-web-1", "3000")]
+	if want := (RouteMeta{Since: back, Source: SourceHeuristic, Scheme: "https"}); got != want {
+		t.Errorf("re-added route meta = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// RouteSnapshot is the set of routes the watcher last wrote to the
+// Caddyfile. It is stored in a file so `status` and `routes` can show the
+// last-known state while the Docker daemon is unreachable.
+type RouteSnapshot struct {
+	Saved  time.Time    `yaml:"saved"`
+	Routes []KnownRoute `yaml:"routes"`
+
+	// DriftRepairs counts the routes the watcher that saved the snapshot
+	// added or removed after missing a container event.
+	DriftRepairs uint64 `yaml:"drift_repairs,omitempty"`
+}
+
+// KnownRoute is one route of a RouteSnapshot. Replica hostnames are listed
+// as routes of their own.
+type KnownRoute struct {
+	Hostname      string `yaml:"hostname"`
+	ContainerName string `yaml:"container"`
+	Port          string `yaml:"port"`
+	Project       string `yaml:"project,omitempty"`
+	Service       string `yaml:"service,omitempty"`
+	Quarantine    string `yaml:"quarantine,omitempty"`
+}
+
+// saveSnapshot records the current routes and the watcher's drift repairs.
+func saveSnapshot(routes *ActiveRoutes, now time.Time, driftRepairs uint64) error {
+	snap := RouteSnapshot{Saved: now.UTC(), DriftRepairs: driftRepairs}
+	for _, r := range routes.All() {
+		known := KnownRoute{
+			Hostname:      r.Hostname,
+			ContainerName: r.ContainerName,
+			Port:          r.Port,
+			Project:       r.Project,
+			Service:       r.Service,
+			Quarantine:    r.Quarantine,
+		}
+		snap.Routes = append(snap.Routes, known)
+		if r.ReplicaHostname != "" && r.Options.ReplicaHostnames {
+			known.Hostname = r.ReplicaHostname
+			snap.Routes = append(snap.Routes, known)
+		}
+	}
+	sort.Slice(snap.Routes, func(i, j int) bool {
+		a, b := snap.Routes[i], snap.Routes[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		return a.ContainerName < b.ContainerName
+	})
+
+	data, err := yaml.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("marshaling route snapshot: %w", err)
+	}
+	if err := config.EnsureHomeDir(); err != nil {
+		return err
+	}
+	return atomicWriteFile(config.RouteSnapshotPath(), data, 0600)
+}
+
+// LoadSnapshot returns the routes the watcher last served, or nil if it
+// never recorded any.
+func LoadSnapshot() *RouteSnapshot {
+	data, err := os.ReadFile(config.RouteSnapshotPath())
+	if err != nil {
+		return nil
+	}
+	var snap RouteSnapshot
+	if err := yaml.Unmarshal(data, &snap); err != nil {
+		return nil
+	}
+	return &snap
+}
@@ -0,0 +1,41 @@
+package watcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestSaveSnapshot(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if got := LoadSnapshot(); got != nil {
+		t.Fatalf("LoadSnapshot() = %+v, want nil before any save", got)
+	}
+
+	routes := NewActiveRoutes()
+	routes.Add("c2", &Route{Hostname: "web.app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web",
+		ReplicaHostname: "web-1.app.localhost", Options: config.ServiceOptions{ReplicaHostnames: true}})
+	routes.Add("c1", &Route{Hostname: "api.app.localhost", ContainerName: "app-api-1", Port: "8080", Project: "app", Service: "api",
+		Quarantine: "bad option"})
+
+	saved := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := saveSnapshot(routes, saved, 2); err != nil {
+		t.Fatalf("saveSnapshot() error = %v", err)
+	}
+
+	want := &RouteSnapshot{
+		Saved:        saved,
+		DriftRepairs: 2,
+		Routes: []KnownRoute{
+			{Hostname: "api.app.localhost", ContainerName: "app-api-1", Port: "8080", Project: "app", Service: "api", Quarantine: "bad option"},
+			{Hostname: "web-1.app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web"},
+			{Hostname: "web.app.localhost", ContainerName: "app-web-1", Port: "3000", Project: "app", Service: "web"},
+		},
+	}
+	if got := LoadSnapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadSnapshot() = %+v, want %+v", got, want)
+	}
+}
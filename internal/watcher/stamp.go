@@ -0,0 +1,142 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// stampPrefix starts the header line the watcher puts on the Caddyfiles it
+// writes.
+const stampPrefix = "# caddy-atc version="
+
+// Lines delimiting a manual section: lines of the Caddyfile the watcher
+// carries over each time it regenerates it.
+const (
+	manualBegin = "# caddy-atc manual begin"
+	manualEnd   = "# caddy-atc manual end"
+)
+
+// CaddyfileStamp is the header of a Caddyfile written by the watcher: the
+// caddy-atc version and time that generated it, and a checksum of what
+// follows, which tells hand edits apart.
+type CaddyfileStamp struct {
+	Version   string
+	Generated time.Time
+	Sum       string // hex SHA-256 of the content after the header line, apart from manual sections
+}
+
+// stampCaddyfile returns content followed by the manual sections under a
+// header line stamping it as generated by version at at.
+func stampCaddyfile(content, manual, version string, at time.Time) string {
+	if version == "" || strings.ContainsFunc(version, func(r rune) bool { return r <= ' ' || r > '~' }) {
+		version = "unknown"
+	}
+	sum := sha256.Sum256([]byte(content))
+	stamped := fmt.Sprintf("%s%s generated=%s sha256=%s\n", stampPrefix, version, at.UTC().Format(time.RFC3339), hex.EncodeToString(sum[:])) + content
+	if manual != "" {
+		stamped += "\n" + manual
+	}
+	return stamped
+}
+
+// ParseCaddyfileStamp splits a Caddyfile into its stamp and the content
+// after it. ok is false if it has no stamp, as with Caddyfiles written by
+// hand or by older versions; content is then all of data.
+func ParseCaddyfileStamp(data string) (stamp CaddyfileStamp, content string, ok bool) {
+	line, rest, found := strings.Cut(data, "\n")
+	if !found || !strings.HasPrefix(line, stampPrefix) {
+		return CaddyfileStamp{}, data, false
+	}
+	for _, field := range strings.Fields(strings.TrimPrefix(line, "# caddy-atc ")) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "version":
+			stamp.Version = value
+		case "generated":
+			stamp.Generated, _ = time.Parse(time.RFC3339, value)
+		case "sha256":
+			stamp.Sum = value
+		}
+	}
+	if stamp.Sum == "" {
+		return CaddyfileStamp{}, data, false
+	}
+	return stamp, rest, true
+}
+
+// Matches reports whether content, apart from its manual sections, is what
+// the stamp was computed over, i.e. the Caddyfile wasn't edited since the
+// watcher wrote it.
+func (s CaddyfileStamp) Matches(content string) bool {
+	generated, _ := SplitManualSections(content)
+	sum := sha256.Sum256([]byte(generated))
+	return s.Sum == hex.EncodeToString(sum[:])
+}
+
+// SplitManualSections splits Caddyfile content into what the watcher
+// generated and the manual sections, each with its marker lines, in order.
+// A blank line before a section goes with it. A section without an end
+// line isn't one.
+func SplitManualSections(content string) (generated, manual string) {
+	lines := strings.SplitAfter(content, "\n")
+	var kept []string
+	var sections strings.Builder
+	start := -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case manualBegin:
+			if start < 0 {
+				start = i
+				continue
+			}
+		case manualEnd:
+			if start >= 0 {
+				if n := len(kept); n > 0 && strings.TrimSpace(kept[n-1]) == "" {
+					kept = kept[:n-1]
+				}
+				section := strings.Join(lines[start:i+1], "")
+				if !strings.HasSuffix(section, "\n") {
+					section += "\n"
+				}
+				sections.WriteString(section)
+				start = -1
+				continue
+			}
+		}
+		if start < 0 {
+			kept = append(kept, line)
+		}
+	}
+	if start >= 0 {
+		kept = append(kept, lines[start:]...)
+	}
+	return strings.Join(kept, ""), sections.String()
+}
+
+// preserveCaddyfileEdits reads the Caddyfile at path before it is replaced
+// and returns its manual sections. If it was edited by hand outside them,
+// it is first copied beside it, and the copy's path returned as backup.
+// Unstamped Caddyfiles can't be checked and aren't copied.
+func preserveCaddyfileEdits(path string, now time.Time) (manual, backup string, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	stamp, content, ok := ParseCaddyfileStamp(string(data))
+	_, manual = SplitManualSections(content)
+	if !ok || stamp.Matches(content) {
+		return manual, "", nil
+	}
+	backup = path + ".edited-" + now.Format("20060102-150405")
+	if err := atomicWriteFile(backup, data, 0600); err != nil {
+		return "", "", fmt.Errorf("backing up the hand-edited Caddyfile: %w", err)
+	}
+	return manual, backup, nil
+}
@@ -0,0 +1,130 @@
+package watcher
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func TestCaddyfileStamp(t *testing.T) {
+	content := "# Auto-generated by caddy-atc - do not edit manually\n{\n    local_certs\n}\n"
+	at := time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC)
+	stamped := stampCaddyfile(content, "", "v1.4.0", at)
+	if !strings.HasPrefix(stamped, "# caddy-atc version=v1.4.0 generated=2026-10-16T08:30:00Z sha256=") {
+		t.Errorf("stamped Caddyfile starts %q", strings.SplitN(stamped, "\n", 2)[0])
+	}
+
+	stamp, got, ok := ParseCaddyfileStamp(stamped)
+	if !ok || got != content || stamp.Version != "v1.4.0" || !stamp.Generated.Equal(at) {
+		t.Fatalf("ParseCaddyfileStamp() = %+v, %q, %v", stamp, got, ok)
+	}
+	if !stamp.Matches(got) {
+		t.Error("unedited Caddyfile does not match its stamp")
+	}
+	if stamp.Matches(strings.Replace(got, "local_certs", "local_certs\n    debug", 1)) {
+		t.Error("edited Caddyfile matches its stamp")
+	}
+
+	if _, got, ok := ParseCaddyfileStamp(content); ok || got != content {
+		t.Errorf("ParseCaddyfileStamp(unstamped) = %q, %v", got, ok)
+	}
+	if line, _, _ := strings.Cut(stampCaddyfile(content, "", "dev build", at), "\n"); !strings.Contains(line, "version=unknown ") {
+		t.Errorf("stamp with an unsafe version = %q", line)
+	}
+}
+
+func TestSplitManualSections(t *testing.T) {
+	content := `{
+    local_certs
+}
+
+# caddy-atc manual begin
+legacy.localhost {
+    reverse_proxy host.docker.internal:8080
+}
+# caddy-atc manual end
+
+app.localhost {
+    reverse_proxy app-web-1:3000
+}
+# caddy-atc manual begin
+# caddy-atc manual end
+`
+	wantGenerated := "{\n    local_certs\n}\n\napp.localhost {\n    reverse_proxy app-web-1:3000\n}\n"
+	wantManual := "# caddy-atc manual begin\nlegacy.localhost {\n    reverse_proxy host.docker.internal:8080\n}\n# caddy-atc manual end\n" +
+		"# caddy-atc manual begin\n# caddy-atc manual end\n"
+	generated, manual := SplitManualSections(content)
+	if generated != wantGenerated {
+		t.Errorf("generated = %q, want %q", generated, wantGenerated)
+	}
+	if manual != wantManual {
+		t.Errorf("manual = %q, want %q", manual, wantManual)
+	}
+
+	// Without an end line, the lines stay part of the generated content.
+	unterminated := "app.localhost {\n}\n# caddy-atc manual begin\nextra\n"
+	if generated, manual := SplitManualSections(unterminated); generated != unterminated || manual != "" {
+		t.Errorf("SplitManualSections(unterminated) = %q, %q", generated, manual)
+	}
+}
+
+func TestWriteCaddyfile_PreservesEdits(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routes := quarantineRoutes()
+	if _, err := WriteCaddyfile(routes, "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	path := config.CaddyfilePath()
+	read := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// A manual section is kept and doesn't count as an edit.
+	section := "# caddy-atc manual begin\nlegacy.localhost {\n    reverse_proxy host.docker.internal:8080\n}\n# caddy-atc manual end\n"
+	if err := os.WriteFile(path, []byte(read()+"\n"+section), 0600); err != nil {
+		t.Fatal(err)
+	}
+	routes.Add("c4", &Route{Hostname: "new.localhost", ContainerName: "new-1", Port: "80"})
+	backup, err := WriteCaddyfile(routes, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != "" {
+		t.Errorf("backup = %q for a manual section, want none", backup)
+	}
+	written := read()
+	if !strings.HasSuffix(written, "}\n\n"+section) || !strings.Contains(written, "new.localhost {") {
+		t.Errorf("Caddyfile lost the manual section or the new route:\n%s", written)
+	}
+	stamp, content, _ := ParseCaddyfileStamp(written)
+	if !stamp.Matches(content) {
+		t.Error("Caddyfile with a manual section does not match its stamp")
+	}
+
+	// Any other edit is backed up before the Caddyfile is regenerated.
+	edited := strings.Replace(written, "reverse_proxy app-web-1:3000", "reverse_proxy app-web-1:3001", 1)
+	if err := os.WriteFile(path, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backup, err = WriteCaddyfile(routes, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup == "" {
+		t.Fatal("no backup of the hand-edited Caddyfile")
+	}
+	if data, err := os.ReadFile(backup); err != nil || string(data) != edited {
+		t.Errorf("backup = %q, %v, want the edited Caddyfile", data, err)
+	}
+	if got := read(); strings.Contains(got, "app-web-1:3001") || !strings.HasSuffix(got, section) {
+		t.Errorf("regenerated Caddyfile:\n%s", got)
+	}
+}
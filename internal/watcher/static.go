@@ -0,0 +1,204 @@
+package watcher
+
+import (
+	"net"
+	"strings"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// staticKeyPrefix namespaces static routes within ActiveRoutes so they
+// can't collide with container IDs.
+const staticKeyPrefix = "static:"
+
+// SyncStatic replaces the static routes held in ar with the given set.
+// Routes must already be validated. Returns true if the set changed.
+func (ar *ActiveRoutes) SyncStatic(static []*config.StaticRoute) bool {
+	want := make(map[string]*Route, len(static))
+	for _, sr := range static {
+		if sr.Root != "" {
+			key := staticKeyPrefix + sr.Hostname + "|" + sr.Root
+			if sr.SPA {
+				key += "|spa"
+			}
+			want[key] = &Route{
+				Hostname: sr.Hostname,
+				Root:     sr.MountPath(),
+				SPA:      sr.SPA,
+				Source:   SourceManual,
+			}
+			continue
+		}
+		host, port, err := net.SplitHostPort(sr.Upstream)
+		if err != nil {
+			continue
+		}
+		want[staticKeyPrefix+sr.Hostname+"|"+sr.Upstream] = &Route{
+			Hostname:      sr.Hostname,
+			ContainerName: host,
+			Port:          port,
+			Source:        SourceManual,
+		}
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	changed := false
+	for key := range ar.routes {
+		if !isStaticKey(key) {
+			continue
+		}
+		if _, ok := want[key]; !ok {
+			delete(ar.routes, key)
+			changed = true
+		}
+	}
+	for key, r := range want {
+		if _, ok := ar.routes[key]; !ok {
+			ar.routes[key] = r
+			changed = true
+		}
+	}
+	return changed
+}
+
+// SyncOptions refreshes the per-service options, snippets, retry pages and
+// mixed-content modes of container routes from cfg and each project's
+// .caddy-atc.yml, so option edits in projects.yml apply without restarting
+// containers. Returns true if any route changed.
+func (ar *ActiveRoutes) SyncOptions(cfg *config.Config) bool {
+	// Invalid project files are reported when containers start.
+	projectFiles := make(map[string]*config.ProjectFile, len(cfg.Projects))
+	for name, proj := range cfg.Projects {
+		projectFiles[name], _ = config.LoadProjectFile(proj.Dir)
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	changed := false
+	for key, r := range ar.routes {
+		if isStaticKey(key) || r.Project == "" {
+			continue
+		}
+		projName, projCfg := cfg.FindProjectByComposeProject(r.Project)
+		if projCfg == nil {
+			continue
+		}
+		opts := projCfg.EffectiveServiceOptions(r.Service, projectFiles[projName])
+		snippet := projectFiles[projName].SiteSnippet()
+		if opts != r.Options || snippet != r.Snippet || projCfg.RetryPage != r.RetryPage ||
+			projCfg.MixedContent != r.MixedContent {
+			// Routes are shared with readers; replace rather than mutate.
+			updated := *r
+			updated.Options = opts
+			updated.Snippet = snippet
+			updated.RetryPage = projCfg.RetryPage
+			updated.MixedContent = projCfg.MixedContent
+			updated.Quarantine = "" // give the new options a chance
+			ar.routes[key] = &updated
+			changed = true
+		}
+	}
+	return changed
+}
+
+func isStaticKey(key string) bool {
+	return strings.HasPrefix(key, staticKeyPrefix)
+}
+
+// refreshStaticRoutes reloads static routes, per-service options and
+// gateway settings from projects.yml when the file has changed since the
+// last check. Invalid static routes are logged and skipped. Returns true if
+// the Caddyfile needs regenerating.
+func (w *Watcher) refreshStaticRoutes() bool {
+	// Either file changing reloads both.
+	stamp := currentConfigStamp()
+	if w.configSeen && stamp == w.configStamp {
+		return false
+	}
+	w.configSeen = true
+	w.configStamp = stamp
+
+	cfg, err := w.config.load()
+	if err != nil {
+		w.logger.Error("Loading config failed", "err", err)
+		return false
+	}
+
+	lazy, idle, err := cfg.LazyGateway()
+	if err != nil {
+		w.logger.Warn("Invalid setting", "err", err, "using", idle)
+	}
+	w.lazy, w.idleTimeout = lazy, idle
+
+	addr, err := cfg.GatewayAddress()
+	if err != nil {
+		w.logger.Warn("Invalid setting", "err", err, "using", addr)
+	}
+	w.gatewayAddr = addr
+
+	_, w.httpsPort = cfg.HTTPPorts()
+	debounce, err := cfg.ReloadDebounce()
+	if err != nil {
+		w.logger.Warn("Invalid setting", "err", err, "using", debounce)
+	}
+	w.debounce = debounce
+
+	reconcile, err := cfg.ReconcileInterval()
+	if err != nil {
+		w.logger.Warn("Invalid setting", "err", err, "using", reconcile)
+	}
+	w.reconcileInterval = reconcile
+
+	timeout, err := cfg.DockerTimeout()
+	if err != nil {
+		w.logger.Warn("Invalid setting", "err", err, "using", timeout)
+	}
+	gateway.SetDockerTimeout(timeout)
+
+	w.hostsSync = cfg.Hosts != nil && cfg.Hosts.AutoSync
+	w.lanEnabled = cfg.LAN != nil && cfg.LAN.Enabled
+	w.lanName = ""
+	if cfg.LAN != nil {
+		w.lanName = cfg.LAN.Name
+	}
+	w.lanDirty = true
+	w.disabledDirty = true
+
+	var valid []*config.StaticRoute
+	for _, sr := range cfg.StaticRoutes {
+		if err := sr.Validate(); err != nil {
+			w.logger.Warn("Ignoring static route", "hostname", sr.Hostname, "err", err)
+			continue
+		}
+		valid = append(valid, sr)
+	}
+	staticChanged := w.routes.SyncStatic(valid)
+	optionsChanged := w.routes.SyncOptions(cfg)
+	levelChanged := w.routes.SetLogLevel(cfg.GatewayLogLevel())
+
+	var pki config.PKIConfig
+	if cfg.PKI != nil {
+		if err := cfg.PKI.Validate(); err != nil {
+			w.logger.Warn("Ignoring pki settings", "err", err)
+		} else {
+			pki = *cfg.PKI
+		}
+	}
+	pkiChanged := w.routes.SetPKI(pki)
+
+	var exposed string
+	if cfg.LAN != nil && cfg.LAN.Expose != "" {
+		if err := config.ValidateHostname(cfg.LAN.Expose); err != nil {
+			w.logger.Warn("Ignoring lan.expose", "err", err)
+		} else {
+			exposed = cfg.LAN.Expose
+		}
+	}
+	exposedChanged := w.routes.SetExposed(exposed)
+	errorPagesChanged := w.routes.SetErrorPages(cfg.ErrorPageDomains())
+	return staticChanged || optionsChanged || levelChanged || pkiChanged || exposedChanged || errorPagesChanged
+}
@@ -0,0 +1,171 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/gateway"
+)
+
+// TemplateData is what a Caddyfile template is executed with. The built-in
+// Caddyfile is passed in pieces, so a template can rearrange or extend it
+// without rebuilding site blocks itself.
+type TemplateData struct {
+	Builtin string          // the whole built-in Caddyfile
+	Global  string          // its global options block, braces included
+	Sites   []TemplateSite  // its site blocks, in order
+	Routes  []TemplateRoute // the routes served, sorted by hostname
+}
+
+// TemplateSite is one site block of the built-in Caddyfile.
+type TemplateSite struct {
+	Address  string // the block's site address(es)
+	Hostname string // the route hostname the block serves; "" for the LAN site
+	Block    string // the block, address line and closing brace included
+}
+
+// TemplateRoute is a served route.
+type TemplateRoute struct {
+	Hostname  string
+	Container string // "" for file routes
+	Port      string
+	Project   string
+	Service   string
+	Root      string // gateway directory of a file route
+}
+
+// templateFuncs are the functions available to Caddyfile templates.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	// replace replaces every old in s with new, taking s last for pipelines.
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	// indent prefixes every non-empty line of s with n spaces.
+	"indent": func(n int, s string) string {
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = strings.Repeat(" ", n) + line
+			}
+		}
+		return strings.Join(lines, "\n")
+	},
+}
+
+// RenderCaddyfileTemplate executes the user's Caddyfile template for
+// routes. It returns "" without error if there is no template.
+func RenderCaddyfileTemplate(routes *ActiveRoutes) (string, error) {
+	data, err := os.ReadFile(config.CaddyfileTemplatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New("Caddyfile").Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return "", err
+	}
+	builtin, spans, err := renderCaddyfile(routes)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, newTemplateData(builtin, spans, routes)); err != nil {
+		return "", err
+	}
+	// Manual sections carried over from the last Caddyfile follow it.
+	if rendered := out.String(); rendered != "" && !strings.HasSuffix(rendered, "\n") {
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+// newTemplateData splits the built-in Caddyfile into its global options
+// and site blocks. Blocks open on an unindented line and close with an
+// unindented "}", which is how renderCaddyfile writes them.
+func newTemplateData(builtin string, spans []siteSpan, routes *ActiveRoutes) TemplateData {
+	d := TemplateData{Builtin: builtin}
+	owner := make(map[int]string, len(spans))
+	for _, s := range spans {
+		owner[s.first] = s.hostname
+	}
+
+	lines := strings.Split(builtin, "\n")
+	start := -1
+	for i, line := range lines {
+		switch {
+		case start < 0 && strings.HasSuffix(line, "{") && line != "" && line[0] != ' ' && line[0] != '#':
+			start = i
+		case start >= 0 && line == "}":
+			block := strings.Join(lines[start:i+1], "\n") + "\n"
+			if lines[start] == "{" {
+				d.Global = block
+			} else {
+				d.Sites = append(d.Sites, TemplateSite{
+					Address:  strings.TrimSuffix(lines[start], " {"),
+					Hostname: owner[start+1],
+					Block:    block,
+				})
+			}
+			start = -1
+		}
+	}
+
+	for _, r := range routes.All() {
+		if r.Quarantine != "" {
+			continue
+		}
+		tr := TemplateRoute{Hostname: r.Hostname, Project: r.Project, Service: r.Service, Root: r.Root}
+		if r.Root == "" {
+			tr.Container, tr.Port = r.ContainerName, r.Port
+		}
+		d.Routes = append(d.Routes, tr)
+	}
+	return d
+}
+
+// applyCaddyfileTemplate replaces the built-in Caddyfile just written with
+// the user's template, if there is one, once the running gateway's `caddy
+// validate` accepts it. Otherwise the built-in Caddyfile is put back. A
+// stopped gateway can't validate the template, so the gateway starts on the
+// built-in Caddyfile; it reports true then, for the caller to apply the
+// template again once the gateway runs.
+func (w *Watcher) applyCaddyfileTemplate(ctx context.Context) (retry bool) {
+	content, err := RenderCaddyfileTemplate(w.routes)
+	if err == nil && content == "" {
+		return false
+	}
+	path := config.CaddyfileTemplatePath()
+	builtin, readErr := os.ReadFile(config.CaddyfilePath())
+	if readErr != nil {
+		w.logger.Warn("Reading Caddyfile failed", "err", readErr)
+		return false
+	}
+	if err == nil {
+		_, written, _ := ParseCaddyfileStamp(string(builtin))
+		_, manual := SplitManualSections(written)
+		err = atomicWriteFile(config.CaddyfilePath(), []byte(stampCaddyfile(content, manual, w.opts.Version, time.Now())), 0600)
+	}
+	if err == nil {
+		err = gateway.ValidateConfig(ctx)
+		if err != nil && isContainerStoppedErr(err) {
+			w.logger.Debug("Gateway not running, applying the Caddyfile template once it is", "path", path)
+			retry, err = true, nil
+		}
+	}
+	if err != nil {
+		w.logger.Warn("Invalid Caddyfile template, using the built-in Caddyfile", "path", path, "err", err)
+	} else if !retry {
+		return false
+	}
+	if err := atomicWriteFile(config.CaddyfilePath(), builtin, 0600); err != nil {
+		w.logger.Warn("Restoring the built-in Caddyfile failed", "err", err)
+	}
+	return retry
+}
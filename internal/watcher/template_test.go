@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+func writeTemplate(t *testing.T, content string) {
+	t.Helper()
+	path := config.CaddyfileTemplatePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeDocker puts a docker script running body first in PATH.
+func fakeDocker(t *testing.T, body string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as docker")
+	}
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRenderCaddyfileTemplate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Project: "app", Service: "web",
+		Options: config.ServiceOptions{RedirectFrom: "www.app.localhost"}})
+	routes.Add("c2", &Route{Hostname: "api.localhost", ContainerName: "app-api-1", Port: "3000", Project: "app", Service: "api"})
+
+	if got, err := RenderCaddyfileTemplate(routes); err != nil || got != "" {
+		t.Fatalf("RenderCaddyfileTemplate() without a template = %q, %v", got, err)
+	}
+
+	writeTemplate(t, `{{.Global}}
+(team) {
+    header X-Team platform
+}
+{{range .Sites}}
+# {{.Hostname}}
+{{.Block | replace "    tls internal\n" "    tls internal\n    import team\n"}}{{end}}
+{{- range .Routes}}
+# route {{.Hostname}} -> {{.Container}}:{{.Port}} ({{.Project}}/{{.Service}}){{end}}
+`)
+	got, err := RenderCaddyfileTemplate(routes)
+	if err != nil {
+		t.Fatalf("RenderCaddyfileTemplate() error = %v", err)
+	}
+	builtin, err := GenerateCaddyfile(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"{\n    local_certs\n",
+		"(team) {\n",
+		"# api.localhost\napi.localhost {\n    tls internal\n    import team\n",
+		"# app.localhost\nwww.app.localhost {\n    tls internal\n    import team\n    redir https://app.localhost",
+		"# route app.localhost -> app-web-1:80 (app/web)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in rendered template:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "reverse_proxy") != strings.Count(builtin, "reverse_proxy") {
+		t.Errorf("template output lost site blocks:\n%s", got)
+	}
+
+	writeTemplate(t, "{{.Missing}}")
+	if _, err := RenderCaddyfileTemplate(routes); err == nil {
+		t.Error("expected error for an unknown field")
+	}
+	writeTemplate(t, "{{range}")
+	if _, err := RenderCaddyfileTemplate(routes); err == nil {
+		t.Error("expected error for an unparsable template")
+	}
+}
+
+func TestApplyCaddyfileTemplate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var buf bytes.Buffer
+	w := &Watcher{routes: NewActiveRoutes(), logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	w.routes.Add("c1", &Route{Hostname: "app.localhost", ContainerName: "app-web-1", Port: "80", Project: "app", Service: "web"})
+	writeTemplate(t, "{{.Global}}{{range .Sites}}{{.Block}}{{end}}\n# templated\n")
+
+	apply := func(docker string) (bool, string) {
+		t.Helper()
+		buf.Reset()
+		if _, err := WriteCaddyfile(w.routes, "test"); err != nil {
+			t.Fatal(err)
+		}
+		fakeDocker(t, docker)
+		retry := w.applyCaddyfileTemplate(context.Background())
+		data, err := os.ReadFile(config.CaddyfilePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return retry, string(data)
+	}
+
+	// A stopped gateway can't validate the template: it is kept for once
+	// the gateway runs, which starts on the built-in Caddyfile.
+	retry, got := apply(`echo "Error response from daemon: container 4f2a is not running" >&2; exit 1`)
+	if !retry || strings.Contains(got, "# templated") {
+		t.Errorf("with a stopped gateway: retry = %v, Caddyfile templated = %v; want the built-in one and a retry", retry, strings.Contains(got, "# templated"))
+	}
+	if strings.Contains(buf.String(), "Invalid Caddyfile template") {
+		t.Errorf("with a stopped gateway, the template was reported invalid:\n%s", buf.String())
+	}
+
+	retry, got = apply(`exit 0`)
+	if retry || !strings.Contains(got, "# templated") {
+		t.Errorf("with a running gateway: retry = %v, Caddyfile:\n%s", retry, got)
+	}
+
+	retry, got = apply(`echo "Error: adapting config using caddyfile: Caddyfile:3: unrecognized directive: bogus" >&2; exit 1`)
+	if retry || strings.Contains(got, "# templated") {
+		t.Errorf("with a rejected template: retry = %v, Caddyfile:\n%s", retry, got)
+	}
+	if !strings.Contains(buf.String(), "Invalid Caddyfile template") {
+		t.Errorf("rejected template not reported:\n%s", buf.String())
+	}
+}
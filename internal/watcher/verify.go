@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// verify requests the route's hostname through the gateway in the
+// background and logs how it responded, so a broken setup shows up as soon
+// as a project's first route goes live. Wildcard hostnames are skipped.
+func (w *Watcher) verify(ctx context.Context, route *Route) {
+	if w.opts.Observe || strings.HasPrefix(route.Hostname, "*.") {
+		return
+	}
+	// The event loop may change the port while the request runs.
+	addr, url := w.gatewayHTTPS(), config.SiteURL(route.Hostname, w.httpsPort)
+	go func() {
+		status, elapsed, err := VerifyRoute(ctx, addr, route.Hostname)
+		switch {
+		case err != nil:
+			w.logger.Warn("Verification failed", "event", "verify", "url", url, "err", err)
+		case status >= 500:
+			w.logger.Warn("Verification failed, is the container listening on the port?", "event", "verify", "url", url, "status", status, "elapsed", elapsed, "upstream", route.ContainerName+":"+route.Port)
+		default:
+			w.logger.Info("Verified", "event", "verify", "url", url, "status", status, "elapsed", elapsed)
+		}
+	}()
+}
+
+// gatewayHTTPS returns where the gateway's HTTPS port is reached.
+func (w *Watcher) gatewayHTTPS() string {
+	port := w.httpsPort
+	if port == 0 {
+		port = config.DefaultHTTPSPort
+	}
+	return net.JoinHostPort(w.gatewayAddr, strconv.Itoa(port))
+}
+
+// VerifyRoute requests https://hostname/ through the gateway at addr and
+// returns the response status and how long it took.
+func VerifyRoute(ctx context.Context, addr, hostname string) (int, time.Duration, error) {
+	start := time.Now()
+	status, err := warmUpRequest(ctx, addr, hostname, "/")
+	return status, time.Since(start).Round(time.Millisecond), err
+}
+
+// projectRouted reports whether any container route belongs to project.
+func (ar *ActiveRoutes) projectRouted(project string) bool {
+	for _, r := range ar.All() {
+		if r.Project == project {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package watcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoute(t *testing.T) {
+	var gotHost, gotPath string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotPath = r.Host, r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	status, elapsed, err := VerifyRoute(context.Background(), strings.TrimPrefix(srv.URL, "https://"), "myapp.localhost")
+	if err != nil {
+		t.Fatalf("VerifyRoute() error = %v", err)
+	}
+	if status != http.StatusOK || elapsed < 0 {
+		t.Errorf("VerifyRoute() = %d, %s", status, elapsed)
+	}
+	if gotHost != "myapp.localhost" || gotPath != "/" {
+		t.Errorf("request = %s%s, want myapp.localhost/", gotHost, gotPath)
+	}
+}
+
+func TestActiveRoutes_ProjectRouted(t *testing.T) {
+	routes := NewActiveRoutes()
+	routes.Add("c1", &Route{Hostname: "myapp.localhost", ContainerName: "myapp-web-1", Port: "80", Project: "myapp"})
+
+	if !routes.projectRouted("myapp") {
+		t.Error("projectRouted(myapp) = false, want true")
+	}
+	if routes.projectRouted("shop") {
+		t.Error("projectRouted(shop) = true, want false")
+	}
+}
@@ -0,0 +1,70 @@
+package watcher
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/g-brodiei/caddy-atc/internal/config"
+)
+
+// warmupTimeout bounds a single warm-up request; cold JVM or .NET
+// backends can take a while to answer their first request.
+const warmupTimeout = 60 * time.Second
+
+// warmUp requests the route's warm-up path in the background, if configured,
+// and logs the outcome. Routes with wildcard hostnames are skipped since
+// there is no concrete hostname to request.
+func (w *Watcher) warmUp(ctx context.Context, route *Route) {
+	if w.opts.Observe || route.Options.WarmupPath == "" || strings.HasPrefix(route.Hostname, "*.") {
+		return
+	}
+	// The event loop may change the port while the request runs.
+	addr, url := w.gatewayHTTPS(), config.SiteURL(route.Hostname, w.httpsPort)+route.Options.WarmupPath
+	go func() {
+		start := time.Now()
+		status, err := warmUpRequest(ctx, addr, route.Hostname, route.Options.WarmupPath)
+		if err != nil {
+			w.logger.Warn("Warm-up failed", "event", "warmup", "url", url, "err", err)
+			return
+		}
+		w.logger.Info("Warmed up", "event", "warmup", "url", url, "status", status, "elapsed", time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// warmUpRequest sends a GET for hostname+path to the gateway at addr and
+// returns the response status. The gateway serves certificates from its own
+// internal CA, which the host may not trust, so verification is skipped.
+func warmUpRequest(ctx context.Context, addr, hostname, path string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSClientConfig: &tls.Config{ServerName: hostname, InsecureSkipVerify: true},
+		},
+		// Report redirects (e.g. to a login page) rather than following them.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+hostname+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,46 @@
+package watcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWarmUpRequest(t *testing.T) {
+	var gotHost, gotPath string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotPath = r.Host, r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "https://")
+	status, err := warmUpRequest(context.Background(), addr, "app.localhost", "/health")
+	if err != nil {
+		t.Fatalf("warmUpRequest() error = %v", err)
+	}
+	if status != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", status, http.StatusNoContent)
+	}
+	if gotHost != "app.localhost" || gotPath != "/health" {
+		t.Errorf("request = %s%s, want app.localhost/health", gotHost, gotPath)
+	}
+}
+
+func TestWarmUpRequest_DoesNotFollowRedirects(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "https://")
+	status, err := warmUpRequest(context.Background(), addr, "app.localhost", "/")
+	if err != nil {
+		t.Fatalf("warmUpRequest() error = %v", err)
+	}
+	if status != http.StatusFound {
+		t.Errorf("status = %d, want %d", status, http.StatusFound)
+	}
+}
@@ -3,38 +3,143 @@ package watcher
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/g-brodiei/caddy-atc/internal/config"
 	"github.com/g-brodiei/caddy-atc/internal/gateway"
 )
 
+// Options configures a Watcher.
+type Options struct {
+	// Observe logs the routes and Caddyfile the watcher would produce
+	// without connecting containers to the network or touching the gateway.
+	Observe bool
+
+	// Version is the caddy-atc version stamped on the Caddyfiles written.
+	Version string
+}
+
 // Watcher monitors Docker events and manages routes.
 type Watcher struct {
 	cli    *client.Client
 	routes *ActiveRoutes
-	logger *log.Logger
+	logger *slog.Logger
+	opts   Options
+
+	// paused mirrors the pause marker as of the last control tick;
+	// pending records that a reload was deferred while paused.
+	paused  bool
+	pending bool
+
+	// configStamp identifies projects.yml and config.yml as of the last
+	// static route refresh; configSeen is false until the first refresh.
+	configStamp configStamp
+	configSeen  bool
+
+	// reconcileInterval is how often routes are checked against the
+	// running containers, as of lastReconcile; streamDown is set while the
+	// Docker event stream is reconnecting.
+	reconcileInterval time.Duration
+	lastReconcile     time.Time
+	streamDown        bool
+
+	// disabled holds the compose projects disabled in projects.yml as of
+	// the last sync; disabledDirty is set when the file changed since.
+	disabled      map[string]bool
+	disabledDirty bool
+
+	// restored holds the routes saved by the previous watcher until the
+	// first scan takes over those still valid.
+	restored *savedRoutes
+
+	// meta records when each route appeared and its last health.
+	meta routeMetaStore
+
+	// config caches the parsed projects.yml and config.yml for event
+	// handling.
+	config configCache
+
+	// lazy and idleTimeout mirror the gateway's lazy startup settings;
+	// idleSince is when the last route went away.
+	lazy        bool
+	idleTimeout time.Duration
+	idleSince   time.Time
+
+	// gatewayAddr is where the gateway's published ports are reached, and
+	// httpsPort its HTTPS port there.
+	gatewayAddr string
+	httpsPort   int
+
+	// stopping holds when routed containers that stopped lose their
+	// route, unless they start again first.
+	stopping map[string]time.Time
+
+	// debounce mirrors the reload debounce setting; reloadTimer fires a
+	// debounced reload, after which the afterReload callbacks run.
+	// reloadSince is when the pending reload was first scheduled.
+	debounce    time.Duration
+	reloadTimer *time.Timer
+	reloadSince time.Time
+	afterReload []func()
+
+	// hostsSync mirrors the hosts auto_sync setting; hostsBlock is the
+	// block last written to the hosts files and hostsErr the last failure.
+	hostsSync  bool
+	hostsBlock string
+	hostsErr   string
+
+	// lanEnabled and lanName mirror the LAN mode settings; lanDirty asks
+	// for the LAN addresses to be re-detected before lanChecked+lanInterval.
+	lanEnabled bool
+	lanName    string
+	lanDirty   bool
+	lanChecked time.Time
+	lanErr     string
+
+	// dedup collapses repeated log lines; warned rate-limits identical
+	// warnings per container.
+	dedup  *dedupHandler
+	warnMu sync.Mutex
+	warned map[string]*warnState
+
+	metrics metrics
+
+	// started is when Run began; control carries control socket requests
+	// to the event loop.
+	started time.Time
+	control chan func(context.Context)
 }
 
-// New creates a new Watcher.
-func New(logger *log.Logger) (*Watcher, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// controlInterval is how often the watcher polls for out-of-band state
+// changes (such as pause/resume) made by other caddy-atc processes.
+const controlInterval = time.Second
+
+// New creates a new Watcher logging to logger.
+func New(logger *slog.Logger, opts Options) (*Watcher, error) {
+	cli, err := gateway.NewClient()
 	if err != nil {
 		return nil, fmt.Errorf("connecting to Docker: %w", err)
 	}
 
+	logger, dedup := newDedupLogger(logger)
 	return &Watcher{
-		cli:    cli,
-		routes: NewActiveRoutes(),
-		logger: logger,
+		cli:         cli,
+		routes:      NewActiveRoutes(),
+		logger:      logger,
+		opts:        opts,
+		gatewayAddr: config.DefaultGatewayAddress,
+		httpsPort:   config.DefaultHTTPSPort,
+		dedup:       dedup,
+		control:     make(chan func(context.Context)),
 	}, nil
 }
 
@@ -47,37 +152,147 @@ func (w *Watcher) Close() {
 
 // Run starts the watcher: scans existing containers, then listens for events.
 func (w *Watcher) Run(ctx context.Context) error {
-	w.logger.Println("Starting watcher...")
-
-	// Scan existing containers on startup
+	w.logger.Info("Starting watcher")
+	w.started = time.Now()
+	if w.opts.Observe {
+		w.logger.Info("Observe mode: no network or gateway changes will be made")
+	} else {
+		// Quarantined routes and health states are only meaningful while
+		// this watcher runs.
+		defer clearQuarantine()
+		defer clearHealth()
+		go w.runHealthChecks(ctx)
+		go w.followProxyErrors(ctx)
+	}
+	w.startMetrics(ctx)
+	w.startDashboard(ctx)
+	w.startDNS(ctx)
+	w.startControl(ctx)
+
+	// Load static routes and pins, then scan existing containers on startup
+	w.refreshStaticRoutes()
+	w.checkLAN()
+	w.checkPins()
+	w.restored = loadActiveRoutes()
 	if err := w.scanExisting(ctx); err != nil {
-		w.logger.Printf("Warning: failed to scan existing containers: %v", err)
+		w.logger.Warn("Scanning existing containers failed", "err", err)
 	}
 
 	// Listen for Docker events
-	eventFilter := filters.NewArgs(
-		filters.Arg("type", "container"),
-		filters.Arg("event", "start"),
-		filters.Arg("event", "stop"),
-		filters.Arg("event", "die"),
-	)
+	msgCh, errCh := w.subscribe(ctx)
+
+	w.logger.Info("Watching for container events")
 
-	msgCh, errCh := w.cli.Events(ctx, events.ListOptions{Filters: eventFilter})
+	ticker := time.NewTicker(controlInterval)
+	defer ticker.Stop()
 
-	w.logger.Println("Watching for container events...")
+	// While the event stream is down, retry fires the next reconnection
+	// attempt.
+	var (
+		retry <-chan time.Time
+		delay time.Duration
+		lost  time.Time
+	)
 
 	for {
 		select {
 		case <-ctx.Done():
-			w.logger.Println("Watcher stopping.")
+			w.logger.Info("Watcher stopping")
 			return nil
 		case err := <-errCh:
-			if err != nil {
-				return fmt.Errorf("Docker event error: %w", err)
+			if ctx.Err() != nil {
+				continue // stopping
+			}
+			w.logger.Warn("Lost connection to Docker, reconnecting", "event", "docker_disconnected", "err", err)
+			msgCh, errCh = nil, nil
+			w.streamDown = true
+			lost = time.Now()
+			delay = minReconnectDelay
+			retry = time.After(delay)
+		case <-retry:
+			if !w.reconnect(ctx) {
+				delay = nextReconnectDelay(delay)
+				retry = time.After(delay)
+				continue
 			}
+			retry = nil
+			w.streamDown = false
+			w.logger.Info("Reconnected to Docker, rescanning containers", "event", "docker_reconnected", "after", time.Since(lost).Round(time.Second))
+			msgCh, errCh = w.subscribe(ctx)
+			w.reconcile(ctx)
 		case msg := <-msgCh:
 			w.handleEvent(ctx, msg)
+		case <-w.reloadDue():
+			w.reloadNow(ctx)
+		case <-ticker.C:
+			w.checkControl(ctx)
+		case f := <-w.control:
+			f(ctx)
+		}
+	}
+}
+
+// checkControl applies state changes made by other caddy-atc processes.
+func (w *Watcher) checkControl(ctx context.Context) {
+	w.removeStopped(ctx, time.Now())
+	if w.disabledDirty {
+		w.disabledDirty = false
+		w.syncDisabled(ctx)
+	}
+	w.checkDrift(ctx, time.Now())
+	changed := w.refreshStaticRoutes()
+	if lanChanged, pinned := w.checkLAN(), w.checkPins(); changed || lanChanged || pinned {
+		if changed {
+			w.logger.Info("Routing config changed", "event", "config_changed")
+		}
+		w.reloadNow(ctx)
+	}
+
+	w.syncPause(ctx)
+	w.checkIdle(ctx)
+	if w.dedup != nil {
+		w.dedup.flushStale(time.Now())
+	}
+}
+
+// syncPause applies the pause marker. On resume, any reloads deferred
+// while paused are consolidated into one.
+func (w *Watcher) syncPause(ctx context.Context) {
+	st := CurrentPause()
+	switch {
+	case st != nil && !w.paused:
+		w.paused = true
+		w.logger.Info("Routing paused", "event", "paused", "reason", st.Reason)
+	case st == nil && (w.paused || w.pending):
+		// A pause that began and ended between two ticks leaves only the
+		// deferred reload behind.
+		if w.paused {
+			w.paused = false
+			w.logger.Info("Routing resumed", "event", "resumed")
+		}
+		if w.routes.SetMaintenance(false) {
+			w.pending = true
+		}
+		if w.pending {
+			w.reloadNow(ctx)
 		}
+		return
+	}
+	if st != nil && w.routes.SetMaintenance(st.Maintenance) {
+		w.syncMaintenance(ctx, st.Maintenance)
+	}
+}
+
+// syncMaintenance applies the maintenance page being turned on or off
+// while routing is paused.
+func (w *Watcher) syncMaintenance(ctx context.Context, on bool) {
+	if on {
+		w.logger.Info("Serving the maintenance page (503) on routed hostnames", "event", "maintenance_on")
+	} else {
+		w.logger.Info("Maintenance page off, serving the routes as of the pause", "event", "maintenance_off")
+	}
+	if err := w.applyRoutes(ctx); err != nil {
+		w.logger.Error("Reloading routes failed", "err", err)
 	}
 }
 
@@ -95,26 +310,48 @@ func (w *Watcher) handleEvent(ctx context.Context, msg events.Message) {
 		return
 	}
 
+	w.metrics.observeEvent(msg.TimeNano, time.Now())
+
 	switch msg.Action {
 	case "start":
-		w.logger.Printf("Container started: %s (%s)", containerName, shortID(containerID))
+		w.logger.Info("Container started", "event", "container_started", "container", containerName, "id", shortID(containerID))
 		w.handleContainerStart(ctx, containerID)
 	case "stop", "die":
-		w.logger.Printf("Container stopped: %s (%s)", containerName, shortID(containerID))
+		w.logger.Info("Container stopped", "event", "container_stopped", "container", containerName, "id", shortID(containerID))
 		w.handleContainerStop(ctx, containerID)
+	case "restart":
+		// Follows the container's start event; only a missed start needs
+		// handling here.
+		if _, ok := w.routes.Get(containerID); !ok {
+			w.handleContainerStart(ctx, containerID)
+		}
+	case "pause":
+		w.logger.Info("Container paused", "event", "container_paused", "container", containerName, "id", shortID(containerID))
+		w.removeRoute(ctx, containerID)
+	case "unpause":
+		w.logger.Info("Container unpaused", "event", "container_unpaused", "container", containerName, "id", shortID(containerID))
+		w.handleContainerStart(ctx, containerID)
+	case "rename":
+		// The upstream is addressed by container name, so the route follows
+		// the new one.
+		if _, ok := w.routes.Get(containerID); ok {
+			w.logger.Info("Container renamed", "event", "container_renamed", "container", containerName,
+				"old_name", strings.TrimPrefix(msg.Actor.Attributes["oldName"], "/"), "id", shortID(containerID))
+			w.handleContainerStart(ctx, containerID)
+		}
 	}
 }
 
 func (w *Watcher) handleContainerStart(ctx context.Context, containerID string) {
-	cfg, err := config.Load()
+	cfg, err := w.config.load()
 	if err != nil {
-		w.logger.Printf("Error loading config: %v", err)
+		w.logger.Error("Loading config failed", "err", err)
 		return
 	}
 
-	info, err := w.cli.ContainerInspect(ctx, containerID)
+	info, err := gateway.InspectContainer(ctx, w.cli, containerID)
 	if err != nil {
-		w.logger.Printf("Error inspecting container %s: %v", shortID(containerID), err)
+		w.logger.Error("Inspecting container failed", "id", shortID(containerID), "err", err)
 		return
 	}
 
@@ -123,12 +360,15 @@ func (w *Watcher) handleContainerStart(ctx context.Context, containerID string)
 	composeService := info.Config.Labels["com.docker.compose.service"]
 
 	if composeProject == "" {
-		w.logger.Printf("Container %s has no compose project label, skipping", info.Name)
+		w.warn(info.Name, "Container has no compose project label, skipping")
 		return
 	}
+	if config.IsIgnored(info.Config.Labels) {
+		return // opted out of routing
+	}
 
 	// Look up in adopted projects
-	_, projCfg := cfg.FindProjectByComposeProject(composeProject)
+	projName, projCfg := cfg.FindRoutedProject(composeProject)
 	if projCfg == nil {
 		return // not adopted, ignore silently
 	}
@@ -139,85 +379,200 @@ func (w *Watcher) handleContainerStart(ctx context.Context, containerID string)
 	if composeWorkDir != "" && projCfg.Dir != "" {
 		absDir, err := filepath.Abs(projCfg.Dir)
 		if err == nil && composeWorkDir != absDir {
-			w.logger.Printf("Ignoring container %s: working_dir %q doesn't match adopted dir %q",
-				shortID(containerID), composeWorkDir, absDir)
+			w.warn(info.Name, "Ignoring container: working_dir doesn't match adopted dir",
+				"id", shortID(containerID), "working_dir", composeWorkDir, "dir", absDir)
 			return
 		}
 	}
 
-	// Detect HTTP port
-	port := DetectHTTPPort(info)
-	if port == "" {
-		w.logger.Printf("No HTTP port detected for %s/%s, skipping (hint: add EXPOSE <port> to the Dockerfile or label caddy-atc.port=<port> in docker-compose.yml)", composeProject, composeService)
+	// Declarations in the project's .caddy-atc.yml, re-read on every start,
+	// stand in for labels the container doesn't set.
+	pf := w.loadProjectFile(projCfg)
+	info.Config.Labels = pf.Labels(composeService, info.Config.Labels)
+	if config.IsIgnored(info.Config.Labels) {
 		return
 	}
 
-	// Determine hostname
-	hostname := projCfg.ResolveHostname(composeService)
-
-	// Validate before adding route
-	if err := config.ValidateHostname(hostname); err != nil {
-		w.logger.Printf("Invalid hostname for %s/%s: %v", composeProject, composeService, err)
+	route := w.routeFor(cfg, info, projName, projCfg, pf)
+	if route == nil {
 		return
 	}
+	hostname, containerName, port := route.Hostname, route.ContainerName, route.Port
 
-	// Connect container to caddy-atc network
-	containerName := strings.TrimPrefix(info.Name, "/")
-	if err := config.ValidateContainerName(containerName); err != nil {
-		w.logger.Printf("Invalid container name %q: %v", containerName, err)
+	if err := w.connectToNetwork(ctx, containerID); err != nil {
+		w.logger.Error("Connecting container to network failed", "container", containerName, "err", err)
 		return
 	}
 
-	if err := w.connectToNetwork(ctx, containerID); err != nil {
-		w.logger.Printf("Error connecting %s to network: %v", containerName, err)
+	// A restart within restartGrace leaves an unchanged route as it was,
+	// without a reload.
+	_, restarted := w.stopping[containerID]
+	delete(w.stopping, containerID)
+	if old, ok := w.routes.Get(containerID); ok && old.Quarantine == "" && *old == *route {
+		if restarted {
+			w.logger.Debug("Route kept", "event", "route_kept", "hostname", hostname, "upstream", containerName+":"+port, "project", composeProject, "service", composeService)
+		}
 		return
 	}
 
-	// Add route
-	route := &Route{
+	firstForProject := !w.routes.projectRouted(composeProject)
+	w.routes.Add(containerID, route)
+	w.routeAdded(route)
+
+	w.logger.Info("Route added", "event", "route_added", "hostname", hostname, "upstream", containerName+":"+port, "project", composeProject, "service", composeService)
+	if route.ReplicaHostname != "" && route.Options.ReplicaHostnames {
+		w.logger.Info("Route added", "event", "route_added", "hostname", route.ReplicaHostname, "upstream", containerName+":"+port, "project", composeProject, "service", composeService, "replica", true)
+	}
+
+	// Regenerate Caddyfile and reload
+	w.scheduleReload(ctx, func() {
+		w.warmUp(ctx, route)
+		if firstForProject && projCfg.Verify {
+			w.verify(ctx, route)
+		}
+	})
+}
+
+// routeFor builds the route for a container of an adopted project from its
+// labels, which already include the project file's declarations. Labels
+// that don't parse are ignored with a warning; it returns nil, also with a
+// warning, if the container can't be routed.
+func (w *Watcher) routeFor(cfg *config.Config, info types.ContainerJSON, projName string, projCfg *config.ProjectConfig, pf *config.ProjectFile) *Route {
+	var labels map[string]string
+	if info.Config != nil {
+		labels = info.Config.Labels
+	}
+	composeProject := labels["com.docker.compose.project"]
+	composeService := labels["com.docker.compose.service"]
+
+	port := DetectHTTPPort(info)
+	if port == "" {
+		w.warn(info.Name, "No HTTP port detected, skipping", "project", composeProject, "service", composeService, "hint", "add EXPOSE <port> to the Dockerfile or label caddy-atc.port=<port> in docker-compose.yml")
+		return nil
+	}
+
+	hostname, err := cfg.ResolveContainerHostname(projName, labels)
+	if err != nil {
+		w.warn(info.Name, "Ignoring hostname label", "project", composeProject, "service", composeService, "err", err)
+	}
+	protocol, err := config.ContainerProtocol(labels)
+	if err != nil {
+		w.warn(info.Name, "Ignoring protocol label", "project", composeProject, "service", composeService, "err", err)
+	}
+	scheme, err := config.ContainerUpstreamScheme(labels)
+	if err != nil {
+		w.warn(info.Name, "Ignoring upstream scheme label", "project", composeProject, "service", composeService, "err", err)
+	}
+	healthPath, err := config.ContainerHealthPath(labels)
+	if err != nil {
+		w.warn(info.Name, "Ignoring health path label", "project", composeProject, "service", composeService, "err", err)
+	}
+	redirectFrom, err := config.ContainerRedirectFrom(labels)
+	if err != nil {
+		w.warn(info.Name, "Ignoring redirect label", "project", composeProject, "service", composeService, "err", err)
+	}
+	rewrite, err := config.ContainerRewrite(labels)
+	if err != nil {
+		w.warn(info.Name, "Ignoring rewrite label", "project", composeProject, "service", composeService, "err", err)
+	}
+
+	// Validate before adding route
+	if err := config.ValidateHostname(hostname); err != nil {
+		w.warn(info.Name, "Invalid hostname, skipping", "project", composeProject, "service", composeService, "err", err)
+		return nil
+	}
+	containerName := strings.TrimPrefix(info.Name, "/")
+	if err := config.ValidateContainerName(containerName); err != nil {
+		w.warn(info.Name, "Invalid container name, skipping", "err", err)
+		return nil
+	}
+
+	return &Route{
 		Hostname:      hostname,
 		ContainerName: containerName,
 		Port:          port,
 		Project:       composeProject,
 		Service:       composeService,
+		Protocol:      protocol,
+		Scheme:        scheme,
+		LearnedScheme: w.meta.scheme(hostname, containerName, port),
+		HealthPath:    healthPath,
+		RedirectFrom:  redirectFrom,
+		Rewrite:       rewrite,
+		Snippet:       pf.SiteSnippet(),
+		RetryPage:     projCfg.RetryPage,
+		MixedContent:  projCfg.MixedContent,
+		Options:       projCfg.EffectiveServiceOptions(composeService, pf),
+		Source:        PortSource(info),
+
+		ReplicaHostname: projCfg.ReplicaHostname(composeService, labels[config.ReplicaNumberLabel]),
 	}
-	w.routes.Add(containerID, route)
+}
 
-	w.logger.Printf("Route added: %s -> %s:%s", hostname, containerName, port)
+// restartGrace is how long a stopped container's route is kept for it to
+// start again, so a restart doesn't remove and re-add the route.
+const restartGrace = 2 * time.Second
 
-	// Regenerate Caddyfile and reload
-	if err := w.reloadRoutes(ctx); err != nil {
-		w.logger.Printf("Error reloading routes: %v", err)
+// handleContainerStop removes the container's route once it has stayed
+// stopped for restartGrace.
+func (w *Watcher) handleContainerStop(ctx context.Context, containerID string) {
+	if _, ok := w.routes.Get(containerID); !ok {
+		return // not a routed container
+	}
+	if _, ok := w.stopping[containerID]; ok {
+		return // both stop and die are reported
 	}
+	if w.stopping == nil {
+		w.stopping = make(map[string]time.Time)
+	}
+	w.stopping[containerID] = time.Now().Add(restartGrace)
 }
 
-func (w *Watcher) handleContainerStop(ctx context.Context, containerID string) {
+// removeStopped removes the routes of containers still stopped after
+// restartGrace.
+func (w *Watcher) removeStopped(ctx context.Context, now time.Time) {
+	for id, deadline := range w.stopping {
+		if now.Before(deadline) {
+			continue
+		}
+		delete(w.stopping, id)
+		w.removeRoute(ctx, id)
+	}
+}
+
+// removeRoute removes a container's route and schedules a reload.
+func (w *Watcher) removeRoute(ctx context.Context, containerID string) {
 	route, ok := w.routes.Get(containerID)
 	if !ok {
 		return // not a routed container
 	}
+	delete(w.stopping, containerID)
 
-	w.logger.Printf("Route removed: %s -> %s:%s", route.Hostname, route.ContainerName, route.Port)
+	w.logger.Info("Route removed", "event", "route_removed", "hostname", route.Hostname, "upstream", route.ContainerName+":"+route.Port, "project", route.Project, "service", route.Service)
 	w.routes.Remove(containerID)
+	w.routeRemoved(route)
 
-	if err := w.reloadRoutes(ctx); err != nil {
-		w.logger.Printf("Error reloading routes: %v", err)
-	}
+	w.scheduleReload(ctx, nil)
 }
 
 func (w *Watcher) scanExisting(ctx context.Context) error {
-	w.logger.Println("Scanning existing containers...")
+	w.logger.Info("Scanning existing containers")
 
-	cfg, err := config.Load()
+	cfg, err := w.config.load()
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
 
-	containers, err := w.cli.ContainerList(ctx, container.ListOptions{})
+	containers, err := gateway.ListContainers(ctx, w.cli, container.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("listing containers: %w", err)
 	}
 
+	var added, toVerify []*Route
+	verifying := make(map[string]bool) // compose projects with a route in toVerify
+	projectFiles := make(map[string]*config.ProjectFile)
+	restored, reused := w.restored, 0
+	w.restored = nil
 	for _, c := range containers {
 		// Skip the gateway container
 		if isGatewayContainer(c.Names) {
@@ -226,11 +581,11 @@ func (w *Watcher) scanExisting(ctx context.Context) error {
 
 		composeProject := c.Labels["com.docker.compose.project"]
 		composeService := c.Labels["com.docker.compose.service"]
-		if composeProject == "" {
+		if composeProject == "" || config.IsIgnored(c.Labels) {
 			continue
 		}
 
-		_, projCfg := cfg.FindProjectByComposeProject(composeProject)
+		projName, projCfg := cfg.FindRoutedProject(composeProject)
 		if projCfg == nil {
 			continue
 		}
@@ -240,107 +595,219 @@ func (w *Watcher) scanExisting(ctx context.Context) error {
 		if composeWorkDir != "" && projCfg.Dir != "" {
 			absDir, err := filepath.Abs(projCfg.Dir)
 			if err == nil && composeWorkDir != absDir {
-				w.logger.Printf("Ignoring container %s: working_dir %q doesn't match adopted dir %q",
-					shortID(c.ID), composeWorkDir, absDir)
+				w.logger.Warn("Ignoring container: working_dir doesn't match adopted dir",
+					"id", shortID(c.ID), "working_dir", composeWorkDir, "dir", absDir)
 				continue
 			}
 		}
 
-		info, err := w.cli.ContainerInspect(ctx, c.ID)
-		if err != nil {
-			w.logger.Printf("Error inspecting container %s: %v", shortID(c.ID), err)
+		if r := restored.reuse(c, projCfg); r != nil {
+			w.routes.Add(c.ID, r)
+			reused++
 			continue
 		}
 
-		port := DetectHTTPPort(info)
-		if port == "" {
-			w.logger.Printf("No HTTP port detected for %s/%s, skipping (hint: add EXPOSE <port> to the Dockerfile or label caddy-atc.port=<port> in docker-compose.yml)", composeProject, composeService)
+		pf, ok := projectFiles[projName]
+		if !ok {
+			pf = w.loadProjectFile(projCfg)
+			projectFiles[projName] = pf
+		}
+		labels := pf.Labels(composeService, c.Labels)
+		if config.IsIgnored(labels) {
 			continue
 		}
 
-		hostname := projCfg.ResolveHostname(composeService)
-		containerName := strings.TrimPrefix(info.Name, "/")
-
-		// Validate before adding route
-		if err := config.ValidateHostname(hostname); err != nil {
-			w.logger.Printf("Invalid hostname for %s/%s: %v, skipping", composeProject, composeService, err)
+		info, err := gateway.InspectContainer(ctx, w.cli, c.ID)
+		if err != nil {
+			w.logger.Error("Inspecting container failed", "id", shortID(c.ID), "err", err)
 			continue
 		}
-		if err := config.ValidateContainerName(containerName); err != nil {
-			w.logger.Printf("Invalid container name %q: %v, skipping", containerName, err)
-			continue
+		if info.Config != nil {
+			info.Config.Labels = labels
 		}
 
-		// Connect to network
-		if err := w.connectToNetwork(ctx, c.ID); err != nil {
-			w.logger.Printf("Error connecting %s to network: %v", containerName, err)
+		route := w.routeFor(cfg, info, projName, projCfg, pf)
+		if route == nil {
 			continue
 		}
-
-		route := &Route{
-			Hostname:      hostname,
-			ContainerName: containerName,
-			Port:          port,
-			Project:       composeProject,
-			Service:       composeService,
+		if err := w.connectToNetwork(ctx, c.ID); err != nil {
+			w.logger.Error("Connecting container to network failed", "container", route.ContainerName, "err", err)
+			continue
 		}
 		w.routes.Add(c.ID, route)
-		w.logger.Printf("Existing route: %s -> %s:%s", hostname, containerName, port)
+		w.routeAdded(route)
+		added = append(added, route)
+		if projCfg.Verify && !verifying[composeProject] {
+			verifying[composeProject] = true
+			toVerify = append(toVerify, route)
+		}
+		w.logger.Info("Existing route", "event", "route_added", "hostname", route.Hostname, "upstream", route.ContainerName+":"+route.Port, "project", composeProject, "service", composeService)
+	}
+
+	if reused > 0 {
+		w.logger.Info("Restored routes from the previous watcher", "routes", reused)
+	}
+	// Nothing to reload if the routes are exactly the ones the gateway
+	// already serves.
+	if restored != nil && len(added) == 0 && !w.opts.Observe && caddyfileCurrent(w.routes) && w.gatewayRunning(ctx) {
+		w.logger.Debug("Caddyfile unchanged", "routes", w.routes.Len())
+		return nil
 	}
 
 	if w.routes.Len() > 0 {
 		if err := w.reloadRoutes(ctx); err != nil {
 			return fmt.Errorf("reloading routes: %w", err)
 		}
+		if !w.pending {
+			for _, r := range added {
+				w.warmUp(ctx, r)
+			}
+			for _, r := range toVerify {
+				w.verify(ctx, r)
+			}
+		}
 	}
 
-	w.logger.Printf("Found %d active routes", w.routes.Len())
+	w.logger.Info("Found active routes", "routes", w.routes.Len())
 	return nil
 }
 
+// loadProjectFile reads a project's .caddy-atc.yml and snippet, logging
+// and ignoring them if invalid. Returns nil if there are none.
+func (w *Watcher) loadProjectFile(proj *config.ProjectConfig) *config.ProjectFile {
+	pf, err := config.LoadProjectFile(proj.Dir)
+	if err != nil {
+		w.logger.Warn("Ignoring project config", "dir", proj.Dir, "err", err)
+	}
+	return pf
+}
+
 func (w *Watcher) connectToNetwork(ctx context.Context, containerID string) error {
+	if w.opts.Observe {
+		w.logger.Info("Observe mode: would connect container to network", "id", shortID(containerID), "network", gateway.Network())
+		return nil
+	}
+
 	// Check if already connected
-	info, err := w.cli.ContainerInspect(ctx, containerID)
+	info, err := gateway.InspectContainer(ctx, w.cli, containerID)
 	if err != nil {
 		return err
 	}
 
 	if info.NetworkSettings != nil {
 		for name := range info.NetworkSettings.Networks {
-			if name == gateway.NetworkName {
+			if name == gateway.Network() {
 				return nil // already connected
 			}
 		}
 	}
 
-	return w.cli.NetworkConnect(ctx, gateway.NetworkName, containerID, &network.EndpointSettings{})
+	return gateway.Do(ctx, func(ctx context.Context) error {
+		return w.cli.NetworkConnect(ctx, gateway.Network(), containerID, &network.EndpointSettings{})
+	})
 }
 
 func (w *Watcher) reloadRoutes(ctx context.Context) error {
-	if err := WriteCaddyfile(w.routes); err != nil {
+	if CurrentPause() != nil {
+		if !w.pending {
+			w.logger.Info("Routing paused, deferring Caddy reload until resume")
+		}
+		w.pending = true
+		return nil
+	}
+	w.pending = false
+	return w.applyRoutes(ctx)
+}
+
+// writeCaddyfile writes the Caddyfile and error pages for the routes,
+// warning when it replaces a Caddyfile edited by hand.
+func (w *Watcher) writeCaddyfile() error {
+	backup, err := WriteCaddyfile(w.routes, w.opts.Version)
+	if backup != "" {
+		w.logger.Warn("Caddyfile was edited by hand, saved a copy before regenerating it", "event", "caddyfile_edited", "backup", backup,
+			"hint", "put lasting changes between '"+manualBegin+"' and '"+manualEnd+"' lines")
+	}
+	if err != nil {
+		return err
+	}
+	// Without the pages, the gateway answers with a 404 instead.
+	cfg, err := w.config.load()
+	if err == nil {
+		err = syncErrorPages(w.routes, cfg)
+	}
+	if err != nil {
+		w.logger.Warn("Writing error pages failed", "err", err)
+	}
+	return nil
+}
+
+// applyRoutes writes the Caddyfile for the routes and reloads Caddy,
+// regardless of any pause.
+func (w *Watcher) applyRoutes(ctx context.Context) error {
+	w.quarantineInvalidRoutes()
+
+	if w.opts.Observe {
+		content, err := GenerateCaddyfile(w.routes)
+		if err != nil {
+			return fmt.Errorf("generating Caddyfile: %w", err)
+		}
+		w.logger.Info("Observe mode: would write Caddyfile and reload Caddy", "caddyfile", content)
+		return nil
+	}
+
+	defer func() {
+		if err := saveQuarantine(w.routes); err != nil {
+			w.logger.Warn("Saving watcher state failed", "err", err)
+		}
+		if err := saveSnapshot(w.routes, time.Now(), w.metrics.driftRepairs.Load()); err != nil {
+			w.logger.Warn("Saving watcher state failed", "err", err)
+		}
+		if err := saveActiveRoutes(w.routes, time.Now()); err != nil {
+			w.logger.Warn("Saving watcher state failed", "err", err)
+		}
+		if err := w.meta.sync(w.routes.All(), time.Now()); err != nil {
+			w.logger.Warn("Saving route metadata failed", "err", err)
+		}
+	}()
+
+	if err := w.writeCaddyfile(); err != nil {
 		return fmt.Errorf("writing Caddyfile: %w", err)
 	}
+	retryTemplate := w.applyCaddyfileTemplate(ctx)
+	w.syncHosts()
+
+	if w.lazy {
+		if handled, err := w.lazyReload(ctx); handled || err != nil {
+			if err == nil && retryTemplate && w.gatewayRunning(ctx) {
+				// It started on the built-in Caddyfile.
+				w.applyCaddyfileTemplate(ctx)
+				return w.reloadCaddy(ctx)
+			}
+			return err
+		}
+	}
 
 	// Try reload directly (fast path when gateway is already running)
-	err := ReloadCaddy(ctx)
+	err := w.reloadCaddy(ctx)
 	if err == nil {
 		return nil
 	}
 
-	// Only recover if the failure is due to the container being stopped;
-	// other errors (bad Caddyfile syntax, etc.) should surface immediately.
+	// Only restart the gateway if the failure is due to the container
+	// being stopped. Caddy rejecting the config is traced back to the
+	// offending site, which is quarantined so the other routes still load.
 	if !isContainerStoppedErr(err) {
-		return fmt.Errorf("reloading Caddy: %w", err)
+		return w.reloadWithQuarantine(ctx, err)
 	}
 
 	// Gateway container is stopped or unresponsive — restart it.
 	// A plain Up() is insufficient: the Docker API may report the container as
 	// "running" (zombie state after WSL2 sleep/hibernate) while exec fails.
 	// Restart handles both truly-stopped and zombie containers.
-	w.logger.Println("Gateway container not responding, restarting...")
+	w.logger.Warn("Gateway container not responding, restarting", "event", "gateway_restart")
 	if restartErr := gateway.Restart(ctx); restartErr != nil {
 		// Container may not exist at all — fall back to creating it
-		w.logger.Printf("Restart failed (%v), starting gateway from scratch...", restartErr)
+		w.logger.Warn("Restart failed, starting gateway from scratch", "err", restartErr)
 		if err := gateway.Up(ctx); err != nil {
 			return fmt.Errorf("starting gateway: %w", err)
 		}
@@ -349,8 +816,11 @@ func (w *Watcher) reloadRoutes(ctx context.Context) error {
 	if err := w.waitForGatewayReady(ctx); err != nil {
 		return fmt.Errorf("waiting for gateway: %w", err)
 	}
+	if retryTemplate {
+		w.applyCaddyfileTemplate(ctx)
+	}
 
-	if err := ReloadCaddy(ctx); err != nil {
+	if err := w.reloadCaddy(ctx); err != nil {
 		return fmt.Errorf("reloading Caddy: %w", err)
 	}
 	return nil
@@ -363,7 +833,7 @@ func (w *Watcher) waitForGatewayReady(ctx context.Context) error {
 	defer cancel()
 
 	for {
-		info, err := w.cli.ContainerInspect(ctx, gateway.ContainerName)
+		info, err := gateway.InspectContainer(ctx, w.cli, gateway.ContainerName)
 		if err == nil && info.State != nil && info.State.Running {
 			// Container is running; give Caddy a moment to bind its listener
 			select {
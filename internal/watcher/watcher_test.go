@@ -0,0 +1,153 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/g-brodiei/caddy-atc/internal/config"
+	"github.com/g-brodiei/caddy-atc/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	testenv.Main(m, config.DirEnv)
+}
+
+func TestReloadRoutes_ObserveMode(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var buf bytes.Buffer
+	w := &Watcher{
+		routes: NewActiveRoutes(),
+		logger: slog.New(slog.NewTextHandler(&buf, nil)),
+		opts:   Options{Observe: true},
+	}
+	w.routes.Add("c1", &Route{
+		Hostname:      "app.localhost",
+		ContainerName: "myapp-web-1",
+		Port:          "3000",
+	})
+
+	if err := w.reloadRoutes(context.Background()); err != nil {
+		t.Fatalf("reloadRoutes() error = %v", err)
+	}
+
+	if _, err := os.Stat(config.CaddyfilePath()); !os.IsNotExist(err) {
+		t.Error("observe mode wrote the Caddyfile")
+	}
+	if !strings.Contains(buf.String(), "reverse_proxy myapp-web-1:3000") {
+		t.Errorf("expected generated Caddyfile in log, got:\n%s", buf.String())
+	}
+}
+
+func TestRouteFor(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var buf bytes.Buffer
+	w := &Watcher{logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	projCfg := &config.ProjectConfig{Hostname: "app.localhost"}
+	cfg := &config.Config{Projects: map[string]*config.ProjectConfig{"app": projCfg}}
+
+	info := makeContainerJSON("web", nat.PortSet{"3000/tcp": struct{}{}}, nil)
+	info.Name = "/app-web-1"
+	info.Config.Labels["com.docker.compose.project"] = "app"
+	info.Config.Labels[config.ProtocolLabel] = config.ProtocolGRPC
+	info.Config.Labels[config.UpstreamSchemeLabel] = "gopher"
+	info.Config.Labels[config.HealthPathLabel] = "/healthz"
+
+	r := w.routeFor(cfg, info, "app", projCfg, nil)
+	if r == nil {
+		t.Fatalf("routeFor() = nil, log:\n%s", buf.String())
+	}
+	want := Route{
+		Hostname:      cfg.ResolveHostname("app", "web"),
+		ContainerName: "app-web-1",
+		Port:          "3000",
+		Project:       "app",
+		Service:       "web",
+		Protocol:      config.ProtocolGRPC,
+		HealthPath:    "/healthz",
+		Source:        PortSource(info),
+	}
+	if *r != want {
+		t.Errorf("routeFor() = %+v, want %+v", *r, want)
+	}
+	if !strings.Contains(buf.String(), "Ignoring upstream scheme label") {
+		t.Errorf("expected a warning for the bad scheme label, got:\n%s", buf.String())
+	}
+
+	// Without a port there is nothing to route.
+	info.Config.ExposedPorts = nil
+	if r := w.routeFor(cfg, info, "app", projCfg, nil); r != nil {
+		t.Errorf("routeFor() without a port = %+v, want nil", *r)
+	}
+}
+
+func TestConnectToNetwork_ObserveMode(t *testing.T) {
+	var buf bytes.Buffer
+	w := &Watcher{
+		logger: slog.New(slog.NewTextHandler(&buf, nil)),
+		opts:   Options{Observe: true},
+	}
+
+	// cli is nil: any Docker call would panic
+	if err := w.connectToNetwork(context.Background(), "0123456789abcdef"); err != nil {
+		t.Fatalf("connectToNetwork() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "would connect container to network\" id=0123456789ab") {
+		t.Errorf("expected observe log line, got: %q", buf.String())
+	}
+}
+
+func TestHandleContainerStop_RestartGrace(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	w := &Watcher{
+		routes: NewActiveRoutes(),
+		logger: slog.New(slog.DiscardHandler),
+		opts:   Options{Observe: true},
+	}
+	w.routes.Add("abc", &Route{Hostname: "app.localhost", ContainerName: "app", Port: "80"})
+
+	// Stop and die both arrive; the route survives until the grace ends.
+	now := time.Now()
+	w.handleContainerStop(t.Context(), "abc")
+	w.handleContainerStop(t.Context(), "abc")
+	w.removeStopped(t.Context(), now)
+	if _, ok := w.routes.Get("abc"); !ok {
+		t.Fatal("route removed within the restart grace")
+	}
+
+	w.removeStopped(t.Context(), now.Add(restartGrace+time.Second))
+	if _, ok := w.routes.Get("abc"); ok {
+		t.Error("route kept after the restart grace")
+	}
+	if len(w.stopping) != 0 {
+		t.Errorf("stopping = %v, want empty", w.stopping)
+	}
+}
+
+func TestRemoveRoute_Pause(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	w := &Watcher{
+		routes: NewActiveRoutes(),
+		logger: slog.New(slog.DiscardHandler),
+		opts:   Options{Observe: true},
+	}
+	w.routes.Add("abc", &Route{Hostname: "app.localhost", ContainerName: "app", Port: "80"})
+	w.handleContainerStop(t.Context(), "abc")
+
+	w.removeRoute(t.Context(), "abc")
+	if _, ok := w.routes.Get("abc"); ok {
+		t.Error("paused container still routed")
+	}
+	if _, ok := w.stopping["abc"]; ok {
+		t.Error("removed route still pending removal")
+	}
+}